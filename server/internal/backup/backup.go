@@ -0,0 +1,422 @@
+// Package backup takes compressed, checksummed snapshots of the database,
+// keeps a bounded number of them and restores them.
+//
+// SQLite databases are copied with VACUUM INTO, which produces a consistent
+// copy of a live database. PostgreSQL databases are written as a logical dump
+// of every table's rows in COPY format, read inside a single repeatable-read
+// transaction. Either way the result is gzip-compressed and described by a
+// JSON sidecar that records its checksum and schema version.
+package backup
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Backup labels record why a backup was taken
+const (
+	LabelManual       = "manual"
+	LabelPreMigration = "pre-migration"
+	LabelPreRestore   = "pre-restore"
+)
+
+var (
+	ErrNotFound            = errors.New("backup not found")
+	ErrChecksumMismatch    = errors.New("backup checksum does not match; the file is damaged")
+	ErrCorrupt             = errors.New("backup is corrupt")
+	ErrSchemaMismatch      = errors.New("backup was taken at a different schema version")
+	ErrDatabaseMismatch    = errors.New("backup was taken from a different kind of database")
+	ErrUnsupportedDatabase = errors.New("backups are not supported for this database")
+)
+
+// Info describes a backup. It is stored next to the backup file as
+// <name>.json.
+type Info struct {
+	Name          string    `json:"name"`
+	Label         string    `json:"label"`
+	Database      string    `json:"database"`
+	SchemaVersion string    `json:"schema_version"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Path is where the backup file was found
+	Path string `json:"-"`
+}
+
+// Manager creates backups in a directory and prunes old ones
+type Manager struct {
+	db     *gorm.DB
+	dir    string
+	keep   int
+	maxAge time.Duration
+}
+
+// NewManager creates a backup manager. After every backup, all but the keep
+// newest backups and any older than maxAge are removed; 0 disables either
+// rule. The newest backup is never removed.
+func NewManager(db *gorm.DB, dir string, keep int, maxAge time.Duration) *Manager {
+	return &Manager{db: db, dir: dir, keep: keep, maxAge: maxAge}
+}
+
+// Dir returns the directory backups are kept in
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Create takes a backup of the database and prunes old backups
+func (m *Manager) Create(label string) (*Info, error) {
+	info, err := m.write(label)
+	if err != nil {
+		return nil, err
+	}
+	m.prune()
+	return info, nil
+}
+
+// write takes a backup under a new name in the backup directory
+func (m *Manager) write(label string) (*Info, error) {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	ext, err := extension(m.db)
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("notesage-%s-%s", time.Now().UTC().Format("20060102T150405.000Z"), label)
+	name := base + ext
+	for i := 2; fileExists(filepath.Join(m.dir, name)); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+
+	return Write(m.db, filepath.Join(m.dir, name), label)
+}
+
+// prune applies the retention limits, logging rather than failing
+func (m *Manager) prune() {
+	if _, err := m.Prune(); err != nil {
+		log.Printf("Failed to prune old backups: %v", err)
+	}
+}
+
+// List returns the backups in the directory, newest first
+func (m *Manager) List() ([]Info, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	backups := []Info{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := readInfo(filepath.Join(m.dir, entry.Name()))
+		if err != nil || !fileExists(info.Path) {
+			continue
+		}
+		backups = append(backups, *info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// Get returns a backup by name
+func (m *Manager) Get(name string) (*Info, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasSuffix(name, ".json") {
+		return nil, ErrNotFound
+	}
+	info, err := readInfo(filepath.Join(m.dir, name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if !fileExists(info.Path) {
+		return nil, ErrNotFound
+	}
+	return info, nil
+}
+
+// Verify checks a backup's checksum and that its contents can be read back
+func (m *Manager) Verify(name string) (*Info, error) {
+	info, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return info, Verify(info)
+}
+
+// Restore replaces the contents of the database with a backup. The backup is
+// verified first and must come from the same kind of database. With
+// backupFirst the current data is backed up before it is replaced, and that
+// backup is returned.
+func (m *Manager) Restore(name string, backupFirst bool) (*Info, error) {
+	info, err := m.Verify(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Database != m.db.Dialector.Name() {
+		return nil, fmt.Errorf("%w: backup is %s, database is %s", ErrDatabaseMismatch, info.Database, m.db.Dialector.Name())
+	}
+	if info.Database == "postgres" {
+		current, err := SchemaVersion(m.db)
+		if err != nil {
+			return nil, err
+		}
+		// The dump only holds rows, so the tables have to match
+		if current != info.SchemaVersion {
+			return nil, fmt.Errorf("%w: backup is at %s, database is at %s; migrate the database to %s first",
+				ErrSchemaMismatch, info.SchemaVersion, current, info.SchemaVersion)
+		}
+	}
+
+	// Pruning waits until the restore is done so it cannot remove the backup
+	// being restored
+	var current *Info
+	if backupFirst {
+		if current, err = m.write(LabelPreRestore); err != nil {
+			return nil, fmt.Errorf("failed to back up current data: %w", err)
+		}
+		defer m.prune()
+	}
+
+	switch info.Database {
+	case "sqlite":
+		err = restoreSQLite(m.db, info)
+	case "postgres":
+		err = restorePostgres(m.db, info)
+	default:
+		err = ErrUnsupportedDatabase
+	}
+	return current, err
+}
+
+// Prune removes backups beyond the retention limits and returns them
+func (m *Manager) Prune() ([]Info, error) {
+	backups, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Info
+	for i, info := range backups {
+		if i == 0 {
+			continue
+		}
+		expired := m.maxAge > 0 && time.Since(info.CreatedAt) > m.maxAge
+		if (m.keep > 0 && i >= m.keep) || expired {
+			if err := remove(info); err != nil {
+				return removed, err
+			}
+			removed = append(removed, info)
+		}
+	}
+	return removed, nil
+}
+
+// Write takes a backup of db and writes it to path, with its description in
+// path.json
+func Write(db *gorm.DB, path, label string) (*Info, error) {
+	dialect := db.Dialector.Name()
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	partial := path + ".partial"
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer os.Remove(partial)
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, hash))
+	switch dialect {
+	case "sqlite":
+		err = dumpSQLite(db, filepath.Dir(path), gz)
+	case "postgres":
+		err = dumpPostgres(db, gz)
+	default:
+		err = ErrUnsupportedDatabase
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	stat, err := os.Stat(partial)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, fmt.Errorf("failed to save backup: %w", err)
+	}
+
+	info := &Info{
+		Name:          filepath.Base(path),
+		Label:         label,
+		Database:      dialect,
+		SchemaVersion: version,
+		Size:          stat.Size(),
+		SHA256:        hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:     time.Now().UTC(),
+		Path:          path,
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".json", data, 0o600); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to save backup description: %w", err)
+	}
+	return info, nil
+}
+
+// Verify checks a backup file against its checksum and reads it back
+func Verify(info *Info) error {
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if size != info.Size || hex.EncodeToString(hash.Sum(nil)) != info.SHA256 {
+		return ErrChecksumMismatch
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer gz.Close()
+
+	switch info.Database {
+	case "sqlite":
+		return verifySQLite(gz, filepath.Dir(info.Path), info.SchemaVersion)
+	case "postgres":
+		return readDump(gz, func(string, io.Reader) error { return nil })
+	default:
+		return ErrUnsupportedDatabase
+	}
+}
+
+// SchemaVersion returns the latest migration applied to db
+func SchemaVersion(db *gorm.DB) (string, error) {
+	if !db.Migrator().HasTable(&models.Migration{}) {
+		return "", nil
+	}
+	var versions []string
+	if err := db.Model(&models.Migration{}).Order("version DESC").Limit(1).Pluck("version", &versions).Error; err != nil {
+		return "", fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if len(versions) == 0 {
+		return "", nil
+	}
+	return versions[0], nil
+}
+
+// extension returns the backup file extension for db
+func extension(db *gorm.DB) (string, error) {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return ".sqlite.gz", nil
+	case "postgres":
+		return ".sql.gz", nil
+	default:
+		return "", ErrUnsupportedDatabase
+	}
+}
+
+// readInfo reads a backup description and points it at its backup file
+func readInfo(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid backup description %s: %w", filepath.Base(path), err)
+	}
+	if info.Name == "" || filepath.Base(info.Name) != info.Name {
+		return nil, fmt.Errorf("invalid backup description %s", filepath.Base(path))
+	}
+	info.Path = filepath.Join(filepath.Dir(path), info.Name)
+	return &info, nil
+}
+
+// remove deletes a backup and its description
+func remove(info Info) error {
+	if err := os.Remove(info.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove backup %s: %w", info.Name, err)
+	}
+	if err := os.Remove(info.Path + ".json"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove backup %s: %w", info.Name, err)
+	}
+	return nil
+}
+
+// extract decompresses a backup into a temporary file in dir and returns its
+// path
+func extract(r io.Reader, dir string) (string, error) {
+	file, err := os.CreateTemp(dir, ".restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
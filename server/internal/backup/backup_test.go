@@ -0,0 +1,149 @@
+package backup_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"notesage-server/internal/backup"
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func createTestUser(t *testing.T, db *gorm.DB) *models.User {
+	user := &models.User{
+		Username: "backup_" + uuid.New().String()[:8],
+		Email:    uuid.New().String()[:8] + "@example.com",
+		Password: "hashed",
+	}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
+
+func TestManager_CreateAndRestore(t *testing.T) {
+	db := database.SetupTestDB(t)
+	manager := backup.NewManager(db, t.TempDir(), 0, 0)
+
+	user := createTestUser(t, db)
+	kept := models.Note{UserID: user.ID, Title: "Kept"}
+	require.NoError(t, db.Create(&kept).Error)
+
+	info, err := manager.Create(backup.LabelManual)
+	require.NoError(t, err)
+	assert.Equal(t, "sqlite", info.Database)
+	assert.Equal(t, backup.LabelManual, info.Label)
+	assert.NotEmpty(t, info.SchemaVersion)
+	assert.Len(t, info.SHA256, 64)
+	assert.FileExists(t, info.Path)
+	assert.FileExists(t, info.Path+".json")
+
+	list, err := manager.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, info.Name, list[0].Name)
+
+	_, err = manager.Verify(info.Name)
+	require.NoError(t, err)
+
+	// Change data and schema after the backup
+	require.NoError(t, db.Delete(&kept).Error)
+	require.NoError(t, db.Create(&models.Note{UserID: user.ID, Title: "Added later"}).Error)
+	require.NoError(t, db.Exec("CREATE TABLE added_later (id INTEGER PRIMARY KEY)").Error)
+
+	current, err := manager.Restore(info.Name, true)
+	require.NoError(t, err)
+	require.NotNil(t, current)
+	assert.Equal(t, backup.LabelPreRestore, current.Label)
+
+	var notes []models.Note
+	require.NoError(t, db.Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "Kept", notes[0].Title)
+	assert.False(t, db.Migrator().HasTable("added_later"))
+
+	// The SQLite search index comes back in step with the notes
+	if db.Migrator().HasTable("notes_fts") {
+		require.NoError(t, db.Exec("INSERT INTO notes_fts (notes_fts) VALUES ('integrity-check')").Error)
+		var matches int64
+		require.NoError(t, db.Raw("SELECT COUNT(*) FROM notes_fts WHERE notes_fts MATCH 'kept OR added'").Scan(&matches).Error)
+		assert.Equal(t, int64(1), matches)
+	}
+
+	// Foreign keys still hold after the restore
+	err = db.Create(&models.Note{UserID: uuid.New(), Title: "Orphan"}).Error
+	assert.Error(t, err)
+
+	// The backup taken before restoring brings the later data back
+	_, err = manager.Restore(current.Name, false)
+	require.NoError(t, err)
+	require.NoError(t, db.Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "Added later", notes[0].Title)
+	assert.True(t, db.Migrator().HasTable("added_later"))
+}
+
+func TestManager_VerifyDetectsDamage(t *testing.T) {
+	db := database.SetupTestDB(t)
+	manager := backup.NewManager(db, t.TempDir(), 0, 0)
+
+	info, err := manager.Create(backup.LabelManual)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(info.Path)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	require.NoError(t, os.WriteFile(info.Path, data, 0o600))
+
+	_, err = manager.Verify(info.Name)
+	assert.ErrorIs(t, err, backup.ErrChecksumMismatch)
+	_, err = manager.Restore(info.Name, false)
+	assert.ErrorIs(t, err, backup.ErrChecksumMismatch)
+
+	_, err = manager.Verify("../" + info.Name)
+	assert.ErrorIs(t, err, backup.ErrNotFound)
+	_, err = manager.Verify("missing.sqlite.gz")
+	assert.ErrorIs(t, err, backup.ErrNotFound)
+}
+
+func TestManager_Prune(t *testing.T) {
+	db := database.SetupTestDB(t)
+	dir := t.TempDir()
+	manager := backup.NewManager(db, dir, 2, 7*24*time.Hour)
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		info, err := manager.Create(backup.LabelManual)
+		require.NoError(t, err)
+		names = append(names, info.Name)
+	}
+
+	list, err := manager.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, names[2], list[0].Name)
+	assert.Equal(t, names[1], list[1].Name)
+	assert.NoFileExists(t, dir+"/"+names[0])
+
+	// Age out the older backup, but never the newest
+	for _, info := range list {
+		info.CreatedAt = info.CreatedAt.Add(-30 * 24 * time.Hour)
+		data, err := json.Marshal(info)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(info.Path+".json", data, 0o600))
+	}
+	removed, err := manager.Prune()
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, names[1], removed[0].Name)
+
+	list, err = manager.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, names[2], list[0].Name)
+}
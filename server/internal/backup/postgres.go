@@ -0,0 +1,311 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// A PostgreSQL backup is a header line, one COPY block per table and a
+// trailer. The blocks are in the same text format psql and pg_dump use, so a
+// dump can also be loaded by hand into an empty schema.
+const (
+	dumpHeader  = "-- NoteSage logical backup"
+	dumpTrailer = "-- end of backup"
+	copyEnd     = "\\.\n"
+)
+
+// pgForeignKey is a foreign key constraint, dropped while rows are restored
+type pgForeignKey struct {
+	table      string
+	name       string
+	definition string
+}
+
+// withPgConn runs fn on a dedicated pgx connection from db's pool
+func withPgConn(db *gorm.DB, fn func(ctx context.Context, conn *pgx.Conn) error) error {
+	ctx := context.Background()
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("%w: unexpected driver %T", ErrUnsupportedDatabase, driverConn)
+		}
+		return fn(ctx, pgConn.Conn())
+	})
+}
+
+// dumpPostgres writes every table's rows to w from one consistent snapshot
+func dumpPostgres(db *gorm.DB, w io.Writer) error {
+	return withPgConn(db, func(ctx context.Context, conn *pgx.Conn) error {
+		tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		tables, err := pgTables(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(w, dumpHeader); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			columns, err := pgColumns(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+			target := pgx.Identifier{table}.Sanitize() + " (" + columns + ")"
+			if _, err := fmt.Fprintf(w, "COPY %s FROM stdin;\n", target); err != nil {
+				return err
+			}
+			if _, err := tx.Conn().PgConn().CopyTo(ctx, w, "COPY "+target+" TO STDOUT"); err != nil {
+				return fmt.Errorf("failed to dump %s: %w", table, err)
+			}
+			if _, err := io.WriteString(w, copyEnd); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, dumpTrailer); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// restorePostgres empties every table and loads the backup's rows in one
+// transaction. Foreign keys are dropped while rows are loaded and added back
+// afterwards, which checks the restored rows against them.
+func restorePostgres(db *gorm.DB, info *Info) error {
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer gz.Close()
+
+	return withPgConn(db, func(ctx context.Context, conn *pgx.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		tables, err := pgTables(ctx, tx)
+		if err != nil {
+			return err
+		}
+		foreignKeys, err := pgForeignKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range foreignKeys {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+pgx.Identifier{key.table}.Sanitize()+" DROP CONSTRAINT "+pgx.Identifier{key.name}.Sanitize()); err != nil {
+				return fmt.Errorf("failed to drop constraint %s: %w", key.name, err)
+			}
+		}
+		if len(tables) > 0 {
+			names := make([]string, len(tables))
+			for i, table := range tables {
+				names[i] = pgx.Identifier{table}.Sanitize()
+			}
+			if _, err := tx.Exec(ctx, "TRUNCATE "+strings.Join(names, ", ")); err != nil {
+				return fmt.Errorf("failed to clear database: %w", err)
+			}
+		}
+
+		err = readDump(gz, func(copySQL string, rows io.Reader) error {
+			_, err := tx.Conn().PgConn().CopyFrom(ctx, rows, copySQL)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore backup: %w", err)
+		}
+
+		for _, key := range foreignKeys {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+pgx.Identifier{key.table}.Sanitize()+" ADD CONSTRAINT "+pgx.Identifier{key.name}.Sanitize()+" "+key.definition); err != nil {
+				return fmt.Errorf("failed to restore constraint %s: %w", key.name, err)
+			}
+		}
+		if err := resetPgSequences(ctx, tx); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// readDump parses a PostgreSQL backup and calls fn with the COPY ... FROM
+// STDIN statement and rows of each table. A dump without its trailer was cut
+// short and is rejected.
+func readDump(r io.Reader, fn func(copySQL string, rows io.Reader) error) error {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSuffix(header, "\n") != dumpHeader {
+		return fmt.Errorf("%w: not a NoteSage backup", ErrCorrupt)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("%w: backup is truncated", ErrCorrupt)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == dumpTrailer:
+			return nil
+		case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, " FROM stdin;"):
+			block := &copyBlock{reader: reader}
+			if err := fn(strings.TrimSuffix(line, "stdin;")+"STDIN", block); err != nil {
+				return err
+			}
+			// Drain whatever fn did not read so the next line is a statement
+			if _, err := io.Copy(io.Discard, block); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unexpected line %q", ErrCorrupt, line)
+		}
+	}
+}
+
+// copyBlock reads the rows of one COPY block, up to its \. terminator
+type copyBlock struct {
+	reader  *bufio.Reader
+	pending []byte
+	done    bool
+}
+
+func (b *copyBlock) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		line, err := b.reader.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, fmt.Errorf("%w: backup is truncated", ErrCorrupt)
+			}
+			return 0, err
+		}
+		if string(line) == copyEnd {
+			b.done = true
+			continue
+		}
+		b.pending = line
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// pgTables lists the tables in the current schema
+func pgTables(ctx context.Context, tx pgx.Tx) ([]string, error) {
+	rows, err := tx.Query(ctx, `SELECT tablename FROM pg_tables WHERE schemaname = current_schema() ORDER BY tablename`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// pgColumns returns the quoted, comma separated columns of table that hold
+// data; generated columns are computed again on restore
+func pgColumns(ctx context.Context, tx pgx.Tx, table string) (string, error) {
+	rows, err := tx.Query(ctx, `SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to list columns of %s: %w", table, err)
+	}
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", err
+	}
+	for i, column := range columns {
+		columns[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(columns, ", "), nil
+}
+
+// pgForeignKeys lists the foreign key constraints in the current schema
+func pgForeignKeys(ctx context.Context, tx pgx.Tx) ([]pgForeignKey, error) {
+	rows, err := tx.Query(ctx, `SELECT c.relname, con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE con.contype = 'f' AND n.nspname = current_schema()
+		ORDER BY c.relname, con.conname`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []pgForeignKey
+	for rows.Next() {
+		var key pgForeignKey
+		if err := rows.Scan(&key.table, &key.name, &key.definition); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// resetPgSequences moves every serial column's sequence past the restored
+// rows
+func resetPgSequences(ctx context.Context, tx pgx.Tx) error {
+	rows, err := tx.Query(ctx, `SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND column_default LIKE 'nextval(%'`)
+	if err != nil {
+		return fmt.Errorf("failed to list sequences: %w", err)
+	}
+	type serial struct{ table, column string }
+	var serials []serial
+	for rows.Next() {
+		var s serial
+		if err := rows.Scan(&s.table, &s.column); err != nil {
+			rows.Close()
+			return err
+		}
+		serials = append(serials, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range serials {
+		query := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%s), 0) + 1, false) FROM %s`,
+			pgx.Identifier{s.column}.Sanitize(), pgx.Identifier{s.table}.Sanitize())
+		var value sql.NullInt64
+		if err := tx.QueryRow(ctx, query, s.table, s.column).Scan(&value); err != nil {
+			return fmt.Errorf("failed to reset sequence for %s.%s: %w", s.table, s.column, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDump(t *testing.T) {
+	dump := dumpHeader + "\n" +
+		"COPY \"users\" (\"id\", \"username\") FROM stdin;\n" +
+		"1\tana\n" +
+		"2\tback\\\\slash\n" +
+		copyEnd +
+		"COPY \"notes\" (\"id\") FROM stdin;\n" +
+		copyEnd +
+		dumpTrailer + "\n"
+
+	type block struct{ sql, rows string }
+	var blocks []block
+	err := readDump(strings.NewReader(dump), func(copySQL string, rows io.Reader) error {
+		data, err := io.ReadAll(rows)
+		blocks = append(blocks, block{copySQL, string(data)})
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []block{
+		{`COPY "users" ("id", "username") FROM STDIN`, "1\tana\n2\tback\\\\slash\n"},
+		{`COPY "notes" ("id") FROM STDIN`, ""},
+	}, blocks)
+
+	// Callers that stop reading early still leave the dump in step
+	err = readDump(strings.NewReader(dump), func(string, io.Reader) error { return nil })
+	assert.NoError(t, err)
+
+	for name, broken := range map[string]string{
+		"no header":       strings.TrimPrefix(dump, dumpHeader+"\n"),
+		"no trailer":      strings.TrimSuffix(dump, dumpTrailer+"\n"),
+		"cut off in rows": dump[:strings.Index(dump, "2\t")],
+		"stray line":      strings.Replace(dump, copyEnd+"COPY \"notes\"", copyEnd+"DROP TABLE notes;\nCOPY \"notes\"", 1),
+	} {
+		err := readDump(strings.NewReader(broken), func(string, io.Reader) error { return nil })
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
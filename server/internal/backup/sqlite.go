@@ -0,0 +1,257 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqliteObject is a row of sqlite_master
+type sqliteObject struct {
+	Type string `gorm:"column:type"`
+	Name string `gorm:"column:name"`
+	SQL  string `gorm:"column:sql"`
+}
+
+// dumpSQLite copies the database with VACUUM INTO, using a temporary file in
+// dir, and writes the copy to w
+func dumpSQLite(db *gorm.DB, dir string, w io.Writer) error {
+	tmp, err := os.CreateTemp(dir, ".vacuum-*.db")
+	if err != nil {
+		return err
+	}
+	path := tmp.Name()
+	tmp.Close()
+	// VACUUM INTO refuses to overwrite an existing file
+	os.Remove(path)
+	defer os.Remove(path)
+
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// verifySQLite runs an integrity check on a decompressed backup and checks
+// that it holds the schema version it claims to
+func verifySQLite(r io.Reader, dir, schemaVersion string) error {
+	path, err := extract(r, dir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	check, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	sqlDB, err := check.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	rows, err := sqlDB.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrCorrupt, strings.Join(problems, "; "))
+	}
+
+	version, err := SchemaVersion(check)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if version != schemaVersion {
+		return fmt.Errorf("%w: contains schema version %q, expected %q", ErrCorrupt, version, schemaVersion)
+	}
+	return nil
+}
+
+// restoreSQLite replaces the database's tables with those in the backup. The
+// backup is attached to a single connection and copied over in one
+// transaction, so other connections see either the old or the restored data.
+// Schema and data both come from the backup, which means a database can be
+// restored to a snapshot taken before a migration.
+func restoreSQLite(db *gorm.DB, info *Info) error {
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer gz.Close()
+
+	path, err := extract(gz, filepath.Dir(info.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	return db.Connection(func(conn *gorm.DB) error {
+		// Foreign keys can only be switched off outside a transaction
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+
+		if err := conn.Exec("ATTACH DATABASE ? AS restore_source", path).Error; err != nil {
+			return fmt.Errorf("failed to open backup: %w", err)
+		}
+		defer conn.Exec("DETACH DATABASE restore_source")
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			if err := dropSQLiteSchema(tx); err != nil {
+				return fmt.Errorf("failed to clear database: %w", err)
+			}
+			if err := copySQLiteSchema(tx); err != nil {
+				return fmt.Errorf("failed to restore backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// sqliteObjects lists the tables, indexes, triggers and views in schema in
+// creation order
+func sqliteObjects(tx *gorm.DB, schema string) ([]sqliteObject, error) {
+	var objects []sqliteObject
+	err := tx.Raw(`SELECT type, name, sql FROM ` + schema + `.sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY rowid`).Scan(&objects).Error
+	return objects, err
+}
+
+// dropSQLiteSchema drops every view and table in the main database. Virtual
+// tables go first so that they take their shadow tables with them.
+func dropSQLiteSchema(tx *gorm.DB) error {
+	objects, err := sqliteObjects(tx, "main")
+	if err != nil {
+		return err
+	}
+	for _, pass := range []func(sqliteObject) bool{
+		func(o sqliteObject) bool { return o.Type == "view" },
+		func(o sqliteObject) bool { return o.Type == "table" && isVirtualTable(o) },
+		func(o sqliteObject) bool { return o.Type == "table" },
+	} {
+		for _, object := range objects {
+			if !pass(object) {
+				continue
+			}
+			statement := "DROP TABLE IF EXISTS main."
+			if object.Type == "view" {
+				statement = "DROP VIEW IF EXISTS main."
+			}
+			if err := tx.Exec(statement + quoteIdent(object.Name)).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copySQLiteSchema recreates the backup's tables in the main database, copies
+// their rows and then adds indexes, triggers and views, so triggers do not
+// fire for restored rows
+func copySQLiteSchema(tx *gorm.DB) error {
+	objects, err := sqliteObjects(tx, "restore_source")
+	if err != nil {
+		return err
+	}
+
+	var virtual []string
+	for _, object := range objects {
+		if object.Type == "table" && isVirtualTable(object) {
+			virtual = append(virtual, object.Name)
+		}
+	}
+	isShadow := func(name string) bool {
+		for _, table := range virtual {
+			if strings.HasPrefix(name, table+"_") {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Creating a virtual table creates its shadow tables too
+	for _, object := range objects {
+		if object.Type == "table" && !isShadow(object.Name) {
+			if err := tx.Exec(object.SQL).Error; err != nil {
+				return fmt.Errorf("failed to create %s: %w", object.Name, err)
+			}
+		}
+	}
+
+	// The rows of a virtual table live in its shadow tables
+	tables := []string{}
+	for _, object := range objects {
+		if object.Type == "table" && !isVirtualTable(object) {
+			tables = append(tables, object.Name)
+		}
+	}
+	var sequences int64
+	tx.Raw("SELECT COUNT(*) FROM restore_source.sqlite_master WHERE name = 'sqlite_sequence'").Scan(&sequences)
+	if sequences > 0 {
+		tables = append(tables, "sqlite_sequence")
+	}
+	for _, table := range tables {
+		name := quoteIdent(table)
+		if err := tx.Exec("DELETE FROM main." + name).Error; err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+		if err := tx.Exec("INSERT INTO main." + name + " SELECT * FROM restore_source." + name).Error; err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+	}
+
+	for _, object := range objects {
+		if object.Type != "table" {
+			if err := tx.Exec(object.SQL).Error; err != nil {
+				return fmt.Errorf("failed to create %s: %w", object.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isVirtualTable(object sqliteObject) bool {
+	return strings.HasPrefix(strings.ToUpper(object.SQL), "CREATE VIRTUAL TABLE")
+}
+
+// quoteIdent quotes an SQLite identifier
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"fmt"
+
+	"notesage-server/internal/backup"
+	"notesage-server/internal/config"
+	"notesage-server/internal/database"
+
+	"github.com/spf13/cobra"
+)
+
+// BackupCmd represents the backup command
+var BackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Database backup commands",
+	Long: `Create, list, verify and restore compressed database backups. Backups are
+kept in BACKUP_DIR; after each new backup only the newest BACKUP_KEEP backups
+younger than BACKUP_MAX_AGE are kept.`,
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Back up the database",
+	Long:  `Write a compressed, checksummed backup of the database to the backup directory`,
+	RunE:  runBackupCreate,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backups",
+	Long:  `List the backups in the backup directory, newest first`,
+	RunE:  runBackupList,
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify [name]",
+	Short: "Check that a backup is intact",
+	Long:  `Check a backup against its checksum and read it back to make sure it can be restored`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBackupVerify,
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore [name]",
+	Short: "Replace the database with a backup",
+	Long: `Replace all data in the database with the contents of a backup. The current
+data is backed up first. Stop the server before restoring.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupRestore,
+}
+
+func init() {
+	BackupCmd.AddCommand(backupCreateCmd)
+	BackupCmd.AddCommand(backupListCmd)
+	BackupCmd.AddCommand(backupVerifyCmd)
+	BackupCmd.AddCommand(backupRestoreCmd)
+
+	backupRestoreCmd.Flags().BoolP("yes", "y", false, "Confirm that the current data should be replaced")
+	backupRestoreCmd.Flags().Bool("no-backup", false, "Skip the backup of the current data")
+}
+
+func runBackupCreate(cmd *cobra.Command, args []string) error {
+	manager, err := setupBackups()
+	if err != nil {
+		return err
+	}
+
+	info, err := manager.Create(backup.LabelManual)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created backup %s (%d bytes, sha256 %s)\n", info.Name, info.Size, info.SHA256)
+	return nil
+}
+
+func runBackupList(cmd *cobra.Command, args []string) error {
+	manager, err := setupBackups()
+	if err != nil {
+		return err
+	}
+
+	backups, err := manager.List()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups in %s\n", manager.Dir())
+		return nil
+	}
+
+	fmt.Printf("Backups in %s:\n", manager.Dir())
+	for _, info := range backups {
+		fmt.Printf("  %s  %s  schema %s  %d bytes  (%s)\n",
+			info.Name, info.CreatedAt.Local().Format("2006-01-02 15:04:05"), info.SchemaVersion, info.Size, info.Label)
+	}
+	return nil
+}
+
+func runBackupVerify(cmd *cobra.Command, args []string) error {
+	manager, err := setupBackups()
+	if err != nil {
+		return err
+	}
+
+	if _, err := manager.Verify(args[0]); err != nil {
+		return fmt.Errorf("backup %s failed verification: %w", args[0], err)
+	}
+
+	fmt.Printf("✓ %s is intact\n", args[0])
+	return nil
+}
+
+func runBackupRestore(cmd *cobra.Command, args []string) error {
+	yes, _ := cmd.Flags().GetBool("yes")
+	noBackup, _ := cmd.Flags().GetBool("no-backup")
+	if !yes {
+		return fmt.Errorf("restoring replaces all data in the database; re-run with --yes to continue")
+	}
+
+	manager, err := setupBackups()
+	if err != nil {
+		return err
+	}
+
+	current, err := manager.Restore(args[0], !noBackup)
+	if current != nil {
+		fmt.Printf("Backed up current data to %s\n", current.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s\n", args[0])
+	return nil
+}
+
+func setupBackups() (*backup.Manager, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, cfg.Backup.MaxAge), nil
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+
+	"notesage-server/internal/config"
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// ImportCmd imports notes and people from other note apps
+var ImportCmd = &cobra.Command{
+	Use:   "import [path]",
+	Short: "Import notes from Obsidian, Notion or Evernote",
+	Long: `Import an Obsidian vault (a directory or zip), a Notion Markdown export
+(a directory or zip) or an Evernote .enex file for a user. Notes become notes,
+pages marked as a person become people, and links between them become
+connections. Items that cannot be imported are listed at the end.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	ImportCmd.Flags().StringP("format", "f", "", "Import format: obsidian, notion or enex")
+	ImportCmd.Flags().StringP("user", "u", "", "Username or email of the user to import for")
+	ImportCmd.Flags().String("workspace", "", "ID of a workspace to import into instead of the personal space")
+	ImportCmd.Flags().String("folder", "/", "Folder to import into")
+	ImportCmd.Flags().BoolP("dry-run", "n", false, "Show what would be imported without saving")
+	_ = ImportCmd.MarkFlagRequired("format")
+	_ = ImportCmd.MarkFlagRequired("user")
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	username, _ := cmd.Flags().GetString("user")
+	workspace, _ := cmd.Flags().GetString("workspace")
+	folder, _ := cmd.Flags().GetString("folder")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	maxFileSize, err := config.ParseSize(cfg.Features.MaxUploadSize)
+	if err != nil || maxFileSize <= 0 {
+		maxFileSize = 10 << 20
+	}
+
+	batch, err := services.ReadImportPath(format, args[0], folder, maxFileSize)
+	if err != nil {
+		return fmt.Errorf("failed to read import: %w", err)
+	}
+
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	var user models.User
+	if err := db.Where("username = ? OR email = ?", username, username).First(&user).Error; err != nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+
+	var workspaceID *uuid.UUID
+	if workspace != "" {
+		id, err := uuid.Parse(workspace)
+		if err != nil {
+			return fmt.Errorf("invalid workspace ID: %w", err)
+		}
+		member, err := services.NewWorkspaceService(db).Membership(user.ID, id)
+		if err != nil || !models.WorkspaceRoleAllows(member.Role, models.WorkspaceRoleMember) {
+			return fmt.Errorf("%s cannot add notes to workspace %s", user.Username, id)
+		}
+		workspaceID = &id
+	}
+
+	if dryRun {
+		fmt.Printf("Would import %d notes and %d people for %s\n", len(batch.Notes), len(batch.People), user.Username)
+		printSkipped(batch.Skipped)
+		return nil
+	}
+
+	result, err := services.NewImportService(db).InWorkspace(workspaceID).Import(user.ID, batch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d notes, %d people and %d connections for %s\n",
+		len(result.Notes), len(result.People), result.Connections, user.Username)
+	printSkipped(result.Skipped)
+	return nil
+}
+
+func printSkipped(skipped []services.SkippedItem) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf("\nSkipped %d items:\n", len(skipped))
+	for _, item := range skipped {
+		fmt.Printf("  %s: %s\n", item.Path, item.Reason)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 
+	"notesage-server/internal/backup"
 	"notesage-server/internal/config"
 	"notesage-server/internal/database"
 	"notesage-server/internal/migrations"
@@ -94,6 +95,7 @@ func init() {
 	MigrateCmd.PersistentFlags().StringP("config", "c", "", "Path to configuration file")
 	MigrateCmd.PersistentFlags().BoolP("dry-run", "n", false, "Show what would be done without executing")
 	MigrateCmd.PersistentFlags().BoolP("verbose", "v", false, "Verbose output")
+	MigrateCmd.PersistentFlags().Bool("no-backup", false, "Skip the backup taken before pending migrations run")
 }
 
 func runMigrateUp(cmd *cobra.Command, args []string) error {
@@ -289,14 +291,15 @@ func setupMigrator(cmd *cobra.Command) (*migrations.Migrator, error) {
 
 	// Create migrator
 	migrator := migrations.NewMigrator(db)
-	return migrator, nil
-	verbose, _ := cmd.Flags().GetBool("verbose")
-	if verbose {
-		logger := &VerboseLogger{}
-		return migrations.NewMigratorWithLogger(db, logger), nil
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		migrator = migrations.NewMigratorWithLogger(db, &VerboseLogger{})
 	}
 
-	return migrations.NewMigrator(db), nil
+	// Back up existing data before migrations run
+	if noBackup, _ := cmd.Flags().GetBool("no-backup"); !noBackup {
+		migrator.WithBackups(backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, cfg.Backup.MaxAge))
+	}
+	return migrator, nil
 }
 
 func showPendingMigrations(migrator *migrations.Migrator) error {
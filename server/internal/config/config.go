@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,7 @@ type Config struct {
 	Auth     AuthConfig
 	Logging  LoggingConfig
 	Features FeaturesConfig
+	Backup   BackupConfig
 	AI       AIConfig
 }
 
@@ -54,6 +57,14 @@ type FeaturesConfig struct {
 	WebSocketEnabled bool
 	FileUploads      bool
 	MaxUploadSize    string
+	UploadPath       string
+	TrashRetention   time.Duration
+}
+
+type BackupConfig struct {
+	Dir    string
+	Keep   int
+	MaxAge time.Duration
 }
 
 type AIConfig struct {
@@ -63,6 +74,22 @@ type AIConfig struct {
 	Model      string
 	MaxTokens  int
 	Timeout    int // seconds
+	// EmbeddingModel is the model semantic search embeds notes with
+	EmbeddingModel string
+	// MaxRetries is how many times a request the provider refuses with
+	// 429 or a 5xx status is retried
+	MaxRetries int
+	// UserDailyTokens, UserMonthlyTokens, DailyTokens and MonthlyTokens
+	// cap the tokens each user and the whole server may use per UTC day
+	// and month; zero is unlimited
+	UserDailyTokens   int
+	UserMonthlyTokens int
+	DailyTokens       int
+	MonthlyTokens     int
+	// InputPrice and OutputPrice set the price of Model in US dollars per
+	// million tokens for cost estimates
+	InputPrice  float64
+	OutputPrice float64
 }
 
 func Load() (*Config, error) {
@@ -100,20 +127,64 @@ func Load() (*Config, error) {
 			WebSocketEnabled: getEnvAsBool("WEBSOCKET_ENABLED", true),
 			FileUploads:      getEnvAsBool("FILE_UPLOADS", true),
 			MaxUploadSize:    getEnv("MAX_UPLOAD_SIZE", "10MB"),
+			UploadPath:       getEnv("UPLOAD_PATH", "data/attachments"),
+			TrashRetention:   getEnvAsDuration("TRASH_RETENTION", 30*24*time.Hour),
+		},
+		Backup: BackupConfig{
+			Dir:    getEnv("BACKUP_DIR", "data/backups"),
+			Keep:   getEnvAsInt("BACKUP_KEEP", 10),
+			MaxAge: getEnvAsDuration("BACKUP_MAX_AGE", 30*24*time.Hour),
 		},
 		AI: AIConfig{
-			Provider:  getEnv("AI_PROVIDER", "openai"),
-			APIKey:    getEnv("AI_API_KEY", ""),
-			BaseURL:   getEnv("AI_BASE_URL", ""),
-			Model:     getEnv("AI_MODEL", ""),
-			MaxTokens: getEnvAsInt("AI_MAX_TOKENS", 1000),
-			Timeout:   getEnvAsInt("AI_TIMEOUT", 30),
+			Provider:       getEnv("AI_PROVIDER", "openai"),
+			APIKey:         getEnv("AI_API_KEY", ""),
+			BaseURL:        getEnv("AI_BASE_URL", ""),
+			Model:          getEnv("AI_MODEL", ""),
+			MaxTokens:      getEnvAsInt("AI_MAX_TOKENS", 1000),
+			Timeout:        getEnvAsInt("AI_TIMEOUT", 30),
+			EmbeddingModel: getEnv("AI_EMBEDDING_MODEL", ""),
+			MaxRetries:     getEnvAsInt("AI_MAX_RETRIES", 2),
+
+			UserDailyTokens:   getEnvAsInt("AI_USER_DAILY_TOKENS", 0),
+			UserMonthlyTokens: getEnvAsInt("AI_USER_MONTHLY_TOKENS", 0),
+			DailyTokens:       getEnvAsInt("AI_DAILY_TOKENS", 0),
+			MonthlyTokens:     getEnvAsInt("AI_MONTHLY_TOKENS", 0),
+			InputPrice:        getEnvAsFloat("AI_INPUT_PRICE", 0),
+			OutputPrice:       getEnvAsFloat("AI_OUTPUT_PRICE", 0),
 		},
 	}
 
 	return cfg, nil
 }
 
+// ParseSize parses a byte size such as "10MB", "512KB" or "1048576". Units
+// are binary, so "1KB" is 1024 bytes.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -130,6 +201,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"notesage-server/internal/backup"
 	"notesage-server/internal/config"
 	"notesage-server/internal/migrations"
 
@@ -65,13 +66,23 @@ func Initialize(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// Index notes for search if the database was migrated by a build without
+	// SQLite FTS5
+	if err := migrations.EnsureNoteSearchIndex(db); err != nil {
+		return nil, err
+	}
+
 	fmt.Printf("Successfully connected to %s database\n", cfg.Type)
 	return db, nil
 }
 
-// Migrate runs database migrations
-func Migrate(db *gorm.DB) error {
+// Migrate runs database migrations, backing up a database that already has
+// some before pending migrations run. backups may be nil to skip the backup.
+func Migrate(db *gorm.DB, backups *backup.Manager) error {
 	migrator := migrations.NewMigrator(db)
+	if backups != nil {
+		migrator.WithBackups(backups)
+	}
 	return migrator.Migrate()
 }
 
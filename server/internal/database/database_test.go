@@ -419,13 +419,13 @@ func TestForeignKeyConstraints(t *testing.T) {
 	err = db.Create(&todo).Error
 	require.NoError(t, err)
 
-	// Delete note (should cascade delete todo)
-	err = db.Delete(&note).Error
+	// Permanently delete note (should cascade delete todo)
+	err = db.Unscoped().Delete(&note).Error
 	assert.NoError(t, err)
 
 	// Verify todo was deleted due to cascade
 	var foundTodo models.Todo
-	err = db.First(&foundTodo, todo.ID).Error
+	err = db.Unscoped().First(&foundTodo, todo.ID).Error
 	assert.Error(t, err, "Todo should be deleted due to cascade")
 
 	// Delete user (should cascade delete remaining records)
@@ -34,7 +34,7 @@ func SetupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// Run migrations
-	if err := Migrate(db); err != nil {
+	if err := Migrate(db, nil); err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
 
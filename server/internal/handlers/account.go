@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxAccountArchiveSize caps the size of an uploaded account archive
+const maxAccountArchiveSize = 1 << 30
+
+// AccountHandler exports a user's whole account as an archive and restores
+// such archives
+type AccountHandler struct {
+	accountService *services.AccountService
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(accountService *services.AccountService) *AccountHandler {
+	return &AccountHandler{accountService: accountService}
+}
+
+// ExportAccount streams a zip archive of everything the user owns in the
+// active space
+func (h *AccountHandler) ExportAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	filename := fmt.Sprintf("notesage-account-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// Headers are sent with the first write, so a failure part way through
+	// can only be logged; the client sees a truncated archive
+	if err := h.accountService.InWorkspace(activeWorkspace(c)).ExportAccount(uuid.MustParse(userID.(string)), c.Writer); err != nil {
+		log.Printf("Failed to export account %s: %v", userID, err)
+		c.Abort()
+	}
+}
+
+// ImportAccount restores an account archive from a multipart "file" upload
+// into the active space
+func (h *AccountHandler) ImportAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAccountArchiveSize+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named \"file\" is required"})
+		}
+		return
+	}
+	defer file.Close()
+
+	result, err := h.accountService.InWorkspace(activeWorkspace(c)).ImportAccount(uuid.MustParse(userID.(string)), file, header.Size)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAccountArchive), errors.Is(err, services.ErrUnsupportedArchiveVersion):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Failed to import account for %s: %v", userID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to restore archive: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
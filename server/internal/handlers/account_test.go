@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAndImportAccount(t *testing.T) {
+	router, db, owner, ownerToken := setupSharesRouter(t)
+	other, otherToken := createSharesTestUser(t, db, "other")
+
+	storage, err := services.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	accountHandler := NewAccountHandler(services.NewAccountService(db, storage, 1<<20))
+	api := router.Group("/api")
+	api.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		api.GET("/export/account", accountHandler.ExportAccount)
+		api.POST("/import/account", accountHandler.ImportAccount)
+	}
+
+	w := makeRequest(t, router, "POST", "/api/notes", ownerToken, CreateNoteRequest{Title: "Portable", Tags: []string{"mine"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/export/account", ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "notesage-account-")
+	archive := w.Body.Bytes()
+
+	upload := func(token string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "account.zip")
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest("POST", "/api/import/account", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = upload(otherToken, archive)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var result services.AccountImportResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Notes)
+
+	var notes []models.Note
+	require.NoError(t, db.Where("user_id = ?", other.ID).Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "Portable", notes[0].Title)
+
+	var ownerCount int64
+	db.Model(&models.Note{}).Where("user_id = ?", owner.ID).Count(&ownerCount)
+	assert.Equal(t, int64(1), ownerCount)
+
+	w = upload(otherToken, []byte("not a zip"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
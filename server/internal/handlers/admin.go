@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,20 +11,37 @@ import (
 	"strings"
 	"time"
 
+	"notesage-server/internal/backup"
 	"notesage-server/internal/models"
+	"notesage-server/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// maxAdminImportSize caps the size of an uploaded import archive
+const maxAdminImportSize = 512 << 20
+
 // AdminHandler handles administrative endpoints
 type AdminHandler struct {
-	db *gorm.DB
+	db               *gorm.DB
+	importService    *services.ImportService
+	workspaceService *services.WorkspaceService
+	backups          *backup.Manager
+	maxUploadSize    int64
 }
 
-// NewAdminHandler creates a new admin handler
-func NewAdminHandler(db *gorm.DB) *AdminHandler {
-	return &AdminHandler{db: db}
+// NewAdminHandler creates a new admin handler. Files inside an import larger
+// than maxUploadSize bytes are skipped; 0 means no limit.
+func NewAdminHandler(db *gorm.DB, backups *backup.Manager, maxUploadSize int64) *AdminHandler {
+	return &AdminHandler{
+		db:               db,
+		importService:    services.NewImportService(db),
+		workspaceService: services.NewWorkspaceService(db),
+		backups:          backups,
+		maxUploadSize:    maxUploadSize,
+	}
 }
 
 // ServerInfo contains server information
@@ -67,15 +85,6 @@ type LogInfo struct {
 	Lines        int       `json:"lines"`
 }
 
-// BackupInfo contains backup information
-type BackupInfo struct {
-	Name         string    `json:"name"`
-	Path         string    `json:"path"`
-	Size         int64     `json:"size"`
-	CreatedTime  time.Time `json:"created_time"`
-	Type         string    `json:"type"`
-}
-
 // MaintenanceTask represents a maintenance task
 type MaintenanceTask struct {
 	Name        string    `json:"name"`
@@ -234,29 +243,42 @@ func (h *AdminHandler) GetLogContent(c *gin.Context) {
 	})
 }
 
-// GetBackups returns backup information
+// GetBackups returns the database backups, newest first
 func (h *AdminHandler) GetBackups(c *gin.Context) {
-	backups := h.getBackupInfo()
+	backups, err := h.backups.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, backups)
 }
 
-// CreateBackup creates a new backup
+// CreateBackup takes a database backup
 func (h *AdminHandler) CreateBackup(c *gin.Context) {
-	// Execute backup script
-	cmd := exec.Command("/opt/notesage/backup.sh", "create")
-	output, err := cmd.CombinedOutput()
+	info, err := h.backups.Create(backup.LabelManual)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":  fmt.Sprintf("Backup failed: %v", err),
-			"output": string(output),
+			"error": fmt.Sprintf("Backup failed: %v", err),
 		})
 		return
 	}
-	
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Backup created successfully",
-		"output":  string(output),
-	})
+
+	c.JSON(http.StatusCreated, info)
+}
+
+// VerifyBackup checks that a backup is intact and can be restored
+func (h *AdminHandler) VerifyBackup(c *gin.Context) {
+	info, err := h.backups.Verify(c.Param("name"))
+	switch {
+	case errors.Is(err, backup.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, backup.ErrChecksumMismatch), errors.Is(err, backup.ErrCorrupt):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "backup": info})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Backup is intact", "backup": info})
+	}
 }
 
 // RunMaintenance runs maintenance tasks
@@ -345,6 +367,84 @@ func (h *AdminHandler) PerformUpgrade(c *gin.Context) {
 
 // Helper methods
 
+// ImportData imports an Obsidian vault or Notion export (.zip) or an
+// Evernote export (.enex) from a multipart "file" upload. The "format" field
+// names the format; optional "user_id", "workspace_id" and "folder_path"
+// fields choose whose notes they become and where. The caller is the default
+// user.
+func (h *AdminHandler) ImportData(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAdminImportSize+multipartOverhead)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named \"file\" is required"})
+		}
+		return
+	}
+	defer file.Close()
+
+	format := c.PostForm("format")
+	switch format {
+	case services.ImportFormatObsidian, services.ImportFormatNotion, services.ImportFormatEvernote:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be obsidian, notion or enex"})
+		return
+	}
+
+	callerID, _ := c.Get("userID")
+	userID := uuid.MustParse(callerID.(string))
+	if raw := c.PostForm("user_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if err := h.db.First(&models.User{}, "id = ?", id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		userID = id
+	}
+
+	var workspaceID *uuid.UUID
+	if raw := c.PostForm("workspace_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+			return
+		}
+		member, err := h.workspaceService.Membership(userID, id)
+		if err != nil || !models.WorkspaceRoleAllows(member.Role, models.WorkspaceRoleMember) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The user cannot add notes to this workspace"})
+			return
+		}
+		workspaceID = &id
+	}
+
+	batch, err := services.ReadImportArchive(format, file, header.Size, c.PostForm("folder_path"), h.maxUploadSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Sanitize content to prevent XSS
+	for i := range batch.Notes {
+		batch.Notes[i].Content = services.SanitizeContent(batch.Notes[i].Content)
+	}
+
+	result, err := h.importService.InWorkspace(workspaceID).Import(userID, batch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
 func (h *AdminHandler) getDatabaseInfo() DatabaseInfo {
 	info := DatabaseInfo{
 		Type: "PostgreSQL",
@@ -431,12 +531,11 @@ func (h *AdminHandler) getLogInfo() []LogInfo {
 	return logs
 }
 
-func (h *AdminHandler) getBackupInfo() []BackupInfo {
-	var backups []BackupInfo
-	
-	// This is a simplified implementation
-	// In a real implementation, you would scan the backup directory
-	
+func (h *AdminHandler) getBackupInfo() []backup.Info {
+	backups, err := h.backups.List()
+	if err != nil {
+		return []backup.Info{}
+	}
 	return backups
 }
 
@@ -489,8 +588,8 @@ func getUptime() string {
 }
 
 // RegisterAdminRoutes registers all admin routes
-func RegisterAdminRoutes(router *gin.RouterGroup, db *gorm.DB) {
-	handler := NewAdminHandler(db)
+func RegisterAdminRoutes(router *gin.RouterGroup, db *gorm.DB, backups *backup.Manager, maxUploadSize int64) {
+	handler := NewAdminHandler(db, backups, maxUploadSize)
 	
 	// Dashboard
 	router.GET("/dashboard", handler.AdminDashboard)
@@ -510,6 +609,7 @@ func RegisterAdminRoutes(router *gin.RouterGroup, db *gorm.DB) {
 	// Backup management
 	router.GET("/backups", handler.GetBackups)
 	router.POST("/backups", handler.CreateBackup)
+	router.POST("/backups/:name/verify", handler.VerifyBackup)
 	
 	// Maintenance
 	router.GET("/maintenance", handler.GetMaintenanceTasks)
@@ -518,4 +618,7 @@ func RegisterAdminRoutes(router *gin.RouterGroup, db *gorm.DB) {
 	// Updates
 	router.GET("/updates/check", handler.CheckForUpdates)
 	router.POST("/updates/upgrade", handler.PerformUpgrade)
+
+	// Imports from other note apps
+	router.POST("/import", handler.ImportData)
 }
\ No newline at end of file
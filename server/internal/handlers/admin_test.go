@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"notesage-server/internal/backup"
+	"notesage-server/internal/config"
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeAdminImportRequest(t *testing.T, handler http.Handler, token string, fields map[string]string, filename string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/api/admin/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminImportData(t *testing.T) {
+	router, db, user, token := setupSharesRouter(t)
+
+	admin, _ := createSharesTestUser(t, db, "admin")
+	admin.Role = models.RoleAdmin
+	require.NoError(t, db.Save(admin).Error)
+	authHandler := NewAuthHandler(db, &config.Config{Auth: config.AuthConfig{JWTSecret: "test-secret", SessionTimeout: time.Hour}})
+	adminToken, _, err := authHandler.generateToken(*admin)
+	require.NoError(t, err)
+
+	group := router.Group("/api/admin")
+	group.Use(middleware.AuthMiddleware("test-secret"), middleware.RequireAdmin())
+	RegisterAdminRoutes(group, db, backup.NewManager(db, t.TempDir(), 0, 0), 1<<20)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, body := range map[string]string{
+		"Ideas.md":       "Ask [[Sam Lee]] about [[Roadmap]]",
+		"Roadmap.md":     "---\ntags: [planning]\n---\nQ3",
+		"Sam Lee.md":     "---\ntags: [person]\n---\nEngineer",
+		"attachment.pdf": "pdf",
+	} {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	fields := map[string]string{"format": "obsidian", "user_id": user.ID.String(), "folder_path": "/Vault"}
+
+	w := makeAdminImportRequest(t, router, token, fields, "vault.zip", archive.Bytes())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = makeAdminImportRequest(t, router, adminToken, fields, "vault.zip", archive.Bytes())
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var result services.ImportResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Len(t, result.Notes, 2)
+	assert.Len(t, result.People, 1)
+	assert.Equal(t, 2, result.Connections)
+	assert.Equal(t, []services.SkippedItem{{Path: "attachment.pdf", Reason: services.ErrUnsupportedImport.Error()}}, result.Skipped)
+	for _, note := range result.Notes {
+		assert.Equal(t, user.ID, note.UserID)
+		assert.Equal(t, "/Vault", note.FolderPath)
+	}
+
+	w = makeAdminImportRequest(t, router, adminToken, map[string]string{"format": "onenote"}, "vault.zip", archive.Bytes())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeAdminImportRequest(t, router, adminToken, map[string]string{"format": "enex"}, "export.enex", []byte("<en-export/>"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminBackups(t *testing.T) {
+	router, db, _, _ := setupSharesRouter(t)
+
+	admin, _ := createSharesTestUser(t, db, "admin")
+	admin.Role = models.RoleAdmin
+	require.NoError(t, db.Save(admin).Error)
+	authHandler := NewAuthHandler(db, &config.Config{Auth: config.AuthConfig{JWTSecret: "test-secret", SessionTimeout: time.Hour}})
+	adminToken, _, err := authHandler.generateToken(*admin)
+	require.NoError(t, err)
+
+	group := router.Group("/api/admin")
+	group.Use(middleware.AuthMiddleware("test-secret"), middleware.RequireAdmin())
+	RegisterAdminRoutes(group, db, backup.NewManager(db, t.TempDir(), 0, 0), 1<<20)
+
+	w := makeRequest(t, router, "POST", "/api/admin/backups", adminToken, nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created backup.Info
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, backup.LabelManual, created.Label)
+
+	w = makeRequest(t, router, "GET", "/api/admin/backups", adminToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []backup.Info
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.Name, list[0].Name)
+
+	w = makeRequest(t, router, "POST", "/api/admin/backups/"+created.Name+"/verify", adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = makeRequest(t, router, "POST", "/api/admin/backups/missing.sqlite.gz/verify", adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
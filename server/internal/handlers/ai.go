@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"notesage-server/internal/models"
 	"notesage-server/internal/services"
@@ -61,15 +63,20 @@ func (h *AIHandler) GetAIStatus(c *gin.Context) {
 
 // ExtractTodos extracts todos from note content using AI
 func (h *AIHandler) ExtractTodos(c *gin.Context) {
+	userUUID, ok := aiUserID(c)
+	if !ok {
+		return
+	}
+
 	var req ExtractTodosRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
-	result, err := h.aiService.ExtractTodosFromNote(c.Request.Context(), req.Content)
+	result, err := h.aiService.ExtractTodosFromNote(c.Request.Context(), req.Content, userUUID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract todos"})
+		writeAIError(c, err, "Failed to extract todos")
 		return
 	}
 
@@ -78,15 +85,8 @@ func (h *AIHandler) ExtractTodos(c *gin.Context) {
 
 // AnalyzePeople analyzes people mentions and relationships in content
 func (h *AIHandler) AnalyzePeople(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
-	userUUID, ok := userID.(uuid.UUID)
+	userUUID, ok := aiUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
@@ -98,7 +98,7 @@ func (h *AIHandler) AnalyzePeople(c *gin.Context) {
 
 	result, err := h.aiService.AnalyzePeopleMentions(c.Request.Context(), req.Content, userUUID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze people mentions"})
+		writeAIError(c, err, "Failed to analyze people mentions")
 		return
 	}
 
@@ -107,15 +107,8 @@ func (h *AIHandler) AnalyzePeople(c *gin.Context) {
 
 // GenerateInsights generates insights from user's knowledge base
 func (h *AIHandler) GenerateInsights(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
-	userUUID, ok := userID.(uuid.UUID)
+	userUUID, ok := aiUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
@@ -129,7 +122,7 @@ func (h *AIHandler) GenerateInsights(c *gin.Context) {
 
 	result, err := h.aiService.GenerateInsights(c.Request.Context(), userUUID, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate insights"})
+		writeAIError(c, err, "Failed to generate insights")
 		return
 	}
 
@@ -138,15 +131,8 @@ func (h *AIHandler) GenerateInsights(c *gin.Context) {
 
 // ExtractTodosFromNote extracts todos from a specific note using AI
 func (h *AIHandler) ExtractTodosFromNote(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
-	userUUID, ok := userID.(uuid.UUID)
+	userUUID, ok := aiUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
@@ -165,9 +151,9 @@ func (h *AIHandler) ExtractTodosFromNote(c *gin.Context) {
 		return
 	}
 
-	result, err := h.aiService.ExtractTodosFromNote(c.Request.Context(), req.Content)
+	result, err := h.aiService.ExtractTodosFromNote(c.Request.Context(), req.Content, userUUID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract todos from note"})
+		writeAIError(c, err, "Failed to extract todos from note")
 		return
 	}
 
@@ -183,15 +169,8 @@ func (h *AIHandler) ExtractTodosFromNote(c *gin.Context) {
 
 // AnalyzePeopleInNote analyzes people mentions in a specific note
 func (h *AIHandler) AnalyzePeopleInNote(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
-	userUUID, ok := userID.(uuid.UUID)
+	userUUID, ok := aiUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
@@ -210,7 +189,7 @@ func (h *AIHandler) AnalyzePeopleInNote(c *gin.Context) {
 
 	result, err := h.aiService.AnalyzePeopleMentions(c.Request.Context(), req.Content, userUUID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze people mentions in note"})
+		writeAIError(c, err, "Failed to analyze people mentions in note")
 		return
 	}
 
@@ -222,4 +201,93 @@ func (h *AIHandler) AnalyzePeopleInNote(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+}
+
+// GetUsage reports the user's AI usage over the last ?days=30 days, with the
+// quotas that apply to them
+func (h *AIHandler) GetUsage(c *gin.Context) {
+	userUUID, ok := aiUserID(c)
+	if !ok {
+		return
+	}
+	usage := h.aiService.Usage()
+	if usage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI usage is not recorded"})
+		return
+	}
+
+	report, err := usage.Report(userUUID, usageSince(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetServerUsage reports the AI usage of all users over the last ?days=30
+// days, with the ?limit=50 users using the most tokens
+func (h *AIHandler) GetServerUsage(c *gin.Context) {
+	usage := h.aiService.Usage()
+	if usage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI usage is not recorded"})
+		return
+	}
+
+	limit := 50
+	if parsedLimit, err := strconv.Atoi(c.Query("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+
+	report, err := usage.ServerReport(usageSince(c), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// usageSince returns the start of the ?days=30 days a usage report covers,
+// up to a year
+func usageSince(c *gin.Context) time.Time {
+	days := 30
+	if parsedDays, err := strconv.Atoi(c.Query("days")); err == nil && parsedDays > 0 {
+		days = parsedDays
+	}
+	if days > 365 {
+		days = 365
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// aiUserID returns the authenticated user's ID, writing an error response
+// when there is none
+func aiUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return uuid.Nil, false
+	}
+
+	// The auth middleware sets the ID as a string
+	switch id := userID.(type) {
+	case uuid.UUID:
+		return id, true
+	case string:
+		if parsed, err := uuid.Parse(id); err == nil {
+			return parsed, true
+		}
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+	return uuid.Nil, false
+}
+
+// writeAIError writes the response for an error from the AI service
+func writeAIError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrAIQuotaExceeded) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
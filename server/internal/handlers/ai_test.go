@@ -130,6 +130,7 @@ func TestAIHandler_ExtractTodos(t *testing.T) {
 	_, handler, router, mockServer := setupAIHandlerTestWithMockAI(t)
 	defer mockServer.Close()
 
+	addAuthMiddleware(router, uuid.New())
 	router.POST("/ai/extract-todos", handler.ExtractTodos)
 
 	tests := []struct {
@@ -609,6 +610,7 @@ func TestAIHandler_AnalyzePeopleInNote(t *testing.T) {
 func TestAIHandler_DisabledService(t *testing.T) {
 	_, handler, router := setupAIHandlerTest(t)
 
+	addAuthMiddleware(router, uuid.New())
 	router.POST("/ai/extract-todos", handler.ExtractTodos)
 
 	requestBody := ExtractTodosRequest{
@@ -633,4 +635,73 @@ func TestAIHandler_DisabledService(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "AI service not available", response.Error)
 	assert.Empty(t, response.Todos)
-}
\ No newline at end of file
+}
+func TestAIHandler_Usage(t *testing.T) {
+	db, _, router, mockServer := setupAIHandlerTestWithMockAI(t)
+	defer mockServer.Close()
+
+	user := models.User{ID: uuid.New(), Username: "usage_user", Email: "usage@example.com", Password: "hashedpassword", Role: models.RoleUser, IsActive: true}
+	require.NoError(t, db.Create(&user).Error)
+
+	aiService := services.NewAIService(db, &services.AIConfig{
+		Provider: "openai",
+		APIKey:   "test-key",
+		BaseURL:  mockServer.URL,
+		Quotas:   services.AIQuotas{UserDailyTokens: 10},
+	})
+	handler := NewAIHandler(aiService)
+
+	// The auth middleware sets the user ID as a string
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", user.ID.String())
+		c.Next()
+	})
+	router.POST("/ai/extract-todos", handler.ExtractTodos)
+	router.GET("/ai/usage", handler.GetUsage)
+	router.GET("/admin/ai/usage", handler.GetServerUsage)
+
+	extract := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ExtractTodosRequest{Content: models.JSONB{
+			"type": "doc",
+			"content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "Remember to send the quarterly report to the finance team by Friday"},
+			},
+		}})
+		req, _ := http.NewRequest("POST", "/ai/extract-todos", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	require.Equal(t, http.StatusOK, extract().Code)
+
+	// The call used up the user's daily tokens
+	w := extract()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "quota exceeded")
+
+	req, _ := http.NewRequest("GET", "/ai/usage?days=7", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var report services.AIUsageReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(1), report.Totals.Requests)
+	assert.Greater(t, report.Totals.TotalTokens, int64(10))
+	require.Len(t, report.ByFeature, 1)
+	assert.Equal(t, services.AIFeatureTodos, report.ByFeature[0].Name)
+	require.Len(t, report.Quotas, 1)
+	assert.Zero(t, report.Quotas[0].Remaining)
+
+	req, _ = http.NewRequest("GET", "/admin/ai/usage", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	report = services.AIUsageReport{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Len(t, report.ByUser, 1)
+	assert.Equal(t, user.ID, report.ByUser[0].UserID)
+	assert.Equal(t, "usage_user", report.ByUser[0].Username)
+}
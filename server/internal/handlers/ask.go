@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AskHandler answers questions about the user's notes and keeps the chat
+// sessions they are asked in
+type AskHandler struct {
+	askService *services.AskService
+}
+
+// NewAskHandler creates a new ask handler. embeddings may be nil, in which
+// case passages are found by keyword.
+func NewAskHandler(db *gorm.DB, aiService *services.AIService, embeddings *services.EmbeddingService) *AskHandler {
+	return &AskHandler{
+		askService: services.NewAskService(db, aiService, embeddings),
+	}
+}
+
+// AskRequest asks a question, continuing a chat session when SessionID is
+// set
+type AskRequest struct {
+	Question  string     `json:"question" binding:"required"`
+	SessionID *uuid.UUID `json:"session_id"`
+}
+
+// Ask answers a question from the user's notes, citing the passages the
+// answer draws on
+func (h *AskHandler) Ask(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req AskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Question) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question is required"})
+		return
+	}
+
+	response, err := h.askService.InWorkspace(activeWorkspace(c)).Ask(c.Request.Context(), uuid.MustParse(userID.(string)), req.SessionID, req.Question)
+	if err != nil {
+		writeAskError(c, err, "Failed to answer question")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AskStream answers a question like Ask, streaming the answer as
+// server-sent events: a "chunk" event for each piece of the answer as the
+// provider writes it, then a "done" event with the saved answer and its
+// citations, or an "error" event. Errors found before the answer starts are
+// returned as plain JSON responses. The answer stops when the client
+// disconnects.
+func (h *AskHandler) AskStream(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req AskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Question) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question is required"})
+		return
+	}
+
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+	}
+
+	response, err := h.askService.InWorkspace(activeWorkspace(c)).AskStream(c.Request.Context(), uuid.MustParse(userID.(string)), req.SessionID, req.Question, func(delta string) error {
+		start()
+		c.SSEvent("chunk", gin.H{"text": delta})
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		if !started {
+			writeAskError(c, err, "Failed to answer question")
+			return
+		}
+		if c.Request.Context().Err() == nil {
+			c.SSEvent("error", gin.H{"error": "Failed to answer question"})
+			c.Writer.Flush()
+		}
+		return
+	}
+
+	start()
+	c.SSEvent("done", response)
+	c.Writer.Flush()
+}
+
+// GetSessions lists the user's chat sessions
+func (h *AskHandler) GetSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	sessions, err := h.askService.InWorkspace(activeWorkspace(c)).Sessions(uuid.MustParse(userID.(string)))
+	if err != nil {
+		writeAskError(c, err, "Failed to fetch chat sessions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "total": len(sessions)})
+}
+
+// GetSession returns a chat session with its messages
+func (h *AskHandler) GetSession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := chatSessionParam(c)
+	if !ok {
+		return
+	}
+
+	session, err := h.askService.InWorkspace(activeWorkspace(c)).Session(uuid.MustParse(userID.(string)), id)
+	if err != nil {
+		writeAskError(c, err, "Failed to fetch chat session")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// DeleteSession deletes a chat session
+func (h *AskHandler) DeleteSession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := chatSessionParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.askService.InWorkspace(activeWorkspace(c)).DeleteSession(uuid.MustParse(userID.(string)), id); err != nil {
+		writeAskError(c, err, "Failed to delete chat session")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chat session deleted successfully"})
+}
+
+// chatSessionParam parses the :id path parameter, writing a 404 if it is not
+// a valid ID
+func chatSessionParam(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrChatSessionNotFound.Error()})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// writeAskError writes the response for an error from the ask service
+func writeAskError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrAIDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrChatSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAIQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupAskRouter(t *testing.T, aiService *services.AIService) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+
+	_, db, user := setupSearchRouter(t)
+	handler := NewAskHandler(db, aiService, nil)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", user.ID.String())
+		c.Next()
+	})
+	router.POST("/api/ai/ask", handler.Ask)
+	router.POST("/api/ai/ask/stream", handler.AskStream)
+	router.GET("/api/ai/sessions", handler.GetSessions)
+	router.GET("/api/ai/sessions/:id", handler.GetSession)
+	router.DELETE("/api/ai/sessions/:id", handler.DeleteSession)
+	return router, db
+}
+
+func TestAskHandler(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": "Go is covered in a tutorial [1]."}}},
+		})
+	}))
+	defer server.Close()
+	router, _ := setupAskRouter(t, services.NewAIService(nil, &services.AIConfig{
+		Provider: services.ProviderOpenAI, APIKey: "test-key", BaseURL: server.URL,
+	}))
+
+	w := makeRequest(t, router, "POST", "/api/ai/ask", "", map[string]interface{}{"question": "  "})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "POST", "/api/ai/ask", "", map[string]interface{}{"question": "Where did I write about Go programming?"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var answer services.AskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &answer))
+	assert.Equal(t, "Go is covered in a tutorial [1].", answer.Answer)
+	require.Len(t, answer.Citations, 1)
+	assert.Equal(t, "Go Programming Tutorial", answer.Citations[0].Title)
+
+	w = makeRequest(t, router, "POST", "/api/ai/ask", "", map[string]interface{}{"question": "And Python?", "session_id": answer.SessionID})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/ai/sessions/"+answer.SessionID.String(), "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var session models.ChatSession
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	assert.Len(t, session.Messages, 4)
+
+	w = makeRequest(t, router, "POST", "/api/ai/ask", "", map[string]interface{}{"question": "Hello?", "session_id": uuid.New()})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = makeRequest(t, router, "GET", "/api/ai/sessions/not-a-uuid", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "DELETE", "/api/ai/sessions/"+answer.SessionID.String(), "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = makeRequest(t, router, "GET", "/api/ai/sessions", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":0`)
+}
+
+func TestAskHandler_Disabled(t *testing.T) {
+	t.Parallel()
+	router, _ := setupAskRouter(t, services.NewAIService(nil, nil))
+
+	w := makeRequest(t, router, "POST", "/api/ai/ask", "", map[string]interface{}{"question": "What are my notes about?"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = makeRequest(t, router, "POST", "/api/ai/ask/stream", "", map[string]interface{}{"question": "What are my notes about?"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestAskHandler_AskStream(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range []string{"See the ", "tutorial [1]."} {
+			data, _ := json.Marshal(map[string]interface{}{
+				"choices": []map[string]interface{}{{"delta": map[string]string{"content": piece}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	router, _ := setupAskRouter(t, services.NewAIService(nil, &services.AIConfig{
+		Provider: services.ProviderOpenAI, APIKey: "test-key", BaseURL: server.URL,
+	}))
+
+	w := makeRequest(t, router, "POST", "/api/ai/ask/stream", "", map[string]interface{}{"question": "Where did I write about Go programming?"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, "event:chunk\ndata:{\"text\":\"See the \"}\n\n")
+	assert.Contains(t, body, "event:chunk\ndata:{\"text\":\"tutorial [1].\"}\n\n")
+	done := body[strings.Index(body, "event:done\ndata:")+len("event:done\ndata:"):]
+	var answer services.AskResponse
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(done)), &answer))
+	assert.Equal(t, "See the tutorial [1].", answer.Answer)
+	require.Len(t, answer.Citations, 1)
+	assert.Equal(t, "Go Programming Tutorial", answer.Citations[0].Title)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// multipartOverhead is allowed on top of the upload size limit for the
+// multipart framing and form fields around the file
+const multipartOverhead = 1 << 20
+
+// AttachmentHandler handles file uploads and their links to notes
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+}
+
+// NewAttachmentHandler creates a new attachment handler. Its routes are only
+// registered when file uploads are enabled.
+func NewAttachmentHandler(attachmentService *services.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+	}
+}
+
+// UploadAttachment stores a multipart "file" upload in the active workspace.
+// An optional "note_id" form field links it to that note.
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	userUUID := uuid.MustParse(userID.(string))
+
+	maxSize := h.attachmentService.MaxSize()
+	if maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named \"file\" is required"})
+		}
+		return
+	}
+	defer file.Close()
+	if maxSize > 0 && header.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		return
+	}
+
+	var noteID uuid.UUID
+	if raw := c.PostForm("note_id"); raw != "" {
+		if noteID, err = uuid.Parse(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+			return
+		}
+	}
+
+	attachment, err := h.attachmentService.Upload(userUUID, activeWorkspace(c), header.Filename, header.Header.Get("Content-Type"), file)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		}
+		return
+	}
+
+	if noteID != uuid.Nil {
+		if err := h.attachmentService.LinkToNote(userUUID, noteID, attachment.ID); err != nil {
+			writeNoteAccessError(c, err, "Failed to link attachment")
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// GetAttachment streams an attachment's contents
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	attachmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	attachment, content, err := h.attachmentService.GetAttachment(uuid.MustParse(userID.(string)), attachmentID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, services.ErrBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachment"})
+		}
+		return
+	}
+	defer content.Close()
+
+	// Contents never change for an ID, so the hash is a strong validator
+	etag := `"` + attachment.SHA256 + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// The content type is the uploader's, so only raster images, which
+	// cannot run scripts, are shown inline
+	disposition := "attachment"
+	if mediaType, _, err := mime.ParseMediaType(attachment.ContentType); err == nil && inlineContentTypes[mediaType] {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"Content-Security-Policy": "sandbox",
+		"ETag":                    etag,
+		"Cache-Control":           "private, max-age=" + strconv.Itoa(24*60*60),
+		"X-Content-Type-Options":  "nosniff",
+	})
+}
+
+// inlineContentTypes are the attachment types browsers may show in place
+var inlineContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// GetNoteAttachments lists the attachments linked to a note
+func (h *AttachmentHandler) GetNoteAttachments(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	attachments, err := h.attachmentService.ListNoteAttachments(uuid.MustParse(userID.(string)), noteID)
+	if err != nil {
+		writeNoteAccessError(c, err, "Failed to fetch attachments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"attachments": attachments, "total": len(attachments)})
+}
+
+// LinkAttachment links an existing attachment to a note
+func (h *AttachmentHandler) LinkAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, attachmentID, ok := attachmentParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.attachmentService.LinkToNote(uuid.MustParse(userID.(string)), noteID, attachmentID); err != nil {
+		writeNoteAccessError(c, err, "Failed to link attachment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment linked"})
+}
+
+// UnlinkAttachment removes an attachment from a note
+func (h *AttachmentHandler) UnlinkAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, attachmentID, ok := attachmentParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.attachmentService.UnlinkFromNote(uuid.MustParse(userID.(string)), noteID, attachmentID); err != nil {
+		writeNoteAccessError(c, err, "Failed to unlink attachment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment unlinked"})
+}
+
+// attachmentParams parses the :id and :attachment_id path parameters,
+// writing a 404 if either is not a valid ID
+func attachmentParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	attachmentID, err := uuid.Parse(c.Param("attachment_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return noteID, attachmentID, true
+}
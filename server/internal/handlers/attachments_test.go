@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeUploadRequest(t *testing.T, handler http.Handler, token, filename, content string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/api/attachments", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadAndDownloadAttachment(t *testing.T) {
+	t.Parallel()
+	router, db, _, ownerToken := setupSharesRouter(t)
+	_, strangerToken := createSharesTestUser(t, db, "stranger")
+
+	storage, err := services.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	attachmentHandler := NewAttachmentHandler(services.NewAttachmentService(db, storage, 32))
+	api := router.Group("/api")
+	api.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		api.POST("/attachments", attachmentHandler.UploadAttachment)
+		api.GET("/attachments/:id", attachmentHandler.GetAttachment)
+		api.GET("/notes/:id/attachments", attachmentHandler.GetNoteAttachments)
+	}
+
+	w := makeRequest(t, router, "POST", "/api/notes", ownerToken, CreateNoteRequest{Title: "Specs"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	w = makeUploadRequest(t, router, ownerToken, "huge.txt", strings.Repeat("x", 64), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = makeUploadRequest(t, router, ownerToken, "notes.txt", "meeting notes", map[string]string{"note_id": note.ID.String()})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var attachment models.Attachment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &attachment))
+	assert.Equal(t, "notes.txt", attachment.Filename)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/attachments", note.ID), ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), attachment.ID.String())
+
+	w = makeRequest(t, router, "GET", "/api/attachments/"+attachment.ID.String(), ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "meeting notes", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename=notes.txt`)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req := httptest.NewRequest("GET", "/api/attachments/"+attachment.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/attachments/"+attachment.ID.String(), strangerToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetAttachment_Disposition(t *testing.T) {
+	t.Parallel()
+	router, db, owner, ownerToken := setupSharesRouter(t)
+
+	storage, err := services.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	attachmentService := services.NewAttachmentService(db, storage, 0)
+	attachmentHandler := NewAttachmentHandler(attachmentService)
+	api := router.Group("/api")
+	api.Use(middleware.AuthMiddleware("test-secret"))
+	api.GET("/attachments/:id", attachmentHandler.GetAttachment)
+
+	tests := []struct {
+		filename    string
+		contentType string
+		disposition string
+	}{
+		{"photo.png", "image/png", "inline"},
+		{"photo.jpg", "image/jpeg; charset=binary", "inline"},
+		{"drawing.svg", "image/svg+xml", "attachment"},
+		{"page.html", "text/html", "attachment"},
+	}
+	for _, tt := range tests {
+		attachment, err := attachmentService.Upload(owner.ID, nil, tt.filename, tt.contentType, strings.NewReader("<svg onload=alert(1)>"+tt.filename))
+		require.NoError(t, err)
+
+		w := makeRequest(t, router, "GET", "/api/attachments/"+attachment.ID.String(), ownerToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, tt.filename)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Disposition"), tt.disposition+";"), tt.filename)
+		assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"), tt.filename)
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CommentHandler manages comment threads on notes and relays changes to the
+// note's collaboration room
+type CommentHandler struct {
+	db             *gorm.DB
+	commentService *services.CommentService
+	wsService      *services.WebSocketService
+}
+
+// NewCommentHandler creates a new comment handler. wsService may be nil, in
+// which case changes are not broadcast.
+func NewCommentHandler(db *gorm.DB, wsService *services.WebSocketService) *CommentHandler {
+	return &CommentHandler{
+		db:             db,
+		commentService: services.NewCommentService(db),
+		wsService:      wsService,
+	}
+}
+
+// CommentAnchor locates the text a thread is about: a dot-separated node path
+// into the note content and a range of offsets within that node
+type CommentAnchor struct {
+	Path string `json:"path" binding:"required"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// CreateCommentRequest starts a thread, or replies to one when parent_id is
+// set. Threads need an anchor; replies ignore it.
+type CreateCommentRequest struct {
+	Body             string         `json:"body" binding:"required"`
+	ParentID         *uuid.UUID     `json:"parent_id"`
+	Anchor           *CommentAnchor `json:"anchor"`
+	QuotedText       string         `json:"quoted_text"`
+	MentionUserIDs   []uuid.UUID    `json:"mention_user_ids"`
+	MentionPersonIDs []uuid.UUID    `json:"mention_person_ids"`
+}
+
+type UpdateCommentRequest struct {
+	Body             string      `json:"body" binding:"required"`
+	MentionUserIDs   []uuid.UUID `json:"mention_user_ids"`
+	MentionPersonIDs []uuid.UUID `json:"mention_person_ids"`
+}
+
+// GetComments lists the threads on a note (?status=open|resolved)
+func (h *CommentHandler) GetComments(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && status != "open" && status != "resolved" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open or resolved"})
+		return
+	}
+
+	threads, err := h.commentService.ListThreads(uuid.MustParse(userID.(string)), noteID, status)
+	if err != nil {
+		writeCommentError(c, err, "Failed to fetch comments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"comments": threads, "total": len(threads)})
+}
+
+// GetComment returns a single comment with its replies
+func (h *CommentHandler) GetComment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, commentID, ok := commentParams(c)
+	if !ok {
+		return
+	}
+
+	comment, err := h.commentService.GetComment(uuid.MustParse(userID.(string)), noteID, commentID)
+	if err != nil {
+		writeCommentError(c, err, "Failed to fetch comment")
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// CreateComment starts a thread or replies to one
+func (h *CommentHandler) CreateComment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	var req CreateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ParentID == nil && req.Anchor == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "anchor is required for a new thread"})
+		return
+	}
+
+	input := services.CommentInput{
+		ParentID:         req.ParentID,
+		Body:             req.Body,
+		QuotedText:       req.QuotedText,
+		MentionUserIDs:   req.MentionUserIDs,
+		MentionPersonIDs: req.MentionPersonIDs,
+	}
+	if req.Anchor != nil {
+		input.AnchorPath = req.Anchor.Path
+		input.AnchorFrom = req.Anchor.From
+		input.AnchorTo = req.Anchor.To
+	}
+
+	comment, err := h.commentService.CreateComment(uuid.MustParse(userID.(string)), noteID, input)
+	if err != nil {
+		writeCommentError(c, err, "Failed to create comment")
+		return
+	}
+
+	h.broadcast(c, noteID, models.CommentActionCreated, comment)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// UpdateComment edits the body of the caller's own comment
+func (h *CommentHandler) UpdateComment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, commentID, ok := commentParams(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment, err := h.commentService.UpdateComment(uuid.MustParse(userID.(string)), noteID, commentID, req.Body, req.MentionUserIDs, req.MentionPersonIDs)
+	if err != nil {
+		writeCommentError(c, err, "Failed to update comment")
+		return
+	}
+
+	h.broadcast(c, noteID, models.CommentActionUpdated, comment)
+	c.JSON(http.StatusOK, comment)
+}
+
+// ResolveComment marks a comment's thread as resolved
+func (h *CommentHandler) ResolveComment(c *gin.Context) {
+	h.setResolved(c, true)
+}
+
+// ReopenComment marks a resolved thread as open again
+func (h *CommentHandler) ReopenComment(c *gin.Context) {
+	h.setResolved(c, false)
+}
+
+func (h *CommentHandler) setResolved(c *gin.Context, resolved bool) {
+	userID, _ := c.Get("userID")
+
+	noteID, commentID, ok := commentParams(c)
+	if !ok {
+		return
+	}
+
+	thread, err := h.commentService.SetResolved(uuid.MustParse(userID.(string)), noteID, commentID, resolved)
+	if err != nil {
+		writeCommentError(c, err, "Failed to update comment")
+		return
+	}
+
+	action := models.CommentActionReopened
+	if resolved {
+		action = models.CommentActionResolved
+	}
+	h.broadcast(c, noteID, action, thread)
+	c.JSON(http.StatusOK, thread)
+}
+
+// DeleteComment removes a comment, or a whole thread when given its first comment
+func (h *CommentHandler) DeleteComment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, commentID, ok := commentParams(c)
+	if !ok {
+		return
+	}
+
+	comment, err := h.commentService.DeleteComment(uuid.MustParse(userID.(string)), noteID, commentID)
+	if err != nil {
+		writeCommentError(c, err, "Failed to delete comment")
+		return
+	}
+
+	h.broadcast(c, noteID, models.CommentActionDeleted, comment)
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
+}
+
+// broadcast tells the note's collaboration room about a comment change
+func (h *CommentHandler) broadcast(c *gin.Context, noteID uuid.UUID, action string, comment *models.Comment) {
+	if h.wsService == nil {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	username, _ := c.Get("username")
+	name, _ := username.(string)
+
+	h.wsService.BroadcastToNote(noteID, &models.WebSocketMessage{
+		Type:      models.MessageTypeComment,
+		UserID:    uuid.MustParse(userID.(string)),
+		Username:  name,
+		Timestamp: time.Now(),
+		Data: models.CommentData{
+			NoteID:  noteID,
+			Action:  action,
+			Comment: comment,
+		},
+	})
+}
+
+// commentParams parses the :id and :comment_id path parameters, writing a
+// 404 if either is not a valid ID
+func commentParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return noteID, commentID, true
+}
+
+// writeCommentError maps comment errors to responses, falling back to the
+// note access errors
+func writeCommentError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrCommentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotCommentAuthor):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidComment), errors.Is(err, services.ErrCommentAnchorInvalid),
+		errors.Is(err, services.ErrMentionNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, services.ErrNoteAccessDenied):
+		writeNoteAccessError(c, err, message)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
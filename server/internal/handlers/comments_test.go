@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommentThreadLifecycle(t *testing.T) {
+	t.Parallel()
+	router, db, _, ownerToken := setupSharesRouter(t)
+	commentHandler := NewCommentHandler(db, nil)
+	notes := router.Group("/api/notes")
+	notes.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		notes.GET("/:id/comments", commentHandler.GetComments)
+		notes.POST("/:id/comments", commentHandler.CreateComment)
+		notes.PUT("/:id/comments/:comment_id", commentHandler.UpdateComment)
+		notes.DELETE("/:id/comments/:comment_id", commentHandler.DeleteComment)
+		notes.POST("/:id/comments/:comment_id/resolve", commentHandler.ResolveComment)
+		notes.POST("/:id/comments/:comment_id/reopen", commentHandler.ReopenComment)
+	}
+	_, viewerToken := createSharesTestUser(t, db, "viewer")
+
+	content := models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Launch on Friday"},
+		}},
+	}}
+	w := makeRequest(t, router, "POST", "/api/notes", ownerToken, CreateNoteRequest{Title: "Plans", Content: content})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+	commentsURL := fmt.Sprintf("/api/notes/%s/comments", note.ID)
+
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/shares", note.ID), ownerToken, ShareNoteRequest{Username: "viewer", Role: "viewer"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Threads need a valid anchor
+	w = makeRequest(t, router, "POST", commentsURL, ownerToken, CreateCommentRequest{Body: "Why Friday?"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = makeRequest(t, router, "POST", commentsURL, ownerToken, CreateCommentRequest{Body: "Why Friday?", Anchor: &CommentAnchor{Path: "0", From: 10, To: 40}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "POST", commentsURL, ownerToken, CreateCommentRequest{
+		Body:   "Why Friday? @viewer",
+		Anchor: &CommentAnchor{Path: "0", From: 10, To: 16},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var thread models.Comment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &thread))
+	assert.Len(t, thread.Mentions, 1)
+	threadURL := fmt.Sprintf("%s/%s", commentsURL, thread.ID)
+
+	// Viewers can read threads but not write to them
+	w = makeRequest(t, router, "POST", commentsURL, viewerToken, CreateCommentRequest{Body: "Agreed", ParentID: &thread.ID})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = makeRequest(t, router, "GET", commentsURL, viewerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Why Friday?")
+
+	w = makeRequest(t, router, "POST", threadURL+"/resolve", ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = makeRequest(t, router, "GET", commentsURL+"?status=open", ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":0`)
+	w = makeRequest(t, router, "POST", threadURL+"/reopen", ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := "Why not Thursday?"
+	w = makeRequest(t, router, "PUT", threadURL, ownerToken, UpdateCommentRequest{Body: body})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), body)
+
+	w = makeRequest(t, router, "DELETE", threadURL, viewerToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = makeRequest(t, router, "DELETE", threadURL, ownerToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = makeRequest(t, router, "PUT", threadURL, ownerToken, UpdateCommentRequest{Body: body})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
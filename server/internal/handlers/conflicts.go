@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ConflictHandler exposes recorded edit conflicts and their resolution
+type ConflictHandler struct {
+	db              *gorm.DB
+	conflictService *services.ConflictService
+	shareService    *services.ShareService
+}
+
+// NewConflictHandler creates a new conflict handler. When wsService is not
+// nil, resolutions are serialised with its live edits.
+func NewConflictHandler(db *gorm.DB, wsService *services.WebSocketService) *ConflictHandler {
+	conflictService := services.NewConflictService(db)
+	if wsService != nil {
+		conflictService = conflictService.WithOperations(wsService.Operations())
+	}
+	return &ConflictHandler{
+		db:              db,
+		conflictService: conflictService,
+		shareService:    services.NewShareService(db),
+	}
+}
+
+// ResolveConflictRequest represents the request body for resolving a conflict
+type ResolveConflictRequest struct {
+	Resolution string       `json:"resolution" binding:"required,oneof=mine theirs merge"`
+	Content    models.JSONB `json:"content"`
+}
+
+// GetOpenConflicts returns the user's unresolved conflicts across all notes,
+// so a client can pick them up again after reconnecting
+func (h *ConflictHandler) GetOpenConflicts(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	conflicts, err := h.conflictService.ListConflicts(uuid.MustParse(userID.(string)), nil, models.ConflictStatusOpen)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conflicts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conflicts": conflicts, "total": len(conflicts)})
+}
+
+// GetNoteConflicts returns the conflicts recorded on a note. Only open conflicts
+// are returned unless ?status=resolved or ?status=all is given.
+func (h *ConflictHandler) GetNoteConflicts(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+		return
+	}
+
+	status := c.DefaultQuery("status", models.ConflictStatusOpen)
+	switch status {
+	case models.ConflictStatusOpen, models.ConflictStatusResolved:
+	case "all":
+		status = ""
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be one of: open, resolved, all"})
+		return
+	}
+
+	note, _, err := h.shareService.AuthorizeNote(uuid.MustParse(userID.(string)), noteID, models.NoteRoleViewer)
+	if err != nil {
+		writeNoteAccessError(c, err, "Failed to fetch note")
+		return
+	}
+
+	conflicts, err := h.conflictService.ListConflicts(uuid.MustParse(userID.(string)), &note.ID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conflicts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conflicts":       conflicts,
+		"total":           len(conflicts),
+		"current_version": note.Version,
+	})
+}
+
+// ResolveConflict settles a conflict by keeping the client's content ("mine"), the
+// server's content ("theirs") or a merged document ("merge"). A merge without content
+// is attempted automatically against the common ancestor version.
+func (h *ConflictHandler) ResolveConflict(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+		return
+	}
+	conflictID, err := uuid.Parse(c.Param("conflict_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conflict ID"})
+		return
+	}
+
+	var req ResolveConflictRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Content != nil {
+		req.Content = services.SanitizeContent(req.Content)
+	}
+
+	note, merge, err := h.conflictService.ResolveConflict(uuid.MustParse(userID.(string)), noteID, conflictID, req.Resolution, req.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conflict not found"})
+		case errors.Is(err, services.ErrNoteAccessDenied):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrMergeConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "merge": merge})
+		case errors.Is(err, services.ErrConflictResolved), errors.Is(err, services.ErrNoteChanged), errors.Is(err, services.ErrLocalContentMissing):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrAncestorUnavailable), errors.Is(err, services.ErrInvalidResolution):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve conflict"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conflict resolved successfully", "note": note})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupConflictsRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string) {
+	t.Helper()
+
+	router, db, user, token := setupNotesRouter(t)
+	conflictHandler := NewConflictHandler(db, nil)
+
+	notes := router.Group("/api/notes")
+	notes.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		notes.GET("/conflicts", conflictHandler.GetOpenConflicts)
+		notes.GET("/:id/conflicts", conflictHandler.GetNoteConflicts)
+		notes.POST("/:id/conflicts/:conflict_id/resolve", conflictHandler.ResolveConflict)
+	}
+
+	return router, db, user, token
+}
+
+func paragraphDoc(texts ...string) models.JSONB {
+	content := make([]interface{}, len(texts))
+	for i, text := range texts {
+		content[i] = map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": text},
+		}}
+	}
+	return models.JSONB{"type": "doc", "content": content}
+}
+
+func TestNoteConflicts(t *testing.T) {
+	t.Parallel()
+	router, db, user, token := setupConflictsRouter(t)
+
+	// Version 1 is created through the API so the ancestor is in the history
+	w := makeRequest(t, router, "POST", "/api/notes", token, CreateNoteRequest{
+		Title:   "Shared",
+		Content: paragraphDoc("a", "b"),
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	content := paragraphDoc("a", "B")
+	w = makeRequest(t, router, "PUT", fmt.Sprintf("/api/notes/%s", note.ID), token, UpdateNoteRequest{Content: &content})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	conflicts := services.NewConflictService(db)
+	first, err := conflicts.CreateConflict(&note, user.ID, 1, paragraphDoc("A", "b"))
+	require.NoError(t, err)
+	second, err := conflicts.CreateConflict(&note, user.ID, 1, paragraphDoc("a", "mine"))
+	require.NoError(t, err)
+
+	// Unresolved conflicts are listed after a reconnect
+	w = makeRequest(t, router, "GET", "/api/notes/conflicts", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		Conflicts []models.NoteConflict `json:"conflicts"`
+		Total     int                   `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Total)
+
+	// Merge without content merges against the common ancestor
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, first.ID), token, ResolveConflictRequest{
+		Resolution: models.ConflictResolutionMerge,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resolved struct {
+		Note models.Note `json:"note"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resolved))
+	assert.Equal(t, 3, resolved.Note.Version)
+	assert.Equal(t, paragraphDoc("A", "B"), resolved.Note.Content)
+
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, first.ID), token, ResolveConflictRequest{
+		Resolution: models.ConflictResolutionMine,
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Overlapping edits come back with the merge result
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, second.ID), token, ResolveConflictRequest{
+		Resolution: models.ConflictResolutionMerge,
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var mergeFailure struct {
+		Merge services.MergeResult `json:"merge"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &mergeFailure))
+	assert.Len(t, mergeFailure.Merge.Conflicts, 1)
+
+	// A hand-merged document settles it
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, second.ID), token, ResolveConflictRequest{
+		Resolution: models.ConflictResolutionMerge,
+		Content:    paragraphDoc("A", "B and mine"),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/conflicts?status=all", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Total)
+	for _, conflict := range list.Conflicts {
+		assert.Equal(t, models.ConflictStatusResolved, conflict.Status)
+	}
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/conflicts", note.ID), token, nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 0, list.Total)
+
+	// Validation and access checks
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, second.ID), token, ResolveConflictRequest{
+		Resolution: "rebase",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/conflicts?status=pending", note.ID), token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/conflicts/%s/resolve", note.ID, uuid.New()), token, ResolveConflictRequest{
+		Resolution: models.ConflictResolutionTheirs,
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/conflicts", uuid.New()), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"notesage-server/internal/models"
 	"notesage-server/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -13,11 +14,13 @@ import (
 
 type GraphHandler struct {
 	connectionService *services.ConnectionService
+	shareService      *services.ShareService
 }
 
 func NewGraphHandler(db *gorm.DB) *GraphHandler {
 	return &GraphHandler{
 		connectionService: services.NewConnectionService(db),
+		shareService:      services.NewShareService(db),
 	}
 }
 
@@ -56,7 +59,7 @@ func (h *GraphHandler) GetGraph(c *gin.Context) {
 		filterMap["tags"] = filters.Tags
 	}
 	
-	graphData, err := h.connectionService.GetGraphData(userUUID, filterMap)
+	graphData, err := h.connectionService.InWorkspace(activeWorkspace(c)).GetGraphData(userUUID, filterMap)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch graph data"})
 		return
@@ -82,7 +85,7 @@ func (h *GraphHandler) SearchGraph(c *gin.Context) {
 		return
 	}
 	
-	nodes, err := h.connectionService.SearchGraph(userUUID, req.Query, req.NodeType)
+	nodes, err := h.connectionService.InWorkspace(activeWorkspace(c)).SearchGraph(userUUID, req.Query, req.NodeType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search graph"})
 		return
@@ -106,7 +109,7 @@ func (h *GraphHandler) GetNodeConnections(c *gin.Context) {
 		return
 	}
 	
-	connections, err := h.connectionService.GetNodeConnections(userUUID, nodeID)
+	connections, err := h.connectionService.InWorkspace(activeWorkspace(c)).GetNodeConnections(userUUID, nodeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch node connections"})
 		return
@@ -139,7 +142,16 @@ func (h *GraphHandler) DetectConnections(c *gin.Context) {
 		return
 	}
 	
-	connections, err := h.connectionService.DetectConnections(userUUID, noteID, req.Content)
+	// Mentions in a shared note refer to the owner's people. Detection saves
+	// nothing, so unknown notes fall back to the caller's own people.
+	ownerID := userUUID
+	connectionService := h.connectionService.InWorkspace(activeWorkspace(c))
+	if note, _, err := h.shareService.AuthorizeNote(userUUID, noteID, models.NoteRoleViewer); err == nil {
+		ownerID = note.UserID
+		connectionService = h.connectionService.InWorkspace(note.WorkspaceID)
+	}
+	
+	connections, err := connectionService.DetectConnections(ownerID, noteID, req.Content)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detect connections"})
 		return
@@ -172,15 +184,23 @@ func (h *GraphHandler) UpdateConnections(c *gin.Context) {
 		return
 	}
 	
+	// Connections belong to the note's owner, whoever edits it
+	note, _, err := h.shareService.AuthorizeNote(userUUID, noteID, models.NoteRoleEditor)
+	if err != nil {
+		writeNoteAccessError(c, err, "Failed to fetch note")
+		return
+	}
+	
 	// Detect connections
-	connections, err := h.connectionService.DetectConnections(userUUID, noteID, req.Content)
+	connectionService := h.connectionService.InWorkspace(note.WorkspaceID)
+	connections, err := connectionService.DetectConnections(note.UserID, noteID, req.Content)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detect connections"})
 		return
 	}
 	
 	// Update connections in database
-	if err := h.connectionService.UpdateConnections(userUUID, noteID, connections); err != nil {
+	if err := connectionService.UpdateConnections(note.UserID, noteID, connections); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update connections"})
 		return
 	}
@@ -203,7 +223,7 @@ func (h *GraphHandler) ExportGraph(c *gin.Context) {
 		return
 	}
 	
-	data, err := h.connectionService.ExportGraphData(userUUID, req.Format)
+	data, err := h.connectionService.InWorkspace(activeWorkspace(c)).ExportGraphData(userUUID, req.Format)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export graph data"})
 		return
@@ -238,7 +258,7 @@ func (h *GraphHandler) GetGraphStats(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	userUUID := uuid.MustParse(userID.(string))
 	
-	graphData, err := h.connectionService.GetGraphData(userUUID, map[string]interface{}{})
+	graphData, err := h.connectionService.InWorkspace(activeWorkspace(c)).GetGraphData(userUUID, map[string]interface{}{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch graph data"})
 		return
@@ -308,7 +328,7 @@ func (h *GraphHandler) GetConnectionTypes(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	userUUID := uuid.MustParse(userID.(string))
 	
-	graphData, err := h.connectionService.GetGraphData(userUUID, map[string]interface{}{})
+	graphData, err := h.connectionService.InWorkspace(activeWorkspace(c)).GetGraphData(userUUID, map[string]interface{}{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch graph data"})
 		return
@@ -345,7 +365,7 @@ func (h *GraphHandler) GetSubgraph(c *gin.Context) {
 	}
 	
 	// Get the full graph first
-	fullGraph, err := h.connectionService.GetGraphData(userUUID, map[string]interface{}{})
+	fullGraph, err := h.connectionService.InWorkspace(activeWorkspace(c)).GetGraphData(userUUID, map[string]interface{}{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch graph data"})
 		return
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"notesage-server/internal/markdown"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// MarkdownHandler exports notes as Markdown files and imports Markdown files
+// and zipped folders of them as notes
+type MarkdownHandler struct {
+	importService *services.ImportService
+	shareService  *services.ShareService
+	maxUploadSize int64
+}
+
+// NewMarkdownHandler creates a new Markdown handler. Import uploads larger
+// than maxUploadSize bytes are refused; 0 means no limit.
+func NewMarkdownHandler(importService *services.ImportService, shareService *services.ShareService, maxUploadSize int64) *MarkdownHandler {
+	return &MarkdownHandler{
+		importService: importService,
+		shareService:  shareService,
+		maxUploadSize: maxUploadSize,
+	}
+}
+
+// ExportNote downloads a note as a file. Only format=markdown, the default,
+// is supported.
+func (h *MarkdownHandler) ExportNote(c *gin.Context) {
+	format := c.DefaultQuery("format", "markdown")
+	if format != "markdown" && format != "md" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported export format"})
+		return
+	}
+
+	note, _, ok := authorizeNote(c, h.shareService, models.NoteRoleViewer)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": markdown.Filename(note.Title) + ".md"}))
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(markdown.Export(note)))
+}
+
+// ImportNotes creates notes from a multipart "file" upload: a single .md,
+// .markdown or .txt file, or a .zip whose directories become folder paths.
+// An optional "folder_path" form field is the folder to import into.
+func (h *MarkdownHandler) ImportNotes(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	if h.maxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize+multipartOverhead)
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named \"file\" is required"})
+		}
+		return
+	}
+	defer file.Close()
+	if h.maxUploadSize > 0 && header.Size > h.maxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrUploadTooLarge.Error()})
+		return
+	}
+
+	folderPath := services.JoinFolderPath(c.PostForm("folder_path"), "")
+
+	var imported []services.ImportedNote
+	skipped := []services.SkippedItem{}
+	switch {
+	case strings.EqualFold(path.Ext(header.Filename), ".zip"):
+		var archiveSkipped []services.SkippedItem
+		imported, archiveSkipped, err = services.ReadMarkdownZip(file, header.Size, folderPath, h.maxUploadSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		skipped = append(skipped, archiveSkipped...)
+
+	case services.IsMarkdownFile(header.Filename):
+		data, err := io.ReadAll(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		note, err := services.ReadMarkdownFile(header.Filename, data, folderPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		imported = append(imported, *note)
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload a .md, .markdown, .txt or .zip file"})
+		return
+	}
+
+	// Sanitize content to prevent XSS
+	for i := range imported {
+		imported[i].Content = services.SanitizeContent(imported[i].Content)
+	}
+
+	batch := &services.ImportBatch{Notes: imported, Skipped: skipped}
+	result, err := h.importService.InWorkspace(activeWorkspace(c)).Import(uuid.MustParse(userID.(string)), batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import notes"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
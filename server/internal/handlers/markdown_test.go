@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupMarkdownRouter(t *testing.T) (*gin.Engine, *gorm.DB, string) {
+	router, db, _, token := setupSharesRouter(t)
+
+	markdownHandler := NewMarkdownHandler(services.NewImportService(db), services.NewShareService(db), 1<<20)
+	api := router.Group("/api")
+	api.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		api.POST("/notes/import", markdownHandler.ImportNotes)
+		api.GET("/notes/:id/export", markdownHandler.ExportNote)
+	}
+	return router, db, token
+}
+
+func makeImportRequest(t *testing.T, handler http.Handler, token, filename string, content []byte, folderPath string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	if folderPath != "" {
+		require.NoError(t, writer.WriteField("folder_path", folderPath))
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/api/notes/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestExportNoteAsMarkdown(t *testing.T) {
+	router, db, token := setupMarkdownRouter(t)
+	_, strangerToken := createSharesTestUser(t, db, "stranger")
+
+	content := models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "- [ ][t1] Book flights @alice 2024-06-01"},
+		}},
+		map[string]interface{}{"type": "taskList", "content": []interface{}{
+			map[string]interface{}{"type": "taskItem", "attrs": map[string]interface{}{"checked": true}, "content": []interface{}{
+				map[string]interface{}{"type": "paragraph", "content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "Pack"},
+				}},
+			}},
+		}},
+	}}
+	w := makeRequest(t, router, "POST", "/api/notes", token, CreateNoteRequest{Title: "Trip: plan", Content: content, Tags: []string{"travel"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/export?format=markdown", note.ID), token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="Trip- plan.md"`)
+	assert.Equal(t, "---\ntitle: 'Trip: plan'\ntags:\n    - travel\n---\n\n- [ ][t1] Book flights @alice 2024-06-01\n\n- [x] Pack\n", w.Body.String())
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/export?format=pdf", note.ID), token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/export", note.ID), strangerToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestImportNotesFromMarkdown(t *testing.T) {
+	router, db, token := setupMarkdownRouter(t)
+
+	w := makeImportRequest(t, router, token, "Groceries.md", []byte("- [ ][t1] Buy milk\n\n<script>alert(1)</script>"), "/Home")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var result services.ImportResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.Len(t, result.Notes, 1)
+	assert.Equal(t, "Groceries", result.Notes[0].Title)
+	assert.Equal(t, "/Home", result.Notes[0].FolderPath)
+	assert.NotContains(t, w.Body.String(), "<script>")
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	files := map[string]string{
+		"Work/Projects/plan.md":  "---\ntitle: Q3 plan\ntags: [work]\n---\nShip it",
+		"Work/standup.markdown":  "# Standup\n\nNotes",
+		"Work/diagram.png":       "png",
+		".obsidian/config.md":    "hidden",
+		"Personal/journal.txt":   "Dear diary",
+		"Personal/../../evil.md": "escape",
+	}
+	for name, body := range files {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	w = makeImportRequest(t, router, token, "vault.zip", archive.Bytes(), "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	result = services.ImportResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+
+	folders := map[string]string{}
+	for _, note := range result.Notes {
+		folders[note.Title] = note.FolderPath
+	}
+	assert.Equal(t, map[string]string{"Q3 plan": "/Work/Projects", "Standup": "/Work", "journal": "/Personal"}, folders)
+	assert.Len(t, result.Skipped, 3)
+
+	var count int64
+	db.Model(&models.Note{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+
+	w = makeImportRequest(t, router, token, "photo.png", []byte("png"), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeImportRequest(t, router, token, "broken.zip", []byte("not a zip"), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,9 +1,8 @@
 package handlers
 
 import (
-	"encoding/json"
+	"log"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -20,12 +19,24 @@ import (
 type NoteHandler struct {
 	db                *gorm.DB
 	connectionService *services.ConnectionService
+	revisionService   *services.RevisionService
+	shareService      *services.ShareService
+	savedSearches     *services.SavedSearchService
+	accessService     *services.NoteAccessService
+	wsService         *services.WebSocketService
 }
 
-func NewNoteHandler(db *gorm.DB) *NoteHandler {
+// NewNoteHandler creates a note handler. wsService may be nil, in which case
+// saved search notifications are not sent.
+func NewNoteHandler(db *gorm.DB, wsService *services.WebSocketService) *NoteHandler {
 	return &NoteHandler{
 		db:                db,
 		connectionService: services.NewConnectionService(db),
+		revisionService:   services.NewRevisionService(db),
+		shareService:      services.NewShareService(db),
+		savedSearches:     services.NewSavedSearchService(db, wsService),
+		accessService:     services.NewNoteAccessService(db),
+		wsService:         wsService,
 	}
 }
 
@@ -61,6 +72,8 @@ type SearchNotesRequest struct {
 	DateTo     *time.Time `json:"date_to" form:"date_to"`
 	Limit      int        `json:"limit" form:"limit"`
 	Offset     int        `json:"offset" form:"offset"`
+	// SavedSearch lists the notes matching a saved search instead
+	SavedSearch string `json:"saved_search" form:"saved_search"`
 }
 
 type NotesResponse struct {
@@ -70,112 +83,6 @@ type NotesResponse struct {
 	Offset int           `json:"offset"`
 }
 
-// sanitizeContent removes potentially dangerous HTML tags and attributes from text fields
-func sanitizeContent(content models.JSONB) models.JSONB {
-	if content == nil {
-		return content
-	}
-
-	// Convert to map for processing
-	var contentMap map[string]interface{}
-	contentBytes, err := json.Marshal(content)
-	if err != nil {
-		return content
-	}
-
-	err = json.Unmarshal(contentBytes, &contentMap)
-	if err != nil {
-		return content
-	}
-
-	// Recursively sanitize the content
-	sanitizedMap := sanitizeMap(contentMap)
-
-	// Convert back to JSONB
-	sanitizedBytes, err := json.Marshal(sanitizedMap)
-	if err != nil {
-		return content
-	}
-
-	var sanitizedContent models.JSONB
-	err = json.Unmarshal(sanitizedBytes, &sanitizedContent)
-	if err != nil {
-		return content
-	}
-
-	return sanitizedContent
-}
-
-// sanitizeMap recursively sanitizes text fields in a map
-func sanitizeMap(m map[string]interface{}) map[string]interface{} {
-	sanitized := make(map[string]interface{})
-
-	for key, value := range m {
-		switch v := value.(type) {
-		case string:
-			// Sanitize text fields
-			if key == "text" {
-				sanitized[key] = sanitizeText(v)
-			} else {
-				sanitized[key] = v
-			}
-		case map[string]interface{}:
-			// Recursively sanitize nested maps
-			sanitized[key] = sanitizeMap(v)
-		case []interface{}:
-			// Recursively sanitize arrays
-			sanitized[key] = sanitizeArray(v)
-		default:
-			sanitized[key] = v
-		}
-	}
-
-	return sanitized
-}
-
-// sanitizeArray recursively sanitizes arrays
-func sanitizeArray(arr []interface{}) []interface{} {
-	sanitized := make([]interface{}, len(arr))
-
-	for i, value := range arr {
-		switch v := value.(type) {
-		case string:
-			sanitized[i] = v
-		case map[string]interface{}:
-			sanitized[i] = sanitizeMap(v)
-		case []interface{}:
-			sanitized[i] = sanitizeArray(v)
-		default:
-			sanitized[i] = v
-		}
-	}
-
-	return sanitized
-}
-
-// sanitizeText removes script tags and other dangerous HTML
-func sanitizeText(text string) string {
-	// Remove script tags and their content
-	scriptRegex := regexp.MustCompile(`(?i)<script[^>]*>.*?</script>`)
-	text = scriptRegex.ReplaceAllString(text, "")
-
-	// Remove other potentially dangerous tags
-	dangerousTags := []string{"iframe", "object", "embed", "form", "input", "button", "select", "textarea"}
-	for _, tag := range dangerousTags {
-		regex := regexp.MustCompile(`(?i)<` + tag + `[^>]*>.*?</` + tag + `>`)
-		text = regex.ReplaceAllString(text, "")
-	}
-
-	// Remove dangerous attributes
-	dangerousAttrs := []string{"onclick", "onload", "onerror", "onmouseover", "onfocus", "onblur"}
-	for _, attr := range dangerousAttrs {
-		regex := regexp.MustCompile(`(?i)\s+` + attr + `\s*=\s*["'][^"']*["']`)
-		text = regex.ReplaceAllString(text, "")
-	}
-
-	return text
-}
-
 func (h *NoteHandler) GetNotes(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
@@ -197,7 +104,13 @@ func (h *NoteHandler) GetNotes(c *gin.Context) {
 	var notes []models.Note
 	var total int64
 
-	query := h.db.Model(&models.Note{}).Where("user_id = ?", userID)
+	// A saved search lists its notes like a folder, in place of the filters
+	if req.SavedSearch != "" {
+		h.getSavedSearchNotes(c, req)
+		return
+	}
+
+	query := h.db.Model(&models.Note{}).Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c)))
 
 	// Exclude archived notes by default unless explicitly requested
 	if req.IsArchived == nil {
@@ -278,6 +191,44 @@ func (h *NoteHandler) GetNotes(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// getSavedSearchNotes lists the notes matching the saved search in
+// req.SavedSearch, ordered like any other note list
+func (h *NoteHandler) getSavedSearchNotes(c *gin.Context, req SearchNotesRequest) {
+	userID, _ := c.Get("userID")
+
+	id, err := uuid.Parse(req.SavedSearch)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrSavedSearchNotFound.Error()})
+		return
+	}
+	query, err := h.savedSearches.InWorkspace(activeWorkspace(c)).NoteQuery(uuid.MustParse(userID.(string)), id)
+	if err != nil {
+		writeSearchError(c, err, "Failed to fetch saved search")
+		return
+	}
+
+	var notes []models.Note
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notes"})
+		return
+	}
+	if err := query.Order("notes.is_pinned DESC, notes.updated_at DESC").
+		Limit(req.Limit).
+		Offset(req.Offset).
+		Find(&notes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, NotesResponse{
+		Notes:  notes,
+		Total:  total,
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	})
+}
+
 func (h *NoteHandler) CreateNote(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
@@ -289,16 +240,17 @@ func (h *NoteHandler) CreateNote(c *gin.Context) {
 
 	// Sanitize content to prevent XSS
 	if req.Content != nil {
-		req.Content = sanitizeContent(req.Content)
+		req.Content = services.SanitizeContent(req.Content)
 	}
 
 	note := models.Note{
-		UserID:     uuid.MustParse(userID.(string)),
-		Title:      req.Title,
-		Content:    req.Content,
-		Category:   req.Category,
-		Tags:       pq.StringArray(req.Tags),
-		FolderPath: req.FolderPath,
+		UserID:      uuid.MustParse(userID.(string)),
+		WorkspaceID: activeWorkspace(c),
+		Title:       req.Title,
+		Content:     req.Content,
+		Category:    req.Category,
+		Tags:        pq.StringArray(req.Tags),
+		FolderPath:  req.FolderPath,
 	}
 
 	if req.Category == "" {
@@ -313,45 +265,42 @@ func (h *NoteHandler) CreateNote(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Create(&note).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+		if err := h.revisionService.RecordRevision(tx, &note, note.UserID, "Created"); err != nil {
+			return err
+		}
+		return services.RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionCreate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
 		return
 	}
 
 	// Detect and update connections for the new note
-	if note.Content != nil {
-		connections, err := h.connectionService.DetectConnections(note.UserID, note.ID, note.Content)
-		if err == nil {
-			// Update connections in background - don't fail the request if this fails
-			go func() {
-				h.connectionService.UpdateConnections(note.UserID, note.ID, connections)
-			}()
-		}
-	}
+	h.afterSave(&note, nil)
 
 	c.JSON(http.StatusCreated, note)
 }
 
 func (h *NoteHandler) GetNote(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	noteID := c.Param("id")
-
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
-		}
+	note, role, ok := authorizeNote(c, h.shareService, models.NoteRoleViewer)
+	if !ok {
 		return
 	}
 
+	userID, _ := c.Get("userID")
+	if err := h.accessService.Record(uuid.MustParse(userID.(string)), note.ID, models.NoteAccessOpen); err != nil {
+		log.Printf("Failed to record access to note %s: %v", note.ID, err)
+	}
+
+	c.Header("X-Note-Role", role)
 	c.JSON(http.StatusOK, note)
 }
 
 func (h *NoteHandler) UpdateNote(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	noteID := c.Param("id")
 
 	var req UpdateNoteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -359,13 +308,15 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
-		}
+	note, role, ok := authorizeNote(c, h.shareService, models.NoteRoleEditor)
+	if !ok {
+		return
+	}
+
+	// Where a note lives and how it is flagged belongs to its owner
+	if role != models.NoteRoleOwner &&
+		(req.FolderPath != nil || req.IsArchived != nil || req.IsPinned != nil || req.IsFavorite != nil) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can move, archive, pin or favorite a note"})
 		return
 	}
 
@@ -375,7 +326,7 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 	}
 	if req.Content != nil {
 		// Sanitize content to prevent XSS
-		sanitizedContent := sanitizeContent(*req.Content)
+		sanitizedContent := services.SanitizeContent(*req.Content)
 		note.Content = sanitizedContent
 	}
 	if req.Category != nil {
@@ -407,41 +358,91 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Save(&note).Error; err != nil {
+	// Remember which saved searches matched, to notify only new matches
+	var matched map[uuid.UUID]bool
+	if h.wsService != nil {
+		var err error
+		if matched, err = h.savedSearches.Matching(note); err != nil {
+			log.Printf("Failed to match saved searches for note %s: %v", note.ID, err)
+		}
+	}
+
+	if err := h.saveWithRevision(note, uuid.MustParse(userID.(string)), ""); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
 		return
 	}
 
 	// Detect and update connections for the updated note
+	h.afterSave(note, matched)
+
+	c.JSON(http.StatusOK, note)
+}
+
+// afterSave updates a saved note's connections and then tells users whose
+// saved searches it now matches, in the background so the request does not
+// wait. matched holds the saved searches the note matched before, and is nil
+// for new notes.
+func (h *NoteHandler) afterSave(note *models.Note, matched map[uuid.UUID]bool) {
+	var connections []services.DetectedConnection
+	detected := false
+	connectionService := h.connectionService.InWorkspace(note.WorkspaceID)
 	if note.Content != nil {
-		connections, err := h.connectionService.DetectConnections(note.UserID, note.ID, note.Content)
-		if err == nil {
-			// Update connections in background - don't fail the request if this fails
-			go func() {
-				h.connectionService.UpdateConnections(note.UserID, note.ID, connections)
-			}()
-		}
+		var err error
+		connections, err = connectionService.DetectConnections(note.UserID, note.ID, note.Content)
+		detected = err == nil
+	}
+	if !detected && h.wsService == nil {
+		return
 	}
 
-	c.JSON(http.StatusOK, note)
+	saved := *note
+	// Don't fail the request if this fails
+	go func() {
+		if detected {
+			connectionService.UpdateConnections(saved.UserID, saved.ID, connections)
+		}
+		if h.wsService != nil {
+			h.savedSearches.NotifyNewMatches(&saved, matched)
+		}
+	}()
+}
+
+// saveWithRevision persists a note and records its new version in the history
+func (h *NoteHandler) saveWithRevision(note *models.Note, authorID uuid.UUID, changeDescription string) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(note).Error; err != nil {
+			return err
+		}
+		if err := h.revisionService.RecordRevision(tx, note, authorID, changeDescription); err != nil {
+			return err
+		}
+		return services.RecordNoteSyncChange(tx, note, models.SyncEntityNote, note.ID, models.SyncActionUpdate)
+	})
 }
 
 func (h *NoteHandler) DeleteNote(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	noteID := c.Param("id")
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
 
-	result := h.db.Where("id = ? AND user_id = ?", noteID, userID).Delete(&models.Note{})
-	if result.Error != nil {
+	var deleted bool
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		deleted, err = services.DeleteNote(tx, uuid.MustParse(userID.(string)), activeWorkspace(c), noteID)
+		return err
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
 		return
 	}
 
-	if result.RowsAffected == 0 {
+	if !deleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Note moved to trash"})
 }
 
 // SearchNotes provides advanced search functionality
@@ -470,7 +471,7 @@ func (h *NoteHandler) SearchNotes(c *gin.Context) {
 	var notes []models.Note
 	var total int64
 
-	query := h.db.Model(&models.Note{}).Where("user_id = ?", userID)
+	query := h.db.Model(&models.Note{}).Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c)))
 
 	// Full-text search - use LIKE for compatibility with SQLite and PostgreSQL
 	searchQuery := strings.ToLower(req.Query)
@@ -541,7 +542,8 @@ func (h *NoteHandler) ArchiveNote(c *gin.Context) {
 	noteID := c.Param("id")
 
 	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+	if err := h.db.Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("id = ?", noteID).First(&note).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		} else {
@@ -553,7 +555,7 @@ func (h *NoteHandler) ArchiveNote(c *gin.Context) {
 	note.IsArchived = true
 	note.Version++
 
-	if err := h.db.Save(&note).Error; err != nil {
+	if err := h.saveWithRevision(&note, uuid.MustParse(userID.(string)), "Archived"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive note"})
 		return
 	}
@@ -567,7 +569,8 @@ func (h *NoteHandler) RestoreNote(c *gin.Context) {
 	noteID := c.Param("id")
 
 	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+	if err := h.db.Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("id = ?", noteID).First(&note).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		} else {
@@ -579,7 +582,7 @@ func (h *NoteHandler) RestoreNote(c *gin.Context) {
 	note.IsArchived = false
 	note.Version++
 
-	if err := h.db.Save(&note).Error; err != nil {
+	if err := h.saveWithRevision(&note, uuid.MustParse(userID.(string)), "Unarchived"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore note"})
 		return
 	}
@@ -608,7 +611,8 @@ func (h *NoteHandler) GetArchivedNotes(c *gin.Context) {
 	var notes []models.Note
 	var total int64
 
-	query := h.db.Model(&models.Note{}).Where("user_id = ? AND is_archived = ?", userID, true)
+	query := h.db.Model(&models.Note{}).Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("is_archived = ?", true)
 
 	// Get total count
 	if err := query.Count(&total).Error; err != nil {
@@ -662,7 +666,8 @@ func (h *NoteHandler) GetNotesByCategory(c *gin.Context) {
 	var notes []models.Note
 	var total int64
 
-	query := h.db.Model(&models.Note{}).Where("user_id = ? AND category = ? AND is_archived = ?", userID, category, false)
+	query := h.db.Model(&models.Note{}).Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("category = ? AND is_archived = ?", category, false)
 
 	// Get total count
 	if err := query.Count(&total).Error; err != nil {
@@ -716,7 +721,8 @@ func (h *NoteHandler) GetNotesByTag(c *gin.Context) {
 	var notes []models.Note
 	var total int64
 
-	query := h.db.Model(&models.Note{}).Where("user_id = ? AND tags LIKE ? AND is_archived = ?", userID, "%\""+tag+"\"%", false)
+	query := h.db.Model(&models.Note{}).Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("tags LIKE ? AND is_archived = ?", "%\""+tag+"\"%", false)
 
 	// Get total count
 	if err := query.Count(&total).Error; err != nil {
@@ -32,7 +32,7 @@ func setupNotesRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string
 		},
 	}
 
-	noteHandler := NewNoteHandler(db)
+	noteHandler := NewNoteHandler(db, nil)
 	authHandler := NewAuthHandler(db, cfg)
 
 	gin.SetMode(gin.TestMode)
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"notesage-server/internal/models"
+	"notesage-server/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -43,7 +44,6 @@ type UpdatePersonRequest struct {
 }
 
 func (h *PersonHandler) GetPeople(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	
 	// Parse query parameters for search and filtering
 	search := c.Query("search")
@@ -54,7 +54,7 @@ func (h *PersonHandler) GetPeople(c *gin.Context) {
 	limit, _ := strconv.Atoi(limitStr)
 	offset, _ := strconv.Atoi(offsetStr)
 	
-	query := h.db.Where("user_id = ?", userID)
+	query := h.db.Scopes(peopleInScope(c))
 	
 	// Apply search filter
 	if search != "" {
@@ -88,6 +88,7 @@ func (h *PersonHandler) CreatePerson(c *gin.Context) {
 	
 	person := models.Person{
 		UserID:      uuid.MustParse(userID.(string)),
+		WorkspaceID: activeWorkspace(c),
 		Name:        req.Name,
 		Email:       req.Email,
 		Phone:       req.Phone,
@@ -98,7 +99,12 @@ func (h *PersonHandler) CreatePerson(c *gin.Context) {
 		Notes:       req.Notes,
 	}
 	
-	if err := h.db.Create(&person).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&person).Error; err != nil {
+			return err
+		}
+		return services.RecordSyncChange(tx, person.UserID, models.SyncEntityPerson, person.ID, models.SyncActionCreate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create person"})
 		return
 	}
@@ -107,11 +113,10 @@ func (h *PersonHandler) CreatePerson(c *gin.Context) {
 }
 
 func (h *PersonHandler) GetPerson(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	personID := c.Param("id")
 	
 	var person models.Person
-	if err := h.db.Where("id = ? AND user_id = ?", personID, userID).First(&person).Error; err != nil {
+	if err := h.db.Scopes(peopleInScope(c)).Where("id = ?", personID).First(&person).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
 		} else {
@@ -124,7 +129,6 @@ func (h *PersonHandler) GetPerson(c *gin.Context) {
 }
 
 func (h *PersonHandler) UpdatePerson(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	personID := c.Param("id")
 	
 	var req UpdatePersonRequest
@@ -134,7 +138,7 @@ func (h *PersonHandler) UpdatePerson(c *gin.Context) {
 	}
 	
 	var person models.Person
-	if err := h.db.Where("id = ? AND user_id = ?", personID, userID).First(&person).Error; err != nil {
+	if err := h.db.Scopes(peopleInScope(c)).Where("id = ?", personID).First(&person).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
 		} else {
@@ -169,7 +173,12 @@ func (h *PersonHandler) UpdatePerson(c *gin.Context) {
 		person.Notes = *req.Notes
 	}
 	
-	if err := h.db.Save(&person).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&person).Error; err != nil {
+			return err
+		}
+		return services.RecordSyncChange(tx, person.UserID, models.SyncEntityPerson, person.ID, models.SyncActionUpdate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update person"})
 		return
 	}
@@ -179,20 +188,31 @@ func (h *PersonHandler) UpdatePerson(c *gin.Context) {
 
 func (h *PersonHandler) DeletePerson(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	personID := c.Param("id")
+	personID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
 	
-	result := h.db.Where("id = ? AND user_id = ?", personID, userID).Delete(&models.Person{})
-	if result.Error != nil {
+	var deleted int64
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Scopes(peopleInScope(c)).Where("id = ?", personID).Delete(&models.Person{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return services.RecordSyncChange(tx, uuid.MustParse(userID.(string)), models.SyncEntityPerson, personID, models.SyncActionDelete)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete person"})
 		return
 	}
 	
-	if result.RowsAffected == 0 {
+	if deleted == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"message": "Person deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Person moved to trash"})
 }
 
 // PersonConnection represents a connection between a person and notes
@@ -213,11 +233,12 @@ type PersonConnectionsResponse struct {
 
 func (h *PersonHandler) GetPersonConnections(c *gin.Context) {
 	userID, _ := c.Get("userID")
+	userUUID := uuid.MustParse(userID.(string))
 	personID := c.Param("id")
 	
 	// First, verify the person exists and belongs to the user
 	var person models.Person
-	if err := h.db.Where("id = ? AND user_id = ?", personID, userID).First(&person).Error; err != nil {
+	if err := h.db.Scopes(peopleInScope(c)).Where("id = ?", personID).First(&person).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
 		} else {
@@ -237,9 +258,10 @@ func (h *PersonHandler) GetPersonConnections(c *gin.Context) {
 	err := h.db.Table("connections").
 		Select("notes.id as note_id, notes.title as note_title, notes.updated_at, COUNT(*) as connections").
 		Joins("JOIN notes ON connections.source_id = notes.id OR connections.target_id = notes.id").
-		Where("connections.user_id = ? AND ((connections.source_id = ? AND connections.source_type = 'person') OR (connections.target_id = ? AND connections.target_type = 'person'))", 
-			userID, personID, personID).
-		Where("notes.user_id = ?", userID).
+		Scopes(services.OwnedBy("connections", userUUID, activeWorkspace(c)), services.OwnedBy("notes", userUUID, activeWorkspace(c))).
+		Where("((connections.source_id = ? AND connections.source_type = 'person') OR (connections.target_id = ? AND connections.target_type = 'person'))", 
+			personID, personID).
+		Where("notes.deleted_at IS NULL").
 		Group("notes.id, notes.title, notes.updated_at").
 		Order("notes.updated_at DESC").
 		Find(&connections).Error
@@ -264,7 +286,8 @@ func (h *PersonHandler) GetPersonConnections(c *gin.Context) {
 	var totalTodos int64
 	h.db.Model(&models.Todo{}).
 		Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("todos.assigned_person_id = ? AND notes.user_id = ?", personID, userID).
+		Scopes(services.OwnedBy("notes", userUUID, activeWorkspace(c))).
+		Where("todos.assigned_person_id = ?", personID).
 		Count(&totalTodos)
 	
 	response := PersonConnectionsResponse{
@@ -278,7 +301,6 @@ func (h *PersonHandler) GetPersonConnections(c *gin.Context) {
 }
 
 func (h *PersonHandler) SearchPeople(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	query := c.Query("q")
 	
 	if query == "" {
@@ -289,8 +311,8 @@ func (h *PersonHandler) SearchPeople(c *gin.Context) {
 	searchTerm := "%" + strings.ToLower(query) + "%"
 	
 	var people []models.Person
-	err := h.db.Where("user_id = ? AND (LOWER(name) LIKE ? OR LOWER(email) LIKE ? OR LOWER(company) LIKE ?)", 
-		userID, searchTerm, searchTerm, searchTerm).
+	err := h.db.Scopes(peopleInScope(c)).Where("(LOWER(name) LIKE ? OR LOWER(email) LIKE ? OR LOWER(company) LIKE ?)", 
+		searchTerm, searchTerm, searchTerm).
 		Order("name ASC").
 		Limit(20).
 		Find(&people).Error
@@ -319,7 +341,7 @@ func (h *PersonHandler) CreatePersonConnection(c *gin.Context) {
 	
 	// Verify person exists and belongs to user
 	var person models.Person
-	if err := h.db.Where("id = ? AND user_id = ?", personID, userID).First(&person).Error; err != nil {
+	if err := h.db.Scopes(peopleInScope(c)).Where("id = ?", personID).First(&person).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
 		} else {
@@ -330,7 +352,8 @@ func (h *PersonHandler) CreatePersonConnection(c *gin.Context) {
 	
 	// Verify note exists and belongs to user
 	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", req.NoteID, userID).First(&note).Error; err != nil {
+	if err := h.db.Scopes(services.OwnedBy("notes", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("id = ?", req.NoteID).First(&note).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		} else {
@@ -341,13 +364,19 @@ func (h *PersonHandler) CreatePersonConnection(c *gin.Context) {
 	
 	// Check if connection already exists
 	var existingConnection models.Connection
-	err := h.db.Where("user_id = ? AND ((source_id = ? AND source_type = 'person' AND target_id = ? AND target_type = 'note') OR (source_id = ? AND source_type = 'note' AND target_id = ? AND target_type = 'person'))", 
-		userID, personID, req.NoteID, req.NoteID, personID).First(&existingConnection).Error
+	err := h.db.Scopes(services.OwnedBy("connections", uuid.MustParse(userID.(string)), activeWorkspace(c))).
+		Where("((source_id = ? AND source_type = 'person' AND target_id = ? AND target_type = 'note') OR (source_id = ? AND source_type = 'note' AND target_id = ? AND target_type = 'person'))", 
+			personID, req.NoteID, req.NoteID, personID).First(&existingConnection).Error
 	
 	if err == nil {
 		// Connection already exists, increment strength
 		existingConnection.Strength++
-		if err := h.db.Save(&existingConnection).Error; err != nil {
+		if err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&existingConnection).Error; err != nil {
+				return err
+			}
+			return services.RecordSyncChange(tx, existingConnection.UserID, models.SyncEntityConnection, existingConnection.ID, models.SyncActionUpdate)
+		}); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update connection"})
 			return
 		}
@@ -360,18 +389,31 @@ func (h *PersonHandler) CreatePersonConnection(c *gin.Context) {
 	
 	// Create new connection
 	connection := models.Connection{
-		UserID:     uuid.MustParse(userID.(string)),
-		SourceID:   uuid.MustParse(personID),
+		UserID:      uuid.MustParse(userID.(string)),
+		WorkspaceID: activeWorkspace(c),
+		SourceID:    uuid.MustParse(personID),
 		SourceType: "person",
 		TargetID:   uuid.MustParse(req.NoteID),
 		TargetType: "note",
 		Strength:   1,
 	}
 	
-	if err := h.db.Create(&connection).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&connection).Error; err != nil {
+			return err
+		}
+		return services.RecordSyncChange(tx, connection.UserID, models.SyncEntityConnection, connection.ID, models.SyncActionCreate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create connection"})
 		return
 	}
 	
 	c.JSON(http.StatusCreated, connection)
-}
\ No newline at end of file
+}
+
+// peopleInScope scopes a people query to the active workspace or the user's
+// personal people
+func peopleInScope(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	userID, _ := c.Get("userID")
+	return services.OwnedBy("people", uuid.MustParse(userID.(string)), activeWorkspace(c))
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RevisionHandler exposes note version history
+type RevisionHandler struct {
+	db              *gorm.DB
+	revisionService *services.RevisionService
+	shareService    *services.ShareService
+}
+
+// NewRevisionHandler creates a new revision handler
+func NewRevisionHandler(db *gorm.DB) *RevisionHandler {
+	return &RevisionHandler{
+		db:              db,
+		revisionService: services.NewRevisionService(db),
+		shareService:    services.NewShareService(db),
+	}
+}
+
+// ListVersions returns the version history of a note, newest first
+func (h *RevisionHandler) ListVersions(c *gin.Context) {
+	note, _, ok := authorizeNote(c, h.shareService, models.NoteRoleViewer)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	versions, total, err := h.revisionService.ListRevisions(note.ID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions":        versions,
+		"total":           total,
+		"limit":           limit,
+		"offset":          offset,
+		"current_version": note.Version,
+	})
+}
+
+// GetVersion returns a single version of a note including its content
+func (h *RevisionHandler) GetVersion(c *gin.Context) {
+	note, _, ok := authorizeNote(c, h.shareService, models.NoteRoleViewer)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	revision, err := h.revisionService.GetRevision(note.ID, version)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffVersions returns a structural diff between two versions of a note.
+// "to" defaults to the current version when omitted.
+func (h *RevisionHandler) DiffVersions(c *gin.Context) {
+	note, _, ok := authorizeNote(c, h.shareService, models.NoteRoleViewer)
+	if !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'from' must be a version number"})
+		return
+	}
+
+	to := note.Version
+	if toStr := c.Query("to"); toStr != "" {
+		to, err = strconv.Atoi(toStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'to' must be a version number"})
+			return
+		}
+	}
+
+	diff, err := h.revisionService.DiffRevisions(note.ID, from, to)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff versions"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RestoreVersion makes an earlier version the current content of a note
+func (h *RevisionHandler) RestoreVersion(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	note, _, ok := authorizeNote(c, h.shareService, models.NoteRoleEditor)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	if err := h.revisionService.RestoreRevision(note, version, uuid.MustParse(userID.(string))); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Version restored successfully", "note": note})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupRevisionsRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string) {
+	t.Helper()
+
+	router, db, user, token := setupNotesRouter(t)
+	revisionHandler := NewRevisionHandler(db)
+
+	notes := router.Group("/api/notes")
+	notes.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		notes.GET("/:id/versions", revisionHandler.ListVersions)
+		notes.GET("/:id/versions/diff", revisionHandler.DiffVersions)
+		notes.GET("/:id/versions/:version", revisionHandler.GetVersion)
+		notes.POST("/:id/versions/:version/restore", revisionHandler.RestoreVersion)
+	}
+
+	return router, db, user, token
+}
+
+func TestNoteVersionHistory(t *testing.T) {
+	t.Parallel()
+	router, _, _, token := setupRevisionsRouter(t)
+
+	// Create and edit a note through the API so every save is recorded
+	w := makeRequest(t, router, "POST", "/api/notes", token, CreateNoteRequest{
+		Title:   "Draft",
+		Content: models.JSONB{"type": "doc", "content": []interface{}{}},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	content := models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "First paragraph"},
+		}},
+	}}
+	w = makeRequest(t, router, "PUT", fmt.Sprintf("/api/notes/%s", note.ID), token, UpdateNoteRequest{
+		Title:   stringPtr("Final"),
+		Content: &content,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// List versions
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		Versions       []services.RevisionSummary `json:"versions"`
+		Total          int64                      `json:"total"`
+		CurrentVersion int                        `json:"current_version"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, int64(2), list.Total)
+	assert.Equal(t, 2, list.CurrentVersion)
+	require.Len(t, list.Versions, 2)
+	assert.Equal(t, "Final", list.Versions[0].Title)
+	assert.Equal(t, "Created", list.Versions[1].ChangeDescription)
+
+	// Get a single version
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions/1", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var revision models.NoteRevision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revision))
+	assert.Equal(t, "Draft", revision.Title)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions/42", note.ID), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions/latest", note.ID), token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Diff defaults "to" to the current version
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions/diff?from=1", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var diff services.NoteDiff
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, 2, diff.ToVersion)
+	assert.Equal(t, 1, diff.Stats.Added)
+	require.Len(t, diff.Blocks, 1)
+	assert.Equal(t, "First paragraph", diff.Blocks[0].NewText)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions/diff", note.ID), token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Restore the first version; the restore becomes version 3
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/notes/%s/versions/1/restore", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var restored struct {
+		Note models.Note `json:"note"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+	assert.Equal(t, "Draft", restored.Note.Title)
+	assert.Equal(t, 3, restored.Note.Version)
+
+	// Another user's note is not visible
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s/versions", uuid.New()), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
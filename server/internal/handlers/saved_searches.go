@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SavedSearchHandler manages saved searches and runs them
+type SavedSearchHandler struct {
+	savedSearchService *services.SavedSearchService
+}
+
+// NewSavedSearchHandler creates a new saved search handler
+func NewSavedSearchHandler(db *gorm.DB, wsService *services.WebSocketService) *SavedSearchHandler {
+	return &SavedSearchHandler{
+		savedSearchService: services.NewSavedSearchService(db, wsService),
+	}
+}
+
+// SavedSearchRequest creates a saved search. Query is in the search query
+// language; filters takes the other parameters of GET /api/search, such as
+// categories, tags and is_pinned.
+type SavedSearchRequest struct {
+	Name    string       `json:"name" binding:"required"`
+	Icon    string       `json:"icon"`
+	Query   string       `json:"query"`
+	Filters models.JSONB `json:"filters"`
+	Notify  bool         `json:"notify"`
+}
+
+// GetSavedSearches lists the user's saved searches
+func (h *SavedSearchHandler) GetSavedSearches(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	searches, err := h.savedSearchService.InWorkspace(activeWorkspace(c)).List(uuid.MustParse(userID.(string)))
+	if err != nil {
+		writeSearchError(c, err, "Failed to fetch saved searches")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_searches": searches, "total": len(searches)})
+}
+
+// CreateSavedSearch saves a search
+func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req SavedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved := models.SavedSearch{
+		Name:    req.Name,
+		Icon:    req.Icon,
+		Query:   req.Query,
+		Filters: req.Filters,
+		Notify:  req.Notify,
+	}
+	if err := h.savedSearchService.InWorkspace(activeWorkspace(c)).Create(uuid.MustParse(userID.(string)), &saved); err != nil {
+		writeSearchError(c, err, "Failed to create saved search")
+		return
+	}
+
+	c.JSON(http.StatusCreated, saved)
+}
+
+// GetSavedSearch returns a saved search
+func (h *SavedSearchHandler) GetSavedSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := savedSearchParam(c)
+	if !ok {
+		return
+	}
+
+	saved, err := h.savedSearchService.InWorkspace(activeWorkspace(c)).Get(uuid.MustParse(userID.(string)), id)
+	if err != nil {
+		writeSearchError(c, err, "Failed to fetch saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// UpdateSavedSearch changes the fields of a saved search that are set
+func (h *SavedSearchHandler) UpdateSavedSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := savedSearchParam(c)
+	if !ok {
+		return
+	}
+
+	var req services.SavedSearchUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := h.savedSearchService.InWorkspace(activeWorkspace(c)).Update(uuid.MustParse(userID.(string)), id, req)
+	if err != nil {
+		writeSearchError(c, err, "Failed to update saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteSavedSearch removes a saved search
+func (h *SavedSearchHandler) DeleteSavedSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := savedSearchParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.savedSearchService.InWorkspace(activeWorkspace(c)).Delete(uuid.MustParse(userID.(string)), id); err != nil {
+		writeSearchError(c, err, "Failed to delete saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted successfully"})
+}
+
+// RunSavedSearch runs a saved search, taking limit, offset, types and
+// include_snippets from the query string
+func (h *SavedSearchHandler) RunSavedSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	id, ok := savedSearchParam(c)
+	if !ok {
+		return
+	}
+
+	var page services.SearchRequest
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.savedSearchService.InWorkspace(activeWorkspace(c)).Run(uuid.MustParse(userID.(string)), id, page)
+	if err != nil {
+		writeSearchError(c, err, "Failed to run saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// savedSearchParam parses the :id path parameter, writing a 404 if it is not
+// a valid ID
+func savedSearchParam(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrSavedSearchNotFound.Error()})
+		return uuid.Nil, false
+	}
+	return id, true
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedSearches(t *testing.T) {
+	t.Parallel()
+	router, _, _ := setupSearchRouter(t)
+
+	w := makeRequest(t, router, "POST", "/api/search/saved", "", gin.H{
+		"name": "Pinned Go", "icon": "star", "query": "tag:go", "filters": gin.H{"is_pinned": true},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var saved models.SavedSearch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &saved))
+	path := "/api/search/saved/" + saved.ID.String()
+
+	w = makeRequest(t, router, "GET", "/api/search/saved", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Pinned Go")
+
+	w = makeRequest(t, router, "GET", path+"/results?include_snippets=true", "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var results services.UnifiedSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, "Go Programming Tutorial", results.Results[0].Title)
+
+	// Saved searches list like folders
+	w = makeRequest(t, router, "GET", "/api/notes?saved_search="+saved.ID.String(), "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var notes NotesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &notes))
+	assert.Equal(t, int64(1), notes.Total)
+
+	w = makeRequest(t, router, "PUT", path, "", gin.H{"query": "tag:"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"position":4`)
+
+	w = makeRequest(t, router, "PUT", path, "", gin.H{"name": "Go"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "DELETE", path, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, missing := range []string{path, "/api/search/saved/not-a-uuid", "/api/search/saved/" + uuid.NewString() + "/results"} {
+		w = makeRequest(t, router, "GET", missing, "", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, missing)
+	}
+	w = makeRequest(t, router, "GET", "/api/notes?saved_search="+saved.ID.String(), "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "POST", "/api/search/saved", "", gin.H{"query": "go"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
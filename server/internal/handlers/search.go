@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,16 +15,30 @@ import (
 )
 
 type SearchHandler struct {
-	searchService *services.SearchService
+	searchService    *services.SearchService
+	analyticsService *services.SearchAnalyticsService
+	accessService    *services.NoteAccessService
+	embeddings       *services.EmbeddingService
 }
 
-func NewSearchHandler(db *gorm.DB) *SearchHandler {
+// NewSearchHandler creates a search handler. embeddings serves semantic
+// search and may be nil, in which case it reports that semantic search is
+// not configured.
+func NewSearchHandler(db *gorm.DB, embeddings *services.EmbeddingService) *SearchHandler {
+	if embeddings == nil {
+		embeddings = services.NewEmbeddingService(db, nil)
+	}
 	return &SearchHandler{
-		searchService: services.NewSearchService(db),
+		searchService:    services.NewSearchService(db),
+		analyticsService: services.NewSearchAnalyticsService(db),
+		accessService:    services.NewNoteAccessService(db),
+		embeddings:       embeddings,
 	}
 }
 
-// AdvancedSearch performs advanced full-text search with filters and ranking
+// AdvancedSearch searches notes, people, todos and attachments, with filters
+// for notes, and returns the results ranked together, grouped by type and
+// with facet counts. The types parameter limits the result types.
 func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	userUUID := uuid.MustParse(userID.(string))
@@ -71,9 +87,54 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 		}
 	}
 
-	response, err := h.searchService.FullTextSearch(userUUID, req)
+	start := time.Now()
+	response, err := h.searchService.InWorkspace(activeWorkspace(c)).Search(userUUID, req)
+	if err != nil {
+		writeSearchError(c, err, "Search failed: "+err.Error())
+		return
+	}
+
+	// A search that worked but could not be logged is still answered
+	if err := h.analyticsService.InWorkspace(activeWorkspace(c)).Record(userUUID, req.Query, response.Total, time.Since(start)); err != nil {
+		log.Printf("Failed to record search: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SemanticSearch finds the notes closest in meaning to q. With mode=hybrid
+// the semantic ranking is fused with the keyword ranking.
+func (h *SearchHandler) SemanticSearch(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	userUUID := uuid.MustParse(userID.(string))
+
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'q' is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	embeddings := h.embeddings.InWorkspace(activeWorkspace(c))
+	var response *services.SemanticSearchResponse
+	switch mode := c.DefaultQuery("mode", services.SemanticModeSemantic); mode {
+	case services.SemanticModeSemantic:
+		response, err = embeddings.SemanticSearch(c.Request.Context(), userUUID, query, limit)
+	case services.SemanticModeHybrid:
+		response, err = embeddings.HybridSearch(c.Request.Context(), userUUID, query, limit)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be semantic or hybrid"})
+		return
+	}
+	if errors.Is(err, services.ErrSemanticSearchDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed: " + err.Error()})
+		writeSearchError(c, err, "Semantic search failed: "+err.Error())
 		return
 	}
 
@@ -93,7 +154,7 @@ func (h *SearchHandler) QuickSwitcher(c *gin.Context) {
 		limit = 10
 	}
 
-	results, err := h.searchService.QuickSwitcher(userUUID, query, limit)
+	results, err := h.searchService.InWorkspace(activeWorkspace(c)).QuickSwitcher(userUUID, query, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Quick switcher search failed: " + err.Error()})
 		return
@@ -106,7 +167,7 @@ func (h *SearchHandler) QuickSwitcher(c *gin.Context) {
 	})
 }
 
-// GetRecentNotes retrieves recently accessed notes
+// GetRecentNotes returns the notes the user opened most recently
 func (h *SearchHandler) GetRecentNotes(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	userUUID := uuid.MustParse(userID.(string))
@@ -118,7 +179,7 @@ func (h *SearchHandler) GetRecentNotes(c *gin.Context) {
 		limit = 10
 	}
 
-	results, err := h.searchService.GetRecentNotes(userUUID, limit)
+	results, err := h.searchService.InWorkspace(activeWorkspace(c)).GetRecentNotes(userUUID, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recent notes: " + err.Error()})
 		return
@@ -130,6 +191,34 @@ func (h *SearchHandler) GetRecentNotes(c *gin.Context) {
 	})
 }
 
+// GetFrequentNotes returns the notes the user opened most often over the
+// last days days (30 by default)
+func (h *SearchHandler) GetFrequentNotes(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	userUUID := uuid.MustParse(userID.(string))
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 || days > 365 {
+		days = 30
+	}
+
+	results, err := h.accessService.InWorkspace(activeWorkspace(c)).FrequentlyUsed(userUUID, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get frequent notes: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"frequent_notes": results,
+		"limit":          limit,
+		"days":           days,
+	})
+}
+
 // SearchSuggestions provides search suggestions and autocomplete
 func (h *SearchHandler) SearchSuggestions(c *gin.Context) {
 	userID, _ := c.Get("userID")
@@ -148,12 +237,19 @@ func (h *SearchHandler) SearchSuggestions(c *gin.Context) {
 	}
 
 	// Get quick switcher results as suggestions
-	suggestions, err := h.searchService.QuickSwitcher(userUUID, query, limit)
+	suggestions, err := h.searchService.InWorkspace(activeWorkspace(c)).QuickSwitcher(userUUID, query, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get suggestions: " + err.Error()})
 		return
 	}
 
+	// Earlier searches the user can repeat
+	recentSearches, err := h.analyticsService.InWorkspace(activeWorkspace(c)).RecentQueries(userUUID, query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recent searches: " + err.Error()})
+		return
+	}
+
 	// Convert to simpler suggestion format
 	simpleSuggestions := make([]gin.H, len(suggestions))
 	for i, suggestion := range suggestions {
@@ -166,29 +262,52 @@ func (h *SearchHandler) SearchSuggestions(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"suggestions": simpleSuggestions,
-		"query":       query,
-		"limit":       limit,
+		"suggestions":     simpleSuggestions,
+		"recent_searches": recentSearches,
+		"query":           query,
+		"limit":           limit,
 	})
 }
 
-// GetSearchStats provides search statistics and analytics
+// GetSearchStats reports on the user's searches over the last days days
+// (30 by default): how many, the most recent, popular and zero-result
+// queries, and search latency
 func (h *SearchHandler) GetSearchStats(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	userUUID := uuid.MustParse(userID.(string))
 
-	// This would typically track search analytics
-	// For now, return basic stats
-	stats := gin.H{
-		"user_id":           userUUID,
-		"total_searches":    0, // Would be tracked in a real implementation
-		"popular_queries":   []string{},
-		"recent_searches":   []string{},
-		"search_performance": gin.H{
-			"avg_response_time": "0ms",
-			"total_indexed":     0,
-		},
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 || days > 365 {
+		days = 30
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	stats, err := h.analyticsService.InWorkspace(activeWorkspace(c)).Stats(userUUID, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get search stats: " + err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, stats)
-}
\ No newline at end of file
+}
+
+// writeSearchError maps search errors to responses. Malformed queries report
+// where the problem is.
+func writeSearchError(c *gin.Context, err error, message string) {
+	var queryErr *services.QueryError
+	switch {
+	case errors.As(err, &queryErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": queryErr.Error(), "position": queryErr.Pos})
+	case errors.Is(err, services.ErrUnknownSearchType), errors.Is(err, services.ErrInvalidSavedSearch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSavedSearchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAIQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"notesage-server/internal/database"
 	"notesage-server/internal/models"
@@ -68,7 +72,9 @@ func setupSearchRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User) {
 		require.NoError(t, db.Create(&note).Error)
 	}
 
-	searchHandler := NewSearchHandler(db)
+	searchHandler := NewSearchHandler(db, nil)
+	savedSearchHandler := NewSavedSearchHandler(db, nil)
+	noteHandler := NewNoteHandler(db, nil)
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
@@ -83,9 +89,19 @@ func setupSearchRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User) {
 		api.GET("", searchHandler.AdvancedSearch)
 		api.GET("/quick", searchHandler.QuickSwitcher)
 		api.GET("/recent", searchHandler.GetRecentNotes)
+		api.GET("/frequent", searchHandler.GetFrequentNotes)
 		api.GET("/suggestions", searchHandler.SearchSuggestions)
 		api.GET("/stats", searchHandler.GetSearchStats)
+		api.GET("/semantic", searchHandler.SemanticSearch)
+		api.GET("/saved", savedSearchHandler.GetSavedSearches)
+		api.POST("/saved", savedSearchHandler.CreateSavedSearch)
+		api.GET("/saved/:id", savedSearchHandler.GetSavedSearch)
+		api.PUT("/saved/:id", savedSearchHandler.UpdateSavedSearch)
+		api.DELETE("/saved/:id", savedSearchHandler.DeleteSavedSearch)
+		api.GET("/saved/:id/results", savedSearchHandler.RunSavedSearch)
 	}
+	router.GET("/api/notes", noteHandler.GetNotes)
+	router.GET("/api/notes/:id", noteHandler.GetNote)
 
 	return router, db, user
 }
@@ -106,6 +122,121 @@ func TestAdvancedSearch(t *testing.T) {
 	assert.Equal(t, int64(1), response.Total)
 }
 
+func TestAdvancedSearchMixedResults(t *testing.T) {
+	t.Parallel()
+	router, db, user := setupSearchRouter(t)
+
+	var note models.Note
+	require.NoError(t, db.Where("title = ?", "Meeting Notes - Project Alpha").First(&note).Error)
+	require.NoError(t, db.Create(&models.Person{UserID: user.ID, Name: "Alpha Centauri", Company: "Acme"}).Error)
+	require.NoError(t, db.Create(&models.Todo{NoteID: note.ID, TodoID: "t1", Text: "Send the alpha timeline"}).Error)
+	require.NoError(t, db.Create(&models.Attachment{UserID: user.ID, Filename: "alpha-plan.pdf", ContentType: "application/pdf", SHA256: "x"}).Error)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/search?q=alpha", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response services.UnifiedSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(4), response.Total)
+	assert.Equal(t, map[string]int64{"note": 1, "person": 1, "todo": 1, "attachment": 1}, response.Facets.Type)
+	assert.Equal(t, map[string]int64{"Meeting": 1}, response.Facets.Category)
+	assert.Equal(t, map[string]int64{"meeting": 1, "project-alpha": 1}, response.Facets.Tag)
+
+	// Titles starting with the word rank above titles containing it
+	require.Len(t, response.Results, 4)
+	assert.ElementsMatch(t, []string{"person", "attachment"}, []string{response.Results[0].Type, response.Results[1].Type})
+	assert.Greater(t, response.Results[1].Score, response.Results[2].Score)
+	for _, result := range response.Results {
+		if result.Type == "person" {
+			require.NotNil(t, result.Person)
+			assert.Equal(t, "Alpha Centauri", result.Person.Name)
+		}
+	}
+
+	require.Len(t, response.Groups, 4)
+	for _, group := range response.Groups {
+		assert.Equal(t, int64(1), group.Total, group.Type)
+		require.Len(t, group.Results, 1, group.Type)
+		assert.Equal(t, group.Type, group.Results[0].Type)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search?q=alpha&types=todo,person", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	response = services.UnifiedSearchResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]int64{"person": 1, "todo": 1}, response.Facets.Type)
+
+	// Filters only apply to notes
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search?q="+url.QueryEscape("alpha category:meeting"), nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	response = services.UnifiedSearchResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]int64{"note": 1}, response.Facets.Type)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search?q=alpha&types=event", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdvancedSearchMatchesWildcardsLiterally(t *testing.T) {
+	t.Parallel()
+	router, db, user := setupSearchRouter(t)
+
+	for _, name := range []string{"Save 50% now", "Club 500", "snake_case", "snakeXcase"} {
+		require.NoError(t, db.Create(&models.Person{UserID: user.ID, Name: name}).Error)
+	}
+
+	search := func(q string) []string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/search?types=person&q="+url.QueryEscape(q), nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response services.UnifiedSearchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		var names []string
+		for _, result := range response.Results {
+			names = append(names, result.Person.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"Save 50% now"}, search("50%"))
+	assert.Equal(t, []string{"snake_case"}, search("snake_case"))
+}
+
+func TestAdvancedSearchQuerySyntax(t *testing.T) {
+	t.Parallel()
+	router, _, _ := setupSearchRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/search?q="+url.QueryEscape("tag:javascript OR category:meeting -is:favorite"), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response services.SearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, int64(1), response.Total)
+	assert.Equal(t, "Meeting Notes - Project Alpha", response.Results[0].Note.Title)
+
+	// Malformed queries report where the problem is
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search?q="+url.QueryEscape("alpha created:>soon"), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var errResponse map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResponse))
+	assert.Equal(t, float64(15), errResponse["position"])
+	assert.Contains(t, errResponse["error"], "not a date")
+}
+
 func TestQuickSwitcher(t *testing.T) {
 	t.Parallel()
 	router, _, _ := setupSearchRouter(t)
@@ -126,11 +257,25 @@ func TestQuickSwitcher(t *testing.T) {
 
 func TestGetRecentNotes(t *testing.T) {
 	t.Parallel()
-	router, _, _ := setupSearchRouter(t)
+	router, db, user := setupSearchRouter(t)
 
+	// Nothing has been opened yet
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/search/recent", nil)
 	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"recent_notes":[]`)
+
+	var notes []models.Note
+	require.NoError(t, db.Where("user_id = ?", user.ID).Find(&notes).Error)
+	access := services.NewNoteAccessService(db)
+	for _, note := range notes {
+		require.NoError(t, access.Record(user.ID, note.ID, models.NoteAccessOpen))
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/recent", nil)
+	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -142,6 +287,61 @@ func TestGetRecentNotes(t *testing.T) {
 	assert.Len(t, recentNotes, 3)
 }
 
+func TestNoteAccessRanking(t *testing.T) {
+	t.Parallel()
+	router, db, user := setupSearchRouter(t)
+
+	var alpha, javascript models.Note
+	require.NoError(t, db.Where("title = ?", "Meeting Notes - Project Alpha").First(&alpha).Error)
+	require.NoError(t, db.Where("title = ?", "JavaScript Best Practices").First(&javascript).Error)
+
+	get := func(path string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// Opening a note records it, but opening it again straight away does not
+	get("/api/notes/" + alpha.ID.String())
+	get("/api/notes/" + alpha.ID.String())
+	var count int64
+	require.NoError(t, db.Model(&models.NoteAccess{}).Where("note_id = ?", alpha.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Earlier opens of the JavaScript note make it the most used
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, db.Create(&models.NoteAccess{
+			UserID: user.ID, NoteID: javascript.ID, Source: models.NoteAccessCollaborate,
+			AccessedAt: time.Now().Add(-time.Duration(i) * time.Hour),
+		}).Error)
+	}
+
+	recent := get("/api/search/recent")["recent_notes"].([]interface{})
+	require.Len(t, recent, 2)
+	assert.Equal(t, alpha.ID.String(), recent[0].(map[string]interface{})["id"])
+
+	frequent := get("/api/search/frequent")["frequent_notes"].([]interface{})
+	require.Len(t, frequent, 2)
+	assert.Equal(t, javascript.ID.String(), frequent[0].(map[string]interface{})["id"])
+	assert.Equal(t, float64(3), frequent[0].(map[string]interface{})["access_count"])
+
+	// Without a query the quick switcher ranks by use, then pinned notes
+	results := get("/api/search/quick")["results"].([]interface{})
+	require.Len(t, results, 3)
+	assert.Equal(t, javascript.ID.String(), results[0].(map[string]interface{})["id"])
+	assert.Equal(t, alpha.ID.String(), results[1].(map[string]interface{})["id"])
+	assert.Equal(t, "Go Programming Tutorial", results[2].(map[string]interface{})["title"])
+
+	// Use never outranks a better title match
+	results = get("/api/search/quick?q=go")["results"].([]interface{})
+	require.NotEmpty(t, results)
+	assert.Equal(t, "Go Programming Tutorial", results[0].(map[string]interface{})["title"])
+}
+
 func TestSearchSuggestions(t *testing.T) {
 	t.Parallel()
 	router, _, _ := setupSearchRouter(t)
@@ -174,4 +374,102 @@ func TestGetSearchStats(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	require.NoError(t, err)
 	assert.Equal(t, user.ID.String(), response["user_id"])
+	assert.Equal(t, float64(0), response["total_searches"])
+
+	// Searches are logged as they are made
+	for _, q := range []string{"Go", "go", "nothing-matches"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/search?q="+q, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/stats", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats services.SearchStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, int64(3), stats.TotalSearches)
+	assert.Equal(t, services.QueryCount{Query: "go", Count: 2}, stats.PopularQueries[0])
+	assert.Equal(t, []services.QueryCount{{Query: "nothing-matches", Count: 1}}, stats.ZeroResultQueries)
+	assert.ElementsMatch(t, []string{"go", "nothing-matches"}, stats.RecentSearches)
+	assert.Equal(t, int64(3), stats.SearchPerformance.TotalIndexed)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/suggestions?q=G", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	response = nil
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []interface{}{"go"}, response["recent_searches"])
+}
+
+// keywordEmbedder embeds text by which of a few keywords it mentions
+type keywordEmbedder struct{}
+
+var embedderKeywords = []string{"go", "javascript", "meeting"}
+
+func (keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(embedderKeywords)+1)
+		vectors[i][len(embedderKeywords)] = 0.1
+		for j, keyword := range embedderKeywords {
+			if strings.Contains(strings.ToLower(text), keyword) {
+				vectors[i][j] = 1
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func (keywordEmbedder) EmbeddingModel() string {
+	return "keywords"
+}
+
+func TestSemanticSearch(t *testing.T) {
+	t.Parallel()
+	router, db, user := setupSearchRouter(t)
+
+	// Without an embedding provider semantic search is unavailable
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/search/semantic?q=javascript", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	embeddings := services.NewEmbeddingService(db, keywordEmbedder{})
+	_, err := embeddings.IndexPending(context.Background(), 10)
+	require.NoError(t, err)
+	handler := NewSearchHandler(db, embeddings)
+	router = gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", user.ID.String())
+		c.Next()
+	})
+	router.GET("/api/search/semantic", handler.SemanticSearch)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/semantic", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/semantic?q=javascript&mode=fuzzy", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	for _, mode := range []string{services.SemanticModeSemantic, services.SemanticModeHybrid} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/search/semantic?q=javascript+tips&mode="+mode, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response services.SemanticSearchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, mode, response.Mode)
+		require.NotEmpty(t, response.Results)
+		assert.Equal(t, "JavaScript Best Practices", response.Results[0].Note.Title)
+	}
 }
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ShareHandler manages who else can access a note
+type ShareHandler struct {
+	db           *gorm.DB
+	shareService *services.ShareService
+}
+
+// NewShareHandler creates a new share handler
+func NewShareHandler(db *gorm.DB) *ShareHandler {
+	return &ShareHandler{
+		db:           db,
+		shareService: services.NewShareService(db),
+	}
+}
+
+// ShareNoteRequest grants a user access to a note. The user is identified by
+// exactly one of user_id, email or username.
+type ShareNoteRequest struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Role     string `json:"role" binding:"required,oneof=viewer commenter editor"`
+}
+
+// GetShares lists the users a note is shared with
+func (h *ShareHandler) GetShares(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	shares, err := h.shareService.ListShares(uuid.MustParse(userID.(string)), noteID)
+	if err != nil {
+		writeNoteAccessError(c, err, "Failed to fetch shares")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares, "total": len(shares)})
+}
+
+// ShareNote grants or updates another user's role on a note
+func (h *ShareHandler) ShareNote(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	var req ShareNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := h.db.Model(&models.User{})
+	switch {
+	case req.UserID != "":
+		query = query.Where("id = ?", req.UserID)
+	case req.Email != "":
+		query = query.Where("email = ?", req.Email)
+	case req.Username != "":
+		query = query.Where("username = ?", req.Username)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of user_id, email or username is required"})
+		return
+	}
+	var grantee models.User
+	if err := query.First(&grantee).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		}
+		return
+	}
+
+	share, err := h.shareService.ShareNote(uuid.MustParse(userID.(string)), noteID, grantee.ID, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrShareWithOwner):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrShareUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			writeNoteAccessError(c, err, "Failed to share note")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, share)
+}
+
+// RevokeShare removes a user's access to a note. Collaborators may use it to
+// leave a note that was shared with them.
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	granteeID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.shareService.RevokeShare(uuid.MustParse(userID.(string)), noteID, granteeID); err != nil {
+		if errors.Is(err, services.ErrShareNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			writeNoteAccessError(c, err, "Failed to revoke share")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked"})
+}
+
+// GetSharedWithMe lists the notes other users have shared with the current user
+func (h *ShareHandler) GetSharedWithMe(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	notes, err := h.shareService.SharedWithUser(uuid.MustParse(userID.(string)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shared notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notes": notes, "total": len(notes)})
+}
+
+// authorizeNote loads the note named by the :id path parameter and checks the
+// current user holds at least the required role, writing an error response if not
+func authorizeNote(c *gin.Context, shares *services.ShareService, required string) (*models.Note, string, bool) {
+	userID, _ := c.Get("userID")
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return nil, "", false
+	}
+
+	note, role, err := shares.AuthorizeNote(uuid.MustParse(userID.(string)), noteID, required)
+	if err != nil {
+		writeNoteAccessError(c, err, "Failed to fetch note")
+		return nil, "", false
+	}
+	return note, role, true
+}
+
+// writeNoteAccessError maps note access errors to responses: 404 for notes the
+// user cannot see, 403 for notes they cannot act on
+func writeNoteAccessError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	case errors.Is(err, services.ErrNoteAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"notesage-server/internal/config"
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupSharesRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string) {
+	t.Helper()
+
+	router, db, user, token := setupNotesRouter(t)
+	shareHandler := NewShareHandler(db)
+
+	notes := router.Group("/api/notes")
+	notes.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		notes.GET("/shared", shareHandler.GetSharedWithMe)
+		notes.GET("/:id/shares", shareHandler.GetShares)
+		notes.POST("/:id/shares", shareHandler.ShareNote)
+		notes.DELETE("/:id/shares/:user_id", shareHandler.RevokeShare)
+	}
+
+	return router, db, user, token
+}
+
+func createSharesTestUser(t *testing.T, db *gorm.DB, username string) (*models.User, string) {
+	t.Helper()
+
+	user := &models.User{
+		ID:       uuid.New(),
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "hashedpassword",
+		Role:     models.RoleUser,
+		IsActive: true,
+	}
+	require.NoError(t, db.Create(user).Error)
+
+	authHandler := NewAuthHandler(db, &config.Config{Auth: config.AuthConfig{JWTSecret: "test-secret", SessionTimeout: 24 * time.Hour}})
+	token, _, err := authHandler.generateToken(*user)
+	require.NoError(t, err)
+	return user, token
+}
+
+func TestShareNoteAndEnforceRoles(t *testing.T) {
+	t.Parallel()
+	router, db, _, ownerToken := setupSharesRouter(t)
+	collaborator, collaboratorToken := createSharesTestUser(t, db, "collaborator")
+
+	w := makeRequest(t, router, "POST", "/api/notes", ownerToken, CreateNoteRequest{Title: "Plans"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+	noteURL := fmt.Sprintf("/api/notes/%s", note.ID)
+
+	// Without a share the note is invisible
+	w = makeRequest(t, router, "GET", noteURL, collaboratorToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "POST", noteURL+"/shares", ownerToken, ShareNoteRequest{Username: "nobody", Role: "viewer"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = makeRequest(t, router, "POST", noteURL+"/shares", ownerToken, ShareNoteRequest{Username: "collaborator", Role: "owner"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "POST", noteURL+"/shares", ownerToken, ShareNoteRequest{Email: "collaborator@example.com", Role: "viewer"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Viewers can read but not write
+	w = makeRequest(t, router, "GET", noteURL, collaboratorToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, models.NoteRoleViewer, w.Header().Get("X-Note-Role"))
+
+	title := "Edited"
+	w = makeRequest(t, router, "PUT", noteURL, collaboratorToken, UpdateNoteRequest{Title: &title})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/notes/shared", collaboratorToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var shared struct {
+		Total int `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	assert.Equal(t, 1, shared.Total)
+
+	// Editors can change the content but not the owner's organisation of it
+	w = makeRequest(t, router, "POST", noteURL+"/shares", ownerToken, ShareNoteRequest{UserID: collaborator.ID.String(), Role: "editor"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "PUT", noteURL, collaboratorToken, UpdateNoteRequest{Title: &title})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	pinned := true
+	w = makeRequest(t, router, "PUT", noteURL, collaboratorToken, UpdateNoteRequest{IsPinned: &pinned})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = makeRequest(t, router, "DELETE", noteURL, collaboratorToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "POST", noteURL+"/shares", collaboratorToken, ShareNoteRequest{Username: "testuser", Role: "viewer"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = makeRequest(t, router, "GET", noteURL+"/shares", collaboratorToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Revoking access hides the note again
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("%s/shares/%s", noteURL, collaborator.ID), ownerToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("%s/shares/%s", noteURL, collaborator.ID), ownerToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "GET", noteURL, collaboratorToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SyncHandler serves the offline sync protocol
+type SyncHandler struct {
+	syncService *services.SyncService
+}
+
+// NewSyncHandler creates a new sync handler
+func NewSyncHandler(db *gorm.DB) *SyncHandler {
+	return &SyncHandler{syncService: services.NewSyncService(db)}
+}
+
+// SyncPushRequest represents a batch of offline mutations
+type SyncPushRequest struct {
+	Mutations []services.SyncMutation `json:"mutations" binding:"required"`
+}
+
+// GetChanges returns everything that changed since the given cursor. Clients keep
+// calling with the returned cursor until has_more is false.
+func (h *SyncHandler) GetChanges(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	changes, err := h.syncService.InWorkspace(activeWorkspace(c)).GetChanges(uuid.MustParse(userID.(string)), c.Query("since"), limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch changes"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// Push applies offline mutations and reports an accepted, conflict or rejected
+// result for each one, in the order they were sent
+func (h *SyncHandler) Push(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req SyncPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Mutations) > services.MaxSyncPushSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A push may contain at most %d mutations", services.MaxSyncPushSize)})
+		return
+	}
+
+	// Sanitize note content to prevent XSS, as the notes API does
+	for i := range req.Mutations {
+		if req.Mutations[i].EntityType == models.SyncEntityNote {
+			req.Mutations[i].Data = sanitizeMutationContent(req.Mutations[i].Data)
+		}
+	}
+
+	results := h.syncService.InWorkspace(activeWorkspace(c)).Push(uuid.MustParse(userID.(string)), req.Mutations)
+
+	summary := map[string]int{
+		services.SyncStatusAccepted: 0,
+		services.SyncStatusConflict: 0,
+		services.SyncStatusRejected: 0,
+	}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "summary": summary})
+}
+
+// sanitizeMutationContent sanitizes the content field of note mutation data,
+// leaving data it cannot parse for the service to reject
+func sanitizeMutationContent(data json.RawMessage) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data
+	}
+	raw, ok := fields["content"]
+	if !ok {
+		return data
+	}
+
+	var content models.JSONB
+	if err := json.Unmarshal(raw, &content); err != nil || content == nil {
+		return data
+	}
+	sanitized, err := json.Marshal(services.SanitizeContent(content))
+	if err != nil {
+		return data
+	}
+	fields["content"] = sanitized
+
+	result, err := json.Marshal(fields)
+	if err != nil {
+		return data
+	}
+	return result
+}
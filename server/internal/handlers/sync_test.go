@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupSyncRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string) {
+	t.Helper()
+
+	router, db, user, token := setupNotesRouter(t)
+	syncHandler := NewSyncHandler(db)
+
+	sync := router.Group("/api/sync")
+	sync.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		sync.GET("/changes", syncHandler.GetChanges)
+		sync.POST("/push", syncHandler.Push)
+	}
+
+	return router, db, user, token
+}
+
+func TestSyncChangesAndPush(t *testing.T) {
+	t.Parallel()
+	router, _, _, token := setupSyncRouter(t)
+
+	// Changes made through the regular API show up in the feed
+	w := makeRequest(t, router, "POST", "/api/notes", token, CreateNoteRequest{Title: "Online note"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	w = makeRequest(t, router, "GET", "/api/sync/changes", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var changes struct {
+		Changes []struct {
+			EntityType string    `json:"entity_type"`
+			EntityID   uuid.UUID `json:"entity_id"`
+			Action     string    `json:"action"`
+		} `json:"changes"`
+		Cursor  string `json:"cursor"`
+		HasMore bool   `json:"has_more"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.Len(t, changes.Changes, 1)
+	assert.Equal(t, note.ID, changes.Changes[0].EntityID)
+	assert.Equal(t, models.SyncActionCreate, changes.Changes[0].Action)
+	cursor := changes.Cursor
+
+	// Push one accepted and one stale edit; scripts are stripped from note content
+	content := models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "<script>alert(1)</script>offline"},
+		}},
+	}}
+	data, err := json.Marshal(map[string]interface{}{"content": content})
+	require.NoError(t, err)
+	version := note.Version
+
+	w = makeRequest(t, router, "POST", "/api/sync/push", token, SyncPushRequest{Mutations: []services.SyncMutation{
+		{ClientID: "a", EntityType: models.SyncEntityNote, Action: models.SyncActionUpdate, EntityID: note.ID, BaseVersion: &version, Data: data},
+		{ClientID: "b", EntityType: models.SyncEntityNote, Action: models.SyncActionUpdate, EntityID: note.ID, BaseVersion: &version, Data: data},
+	}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var push struct {
+		Results []struct {
+			ClientID   string     `json:"client_id"`
+			Status     string     `json:"status"`
+			ConflictID *uuid.UUID `json:"conflict_id"`
+		} `json:"results"`
+		Summary map[string]int `json:"summary"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &push))
+	require.Len(t, push.Results, 2)
+	assert.Equal(t, "a", push.Results[0].ClientID)
+	assert.Equal(t, services.SyncStatusAccepted, push.Results[0].Status)
+	assert.Equal(t, services.SyncStatusConflict, push.Results[1].Status)
+	assert.NotNil(t, push.Results[1].ConflictID)
+	assert.Equal(t, 1, push.Summary[services.SyncStatusAccepted])
+	assert.Equal(t, 1, push.Summary[services.SyncStatusConflict])
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+	assert.NotContains(t, fmt.Sprint(note.Content), "<script>")
+
+	// Deleting through the API is reported after the previous cursor
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/sync/changes?since="+cursor, token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.Len(t, changes.Changes, 1)
+	assert.Equal(t, models.SyncActionDelete, changes.Changes[0].Action)
+
+	w = makeRequest(t, router, "GET", "/api/sync/changes?since=bogus", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = makeRequest(t, router, "POST", "/api/sync/push", token, map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -88,7 +88,7 @@ func (h *TodoHandler) GetTodos(c *gin.Context) {
 	
 	filters.Search = c.Query("search")
 	
-	todos, err := h.todoService.GetTodosWithFilters(userID.(uuid.UUID), filters)
+	todos, err := h.todoService.InWorkspace(activeWorkspace(c)).GetTodosWithFilters(userID.(uuid.UUID), filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch todos"})
 		return
@@ -98,8 +98,6 @@ func (h *TodoHandler) GetTodos(c *gin.Context) {
 }
 
 func (h *TodoHandler) CreateTodo(c *gin.Context) {
-	userID, _ := c.Get("userID")
-	
 	var req CreateTodoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -108,7 +106,7 @@ func (h *TodoHandler) CreateTodo(c *gin.Context) {
 	
 	// Verify note belongs to user
 	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", req.NoteID, userID).First(&note).Error; err != nil {
+	if err := h.db.Scopes(notesInScope(c)).Where("id = ?", req.NoteID).First(&note).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		return
 	}
@@ -143,7 +141,12 @@ func (h *TodoHandler) CreateTodo(c *gin.Context) {
 		}
 	}
 	
-	if err := h.db.Create(&todo).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&todo).Error; err != nil {
+			return err
+		}
+		return services.RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todo.ID, models.SyncActionCreate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create todo"})
 		return
 	}
@@ -155,12 +158,12 @@ func (h *TodoHandler) CreateTodo(c *gin.Context) {
 }
 
 func (h *TodoHandler) GetTodo(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	todoID := c.Param("id")
 	
 	var todo models.Todo
 	if err := h.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("todos.id = ? AND notes.user_id = ?", todoID, userID).
+		Scopes(notesInScope(c)).
+		Where("todos.id = ?", todoID).
 		Preload("Note").
 		Preload("AssignedPerson").
 		First(&todo).Error; err != nil {
@@ -176,7 +179,6 @@ func (h *TodoHandler) GetTodo(c *gin.Context) {
 }
 
 func (h *TodoHandler) UpdateTodo(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	todoID := c.Param("id")
 	
 	var req UpdateTodoRequest
@@ -187,7 +189,8 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 	
 	var todo models.Todo
 	if err := h.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("todos.id = ? AND notes.user_id = ?", todoID, userID).
+		Scopes(notesInScope(c)).
+		Where("todos.id = ?", todoID).
 		First(&todo).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
@@ -222,7 +225,12 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 		}
 	}
 	
-	if err := h.db.Save(&todo).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&todo).Error; err != nil {
+			return err
+		}
+		return recordTodoChange(tx, &todo, models.SyncActionUpdate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update todo"})
 		return
 	}
@@ -234,24 +242,32 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 }
 
 func (h *TodoHandler) DeleteTodo(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	todoID := c.Param("id")
 	
-	result := h.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("todos.id = ? AND notes.user_id = ?", todoID, userID).
-		Delete(&models.Todo{})
-	
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete todo"})
+	var todo models.Todo
+	if err := h.db.Joins("JOIN notes ON todos.note_id = notes.id").
+		Scopes(notesInScope(c)).
+		Where("todos.id = ?", todoID).
+		First(&todo).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete todo"})
+		}
 		return
 	}
 	
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&todo).Error; err != nil {
+			return err
+		}
+		return recordTodoChange(tx, &todo, models.SyncActionDelete)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete todo"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"message": "Todo deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Todo moved to trash"})
 }
 
 // SyncNoteTodos scans a note for todos and synchronizes them with the database
@@ -270,7 +286,7 @@ func (h *TodoHandler) SyncNoteTodos(c *gin.Context) {
 		return
 	}
 	
-	if err := h.todoService.SyncNoteTodos(noteID, userID.(uuid.UUID)); err != nil {
+	if err := h.todoService.InWorkspace(activeWorkspace(c)).SyncNoteTodos(noteID, userID.(uuid.UUID)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync todos"})
 		return
 	}
@@ -302,7 +318,7 @@ func (h *TodoHandler) GetCalendarTodos(c *gin.Context) {
 		return
 	}
 	
-	todos, err := h.todoService.GetCalendarTodos(userID.(uuid.UUID), startDate, endDate)
+	todos, err := h.todoService.InWorkspace(activeWorkspace(c)).GetCalendarTodos(userID.(uuid.UUID), startDate, endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calendar todos"})
 		return
@@ -324,7 +340,7 @@ func (h *TodoHandler) GetTodosByNote(c *gin.Context) {
 	
 	// Verify note belongs to user
 	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteUUID, userID).First(&note).Error; err != nil {
+	if err := h.db.Scopes(notesInScope(c)).Where("id = ?", noteUUID).First(&note).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 		return
 	}
@@ -333,7 +349,7 @@ func (h *TodoHandler) GetTodosByNote(c *gin.Context) {
 		NoteID: &noteUUID,
 	}
 	
-	todos, err := h.todoService.GetTodosWithFilters(userID.(uuid.UUID), filters)
+	todos, err := h.todoService.InWorkspace(activeWorkspace(c)).GetTodosWithFilters(userID.(uuid.UUID), filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch todos"})
 		return
@@ -344,7 +360,6 @@ func (h *TodoHandler) GetTodosByNote(c *gin.Context) {
 
 // UpdateTodoByCompositeKey updates a todo using note_id and todo_id composite key
 func (h *TodoHandler) UpdateTodoByCompositeKey(c *gin.Context) {
-	userID, _ := c.Get("userID")
 	noteID := c.Param("note_id")
 	todoID := c.Param("todo_id")
 	
@@ -362,7 +377,8 @@ func (h *TodoHandler) UpdateTodoByCompositeKey(c *gin.Context) {
 	
 	var todo models.Todo
 	if err := h.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("todos.note_id = ? AND todos.todo_id = ? AND notes.user_id = ?", noteUUID, todoID, userID).
+		Scopes(notesInScope(c)).
+		Where("todos.note_id = ? AND todos.todo_id = ?", noteUUID, todoID).
 		First(&todo).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
@@ -397,7 +413,12 @@ func (h *TodoHandler) UpdateTodoByCompositeKey(c *gin.Context) {
 		}
 	}
 	
-	if err := h.db.Save(&todo).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&todo).Error; err != nil {
+			return err
+		}
+		return recordTodoChange(tx, &todo, models.SyncActionUpdate)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update todo"})
 		return
 	}
@@ -406,4 +427,27 @@ func (h *TodoHandler) UpdateTodoByCompositeKey(c *gin.Context) {
 	h.db.Preload("Note").Preload("AssignedPerson").First(&todo, todo.ID)
 	
 	c.JSON(http.StatusOK, todo)
-}
\ No newline at end of file
+}
+
+// recordTodoChange adds a todo change to the change feed of everyone who can
+// see its note
+func recordTodoChange(tx *gorm.DB, todo *models.Todo, action string) error {
+	var note models.Note
+	if err := tx.Select("id", "user_id", "workspace_id").Where("id = ?", todo.NoteID).First(&note).Error; err != nil {
+		return err
+	}
+	return services.RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todo.ID, action)
+}
+
+// notesInScope scopes a query on notes, or joined to notes, to the active
+// workspace or the user's personal notes
+func notesInScope(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	var userUUID uuid.UUID
+	switch userID, _ := c.Get("userID"); id := userID.(type) {
+	case uuid.UUID:
+		userUUID = id
+	case string:
+		userUUID, _ = uuid.Parse(id)
+	}
+	return services.OwnedBy("notes", userUUID, activeWorkspace(c))
+}
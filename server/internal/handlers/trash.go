@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TrashHandler exposes deleted notes, people and todos for restore and purge
+type TrashHandler struct {
+	trashService *services.TrashService
+}
+
+// NewTrashHandler creates a new trash handler
+func NewTrashHandler(trashService *services.TrashService) *TrashHandler {
+	return &TrashHandler{trashService: trashService}
+}
+
+// GetTrash lists the user's deleted items, optionally filtered by ?type=
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	items, err := h.trashService.InWorkspace(activeWorkspace(c)).ListTrash(uuid.MustParse(userID.(string)), c.Query("type"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTrashType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":          items,
+		"total":          len(items),
+		"retention_days": int(h.trashService.Retention().Hours() / 24),
+	})
+}
+
+// RestoreItem takes a note, person or todo out of the trash
+func (h *TrashHandler) RestoreItem(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	itemID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		return
+	}
+
+	item, err := h.trashService.InWorkspace(activeWorkspace(c)).Restore(uuid.MustParse(userID.(string)), c.Param("type"), itemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTrashType):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		case errors.Is(err, services.ErrParentInTrash):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// PurgeItem permanently deletes an item that is in the trash
+func (h *TrashHandler) PurgeItem(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	itemID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		return
+	}
+
+	if err := h.trashService.InWorkspace(activeWorkspace(c)).Purge(uuid.MustParse(userID.(string)), c.Param("type"), itemID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTrashType):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item permanently deleted"})
+}
+
+// EmptyTrash permanently deletes everything in the user's trash
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	purged, err := h.trashService.InWorkspace(activeWorkspace(c)).EmptyTrash(uuid.MustParse(userID.(string)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trash emptied", "purged": purged})
+}
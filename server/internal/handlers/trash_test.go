@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupTrashRouter(t *testing.T) (*gin.Engine, *gorm.DB, *models.User, string) {
+	t.Helper()
+
+	router, db, user, token := setupNotesRouter(t)
+	trashHandler := NewTrashHandler(services.NewTrashService(db, 30*24*time.Hour))
+
+	trash := router.Group("/api/trash")
+	trash.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		trash.GET("", trashHandler.GetTrash)
+		trash.DELETE("", trashHandler.EmptyTrash)
+		trash.POST("/:type/:id/restore", trashHandler.RestoreItem)
+		trash.DELETE("/:type/:id", trashHandler.PurgeItem)
+	}
+
+	return router, db, user, token
+}
+
+func TestTrashDeleteRestoreAndPurge(t *testing.T) {
+	t.Parallel()
+	router, db, _, token := setupTrashRouter(t)
+
+	w := makeRequest(t, router, "POST", "/api/notes", token, CreateNoteRequest{Title: "Oops"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+
+	// Deleting moves the note to the trash
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "GET", "/api/trash", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var trash struct {
+		Items         []services.TrashItem `json:"items"`
+		Total         int                  `json:"total"`
+		RetentionDays int                  `json:"retention_days"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	require.Equal(t, 1, trash.Total)
+	assert.Equal(t, note.ID, trash.Items[0].ID)
+	assert.Equal(t, "Oops", trash.Items[0].Title)
+	assert.Equal(t, 30, trash.RetentionDays)
+
+	w = makeRequest(t, router, "GET", "/api/trash?type=folder", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Restore
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/trash/note/%s/restore", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/trash/note/%s/restore", note.ID), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Purge
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("/api/notes/%s", note.ID), token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("/api/trash/note/%s", note.ID), token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.Note{}).Where("id = ?", note.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	w = makeRequest(t, router, "DELETE", fmt.Sprintf("/api/trash/note/%s", note.ID), token, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = makeRequest(t, router, "DELETE", "/api/trash", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WorkspaceHandler manages workspaces and their members
+type WorkspaceHandler struct {
+	db               *gorm.DB
+	workspaceService *services.WorkspaceService
+}
+
+// NewWorkspaceHandler creates a new workspace handler
+func NewWorkspaceHandler(db *gorm.DB) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		db:               db,
+		workspaceService: services.NewWorkspaceService(db),
+	}
+}
+
+type CreateWorkspaceRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type UpdateWorkspaceRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// InviteMemberRequest adds a user to a workspace. The user is identified by
+// exactly one of user_id, email or username.
+type InviteMemberRequest struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Role     string `json:"role" binding:"required,oneof=viewer member admin owner"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=viewer member admin owner"`
+}
+
+// GetWorkspaces lists the workspaces the current user belongs to
+func (h *WorkspaceHandler) GetWorkspaces(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaces, err := h.workspaceService.ListWorkspaces(uuid.MustParse(userID.(string)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspaces"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workspaces": workspaces, "total": len(workspaces)})
+}
+
+// CreateWorkspace creates a workspace owned by the current user
+func (h *WorkspaceHandler) CreateWorkspace(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req CreateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace := models.Workspace{Name: req.Name, Description: req.Description}
+	if err := workspace.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.workspaceService.CreateWorkspace(uuid.MustParse(userID.(string)), req.Name, req.Description)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetWorkspace returns a workspace along with the current user's role in it
+func (h *WorkspaceHandler) GetWorkspace(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+
+	workspace, err := h.workspaceService.GetWorkspace(uuid.MustParse(userID.(string)), workspaceID)
+	if err != nil {
+		writeWorkspaceError(c, err, "Failed to fetch workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// UpdateWorkspace renames a workspace or changes its description
+func (h *WorkspaceHandler) UpdateWorkspace(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		if err := (&models.Workspace{Name: *req.Name}).Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	workspace, err := h.workspaceService.UpdateWorkspace(uuid.MustParse(userID.(string)), workspaceID, req.Name, req.Description)
+	if err != nil {
+		writeWorkspaceError(c, err, "Failed to update workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// DeleteWorkspace deletes an empty workspace
+func (h *WorkspaceHandler) DeleteWorkspace(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.workspaceService.DeleteWorkspace(uuid.MustParse(userID.(string)), workspaceID); err != nil {
+		if errors.Is(err, services.ErrWorkspaceNotEmpty) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			writeWorkspaceError(c, err, "Failed to delete workspace")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted successfully"})
+}
+
+// GetMembers lists the members of a workspace
+func (h *WorkspaceHandler) GetMembers(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.workspaceService.ListMembers(uuid.MustParse(userID.(string)), workspaceID)
+	if err != nil {
+		writeWorkspaceError(c, err, "Failed to fetch members")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members, "total": len(members)})
+}
+
+// InviteMember adds an existing user to a workspace
+func (h *WorkspaceHandler) InviteMember(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+
+	var req InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := h.db.Model(&models.User{})
+	switch {
+	case req.UserID != "":
+		query = query.Where("id = ?", req.UserID)
+	case req.Email != "":
+		query = query.Where("email = ?", req.Email)
+	case req.Username != "":
+		query = query.Where("username = ?", req.Username)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of user_id, email or username is required"})
+		return
+	}
+	var invitee models.User
+	if err := query.First(&invitee).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		}
+		return
+	}
+
+	member, err := h.workspaceService.InviteMember(uuid.MustParse(userID.(string)), workspaceID, invitee.ID, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAlreadyMember):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrShareUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			writeWorkspaceError(c, err, "Failed to add member")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember changes a member's role
+func (h *WorkspaceHandler) UpdateMember(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.workspaceService.UpdateMemberRole(uuid.MustParse(userID.(string)), workspaceID, memberID, req.Role)
+	if err != nil {
+		writeWorkspaceError(c, err, "Failed to update member")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a user from a workspace. Members may use it to leave.
+func (h *WorkspaceHandler) RemoveMember(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	workspaceID, ok := workspaceParam(c)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.workspaceService.RemoveMember(uuid.MustParse(userID.(string)), workspaceID, memberID); err != nil {
+		writeWorkspaceError(c, err, "Failed to remove member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// activeWorkspace returns the workspace the request works in, as resolved by
+// middleware.Workspace, or nil for the user's personal space
+func activeWorkspace(c *gin.Context) *uuid.UUID {
+	value, exists := c.Get("workspaceID")
+	if !exists {
+		return nil
+	}
+	workspaceID, ok := value.(uuid.UUID)
+	if !ok {
+		return nil
+	}
+	return &workspaceID
+}
+
+// workspaceParam parses the :workspace_id path parameter, writing a 404 if it
+// is not a valid ID
+func workspaceParam(c *gin.Context) (uuid.UUID, bool) {
+	workspaceID, err := uuid.Parse(c.Param("workspace_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return uuid.Nil, false
+	}
+	return workspaceID, true
+}
+
+// writeWorkspaceError maps workspace errors to responses: 404 for workspaces
+// the user does not belong to, 403 for actions their role does not allow
+func writeWorkspaceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+	case errors.Is(err, services.ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWorkspaceAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLastWorkspaceOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
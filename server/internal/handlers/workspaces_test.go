@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/middleware"
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupWorkspacesRouter(t *testing.T) (*gin.Engine, *gorm.DB, string) {
+	t.Helper()
+
+	db := database.SetupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	noteHandler := NewNoteHandler(db, nil)
+	workspaceHandler := NewWorkspaceHandler(db)
+	workspaceMiddleware := middleware.Workspace(services.NewWorkspaceService(db))
+
+	api := router.Group("/api")
+	api.Use(middleware.AuthMiddleware("test-secret"))
+	{
+		api.GET("/workspaces", workspaceHandler.GetWorkspaces)
+		api.POST("/workspaces", workspaceHandler.CreateWorkspace)
+		api.GET("/workspaces/:workspace_id/members", workspaceHandler.GetMembers)
+		api.POST("/workspaces/:workspace_id/members", workspaceHandler.InviteMember)
+		api.PUT("/workspaces/:workspace_id/members/:user_id", workspaceHandler.UpdateMember)
+
+		for _, group := range []*gin.RouterGroup{
+			api.Group("", workspaceMiddleware),
+			api.Group("/workspaces/:workspace_id", workspaceMiddleware),
+		} {
+			group.GET("/notes", noteHandler.GetNotes)
+			group.POST("/notes", noteHandler.CreateNote)
+			group.GET("/notes/:id", noteHandler.GetNote)
+		}
+	}
+
+	_, ownerToken := createSharesTestUser(t, db, "owner")
+	return router, db, ownerToken
+}
+
+func makeWorkspaceRequest(t *testing.T, router *gin.Engine, method, url, token, workspaceID string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload string
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		payload = string(data)
+	}
+	req := httptest.NewRequest(method, url, strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(middleware.WorkspaceHeader, workspaceID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestWorkspaceScopesNotes(t *testing.T) {
+	t.Parallel()
+	router, db, ownerToken := setupWorkspacesRouter(t)
+	teammate, teammateToken := createSharesTestUser(t, db, "teammate")
+	_, strangerToken := createSharesTestUser(t, db, "stranger")
+
+	w := makeRequest(t, router, "POST", "/api/workspaces", ownerToken, CreateWorkspaceRequest{Name: "Team"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var workspace models.Workspace
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &workspace))
+	workspaceID := workspace.ID.String()
+	membersURL := fmt.Sprintf("/api/workspaces/%s/members", workspaceID)
+
+	w = makeRequest(t, router, "POST", membersURL, ownerToken, InviteMemberRequest{Username: "teammate", Role: "viewer"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = makeRequest(t, router, "POST", membersURL, ownerToken, InviteMemberRequest{Username: "teammate", Role: "viewer"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = makeWorkspaceRequest(t, router, "POST", "/api/notes", ownerToken, workspaceID, CreateNoteRequest{Title: "Roadmap"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var note models.Note
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &note))
+	require.NotNil(t, note.WorkspaceID)
+	assert.Equal(t, workspace.ID, *note.WorkspaceID)
+
+	// Workspace notes stay out of the personal space
+	w = makeRequest(t, router, "GET", "/api/notes", ownerToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":0`)
+
+	// Members see the workspace notes by header or path
+	w = makeWorkspaceRequest(t, router, "GET", "/api/notes", teammateToken, workspaceID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Roadmap")
+	w = makeRequest(t, router, "GET", fmt.Sprintf("/api/workspaces/%s/notes/%s", workspaceID, note.ID), teammateToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Viewers cannot write and non-members cannot see the workspace
+	w = makeWorkspaceRequest(t, router, "POST", "/api/notes", teammateToken, workspaceID, CreateNoteRequest{Title: "Nope"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = makeWorkspaceRequest(t, router, "GET", "/api/notes", strangerToken, workspaceID, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Promoted members can write
+	w = makeRequest(t, router, "PUT", fmt.Sprintf("%s/%s", membersURL, teammate.ID), ownerToken, UpdateMemberRequest{Role: "member"})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = makeRequest(t, router, "POST", fmt.Sprintf("/api/workspaces/%s/notes", workspaceID), teammateToken, CreateNoteRequest{Title: "Retro"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = makeRequest(t, router, "GET", membersURL, teammateToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":2`)
+}
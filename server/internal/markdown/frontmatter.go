@@ -0,0 +1,85 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the YAML block at the top of a Markdown file holding note
+// fields that have no place in the body
+type FrontMatter struct {
+	Title    string  `yaml:"title,omitempty"`
+	Category string  `yaml:"category,omitempty"`
+	Tags     TagList `yaml:"tags,omitempty"`
+
+	// Extra holds any other keys, such as the properties other editors keep
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// TagList accepts tags written as a YAML list or as a single comma or space
+// separated string, as different editors do
+type TagList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *TagList) UnmarshalYAML(value *yaml.Node) error {
+	var raw []string
+	switch value.Kind {
+	case yaml.SequenceNode:
+		if err := value.Decode(&raw); err != nil {
+			return err
+		}
+	case yaml.ScalarNode:
+		raw = strings.FieldsFunc(value.Value, func(r rune) bool { return r == ',' || r == ' ' })
+	default:
+		return fmt.Errorf("tags must be a list or a string")
+	}
+
+	var tags TagList
+	for _, tag := range raw {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	*t = tags
+	return nil
+}
+
+// SplitFrontMatter separates a leading "---" delimited YAML block from the
+// Markdown body. Files without one return an empty FrontMatter and the
+// source unchanged.
+func SplitFrontMatter(src string) (FrontMatter, string, error) {
+	var fm FrontMatter
+
+	src = strings.TrimPrefix(strings.ReplaceAll(src, "\r\n", "\n"), "\ufeff")
+	if !strings.HasPrefix(src, "---\n") {
+		return fm, src, nil
+	}
+
+	lines := strings.SplitAfter(src, "\n")
+	for i := 1; i < len(lines); i++ {
+		closing := strings.TrimRight(lines[i], " \t\n")
+		if closing != "---" && closing != "..." {
+			continue
+		}
+		if err := yaml.Unmarshal([]byte(strings.Join(lines[1:i], "")), &fm); err != nil {
+			return fm, src, fmt.Errorf("invalid front matter: %w", err)
+		}
+		return fm, strings.TrimLeft(strings.Join(lines[i+1:], ""), "\n"), nil
+	}
+	return fm, src, nil
+}
+
+// RenderFrontMatter writes front matter as a "---" delimited YAML block,
+// or nothing when every field is empty
+func RenderFrontMatter(fm FrontMatter) string {
+	if fm.Title == "" && fm.Category == "" && len(fm.Tags) == 0 {
+		return ""
+	}
+	out, err := yaml.Marshal(fm)
+	if err != nil {
+		return ""
+	}
+	return "---\n" + string(out) + "---\n\n"
+}
@@ -0,0 +1,164 @@
+package markdown
+
+import (
+	"encoding/json"
+	"testing"
+
+	"notesage-server/internal/models"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func text(s string, marks ...string) map[string]interface{} {
+	n := map[string]interface{}{"type": "text", "text": s}
+	if len(marks) > 0 {
+		var list []interface{}
+		for _, mark := range marks {
+			list = append(list, map[string]interface{}{"type": mark})
+		}
+		n["marks"] = list
+	}
+	return n
+}
+
+func link(s, href string) map[string]interface{} {
+	return map[string]interface{}{"type": "text", "text": s, "marks": []interface{}{
+		map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": href}},
+	}}
+}
+
+func doc(content ...interface{}) models.JSONB {
+	return models.JSONB{"type": "doc", "content": content}
+}
+
+// normalize round-trips a document through JSON so that numbers and slices
+// compare the way they are stored
+func normalize(t *testing.T, d models.JSONB) models.JSONB {
+	t.Helper()
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	var out models.JSONB
+	require.NoError(t, json.Unmarshal(data, &out))
+	return out
+}
+
+func sampleDoc() models.JSONB {
+	return doc(
+		node("heading", map[string]interface{}{"level": 2}, text("Plan")),
+		node("paragraph", nil, text("Some "), text("bold", "bold"), text(" and "), text("italic", "italic"),
+			text(" with "), text("code", "code"), text(", "), text("gone", "strike"), text(" and a "), link("link", "https://example.com"), text(".")),
+		textParagraph("- [ ][t1] Call the vendor @alice 2024-03-01"),
+		textParagraph("- [x][t2] Send the invoice"),
+		node("bulletList", nil,
+			node("listItem", nil, node("paragraph", nil, text("one")),
+				node("orderedList", map[string]interface{}{"start": 3},
+					node("listItem", nil, node("paragraph", nil, text("three"))),
+					node("listItem", nil, node("paragraph", nil, text("four"))))),
+			node("listItem", nil, node("paragraph", nil, text("two")))),
+		node("taskList", nil,
+			node("taskItem", map[string]interface{}{"checked": true}, node("paragraph", nil, text("done"))),
+			node("taskItem", map[string]interface{}{"checked": false}, node("paragraph", nil, text("open")))),
+		node("blockquote", nil, node("paragraph", nil, text("quoted"))),
+		node("callout", map[string]interface{}{"type": "warning"}, node("paragraph", nil, text("careful"))),
+		codeBlock("func main() {\n\tprintln(\"```\")\n}", "go"),
+		node("mermaid", map[string]interface{}{"code": "graph TD\n  A-->B"}),
+		node("horizontalRule", nil),
+		node("table", nil,
+			node("tableRow", nil,
+				node("tableHeader", nil, node("paragraph", nil, text("Name"))),
+				node("tableHeader", nil, node("paragraph", nil, text("Role")))),
+			node("tableRow", nil,
+				node("tableCell", nil, node("paragraph", nil, text("Ada"))),
+				node("tableCell", nil, node("paragraph", nil, text("a|b"))))),
+		node("paragraph", nil, text("1. not a list, *not* emphasis")),
+		node("paragraph", nil, text("snake_case [brackets] <b> back\\slash ~tilde_ "), text("both", "bold", "italic"),
+			node("hardBreak", nil), text("- after a break")),
+	)
+}
+
+func TestRender(t *testing.T) {
+	out := Render(sampleDoc())
+
+	assert.Contains(t, out, "## Plan\n")
+	assert.Contains(t, out, "Some **bold** and *italic* with `code`, ~~gone~~ and a [link](https://example.com).")
+	assert.Contains(t, out, "\n- [ ][t1] Call the vendor @alice 2024-03-01\n")
+	assert.Contains(t, out, "- one\n\n  3. three\n  4. four\n- two")
+	assert.Contains(t, out, "- [x] done\n- [ ] open")
+	assert.Contains(t, out, "> [!warning]\n> careful")
+	assert.Contains(t, out, "````go\nfunc main() {")
+	assert.Contains(t, out, "```mermaid\ngraph TD\n  A-->B\n```")
+	assert.Contains(t, out, "| Name | Role |\n| --- | --- |\n| Ada | a\\|b |")
+	assert.Contains(t, out, `1\. not a list, \*not\* emphasis`)
+}
+
+func TestRoundTrip(t *testing.T) {
+	original := normalize(t, sampleDoc())
+
+	parsed := normalize(t, Parse(Render(original)))
+	assert.Equal(t, original, parsed)
+	assert.Equal(t, Render(original), Render(parsed))
+}
+
+func TestParse(t *testing.T) {
+	src := "Intro line\ncontinues here  \nafter break\n\n" +
+		"- [ ][t7] Review draft @bob 2024-05-01\n" +
+		"- [ ] ship it\n" +
+		"- [x] test it\n\n" +
+		"* plain\n* list\n\n" +
+		"Setext\n===\n\n" +
+		"    indented code\n\n" +
+		"![diagram](img/a.png \"Flow\")\n\n" +
+		"[bad](javascript:alert(1)) <https://example.com>\n\n" +
+		"[Go](https://en.wikipedia.org/wiki/Go_(language)) ok\n"
+
+	parsed := normalize(t, Parse(src))
+	expected := normalize(t, doc(
+		node("paragraph", nil, text("Intro line continues here"), node("hardBreak", nil), text("after break")),
+		textParagraph("- [ ][t7] Review draft @bob 2024-05-01"),
+		node("taskList", nil,
+			node("taskItem", map[string]interface{}{"checked": false}, node("paragraph", nil, text("ship it"))),
+			node("taskItem", map[string]interface{}{"checked": true}, node("paragraph", nil, text("test it")))),
+		node("bulletList", nil,
+			node("listItem", nil, node("paragraph", nil, text("plain"))),
+			node("listItem", nil, node("paragraph", nil, text("list")))),
+		node("heading", map[string]interface{}{"level": 1}, text("Setext")),
+		codeBlock("indented code", ""),
+		node("image", map[string]interface{}{"src": "img/a.png", "alt": "diagram", "title": "Flow"}),
+		node("paragraph", nil, text("bad "), link("https://example.com", "https://example.com")),
+		node("paragraph", nil, link("Go", "https://en.wikipedia.org/wiki/Go_(language)"), text(" ok")),
+	))
+	assert.Equal(t, expected, parsed)
+}
+
+func TestImportAndExport(t *testing.T) {
+	src := "---\ntitle: Weekly sync\ncategory: Meeting\ntags: \"work, #planning\"\n---\n\n# Ignored heading\n\nBody text\n"
+	document, err := Import(src, "fallback")
+	require.NoError(t, err)
+	assert.Equal(t, "Weekly sync", document.Title)
+	assert.Equal(t, "Meeting", document.Category)
+	assert.Equal(t, []string{"work", "planning"}, document.Tags)
+	assert.Len(t, contentOf(document.Content), 2)
+
+	document, err = Import("# From heading\n\nBody", "fallback")
+	require.NoError(t, err)
+	assert.Equal(t, "From heading", document.Title)
+	assert.Equal(t, "Body\n", Render(document.Content))
+
+	document, err = Import("Just text", "fallback")
+	require.NoError(t, err)
+	assert.Equal(t, "fallback", document.Title)
+
+	_, err = Import("---\ntitle: [unclosed\n---\n", "fallback")
+	assert.Error(t, err)
+
+	note := &models.Note{Title: "Weekly sync", Category: "Meeting", Tags: pq.StringArray{"work"}, Content: document.Content}
+	out := Export(note)
+	assert.Equal(t, "---\ntitle: Weekly sync\ncategory: Meeting\ntags:\n    - work\n---\n\nJust text\n", out)
+
+	reimported, err := Import(out, "fallback")
+	require.NoError(t, err)
+	assert.Equal(t, note.Title, reimported.Title)
+	assert.Equal(t, []string(note.Tags), reimported.Tags)
+}
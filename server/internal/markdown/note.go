@@ -0,0 +1,107 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+)
+
+// Document is a Markdown file read into note fields
+type Document struct {
+	Title    string
+	Category string
+	Tags     []string
+	Content  models.JSONB
+
+	// Properties are the other front matter keys, lowercased, with their
+	// values as text
+	Properties map[string]string
+}
+
+// Export renders a note as a Markdown file, with the fields that are not
+// part of the body in front matter
+func Export(note *models.Note) string {
+	fm := FrontMatter{Title: note.Title, Tags: TagList(note.Tags)}
+	if note.Category != "" && note.Category != "Note" {
+		fm.Category = note.Category
+	}
+	return RenderFrontMatter(fm) + Render(note.Content)
+}
+
+// Import reads a Markdown file. The title is taken from front matter, then
+// from a level 1 heading at the top of the body, which is removed, and
+// otherwise is fallbackTitle.
+func Import(src, fallbackTitle string) (*Document, error) {
+	fm, body, err := SplitFrontMatter(src)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		Title:    strings.TrimSpace(fm.Title),
+		Category: strings.TrimSpace(fm.Category),
+		Tags:     fm.Tags,
+		Content:  Parse(body),
+	}
+	if len(fm.Extra) > 0 {
+		doc.Properties = make(map[string]string, len(fm.Extra))
+		for key, value := range fm.Extra {
+			doc.Properties[strings.ToLower(key)] = propertyText(value)
+		}
+	}
+
+	if doc.Title == "" {
+		content := contentOf(doc.Content)
+		if len(content) > 0 {
+			first, _ := content[0].(map[string]interface{})
+			if nodeType(first) == "heading" && intAttr(first, "level", 0) == 1 {
+				doc.Title = strings.TrimSpace(plainText(first))
+				content = content[1:]
+				if len(content) == 0 {
+					content = []interface{}{node("paragraph", nil)}
+				}
+				doc.Content["content"] = content
+			}
+		}
+	}
+	if doc.Title == "" {
+		doc.Title = fallbackTitle
+	}
+	return doc, nil
+}
+
+// propertyText formats a front matter value as text, joining lists with commas
+func propertyText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, propertyText(item))
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+// Filename turns a note title into a safe file name, without an extension
+func Filename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r < 32, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "note"
+	}
+	return name
+}
@@ -0,0 +1,789 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"notesage-server/internal/models"
+)
+
+var (
+	atxHeadingPattern    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	fencePattern         = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+	thematicBreakPattern = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	listItemPattern      = regexp.MustCompile(`^( {0,3})([-+*]|\d{1,9}[.)])(?:([ \t]+)(.*))?$`)
+	taskMarkerPattern    = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	setextPattern        = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	tableDelimPattern    = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	calloutPattern       = regexp.MustCompile(`^\[!([A-Za-z]+)\][+-]?[ \t]*$`)
+	autolinkPattern      = regexp.MustCompile(`^<((?:https?|mailto):[^<>\s]+)>`)
+	breakTagPattern      = regexp.MustCompile(`^<br\s*/?>`)
+	cellBreakPattern     = regexp.MustCompile(`<br\s*/?>`)
+	safeURLPattern       = regexp.MustCompile(`(?i)^(?:https?:|mailto:|[^:]*$)`)
+)
+
+// Parse converts Markdown to a note document
+func Parse(src string) models.JSONB {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	lines := strings.Split(src, "\n")
+
+	// Tabs indent lists and quotes outside code, but inside fenced code they
+	// are content
+	var fence string
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if fence == "" {
+			lines[i] = expandTabs(line)
+			if match := fencePattern.FindStringSubmatch(strings.TrimLeft(lines[i], " ")); match != nil {
+				fence = match[2]
+			}
+		} else if strings.HasPrefix(trimmed, fence) && strings.Trim(strings.TrimSpace(trimmed), fence[:1]) == "" {
+			fence = ""
+		}
+	}
+
+	content := parseBlocks(lines)
+	if len(content) == 0 {
+		content = []interface{}{node("paragraph", nil)}
+	}
+	return models.JSONB{"type": "doc", "content": content}
+}
+
+// parseBlocks parses lines into block nodes
+func parseBlocks(lines []string) []interface{} {
+	var blocks []interface{}
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || trimmed == "<!-- -->":
+			i++
+
+		case todoLinePattern.MatchString(line):
+			blocks = append(blocks, textParagraph(trimmed))
+			i++
+
+		case fencePattern.MatchString(line):
+			var block map[string]interface{}
+			block, i = parseFence(lines, i)
+			blocks = append(blocks, block)
+
+		case atxHeadingPattern.MatchString(line):
+			match := atxHeadingPattern.FindStringSubmatch(line)
+			blocks = append(blocks, node("heading", map[string]interface{}{"level": len(match[1])}, parseInline(match[2])...))
+			i++
+
+		case thematicBreakPattern.MatchString(line):
+			blocks = append(blocks, node("horizontalRule", nil))
+			i++
+
+		case isQuoteLine(line):
+			var inner []string
+			for i < len(lines) && isQuoteLine(lines[i]) {
+				inner = append(inner, stripQuote(lines[i]))
+				i++
+			}
+			blocks = append(blocks, parseQuote(inner))
+
+		case isTableStart(lines, i):
+			var block map[string]interface{}
+			block, i = parseTable(lines, i)
+			blocks = append(blocks, block)
+
+		case listItemPattern.MatchString(line):
+			var block map[string]interface{}
+			block, i = parseList(lines, i)
+			blocks = append(blocks, block)
+
+		case indentOf(line) >= 4:
+			var code []string
+			for i < len(lines) && (indentOf(lines[i]) >= 4 || strings.TrimSpace(lines[i]) == "") {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+				i++
+			}
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, codeBlock(strings.Join(code, "\n"), ""))
+
+		default:
+			var block map[string]interface{}
+			block, i = parseParagraph(lines, i)
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// parseParagraph collects paragraph lines until a blank line or the start
+// of another block. A setext underline turns the paragraph into a heading.
+func parseParagraph(lines []string, start int) (map[string]interface{}, int) {
+	text := []string{strings.TrimLeft(lines[start], " ")}
+	i := start + 1
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if match := setextPattern.FindStringSubmatch(line); match != nil {
+			level := 2
+			if match[1][0] == '=' {
+				level = 1
+			}
+			return node("heading", map[string]interface{}{"level": level}, parseInline(strings.Join(text, "\n"))...), i + 1
+		}
+		if interruptsParagraph(line) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+
+	inline := parseInline(strings.Join(text, "\n"))
+	// An image on its own line is a block image in the editor
+	if len(inline) == 1 && nodeType(inline[0].(map[string]interface{})) == "image" {
+		return inline[0].(map[string]interface{}), i
+	}
+	return node("paragraph", nil, inline...), i
+}
+
+// interruptsParagraph reports whether a line ends the paragraph before it
+func interruptsParagraph(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+	if todoLinePattern.MatchString(line) || fencePattern.MatchString(line) ||
+		atxHeadingPattern.MatchString(line) || thematicBreakPattern.MatchString(line) || isQuoteLine(line) {
+		return true
+	}
+	if match := listItemPattern.FindStringSubmatch(line); match != nil && strings.TrimSpace(match[4]) != "" {
+		// Only ordered lists starting at 1 may interrupt a paragraph
+		marker := match[2]
+		return marker[0] < '0' || marker[0] > '9' || strings.TrimRight(marker, ".)") == "1"
+	}
+	return false
+}
+
+func parseFence(lines []string, start int) (map[string]interface{}, int) {
+	match := fencePattern.FindStringSubmatch(lines[start])
+	indent, marker, info := len(match[1]), match[2], match[3]
+	lang := ""
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = fields[0]
+	}
+
+	var code []string
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, marker[:1]) && strings.Trim(trimmed, marker[:1]) == "" && len(trimmed) >= len(marker) && indentOf(lines[i]) < 4 {
+			i++
+			break
+		}
+		line := lines[i]
+		for j := 0; j < indent && strings.HasPrefix(line, " "); j++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+
+	if lang == "mermaid" {
+		return node("mermaid", map[string]interface{}{"code": strings.Join(code, "\n")}), i
+	}
+	return codeBlock(strings.Join(code, "\n"), lang), i
+}
+
+// parseQuote turns the lines inside a blockquote into a blockquote, or a
+// callout when the first line is an Obsidian-style "[!type]" marker
+func parseQuote(lines []string) map[string]interface{} {
+	if len(lines) > 0 {
+		if match := calloutPattern.FindStringSubmatch(strings.TrimSpace(lines[0])); match != nil {
+			content := parseBlocks(lines[1:])
+			if len(content) == 0 {
+				content = []interface{}{node("paragraph", nil)}
+			}
+			return node("callout", map[string]interface{}{"type": strings.ToLower(match[1])}, content...)
+		}
+	}
+	content := parseBlocks(lines)
+	if len(content) == 0 {
+		content = []interface{}{node("paragraph", nil)}
+	}
+	return node("blockquote", nil, content...)
+}
+
+func isQuoteLine(line string) bool {
+	return indentOf(line) < 4 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func stripQuote(line string) string {
+	line = strings.TrimPrefix(strings.TrimLeft(line, " "), ">")
+	return strings.TrimPrefix(line, " ")
+}
+
+// parseList collects consecutive items of one list. Items of a bullet list
+// that all start with a checkbox make a task list.
+func parseList(lines []string, start int) (map[string]interface{}, int) {
+	first := listItemPattern.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	delimiter := first[2][len(first[2])-1:]
+
+	var items [][]string
+	i := start
+	for i < len(lines) {
+		match := listItemPattern.FindStringSubmatch(lines[i])
+		if match == nil || todoLinePattern.MatchString(lines[i]) || thematicBreakPattern.MatchString(lines[i]) {
+			break
+		}
+		isOrdered := match[2][0] >= '0' && match[2][0] <= '9'
+		if isOrdered != ordered || match[2][len(match[2])-1:] != delimiter {
+			break
+		}
+
+		// Continuation lines must be indented to the item's content column
+		padding := len(match[3])
+		if padding > 4 || match[4] == "" {
+			padding = 1
+		}
+		contentIndent := len(match[1]) + len(match[2]) + padding
+		item := []string{strings.Repeat(" ", max(0, len(match[3])-padding)) + match[4]}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				next := i + 1
+				for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+					next++
+				}
+				if next < len(lines) && indentOf(lines[next]) >= contentIndent {
+					item = append(item, "")
+					i++
+					continue
+				}
+				break
+			}
+			if indentOf(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+				i++
+				continue
+			}
+			// Lazy continuation of the item's paragraph
+			last := item[len(item)-1]
+			if strings.TrimSpace(last) != "" && !interruptsParagraph(line) && !listItemPattern.MatchString(line) && !isTableStart(lines, i) {
+				item = append(item, strings.TrimLeft(line, " "))
+				i++
+				continue
+			}
+			break
+		}
+		items = append(items, item)
+
+		// A blank line may separate items of the same list
+		next := i
+		for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+			next++
+		}
+		if next > i && next < len(lines) && listItemPattern.MatchString(lines[next]) && !todoLinePattern.MatchString(lines[next]) {
+			i = next
+		}
+	}
+
+	isTaskList := !ordered
+	for _, item := range items {
+		if !taskMarkerPattern.MatchString(item[0]) {
+			isTaskList = false
+		}
+	}
+
+	var children []interface{}
+	for _, item := range items {
+		if isTaskList {
+			match := taskMarkerPattern.FindStringSubmatch(item[0])
+			item[0] = item[0][len(match[0]):]
+			checked := match[1] != " "
+			children = append(children, node("taskItem", map[string]interface{}{"checked": checked}, itemContent(item)...))
+		} else {
+			children = append(children, node("listItem", nil, itemContent(item)...))
+		}
+	}
+
+	switch {
+	case isTaskList:
+		return node("taskList", nil, children...), i
+	case ordered:
+		start, _ := strconv.Atoi(strings.TrimRight(first[2], ".)"))
+		return node("orderedList", map[string]interface{}{"start": start}, children...), i
+	default:
+		return node("bulletList", nil, children...), i
+	}
+}
+
+// itemContent parses a list item's lines, which must start with a paragraph
+// in the editor
+func itemContent(lines []string) []interface{} {
+	content := parseBlocks(lines)
+	if len(content) == 0 || nodeType(content[0].(map[string]interface{})) != "paragraph" {
+		content = append([]interface{}{node("paragraph", nil)}, content...)
+	}
+	return content
+}
+
+func isTableStart(lines []string, i int) bool {
+	return i+1 < len(lines) && strings.Contains(lines[i], "|") && tableDelimPattern.MatchString(lines[i+1]) &&
+		len(splitRow(lines[i])) == len(splitRow(lines[i+1]))
+}
+
+func parseTable(lines []string, start int) (map[string]interface{}, int) {
+	columns := len(splitRow(lines[start]))
+	rows := []interface{}{tableRow(splitRow(lines[start]), columns, "tableHeader")}
+
+	i := start + 2
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" || !strings.Contains(lines[i], "|") {
+			break
+		}
+		rows = append(rows, tableRow(splitRow(lines[i]), columns, "tableCell"))
+	}
+	return node("table", nil, rows...), i
+}
+
+func tableRow(cells []string, columns int, cellType string) map[string]interface{} {
+	var children []interface{}
+	for c := 0; c < columns; c++ {
+		text := ""
+		if c < len(cells) {
+			text = cells[c]
+		}
+		var paragraphs []interface{}
+		for _, part := range cellBreakPattern.Split(text, -1) {
+			paragraphs = append(paragraphs, node("paragraph", nil, parseInline(strings.TrimSpace(part))...))
+		}
+		children = append(children, node(cellType, nil, paragraphs...))
+	}
+	return node("tableRow", nil, children...)
+}
+
+// splitRow splits a table row on unescaped pipes outside code spans
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '`':
+			inCode = !inCode
+			cell.WriteByte('`')
+		case line[i] == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// inlineParser turns inline Markdown into text nodes with marks
+type inlineParser struct {
+	src   string
+	out   []interface{}
+	text  strings.Builder
+	marks []interface{}
+}
+
+// parseInline parses the inline content of a block
+func parseInline(src string) []interface{} {
+	p := &inlineParser{src: src}
+	p.parse(0, len(src))
+	p.flush()
+	return p.out
+}
+
+func (p *inlineParser) parse(from, to int) {
+	i := from
+	for i < to {
+		c := p.src[i]
+		switch {
+		case c == '\\' && i+1 < to && p.src[i+1] == '\n':
+			p.emit(node("hardBreak", nil))
+			i += 2
+
+		case c == '\\' && i+1 < to && isASCIIPunct(p.src[i+1]):
+			p.text.WriteByte(p.src[i+1])
+			i += 2
+
+		case c == '\n':
+			// Two trailing spaces make a hard break, anything else a soft one
+			buffered := p.text.String()
+			if strings.HasSuffix(buffered, "  ") {
+				p.text.Reset()
+				p.text.WriteString(strings.TrimRight(buffered, " "))
+				p.emit(node("hardBreak", nil))
+			} else {
+				p.text.Reset()
+				p.text.WriteString(strings.TrimRight(buffered, " ") + " ")
+			}
+			i++
+			for i < to && p.src[i] == ' ' {
+				i++
+			}
+
+		case c == '`':
+			i = p.codeSpan(i, to)
+
+		case c == '!' && i+1 < to && p.src[i+1] == '[':
+			if next, ok := p.link(i+1, to, true); ok {
+				i = next
+			} else {
+				p.text.WriteByte(c)
+				i++
+			}
+
+		case c == '[':
+			if next, ok := p.link(i, to, false); ok {
+				i = next
+			} else {
+				p.text.WriteByte(c)
+				i++
+			}
+
+		case c == '<':
+			if match := autolinkPattern.FindStringSubmatch(p.src[i:to]); match != nil {
+				p.withMark(map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": match[1]}}, func() {
+					p.text.WriteString(match[1])
+				})
+				i += len(match[0])
+			} else if match := breakTagPattern.FindString(p.src[i:to]); match != "" {
+				p.emit(node("hardBreak", nil))
+				i += len(match)
+			} else {
+				p.text.WriteByte(c)
+				i++
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			i = p.emphasis(i, to)
+
+		default:
+			p.text.WriteByte(c)
+			i++
+		}
+	}
+}
+
+// codeSpan parses a backtick code span starting at i
+func (p *inlineParser) codeSpan(i, to int) int {
+	n := runLength(p.src, i, to, '`')
+	marker := p.src[i : i+n]
+	for j := i + n; j < to; {
+		if p.src[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(p.src, j, to, '`')
+		if m == n {
+			code := strings.ReplaceAll(p.src[i+n:j], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			p.withMark(map[string]interface{}{"type": "code"}, func() { p.text.WriteString(code) })
+			return j + m
+		}
+		j += m
+	}
+	p.text.WriteString(marker)
+	return i + n
+}
+
+// link parses "[label](destination "title")" starting at the opening
+// bracket. Images become image nodes, links a link mark over the label.
+func (p *inlineParser) link(open, to int, image bool) (int, bool) {
+	close := matchingBracket(p.src, open, to)
+	if close < 0 || close+1 >= to || p.src[close+1] != '(' {
+		return 0, false
+	}
+	end := matchingParen(p.src, close+1, to)
+	if end < 0 {
+		return 0, false
+	}
+
+	target := strings.TrimSpace(p.src[close+2 : end])
+	href, title := target, ""
+	if space := strings.IndexAny(target, " \t"); space >= 0 {
+		rest := strings.TrimSpace(target[space:])
+		if len(rest) >= 2 && (rest[0] == '"' || rest[0] == '\'') && rest[len(rest)-1] == rest[0] {
+			href = target[:space]
+			title = strings.ReplaceAll(rest[1:len(rest)-1], `\"`, `"`)
+		}
+	}
+	href = strings.TrimSuffix(strings.TrimPrefix(href, "<"), ">")
+	href = strings.NewReplacer("%20", " ", "%28", "(", "%29", ")").Replace(href)
+
+	if image {
+		attrs := map[string]interface{}{"src": href, "alt": unescape(p.src[open+1 : close])}
+		if title != "" {
+			attrs["title"] = title
+		}
+		if !safeURLPattern.MatchString(href) {
+			attrs["src"] = ""
+		}
+		p.emit(node("image", attrs))
+		return end + 1, true
+	}
+
+	if !safeURLPattern.MatchString(href) {
+		// Unsafe schemes such as javascript: keep their label as plain text
+		p.parse(open+1, close)
+		return end + 1, true
+	}
+	p.withMark(map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": href}}, func() {
+		p.parse(open+1, close)
+	})
+	return end + 1, true
+}
+
+// emphasis parses a run of *, _ or ~ as bold, italic or strikethrough when
+// it has a matching closing run, and as literal text otherwise
+func (p *inlineParser) emphasis(i, to int) int {
+	c := p.src[i]
+	n := runLength(p.src, i, to, c)
+	if c == '~' && n != 2 || n > 3 {
+		p.text.WriteString(p.src[i : i+n])
+		return i + n
+	}
+
+	opens := i+n < to && !spaceAt(p.src, i+n)
+	if c == '_' && wordBefore(p.src, i) {
+		opens = false
+	}
+	if !opens {
+		p.text.WriteString(p.src[i : i+n])
+		return i + n
+	}
+
+	for j := i + n; j < to; {
+		switch p.src[j] {
+		case '\\':
+			j += 2
+			continue
+		case '`':
+			j += runLength(p.src, j, to, '`')
+			continue
+		}
+		if p.src[j] != c {
+			j++
+			continue
+		}
+		m := runLength(p.src, j, to, c)
+		closes := m == n && !spaceBefore(p.src, j)
+		if c == '_' && j+m < to && wordAt(p.src, j+m) {
+			closes = false
+		}
+		if !closes {
+			j += m
+			continue
+		}
+
+		var marks []string
+		switch {
+		case c == '~':
+			marks = []string{"strike"}
+		case n == 1:
+			marks = []string{"italic"}
+		case n == 2:
+			marks = []string{"bold"}
+		default:
+			marks = []string{"bold", "italic"}
+		}
+		inner := func() { p.parse(i+n, j) }
+		for k := len(marks) - 1; k >= 0; k-- {
+			wrapped, markType := inner, marks[k]
+			inner = func() { p.withMark(map[string]interface{}{"type": markType}, wrapped) }
+		}
+		inner()
+		return j + m
+	}
+
+	p.text.WriteString(p.src[i : i+n])
+	return i + n
+}
+
+// withMark runs fn with mark added to the active marks
+func (p *inlineParser) withMark(mark map[string]interface{}, fn func()) {
+	p.flush()
+	saved := p.marks
+	p.marks = append(append([]interface{}{}, p.marks...), mark)
+	fn()
+	p.flush()
+	p.marks = saved
+}
+
+// emit adds a non-text inline node after any buffered text
+func (p *inlineParser) emit(n map[string]interface{}) {
+	p.flush()
+	p.out = append(p.out, n)
+}
+
+// flush writes buffered text as a text node with the active marks, merging
+// it into the previous node when the marks are the same
+func (p *inlineParser) flush() {
+	if p.text.Len() == 0 {
+		return
+	}
+	text := p.text.String()
+	p.text.Reset()
+
+	if len(p.out) > 0 {
+		last := p.out[len(p.out)-1].(map[string]interface{})
+		if nodeType(last) == "text" && sameMarks(marksOf(last), marksOf(map[string]interface{}{"marks": p.marks})) {
+			last["text"] = last["text"].(string) + text
+			return
+		}
+	}
+
+	textNode := map[string]interface{}{"type": "text", "text": text}
+	if len(p.marks) > 0 {
+		textNode["marks"] = append([]interface{}{}, p.marks...)
+	}
+	p.out = append(p.out, textNode)
+}
+
+// matchingBracket finds the ] that closes the [ at open, skipping escapes
+// and nested brackets
+func matchingBracket(src string, open, to int) int {
+	depth := 0
+	for i := open; i < to; i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchingParen finds the ) that closes the link destination opened at open,
+// skipping escapes and balanced parentheses inside the destination
+func matchingParen(src string, open, to int) int {
+	depth := 0
+	for i := open; i < to; i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func node(nodeType string, attrs map[string]interface{}, content ...interface{}) map[string]interface{} {
+	n := map[string]interface{}{"type": nodeType}
+	if attrs != nil {
+		n["attrs"] = attrs
+	}
+	if len(content) > 0 {
+		n["content"] = content
+	}
+	return n
+}
+
+// textParagraph is a paragraph holding text exactly as written
+func textParagraph(text string) map[string]interface{} {
+	return node("paragraph", nil, map[string]interface{}{"type": "text", "text": text})
+}
+
+func codeBlock(code, language string) map[string]interface{} {
+	var attrs map[string]interface{}
+	if language != "" {
+		attrs = map[string]interface{}{"language": language}
+	}
+	if code == "" {
+		return node("codeBlock", attrs)
+	}
+	return node("codeBlock", attrs, map[string]interface{}{"type": "text", "text": code})
+}
+
+func unescape(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]) {
+			i++
+		}
+		out.WriteByte(text[i])
+	}
+	return out.String()
+}
+
+func runLength(src string, i, to int, c byte) int {
+	n := 0
+	for i+n < to && src[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// expandTabs replaces leading tabs with four spaces
+func expandTabs(line string) string {
+	i := 0
+	var out strings.Builder
+	for ; i < len(line) && (line[i] == '\t' || line[i] == ' '); i++ {
+		if line[i] == '\t' {
+			out.WriteString(strings.Repeat(" ", 4-out.Len()%4))
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	return out.String() + line[i:]
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func spaceAt(src string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(src[i:])
+	return unicode.IsSpace(r)
+}
+
+func spaceBefore(src string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(src[:i])
+	return unicode.IsSpace(r)
+}
+
+func wordAt(src string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(src[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func wordBefore(src string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(src[:i])
+	return i > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -0,0 +1,544 @@
+// Package markdown converts note content between the TipTap/ProseMirror
+// document stored in Note.Content and CommonMark with the GitHub extensions
+// the editor supports: task lists, tables, strikethrough and fenced code.
+//
+// Todo lines written in the note todo syntax ("- [ ][t1] text @person date")
+// are kept as literal paragraphs in both directions, so todo IDs survive a
+// round trip.
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"notesage-server/internal/models"
+)
+
+// todoLinePattern matches the start of a line in the note todo syntax
+var todoLinePattern = regexp.MustCompile(`^\s*-\s*\[[xX ]\]\[t\d+\]`)
+
+// blockStartPattern matches paragraph text that would be read back as some
+// other block and so needs its first character escaped
+var blockStartPattern = regexp.MustCompile(`^(#{1,6}(\s|$)|>|[-+*](\s|$)|` + "```" + `|~~~|=+\s*$|\|)`)
+
+// orderedStartPattern matches paragraph text that looks like an ordered list
+// item, which is escaped at its delimiter
+var orderedStartPattern = regexp.MustCompile(`^(\d{1,9})[.)](\s|$)`)
+
+// Render converts a note document to Markdown
+func Render(doc models.JSONB) string {
+	if doc == nil {
+		return ""
+	}
+	out := strings.TrimRight(renderBlocks(contentOf(doc), false), "\n")
+	if out == "" {
+		return ""
+	}
+	return out + "\n"
+}
+
+// escapeBlockStart escapes the start of a line of paragraph text that would
+// otherwise be read back as another kind of block
+func escapeBlockStart(line string) string {
+	if match := orderedStartPattern.FindStringSubmatch(line); match != nil {
+		return match[1] + `\` + line[len(match[1]):]
+	}
+	if blockStartPattern.MatchString(line) {
+		return `\` + line
+	}
+	return line
+}
+
+// renderBlocks renders a sequence of block nodes separated by blank lines, or
+// by single newlines in tight list items
+func renderBlocks(nodes []interface{}, tight bool) string {
+	var blocks []string
+	var previous map[string]interface{}
+	for _, item := range nodes {
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		block := renderBlock(node)
+		if block == "" && nodeType(node) != "paragraph" {
+			continue
+		}
+		if len(blocks) > 0 {
+			separator := "\n\n"
+			// An ordered list that does not start at 1 cannot interrupt a paragraph
+			if tight && !(nodeType(node) == "orderedList" && intAttr(node, "start", 1) != 1) {
+				separator = "\n"
+			}
+			// Adjacent lists with the same markers would merge; a comment keeps them apart
+			if isList(node) && isList(previous) && isOrdered(node) == isOrdered(previous) {
+				separator = "\n\n<!-- -->\n\n"
+			}
+			blocks = append(blocks, separator)
+		}
+		blocks = append(blocks, block)
+		previous = node
+	}
+	return strings.Join(blocks, "")
+}
+
+func renderBlock(node map[string]interface{}) string {
+	switch nodeType(node) {
+	case "paragraph":
+		text := plainText(node)
+		if todoLinePattern.MatchString(text) {
+			return strings.TrimSpace(text)
+		}
+		// Lines after hard breaks must not start a block either
+		lines := strings.Split(renderInline(contentOf(node)), "\n")
+		for i, line := range lines {
+			lines[i] = escapeBlockStart(line)
+		}
+		return strings.Join(lines, "\n")
+
+	case "heading":
+		level := intAttr(node, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + renderInline(contentOf(node))
+
+	case "codeBlock":
+		return fence(plainText(node), stringAttr(node, "language"))
+
+	case "mermaid":
+		return fence(stringAttr(node, "code"), "mermaid")
+
+	case "blockquote":
+		return quote(renderBlocks(contentOf(node), false))
+
+	case "callout":
+		kind := stringAttr(node, "type")
+		if kind == "" {
+			kind = "info"
+		}
+		body := renderBlocks(contentOf(node), false)
+		return quote(fmt.Sprintf("[!%s]\n%s", kind, body))
+
+	case "horizontalRule":
+		return "---"
+
+	case "image":
+		return renderImage(node)
+
+	case "bulletList":
+		return renderList(node, func(int) string { return "- " })
+
+	case "orderedList":
+		start := intAttr(node, "start", 1)
+		return renderList(node, func(i int) string { return fmt.Sprintf("%d. ", start+i) })
+
+	case "taskList":
+		return renderList(node, func(int) string { return "- " })
+
+	case "table":
+		return renderTable(node)
+
+	default:
+		// Unknown containers keep their content; unknown leaves their text
+		if children := contentOf(node); len(children) > 0 {
+			if isTextblock(node) {
+				return renderInline(children)
+			}
+			return renderBlocks(children, false)
+		}
+		return ""
+	}
+}
+
+// renderList renders list items with marker(i) before each, indenting
+// continuation lines to line up with the item's content
+func renderList(node map[string]interface{}, marker func(int) string) string {
+	var items []string
+	for i, item := range contentOf(node) {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		prefix := marker(i)
+		if nodeType(child) == "taskItem" {
+			if boolAttr(child, "checked") {
+				prefix += "[x] "
+			} else {
+				prefix += "[ ] "
+			}
+		}
+
+		body := renderBlocks(contentOf(child), isTightItem(child))
+		indent := strings.Repeat(" ", len(marker(i)))
+		lines := strings.Split(body, "\n")
+		for j := 1; j < len(lines); j++ {
+			if lines[j] != "" {
+				lines[j] = indent + lines[j]
+			}
+		}
+		items = append(items, prefix+strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+// isTightItem reports whether a list item is a single paragraph optionally
+// followed by nested lists, which render without blank lines between them
+func isTightItem(item map[string]interface{}) bool {
+	for i, child := range contentOf(item) {
+		node, ok := child.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if i == 0 && nodeType(node) == "paragraph" {
+			continue
+		}
+		if !isList(node) {
+			return false
+		}
+	}
+	return true
+}
+
+func renderTable(node map[string]interface{}) string {
+	var rows [][]string
+	columns := 0
+	for _, item := range contentOf(node) {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var cells []string
+		for _, cellItem := range contentOf(row) {
+			cell, ok := cellItem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var parts []string
+			for _, block := range contentOf(cell) {
+				if blockNode, ok := block.(map[string]interface{}); ok {
+					parts = append(parts, renderInline(contentOf(blockNode)))
+				}
+			}
+			text := strings.ReplaceAll(strings.Join(parts, "<br>"), "\\\n", "<br>")
+			text = strings.ReplaceAll(text, "|", `\|`)
+			text = strings.ReplaceAll(text, "\n", " ")
+			cells = append(cells, text)
+		}
+		columns = max(columns, len(cells))
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 || columns == 0 {
+		return ""
+	}
+
+	var out []string
+	for i, cells := range rows {
+		for len(cells) < columns {
+			cells = append(cells, "")
+		}
+		out = append(out, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			out = append(out, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderInline renders the inline content of a textblock
+func renderInline(nodes []interface{}) string {
+	var out strings.Builder
+	for _, run := range mergeRuns(nodes) {
+		switch nodeType(run) {
+		case "text":
+			out.WriteString(renderText(run))
+		case "hardBreak":
+			out.WriteString("\\\n")
+		case "image":
+			out.WriteString(renderImage(run))
+		case "mention":
+			name := stringAttr(run, "name")
+			if name == "" {
+				name = stringAttr(run, "label")
+			}
+			if name == "" {
+				name = stringAttr(run, "id")
+			}
+			out.WriteString("@" + name)
+		default:
+			out.WriteString(escapeText(plainText(run)))
+		}
+	}
+	return out.String()
+}
+
+// renderText renders a text node with its marks. Whitespace at the edges is
+// kept outside the delimiters, where CommonMark requires it.
+func renderText(node map[string]interface{}) string {
+	text, _ := node["text"].(string)
+	marks := marksOf(node)
+
+	if hasMark(marks, "code") {
+		return codeSpan(text)
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return escapeText(text)
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+
+	out := escapeText(trimmed)
+	if hasMark(marks, "strike") {
+		out = "~~" + out + "~~"
+	}
+	if hasMark(marks, "italic") {
+		out = "*" + out + "*"
+	}
+	if hasMark(marks, "bold") {
+		out = "**" + out + "**"
+	}
+	for _, mark := range marks {
+		if mark["type"] == "link" {
+			attrs, _ := mark["attrs"].(map[string]interface{})
+			href, _ := attrs["href"].(string)
+			out = "[" + out + "](" + escapeURL(href) + ")"
+		}
+	}
+	return escapeText(lead) + out + escapeText(trail)
+}
+
+func renderImage(node map[string]interface{}) string {
+	out := "![" + escapeText(stringAttr(node, "alt")) + "](" + escapeURL(stringAttr(node, "src"))
+	if title := stringAttr(node, "title"); title != "" {
+		out += ` "` + strings.ReplaceAll(title, `"`, `\"`) + `"`
+	}
+	return out + ")"
+}
+
+// mergeRuns joins adjacent text nodes with the same marks so that emphasis is
+// written once around the whole run
+func mergeRuns(nodes []interface{}) []map[string]interface{} {
+	var runs []map[string]interface{}
+	for _, item := range nodes {
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if len(runs) > 0 {
+			last := runs[len(runs)-1]
+			if nodeType(node) == "text" && nodeType(last) == "text" && sameMarks(marksOf(node), marksOf(last)) {
+				merged := make(map[string]interface{}, len(last))
+				for key, value := range last {
+					merged[key] = value
+				}
+				merged["text"] = last["text"].(string) + node["text"].(string)
+				runs[len(runs)-1] = merged
+				continue
+			}
+		}
+		if nodeType(node) == "text" {
+			if _, ok := node["text"].(string); !ok {
+				continue
+			}
+		}
+		runs = append(runs, node)
+	}
+	return runs
+}
+
+// fence writes a fenced code block, using a fence longer than any run of
+// backticks in the code
+func fence(code, info string) string {
+	longest := 0
+	run := 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return marker + info + "\n" + strings.TrimSuffix(code, "\n") + "\n" + marker
+}
+
+func codeSpan(text string) string {
+	longest := 0
+	run := 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return marker + text + marker
+}
+
+// quote prefixes every line of a block with a blockquote marker
+func quote(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeText backslash-escapes characters that would otherwise start inline
+// Markdown syntax
+func escapeText(text string) string {
+	var out strings.Builder
+	runes := []rune(text)
+	for i, r := range runes {
+		switch r {
+		case '\\', '*', '`', '[', ']', '~', '<':
+			out.WriteRune('\\')
+		case '_':
+			// Intraword underscores never open emphasis
+			if i == 0 || i == len(runes)-1 || !isWordRune(runes[i-1]) || !isWordRune(runes[i+1]) {
+				out.WriteRune('\\')
+			}
+		case '!':
+			if i+1 < len(runes) && runes[i+1] == '[' {
+				out.WriteRune('\\')
+			}
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func escapeURL(url string) string {
+	url = strings.ReplaceAll(url, " ", "%20")
+	url = strings.ReplaceAll(url, "(", "%28")
+	return strings.ReplaceAll(url, ")", "%29")
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127
+}
+
+func isList(node map[string]interface{}) bool {
+	switch nodeType(node) {
+	case "bulletList", "orderedList", "taskList":
+		return true
+	}
+	return false
+}
+
+func isOrdered(node map[string]interface{}) bool {
+	return nodeType(node) == "orderedList"
+}
+
+// isTextblock reports whether a node holds inline content only
+func isTextblock(node map[string]interface{}) bool {
+	for _, item := range contentOf(node) {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch nodeType(child) {
+		case "text", "hardBreak", "mention", "image":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// plainText concatenates the text inside a node
+func plainText(node map[string]interface{}) string {
+	if text, ok := node["text"].(string); ok {
+		return text
+	}
+	var out strings.Builder
+	for _, item := range contentOf(node) {
+		if child, ok := item.(map[string]interface{}); ok {
+			if nodeType(child) == "hardBreak" {
+				out.WriteString("\n")
+				continue
+			}
+			out.WriteString(plainText(child))
+		}
+	}
+	return out.String()
+}
+
+func nodeType(node map[string]interface{}) string {
+	t, _ := node["type"].(string)
+	return t
+}
+
+func contentOf(node map[string]interface{}) []interface{} {
+	content, _ := node["content"].([]interface{})
+	return content
+}
+
+func attrsOf(node map[string]interface{}) map[string]interface{} {
+	attrs, _ := node["attrs"].(map[string]interface{})
+	return attrs
+}
+
+func stringAttr(node map[string]interface{}, key string) string {
+	value, _ := attrsOf(node)[key].(string)
+	return value
+}
+
+func intAttr(node map[string]interface{}, key string, fallback int) int {
+	switch value := attrsOf(node)[key].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+	return fallback
+}
+
+func boolAttr(node map[string]interface{}, key string) bool {
+	value, _ := attrsOf(node)[key].(bool)
+	return value
+}
+
+func marksOf(node map[string]interface{}) []map[string]interface{} {
+	var marks []map[string]interface{}
+	list, _ := node["marks"].([]interface{})
+	for _, item := range list {
+		if mark, ok := item.(map[string]interface{}); ok {
+			marks = append(marks, mark)
+		}
+	}
+	return marks
+}
+
+func hasMark(marks []map[string]interface{}, markType string) bool {
+	for _, mark := range marks {
+		if mark["type"] == markType {
+			return true
+		}
+	}
+	return false
+}
+
+func sameMarks(a, b []map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if fmt.Sprint(a[i]) != fmt.Sprint(b[i]) {
+			return false
+		}
+	}
+	return true
+}
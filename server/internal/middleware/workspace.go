@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"notesage-server/internal/models"
+	"notesage-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WorkspaceHeader selects the active workspace for routes that are not
+// mounted under /api/workspaces/:workspace_id
+const WorkspaceHeader = "X-Workspace-ID"
+
+// Workspace resolves the active workspace from the :workspace_id path
+// parameter or the X-Workspace-ID header and checks the user belongs to it.
+// It sets "workspaceID" and "workspaceRole" for handlers; without either the
+// request works in the user's personal space. Viewers may only read.
+func Workspace(workspaces *services.WorkspaceService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		raw := c.Param("workspace_id")
+		if raw == "" {
+			raw = c.GetHeader(WorkspaceHeader)
+		}
+		if raw == "" {
+			c.Next()
+			return
+		}
+
+		workspaceID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			c.Abort()
+			return
+		}
+
+		userID, _ := c.Get("userID")
+		userUUID, err := uuid.Parse(userID.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			c.Abort()
+			return
+		}
+
+		member, err := workspaces.Membership(userUUID, workspaceID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspace"})
+			}
+			c.Abort()
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !models.WorkspaceRoleAllows(member.Role, models.WorkspaceRoleMember) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Viewers cannot make changes in this workspace"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("workspaceID", workspaceID)
+		c.Set("workspaceRole", member.Role)
+		c.Next()
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration005Up creates the note revision history table and snapshots existing notes
+func migration005Up(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.NoteRevision{}); err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_note_revisions_note_created ON note_revisions(note_id, created_at DESC)").Error; err != nil {
+			return err
+		}
+
+		// Seed history with the current state of every existing note so that the
+		// first edit after upgrading has something to diff against
+		var notes []models.Note
+		if err := tx.Find(&notes).Error; err != nil {
+			return err
+		}
+		for i := range notes {
+			revision := models.NewNoteRevision(&notes[i], notes[i].UserID, "Initial version")
+			revision.CreatedAt = notes[i].UpdatedAt
+			if err := tx.Create(revision).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migration005Down drops the note revision history table
+func migration005Down(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP INDEX IF EXISTS idx_note_revisions_note_created").Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable(&models.NoteRevision{})
+	})
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration006Up creates the table of persisted edit conflicts
+func migration006Up(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.NoteConflict{}); err != nil {
+			return err
+		}
+		return tx.Exec("CREATE INDEX IF NOT EXISTS idx_note_conflicts_user_status ON note_conflicts(user_id, status)").Error
+	})
+}
+
+// migration006Down drops the edit conflicts table
+func migration006Down(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP INDEX IF EXISTS idx_note_conflicts_user_status").Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable(&models.NoteConflict{})
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration007Up creates the sync change feed and seeds it with every existing
+// entity so that a client syncing from scratch receives a complete snapshot
+func migration007Up(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.SyncChange{}); err != nil {
+			return err
+		}
+
+		backfill := []string{
+			"INSERT INTO sync_changes (user_id, entity_type, entity_id, action, created_at) SELECT user_id, 'note', id, 'create', updated_at FROM notes ORDER BY created_at",
+			"INSERT INTO sync_changes (user_id, entity_type, entity_id, action, created_at) SELECT user_id, 'person', id, 'create', updated_at FROM people ORDER BY created_at",
+			"INSERT INTO sync_changes (user_id, entity_type, entity_id, action, created_at) SELECT notes.user_id, 'todo', todos.id, 'create', todos.updated_at FROM todos JOIN notes ON notes.id = todos.note_id ORDER BY todos.created_at",
+			"INSERT INTO sync_changes (user_id, entity_type, entity_id, action, created_at) SELECT user_id, 'connection', id, 'create', updated_at FROM connections ORDER BY created_at",
+		}
+		for _, query := range backfill {
+			if err := tx.Exec(query).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migration007Down drops the sync change feed
+func migration007Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.SyncChange{})
+}
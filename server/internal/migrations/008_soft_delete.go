@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration008Up adds the deleted_at tombstone column to notes, people and todos
+func migration008Up(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Note{}, &models.Person{}, &models.Todo{}} {
+			if !tx.Migrator().HasColumn(model, "DeletedAt") {
+				if err := tx.Migrator().AddColumn(model, "DeletedAt"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasIndex(model, "DeletedAt") {
+				if err := tx.Migrator().CreateIndex(model, "DeletedAt"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// migration008Down permanently removes everything in the trash and drops the
+// tombstone columns
+func migration008Down(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Todo{}, &models.Person{}, &models.Note{}} {
+			if err := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(model).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(model, "DeletedAt") {
+				if err := tx.Migrator().DropIndex(model, "DeletedAt"); err != nil {
+					return err
+				}
+			}
+			if err := tx.Migrator().DropColumn(model, "DeletedAt"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
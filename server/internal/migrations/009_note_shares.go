@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration009Up creates the table of per-note access grants
+func migration009Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.NoteShare{})
+}
+
+// migration009Down drops the note shares table
+func migration009Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.NoteShare{})
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration010Up creates workspaces and their memberships, and adds the
+// workspace column to the models that belong to one. Existing rows keep a
+// nil workspace and stay in their owner's personal space.
+func migration010Up(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.Workspace{}, &models.WorkspaceMember{}); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Note{}, &models.Person{}, &models.Connection{}} {
+			if !tx.Migrator().HasColumn(model, "WorkspaceID") {
+				if err := tx.Migrator().AddColumn(model, "WorkspaceID"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasIndex(model, "WorkspaceID") {
+				if err := tx.Migrator().CreateIndex(model, "WorkspaceID"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// migration010Down permanently removes workspace content and drops the
+// workspace tables and columns
+func migration010Down(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Connection{}, &models.Person{}, &models.Note{}} {
+			if err := tx.Unscoped().Where("workspace_id IS NOT NULL").Delete(model).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(model, "WorkspaceID") {
+				if err := tx.Migrator().DropIndex(model, "WorkspaceID"); err != nil {
+					return err
+				}
+			}
+			if err := tx.Migrator().DropColumn(model, "WorkspaceID"); err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropTable(&models.WorkspaceMember{}, &models.Workspace{})
+	})
+}
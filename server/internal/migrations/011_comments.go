@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration011Up creates the comment threads and their mentions
+func migration011Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Comment{}, &models.CommentMention{})
+}
+
+// migration011Down drops the comment tables
+func migration011Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.CommentMention{}, &models.Comment{})
+}
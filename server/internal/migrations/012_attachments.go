@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration012Up creates uploaded attachments and their links to notes
+func migration012Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Attachment{}, &models.NoteAttachment{})
+}
+
+// migration012Down drops the attachment tables. Stored files are left in place.
+func migration012Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.NoteAttachment{}, &models.Attachment{})
+}
@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// migration013Up adds a full-text search index over notes on PostgreSQL.
+//
+// notes.search_vector is a stored generated column weighting the title (A),
+// tags (B) and the plain text of the content (C): the text of ProseMirror
+// text nodes and the names in mentions, without JSON keys or node types.
+// Adding a stored generated column computes it for every existing row, so
+// existing notes are indexed by the migration itself. The text search
+// configuration must match postgresSearchConfig in the services package.
+func migration013Up(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	statements := []string{
+		// Generated columns may only call immutable functions; these only read
+		// their arguments
+		`CREATE OR REPLACE FUNCTION note_search_text(content text) RETURNS text
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+			SELECT coalesce(string_agg(value #>> '{}', ' '), '')
+			FROM jsonb_array_elements(
+				jsonb_path_query_array(NULLIF(content, '')::jsonb, 'strict $.** ? (@.type == "text").text', '{}', true) ||
+				jsonb_path_query_array(NULLIF(content, '')::jsonb, 'strict $.** ? (@.type == "mention").attrs.name', '{}', true)
+			)
+		$$`,
+		`CREATE OR REPLACE FUNCTION note_search_vector(title text, tags text[], content text) RETURNS tsvector
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+			SELECT setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(array_to_string(tags, ' '), '')), 'B') ||
+				setweight(to_tsvector('english', note_search_text(content)), 'C')
+		$$`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (note_search_vector(title, tags, content)) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration013Down removes the full-text search index
+func migration013Down(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	statements := []string{
+		`DROP INDEX IF EXISTS idx_notes_search_vector`,
+		`ALTER TABLE notes DROP COLUMN IF EXISTS search_vector`,
+		`DROP FUNCTION IF EXISTS note_search_vector(text, text[], text)`,
+		`DROP FUNCTION IF EXISTS note_search_text(text)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
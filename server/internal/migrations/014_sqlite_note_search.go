@@ -0,0 +1,155 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// migration014Up adds a full-text search index over notes on SQLite.
+//
+// Triggers on notes keep note_search, a plain table holding each note's
+// title, tags, the text of its ProseMirror text nodes and the names of the
+// people it mentions, in step with every write. notes_fts is an FTS5 index
+// over note_search, kept in step by triggers of its own. FTS5 is only compiled
+// into builds made with the sqlite_fts5 tag; without it note_search is still
+// maintained and EnsureNoteSearchIndex adds the index once the server runs on
+// a build that has it.
+func migration014Up(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS note_search (
+			id INTEGER PRIMARY KEY,
+			note_id TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			people TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS notes_search_insert AFTER INSERT ON notes BEGIN
+			INSERT INTO note_search (note_id, title, body, tags, people)
+			VALUES (new.id, coalesce(new.title, ''), ` + sqliteNoteBody("new.content") + `, coalesce(new.tags, ''), ` + sqliteNotePeople("new.content") + `);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS notes_search_update AFTER UPDATE OF title, content, tags ON notes BEGIN
+			UPDATE note_search SET
+				title = coalesce(new.title, ''),
+				body = ` + sqliteNoteBody("new.content") + `,
+				tags = coalesce(new.tags, ''),
+				people = ` + sqliteNotePeople("new.content") + `
+			WHERE note_id = new.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS notes_search_delete AFTER DELETE ON notes BEGIN
+			DELETE FROM note_search WHERE note_id = old.id;
+		END`,
+		`INSERT OR IGNORE INTO note_search (note_id, title, body, tags, people)
+			SELECT id, coalesce(title, ''), ` + sqliteNoteBody("content") + `, coalesce(tags, ''), ` + sqliteNotePeople("content") + `
+			FROM notes`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return EnsureNoteSearchIndex(db)
+}
+
+// migration014Down removes the SQLite search index and its triggers
+func migration014Down(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+
+	statements := []string{
+		`DROP TRIGGER IF EXISTS notes_search_insert`,
+		`DROP TRIGGER IF EXISTS notes_search_update`,
+		`DROP TRIGGER IF EXISTS notes_search_delete`,
+		`DROP TRIGGER IF EXISTS note_search_fts_insert`,
+		`DROP TRIGGER IF EXISTS note_search_fts_update`,
+		`DROP TRIGGER IF EXISTS note_search_fts_delete`,
+		`DROP TABLE IF EXISTS notes_fts`,
+		`DROP TABLE IF EXISTS note_search`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureNoteSearchIndex creates the notes_fts index on a SQLite database
+// that has note_search but no index yet, when this build includes FTS5, and
+// fills it from note_search. It does nothing on other databases.
+func EnsureNoteSearchIndex(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	if !db.Migrator().HasTable("note_search") || db.Migrator().HasTable("notes_fts") {
+		return nil
+	}
+
+	var fts5 int
+	if err := db.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5).Error; err != nil {
+		return err
+	}
+	if fts5 != 1 {
+		log.Printf("SQLite was built without FTS5, so note search scans every note; build with -tags sqlite_fts5 to index notes")
+		return nil
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE notes_fts USING fts5(
+			title, body, tags, people,
+			content='note_search', content_rowid='id',
+			tokenize='porter unicode61 remove_diacritics 2', prefix='2 3'
+		)`,
+		`CREATE TRIGGER note_search_fts_insert AFTER INSERT ON note_search BEGIN
+			INSERT INTO notes_fts (rowid, title, body, tags, people)
+			VALUES (new.id, new.title, new.body, new.tags, new.people);
+		END`,
+		`CREATE TRIGGER note_search_fts_update AFTER UPDATE ON note_search BEGIN
+			INSERT INTO notes_fts (notes_fts, rowid, title, body, tags, people)
+			VALUES ('delete', old.id, old.title, old.body, old.tags, old.people);
+			INSERT INTO notes_fts (rowid, title, body, tags, people)
+			VALUES (new.id, new.title, new.body, new.tags, new.people);
+		END`,
+		`CREATE TRIGGER note_search_fts_delete AFTER DELETE ON note_search BEGIN
+			INSERT INTO notes_fts (notes_fts, rowid, title, body, tags, people)
+			VALUES ('delete', old.id, old.title, old.body, old.tags, old.people);
+		END`,
+		`INSERT INTO notes_fts (notes_fts) VALUES ('rebuild')`,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return fmt.Errorf("failed to create note search index: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// sqliteNoteBody is the SQL for the text of the ProseMirror text nodes in a
+// content column, in document order
+func sqliteNoteBody(column string) string {
+	return strings.ReplaceAll(`coalesce((
+		SELECT group_concat(t.value, ' ') FROM json_tree(CASE WHEN json_valid(COLUMN) THEN COLUMN ELSE '{}' END) AS t
+		WHERE t.key = 'text' AND t.type = 'text' AND json_extract(t.json, t.path || '.type') = 'text'
+	), '')`, "COLUMN", column)
+}
+
+// sqliteNotePeople is the SQL for the names in the mention nodes of a
+// content column
+func sqliteNotePeople(column string) string {
+	return strings.ReplaceAll(`coalesce((
+		SELECT group_concat(t.value, ' ') FROM json_tree(CASE WHEN json_valid(COLUMN) THEN COLUMN ELSE '{}' END) AS t
+		WHERE t.key = 'name' AND t.type = 'text' AND t.path LIKE '%.attrs'
+			AND json_extract(t.json, substr(t.path, 1, length(t.path) - 6) || '.type') = 'mention'
+	), '')`, "COLUMN", column)
+}
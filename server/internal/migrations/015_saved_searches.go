@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration015Up creates saved searches
+func migration015Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.SavedSearch{})
+}
+
+// migration015Down drops saved searches
+func migration015Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.SavedSearch{})
+}
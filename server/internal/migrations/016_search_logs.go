@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration016Up creates the search log behind search analytics
+func migration016Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.SearchLog{})
+}
+
+// migration016Down drops the search log
+func migration016Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.SearchLog{})
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration017Up creates the note access log
+func migration017Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.NoteAccess{})
+}
+
+// migration017Down drops the note access log
+func migration017Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.NoteAccess{})
+}
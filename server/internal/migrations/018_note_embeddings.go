@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration018Up creates the vectors behind semantic search
+func migration018Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.NoteEmbedding{})
+}
+
+// migration018Down drops the vectors behind semantic search
+func migration018Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.NoteEmbedding{})
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration019Up creates the chat sessions of questions asked about notes
+func migration019Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.ChatSession{}, &models.ChatMessage{})
+}
+
+// migration019Down drops the chat sessions
+func migration019Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.ChatMessage{}, &models.ChatSession{})
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"notesage-server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// migration020Up creates the log of AI provider calls behind usage reports
+// and quotas
+func migration020Up(db *gorm.DB) error {
+	return db.AutoMigrate(&models.AIUsage{})
+}
+
+// migration020Down drops the AI usage log
+func migration020Down(db *gorm.DB) error {
+	return db.Migrator().DropTable(&models.AIUsage{})
+}
@@ -5,6 +5,7 @@ import (
 	"sort"
 	"time"
 
+	"notesage-server/internal/backup"
 	"notesage-server/internal/models"
 
 	"gorm.io/gorm"
@@ -26,6 +27,7 @@ type Migrator struct {
 	db         *gorm.DB
 	migrations []MigrationEntry
 	logger     Logger
+	backups    *backup.Manager
 }
 
 // Logger interface for migration logging
@@ -68,6 +70,13 @@ func NewMigratorWithLogger(db *gorm.DB, logger Logger) *Migrator {
 	}
 }
 
+// WithBackups makes Migrate take a backup before it applies migrations to a
+// database that already has some
+func (m *Migrator) WithBackups(backups *backup.Manager) *Migrator {
+	m.backups = backups
+	return m
+}
+
 // Migrate runs all pending migrations
 func (m *Migrator) Migrate() error {
 	// Ensure migrations table exists
@@ -91,6 +100,22 @@ func (m *Migrator) Migrate() error {
 		return m.migrations[i].Version < m.migrations[j].Version
 	})
 
+	pending := 0
+	for _, migration := range m.migrations {
+		if !appliedVersions[migration.Version] {
+			pending++
+		}
+	}
+
+	// Snapshot existing data so a failed or unwanted upgrade can be undone
+	if m.backups != nil && len(appliedMigrations) > 0 && pending > 0 {
+		info, err := m.backups.Create(backup.LabelPreMigration)
+		if err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		m.logger.Info("Backed up database to %s", info.Path)
+	}
+
 	// Run pending migrations
 	for _, migration := range m.migrations {
 		if !appliedVersions[migration.Version] {
@@ -364,19 +389,17 @@ func (m *Migrator) ValidateMigrations() error {
 	return nil
 }
 
-// CreateBackup creates a database backup before running migrations
+// CreateBackup writes a compressed, checksummed backup of the database to
+// backupPath, described by backupPath.json
 func (m *Migrator) CreateBackup(backupPath string) error {
 	m.logger.Info("Creating database backup at %s", backupPath)
-	
-	// This would typically use pg_dump or similar
-	// For now, we'll create a simple backup of the migrations table
-	var migrations []models.Migration
-	if err := m.db.Find(&migrations).Error; err != nil {
-		return fmt.Errorf("failed to read migrations for backup: %w", err)
+
+	info, err := backup.Write(m.db, backupPath, backup.LabelManual)
+	if err != nil {
+		return err
 	}
-	
-	// In a real implementation, you would use pg_dump here
-	m.logger.Info("Database backup created successfully")
+
+	m.logger.Info("Database backup created successfully (%d bytes, sha256 %s)", info.Size, info.SHA256)
 	return nil
 }
 
@@ -507,5 +530,101 @@ func getAllMigrations() []MigrationEntry {
 			Up:      migration004Up,
 			Down:    migration004Down,
 		},
+		{
+			Version: "005",
+			Name:    "Add note revision history",
+			Up:      migration005Up,
+			Down:    migration005Down,
+		},
+		{
+			Version: "006",
+			Name:    "Add note conflicts",
+			Up:      migration006Up,
+			Down:    migration006Down,
+		},
+		{
+			Version: "007",
+			Name:    "Add sync change feed",
+			Up:      migration007Up,
+			Down:    migration007Down,
+		},
+		{
+			Version: "008",
+			Name:    "Add soft delete",
+			Up:      migration008Up,
+			Down:    migration008Down,
+		},
+		{
+			Version: "009",
+			Name:    "Add note shares",
+			Up:      migration009Up,
+			Down:    migration009Down,
+		},
+		{
+			Version: "010",
+			Name:    "Add workspaces",
+			Up:      migration010Up,
+			Down:    migration010Down,
+		},
+		{
+			Version: "011",
+			Name:    "Add comments",
+			Up:      migration011Up,
+			Down:    migration011Down,
+		},
+		{
+			Version: "012",
+			Name:    "Add attachments",
+			Up:      migration012Up,
+			Down:    migration012Down,
+		},
+		{
+			Version: "013",
+			Name:    "Add note full-text search index",
+			Up:      migration013Up,
+			Down:    migration013Down,
+		},
+		{
+			Version: "014",
+			Name:    "Add SQLite note full-text search index",
+			Up:      migration014Up,
+			Down:    migration014Down,
+		},
+		{
+			Version: "015",
+			Name:    "Add saved searches",
+			Up:      migration015Up,
+			Down:    migration015Down,
+		},
+		{
+			Version: "016",
+			Name:    "Add search logs",
+			Up:      migration016Up,
+			Down:    migration016Down,
+		},
+		{
+			Version: "017",
+			Name:    "Add note access log",
+			Up:      migration017Up,
+			Down:    migration017Down,
+		},
+		{
+			Version: "018",
+			Name:    "Add note embeddings",
+			Up:      migration018Up,
+			Down:    migration018Down,
+		},
+		{
+			Version: "019",
+			Name:    "Add chat sessions",
+			Up:      migration019Up,
+			Down:    migration019Down,
+		},
+		{
+			Version: "020",
+			Name:    "Add AI usage",
+			Up:      migration020Up,
+			Down:    migration020Down,
+		},
 	}
 }
\ No newline at end of file
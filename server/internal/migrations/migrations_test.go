@@ -1,8 +1,10 @@
 package migrations
 
 import (
+	"path/filepath"
 	"testing"
 
+	"notesage-server/internal/backup"
 	"notesage-server/internal/models"
 
 	"github.com/stretchr/testify/assert"
@@ -226,4 +228,34 @@ func TestMigration003(t *testing.T) {
 	// Test rollback
 	err = migration003Down(db)
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+func TestMigrator_MigrateBacksUpExistingData(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notesage.db")), &gorm.Config{})
+	require.NoError(t, err)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+	backups := backup.NewManager(db, t.TempDir(), 0, 0)
+
+	// A new database has nothing worth backing up
+	partial := NewMigrator(db).WithBackups(backups)
+	partial.migrations = partial.migrations[:3]
+	require.NoError(t, partial.Migrate())
+	list, err := backups.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	require.NoError(t, NewMigrator(db).WithBackups(backups).Migrate())
+	list, err = backups.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, backup.LabelPreMigration, list[0].Label)
+	assert.Equal(t, "003", list[0].SchemaVersion)
+
+	// Nothing pending, no backup
+	require.NoError(t, NewMigrator(db).WithBackups(backups).Migrate())
+	list, err = backups.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AIUsage records one call to an AI provider, for usage reports and quotas.
+// UserID is nil for calls made on nobody's behalf. Tokens are the provider's
+// counts, or estimated from the text when Estimated is set, and CostUSD is
+// estimated from the model's price. Failed calls keep their ErrorMessage.
+type AIUsage struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID           *uuid.UUID `gorm:"type:uuid;index:idx_ai_usage_user_created,priority:1" json:"user_id"`
+	Feature          string     `gorm:"size:50;not null;index" json:"feature"`
+	Provider         string     `gorm:"size:50;not null" json:"provider"`
+	Model            string     `gorm:"size:255;not null" json:"model"`
+	PromptTokens     int64      `gorm:"not null;default:0" json:"prompt_tokens"`
+	CompletionTokens int64      `gorm:"not null;default:0" json:"completion_tokens"`
+	TotalTokens      int64      `gorm:"not null;default:0" json:"total_tokens"`
+	Estimated        bool       `gorm:"not null;default:false" json:"estimated"`
+	LatencyMs        float64    `gorm:"not null;default:0" json:"latency_ms"`
+	CostUSD          float64    `gorm:"not null;default:0" json:"cost_usd"`
+	ErrorMessage     string     `gorm:"type:text" json:"error_message,omitempty"`
+	CreatedAt        time.Time  `gorm:"index;index:idx_ai_usage_user_created,priority:2" json:"created_at"`
+
+	// Relationships
+	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (AIUsage) TableName() string {
+	return "ai_usage"
+}
+
+func (u *AIUsage) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attachment is an uploaded file. Uploads with the same content share one
+// stored blob, keyed by their SHA-256 hash.
+type Attachment struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
+	Filename    string     `gorm:"not null;size:255" json:"filename"`
+	ContentType string     `gorm:"not null;size:255" json:"content_type"`
+	Size        int64      `gorm:"not null" json:"size"`
+	SHA256      string     `gorm:"column:sha256;not null;size:64;index" json:"sha256"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Attachment) TableName() string {
+	return "attachments"
+}
+
+func (a *Attachment) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+func (a *Attachment) Validate() error {
+	if a.Filename == "" {
+		return errors.New("filename is required")
+	}
+	if len(a.Filename) > 255 {
+		return errors.New("filename must be 255 characters or less")
+	}
+	if len(a.SHA256) != 64 {
+		return errors.New("sha256 must be a hex-encoded hash")
+	}
+	return nil
+}
+
+// NoteAttachment links an attachment to a note that uses it. Attachments
+// without links are removed by the cleanup job.
+type NoteAttachment struct {
+	NoteID       uuid.UUID `gorm:"type:uuid;primaryKey" json:"note_id"`
+	AttachmentID uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"attachment_id"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Relationships
+	Note       Note       `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+	Attachment Attachment `gorm:"foreignKey:AttachmentID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (NoteAttachment) TableName() string {
+	return "note_attachments"
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Roles of chat messages
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// ChatSession is a conversation in which a user asks questions about their
+// notes. Follow-up questions are answered with the earlier turns in mind.
+type ChatSession struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
+	Title       string     `gorm:"not null;size:255" json:"title"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"index" json:"updated_at"`
+
+	// Relationships
+	User     User          `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Messages []ChatMessage `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE" json:"messages,omitempty"`
+}
+
+func (ChatSession) TableName() string {
+	return "chat_sessions"
+}
+
+func (s *ChatSession) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// ChatMessage is a question or an answer in a chat session. Answers cite the
+// passages of notes they draw on.
+type ChatMessage struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index" json:"session_id"`
+	Role      string    `gorm:"not null;size:20" json:"role"`
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	Citations Citations `gorm:"type:text" json:"citations,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (ChatMessage) TableName() string {
+	return "chat_messages"
+}
+
+func (m *ChatMessage) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// Citation points from an answer to the passage of a note it draws on. Source
+// is the number the answer cites it by, as in [1]. Start and End are the
+// offsets of Snippet in the note's text, in characters.
+type Citation struct {
+	Source  int       `json:"source"`
+	NoteID  uuid.UUID `json:"note_id"`
+	Title   string    `json:"title"`
+	Snippet string    `json:"snippet"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+}
+
+// Citations is stored as a JSON array
+type Citations []Citation
+
+// Value implements the driver.Valuer interface for Citations
+func (c Citations) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements the sql.Scanner interface for Citations
+func (c *Citations) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, c)
+	case string:
+		return json.Unmarshal([]byte(data), c)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+}
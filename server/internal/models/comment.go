@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of things a comment can mention
+const (
+	MentionTypeUser   = "user"
+	MentionTypePerson = "person"
+)
+
+// anchorPathPattern matches a node path such as "0" or "2.0.1": the child
+// indexes to follow from the root of a note's content
+var anchorPathPattern = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// Comment is a remark on a note. Comments without a ParentID start a thread
+// anchored to a range of text; replies inherit the anchor and resolved state
+// of their thread.
+type Comment struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	NoteID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"note_id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ParentID   *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"`
+	Body       string     `gorm:"type:text;not null" json:"body"`
+	AnchorPath string     `gorm:"size:255" json:"anchor_path"` // node path in the note content, e.g. "0.2"
+	AnchorFrom int        `json:"anchor_from"`                 // text offset within the node where the range starts
+	AnchorTo   int        `json:"anchor_to"`                   // text offset within the node where the range ends
+	QuotedText string     `gorm:"type:text" json:"quoted_text"`
+	ResolvedAt *time.Time `gorm:"index" json:"resolved_at"`
+	ResolvedBy *uuid.UUID `gorm:"type:uuid" json:"resolved_by"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// Relationships
+	Note     Note             `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+	User     User             `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	Mentions []CommentMention `gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE" json:"mentions,omitempty"`
+	Replies  []Comment        `gorm:"foreignKey:ParentID" json:"replies,omitempty"`
+}
+
+func (Comment) TableName() string {
+	return "comments"
+}
+
+func (c *Comment) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+func (c *Comment) Validate() error {
+	if c.NoteID == uuid.Nil {
+		return errors.New("note_id is required")
+	}
+	if strings.TrimSpace(c.Body) == "" {
+		return errors.New("body is required")
+	}
+	if len(c.Body) > 10000 {
+		return errors.New("body must be 10000 characters or less")
+	}
+	if c.ParentID != nil {
+		return nil
+	}
+	if !anchorPathPattern.MatchString(c.AnchorPath) {
+		return errors.New("anchor path must be dot-separated node indexes, e.g. 0.2")
+	}
+	if c.AnchorFrom < 0 || c.AnchorTo < c.AnchorFrom {
+		return errors.New("anchor range must satisfy 0 <= from <= to")
+	}
+	return nil
+}
+
+// IsResolved reports whether the comment's thread has been resolved
+func (c *Comment) IsResolved() bool {
+	return c.ResolvedAt != nil
+}
+
+// CommentMention records a user or person mentioned in a comment
+type CommentMention struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	CommentID   uuid.UUID `gorm:"type:uuid;not null;index" json:"comment_id"`
+	MentionType string    `gorm:"type:varchar(20);not null" json:"mention_type"` // "user", "person"
+	MentionedID uuid.UUID `gorm:"type:uuid;not null;index" json:"mentioned_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (CommentMention) TableName() string {
+	return "comment_mentions"
+}
+
+func (m *CommentMention) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Conflict statuses
+const (
+	ConflictStatusOpen     = "open"
+	ConflictStatusResolved = "resolved"
+)
+
+// Conflict resolutions
+const (
+	ConflictResolutionMine   = "mine"   // keep the content the client tried to save
+	ConflictResolutionTheirs = "theirs" // keep the note as it is on the server
+	ConflictResolutionMerge  = "merge"  // use a merged document, or merge automatically
+)
+
+// NoteConflict records an edit that was rejected because the note had moved on.
+// LocalVersion is the version the client edited, i.e. the common ancestor of both sides.
+type NoteConflict struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	NoteID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"note_id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	LocalVersion    int        `gorm:"not null" json:"local_version"`
+	RemoteVersion   int        `gorm:"not null" json:"remote_version"`
+	LocalContent    JSONB      `gorm:"type:text" json:"local_content"`
+	RemoteContent   JSONB      `gorm:"type:text" json:"remote_content"`
+	Status          string     `gorm:"type:varchar(20);default:'open';not null;index" json:"status"`
+	Resolution      string     `gorm:"type:varchar(20)" json:"resolution,omitempty"`
+	ResolvedVersion *int       `json:"resolved_version,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt       time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// Relationships
+	Note Note `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (NoteConflict) TableName() string {
+	return "note_conflicts"
+}
+
+func (c *NoteConflict) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	if c.Status == "" {
+		c.Status = ConflictStatusOpen
+	}
+	return nil
+}
+
+func (c *NoteConflict) Validate() error {
+	if c.NoteID == uuid.Nil {
+		return errors.New("note_id is required")
+	}
+	if c.UserID == uuid.Nil {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
@@ -67,6 +67,7 @@ type User struct {
 type Note struct {
 	ID            uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
 	UserID        uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID   *uuid.UUID     `gorm:"type:uuid;index" json:"workspace_id"`
 	Title         string         `gorm:"not null;size:500" json:"title"`
 	Content       JSONB          `gorm:"type:text" json:"content"`
 	Category      string         `gorm:"default:'Note';size:100;index" json:"category"`
@@ -79,6 +80,7 @@ type Note struct {
 	CreatedAt     time.Time      `gorm:"index" json:"created_at"`
 	UpdatedAt     time.Time      `gorm:"index" json:"updated_at"`
 	Version       int            `gorm:"default:1" json:"version"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 	
 	// Relationships
 	User  User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
@@ -89,6 +91,7 @@ type Note struct {
 type Person struct {
 	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
 	UserID      uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
 	Name        string    `gorm:"not null;size:255;index" json:"name"`
 	Email       string    `gorm:"size:255;index" json:"email"`
 	Phone       string    `gorm:"size:50" json:"phone"`
@@ -99,13 +102,15 @@ type Person struct {
 	Notes       string    `gorm:"type:text" json:"notes"`
 	CreatedAt   time.Time `gorm:"index" json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 	
 	// Relationships
 	User          User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
 	AssignedTodos []Todo `gorm:"foreignKey:AssignedPersonID" json:"assigned_todos,omitempty"`
 }
 
-// Todo represents a todo item with unique ID per note
+// Todo represents a todo item with unique ID per note. Todos belong to the
+// workspace of their note.
 type Todo struct {
 	ID               uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
 	NoteID           uuid.UUID  `gorm:"type:uuid;not null;index" json:"note_id"`
@@ -116,6 +121,7 @@ type Todo struct {
 	DueDate          *time.Time `gorm:"index" json:"due_date"`
 	CreatedAt        time.Time  `gorm:"index" json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 	
 	// Relationships
 	Note           Note    `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"note,omitempty"`
@@ -126,6 +132,7 @@ type Todo struct {
 type Connection struct {
 	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
 	UserID     uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
 	SourceID   uuid.UUID `gorm:"type:uuid;not null;index" json:"source_id"`
 	SourceType string    `gorm:"not null;size:20;index" json:"source_type"` // "note", "person"
 	TargetID   uuid.UUID `gorm:"type:uuid;not null;index" json:"target_id"`
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// How a note was accessed
+const (
+	NoteAccessOpen        = "open"        // fetched through the API
+	NoteAccessCollaborate = "collaborate" // joined for live editing
+)
+
+// NoteAccess records a user opening a note. Recently viewed and frequently
+// used notes, and the quick switcher's ranking, come from these records.
+type NoteAccess struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index:idx_note_access_user_note,priority:1" json:"user_id"`
+	NoteID     uuid.UUID `gorm:"type:uuid;not null;index:idx_note_access_user_note,priority:2;index" json:"note_id"`
+	Source     string    `gorm:"not null;size:20" json:"source"`
+	AccessedAt time.Time `gorm:"not null;index:idx_note_access_user_note,priority:3" json:"accessed_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Note Note `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (NoteAccess) TableName() string {
+	return "note_access"
+}
+
+func (a *NoteAccess) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	if a.AccessedAt.IsZero() {
+		a.AccessedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NoteEmbedding is the vector of one chunk of a note's text, for semantic
+// search. Vector holds little-endian float32s scaled to unit length. Hash is
+// the SHA-256 of the chunk's text, so unchanged chunks are not embedded again.
+// IndexedAt is when the note was last embedded; notes updated since are
+// embedded again.
+type NoteEmbedding struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	NoteID     uuid.UUID `gorm:"type:uuid;not null;index:idx_note_embeddings_note_model,priority:1" json:"note_id"`
+	Model      string    `gorm:"not null;size:100;index:idx_note_embeddings_note_model,priority:2" json:"model"`
+	ChunkIndex int       `gorm:"not null" json:"chunk_index"`
+	Content    string    `gorm:"type:text;not null" json:"content"`
+	Hash       string    `gorm:"not null;size:64" json:"hash"`
+	Vector     []byte    `json:"-"`
+	IndexedAt  time.Time `gorm:"not null" json:"indexed_at"`
+
+	// Relationships
+	Note Note `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (NoteEmbedding) TableName() string {
+	return "note_embeddings"
+}
+
+func (e *NoteEmbedding) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// NoteRevision is an immutable snapshot of a note taken every time its version changes
+type NoteRevision struct {
+	ID                uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
+	NoteID            uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_note_revisions_note_version" json:"note_id"`
+	Version           int            `gorm:"not null;uniqueIndex:idx_note_revisions_note_version" json:"version"`
+	AuthorID          uuid.UUID      `gorm:"type:uuid;not null;index" json:"author_id"`
+	Title             string         `gorm:"not null;size:500" json:"title"`
+	Content           JSONB          `gorm:"type:text" json:"content"`
+	Category          string         `gorm:"size:100" json:"category"`
+	Tags              pq.StringArray `gorm:"type:text[]" json:"tags"`
+	FolderPath        string         `gorm:"size:1000" json:"folder_path"`
+	ChangeDescription string         `gorm:"size:500" json:"change_description"`
+	CreatedAt         time.Time      `gorm:"index" json:"created_at"`
+
+	// Relationships
+	Note Note `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (NoteRevision) TableName() string {
+	return "note_revisions"
+}
+
+func (r *NoteRevision) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *NoteRevision) Validate() error {
+	if r.NoteID == uuid.Nil {
+		return errors.New("note_id is required")
+	}
+	if r.Version < 1 {
+		return errors.New("version must be positive")
+	}
+	return nil
+}
+
+// NewNoteRevision snapshots the current state of a note
+func NewNoteRevision(note *Note, authorID uuid.UUID, changeDescription string) *NoteRevision {
+	return &NoteRevision{
+		NoteID:            note.ID,
+		Version:           note.Version,
+		AuthorID:          authorID,
+		Title:             note.Title,
+		Content:           note.Content,
+		Category:          note.Category,
+		Tags:              note.Tags,
+		FolderPath:        note.FolderPath,
+		ChangeDescription: changeDescription,
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SavedSearch is a search a user keeps to run again or browse as a virtual
+// folder. Query is in the search query language; Filters holds the other
+// fields of a search request, such as categories, tags and is_pinned.
+type SavedSearch struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
+	Name        string     `gorm:"not null;size:255" json:"name"`
+	Icon        string     `gorm:"size:50" json:"icon"`
+	Query       string     `gorm:"type:text" json:"query"`
+	Filters     JSONB      `gorm:"type:text" json:"filters"`
+	Notify      bool       `gorm:"default:false" json:"notify"` // announce notes that start matching over the WebSocket
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (SavedSearch) TableName() string {
+	return "saved_searches"
+}
+
+func (s *SavedSearch) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *SavedSearch) Validate() error {
+	if s.UserID == uuid.Nil {
+		return errors.New("user_id is required")
+	}
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(s.Name) > 255 {
+		return errors.New("name must be 255 characters or less")
+	}
+	if len(s.Icon) > 50 {
+		return errors.New("icon must be 50 characters or less")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SearchLog records one search a user made, for search analytics. Normalized
+// is the query lowercased with its spaces collapsed, so that the same search
+// typed differently is counted together.
+type SearchLog struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index:idx_search_logs_user_created,priority:1" json:"user_id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid;index" json:"workspace_id"`
+	Query       string     `gorm:"type:text;not null" json:"query"`
+	Normalized  string     `gorm:"type:text;not null;index" json:"normalized"`
+	ResultCount int64      `gorm:"not null;default:0" json:"result_count"`
+	LatencyMs   float64    `gorm:"not null;default:0" json:"latency_ms"`
+	CreatedAt   time.Time  `gorm:"index:idx_search_logs_user_created,priority:2" json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (SearchLog) TableName() string {
+	return "search_logs"
+}
+
+func (l *SearchLog) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
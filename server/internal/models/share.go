@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Note access roles, from least to most privileged. Owner is implied by
+// Note.UserID and is never stored on a share.
+const (
+	NoteRoleViewer    = "viewer"    // read the note and its history
+	NoteRoleCommenter = "commenter" // read and comment
+	NoteRoleEditor    = "editor"    // read, comment and edit
+	NoteRoleOwner     = "owner"     // everything, including sharing and deleting
+)
+
+var noteRoleRank = map[string]int{
+	NoteRoleViewer:    1,
+	NoteRoleCommenter: 2,
+	NoteRoleEditor:    3,
+	NoteRoleOwner:     4,
+}
+
+// NoteRoleAllows reports whether role grants at least the access of required
+func NoteRoleAllows(role, required string) bool {
+	rank, ok := noteRoleRank[role]
+	return ok && rank >= noteRoleRank[required]
+}
+
+// NoteShare grants another user access to a note
+type NoteShare struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	NoteID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_note_shares_note_user" json:"note_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_note_shares_note_user;index" json:"user_id"`
+	Role      string    `gorm:"type:varchar(20);not null" json:"role"`
+	GrantedBy uuid.UUID `gorm:"type:uuid;not null" json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Note Note `gorm:"foreignKey:NoteID;constraint:OnDelete:CASCADE" json:"-"`
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
+
+func (NoteShare) TableName() string {
+	return "note_shares"
+}
+
+func (s *NoteShare) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *NoteShare) Validate() error {
+	if s.NoteID == uuid.Nil {
+		return errors.New("note_id is required")
+	}
+	if s.UserID == uuid.Nil {
+		return errors.New("user_id is required")
+	}
+	switch s.Role {
+	case NoteRoleViewer, NoteRoleCommenter, NoteRoleEditor:
+	default:
+		return errors.New("role must be one of: viewer, commenter, editor")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Entity types tracked by the sync change feed
+const (
+	SyncEntityNote       = "note"
+	SyncEntityPerson     = "person"
+	SyncEntityTodo       = "todo"
+	SyncEntityConnection = "connection"
+)
+
+// Sync change actions
+const (
+	SyncActionCreate = "create"
+	SyncActionUpdate = "update"
+	SyncActionDelete = "delete"
+)
+
+// SyncChange is one entry in a user's change feed. Seq only ever grows, so a
+// client can resume from the last sequence number it has seen.
+type SyncChange struct {
+	Seq        int64     `gorm:"primaryKey;autoIncrement;index:idx_sync_changes_user_seq,priority:2" json:"seq"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index:idx_sync_changes_user_seq,priority:1" json:"user_id"`
+	EntityType string    `gorm:"not null;size:20" json:"entity_type"`
+	EntityID   uuid.UUID `gorm:"type:uuid;not null;index" json:"entity_id"`
+	Action     string    `gorm:"not null;size:20" json:"action"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (SyncChange) TableName() string {
+	return "sync_changes"
+}
+
+func (c *SyncChange) Validate() error {
+	if c.UserID == uuid.Nil {
+		return errors.New("user_id is required")
+	}
+	if c.EntityID == uuid.Nil {
+		return errors.New("entity_id is required")
+	}
+	switch c.EntityType {
+	case SyncEntityNote, SyncEntityPerson, SyncEntityTodo, SyncEntityConnection:
+	default:
+		return errors.New("invalid entity_type")
+	}
+	switch c.Action {
+	case SyncActionCreate, SyncActionUpdate, SyncActionDelete:
+	default:
+		return errors.New("invalid action")
+	}
+	return nil
+}
@@ -8,14 +8,21 @@ import (
 
 // WebSocket message types
 const (
-	MessageTypeJoinRoom      = "join_room"
-	MessageTypeLeaveRoom     = "leave_room"
-	MessageTypeNoteUpdate    = "note_update"
-	MessageTypeCursorUpdate  = "cursor_update"
-	MessageTypePresence      = "presence"
-	MessageTypeConflict      = "conflict"
-	MessageTypeError         = "error"
-	MessageTypeAck           = "ack"
+	MessageTypeJoinRoom         = "join_room"
+	MessageTypeLeaveRoom        = "leave_room"
+	MessageTypeNoteUpdate       = "note_update"
+	MessageTypeCursorUpdate     = "cursor_update"
+	MessageTypePresence         = "presence"
+	MessageTypeConflict         = "conflict"
+	MessageTypeResolveConflict  = "resolve_conflict"
+	MessageTypeComment          = "comment"
+	MessageTypeSavedSearchMatch = "saved_search_match"
+	MessageTypeAIAsk            = "ai_ask"
+	MessageTypeAIChunk          = "ai_chunk"
+	MessageTypeAIDone           = "ai_done"
+	MessageTypeAICancel         = "ai_cancel"
+	MessageTypeError            = "error"
+	MessageTypeAck              = "ack"
 )
 
 // WebSocketMessage represents a WebSocket message
@@ -43,6 +50,22 @@ type NoteUpdateData struct {
 	Position  int       `json:"position,omitempty"`
 	Length    int       `json:"length,omitempty"`
 	Text      string    `json:"text,omitempty"`
+	Mark      JSONB     `json:"mark,omitempty"`  // mark applied by "format", e.g. {"type": "bold"}
+	Unset     bool      `json:"unset,omitempty"` // "format" removes the mark instead of adding it
+
+	// Operations is set by the server on broadcasts and acks: the incoming
+	// operation after it was transformed against concurrent edits
+	Operations []TextOperation `json:"operations,omitempty"`
+}
+
+// TextOperation is a single edit in ProseMirror position space
+type TextOperation struct {
+	Type     string `json:"type"` // "insert", "delete", "format"
+	Position int    `json:"position"`
+	Length   int    `json:"length,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Mark     JSONB  `json:"mark,omitempty"`
+	Unset    bool   `json:"unset,omitempty"`
 }
 
 // CursorUpdateData represents cursor position updates
@@ -72,6 +95,55 @@ type ConflictData struct {
 	RemoteContent JSONB     `json:"remote_content"`
 }
 
+// ResolveConflictData represents a request to resolve a recorded conflict
+type ResolveConflictData struct {
+	NoteID     uuid.UUID `json:"note_id"`
+	ConflictID uuid.UUID `json:"conflict_id"`
+	Resolution string    `json:"resolution"` // "mine", "theirs" or "merge"
+	Content    JSONB     `json:"content,omitempty"`
+}
+
+// Comment events broadcast to a note's room
+const (
+	CommentActionCreated  = "created"
+	CommentActionUpdated  = "updated"
+	CommentActionResolved = "resolved"
+	CommentActionReopened = "reopened"
+	CommentActionDeleted  = "deleted"
+)
+
+// CommentData announces a comment change to the collaborators on a note. For
+// deletions Comment holds the removed comment without its replies.
+type CommentData struct {
+	NoteID  uuid.UUID `json:"note_id"`
+	Action  string    `json:"action"` // "created", "updated", "resolved", "reopened", "deleted"
+	Comment *Comment  `json:"comment"`
+}
+
+// SavedSearchMatchData tells a user that a note started matching one of their
+// saved searches
+type SavedSearchMatchData struct {
+	SavedSearchID uuid.UUID `json:"saved_search_id"`
+	Name          string    `json:"name"`
+	NoteID        uuid.UUID `json:"note_id"`
+	NoteTitle     string    `json:"note_title"`
+}
+
+// AIAskData asks a question about the user's notes. The answer streams back
+// as ai_chunk messages and ends with an ai_done or error message, all with
+// the message_id of the question. An ai_cancel message with the same
+// message_id stops the answer.
+type AIAskData struct {
+	Question    string     `json:"question"`
+	SessionID   *uuid.UUID `json:"session_id,omitempty"`
+	WorkspaceID *uuid.UUID `json:"workspace_id,omitempty"`
+}
+
+// AIChunkData is the next piece of a streamed answer
+type AIChunkData struct {
+	Text string `json:"text"`
+}
+
 // ErrorData represents error information
 type ErrorData struct {
 	Code    string `json:"code"`
@@ -81,7 +153,7 @@ type ErrorData struct {
 
 // Room represents a collaboration room for a note
 type Room struct {
-	ID        string                 `json:"id"`
+	ID        string                `json:"id"`
 	NoteID    uuid.UUID             `json:"note_id"`
 	Clients   map[uuid.UUID]*Client `json:"clients"`
 	CreatedAt time.Time             `json:"created_at"`
@@ -113,4 +185,4 @@ type ActiveUser struct {
 			End   int `json:"end"`
 		} `json:"selection,omitempty"`
 	} `json:"cursor,omitempty"`
-}
\ No newline at end of file
+}
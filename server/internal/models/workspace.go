@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Workspace roles, from least to most privileged
+const (
+	WorkspaceRoleViewer = "viewer" // read everything in the workspace
+	WorkspaceRoleMember = "member" // create and edit notes, people and todos
+	WorkspaceRoleAdmin  = "admin"  // also invite, remove and re-role members
+	WorkspaceRoleOwner  = "owner"  // also manage admins and delete the workspace
+)
+
+var workspaceRoleRank = map[string]int{
+	WorkspaceRoleViewer: 1,
+	WorkspaceRoleMember: 2,
+	WorkspaceRoleAdmin:  3,
+	WorkspaceRoleOwner:  4,
+}
+
+// WorkspaceRoleAllows reports whether role grants at least the access of required
+func WorkspaceRoleAllows(role, required string) bool {
+	rank, ok := workspaceRoleRank[role]
+	return ok && rank >= workspaceRoleRank[required]
+}
+
+// Workspace is a shared knowledge base. Notes, people and connections with a
+// nil WorkspaceID live in their owner's personal space instead.
+type Workspace struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	Name        string    `gorm:"not null;size:255" json:"name"`
+	Description string    `gorm:"type:text" json:"description"`
+	CreatedBy   uuid.UUID `gorm:"type:uuid;not null;index" json:"created_by"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relationships
+	Members []WorkspaceMember `gorm:"foreignKey:WorkspaceID;constraint:OnDelete:CASCADE" json:"members,omitempty"`
+}
+
+func (Workspace) TableName() string {
+	return "workspaces"
+}
+
+func (w *Workspace) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+func (w *Workspace) Validate() error {
+	if strings.TrimSpace(w.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(w.Name) > 255 {
+		return errors.New("name must be 255 characters or less")
+	}
+	return nil
+}
+
+// WorkspaceMember gives a user a role in a workspace
+type WorkspaceMember struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	WorkspaceID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_workspace_members_workspace_user" json:"workspace_id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_workspace_members_workspace_user;index" json:"user_id"`
+	Role        string     `gorm:"type:varchar(20);not null" json:"role"`
+	InvitedBy   *uuid.UUID `gorm:"type:uuid" json:"invited_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
+
+func (WorkspaceMember) TableName() string {
+	return "workspace_members"
+}
+
+func (m *WorkspaceMember) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+func (m *WorkspaceMember) Validate() error {
+	if m.WorkspaceID == uuid.Nil {
+		return errors.New("workspace_id is required")
+	}
+	if m.UserID == uuid.Nil {
+		return errors.New("user_id is required")
+	}
+	if _, ok := workspaceRoleRank[m.Role]; !ok {
+		return errors.New("role must be one of: viewer, member, admin, owner")
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package router
 
 import (
+	"fmt"
+	"log"
+	"time"
+
+	"notesage-server/internal/backup"
 	"notesage-server/internal/config"
 	"notesage-server/internal/handlers"
 	"notesage-server/internal/middleware"
@@ -10,7 +15,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
+// Setup builds the server's routes and starts its background jobs. It fails
+// when a service the config enables cannot be set up.
+func Setup(db *gorm.DB, cfg *config.Config) (*gin.Engine, error) {
 	r := gin.Default()
 
 	// Middleware
@@ -24,34 +31,94 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 
 	// Initialize services
 	wsService := services.NewWebSocketService(db)
+	trashService := services.NewTrashService(db, cfg.Features.TrashRetention)
+	workspaceService := services.NewWorkspaceService(db)
+
+	// Purge expired trash in the background
+	go trashService.RunPurgeJob(time.Hour)
+
+	maxUploadSize, err := config.ParseSize(cfg.Features.MaxUploadSize)
+	if err != nil {
+		log.Printf("Invalid MAX_UPLOAD_SIZE %q, using 10MB: %v", cfg.Features.MaxUploadSize, err)
+		maxUploadSize = 10 << 20
+	}
+
+	// With file uploads enabled, attachments are stored on the local
+	// filesystem and removed a day after the last note stops using them
+	var storage services.Storage
+	var attachmentHandler *handlers.AttachmentHandler
+	if cfg.Features.FileUploads {
+		localStorage, err := services.NewLocalStorage(cfg.Features.UploadPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize attachment storage: %w", err)
+		}
+		storage = localStorage
+		attachmentService := services.NewAttachmentService(db, storage, maxUploadSize)
+		go attachmentService.RunCleanupJob(time.Hour, 24*time.Hour)
+		attachmentHandler = handlers.NewAttachmentHandler(attachmentService)
+	}
+
+	backups := backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, cfg.Backup.MaxAge)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, cfg)
-	noteHandler := handlers.NewNoteHandler(db)
+	noteHandler := handlers.NewNoteHandler(db, wsService)
+	revisionHandler := handlers.NewRevisionHandler(db)
+	conflictHandler := handlers.NewConflictHandler(db, wsService)
+	shareHandler := handlers.NewShareHandler(db)
+	workspaceHandler := handlers.NewWorkspaceHandler(db)
+	commentHandler := handlers.NewCommentHandler(db, wsService)
+	markdownHandler := handlers.NewMarkdownHandler(services.NewImportService(db), services.NewShareService(db), maxUploadSize)
+	accountHandler := handlers.NewAccountHandler(services.NewAccountService(db, storage, maxUploadSize))
 	personHandler := handlers.NewPersonHandler(db)
 	todoHandler := handlers.NewTodoHandler(db)
 	graphHandler := handlers.NewGraphHandler(db)
-	searchHandler := handlers.NewSearchHandler(db)
+	savedSearchHandler := handlers.NewSavedSearchHandler(db, wsService)
+	syncHandler := handlers.NewSyncHandler(db)
+	trashHandler := handlers.NewTrashHandler(trashService)
 	wsHandler := handlers.NewWebSocketHandler(wsService)
 	
 	// Initialize AI service and handler
-	var aiHandler *handlers.AIHandler
-	if cfg.Features.AIEnabled && cfg.AI.APIKey != "" {
+	var aiService *services.AIService
+	var embedder services.Embedder
+	if cfg.Features.AIEnabled {
 		aiConfig := &services.AIConfig{
-			Provider:  services.AIProvider(cfg.AI.Provider),
-			APIKey:    cfg.AI.APIKey,
-			BaseURL:   cfg.AI.BaseURL,
-			Model:     cfg.AI.Model,
-			MaxTokens: cfg.AI.MaxTokens,
-			Timeout:   cfg.AI.Timeout,
+			Provider:       services.AIProviderName(cfg.AI.Provider),
+			APIKey:         cfg.AI.APIKey,
+			BaseURL:        cfg.AI.BaseURL,
+			Model:          cfg.AI.Model,
+			MaxTokens:      cfg.AI.MaxTokens,
+			Timeout:        cfg.AI.Timeout,
+			EmbeddingModel: cfg.AI.EmbeddingModel,
+			MaxRetries:     cfg.AI.MaxRetries,
+			Quotas: services.AIQuotas{
+				UserDailyTokens:   int64(cfg.AI.UserDailyTokens),
+				UserMonthlyTokens: int64(cfg.AI.UserMonthlyTokens),
+				DailyTokens:       int64(cfg.AI.DailyTokens),
+				MonthlyTokens:     int64(cfg.AI.MonthlyTokens),
+			},
+			InputPrice:  cfg.AI.InputPrice,
+			OutputPrice: cfg.AI.OutputPrice,
+		}
+		aiService = services.NewAIService(db, aiConfig)
+		if err := aiService.ProviderError(); err != nil {
+			log.Printf("AI features are disabled: %v", err)
+		}
+		if aiService.CanEmbed() {
+			embedder = aiService
 		}
-		aiService := services.NewAIService(db, aiConfig)
-		aiHandler = handlers.NewAIHandler(aiService)
 	} else {
 		// Create disabled AI service
-		aiService := services.NewAIService(db, nil)
-		aiHandler = handlers.NewAIHandler(aiService)
+		aiService = services.NewAIService(db, nil)
 	}
+	aiHandler := handlers.NewAIHandler(aiService)
+
+	// Notes are embedded for semantic search in the background
+	embeddingService := services.NewEmbeddingService(db, embedder)
+	go embeddingService.RunIndexJob(time.Minute)
+	searchHandler := handlers.NewSearchHandler(db, embeddingService)
+	askHandler := handlers.NewAskHandler(db, aiService, embeddingService)
+	wsService.SetAskService(services.NewAskService(db, aiService, embeddingService))
 
 	// Public routes
 	auth := r.Group("/api/auth")
@@ -72,6 +139,12 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			profile.POST("/change-password", authHandler.ChangePassword)
 		}
 
+		// Server administration
+		admin := api.Group("/admin")
+		admin.Use(middleware.RequireAdmin())
+		handlers.RegisterAdminRoutes(admin, db, backups, maxUploadSize)
+		admin.GET("/ai/usage", aiHandler.GetServerUsage)
+
 		// Admin-only user management
 		users := api.Group("/users")
 		users.Use(middleware.RequireAdmin())
@@ -81,67 +154,172 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			users.PUT("/:id", authHandler.UpdateUser)
 			users.DELETE("/:id", authHandler.DeleteUser)
 		}
-		// Notes
-		notes := api.Group("/notes")
-		{
-			notes.GET("", noteHandler.GetNotes)
-			notes.POST("", noteHandler.CreateNote)
-			notes.GET("/search", noteHandler.SearchNotes)
-			notes.GET("/archived", noteHandler.GetArchivedNotes)
-			notes.GET("/category/:category", noteHandler.GetNotesByCategory)
-			notes.GET("/tag/:tag", noteHandler.GetNotesByTag)
-			notes.GET("/:id", noteHandler.GetNote)
-			notes.PUT("/:id", noteHandler.UpdateNote)
-			notes.POST("/:id/archive", noteHandler.ArchiveNote)
-			notes.POST("/:id/restore", noteHandler.RestoreNote)
-			notes.DELETE("/:id", noteHandler.DeleteNote)
-		}
 
-		// People
-		people := api.Group("/people")
+		// Workspaces
+		workspaces := api.Group("/workspaces")
 		{
-			people.GET("", personHandler.GetPeople)
-			people.POST("", personHandler.CreatePerson)
-			people.GET("/search", personHandler.SearchPeople)
-			people.GET("/:id", personHandler.GetPerson)
-			people.PUT("/:id", personHandler.UpdatePerson)
-			people.DELETE("/:id", personHandler.DeletePerson)
-			people.GET("/:id/connections", personHandler.GetPersonConnections)
-			people.POST("/:id/connections", personHandler.CreatePersonConnection)
+			workspaces.GET("", workspaceHandler.GetWorkspaces)
+			workspaces.POST("", workspaceHandler.CreateWorkspace)
+			workspaces.GET("/:workspace_id", workspaceHandler.GetWorkspace)
+			workspaces.PUT("/:workspace_id", workspaceHandler.UpdateWorkspace)
+			workspaces.DELETE("/:workspace_id", workspaceHandler.DeleteWorkspace)
+			workspaces.GET("/:workspace_id/members", workspaceHandler.GetMembers)
+			workspaces.POST("/:workspace_id/members", workspaceHandler.InviteMember)
+			workspaces.PUT("/:workspace_id/members/:user_id", workspaceHandler.UpdateMember)
+			workspaces.DELETE("/:workspace_id/members/:user_id", workspaceHandler.RemoveMember)
 		}
 
-		// Todos
-		todos := api.Group("/todos")
-		{
-			todos.GET("", todoHandler.GetTodos)
-			todos.POST("", todoHandler.CreateTodo)
-			todos.POST("/sync", todoHandler.SyncNoteTodos)
-			todos.GET("/calendar", todoHandler.GetCalendarTodos)
-			todos.GET("/note/:note_id", todoHandler.GetTodosByNote)
-			todos.GET("/:id", todoHandler.GetTodo)
-			todos.PUT("/:id", todoHandler.UpdateTodo)
-			todos.PUT("/note/:note_id/todo/:todo_id", todoHandler.UpdateTodoByCompositeKey)
-			todos.DELETE("/:id", todoHandler.DeleteTodo)
-		}
+		// Notes, attachments, people, todos, graph, search, trash and sync work
+		// in the personal space or, with an X-Workspace-ID header, in that
+		// workspace. They are also served under /api/workspaces/:workspace_id.
+		scoped := func(rg *gin.RouterGroup) {
+			// Notes
+			notes := rg.Group("/notes")
+			{
+				notes.GET("", noteHandler.GetNotes)
+				notes.POST("", noteHandler.CreateNote)
+				notes.POST("/import", markdownHandler.ImportNotes)
+				notes.GET("/search", noteHandler.SearchNotes)
+				notes.GET("/archived", noteHandler.GetArchivedNotes)
+				notes.GET("/conflicts", conflictHandler.GetOpenConflicts)
+				notes.GET("/shared", shareHandler.GetSharedWithMe)
+				notes.GET("/category/:category", noteHandler.GetNotesByCategory)
+				notes.GET("/tag/:tag", noteHandler.GetNotesByTag)
+				notes.GET("/:id", noteHandler.GetNote)
+				notes.PUT("/:id", noteHandler.UpdateNote)
+				notes.POST("/:id/archive", noteHandler.ArchiveNote)
+				notes.POST("/:id/restore", noteHandler.RestoreNote)
+				notes.DELETE("/:id", noteHandler.DeleteNote)
+				notes.GET("/:id/export", markdownHandler.ExportNote)
+				notes.GET("/:id/versions", revisionHandler.ListVersions)
+				notes.GET("/:id/versions/diff", revisionHandler.DiffVersions)
+				notes.GET("/:id/versions/:version", revisionHandler.GetVersion)
+				notes.POST("/:id/versions/:version/restore", revisionHandler.RestoreVersion)
+				notes.GET("/:id/conflicts", conflictHandler.GetNoteConflicts)
+				notes.POST("/:id/conflicts/:conflict_id/resolve", conflictHandler.ResolveConflict)
+				notes.GET("/:id/shares", shareHandler.GetShares)
+				notes.POST("/:id/shares", shareHandler.ShareNote)
+				notes.DELETE("/:id/shares/:user_id", shareHandler.RevokeShare)
+				notes.GET("/:id/comments", commentHandler.GetComments)
+				notes.POST("/:id/comments", commentHandler.CreateComment)
+				notes.GET("/:id/comments/:comment_id", commentHandler.GetComment)
+				notes.PUT("/:id/comments/:comment_id", commentHandler.UpdateComment)
+				notes.DELETE("/:id/comments/:comment_id", commentHandler.DeleteComment)
+				notes.POST("/:id/comments/:comment_id/resolve", commentHandler.ResolveComment)
+				notes.POST("/:id/comments/:comment_id/reopen", commentHandler.ReopenComment)
+				if attachmentHandler != nil {
+					notes.GET("/:id/attachments", attachmentHandler.GetNoteAttachments)
+					notes.POST("/:id/attachments/:attachment_id", attachmentHandler.LinkAttachment)
+					notes.DELETE("/:id/attachments/:attachment_id", attachmentHandler.UnlinkAttachment)
+				}
+			}
 
-		// Knowledge Graph
-		graph := api.Group("/graph")
-		{
-			graph.GET("", graphHandler.GetGraph)
-			graph.GET("/search", graphHandler.SearchGraph)
-			graph.GET("/stats", graphHandler.GetGraphStats)
-			graph.GET("/types", graphHandler.GetConnectionTypes)
-			graph.GET("/export", graphHandler.ExportGraph)
-			graph.GET("/nodes/:id/connections", graphHandler.GetNodeConnections)
-			graph.GET("/nodes/:id/subgraph", graphHandler.GetSubgraph)
-			graph.POST("/notes/:note_id/detect", graphHandler.DetectConnections)
-			graph.POST("/notes/:note_id/update", graphHandler.UpdateConnections)
+			// Account export and restore
+			rg.GET("/export/account", accountHandler.ExportAccount)
+			rg.POST("/import/account", accountHandler.ImportAccount)
+
+			// Attachments
+			if attachmentHandler != nil {
+				attachments := rg.Group("/attachments")
+				{
+					attachments.POST("", attachmentHandler.UploadAttachment)
+					attachments.GET("/:id", attachmentHandler.GetAttachment)
+				}
+			}
+
+			// People
+			people := rg.Group("/people")
+			{
+				people.GET("", personHandler.GetPeople)
+				people.POST("", personHandler.CreatePerson)
+				people.GET("/search", personHandler.SearchPeople)
+				people.GET("/:id", personHandler.GetPerson)
+				people.PUT("/:id", personHandler.UpdatePerson)
+				people.DELETE("/:id", personHandler.DeletePerson)
+				people.GET("/:id/connections", personHandler.GetPersonConnections)
+				people.POST("/:id/connections", personHandler.CreatePersonConnection)
+			}
+
+			// Todos
+			todos := rg.Group("/todos")
+			{
+				todos.GET("", todoHandler.GetTodos)
+				todos.POST("", todoHandler.CreateTodo)
+				todos.POST("/sync", todoHandler.SyncNoteTodos)
+				todos.GET("/calendar", todoHandler.GetCalendarTodos)
+				todos.GET("/note/:note_id", todoHandler.GetTodosByNote)
+				todos.GET("/:id", todoHandler.GetTodo)
+				todos.PUT("/:id", todoHandler.UpdateTodo)
+				todos.PUT("/note/:note_id/todo/:todo_id", todoHandler.UpdateTodoByCompositeKey)
+				todos.DELETE("/:id", todoHandler.DeleteTodo)
+			}
+
+			// Knowledge Graph
+			graph := rg.Group("/graph")
+			{
+				graph.GET("", graphHandler.GetGraph)
+				graph.GET("/search", graphHandler.SearchGraph)
+				graph.GET("/stats", graphHandler.GetGraphStats)
+				graph.GET("/types", graphHandler.GetConnectionTypes)
+				graph.GET("/export", graphHandler.ExportGraph)
+				graph.GET("/nodes/:id/connections", graphHandler.GetNodeConnections)
+				graph.GET("/nodes/:id/subgraph", graphHandler.GetSubgraph)
+				graph.POST("/notes/:note_id/detect", graphHandler.DetectConnections)
+				graph.POST("/notes/:note_id/update", graphHandler.UpdateConnections)
+			}
+
+			// Search Engine
+			search := rg.Group("/search")
+			{
+				search.GET("", searchHandler.AdvancedSearch)
+				search.GET("/semantic", searchHandler.SemanticSearch)
+				search.GET("/quick", searchHandler.QuickSwitcher)
+				search.GET("/recent", searchHandler.GetRecentNotes)
+				search.GET("/frequent", searchHandler.GetFrequentNotes)
+				search.GET("/suggestions", searchHandler.SearchSuggestions)
+				search.GET("/stats", searchHandler.GetSearchStats)
+				search.GET("/saved", savedSearchHandler.GetSavedSearches)
+				search.POST("/saved", savedSearchHandler.CreateSavedSearch)
+				search.GET("/saved/:id", savedSearchHandler.GetSavedSearch)
+				search.PUT("/saved/:id", savedSearchHandler.UpdateSavedSearch)
+				search.DELETE("/saved/:id", savedSearchHandler.DeleteSavedSearch)
+				search.GET("/saved/:id/results", savedSearchHandler.RunSavedSearch)
+			}
+
+			// Questions about notes
+			ask := rg.Group("/ai")
+			{
+				ask.POST("/ask", askHandler.Ask)
+				ask.POST("/ask/stream", askHandler.AskStream)
+				ask.GET("/sessions", askHandler.GetSessions)
+				ask.GET("/sessions/:id", askHandler.GetSession)
+				ask.DELETE("/sessions/:id", askHandler.DeleteSession)
+			}
+
+			// Trash
+			trash := rg.Group("/trash")
+			{
+				trash.GET("", trashHandler.GetTrash)
+				trash.DELETE("", trashHandler.EmptyTrash)
+				trash.POST("/:type/:id/restore", trashHandler.RestoreItem)
+				trash.DELETE("/:type/:id", trashHandler.PurgeItem)
+			}
+
+			// Offline Sync
+			sync := rg.Group("/sync")
+			{
+				sync.GET("/changes", syncHandler.GetChanges)
+				sync.POST("/push", syncHandler.Push)
+			}
 		}
+		scoped(api.Group("", middleware.Workspace(workspaceService)))
+		scoped(api.Group("/workspaces/:workspace_id", middleware.Workspace(workspaceService)))
 
 		// AI Features
 		ai := api.Group("/ai")
 		{
 			ai.GET("/status", aiHandler.GetAIStatus)
+			ai.GET("/usage", aiHandler.GetUsage)
 			ai.POST("/extract-todos", aiHandler.ExtractTodos)
 			ai.POST("/analyze-people", aiHandler.AnalyzePeople)
 			ai.GET("/insights", aiHandler.GenerateInsights)
@@ -149,16 +327,6 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			ai.POST("/notes/:noteId/analyze-people", aiHandler.AnalyzePeopleInNote)
 		}
 
-		// Search Engine
-		search := api.Group("/search")
-		{
-			search.GET("", searchHandler.AdvancedSearch)
-			search.GET("/quick", searchHandler.QuickSwitcher)
-			search.GET("/recent", searchHandler.GetRecentNotes)
-			search.GET("/suggestions", searchHandler.SearchSuggestions)
-			search.GET("/stats", searchHandler.GetSearchStats)
-		}
-
 		// WebSocket and Real-time Collaboration
 		ws := api.Group("/ws")
 		{
@@ -170,5 +338,5 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 	// WebSocket endpoint (needs to be outside the API group to avoid middleware conflicts)
 	r.GET("/ws", middleware.AuthMiddleware(cfg.Auth.JWTSecret), wsHandler.HandleWebSocket)
 
-	return r
+	return r, nil
 }
\ No newline at end of file
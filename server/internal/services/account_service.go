@@ -0,0 +1,808 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"notesage-server/internal/markdown"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+const (
+	// AccountArchiveFormat identifies an account archive in its manifest
+	AccountArchiveFormat = "notesage-account"
+
+	// AccountArchiveVersion is the version of the archive layout written by
+	// ExportAccount. Archives with a newer version are refused on import.
+	AccountArchiveVersion = 1
+
+	// maxArchiveJSONSize caps each JSON file read from an account archive
+	maxArchiveJSONSize = 64 << 20
+)
+
+var (
+	// ErrInvalidAccountArchive means an upload is not an account archive
+	ErrInvalidAccountArchive = errors.New("not a NoteSage account archive")
+
+	// ErrUnsupportedArchiveVersion means an account archive was written by a
+	// newer server than this one
+	ErrUnsupportedArchiveVersion = errors.New("account archive version is not supported")
+)
+
+var (
+	uuidPattern   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	todoIDPattern = regexp.MustCompile(`^t(\d+)$`)
+)
+
+// AccountManifest describes an account archive. It is written to
+// manifest.json.
+type AccountManifest struct {
+	Format        string         `json:"format"`
+	Version       int            `json:"version"`
+	SchemaVersion string         `json:"schema_version"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	Username      string         `json:"username"`
+	Email         string         `json:"email"`
+	Counts        map[string]int `json:"counts"`
+}
+
+// AccountImportResult counts what an account import created
+type AccountImportResult struct {
+	Notes       int `json:"notes"`
+	People      int `json:"people"`
+	Todos       int `json:"todos"`
+	Connections int `json:"connections"`
+	Attachments int `json:"attachments"`
+}
+
+// The archive records hold the fields that move between servers; owner and
+// workspace are set by the importing server
+
+type archiveNote struct {
+	ID            uuid.UUID    `json:"id"`
+	Title         string       `json:"title"`
+	Content       models.JSONB `json:"content"`
+	Category      string       `json:"category"`
+	Tags          []string     `json:"tags"`
+	FolderPath    string       `json:"folder_path"`
+	ScheduledDate *time.Time   `json:"scheduled_date"`
+	IsArchived    bool         `json:"is_archived"`
+	IsPinned      bool         `json:"is_pinned"`
+	IsFavorite    bool         `json:"is_favorite"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+type archivePerson struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Phone       string    `json:"phone"`
+	Company     string    `json:"company"`
+	Title       string    `json:"title"`
+	LinkedinURL string    `json:"linkedin_url"`
+	AvatarURL   string    `json:"avatar_url"`
+	Notes       string    `json:"notes"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type archiveTodo struct {
+	ID               uuid.UUID  `json:"id"`
+	NoteID           uuid.UUID  `json:"note_id"`
+	TodoID           string     `json:"todo_id"`
+	Text             string     `json:"text"`
+	IsCompleted      bool       `json:"is_completed"`
+	AssignedPersonID *uuid.UUID `json:"assigned_person_id"`
+	DueDate          *time.Time `json:"due_date"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+type archiveConnection struct {
+	ID         uuid.UUID `json:"id"`
+	SourceID   uuid.UUID `json:"source_id"`
+	SourceType string    `json:"source_type"`
+	TargetID   uuid.UUID `json:"target_id"`
+	TargetType string    `json:"target_type"`
+	Strength   int       `json:"strength"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type archiveAttachment struct {
+	ID          uuid.UUID   `json:"id"`
+	Filename    string      `json:"filename"`
+	ContentType string      `json:"content_type"`
+	Size        int64       `json:"size"`
+	SHA256      string      `json:"sha256"`
+	CreatedAt   time.Time   `json:"created_at"`
+	NoteIDs     []uuid.UUID `json:"note_ids"`
+}
+
+// AccountService exports everything a user owns as a zip archive and
+// restores such archives, on this server or another one
+type AccountService struct {
+	db          *gorm.DB
+	storage     Storage
+	revisions   *RevisionService
+	workspaceID *uuid.UUID
+	maxFileSize int64
+}
+
+// NewAccountService creates an account service. Attachment contents are read
+// from and written to storage, which is nil when file uploads are disabled
+// and attachments are left out; attachments larger than maxFileSize bytes are
+// refused on import, and 0 means no limit.
+func NewAccountService(db *gorm.DB, storage Storage, maxFileSize int64) *AccountService {
+	return &AccountService{
+		db:          db,
+		storage:     storage,
+		revisions:   NewRevisionService(db),
+		maxFileSize: maxFileSize,
+	}
+}
+
+// InWorkspace returns a copy of the service that exports and imports a
+// workspace, or the personal space when workspaceID is nil
+func (s *AccountService) InWorkspace(workspaceID *uuid.UUID) *AccountService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// ExportAccount writes a zip archive of the notes, people, todos,
+// connections and attachments of the user's space to w. Each note is
+// written as JSON under notes/ and as Markdown under markdown/, in its
+// folder.
+func (s *AccountService) ExportAccount(userID uuid.UUID, w io.Writer) error {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := AccountManifest{
+		Format:        AccountArchiveFormat,
+		Version:       AccountArchiveVersion,
+		SchemaVersion: s.schemaVersion(),
+		ExportedAt:    time.Now().UTC(),
+		Username:      user.Username,
+		Email:         user.Email,
+		Counts:        make(map[string]int),
+	}
+
+	// Notes are written in batches so large accounts are not held in memory
+	var noteIDs []uuid.UUID
+	markdownNames := make(map[string]bool)
+	var batchErr error
+	err := s.db.Scopes(OwnedBy("notes", userID, s.workspaceID)).
+		FindInBatches(&[]models.Note{}, 200, func(tx *gorm.DB, _ int) error {
+			notes := *tx.Statement.Dest.(*[]models.Note)
+			for i := range notes {
+				note := &notes[i]
+				noteIDs = append(noteIDs, note.ID)
+				if batchErr = writeArchiveJSON(zw, "notes/"+note.ID.String()+".json", archiveNote{
+					ID:            note.ID,
+					Title:         note.Title,
+					Content:       note.Content,
+					Category:      note.Category,
+					Tags:          note.Tags,
+					FolderPath:    note.FolderPath,
+					ScheduledDate: note.ScheduledDate,
+					IsArchived:    note.IsArchived,
+					IsPinned:      note.IsPinned,
+					IsFavorite:    note.IsFavorite,
+					CreatedAt:     note.CreatedAt,
+					UpdatedAt:     note.UpdatedAt,
+				}); batchErr != nil {
+					return batchErr
+				}
+				name := uniqueArchiveName(markdownNames, path.Join("markdown", JoinFolderPath(note.FolderPath, ""), markdown.Filename(note.Title)), ".md")
+				if batchErr = writeArchiveFile(zw, name, []byte(markdown.Export(note))); batchErr != nil {
+					return batchErr
+				}
+			}
+			return nil
+		}).Error
+	if batchErr != nil {
+		return batchErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export notes: %w", err)
+	}
+	manifest.Counts["notes"] = len(noteIDs)
+
+	var people []models.Person
+	if err := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).Order("created_at, id").Find(&people).Error; err != nil {
+		return fmt.Errorf("failed to export people: %w", err)
+	}
+	records := make([]archivePerson, 0, len(people))
+	for _, person := range people {
+		records = append(records, archivePerson{
+			ID:          person.ID,
+			Name:        person.Name,
+			Email:       person.Email,
+			Phone:       person.Phone,
+			Company:     person.Company,
+			Title:       person.Title,
+			LinkedinURL: person.LinkedinURL,
+			AvatarURL:   person.AvatarURL,
+			Notes:       person.Notes,
+			CreatedAt:   person.CreatedAt,
+			UpdatedAt:   person.UpdatedAt,
+		})
+	}
+	if err := writeArchiveJSON(zw, "people.json", records); err != nil {
+		return err
+	}
+	manifest.Counts["people"] = len(records)
+
+	var todos []models.Todo
+	err = s.db.Joins("JOIN notes ON notes.id = todos.note_id AND notes.deleted_at IS NULL").
+		Scopes(OwnedBy("notes", userID, s.workspaceID)).Order("todos.note_id, todos.todo_id").Find(&todos).Error
+	if err != nil {
+		return fmt.Errorf("failed to export todos: %w", err)
+	}
+	todoRecords := make([]archiveTodo, 0, len(todos))
+	for _, todo := range todos {
+		todoRecords = append(todoRecords, archiveTodo{
+			ID:               todo.ID,
+			NoteID:           todo.NoteID,
+			TodoID:           todo.TodoID,
+			Text:             todo.Text,
+			IsCompleted:      todo.IsCompleted,
+			AssignedPersonID: todo.AssignedPersonID,
+			DueDate:          todo.DueDate,
+			CreatedAt:        todo.CreatedAt,
+			UpdatedAt:        todo.UpdatedAt,
+		})
+	}
+	if err := writeArchiveJSON(zw, "todos.json", todoRecords); err != nil {
+		return err
+	}
+	manifest.Counts["todos"] = len(todoRecords)
+
+	var connections []models.Connection
+	if err := s.db.Scopes(OwnedBy("connections", userID, s.workspaceID)).Order("created_at, id").Find(&connections).Error; err != nil {
+		return fmt.Errorf("failed to export connections: %w", err)
+	}
+	connectionRecords := make([]archiveConnection, 0, len(connections))
+	for _, connection := range connections {
+		connectionRecords = append(connectionRecords, archiveConnection{
+			ID:         connection.ID,
+			SourceID:   connection.SourceID,
+			SourceType: connection.SourceType,
+			TargetID:   connection.TargetID,
+			TargetType: connection.TargetType,
+			Strength:   connection.Strength,
+			CreatedAt:  connection.CreatedAt,
+			UpdatedAt:  connection.UpdatedAt,
+		})
+	}
+	if err := writeArchiveJSON(zw, "connections.json", connectionRecords); err != nil {
+		return err
+	}
+	manifest.Counts["connections"] = len(connectionRecords)
+
+	attachments, err := s.exportAttachments(zw, userID)
+	if err != nil {
+		return err
+	}
+	manifest.Counts["attachments"] = attachments
+
+	if err := writeArchiveJSON(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// exportAttachments writes attachments.json and each stored file, once per
+// hash, under attachments/
+func (s *AccountService) exportAttachments(zw *zip.Writer, userID uuid.UUID) (int, error) {
+	// Without file uploads there is no storage and nothing to export
+	if s.storage == nil {
+		return 0, writeArchiveJSON(zw, "attachments.json", []archiveAttachment{})
+	}
+
+	var attachments []models.Attachment
+	if err := s.db.Scopes(OwnedBy("attachments", userID, s.workspaceID)).Order("created_at, id").Find(&attachments).Error; err != nil {
+		return 0, fmt.Errorf("failed to export attachments: %w", err)
+	}
+
+	var links []models.NoteAttachment
+	err := s.db.Joins("JOIN notes ON notes.id = note_attachments.note_id AND notes.deleted_at IS NULL").
+		Scopes(OwnedBy("notes", userID, s.workspaceID)).Find(&links).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to export attachment links: %w", err)
+	}
+	noteIDs := make(map[uuid.UUID][]uuid.UUID)
+	for _, link := range links {
+		noteIDs[link.AttachmentID] = append(noteIDs[link.AttachmentID], link.NoteID)
+	}
+
+	records := make([]archiveAttachment, 0, len(attachments))
+	written := make(map[string]bool)
+	for _, attachment := range attachments {
+		if !written[attachment.SHA256] {
+			if err := s.writeBlob(zw, attachment.SHA256); err != nil {
+				return 0, err
+			}
+			written[attachment.SHA256] = true
+		}
+		records = append(records, archiveAttachment{
+			ID:          attachment.ID,
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			SHA256:      attachment.SHA256,
+			CreatedAt:   attachment.CreatedAt,
+			NoteIDs:     noteIDs[attachment.ID],
+		})
+	}
+	if err := writeArchiveJSON(zw, "attachments.json", records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+func (s *AccountService) writeBlob(zw *zip.Writer, key string) error {
+	content, err := s.storage.Open(key)
+	if err != nil {
+		return fmt.Errorf("failed to read attachment %s: %w", key, err)
+	}
+	defer content.Close()
+
+	// Stored files are usually compressed already
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "attachments/" + key, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+// schemaVersion returns the latest migration applied to the database
+func (s *AccountService) schemaVersion() string {
+	var version string
+	s.db.Model(&models.Migration{}).Order("version DESC").Limit(1).Pluck("version", &version)
+	return version
+}
+
+// ImportAccount restores an archive written by ExportAccount for a user.
+// Every record gets a new ID, and references to the old IDs in note content,
+// todos, connections and attachment links are rewritten to match, so an
+// archive can be restored next to the data it came from. Todo IDs stay
+// unique within each note.
+func (s *AccountService) ImportAccount(userID uuid.UUID, r io.ReaderAt, size int64) (*AccountImportResult, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrInvalidAccountArchive
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var manifest AccountManifest
+	if err := readArchiveJSON(files, "manifest.json", &manifest); err != nil || manifest.Format != AccountArchiveFormat {
+		return nil, ErrInvalidAccountArchive
+	}
+	if manifest.Version < 1 || manifest.Version > AccountArchiveVersion {
+		return nil, ErrUnsupportedArchiveVersion
+	}
+
+	var noteRecords []archiveNote
+	for _, file := range archive.File {
+		if path.Dir(file.Name) != "notes" || path.Ext(file.Name) != ".json" {
+			continue
+		}
+		var record archiveNote
+		if err := readArchiveJSON(files, file.Name, &record); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		noteRecords = append(noteRecords, record)
+	}
+	var people []archivePerson
+	var todos []archiveTodo
+	var connections []archiveConnection
+	var attachments []archiveAttachment
+	for name, dest := range map[string]interface{}{
+		"people.json":      &people,
+		"todos.json":       &todos,
+		"connections.json": &connections,
+		"attachments.json": &attachments,
+	} {
+		if err := readArchiveJSON(files, name, dest); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	ids := make(map[uuid.UUID]uuid.UUID)
+	for _, record := range noteRecords {
+		ids[record.ID] = uuid.New()
+	}
+	for _, record := range people {
+		ids[record.ID] = uuid.New()
+	}
+
+	// Attachments are left out when file uploads are disabled
+	if s.storage == nil {
+		attachments = nil
+	}
+
+	// Attachment contents go to storage first; a failed import leaves only
+	// files that the cleanup job removes
+	newAttachments, links, err := s.restoreAttachments(userID, files, attachments, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AccountImportResult{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, record := range people {
+			person := models.Person{
+				ID:          ids[record.ID],
+				UserID:      userID,
+				WorkspaceID: s.workspaceID,
+				Name:        record.Name,
+				Email:       record.Email,
+				Phone:       record.Phone,
+				Company:     record.Company,
+				Title:       record.Title,
+				LinkedinURL: record.LinkedinURL,
+				AvatarURL:   remapIDs(record.AvatarURL, ids),
+				Notes:       remapIDs(record.Notes, ids),
+				CreatedAt:   record.CreatedAt,
+				UpdatedAt:   record.UpdatedAt,
+			}
+			if err := person.Validate(); err != nil {
+				return fmt.Errorf("person %s: %w", record.ID, err)
+			}
+			if err := tx.Create(&person).Error; err != nil {
+				return err
+			}
+			if err := RecordSyncChange(tx, userID, models.SyncEntityPerson, person.ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+			result.People++
+		}
+
+		for _, record := range noteRecords {
+			note := models.Note{
+				ID:            ids[record.ID],
+				UserID:        userID,
+				WorkspaceID:   s.workspaceID,
+				Title:         record.Title,
+				Content:       SanitizeContent(remapContentIDs(record.Content, ids)),
+				Category:      record.Category,
+				Tags:          pq.StringArray(record.Tags),
+				FolderPath:    JoinFolderPath(record.FolderPath, ""),
+				ScheduledDate: record.ScheduledDate,
+				IsArchived:    record.IsArchived,
+				IsPinned:      record.IsPinned,
+				IsFavorite:    record.IsFavorite,
+				CreatedAt:     record.CreatedAt,
+				UpdatedAt:     record.UpdatedAt,
+			}
+			if note.Category == "" {
+				note.Category = "Note"
+			}
+			if err := note.Validate(); err != nil {
+				return fmt.Errorf("note %s: %w", record.ID, err)
+			}
+			if err := tx.Create(&note).Error; err != nil {
+				return err
+			}
+			if err := s.revisions.RecordRevision(tx, &note, userID, "Imported"); err != nil {
+				return err
+			}
+			if err := RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+			result.Notes++
+		}
+
+		for _, todo := range restoreTodos(todos, ids) {
+			if err := tx.Create(&todo).Error; err != nil {
+				return err
+			}
+			// Its note was imported above, into the same space
+			note := models.Note{ID: todo.NoteID, UserID: userID, WorkspaceID: s.workspaceID}
+			if err := RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todo.ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+			result.Todos++
+		}
+
+		for _, record := range connections {
+			sourceID, sourceOK := ids[record.SourceID]
+			targetID, targetOK := ids[record.TargetID]
+			if !sourceOK || !targetOK {
+				continue
+			}
+			connection := models.Connection{
+				ID:          uuid.New(),
+				UserID:      userID,
+				WorkspaceID: s.workspaceID,
+				SourceID:    sourceID,
+				SourceType:  record.SourceType,
+				TargetID:    targetID,
+				TargetType:  record.TargetType,
+				Strength:    record.Strength,
+				CreatedAt:   record.CreatedAt,
+				UpdatedAt:   record.UpdatedAt,
+			}
+			if err := tx.Create(&connection).Error; err != nil {
+				return err
+			}
+			if err := RecordSyncChange(tx, userID, models.SyncEntityConnection, connection.ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+			result.Connections++
+		}
+
+		for i := range newAttachments {
+			if err := tx.Create(&newAttachments[i]).Error; err != nil {
+				return err
+			}
+		}
+		for i := range links {
+			if err := tx.Create(&links[i]).Error; err != nil {
+				return err
+			}
+		}
+		result.Attachments = len(attachments)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore account: %w", err)
+	}
+	return result, nil
+}
+
+// restoreAttachments stores the files of an archive's attachments and
+// returns the attachments to create and their note links. A file the user
+// already has in the target space reuses that attachment. New IDs are added
+// to ids.
+func (s *AccountService) restoreAttachments(userID uuid.UUID, files map[string]*zip.File, records []archiveAttachment, ids map[uuid.UUID]uuid.UUID) ([]models.Attachment, []models.NoteAttachment, error) {
+	var attachments []models.Attachment
+	var links []models.NoteAttachment
+	linked := make(map[[2]uuid.UUID]bool)
+	stored := make(map[string]uuid.UUID)
+	for _, record := range records {
+		if id, ok := stored[record.SHA256]; ok {
+			ids[record.ID] = id
+		} else {
+			var existing models.Attachment
+			err := s.db.Scopes(OwnedBy("attachments", userID, s.workspaceID)).
+				Where("sha256 = ?", record.SHA256).First(&existing).Error
+			switch {
+			case err == nil:
+				ids[record.ID] = existing.ID
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				if err := s.restoreBlob(files, record.SHA256); err != nil {
+					return nil, nil, fmt.Errorf("attachment %s: %w", record.Filename, err)
+				}
+				attachment := models.Attachment{
+					ID:          uuid.New(),
+					UserID:      userID,
+					WorkspaceID: s.workspaceID,
+					Filename:    path.Base(record.Filename),
+					ContentType: record.ContentType,
+					Size:        record.Size,
+					SHA256:      record.SHA256,
+					CreatedAt:   record.CreatedAt,
+				}
+				if err := attachment.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("attachment %s: %w", record.Filename, err)
+				}
+				attachments = append(attachments, attachment)
+				ids[record.ID] = attachment.ID
+			default:
+				return nil, nil, err
+			}
+			stored[record.SHA256] = ids[record.ID]
+		}
+
+		for _, noteID := range record.NoteIDs {
+			newNoteID, ok := ids[noteID]
+			if !ok {
+				continue
+			}
+			link := models.NoteAttachment{NoteID: newNoteID, AttachmentID: ids[record.ID]}
+			if key := [2]uuid.UUID{link.NoteID, link.AttachmentID}; !linked[key] {
+				linked[key] = true
+				links = append(links, link)
+			}
+		}
+	}
+	return attachments, links, nil
+}
+
+// restoreBlob copies an attachment file from the archive into storage after
+// checking it matches its hash
+func (s *AccountService) restoreBlob(files map[string]*zip.File, sum string) error {
+	if exists, err := s.storage.Exists(sum); err != nil {
+		return err
+	} else if exists {
+		return nil
+	}
+	file, ok := files["attachments/"+sum]
+	if !ok {
+		return errors.New("file missing from archive")
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := readLimited(rc, s.maxFileSize)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != sum {
+		return errors.New("file does not match its hash")
+	}
+	return s.storage.Put(sum, bytes.NewReader(data))
+}
+
+// restoreTodos gives todos new IDs on their new notes. Todo IDs are kept
+// unless they repeat within a note or are malformed, in which case the todo
+// gets the next free ID of that note.
+func restoreTodos(records []archiveTodo, ids map[uuid.UUID]uuid.UUID) []models.Todo {
+	used := make(map[uuid.UUID]map[string]bool)
+	next := make(map[uuid.UUID]int)
+	for _, record := range records {
+		if used[record.NoteID] == nil {
+			used[record.NoteID] = make(map[string]bool)
+		}
+		if match := todoIDPattern.FindStringSubmatch(record.TodoID); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n >= next[record.NoteID] {
+				next[record.NoteID] = n + 1
+			}
+		}
+	}
+
+	sorted := append([]archiveTodo{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+
+	var todos []models.Todo
+	for _, record := range sorted {
+		noteID, ok := ids[record.NoteID]
+		if !ok {
+			continue
+		}
+		todoID := record.TodoID
+		if !todoIDPattern.MatchString(todoID) || used[record.NoteID][todoID] {
+			if next[record.NoteID] == 0 {
+				next[record.NoteID] = 1
+			}
+			todoID = fmt.Sprintf("t%d", next[record.NoteID])
+			next[record.NoteID]++
+		}
+		used[record.NoteID][todoID] = true
+
+		todo := models.Todo{
+			ID:          uuid.New(),
+			NoteID:      noteID,
+			TodoID:      todoID,
+			Text:        record.Text,
+			IsCompleted: record.IsCompleted,
+			DueDate:     record.DueDate,
+			CreatedAt:   record.CreatedAt,
+			UpdatedAt:   record.UpdatedAt,
+		}
+		if record.AssignedPersonID != nil {
+			if personID, ok := ids[*record.AssignedPersonID]; ok {
+				todo.AssignedPersonID = &personID
+			}
+		}
+		todos = append(todos, todo)
+	}
+	return todos
+}
+
+// remapContentIDs rewrites old IDs in every string of note content
+func remapContentIDs(content models.JSONB, ids map[uuid.UUID]uuid.UUID) models.JSONB {
+	if content == nil {
+		return nil
+	}
+	var walk func(value interface{}) interface{}
+	walk = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return remapIDs(v, ids)
+		case map[string]interface{}:
+			for key, child := range v {
+				v[key] = walk(child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				v[i] = walk(child)
+			}
+		}
+		return value
+	}
+	return models.JSONB(walk(map[string]interface{}(content)).(map[string]interface{}))
+}
+
+// remapIDs rewrites old IDs in text, such as attachment URLs and mentions
+func remapIDs(text string, ids map[uuid.UUID]uuid.UUID) string {
+	return uuidPattern.ReplaceAllStringFunc(text, func(match string) string {
+		id, err := uuid.Parse(match)
+		if err != nil {
+			return match
+		}
+		if newID, ok := ids[id]; ok {
+			return newID.String()
+		}
+		return match
+	})
+}
+
+func writeArchiveJSON(zw *zip.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeArchiveFile(zw, name, data)
+}
+
+func writeArchiveFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readArchiveJSON decodes a JSON file of an archive; a missing file leaves
+// dest unchanged
+func readArchiveJSON(files map[string]*zip.File, name string, dest interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		if name == "manifest.json" {
+			return ErrInvalidAccountArchive
+		}
+		return nil
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := readLimited(rc, maxArchiveJSONSize)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// uniqueArchiveName returns name+ext, or name (2)+ext and so on when that
+// was already used
+func uniqueArchiveName(used map[string]bool, name, ext string) string {
+	candidate := name + ext
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", name, i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountService_ExportAndImport(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	owner := createTestUser(t, db)
+
+	person := models.Person{UserID: owner.ID, Name: "Ana Lima", Email: "ana@example.com"}
+	require.NoError(t, db.Create(&person).Error)
+	attachment, err := NewAttachmentService(db, storage, 0).Upload(owner.ID, nil, "plan.txt", "", strings.NewReader("the plan"))
+	require.NoError(t, err)
+
+	note := models.Note{
+		UserID:     owner.ID,
+		Title:      "Kickoff",
+		FolderPath: "/Work",
+		Tags:       []string{"work"},
+		Content: models.JSONB{"type": "doc", "content": []interface{}{
+			map[string]interface{}{"type": "paragraph", "content": []interface{}{
+				map[string]interface{}{"type": "mention", "attrs": map[string]interface{}{"id": person.ID.String(), "name": "Ana Lima"}},
+				map[string]interface{}{"type": "text", "text": " see /api/attachments/" + attachment.ID.String()},
+			}},
+		}},
+	}
+	require.NoError(t, db.Create(&note).Error)
+	require.NoError(t, db.Create(&models.NoteAttachment{NoteID: note.ID, AttachmentID: attachment.ID}).Error)
+	require.NoError(t, db.Create(&models.Todo{NoteID: note.ID, TodoID: "t1", Text: "Send agenda", AssignedPersonID: &person.ID}).Error)
+	require.NoError(t, db.Create(&models.Todo{NoteID: note.ID, TodoID: "t2", Text: "Book room", IsCompleted: true}).Error)
+	require.NoError(t, db.Create(&models.Connection{
+		UserID: owner.ID, SourceID: note.ID, SourceType: "note", TargetID: person.ID, TargetType: "person", Strength: 2,
+	}).Error)
+
+	var archive bytes.Buffer
+	require.NoError(t, NewAccountService(db, storage, 0).ExportAccount(owner.ID, &archive))
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	names := map[string]*zip.File{}
+	for _, file := range reader.File {
+		names[file.Name] = file
+	}
+	for _, name := range []string{"manifest.json", "notes/" + note.ID.String() + ".json", "markdown/Work/Kickoff.md", "people.json", "todos.json", "connections.json", "attachments.json", "attachments/" + attachment.SHA256} {
+		assert.Contains(t, names, name)
+	}
+	rc, err := names["manifest.json"].Open()
+	require.NoError(t, err)
+	var manifest AccountManifest
+	require.NoError(t, json.NewDecoder(rc).Decode(&manifest))
+	rc.Close()
+	assert.Equal(t, AccountArchiveFormat, manifest.Format)
+	assert.Equal(t, AccountArchiveVersion, manifest.Version)
+	assert.NotEmpty(t, manifest.SchemaVersion)
+	assert.Equal(t, map[string]int{"notes": 1, "people": 1, "todos": 2, "connections": 1, "attachments": 1}, manifest.Counts)
+
+	// Restore on another server: a new user and empty storage
+	otherStorage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	restorer := createTestUser(t, db)
+	result, err := NewAccountService(db, otherStorage, 0).ImportAccount(restorer.ID, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, &AccountImportResult{Notes: 1, People: 1, Todos: 2, Connections: 1, Attachments: 1}, result)
+
+	var restored models.Note
+	require.NoError(t, db.Where("user_id = ?", restorer.ID).First(&restored).Error)
+	assert.NotEqual(t, note.ID, restored.ID)
+	assert.Equal(t, "/Work", restored.FolderPath)
+	var restoredPerson models.Person
+	require.NoError(t, db.Where("user_id = ?", restorer.ID).First(&restoredPerson).Error)
+	var restoredAttachment models.Attachment
+	require.NoError(t, db.Where("user_id = ?", restorer.ID).First(&restoredAttachment).Error)
+
+	content, err := json.Marshal(restored.Content)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), restoredPerson.ID.String())
+	assert.Contains(t, string(content), "/api/attachments/"+restoredAttachment.ID.String())
+	assert.NotContains(t, string(content), person.ID.String())
+
+	var todos []models.Todo
+	require.NoError(t, db.Where("note_id = ?", restored.ID).Order("todo_id").Find(&todos).Error)
+	require.Len(t, todos, 2)
+	assert.Equal(t, "t1", todos[0].TodoID)
+	assert.Equal(t, &restoredPerson.ID, todos[0].AssignedPersonID)
+	assert.Equal(t, "t2", todos[1].TodoID)
+	assert.True(t, todos[1].IsCompleted)
+
+	var connection models.Connection
+	require.NoError(t, db.Where("user_id = ?", restorer.ID).First(&connection).Error)
+	assert.Equal(t, restored.ID, connection.SourceID)
+	assert.Equal(t, restoredPerson.ID, connection.TargetID)
+
+	var links int64
+	db.Model(&models.NoteAttachment{}).Where("note_id = ? AND attachment_id = ?", restored.ID, restoredAttachment.ID).Count(&links)
+	assert.Equal(t, int64(1), links)
+	blob, err := otherStorage.Open(attachment.SHA256)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	assert.Equal(t, "the plan", string(data))
+
+	// Restoring next to the original data creates copies
+	_, err = NewAccountService(db, storage, 0).ImportAccount(owner.ID, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	var count int64
+	db.Model(&models.Note{}).Where("user_id = ?", owner.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	_, err = NewAccountService(db, storage, 0).ImportAccount(owner.ID, strings.NewReader("nope"), 4)
+	assert.ErrorIs(t, err, ErrInvalidAccountArchive)
+}
+
+func TestRestoreTodosKeepsTodoIDsUnique(t *testing.T) {
+	noteID, newNoteID := uuid.New(), uuid.New()
+	ids := map[uuid.UUID]uuid.UUID{noteID: newNoteID}
+
+	todos := restoreTodos([]archiveTodo{
+		{ID: uuid.New(), NoteID: noteID, TodoID: "t3", Text: "a"},
+		{ID: uuid.New(), NoteID: noteID, TodoID: "t3", Text: "b"},
+		{ID: uuid.New(), NoteID: noteID, TodoID: "bad", Text: "c"},
+		{ID: uuid.New(), NoteID: uuid.New(), TodoID: "t1", Text: "orphan"},
+	}, ids)
+
+	require.Len(t, todos, 3)
+	got := map[string]string{}
+	for _, todo := range todos {
+		assert.Equal(t, newNoteID, todo.NoteID)
+		got[todo.Text] = todo.TodoID
+	}
+	assert.Equal(t, map[string]string{"a": "t3", "b": "t4", "c": "t5"}, got)
+}
+
+func TestAccountService_ExportScopesToSpace(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	member := createTestUser(t, db)
+	workspaces := NewWorkspaceService(db)
+	workspace, err := workspaces.CreateWorkspace(owner.ID, "Team", "")
+	require.NoError(t, err)
+	_, err = workspaces.InviteMember(owner.ID, workspace.ID, member.ID, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+
+	personal := createTestNote(t, db, owner.ID)
+	team := createTestNote(t, db, member.ID)
+	require.NoError(t, db.Model(&team).Update("workspace_id", workspace.ID).Error)
+	require.NoError(t, db.Create(&models.Todo{NoteID: team.ID, TodoID: "t1", Text: "Plan"}).Error)
+	require.NoError(t, db.Create(&models.Person{UserID: owner.ID, WorkspaceID: &workspace.ID, Name: "Ada"}).Error)
+
+	export := func(service *AccountService) (map[string]int, map[string]bool) {
+		var archive bytes.Buffer
+		require.NoError(t, service.ExportAccount(owner.ID, &archive))
+		reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		require.NoError(t, err)
+		names := map[string]bool{}
+		var manifest AccountManifest
+		for _, file := range reader.File {
+			names[file.Name] = true
+			if file.Name == "manifest.json" {
+				rc, err := file.Open()
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(rc).Decode(&manifest))
+				rc.Close()
+			}
+		}
+		return manifest.Counts, names
+	}
+
+	// The personal space holds only the user's own notes
+	counts, names := export(NewAccountService(db, nil, 0))
+	assert.Equal(t, map[string]int{"notes": 1, "people": 0, "todos": 0, "connections": 0, "attachments": 0}, counts)
+	assert.True(t, names["notes/"+personal.ID.String()+".json"])
+
+	// A workspace holds everything in it, whoever wrote it
+	counts, names = export(NewAccountService(db, nil, 0).InWorkspace(&workspace.ID))
+	assert.Equal(t, map[string]int{"notes": 1, "people": 1, "todos": 1, "connections": 0, "attachments": 0}, counts)
+	assert.True(t, names["notes/"+team.ID.String()+".json"])
+}
+
+func TestAccountService_ImportSanitizesContent(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	note := models.Note{
+		UserID: owner.ID,
+		Title:  "Crafted",
+		Content: models.JSONB{"type": "doc", "content": []interface{}{
+			map[string]interface{}{"type": "paragraph", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": `hi<script>alert(1)</script> <img src="x" onerror="alert(2)">`},
+			}},
+		}},
+	}
+	require.NoError(t, db.Create(&note).Error)
+
+	var archive bytes.Buffer
+	require.NoError(t, NewAccountService(db, nil, 0).ExportAccount(owner.ID, &archive))
+
+	restorer := createTestUser(t, db)
+	_, err := NewAccountService(db, nil, 0).ImportAccount(restorer.ID, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+
+	var restored models.Note
+	require.NoError(t, db.Where("user_id = ?", restorer.ID).First(&restored).Error)
+	content, err := json.Marshal(restored.Content)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "<script")
+	assert.NotContains(t, string(content), "onerror")
+	assert.Contains(t, string(content), "hi")
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anthropicVersion is the version of the Anthropic API requests are written
+// for
+const anthropicVersion = "2023-06-01"
+
+// anthropicProvider talks to the Anthropic Messages API
+type anthropicProvider struct {
+	aiProviderSettings
+}
+
+func newAnthropicProvider(settings aiProviderSettings) AIProvider {
+	return &anthropicProvider{settings}
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+func (p *anthropicProvider) messagesRequest(prompt string, stream bool) map[string]interface{} {
+	request := map[string]interface{}{
+		"model": p.model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+		"max_tokens": p.maxTokens,
+	}
+	if stream {
+		request["stream"] = true
+	}
+	return request
+}
+
+// anthropicUsage is the usage block of a message
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Complete calls the messages endpoint
+func (p *anthropicProvider) Complete(ctx context.Context, prompt string) (string, AITokens, error) {
+	var response struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage anthropicUsage `json:"usage"`
+	}
+	if err := p.client.postJSON(ctx, p.baseURL+"/messages", p.headers(), p.messagesRequest(prompt, false), &response); err != nil {
+		return "", AITokens{}, err
+	}
+	tokens := AITokens{Prompt: response.Usage.InputTokens, Completion: response.Usage.OutputTokens}
+
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", tokens, fmt.Errorf("no response from AI")
+	}
+	return text.String(), tokens, nil
+}
+
+// Stream streams from the messages endpoint, which sends the text as
+// content_block_delta events ending with message_stop. The prompt tokens
+// come with message_start and the completion tokens with message_delta.
+func (p *anthropicProvider) Stream(ctx context.Context, prompt string, onDelta StreamFunc) (AITokens, error) {
+	body, err := p.client.postStream(ctx, p.baseURL+"/messages", p.headers(), p.messagesRequest(prompt, true))
+	if err != nil {
+		return AITokens{}, err
+	}
+	defer body.Close()
+
+	var tokens AITokens
+	err = readServerSentEvents(body, func(data string) error {
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Usage anthropicUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			tokens.Prompt = event.Message.Usage.InputTokens
+		case "message_delta":
+			tokens.Completion = event.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				return onDelta(event.Delta.Text)
+			}
+		case "message_stop":
+			return errStopStream
+		case "error":
+			return fmt.Errorf("API stream failed with %s: %s", event.Error.Type, event.Error.Message)
+		}
+		return nil
+	})
+	return tokens, err
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// geminiProvider talks to the Google Gemini API
+type geminiProvider struct {
+	aiProviderSettings
+}
+
+func newGeminiProvider(settings aiProviderSettings) AIProvider {
+	return &geminiProvider{settings}
+}
+
+// geminiResponse is a response, or one event of a streamed response, from
+// generateContent
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (r *geminiResponse) tokens() AITokens {
+	if r.UsageMetadata == nil {
+		return AITokens{}
+	}
+	return AITokens{Prompt: r.UsageMetadata.PromptTokenCount, Completion: r.UsageMetadata.CandidatesTokenCount}
+}
+
+func (p *geminiProvider) generateRequest(prompt string) map[string]interface{} {
+	return map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"parts": []map[string]string{
+					{
+						"text": prompt,
+					},
+				},
+			},
+		},
+		"generationConfig": map[string]interface{}{
+			"maxOutputTokens": p.maxTokens,
+		},
+	}
+}
+
+// Complete calls the generateContent endpoint
+func (p *geminiProvider) Complete(ctx context.Context, prompt string) (string, AITokens, error) {
+	var response geminiResponse
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", p.baseURL, p.model, p.apiKey)
+	if err := p.client.postJSON(ctx, url, nil, p.generateRequest(prompt), &response); err != nil {
+		return "", AITokens{}, err
+	}
+
+	if len(response.Candidates) == 0 || len(response.Candidates[0].Content.Parts) == 0 {
+		return "", response.tokens(), fmt.Errorf("no response from AI")
+	}
+	return response.Candidates[0].Content.Parts[0].Text, response.tokens(), nil
+}
+
+// Stream streams from the streamGenerateContent endpoint, which sends a
+// partial response per event, each with the usage so far
+func (p *geminiProvider) Stream(ctx context.Context, prompt string, onDelta StreamFunc) (AITokens, error) {
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", p.baseURL, p.model, p.apiKey)
+	body, err := p.client.postStream(ctx, url, nil, p.generateRequest(prompt))
+	if err != nil {
+		return AITokens{}, err
+	}
+	defer body.Close()
+
+	var tokens AITokens
+	err = readServerSentEvents(body, func(data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		if chunk.UsageMetadata != nil {
+			tokens = chunk.tokens()
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if err := onDelta(part.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// EmbeddingModel returns the model EmbedTexts uses
+func (p *geminiProvider) EmbeddingModel() string {
+	return p.embeddingModel
+}
+
+// EmbedTexts calls the batchEmbedContents endpoint, which does not report
+// tokens
+func (p *geminiProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, AITokens, error) {
+	model := "models/" + p.embeddingModel
+
+	requests := make([]map[string]interface{}, len(texts))
+	for i, text := range texts {
+		requests[i] = map[string]interface{}{
+			"model": model,
+			"content": map[string]interface{}{
+				"parts": []map[string]string{{"text": text}},
+			},
+		}
+	}
+
+	var response struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	url := fmt.Sprintf("%s/%s:batchEmbedContents?key=%s", p.baseURL, model, p.apiKey)
+	if err := p.client.postJSON(ctx, url, nil, map[string]interface{}{"requests": requests}, &response); err != nil {
+		return nil, AITokens{}, err
+	}
+
+	vectors := make([][]float32, len(response.Embeddings))
+	for i, embedding := range response.Embeddings {
+		vectors[i] = embedding.Values
+	}
+	return vectors, AITokens{}, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ollamaProvider talks to the native API of a local Ollama server, which
+// needs no API key
+type ollamaProvider struct {
+	aiProviderSettings
+}
+
+func newOllamaProvider(settings aiProviderSettings) AIProvider {
+	return &ollamaProvider{settings}
+}
+
+// ollamaChatResponse is a response, or one line of a streamed response, from
+// the chat endpoint
+type ollamaChatResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done  bool   `json:"done"`
+	Error string `json:"error"`
+
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (r *ollamaChatResponse) tokens() AITokens {
+	return AITokens{Prompt: r.PromptEvalCount, Completion: r.EvalCount}
+}
+
+func (p *ollamaProvider) chatRequest(prompt string, stream bool) map[string]interface{} {
+	return map[string]interface{}{
+		"model": p.model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+		"stream": stream,
+		"options": map[string]interface{}{
+			"num_predict": p.maxTokens,
+		},
+	}
+}
+
+// Complete calls the chat endpoint
+func (p *ollamaProvider) Complete(ctx context.Context, prompt string) (string, AITokens, error) {
+	var response ollamaChatResponse
+	if err := p.client.postJSON(ctx, p.baseURL+"/api/chat", nil, p.chatRequest(prompt, false), &response); err != nil {
+		return "", AITokens{}, err
+	}
+	if response.Error != "" {
+		return "", AITokens{}, errors.New(response.Error)
+	}
+	return response.Message.Content, response.tokens(), nil
+}
+
+// Stream streams from the chat endpoint, which sends one JSON object per line
+// until one is marked done, which carries the token counts
+func (p *ollamaProvider) Stream(ctx context.Context, prompt string, onDelta StreamFunc) (AITokens, error) {
+	body, err := p.client.postStream(ctx, p.baseURL+"/api/chat", nil, p.chatRequest(prompt, true))
+	if err != nil {
+		return AITokens{}, err
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamEventSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return AITokens{}, fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		if chunk.Error != "" {
+			return AITokens{}, errors.New(chunk.Error)
+		}
+		if err := onDelta(chunk.Message.Content); err != nil {
+			return AITokens{}, err
+		}
+		if chunk.Done {
+			return chunk.tokens(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return AITokens{}, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return AITokens{}, nil
+}
+
+// EmbeddingModel returns the model EmbedTexts uses
+func (p *ollamaProvider) EmbeddingModel() string {
+	return p.embeddingModel
+}
+
+// EmbedTexts calls the embed endpoint
+func (p *ollamaProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, AITokens, error) {
+	requestBody := map[string]interface{}{
+		"model": p.embeddingModel,
+		"input": texts,
+	}
+
+	var response struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := p.client.postJSON(ctx, p.baseURL+"/api/embed", nil, requestBody, &response); err != nil {
+		return nil, AITokens{}, err
+	}
+	return response.Embeddings, AITokens{Prompt: response.PromptEvalCount}, nil
+}
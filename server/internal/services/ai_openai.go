@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// openAIProvider talks to the OpenAI API and the servers that copy it: Grok,
+// llama.cpp and other OpenAI-compatible servers
+type openAIProvider struct {
+	aiProviderSettings
+}
+
+func newOpenAIProvider(settings aiProviderSettings) AIProvider {
+	return &openAIProvider{settings}
+}
+
+// headers authorizes requests when an API key is set. Local servers take
+// requests without one.
+func (p *openAIProvider) headers() map[string]string {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return headers
+}
+
+func (p *openAIProvider) chatRequest(prompt string, stream bool) map[string]interface{} {
+	request := map[string]interface{}{
+		"model": p.model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+		"max_tokens":  p.maxTokens,
+		"temperature": 0.7,
+	}
+	if stream {
+		request["stream"] = true
+		request["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	return request
+}
+
+// openAIUsage is the usage block of a chat completion or embedding response
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *openAIUsage) tokens() AITokens {
+	if u == nil {
+		return AITokens{}
+	}
+	return AITokens{Prompt: u.PromptTokens, Completion: u.CompletionTokens}
+}
+
+// Complete calls the chat completions endpoint
+func (p *openAIProvider) Complete(ctx context.Context, prompt string) (string, AITokens, error) {
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *openAIUsage `json:"usage"`
+	}
+	if err := p.client.postJSON(ctx, p.baseURL+"/chat/completions", p.headers(), p.chatRequest(prompt, false), &response); err != nil {
+		return "", AITokens{}, err
+	}
+
+	if len(response.Choices) == 0 {
+		return "", response.Usage.tokens(), fmt.Errorf("no response from AI")
+	}
+	return response.Choices[0].Message.Content, response.Usage.tokens(), nil
+}
+
+// Stream streams from the chat completions endpoint, which sends the
+// completion as server-sent deltas ending with [DONE]. The usage comes in an
+// event of its own before [DONE].
+func (p *openAIProvider) Stream(ctx context.Context, prompt string, onDelta StreamFunc) (AITokens, error) {
+	body, err := p.client.postStream(ctx, p.baseURL+"/chat/completions", p.headers(), p.chatRequest(prompt, true))
+	if err != nil {
+		return AITokens{}, err
+	}
+	defer body.Close()
+
+	var tokens AITokens
+	err = readServerSentEvents(body, func(data string) error {
+		if data == "[DONE]" {
+			return errStopStream
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		if chunk.Usage != nil {
+			tokens = chunk.Usage.tokens()
+		}
+		for _, choice := range chunk.Choices {
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// EmbeddingModel returns the model EmbedTexts uses, empty when the provider
+// has none configured
+func (p *openAIProvider) EmbeddingModel() string {
+	return p.embeddingModel
+}
+
+// EmbedTexts calls the embeddings endpoint
+func (p *openAIProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, AITokens, error) {
+	requestBody := map[string]interface{}{
+		"model": p.embeddingModel,
+		"input": texts,
+	}
+
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage *openAIUsage `json:"usage"`
+	}
+	if err := p.client.postJSON(ctx, p.baseURL+"/embeddings", p.headers(), requestBody, &response); err != nil {
+		return nil, AITokens{}, err
+	}
+	tokens := response.Usage.tokens()
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range response.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, tokens, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, tokens, fmt.Errorf("no embedding for input %d", i)
+		}
+	}
+	return vectors, tokens, nil
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AIProviderName names a provider in the registry
+type AIProviderName string
+
+const (
+	ProviderOpenAI    AIProviderName = "openai"
+	ProviderGemini    AIProviderName = "gemini"
+	ProviderGrok      AIProviderName = "grok"
+	ProviderAnthropic AIProviderName = "anthropic"
+	ProviderOllama    AIProviderName = "ollama"
+	ProviderLlamaCpp  AIProviderName = "llamacpp"
+	// ProviderOpenAICompatible is any server speaking the OpenAI chat
+	// completions API at BaseURL
+	ProviderOpenAICompatible AIProviderName = "openai-compatible"
+)
+
+const (
+	// defaultAIRetries is how many times a request refused with 429 or a
+	// 5xx status is retried when the config does not say
+	defaultAIRetries = 2
+
+	// maxAIRetryDelay caps the wait before a retry, including waits asked
+	// for with Retry-After
+	maxAIRetryDelay = 30 * time.Second
+)
+
+// AITokens counts the tokens of a provider call, as the provider reports
+// them. Counts a provider does not report are zero.
+type AITokens struct {
+	Prompt     int
+	Completion int
+}
+
+// AIProvider generates text with a model. Providers that can also embed text
+// implement AIEmbeddingProvider.
+type AIProvider interface {
+	// Model names the model completions come from
+	Model() string
+	// Complete returns the model's completion of prompt
+	Complete(ctx context.Context, prompt string) (string, AITokens, error)
+	// Stream passes each piece of the completion of prompt to onDelta as
+	// it arrives. Pieces may be empty. The tokens counted before a
+	// failure are returned with the error.
+	Stream(ctx context.Context, prompt string, onDelta StreamFunc) (AITokens, error)
+}
+
+// AIEmbeddingProvider is a provider that embeds text
+type AIEmbeddingProvider interface {
+	// EmbeddingModel names the model EmbedTexts uses, or is empty when
+	// the provider has none configured
+	EmbeddingModel() string
+	// EmbedTexts returns one vector for each text, in order
+	EmbedTexts(ctx context.Context, texts []string) ([][]float32, AITokens, error)
+}
+
+// aiProviderSettings are the settings a provider is created with: the config
+// with the provider's defaults filled in
+type aiProviderSettings struct {
+	apiKey         string
+	baseURL        string
+	model          string
+	embeddingModel string
+	maxTokens      int
+	client         *aiClient
+}
+
+// Model returns the model completions come from
+func (s aiProviderSettings) Model() string {
+	return s.model
+}
+
+// aiProviderSpec describes a provider in the registry
+type aiProviderSpec struct {
+	// baseURL, model, embeddingModel and maxTokens are used when the
+	// config leaves them unset. A provider without an embedding model
+	// only embeds when one is configured.
+	baseURL        string
+	model          string
+	embeddingModel string
+	maxTokens      int
+
+	// local providers, such as Ollama or a server of the user's own, take
+	// an API key but never need one. Hosted providers need one unless
+	// BaseURL points elsewhere, such as at a proxy or a local server
+	// speaking the same API.
+	local bool
+
+	new func(settings aiProviderSettings) AIProvider
+}
+
+// aiProviders is the registry of providers by name
+var aiProviders = map[AIProviderName]aiProviderSpec{
+	ProviderOpenAI: {
+		baseURL:        "https://api.openai.com/v1",
+		model:          "gpt-3.5-turbo",
+		embeddingModel: "text-embedding-3-small",
+		maxTokens:      1000,
+		new:            newOpenAIProvider,
+	},
+	ProviderGrok: {
+		baseURL:   "https://api.x.ai/v1",
+		model:     "grok-beta",
+		maxTokens: 1000,
+		new:       newOpenAIProvider,
+	},
+	ProviderGemini: {
+		baseURL:        "https://generativelanguage.googleapis.com/v1beta",
+		model:          "gemini-pro",
+		embeddingModel: "text-embedding-004",
+		maxTokens:      1000,
+		new:            newGeminiProvider,
+	},
+	ProviderAnthropic: {
+		baseURL:   "https://api.anthropic.com/v1",
+		model:     "claude-3-5-haiku-latest",
+		maxTokens: 1000,
+		new:       newAnthropicProvider,
+	},
+	ProviderOllama: {
+		baseURL:        "http://localhost:11434",
+		model:          "llama3.2",
+		embeddingModel: "nomic-embed-text",
+		maxTokens:      1000,
+		local:          true,
+		new:            newOllamaProvider,
+	},
+	ProviderLlamaCpp: {
+		baseURL:   "http://localhost:8080/v1",
+		model:     "default",
+		maxTokens: 1000,
+		local:     true,
+		new:       newOpenAIProvider,
+	},
+	ProviderOpenAICompatible: {
+		maxTokens: 1000,
+		local:     true,
+		new:       newOpenAIProvider,
+	},
+}
+
+// newAIProvider creates the provider config names, with the given client
+func newAIProvider(config *AIConfig, client *aiClient) (AIProvider, error) {
+	spec, ok := aiProviders[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported AI provider: %s", config.Provider)
+	}
+	if !spec.local && config.APIKey == "" && config.BaseURL == "" {
+		return nil, fmt.Errorf("the %s AI provider needs an API key", config.Provider)
+	}
+
+	settings := aiProviderSettings{
+		apiKey:         config.APIKey,
+		baseURL:        strings.TrimSuffix(cmp.Or(config.BaseURL, spec.baseURL), "/"),
+		model:          cmp.Or(config.Model, spec.model),
+		embeddingModel: cmp.Or(config.EmbeddingModel, spec.embeddingModel),
+		maxTokens:      config.MaxTokens,
+		client:         client,
+	}
+	if settings.baseURL == "" {
+		return nil, fmt.Errorf("the %s AI provider needs a base URL", config.Provider)
+	}
+	if settings.maxTokens <= 0 {
+		settings.maxTokens = spec.maxTokens
+	}
+	return spec.new(settings), nil
+}
+
+// aiClient sends requests to providers, retrying those refused with 429 or a
+// 5xx status with exponential backoff
+type aiClient struct {
+	client *http.Client
+
+	// streamClient has no overall timeout, since a streamed completion
+	// may take longer than the timeout; only the wait for its first byte
+	// is limited
+	streamClient *http.Client
+
+	retries int
+	// backoff is the wait before the first retry, doubled for each one
+	// after it
+	backoff time.Duration
+}
+
+// newAIClient creates a client whose requests time out after timeout
+func newAIClient(timeout time.Duration, retries int) *aiClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+
+	return &aiClient{
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		streamClient: &http.Client{
+			Transport: transport,
+		},
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+	}
+}
+
+// postJSON posts a JSON body and decodes the JSON response into out
+func (c *aiClient) postJSON(ctx context.Context, url string, headers map[string]string, body, out interface{}) error {
+	resp, err := c.post(ctx, c.client, url, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// postStream posts a JSON body and returns the response body to read the
+// stream from
+func (c *aiClient) postStream(ctx context.Context, url string, headers map[string]string, body interface{}) (io.ReadCloser, error) {
+	streamHeaders := map[string]string{"Accept": "text/event-stream"}
+	for name, value := range headers {
+		streamHeaders[name] = value
+	}
+
+	resp, err := c.post(ctx, c.streamClient, url, streamHeaders, body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// post posts a JSON body with client until the provider accepts it or the
+// retries run out, and returns the successful response
+func (c *aiClient) post(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxStreamEventSize))
+		resp.Body.Close()
+		err = fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+		if attempt >= c.retries || !retryableStatus(resp.StatusCode) {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(c.retryDelay(attempt, resp.Header.Get("Retry-After"))):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryDelay is the wait before retrying after the given attempt: what the
+// provider asked for with Retry-After, or the backoff doubled for each
+// earlier retry with up to a quarter added at random so clients refused
+// together do not retry together
+func (c *aiClient) retryDelay(attempt int, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		delay := time.Duration(seconds) * time.Second
+		if delay > maxAIRetryDelay {
+			return maxAIRetryDelay
+		}
+		return delay
+	}
+
+	delay := c.backoff << attempt
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/4 + 1))
+	}
+	if delay <= 0 || delay > maxAIRetryDelay {
+		return maxAIRetryDelay
+	}
+	return delay
+}
+
+// retryableStatus reports whether a request refused with status may succeed
+// when sent again
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAIService_Providers(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *AIConfig
+		enabled bool
+		err     string
+	}{
+		{name: "hosted provider without a key", config: &AIConfig{Provider: ProviderAnthropic}, err: "needs an API key"},
+		{name: "hosted provider at a local URL", config: &AIConfig{Provider: ProviderOpenAI, BaseURL: "http://localhost:8000/v1"}, enabled: true},
+		{name: "ollama without a key", config: &AIConfig{Provider: ProviderOllama}, enabled: true},
+		{name: "llama.cpp without a key", config: &AIConfig{Provider: ProviderLlamaCpp}, enabled: true},
+		{name: "compatible server without a URL", config: &AIConfig{Provider: ProviderOpenAICompatible}, err: "needs a base URL"},
+		{name: "unknown provider", config: &AIConfig{Provider: "unknown", APIKey: "key"}, err: "unsupported AI provider"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewAIService(nil, tt.config)
+			assert.Equal(t, tt.enabled, service.IsEnabled())
+			if tt.err != "" {
+				require.Error(t, service.ProviderError())
+				assert.Contains(t, service.ProviderError().Error(), tt.err)
+				_, err := service.callAI(context.Background(), "Say hello")
+				assert.Equal(t, service.ProviderError(), err)
+			} else {
+				assert.NoError(t, service.ProviderError())
+			}
+		})
+	}
+
+	_, err := NewAIService(nil, nil).callAI(context.Background(), "Say hello")
+	assert.ErrorIs(t, err, ErrAIDisabled)
+}
+
+// fastRetries makes an OpenAI service retry without waiting
+func fastRetries(service *AIService) {
+	service.provider.(*openAIProvider).client.backoff = time.Millisecond
+}
+
+func TestAIService_Retry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy/chat/completions":
+			if requests.Add(1) <= 2 {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []map[string]interface{}{{"message": map[string]string{"content": "Hello"}}},
+			})
+		case "/down/chat/completions":
+			requests.Add(1)
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		default:
+			requests.Add(1)
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/busy"})
+	fastRetries(service)
+	completion, err := service.callAI(context.Background(), "Say hello")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Retries run out
+	requests.Store(0)
+	service = NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/down", MaxRetries: 1})
+	fastRetries(service)
+	_, err = service.streamAI(context.Background(), "Say hello", func(string) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Other errors are not retried
+	requests.Store(0)
+	service = NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/invalid"})
+	fastRetries(service)
+	_, err = service.callAI(context.Background(), "Say hello")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	requests.Store(0)
+	service = NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/down", MaxRetries: -1})
+	_, err = service.callAI(context.Background(), "Say hello")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestAIClient_RetryDelay(t *testing.T) {
+	client := newAIClient(time.Second, 3)
+
+	assert.Equal(t, 2*time.Second, client.retryDelay(0, "2"))
+	assert.Equal(t, maxAIRetryDelay, client.retryDelay(0, "3600"))
+
+	for attempt := 0; attempt < 3; attempt++ {
+		delay := client.retryDelay(attempt, "")
+		base := client.backoff << attempt
+		assert.GreaterOrEqual(t, delay, base)
+		assert.LessOrEqual(t, delay, base+base/4)
+	}
+	assert.Equal(t, maxAIRetryDelay, client.retryDelay(20, ""))
+}
+
+func TestAIService_Anthropic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-api-key"))
+		assert.Equal(t, anthropicVersion, r.Header.Get("anthropic-version"))
+
+		var body struct {
+			Model     string `json:"model"`
+			MaxTokens int    `json:"max_tokens"`
+			Stream    bool   `json:"stream"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "claude-3-5-haiku-latest", body.Model)
+		assert.Equal(t, 1000, body.MaxTokens)
+
+		if !body.Stream {
+			fmt.Fprint(w, `{"content": [{"type": "text", "text": "Hel"}, {"type": "text", "text": "lo"}]}`)
+			return
+		}
+		for _, event := range []string{
+			`{"type": "message_start", "message": {}}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}`,
+			`{"type": "ping"}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo"}}`,
+			`{"type": "message_stop"}`,
+		} {
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderAnthropic, APIKey: "key", BaseURL: server.URL})
+	completion, err := service.callAI(context.Background(), "Say hello")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+
+	completion, err = service.streamAI(context.Background(), "Say hello", func(string) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+	assert.False(t, service.CanEmbed())
+}
+
+func TestAIService_AnthropicStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n")
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderAnthropic, APIKey: "key", BaseURL: server.URL})
+	_, err := service.streamAI(context.Background(), "Say hello", func(string) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Overloaded")
+}
+
+func TestAIService_Ollama(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/api/chat":
+			var body struct {
+				Model   string `json:"model"`
+				Stream  bool   `json:"stream"`
+				Options struct {
+					NumPredict int `json:"num_predict"`
+				} `json:"options"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "llama3.2", body.Model)
+			assert.Equal(t, 200, body.Options.NumPredict)
+
+			if !body.Stream {
+				fmt.Fprint(w, `{"message": {"role": "assistant", "content": "Hello"}, "done": true}`)
+				return
+			}
+			fmt.Fprint(w, `{"message": {"content": "Hel"}, "done": false}`+"\n")
+			fmt.Fprint(w, `{"message": {"content": "lo"}, "done": false}`+"\n")
+			fmt.Fprint(w, `{"message": {"content": ""}, "done": true}`+"\n")
+		case "/api/embed":
+			var body struct {
+				Model string   `json:"model"`
+				Input []string `json:"input"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "nomic-embed-text", body.Model)
+			embeddings := make([][]float32, len(body.Input))
+			for i := range body.Input {
+				embeddings[i] = []float32{float32(i), 1}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": embeddings})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderOllama, BaseURL: server.URL + "/", MaxTokens: 200})
+	require.True(t, service.IsEnabled())
+
+	completion, err := service.callAI(context.Background(), "Say hello")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+
+	var deltas []string
+	completion, err = service.streamAI(context.Background(), "Say hello", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+	assert.Equal(t, []string{"Hel", "lo"}, deltas)
+
+	require.True(t, service.CanEmbed())
+	assert.Equal(t, "nomic-embed-text", service.EmbeddingModel())
+	vectors, err := service.Embed(context.Background(), []string{"first", "second"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0, 1}, {1, 1}}, vectors)
+}
@@ -1,12 +1,11 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"strings"
 	"time"
 
@@ -16,48 +15,73 @@ import (
 	"gorm.io/gorm"
 )
 
-// AIProvider represents different AI service providers
-type AIProvider string
-
-const (
-	ProviderOpenAI AIProvider = "openai"
-	ProviderGemini AIProvider = "gemini"
-	ProviderGrok   AIProvider = "grok"
-)
-
 // AIConfig holds configuration for AI providers
 type AIConfig struct {
-	Provider   AIProvider `json:"provider"`
+	Provider   AIProviderName `json:"provider"`
 	APIKey     string     `json:"api_key"`
 	BaseURL    string     `json:"base_url,omitempty"`
 	Model      string     `json:"model,omitempty"`
 	MaxTokens  int        `json:"max_tokens,omitempty"`
 	Timeout    int        `json:"timeout,omitempty"` // seconds
+	// EmbeddingModel is the model Embed uses; each provider has a default
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	// MaxRetries is how many times a request refused with 429 or a 5xx
+	// status is retried; zero uses the default and a negative value
+	// turns retries off
+	MaxRetries int `json:"max_retries,omitempty"`
+	// Quotas limits the tokens users and the server may use
+	Quotas AIQuotas `json:"quotas,omitempty"`
+	// InputPrice and OutputPrice override the price of Model in US
+	// dollars per million tokens, for models the built-in price list
+	// does not know
+	InputPrice  float64 `json:"input_price,omitempty"`
+	OutputPrice float64 `json:"output_price,omitempty"`
 }
 
 // AIService handles AI-powered features
 type AIService struct {
 	db       *gorm.DB
 	config   *AIConfig
-	client   *http.Client
+	provider AIProvider
 	enabled  bool
+	usage    *AIUsageService
+
+	// providerErr is why the configured provider could not be set up
+	providerErr error
 }
 
-// NewAIService creates a new AI service
+// NewAIService creates a new AI service with the provider config names. The
+// service is disabled when config is nil or the provider cannot be set up,
+// such as a hosted provider without an API key.
 func NewAIService(db *gorm.DB, config *AIConfig) *AIService {
+	service := &AIService{
+		db:     db,
+		config: config,
+	}
+	if config == nil {
+		if db != nil {
+			service.usage = NewAIUsageService(db, AIQuotas{})
+		}
+		return service
+	}
+	if db != nil {
+		service.usage = NewAIUsageService(db, config.Quotas)
+	}
+
 	timeout := 30 * time.Second
-	if config != nil && config.Timeout > 0 {
+	if config.Timeout > 0 {
 		timeout = time.Duration(config.Timeout) * time.Second
 	}
-
-	return &AIService{
-		db:      db,
-		config:  config,
-		enabled: config != nil && config.APIKey != "",
-		client: &http.Client{
-			Timeout: timeout,
-		},
+	retries := config.MaxRetries
+	if retries == 0 {
+		retries = defaultAIRetries
+	} else if retries < 0 {
+		retries = 0
 	}
+
+	service.provider, service.providerErr = newAIProvider(config, newAIClient(timeout, retries))
+	service.enabled = service.provider != nil
+	return service
 }
 
 // IsEnabled returns whether AI features are available
@@ -65,6 +89,18 @@ func (s *AIService) IsEnabled() bool {
 	return s.enabled
 }
 
+// Usage returns the service logging AI provider calls, or nil when there is
+// no database
+func (s *AIService) Usage() *AIUsageService {
+	return s.usage
+}
+
+// ProviderError returns why the configured provider could not be set up, or
+// nil
+func (s *AIService) ProviderError() error {
+	return s.providerErr
+}
+
 // TodoExtractionResult represents the result of AI todo extraction
 type TodoExtractionResult struct {
 	Todos  []ExtractedTodo `json:"todos"`
@@ -117,8 +153,9 @@ type Insight struct {
 	Data        map[string]interface{} `json:"data,omitempty"`
 }
 
-// ExtractTodosFromNote extracts todos from note content using AI
-func (s *AIService) ExtractTodosFromNote(ctx context.Context, noteContent models.JSONB) (*TodoExtractionResult, error) {
+// ExtractTodosFromNote extracts todos from note content using AI. It returns
+// ErrAIQuotaExceeded when the user has used up a quota.
+func (s *AIService) ExtractTodosFromNote(ctx context.Context, noteContent models.JSONB, userID uuid.UUID) (*TodoExtractionResult, error) {
 	if !s.enabled {
 		return &TodoExtractionResult{
 			Error: "AI service not available",
@@ -132,7 +169,10 @@ func (s *AIService) ExtractTodosFromNote(ctx context.Context, noteContent models
 	}
 
 	prompt := s.buildTodoExtractionPrompt(textContent)
-	response, err := s.callAI(ctx, prompt)
+	response, err := s.callAI(withAICaller(ctx, userID, AIFeatureTodos), prompt)
+	if errors.Is(err, ErrAIQuotaExceeded) {
+		return nil, err
+	}
 	if err != nil {
 		return &TodoExtractionResult{
 			Error: fmt.Sprintf("AI request failed: %v", err),
@@ -142,7 +182,8 @@ func (s *AIService) ExtractTodosFromNote(ctx context.Context, noteContent models
 	return s.parseTodoExtractionResponse(response)
 }
 
-// AnalyzePeopleMentions analyzes people mentions and relationships in content
+// AnalyzePeopleMentions analyzes people mentions and relationships in
+// content. It returns ErrAIQuotaExceeded when the user has used up a quota.
 func (s *AIService) AnalyzePeopleMentions(ctx context.Context, noteContent models.JSONB, userID uuid.UUID) (*PeopleAnalysisResult, error) {
 	if !s.enabled {
 		return &PeopleAnalysisResult{
@@ -163,7 +204,10 @@ func (s *AIService) AnalyzePeopleMentions(ctx context.Context, noteContent model
 	s.db.Where("user_id = ?", userID).Find(&existingPeople)
 
 	prompt := s.buildPeopleAnalysisPrompt(textContent, existingPeople)
-	response, err := s.callAI(ctx, prompt)
+	response, err := s.callAI(withAICaller(ctx, userID, AIFeaturePeople), prompt)
+	if errors.Is(err, ErrAIQuotaExceeded) {
+		return nil, err
+	}
 	if err != nil {
 		return &PeopleAnalysisResult{
 			Error: fmt.Sprintf("AI request failed: %v", err),
@@ -173,7 +217,8 @@ func (s *AIService) AnalyzePeopleMentions(ctx context.Context, noteContent model
 	return s.parsePeopleAnalysisResponse(response)
 }
 
-// GenerateInsights generates insights from user's knowledge base
+// GenerateInsights generates insights from user's knowledge base. It returns
+// ErrAIQuotaExceeded when the user has used up a quota.
 func (s *AIService) GenerateInsights(ctx context.Context, userID uuid.UUID, limit int) (*InsightResult, error) {
 	if !s.enabled {
 		return &InsightResult{
@@ -190,7 +235,10 @@ func (s *AIService) GenerateInsights(ctx context.Context, userID uuid.UUID, limi
 	}
 
 	prompt := s.buildInsightGenerationPrompt(userData)
-	response, err := s.callAI(ctx, prompt)
+	response, err := s.callAI(withAICaller(ctx, userID, AIFeatureInsights), prompt)
+	if errors.Is(err, ErrAIQuotaExceeded) {
+		return nil, err
+	}
 	if err != nil {
 		return &InsightResult{
 			Error: fmt.Sprintf("AI request failed: %v", err),
@@ -202,241 +250,79 @@ func (s *AIService) GenerateInsights(ctx context.Context, userID uuid.UUID, limi
 
 // callAI makes a request to the configured AI provider
 func (s *AIService) callAI(ctx context.Context, prompt string) (string, error) {
-	switch s.config.Provider {
-	case ProviderOpenAI:
-		return s.callOpenAI(ctx, prompt)
-	case ProviderGemini:
-		return s.callGemini(ctx, prompt)
-	case ProviderGrok:
-		return s.callGrok(ctx, prompt)
-	default:
-		return "", fmt.Errorf("unsupported AI provider: %s", s.config.Provider)
-	}
-}
-
-// callOpenAI makes a request to OpenAI API
-func (s *AIService) callOpenAI(ctx context.Context, prompt string) (string, error) {
-	baseURL := s.config.BaseURL
-	if baseURL == "" {
-		baseURL = "https://api.openai.com/v1"
-	}
-
-	model := s.config.Model
-	if model == "" {
-		model = "gpt-3.5-turbo"
-	}
-
-	maxTokens := s.config.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 1000
-	}
-
-	requestBody := map[string]interface{}{
-		"model": model,
-		"messages": []map[string]string{
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
-		"max_tokens":  maxTokens,
-		"temperature": 0.7,
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
-
-	resp, err := s.client.Do(req)
+	provider, err := s.activeProvider()
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var response struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response from AI")
+		return "", err
 	}
-
-	return response.Choices[0].Message.Content, nil
+	return s.metered(ctx, provider.Model(), prompt, func() (string, AITokens, error) {
+		return provider.Complete(ctx, prompt)
+	})
 }
 
-// callGemini makes a request to Google Gemini API
-func (s *AIService) callGemini(ctx context.Context, prompt string) (string, error) {
-	baseURL := s.config.BaseURL
-	if baseURL == "" {
-		baseURL = "https://generativelanguage.googleapis.com/v1beta"
-	}
-
-	model := s.config.Model
-	if model == "" {
-		model = "gemini-pro"
-	}
-
-	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]string{
-					{
-						"text": prompt,
-					},
-				},
-			},
-		},
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", baseURL, model, s.config.APIKey)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+// metered makes a provider call for the caller ctx is attributed to, after
+// checking their quotas, and logs its tokens, latency and cost. Tokens the
+// provider does not report are estimated from input and the output call
+// returns.
+func (s *AIService) metered(ctx context.Context, model, input string, call func() (string, AITokens, error)) (string, error) {
+	if s.usage == nil {
+		output, _, err := call()
+		return output, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+	caller := aiCallerFrom(ctx)
+	if caller.feature != AIFeatureIndexing {
+		if err := s.usage.CheckQuota(caller.userID); err != nil {
+			return "", err
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	start := time.Now()
+	output, tokens, err := call()
+	usage := &models.AIUsage{
+		UserID:           caller.userID,
+		Feature:          caller.feature,
+		Provider:         string(s.config.Provider),
+		Model:            model,
+		PromptTokens:     int64(tokens.Prompt),
+		CompletionTokens: int64(tokens.Completion),
+		LatencyMs:        float64(time.Since(start).Microseconds()) / 1000,
+	}
+	// A call that failed before the model saw the prompt used no tokens
+	if tokens == (AITokens{}) && (err == nil || output != "") {
+		usage.PromptTokens = int64(estimateTokens(input))
+		usage.CompletionTokens = int64(estimateTokens(output))
+		usage.Estimated = true
+	}
+	price := s.price(model)
+	usage.CostUSD = (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var response struct {
-		Candidates []struct {
-			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-		} `json:"candidates"`
+		usage.ErrorMessage = err.Error()
 	}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	if recordErr := s.usage.Record(usage); recordErr != nil {
+		log.Printf("Failed to record AI usage: %v", recordErr)
 	}
-
-	if len(response.Candidates) == 0 || len(response.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from AI")
-	}
-
-	return response.Candidates[0].Content.Parts[0].Text, nil
+	return output, err
 }
 
-// callGrok makes a request to Grok API (X.AI)
-func (s *AIService) callGrok(ctx context.Context, prompt string) (string, error) {
-	baseURL := s.config.BaseURL
-	if baseURL == "" {
-		baseURL = "https://api.x.ai/v1"
-	}
-
-	model := s.config.Model
-	if model == "" {
-		model = "grok-beta"
-	}
-
-	maxTokens := s.config.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 1000
-	}
-
-	requestBody := map[string]interface{}{
-		"model": model,
-		"messages": []map[string]string{
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
-		"max_tokens":  maxTokens,
-		"temperature": 0.7,
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+// price returns what model costs, as the config sets it for the chat model
+// or from the built-in price list
+func (s *AIService) price(model string) AIPrice {
+	if (s.config.InputPrice > 0 || s.config.OutputPrice > 0) && model == s.provider.Model() {
+		return AIPrice{Input: s.config.InputPrice, Output: s.config.OutputPrice}
 	}
+	return aiModelPrices[model]
+}
 
-	var response struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+// activeProvider returns the configured provider, or why there is none
+func (s *AIService) activeProvider() (AIProvider, error) {
+	if s.provider != nil {
+		return s.provider, nil
 	}
-
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	if s.providerErr != nil {
+		return nil, s.providerErr
 	}
-
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response from AI")
-	}
-
-	return response.Choices[0].Message.Content, nil
+	return nil, ErrAIDisabled
 }
 
 // Helper methods for building prompts and parsing responses
@@ -152,7 +152,7 @@ func TestAIService_ExtractTodosFromNote_Disabled(t *testing.T) {
 		},
 	}
 
-	result, err := service.ExtractTodosFromNote(context.Background(), content)
+	result, err := service.ExtractTodosFromNote(context.Background(), content, uuid.New())
 	require.NoError(t, err)
 	assert.Equal(t, "AI service not available", result.Error)
 	assert.Empty(t, result.Todos)
@@ -191,7 +191,7 @@ func TestAIService_CallOpenAI_MockServer(t *testing.T) {
 	}
 	service := NewAIService(db, config)
 
-	result, err := service.callOpenAI(context.Background(), "test prompt")
+	result, err := service.callAI(context.Background(), "test prompt")
 	require.NoError(t, err)
 	assert.Contains(t, result, "Complete the project")
 }
@@ -233,7 +233,7 @@ func TestAIService_CallGemini_MockServer(t *testing.T) {
 	}
 	service := NewAIService(db, config)
 
-	result, err := service.callGemini(context.Background(), "test prompt")
+	result, err := service.callAI(context.Background(), "test prompt")
 	require.NoError(t, err)
 	assert.Contains(t, result, "John Doe")
 }
@@ -271,7 +271,7 @@ func TestAIService_CallGrok_MockServer(t *testing.T) {
 	}
 	service := NewAIService(db, config)
 
-	result, err := service.callGrok(context.Background(), "test prompt")
+	result, err := service.callAI(context.Background(), "test prompt")
 	require.NoError(t, err)
 	assert.Contains(t, result, "Meeting Pattern")
 }
@@ -332,7 +332,7 @@ func TestAIService_ExtractTodosFromNote_WithMockAI(t *testing.T) {
 		},
 	}
 
-	result, err := service.ExtractTodosFromNote(context.Background(), content)
+	result, err := service.ExtractTodosFromNote(context.Background(), content, createTestUser(t, db).ID)
 	require.NoError(t, err)
 	assert.Empty(t, result.Error)
 	assert.Len(t, result.Todos, 2)
@@ -657,7 +657,7 @@ func TestAIService_ErrorHandling(t *testing.T) {
 			}
 			service := NewAIService(db, config)
 
-			_, err := service.callOpenAI(context.Background(), "test prompt")
+			_, err := service.callAI(context.Background(), "test prompt")
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -694,7 +694,7 @@ func TestAIService_ContextTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
 	defer cancel()
 
-	_, err := service.callOpenAI(ctx, "test prompt")
+	_, err := service.callAI(ctx, "test prompt")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "context deadline exceeded")
 }
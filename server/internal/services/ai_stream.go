@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxStreamEventSize caps the size of one server-sent event from a provider
+const maxStreamEventSize = 1 << 20
+
+// errStopStream is returned by an event callback to end a stream early
+// without an error
+var errStopStream = errors.New("stop stream")
+
+// StreamFunc receives each piece of a completion as it arrives. Returning an
+// error stops the stream with that error.
+type StreamFunc func(delta string) error
+
+// streamAI streams a completion from the configured provider, passing each
+// piece to onDelta, and returns the whole completion. The stream stops when
+// ctx is cancelled.
+func (s *AIService) streamAI(ctx context.Context, prompt string, onDelta StreamFunc) (string, error) {
+	var completion strings.Builder
+	collect := func(delta string) error {
+		if delta == "" {
+			return nil
+		}
+		completion.WriteString(delta)
+		return onDelta(delta)
+	}
+
+	provider, err := s.activeProvider()
+	if err != nil {
+		return "", err
+	}
+	// The completion so far is metered even when the stream fails
+	answer, err := s.metered(ctx, provider.Model(), prompt, func() (string, AITokens, error) {
+		tokens, err := provider.Stream(ctx, prompt, collect)
+		return completion.String(), tokens, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return answer, nil
+}
+
+// readServerSentEvents calls onData with the data of each event of a
+// server-sent event stream until the stream ends or onData returns
+// errStopStream
+func readServerSentEvents(r io.Reader, onData func(data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamEventSize)
+
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		event := strings.Join(data, "\n")
+		data = data[:0]
+		return onData(event)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatch(); err == errStopStream {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+	if err := dispatch(); err != nil && err != errStopStream {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamChatServer serves chat completions as server-sent deltas, one per
+// piece, waiting delay between them. After the pieces it waits for hold to
+// close, if set, before ending the stream. Requests that end early are
+// reported on cancelled.
+func streamChatServer(t *testing.T, pieces []string, delay time.Duration, hold chan struct{}) (*httptest.Server, chan struct{}) {
+	t.Helper()
+
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.True(t, body.Stream)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range pieces {
+			data, _ := json.Marshal(map[string]interface{}{
+				"choices": []map[string]interface{}{{"delta": map[string]string{"content": piece}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			time.Sleep(delay)
+		}
+		if hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+				return
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server, cancelled
+}
+
+func TestAIService_StreamChatCompletions(t *testing.T) {
+	// Streams may run longer than the request timeout
+	server, _ := streamChatServer(t, []string{"Hel", "", "lo"}, 600*time.Millisecond, nil)
+
+	for _, provider := range []AIProviderName{ProviderOpenAI, ProviderGrok} {
+		service := NewAIService(nil, &AIConfig{Provider: provider, APIKey: "key", BaseURL: server.URL, Timeout: 1})
+
+		var deltas []string
+		completion, err := service.streamAI(context.Background(), "Say hello", func(delta string) error {
+			deltas = append(deltas, delta)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Hello", completion)
+		assert.Equal(t, []string{"Hel", "lo"}, deltas)
+	}
+}
+
+func TestAIService_StreamGemini(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/models/gemini-pro:streamGenerateContent?alt=sse&key=key", r.URL.String())
+		for _, piece := range []string{"Hel", "lo"} {
+			fmt.Fprintf(w, "data: {\"candidates\": [{\"content\": {\"parts\": [{\"text\": %q}]}}]}\r\n\r\n", piece)
+		}
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderGemini, APIKey: "key", BaseURL: server.URL})
+	completion, err := service.streamAI(context.Background(), "Say hello", func(string) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion)
+}
+
+func TestAIService_StreamCancel(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	server, cancelled := streamChatServer(t, []string{"Hel"}, 0, hold)
+	service := NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := service.streamAI(ctx, "Say hello", func(string) error {
+		cancel()
+		return nil
+	})
+	assert.Error(t, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider request was not cancelled")
+	}
+}
+
+func TestAIService_StreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	service := NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL})
+	_, err := service.streamAI(context.Background(), "Say hello", func(string) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+}
+
+func TestReadServerSentEvents(t *testing.T) {
+	stream := ": keep-alive\n\nevent: message\ndata: first\ndata: line\n\ndata:second\n\ndata: [DONE]\n\ndata: ignored\n\n"
+
+	var events []string
+	err := readServerSentEvents(strings.NewReader(stream), func(data string) error {
+		if data == "[DONE]" {
+			return errStopStream
+		}
+		events = append(events, data)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first\nline", "second"}, events)
+
+	// The last event needs no blank line after it
+	events = nil
+	require.NoError(t, readServerSentEvents(strings.NewReader("data: only"), func(data string) error {
+		events = append(events, data)
+		return nil
+	}))
+	assert.Equal(t, []string{"only"}, events)
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The features AI provider calls are made for
+const (
+	AIFeatureTodos    = "todos"
+	AIFeaturePeople   = "people"
+	AIFeatureInsights = "insights"
+	AIFeatureAsk      = "ask"
+	AIFeatureSearch   = "search"
+	// AIFeatureIndexing is the background embedding of notes for semantic
+	// search. It is metered but not held to quotas, since a user over
+	// their quota would otherwise stall indexing for everyone.
+	AIFeatureIndexing = "indexing"
+	// AIFeatureOther is any call not made for a user's request
+	AIFeatureOther = "other"
+)
+
+// ErrAIQuotaExceeded is returned instead of calling the provider when a user
+// or the server has used up a token quota
+var ErrAIQuotaExceeded = errors.New("AI usage quota exceeded")
+
+// AIQuotas limits the tokens AI provider calls may use per calendar day and
+// month in UTC, for each user and for the server as a whole. Zero means no
+// limit.
+type AIQuotas struct {
+	UserDailyTokens   int64 `json:"user_daily_tokens,omitempty"`
+	UserMonthlyTokens int64 `json:"user_monthly_tokens,omitempty"`
+	DailyTokens       int64 `json:"daily_tokens,omitempty"`
+	MonthlyTokens     int64 `json:"monthly_tokens,omitempty"`
+}
+
+// AIPrice is what a model costs in US dollars per million tokens
+type AIPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// aiModelPrices are the list prices of the default models of hosted
+// providers, used to estimate costs. Other models, including local ones, are
+// free unless AIConfig sets a price.
+var aiModelPrices = map[string]AIPrice{
+	"gpt-3.5-turbo":            {Input: 0.50, Output: 1.50},
+	"gpt-4o":                   {Input: 2.50, Output: 10},
+	"gpt-4o-mini":              {Input: 0.15, Output: 0.60},
+	"text-embedding-3-small":   {Input: 0.02},
+	"text-embedding-3-large":   {Input: 0.13},
+	"claude-3-5-haiku-latest":  {Input: 0.80, Output: 4},
+	"claude-3-5-sonnet-latest": {Input: 3, Output: 15},
+	"gemini-pro":               {Input: 0.50, Output: 1.50},
+	"grok-beta":                {Input: 5, Output: 15},
+}
+
+// aiCallerKey is the context key of the user and feature an AI provider call
+// is made for
+type aiCallerKey struct{}
+
+type aiCaller struct {
+	userID  *uuid.UUID
+	feature string
+}
+
+// withAICaller attributes the AI provider calls made with ctx to a user and
+// feature. A context that is already attributed keeps its caller, so a call
+// made on the way to answering a question counts towards the question.
+func withAICaller(ctx context.Context, userID uuid.UUID, feature string) context.Context {
+	if _, ok := ctx.Value(aiCallerKey{}).(aiCaller); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, aiCallerKey{}, aiCaller{userID: &userID, feature: feature})
+}
+
+// aiCallerFrom returns who an AI provider call made with ctx is for. Calls
+// made on nobody's behalf have no user.
+func aiCallerFrom(ctx context.Context) aiCaller {
+	if caller, ok := ctx.Value(aiCallerKey{}).(aiCaller); ok {
+		return caller
+	}
+	return aiCaller{feature: AIFeatureOther}
+}
+
+// estimateTokens estimates the tokens of text at about four characters each
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// AIUsageService logs the calls made to AI providers and holds users and the
+// server to their token quotas
+type AIUsageService struct {
+	db     *gorm.DB
+	quotas AIQuotas
+}
+
+// NewAIUsageService creates an AI usage service enforcing quotas
+func NewAIUsageService(db *gorm.DB, quotas AIQuotas) *AIUsageService {
+	return &AIUsageService{db: db, quotas: quotas}
+}
+
+// AIUsageTotals sums a set of AI provider calls
+type AIUsageTotals struct {
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+}
+
+// AIUsageBreakdown is the usage of one feature or model
+type AIUsageBreakdown struct {
+	Name string `json:"name"`
+	AIUsageTotals
+}
+
+// AIUserUsage is the usage of one user
+type AIUserUsage struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	AIUsageTotals
+}
+
+// AIQuotaStatus is how much of a quota is used in the current period
+type AIQuotaStatus struct {
+	Scope     string    `json:"scope"`  // "user" or "global"
+	Period    string    `json:"period"` // "daily" or "monthly"
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// AIUsageReport reports on AI provider calls since a point in time, for one
+// user or, when UserID is nil, for the whole server
+type AIUsageReport struct {
+	UserID    *uuid.UUID         `json:"user_id,omitempty"`
+	Since     time.Time          `json:"since"`
+	Totals    AIUsageTotals      `json:"totals"`
+	ByFeature []AIUsageBreakdown `json:"by_feature"`
+	ByModel   []AIUsageBreakdown `json:"by_model"`
+	ByUser    []AIUserUsage      `json:"by_user,omitempty"`
+	Quotas    []AIQuotaStatus    `json:"quotas"`
+}
+
+// aiUsageTotalsSelect sums AI usage rows into AIUsageTotals
+const aiUsageTotalsSelect = `COUNT(*) AS requests,
+	COALESCE(SUM(CASE WHEN ai_usage.error_message <> '' THEN 1 ELSE 0 END), 0) AS errors,
+	COALESCE(SUM(ai_usage.prompt_tokens), 0) AS prompt_tokens,
+	COALESCE(SUM(ai_usage.completion_tokens), 0) AS completion_tokens,
+	COALESCE(SUM(ai_usage.total_tokens), 0) AS total_tokens,
+	COALESCE(SUM(ai_usage.cost_usd), 0) AS cost_usd,
+	COALESCE(AVG(ai_usage.latency_ms), 0) AS avg_latency_ms`
+
+// Record logs a provider call
+func (s *AIUsageService) Record(usage *models.AIUsage) error {
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if err := s.db.Create(usage).Error; err != nil {
+		return fmt.Errorf("failed to record AI usage: %w", err)
+	}
+	return nil
+}
+
+// CheckQuota returns ErrAIQuotaExceeded when the user, or the server, has
+// used up a quota for the current day or month. userID is nil for calls made
+// on nobody's behalf, which are only held to the server's quotas.
+func (s *AIUsageService) CheckQuota(userID *uuid.UUID) error {
+	statuses, err := s.quotaStatuses(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Remaining <= 0 {
+			return fmt.Errorf("%w: %s %s limit of %d tokens reached", ErrAIQuotaExceeded, status.Scope, status.Period, status.Limit)
+		}
+	}
+	return nil
+}
+
+// Report reports on the user's AI usage since a point in time, with the
+// quotas that apply to them
+func (s *AIUsageService) Report(userID uuid.UUID, since time.Time) (*AIUsageReport, error) {
+	scope := func(db *gorm.DB) *gorm.DB {
+		return db.Where("ai_usage.user_id = ? AND ai_usage.created_at >= ?", userID, since)
+	}
+	report, err := s.report(scope, since)
+	if err != nil {
+		return nil, err
+	}
+	report.UserID = &userID
+
+	if report.Quotas, err = s.quotaStatuses(&userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ServerReport reports on all AI usage since a point in time, with up to
+// limit users using the most tokens and the server's quotas
+func (s *AIUsageService) ServerReport(since time.Time, limit int) (*AIUsageReport, error) {
+	scope := func(db *gorm.DB) *gorm.DB {
+		return db.Where("ai_usage.created_at >= ?", since)
+	}
+	report, err := s.report(scope, since)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ByUser = []AIUserUsage{}
+	if err := s.db.Model(&models.AIUsage{}).Scopes(scope).
+		Select("ai_usage.user_id, users.username, " + aiUsageTotalsSelect).
+		Joins("JOIN users ON users.id = ai_usage.user_id").
+		Group("ai_usage.user_id, users.username").
+		Order("total_tokens DESC").
+		Limit(limit).
+		Scan(&report.ByUser).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum AI usage by user: %w", err)
+	}
+
+	if report.Quotas, err = s.quotaStatuses(nil, time.Now()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// report sums the usage scope selects, overall and by feature and model
+func (s *AIUsageService) report(scope func(*gorm.DB) *gorm.DB, since time.Time) (*AIUsageReport, error) {
+	report := &AIUsageReport{Since: since, ByFeature: []AIUsageBreakdown{}, ByModel: []AIUsageBreakdown{}}
+
+	if err := s.db.Model(&models.AIUsage{}).Scopes(scope).
+		Select(aiUsageTotalsSelect).
+		Scan(&report.Totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum AI usage: %w", err)
+	}
+	for column, breakdown := range map[string]*[]AIUsageBreakdown{"feature": &report.ByFeature, "model": &report.ByModel} {
+		if err := s.db.Model(&models.AIUsage{}).Scopes(scope).
+			Select("ai_usage." + column + " AS name, " + aiUsageTotalsSelect).
+			Group("ai_usage." + column).
+			Order("total_tokens DESC").
+			Scan(breakdown).Error; err != nil {
+			return nil, fmt.Errorf("failed to sum AI usage by %s: %w", column, err)
+		}
+	}
+	return report, nil
+}
+
+// quotaStatuses reports on the quotas that are set: the user's, when userID
+// is set, and the server's
+func (s *AIUsageService) quotaStatuses(userID *uuid.UUID, now time.Time) ([]AIQuotaStatus, error) {
+	statuses := []AIQuotaStatus{}
+	if userID != nil && (s.quotas.UserDailyTokens > 0 || s.quotas.UserMonthlyTokens > 0) {
+		scoped, err := s.periodStatuses("user", userID, s.quotas.UserDailyTokens, s.quotas.UserMonthlyTokens, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, scoped...)
+	}
+	if s.quotas.DailyTokens > 0 || s.quotas.MonthlyTokens > 0 {
+		scoped, err := s.periodStatuses("global", nil, s.quotas.DailyTokens, s.quotas.MonthlyTokens, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, scoped...)
+	}
+	return statuses, nil
+}
+
+// periodStatuses reports on the daily and monthly quotas of a scope that are
+// set, summing the tokens of the user, or of everyone when userID is nil
+func (s *AIUsageService) periodStatuses(scope string, userID *uuid.UUID, daily, monthly int64, now time.Time) ([]AIQuotaStatus, error) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var used struct {
+		Day   int64
+		Month int64
+	}
+	query := s.db.Model(&models.AIUsage{}).
+		Select(`COALESCE(SUM(CASE WHEN ai_usage.created_at >= ? THEN ai_usage.total_tokens ELSE 0 END), 0) AS day,
+			COALESCE(SUM(ai_usage.total_tokens), 0) AS month`, dayStart).
+		Where("ai_usage.created_at >= ?", monthStart)
+	if userID != nil {
+		query = query.Where("ai_usage.user_id = ?", *userID)
+	}
+	if err := query.Scan(&used).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum AI usage for quotas: %w", err)
+	}
+
+	var statuses []AIQuotaStatus
+	if daily > 0 {
+		statuses = append(statuses, AIQuotaStatus{
+			Scope: scope, Period: "daily", Limit: daily, Used: used.Day,
+			Remaining: remainingTokens(daily, used.Day), ResetsAt: dayStart.AddDate(0, 0, 1),
+		})
+	}
+	if monthly > 0 {
+		statuses = append(statuses, AIQuotaStatus{
+			Scope: scope, Period: "monthly", Limit: monthly, Used: used.Month,
+			Remaining: remainingTokens(monthly, used.Month), ResetsAt: monthStart.AddDate(0, 1, 0),
+		})
+	}
+	return statuses, nil
+}
+
+// remainingTokens is what is left of limit after used, never below zero
+func remainingTokens(limit, used int64) int64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIProviders_Tokens(t *testing.T) {
+	tests := []struct {
+		provider AIProviderName
+		complete string
+		stream   []string
+	}{
+		{
+			provider: ProviderOpenAI,
+			complete: `{"choices": [{"message": {"content": "Hello"}}], "usage": {"prompt_tokens": 12, "completion_tokens": 3}}`,
+			stream: []string{
+				`data: {"choices": [{"delta": {"content": "Hello"}}]}`,
+				`data: {"choices": [], "usage": {"prompt_tokens": 12, "completion_tokens": 3}}`,
+				`data: [DONE]`,
+			},
+		},
+		{
+			provider: ProviderGemini,
+			complete: `{"candidates": [{"content": {"parts": [{"text": "Hello"}]}}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 3}}`,
+			stream: []string{
+				`data: {"candidates": [{"content": {"parts": [{"text": "Hel"}]}}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 1}}`,
+				`data: {"candidates": [{"content": {"parts": [{"text": "lo"}]}}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 3}}`,
+			},
+		},
+		{
+			provider: ProviderAnthropic,
+			complete: `{"content": [{"type": "text", "text": "Hello"}], "usage": {"input_tokens": 12, "output_tokens": 3}}`,
+			stream: []string{
+				`data: {"type": "message_start", "message": {"usage": {"input_tokens": 12, "output_tokens": 1}}}`,
+				`data: {"type": "content_block_delta", "delta": {"type": "text_delta", "text": "Hello"}}`,
+				`data: {"type": "message_delta", "usage": {"output_tokens": 3}}`,
+				`data: {"type": "message_stop"}`,
+			},
+		},
+		{
+			provider: ProviderOllama,
+			complete: `{"message": {"content": "Hello"}, "done": true, "prompt_eval_count": 12, "eval_count": 3}`,
+			stream: []string{
+				`{"message": {"content": "Hello"}, "done": false}`,
+				`{"message": {"content": ""}, "done": true, "prompt_eval_count": 12, "eval_count": 3}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.provider), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Stream bool `json:"stream"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				if !body.Stream && r.URL.Query().Get("alt") != "sse" {
+					fmt.Fprint(w, tt.complete)
+					return
+				}
+				for _, line := range tt.stream {
+					fmt.Fprint(w, line+"\n\n")
+				}
+			}))
+			defer server.Close()
+
+			service := NewAIService(nil, &AIConfig{Provider: tt.provider, APIKey: "key", BaseURL: server.URL})
+			completion, tokens, err := service.provider.Complete(context.Background(), "Say hello")
+			require.NoError(t, err)
+			assert.Equal(t, "Hello", completion)
+			assert.Equal(t, AITokens{Prompt: 12, Completion: 3}, tokens)
+
+			tokens, err = service.provider.Stream(context.Background(), "Say hello", func(string) error { return nil })
+			require.NoError(t, err)
+			assert.Equal(t, AITokens{Prompt: 12, Completion: 3}, tokens)
+		})
+	}
+}
+
+func TestAIService_Metering(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat/completions":
+			fmt.Fprint(w, `{"choices": [{"message": {"content": "Hello"}}], "usage": {"prompt_tokens": 1000, "completion_tokens": 200}}`)
+		case "/unmetered/chat/completions":
+			fmt.Fprint(w, `{"choices": [{"message": {"content": "Hello there"}}]}`)
+		default:
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	db := setupAITestDB(t)
+	user := createTestUser(t, db)
+	ctx := withAICaller(context.Background(), user.ID, AIFeatureTodos)
+
+	service := NewAIService(db, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL})
+	_, err := service.callAI(ctx, "Say hello")
+	require.NoError(t, err)
+
+	var usage models.AIUsage
+	require.NoError(t, db.Order("created_at DESC").First(&usage).Error)
+	require.NotNil(t, usage.UserID)
+	assert.Equal(t, user.ID, *usage.UserID)
+	assert.Equal(t, AIFeatureTodos, usage.Feature)
+	assert.Equal(t, "openai", usage.Provider)
+	assert.Equal(t, "gpt-3.5-turbo", usage.Model)
+	assert.Equal(t, int64(1000), usage.PromptTokens)
+	assert.Equal(t, int64(200), usage.CompletionTokens)
+	assert.Equal(t, int64(1200), usage.TotalTokens)
+	assert.False(t, usage.Estimated)
+	assert.Greater(t, usage.LatencyMs, 0.0)
+	assert.InDelta(t, (1000*0.50+200*1.50)/1e6, usage.CostUSD, 1e-12)
+
+	// Tokens the provider does not report are estimated, at the configured
+	// price
+	service = NewAIService(db, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/unmetered", Model: "house-model", InputPrice: 1, OutputPrice: 2})
+	_, err = service.callAI(ctx, "Say hello")
+	require.NoError(t, err)
+	usage = models.AIUsage{}
+	require.NoError(t, db.Where("model = ?", "house-model").First(&usage).Error)
+	assert.True(t, usage.Estimated)
+	assert.Equal(t, int64(estimateTokens("Say hello")), usage.PromptTokens)
+	assert.Equal(t, int64(estimateTokens("Hello there")), usage.CompletionTokens)
+	assert.InDelta(t, float64(usage.PromptTokens*1+usage.CompletionTokens*2)/1e6, usage.CostUSD, 1e-12)
+
+	// Failed calls are logged with their error
+	service = NewAIService(db, &AIConfig{Provider: ProviderOpenAI, APIKey: "key", BaseURL: server.URL + "/invalid", Model: "failing-model"})
+	_, err = service.callAI(context.Background(), "Say hello")
+	require.Error(t, err)
+	usage = models.AIUsage{}
+	require.NoError(t, db.Where("model = ?", "failing-model").First(&usage).Error)
+	assert.Nil(t, usage.UserID)
+	assert.Equal(t, AIFeatureOther, usage.Feature)
+	assert.NotEmpty(t, usage.ErrorMessage)
+	assert.Zero(t, usage.TotalTokens)
+}
+
+func TestAIService_Quotas(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/chat/completions":
+			fmt.Fprint(w, `{"choices": [{"message": {"content": "Hello"}}], "usage": {"prompt_tokens": 80, "completion_tokens": 20}}`)
+		case "/embeddings":
+			fmt.Fprint(w, `{"data": [{"index": 0, "embedding": [1, 0]}], "usage": {"prompt_tokens": 5}}`)
+		}
+	}))
+	defer server.Close()
+
+	db := setupAITestDB(t)
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	carol := createTestUser(t, db)
+	service := NewAIService(db, &AIConfig{
+		Provider: ProviderOpenAI,
+		APIKey:   "key",
+		BaseURL:  server.URL,
+		Quotas:   AIQuotas{UserDailyTokens: 100, MonthlyTokens: 200},
+	})
+
+	_, err := service.callAI(withAICaller(context.Background(), alice.ID, AIFeatureAsk), "Say hello")
+	require.NoError(t, err)
+
+	// Alice has used her daily tokens
+	_, err = service.callAI(withAICaller(context.Background(), alice.ID, AIFeatureAsk), "Say hello")
+	assert.ErrorIs(t, err, ErrAIQuotaExceeded)
+	assert.Contains(t, err.Error(), "user daily limit")
+	assert.Equal(t, int32(1), requests.Load())
+
+	_, err = service.ExtractTodosFromNote(context.Background(), models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "text", "text": "Call Bob"},
+	}}, alice.ID)
+	assert.ErrorIs(t, err, ErrAIQuotaExceeded)
+
+	// but indexing her notes is not held to quotas
+	_, err = service.Embed(withAICaller(context.Background(), alice.ID, AIFeatureIndexing), []string{"note"})
+	require.NoError(t, err)
+
+	_, err = service.callAI(withAICaller(context.Background(), bob.ID, AIFeatureAsk), "Say hello")
+	require.NoError(t, err)
+
+	// The server has used its monthly tokens
+	_, err = service.callAI(withAICaller(context.Background(), carol.ID, AIFeatureAsk), "Say hello")
+	assert.ErrorIs(t, err, ErrAIQuotaExceeded)
+	assert.Contains(t, err.Error(), "global monthly limit")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestAIUsageService_Report(t *testing.T) {
+	db := setupAITestDB(t)
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	usage := NewAIUsageService(db, AIQuotas{UserMonthlyTokens: 1000, DailyTokens: 5000})
+
+	now := time.Now()
+	for _, record := range []models.AIUsage{
+		{UserID: &alice.ID, Feature: AIFeatureAsk, Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 50, CostUSD: 0.01, LatencyMs: 100},
+		{UserID: &alice.ID, Feature: AIFeatureSearch, Model: "text-embedding-3-small", PromptTokens: 10, LatencyMs: 20},
+		{UserID: &alice.ID, Feature: AIFeatureAsk, Model: "gpt-4o", ErrorMessage: "timeout", LatencyMs: 300},
+		{UserID: &alice.ID, Feature: AIFeatureAsk, Model: "gpt-4o", PromptTokens: 999, CreatedAt: now.AddDate(0, 0, -60)},
+		{UserID: &bob.ID, Feature: AIFeatureInsights, Model: "gpt-4o", PromptTokens: 400, CompletionTokens: 100, CostUSD: 0.02},
+		{Feature: AIFeatureIndexing, Model: "text-embedding-3-small", PromptTokens: 40},
+	} {
+		record := record
+		require.NoError(t, usage.Record(&record))
+	}
+
+	since := now.AddDate(0, 0, -30)
+	report, err := usage.Report(alice.ID, since)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), report.Totals.Requests)
+	assert.Equal(t, int64(1), report.Totals.Errors)
+	assert.Equal(t, int64(110), report.Totals.PromptTokens)
+	assert.Equal(t, int64(160), report.Totals.TotalTokens)
+	assert.InDelta(t, 0.01, report.Totals.CostUSD, 1e-9)
+	assert.InDelta(t, 140.0, report.Totals.AvgLatencyMs, 1e-9)
+	require.Len(t, report.ByFeature, 2)
+	assert.Equal(t, AIFeatureAsk, report.ByFeature[0].Name)
+	assert.Equal(t, int64(2), report.ByFeature[0].Requests)
+	require.Len(t, report.ByModel, 2)
+	assert.Equal(t, "gpt-4o", report.ByModel[0].Name)
+	assert.Empty(t, report.ByUser)
+
+	require.Len(t, report.Quotas, 2)
+	assert.Equal(t, AIQuotaStatus{
+		Scope: "user", Period: "monthly", Limit: 1000,
+		Used:      report.Quotas[0].Used,
+		Remaining: 1000 - report.Quotas[0].Used,
+		ResetsAt:  report.Quotas[0].ResetsAt,
+	}, report.Quotas[0])
+	assert.Equal(t, "global", report.Quotas[1].Scope)
+	assert.Equal(t, "daily", report.Quotas[1].Period)
+	assert.Equal(t, int64(700), report.Quotas[1].Used)
+	assert.True(t, report.Quotas[1].ResetsAt.After(now))
+
+	server, err := usage.ServerReport(since, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), server.Totals.Requests)
+	assert.Equal(t, int64(700), server.Totals.TotalTokens)
+	require.Len(t, server.ByUser, 1)
+	assert.Equal(t, bob.ID, server.ByUser[0].UserID)
+	assert.Equal(t, bob.Username, server.ByUser[0].Username)
+	assert.Equal(t, int64(500), server.ByUser[0].TotalTokens)
+	require.Len(t, server.Quotas, 1)
+	assert.Nil(t, server.UserID)
+
+	// Nobody is over a quota
+	assert.NoError(t, usage.CheckQuota(&alice.ID))
+	other := uuid.New()
+	assert.NoError(t, usage.CheckQuota(&other))
+}
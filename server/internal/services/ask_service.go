@@ -0,0 +1,510 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// askSources is how many note passages an answer is grounded in
+	askSources = 6
+
+	// askPeople caps how many people are described to the model
+	askPeople = 5
+
+	// askHistoryMessages is how many earlier messages of a session are sent
+	// with a follow-up question
+	askHistoryMessages = 6
+
+	// askKeywordLimit caps how many words of a question are searched for
+	askKeywordLimit = 12
+
+	// chatTitleLength is how much of its first question names a session
+	chatTitleLength = 80
+)
+
+var (
+	// ErrAIDisabled is returned when no AI provider is configured
+	ErrAIDisabled = errors.New("AI service not available")
+
+	// ErrChatSessionNotFound is returned for sessions that do not exist or
+	// belong to someone else
+	ErrChatSessionNotFound = errors.New("chat session not found")
+)
+
+// citationPattern matches the source markers of an answer, such as [2] or
+// [1, 3]
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citationMarkerPattern matches a source marker with the spaces before it
+var citationMarkerPattern = regexp.MustCompile(`\s*` + citationPattern.String())
+
+// askStopWords are left out of the keyword search for a question
+var askStopWords = map[string]bool{
+	"the": true, "and": true, "not": true, "for": true, "with": true, "about": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true, "whom": true,
+	"why": true, "how": true, "did": true, "does": true, "was": true, "were": true,
+	"are": true, "has": true, "have": true, "had": true, "can": true, "could": true,
+	"should": true, "would": true, "will": true, "this": true, "that": true, "these": true,
+	"those": true, "there": true, "from": true, "into": true, "any": true, "all": true,
+	"you": true, "your": true, "our": true, "their": true, "they": true, "them": true,
+	"his": true, "her": true, "its": true, "say": true, "said": true, "tell": true,
+	"know": true, "notes": true, "note": true,
+}
+
+// AskService answers questions about a user's notes. It retrieves the
+// passages most relevant to a question, asks the AI provider to answer from
+// them alone, and keeps the conversation so that follow-up questions can
+// refer to earlier ones.
+type AskService struct {
+	db          *gorm.DB
+	ai          *AIService
+	embeddings  *EmbeddingService
+	search      *SearchService
+	workspaceID *uuid.UUID
+}
+
+// NewAskService creates an ask service. Passages are found by keyword alone
+// when embeddings is nil or has no embedder.
+func NewAskService(db *gorm.DB, ai *AIService, embeddings *EmbeddingService) *AskService {
+	if embeddings == nil {
+		embeddings = NewEmbeddingService(db, nil)
+	}
+	return &AskService{
+		db:         db,
+		ai:         ai,
+		embeddings: embeddings,
+		search:     NewSearchService(db),
+	}
+}
+
+// InWorkspace returns a copy of the service answering from the notes of a
+// workspace, or from the user's personal and shared notes when workspaceID is
+// nil
+func (s *AskService) InWorkspace(workspaceID *uuid.UUID) *AskService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	scoped.embeddings = s.embeddings.InWorkspace(workspaceID)
+	scoped.search = s.search.InWorkspace(workspaceID)
+	return &scoped
+}
+
+// AskPerson is a person relevant to a question
+type AskPerson struct {
+	ID      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	Company string    `json:"company,omitempty"`
+	Title   string    `json:"title,omitempty"`
+}
+
+// AskResponse is the answer to a question
+type AskResponse struct {
+	SessionID uuid.UUID        `json:"session_id"`
+	MessageID uuid.UUID        `json:"message_id"`
+	Answer    string           `json:"answer"`
+	Citations models.Citations `json:"citations"`
+	People    []AskPerson      `json:"people"`
+}
+
+// askSource is a note passage an answer may cite
+type askSource struct {
+	note    models.Note
+	passage string
+	start   int
+	end     int
+}
+
+// Ask answers a question from the user's notes. Without a session ID a new
+// session is started, titled after the question.
+func (s *AskService) Ask(ctx context.Context, userID uuid.UUID, sessionID *uuid.UUID, question string) (*AskResponse, error) {
+	ctx = withAICaller(ctx, userID, AIFeatureAsk)
+	turn, err := s.prepare(ctx, userID, sessionID, question)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := s.ai.callAI(ctx, turn.prompt)
+	if err != nil {
+		return nil, fmt.Errorf("AI request failed: %w", err)
+	}
+	return s.save(turn, answer)
+}
+
+// AskStream answers a question like Ask, passing the answer to onDelta as
+// it is written. Nothing is saved when the stream fails or ctx is cancelled
+// before the answer is complete.
+func (s *AskService) AskStream(ctx context.Context, userID uuid.UUID, sessionID *uuid.UUID, question string, onDelta StreamFunc) (*AskResponse, error) {
+	ctx = withAICaller(ctx, userID, AIFeatureAsk)
+	turn, err := s.prepare(ctx, userID, sessionID, question)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := s.ai.streamAI(ctx, turn.prompt, onDelta)
+	if err != nil {
+		return nil, fmt.Errorf("AI request failed: %w", err)
+	}
+	return s.save(turn, answer)
+}
+
+// askTurn is a question ready to send to the AI provider
+type askTurn struct {
+	session  models.ChatSession
+	question string
+	sources  []askSource
+	people   []AskPerson
+	prompt   string
+}
+
+// prepare finds or starts the session of a question and retrieves what the
+// answer is grounded in
+func (s *AskService) prepare(ctx context.Context, userID uuid.UUID, sessionID *uuid.UUID, question string) (*askTurn, error) {
+	turn := &askTurn{question: strings.TrimSpace(question)}
+	if s.ai == nil || !s.ai.IsEnabled() {
+		return nil, ErrAIDisabled
+	}
+
+	var history []models.ChatMessage
+	if sessionID != nil {
+		found, err := s.Session(userID, *sessionID)
+		if err != nil {
+			return nil, err
+		}
+		turn.session = *found
+		if len(turn.session.Messages) > askHistoryMessages {
+			history = turn.session.Messages[len(turn.session.Messages)-askHistoryMessages:]
+		} else {
+			history = turn.session.Messages
+		}
+	} else {
+		turn.session = models.ChatSession{UserID: userID, WorkspaceID: s.workspaceID, Title: chatTitle(turn.question)}
+	}
+
+	// A follow-up question is searched for along with the one before it,
+	// which usually names what "it" or "they" refer to
+	retrieval := turn.question
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == models.ChatRoleUser {
+			retrieval = history[i].Content + "\n" + turn.question
+			break
+		}
+	}
+
+	var err error
+	if turn.sources, err = s.retrieve(ctx, userID, retrieval); err != nil {
+		return nil, err
+	}
+	if turn.people, err = s.relevantPeople(userID, retrieval, turn.sources); err != nil {
+		return nil, err
+	}
+	turn.prompt = buildAskPrompt(turn.question, history, turn.sources, turn.people)
+	return turn, nil
+}
+
+// save records a question and its answer in the session, citing the sources
+// the answer refers to
+func (s *AskService) save(turn *askTurn, answer string) (*AskResponse, error) {
+	answer = strings.TrimSpace(answer)
+	session := turn.session
+
+	reply := models.ChatMessage{Role: models.ChatRoleAssistant, Content: answer, Citations: citeSources(answer, turn.sources)}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if session.ID == uuid.Nil {
+			if err := tx.Create(&session).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&session).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		asked := models.ChatMessage{SessionID: session.ID, Role: models.ChatRoleUser, Content: turn.question}
+		if err := tx.Create(&asked).Error; err != nil {
+			return err
+		}
+		reply.SessionID = session.ID
+		return tx.Create(&reply).Error
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save chat messages: %w", err)
+	}
+
+	return &AskResponse{
+		SessionID: session.ID,
+		MessageID: reply.ID,
+		Answer:    answer,
+		Citations: reply.Citations,
+		People:    turn.people,
+	}, nil
+}
+
+// Sessions lists the user's chat sessions, most recently active first
+func (s *AskService) Sessions(userID uuid.UUID) ([]models.ChatSession, error) {
+	sessions := []models.ChatSession{}
+	if err := s.db.Scopes(OwnedBy("chat_sessions", userID, s.workspaceID)).
+		Where("chat_sessions.user_id = ?", userID).
+		Order("updated_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get chat sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Session returns a chat session with its messages in order. Sessions are
+// personal, even in a workspace.
+func (s *AskService) Session(userID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	var session models.ChatSession
+	err := s.db.Scopes(OwnedBy("chat_sessions", userID, s.workspaceID)).
+		Where("chat_sessions.user_id = ?", userID).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("chat_messages.created_at, chat_messages.role DESC")
+		}).
+		First(&session, "chat_sessions.id = ?", sessionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat session: %w", err)
+	}
+	return &session, nil
+}
+
+// DeleteSession deletes a chat session and its messages
+func (s *AskService) DeleteSession(userID, sessionID uuid.UUID) error {
+	session, err := s.Session(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.ID).Delete(&models.ChatMessage{}).Error; err != nil {
+			return fmt.Errorf("failed to delete chat messages: %w", err)
+		}
+		if err := tx.Delete(session).Error; err != nil {
+			return fmt.Errorf("failed to delete chat session: %w", err)
+		}
+		return nil
+	})
+}
+
+// retrieve finds the note passages most relevant to a question: by meaning
+// and keyword when notes are embedded, otherwise by keyword alone
+func (s *AskService) retrieve(ctx context.Context, userID uuid.UUID, question string) ([]askSource, error) {
+	keywords := askKeywords(question)
+	keywordQuery := strings.Join(keywords, " OR ")
+
+	type match struct {
+		note    models.Note
+		passage string
+	}
+	var matches []match
+	if s.embeddings.Enabled() {
+		results, err := s.embeddings.fuse(ctx, userID, keywordQuery, question, askSources)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			matches = append(matches, match{note: result.Note, passage: result.Passage})
+		}
+	} else if keywordQuery != "" {
+		response, err := s.search.FullTextSearch(userID, SearchRequest{Query: keywordQuery, Limit: askSources, SortBy: "relevance"})
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range response.Results {
+			matches = append(matches, match{note: result.Note})
+		}
+	}
+
+	sources := make([]askSource, len(matches))
+	for i, m := range matches {
+		sources[i] = passageSource(m.note, m.passage, keywords)
+	}
+	return sources, nil
+}
+
+// passageSource locates the passage of a note to cite. An embedded passage
+// is used while the note's text still contains it; otherwise the passage
+// mentioning the most keywords is.
+func passageSource(note models.Note, passage string, keywords []string) askSource {
+	source := askSource{note: note}
+	text := plainNoteText(&note)
+	if text == "" {
+		return source
+	}
+
+	prefix := strings.TrimSpace(note.Title) + "\n\n"
+	passage = strings.TrimPrefix(passage, prefix)
+	if passage == "" || !strings.Contains(text, passage) {
+		passage = ""
+		best := -1
+		for _, chunk := range noteChunks(&note) {
+			chunk = strings.TrimPrefix(chunk, prefix)
+			lower := strings.ToLower(chunk)
+			hits := 0
+			for _, keyword := range keywords {
+				if strings.Contains(lower, keyword) {
+					hits++
+				}
+			}
+			if hits > best {
+				passage, best = chunk, hits
+			}
+		}
+	}
+
+	offset := strings.Index(text, passage)
+	if offset < 0 {
+		return source
+	}
+	source.passage = passage
+	source.start = utf8.RuneCountInString(text[:offset])
+	source.end = source.start + utf8.RuneCountInString(passage)
+	return source
+}
+
+// relevantPeople returns the people mentioned in the source notes or named in
+// the question
+func (s *AskService) relevantPeople(userID uuid.UUID, question string, sources []askSource) ([]AskPerson, error) {
+	noteIDs := make([]uuid.UUID, len(sources))
+	for i, source := range sources {
+		noteIDs[i] = source.note.ID
+	}
+
+	conditions := []string{`people.id IN (SELECT target_id FROM connections
+		WHERE source_type = 'note' AND target_type = 'person' AND source_id IN ?)`}
+	args := []interface{}{noteIDs}
+	for _, keyword := range askKeywords(question) {
+		conditions = append(conditions, "LOWER(people.name) LIKE ? OR LOWER(people.name) LIKE ?")
+		args = append(args, keyword+"%", "% "+keyword+"%")
+	}
+
+	people := []AskPerson{}
+	if err := s.db.Model(&models.Person{}).
+		Scopes(OwnedBy("people", userID, s.workspaceID)).
+		Where("("+strings.Join(conditions, " OR ")+")", args...).
+		Select("people.id, people.name, people.company, people.title").
+		Order("people.name").
+		Limit(askPeople).
+		Scan(&people).Error; err != nil {
+		return nil, fmt.Errorf("failed to find people: %w", err)
+	}
+	return people, nil
+}
+
+// buildAskPrompt asks the model to answer from the numbered sources alone
+func buildAskPrompt(question string, history []models.ChatMessage, sources []askSource, people []AskPerson) string {
+	var b strings.Builder
+	b.WriteString(`You answer questions about the user's personal notes. Answer only from the sources below. ` +
+		`Cite the sources you use with their numbers in square brackets, like [1] or [2, 3]. ` +
+		`If the sources do not contain the answer, say that the notes do not say.` + "\n\n")
+
+	b.WriteString("Sources:\n")
+	if len(sources) == 0 {
+		b.WriteString("(no matching notes)\n")
+	}
+	for i, source := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, source.note.Title)
+		if source.passage != "" {
+			b.WriteString(source.passage + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if len(people) > 0 {
+		b.WriteString("\nPeople:\n")
+		for _, person := range people {
+			b.WriteString("- " + person.Name)
+			if details := strings.Trim(strings.Join([]string{person.Title, person.Company}, ", "), ", "); details != "" {
+				b.WriteString(" (" + details + ")")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if len(history) > 0 {
+		b.WriteString("\nConversation so far:\n")
+		for _, message := range history {
+			role := "User"
+			if message.Role == models.ChatRoleAssistant {
+				role = "Assistant"
+			}
+			// Earlier answers cited other sources, so their markers are dropped
+			content := strings.TrimSpace(citationMarkerPattern.ReplaceAllString(message.Content, ""))
+			b.WriteString(role + ": " + content + "\n")
+		}
+	}
+
+	b.WriteString("\nQuestion: " + question + "\n")
+	return b.String()
+}
+
+// citeSources returns a citation for each source an answer cites, in the
+// order they are first cited
+func citeSources(answer string, sources []askSource) models.Citations {
+	citations := models.Citations{}
+	cited := make(map[int]bool)
+	for _, marker := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		for _, number := range strings.Split(marker[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(number))
+			if err != nil || n < 1 || n > len(sources) || cited[n] {
+				continue
+			}
+			cited[n] = true
+			source := sources[n-1]
+			citations = append(citations, models.Citation{
+				Source:  n,
+				NoteID:  source.note.ID,
+				Title:   source.note.Title,
+				Snippet: source.passage,
+				Start:   source.start,
+				End:     source.end,
+			})
+		}
+	}
+	return citations
+}
+
+// askKeywords returns the distinct words of a question worth searching for,
+// lowercased
+func askKeywords(question string) []string {
+	words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < 3 || askStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+		if len(keywords) == askKeywordLimit {
+			break
+		}
+	}
+	return keywords
+}
+
+// plainNoteText is the text of a note's text nodes, with runs of spaces
+// collapsed. Citation offsets count characters of this text.
+func plainNoteText(note *models.Note) string {
+	var words []string
+	collectWords(map[string]interface{}(note.Content), &words)
+	return strings.Join(words, " ")
+}
+
+// chatTitle names a session after its first question
+func chatTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if utf8.RuneCountInString(title) <= chatTitleLength {
+		return title
+	}
+	return strings.TrimSpace(string([]rune(title)[:chatTitleLength-1])) + "…"
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupAskTest starts a mock chat completions server that answers by citing
+// the source titled cite, and returns an AI service using it and a pointer to
+// the last prompt
+func setupAskTest(t *testing.T, cite string) (*AIService, *string) {
+	t.Helper()
+
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		prompt = body.Messages[0].Content
+
+		answer := "The notes do not say."
+		if match := regexp.MustCompile(`\[(\d+)\] ` + regexp.QuoteMeta(cite) + "\n").FindStringSubmatch(prompt); match != nil {
+			answer = "Prefer modern patterns [" + match[1] + "]. See also [" + match[1] + ", 99]."
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": answer}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "test-key", BaseURL: server.URL}), &prompt
+}
+
+func TestAskService_Ask(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	ai, prompt := setupAskTest(t, "JavaScript Best Practices")
+	service := NewAskService(db, ai, nil)
+	ctx := context.Background()
+
+	var note models.Note
+	require.NoError(t, db.Where("title = ?", "JavaScript Best Practices").First(&note).Error)
+	person := models.Person{UserID: user.ID, Name: "Ana Lopez", Company: "Acme"}
+	require.NoError(t, db.Create(&person).Error)
+	require.NoError(t, db.Create(&models.Connection{
+		UserID: user.ID, SourceID: note.ID, SourceType: "note", TargetID: person.ID, TargetType: "person",
+	}).Error)
+
+	response, err := service.Ask(ctx, user.ID, nil, "What are the JavaScript best practices?")
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, response.SessionID)
+	assert.Contains(t, *prompt, "Modern JavaScript development practices and patterns")
+	assert.Contains(t, *prompt, "Ana Lopez (Acme)")
+
+	// Repeated and unknown markers are cited once or not at all
+	require.Len(t, response.Citations, 1)
+	citation := response.Citations[0]
+	assert.Equal(t, note.ID, citation.NoteID)
+	assert.Equal(t, "Modern JavaScript development practices and patterns", citation.Snippet)
+	assert.Equal(t, 0, citation.Start)
+	assert.Equal(t, len(citation.Snippet), citation.End)
+	assert.Equal(t, []AskPerson{{ID: person.ID, Name: "Ana Lopez", Company: "Acme"}}, response.People)
+
+	// Follow-ups see the conversation, without its citation markers
+	followUp, err := service.Ask(ctx, user.ID, &response.SessionID, "Which of them apply to TypeScript?")
+	require.NoError(t, err)
+	assert.Equal(t, response.SessionID, followUp.SessionID)
+	assert.Contains(t, *prompt, "Conversation so far:\nUser: What are the JavaScript best practices?\nAssistant: Prefer modern patterns. See also.\n")
+
+	session, err := service.Session(user.ID, response.SessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "What are the JavaScript best practices?", session.Title)
+	require.Len(t, session.Messages, 4)
+	assert.Equal(t, models.ChatRoleUser, session.Messages[0].Role)
+	assert.Equal(t, models.ChatRoleAssistant, session.Messages[1].Role)
+	assert.Equal(t, response.Citations, session.Messages[1].Citations)
+	assert.Equal(t, "Which of them apply to TypeScript?", session.Messages[2].Content)
+
+	// Sessions are private
+	other := createTestUser(t, db)
+	_, err = service.Session(other.ID, response.SessionID)
+	assert.ErrorIs(t, err, ErrChatSessionNotFound)
+	_, err = service.Ask(ctx, other.ID, &response.SessionID, "Anything?")
+	assert.ErrorIs(t, err, ErrChatSessionNotFound)
+
+	sessions, err := service.Sessions(user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	require.NoError(t, service.DeleteSession(user.ID, response.SessionID))
+	sessions, err = service.Sessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	var messages int64
+	db.Model(&models.ChatMessage{}).Count(&messages)
+	assert.Zero(t, messages)
+}
+
+func TestAskService_CitationOffsets(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	ai, _ := setupAskTest(t, "Long Report")
+	ctx := context.Background()
+
+	words := make([]string, 300)
+	for i := range words {
+		words[i] = "filler"
+	}
+	words[250] = "quarterly"
+	words[251] = "budget"
+	note := models.Note{UserID: user.ID, Title: "Long Report", Content: models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": strings.Join(words, " ")},
+		}},
+	}}}
+	require.NoError(t, db.Create(&note).Error)
+
+	// Passages are found by keyword, and by meaning once notes are embedded
+	for _, embeddings := range []*EmbeddingService{nil, NewEmbeddingService(db, &wordEmbedder{})} {
+		if embeddings != nil {
+			_, err := embeddings.IndexPending(ctx, 50)
+			require.NoError(t, err)
+		}
+		response, err := NewAskService(db, ai, embeddings).Ask(ctx, user.ID, nil, "What was the quarterly budget?")
+		require.NoError(t, err)
+		require.Len(t, response.Citations, 1)
+
+		citation := response.Citations[0]
+		assert.Equal(t, note.ID, citation.NoteID)
+		assert.Contains(t, citation.Snippet, "quarterly budget")
+		assert.Positive(t, citation.Start, "the passage is the note's second")
+		text := []rune(plainNoteText(&note))
+		assert.Equal(t, citation.Snippet, string(text[citation.Start:citation.End]))
+	}
+}
+
+func TestAskService_Disabled(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewAskService(db, NewAIService(db, nil), nil)
+
+	_, err := service.Ask(context.Background(), user.ID, nil, "What are my notes about?")
+	assert.ErrorIs(t, err, ErrAIDisabled)
+}
+
+func TestAskKeywords(t *testing.T) {
+	assert.Equal(t, []string{"ana", "budget", "2026"}, askKeywords(`What did Ana say about the budget -- in "2026" or budget?`))
+	assert.Empty(t, askKeywords("Who is it?"))
+}
+
+func TestAskService_AskStream(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	server, _ := streamChatServer(t, []string{"It is in ", "a tutorial [1]."}, 0, nil)
+	ai := NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "test-key", BaseURL: server.URL})
+	service := NewAskService(db, ai, nil)
+
+	var streamed strings.Builder
+	response, err := service.AskStream(context.Background(), user.ID, nil, "Where is the Go programming tutorial?", func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "It is in a tutorial [1].", streamed.String())
+	assert.Equal(t, streamed.String(), response.Answer)
+	require.Len(t, response.Citations, 1)
+	assert.Equal(t, "Go Programming Tutorial", response.Citations[0].Title)
+
+	session, err := service.Session(user.ID, response.SessionID)
+	require.NoError(t, err)
+	assert.Len(t, session.Messages, 2)
+
+	// Cancelled answers are not saved
+	hold := make(chan struct{})
+	defer close(hold)
+	server, _ = streamChatServer(t, []string{"It is"}, 0, hold)
+	service = NewAskService(db, NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, APIKey: "test-key", BaseURL: server.URL}), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = service.AskStream(ctx, user.ID, &response.SessionID, "And Python?", func(string) error {
+		cancel()
+		return nil
+	})
+	assert.Error(t, err)
+	session, err = service.Session(user.ID, response.SessionID)
+	require.NoError(t, err)
+	assert.Len(t, session.Messages, 2)
+}
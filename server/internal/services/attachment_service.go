@@ -0,0 +1,333 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrUploadTooLarge means an upload exceeded the configured size limit
+var ErrUploadTooLarge = errors.New("file exceeds the maximum upload size")
+
+// AttachmentService stores uploaded files, links them to notes and removes
+// the ones no note uses any more
+type AttachmentService struct {
+	db      *gorm.DB
+	storage Storage
+	shares  *ShareService
+	maxSize int64
+}
+
+// NewAttachmentService creates an attachment service that keeps file
+// contents in storage and rejects uploads larger than maxSize bytes. A
+// maxSize of 0 means no limit.
+func NewAttachmentService(db *gorm.DB, storage Storage, maxSize int64) *AttachmentService {
+	return &AttachmentService{
+		db:      db,
+		storage: storage,
+		shares:  NewShareService(db),
+		maxSize: maxSize,
+	}
+}
+
+// MaxSize returns the upload size limit in bytes, or 0 for no limit
+func (s *AttachmentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload stores a file for a user in a workspace, or in their personal space
+// when workspaceID is nil. Content is stored once per hash, and uploading
+// the same file again in the same space returns the existing attachment.
+func (s *AttachmentService) Upload(userID uuid.UUID, workspaceID *uuid.UUID, filename, contentType string, r io.Reader) (*models.Attachment, error) {
+	// Spool to disk first: the storage key is the content hash, which is only
+	// known once the whole upload has been read
+	tmp, err := os.CreateTemp("", "notesage-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	reader := r
+	if s.maxSize > 0 {
+		reader = io.LimitReader(r, s.maxSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	// Cleanup must not delete the file, or the attachment reused for it,
+	// between the lookup and the new or reused row
+	unlock := lockBlob(sum)
+	defer unlock()
+
+	var existing models.Attachment
+	err = s.db.Scopes(OwnedBy("attachments", userID, workspaceID)).
+		Where("sha256 = ?", sum).First(&existing).Error
+	if err == nil {
+		// Uploading it again restarts its grace period
+		existing.CreatedAt = time.Now()
+		if err := s.db.Model(&existing).UpdateColumn("created_at", existing.CreatedAt).Error; err != nil {
+			return nil, fmt.Errorf("failed to reuse attachment: %w", err)
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if contentType == "" || contentType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, _ := tmp.ReadAt(head, 0)
+		contentType = http.DetectContentType(head[:n])
+	}
+
+	attachment := models.Attachment{
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		Filename:    filepath.Base(filename),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+	}
+	if err := attachment.Validate(); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.storage.Exists(sum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check storage: %w", err)
+	}
+	if !stored {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := s.storage.Put(sum, tmp); err != nil {
+			return nil, fmt.Errorf("failed to store file: %w", err)
+		}
+	}
+
+	if err := s.db.Create(&attachment).Error; err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// GetAttachment returns an attachment the user can see, with a reader for
+// its contents that the caller must close
+func (s *AttachmentService) GetAttachment(userID, attachmentID uuid.UUID) (*models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.authorize(userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Open(attachment.SHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// ListNoteAttachments returns the attachments linked to a note
+func (s *AttachmentService) ListNoteAttachments(userID, noteID uuid.UUID) ([]models.Attachment, error) {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleViewer); err != nil {
+		return nil, err
+	}
+
+	var attachments []models.Attachment
+	err := s.db.Joins("JOIN note_attachments ON note_attachments.attachment_id = attachments.id").
+		Where("note_attachments.note_id = ?", noteID).
+		Order("note_attachments.created_at").Find(&attachments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// LinkToNote records that a note uses an attachment. The user must be able to
+// edit the note and see the attachment. Linking twice is not an error.
+func (s *AttachmentService) LinkToNote(userID, noteID, attachmentID uuid.UUID) error {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleEditor); err != nil {
+		return err
+	}
+	if _, err := s.authorize(userID, attachmentID); err != nil {
+		return err
+	}
+
+	link := models.NoteAttachment{NoteID: noteID, AttachmentID: attachmentID}
+	return s.db.Where(link).FirstOrCreate(&link).Error
+}
+
+// UnlinkFromNote removes an attachment from a note. The attachment itself is
+// removed by the cleanup job once nothing links to it.
+func (s *AttachmentService) UnlinkFromNote(userID, noteID, attachmentID uuid.UUID) error {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleEditor); err != nil {
+		return err
+	}
+
+	result := s.db.Where("note_id = ? AND attachment_id = ?", noteID, attachmentID).Delete(&models.NoteAttachment{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CleanupUnreferenced deletes attachments older than gracePeriod that no note
+// links to, and removes stored files no attachment uses any more. The grace
+// period leaves time to link a fresh upload to its note.
+func (s *AttachmentService) CleanupUnreferenced(gracePeriod time.Duration) (int, error) {
+	cutoff := time.Now().Add(-gracePeriod)
+	var orphans []models.Attachment
+	err := s.db.Scopes(unreferencedBefore(cutoff)).Find(&orphans).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find unreferenced attachments: %w", err)
+	}
+
+	removed := 0
+	for i := range orphans {
+		deleted, err := s.removeAttachment(&orphans[i], cutoff)
+		if err != nil {
+			return removed, err
+		}
+		if deleted {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// unreferencedBefore matches attachments created before cutoff that no note
+// links to
+func unreferencedBefore(cutoff time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("created_at < ?", cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM note_attachments WHERE note_attachments.attachment_id = attachments.id)")
+	}
+}
+
+// removeAttachment deletes an attachment that is still unreferenced and
+// created before cutoff, and its stored file when no other attachment shares
+// it. It reports whether the attachment was deleted: an upload may have
+// reused it since cleanup listed it.
+func (s *AttachmentService) removeAttachment(attachment *models.Attachment, cutoff time.Time) (bool, error) {
+	unlock := lockBlob(attachment.SHA256)
+	defer unlock()
+
+	result := s.db.Scopes(unreferencedBefore(cutoff)).Delete(attachment)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	var users int64
+	if err := s.db.Model(&models.Attachment{}).Where("sha256 = ?", attachment.SHA256).Count(&users).Error; err != nil {
+		return false, err
+	}
+	if users == 0 {
+		if err := s.storage.Delete(attachment.SHA256); err != nil {
+			return false, fmt.Errorf("failed to delete stored file: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// blobLocks holds a lock per stored file hash, so that an upload reusing a
+// file and cleanup deleting it never interleave
+var blobLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// lockBlob locks the stored file with hash sum and returns the matching
+// unlock function
+func lockBlob(sum string) func() {
+	blobLocks.Lock()
+	lock, exists := blobLocks.locks[sum]
+	if !exists {
+		lock = &sync.Mutex{}
+		blobLocks.locks[sum] = lock
+	}
+	blobLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// RunCleanupJob removes unreferenced attachments every interval until the
+// process exits
+func (s *AttachmentService) RunCleanupJob(interval, gracePeriod time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := s.CleanupUnreferenced(gracePeriod)
+		if err != nil {
+			log.Printf("Attachment cleanup failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("Removed %d unreferenced attachments", removed)
+		}
+	}
+}
+
+// authorize loads an attachment the user can see: their own personal
+// uploads, uploads in a workspace they belong to, and anything linked to a
+// note they can open. Others are reported as gorm.ErrRecordNotFound.
+func (s *AttachmentService) authorize(userID, attachmentID uuid.UUID) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.Where("id = ?", attachmentID).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+
+	if attachment.WorkspaceID != nil {
+		var count int64
+		if err := s.db.Model(&models.WorkspaceMember{}).
+			Where("workspace_id = ? AND user_id = ?", *attachment.WorkspaceID, userID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return &attachment, nil
+		}
+	} else if attachment.UserID == userID {
+		return &attachment, nil
+	}
+
+	var noteIDs []uuid.UUID
+	if err := s.db.Model(&models.NoteAttachment{}).Where("attachment_id = ?", attachmentID).
+		Pluck("note_id", &noteIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, noteID := range noteIDs {
+		if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleViewer); err == nil {
+			return &attachment, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
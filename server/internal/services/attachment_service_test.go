@@ -0,0 +1,197 @@
+package services
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestAttachmentService_UploadAndDedupe(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	service := NewAttachmentService(db, storage, 16)
+
+	owner := createTestUser(t, db)
+	other := createTestUser(t, db)
+
+	_, err = service.Upload(owner.ID, nil, "big.txt", "text/plain", strings.NewReader(strings.Repeat("x", 17)))
+	assert.ErrorIs(t, err, ErrUploadTooLarge)
+
+	first, err := service.Upload(owner.ID, nil, "hello.txt", "", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), first.Size)
+	assert.Equal(t, "text/plain; charset=utf-8", first.ContentType)
+
+	// The same content in the same space is the same attachment
+	again, err := service.Upload(owner.ID, nil, "copy.txt", "", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	// Another user gets their own attachment backed by the same file
+	theirs, err := service.Upload(other.ID, nil, "hello.txt", "", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, theirs.ID)
+	assert.Equal(t, first.SHA256, theirs.SHA256)
+
+	_, content, err := service.GetAttachment(owner.ID, first.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(content)
+	content.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, _, err = service.GetAttachment(other.ID, first.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestAttachmentService_LinksAndCleanup(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	service := NewAttachmentService(db, storage, 0)
+
+	owner := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	note := createTestNote(t, db, owner.ID)
+	_, err = NewShareService(db).ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+
+	linked, err := service.Upload(owner.ID, nil, "diagram.png", "image/png", strings.NewReader("png"))
+	require.NoError(t, err)
+	orphan, err := service.Upload(owner.ID, nil, "draft.txt", "text/plain", strings.NewReader("draft"))
+	require.NoError(t, err)
+
+	// Viewers can read linked attachments but not link new ones
+	assert.ErrorIs(t, service.LinkToNote(viewer.ID, note.ID, linked.ID), ErrNoteAccessDenied)
+	require.NoError(t, service.LinkToNote(owner.ID, note.ID, linked.ID))
+	require.NoError(t, service.LinkToNote(owner.ID, note.ID, linked.ID))
+
+	attachments, err := service.ListNoteAttachments(viewer.ID, note.ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	_, content, err := service.GetAttachment(viewer.ID, linked.ID)
+	require.NoError(t, err)
+	content.Close()
+
+	// Fresh uploads survive the grace period
+	removed, err := service.CleanupUnreferenced(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	removed, err = service.CleanupUnreferenced(-time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	exists, err := storage.Exists(orphan.SHA256)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, service.UnlinkFromNote(owner.ID, note.ID, linked.ID))
+	removed, err = service.CleanupUnreferenced(-time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	exists, err = storage.Exists(linked.SHA256)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// racingStorage runs cleanup in the middle of an upload, right after the
+// upload finds the file already stored
+type racingStorage struct {
+	Storage
+	cleanup func()
+}
+
+func (s *racingStorage) Exists(key string) (bool, error) {
+	exists, err := s.Storage.Exists(key)
+	if cleanup := s.cleanup; cleanup != nil {
+		s.cleanup = nil
+		done := make(chan struct{})
+		go func() {
+			cleanup()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return exists, err
+}
+
+func TestAttachmentService_CleanupDuringUpload(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	local, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	storage := &racingStorage{Storage: local}
+	service := NewAttachmentService(db, storage, 0)
+
+	owner := createTestUser(t, db)
+	other := createTestUser(t, db)
+	orphan, err := service.Upload(owner.ID, nil, "draft.txt", "text/plain", strings.NewReader("draft"))
+	require.NoError(t, err)
+
+	cleaned := make(chan error, 1)
+	storage.cleanup = func() {
+		_, err := service.CleanupUnreferenced(-time.Minute)
+		cleaned <- err
+	}
+	uploaded, err := service.Upload(other.ID, nil, "draft.txt", "text/plain", strings.NewReader("draft"))
+	require.NoError(t, err)
+	require.NoError(t, <-cleaned)
+
+	// The new upload keeps the file the orphan shared
+	assert.Equal(t, orphan.SHA256, uploaded.SHA256)
+	_, content, err := service.GetAttachment(other.ID, uploaded.ID)
+	require.NoError(t, err)
+	content.Close()
+}
+
+func TestAttachmentService_CleanupAfterReuse(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	service := NewAttachmentService(db, storage, 0)
+
+	owner := createTestUser(t, db)
+	orphan, err := service.Upload(owner.ID, nil, "draft.txt", "text/plain", strings.NewReader("draft"))
+	require.NoError(t, err)
+	require.NoError(t, db.Model(orphan).UpdateColumn("created_at", time.Now().Add(-2*time.Hour)).Error)
+
+	// Cleanup listed the orphan before the upload handed it back again
+	cutoff := time.Now().Add(-time.Hour)
+	var listed []models.Attachment
+	require.NoError(t, db.Scopes(unreferencedBefore(cutoff)).Find(&listed).Error)
+	require.Len(t, listed, 1)
+
+	reused, err := service.Upload(owner.ID, nil, "draft.txt", "text/plain", strings.NewReader("draft"))
+	require.NoError(t, err)
+	assert.Equal(t, orphan.ID, reused.ID)
+
+	deleted, err := service.removeAttachment(&listed[0], cutoff)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	removed, err := service.CleanupUnreferenced(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	_, content, err := service.GetAttachment(owner.ID, reused.ID)
+	require.NoError(t, err)
+	content.Close()
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidComment wraps comment validation failures
+	ErrInvalidComment = errors.New("invalid comment")
+	// ErrCommentNotFound means the comment does not exist on the note
+	ErrCommentNotFound = errors.New("comment not found")
+	// ErrCommentAnchorInvalid means a thread's anchor does not point at a
+	// range inside the note's content
+	ErrCommentAnchorInvalid = errors.New("comment anchor does not match the note content")
+	// ErrMentionNotFound means a mentioned user or person does not exist or
+	// cannot see the note
+	ErrMentionNotFound = errors.New("mentioned user or person not found")
+	// ErrNotCommentAuthor means someone other than the author tried to edit a comment
+	ErrNotCommentAuthor = errors.New("only the author can edit a comment")
+)
+
+// usernameMentionPattern finds @username mentions in comment bodies
+var usernameMentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.-]+)`)
+
+// CommentInput describes a new comment or reply. Anchor fields are only used
+// for comments that start a thread.
+type CommentInput struct {
+	ParentID         *uuid.UUID
+	Body             string
+	AnchorPath       string
+	AnchorFrom       int
+	AnchorTo         int
+	QuotedText       string
+	MentionUserIDs   []uuid.UUID
+	MentionPersonIDs []uuid.UUID
+}
+
+// CommentService manages threaded discussions on notes
+type CommentService struct {
+	db     *gorm.DB
+	shares *ShareService
+}
+
+// NewCommentService creates a new comment service
+func NewCommentService(db *gorm.DB) *CommentService {
+	return &CommentService{
+		db:     db,
+		shares: NewShareService(db),
+	}
+}
+
+// ListThreads returns the threads on a note, oldest first, with their replies.
+// status filters on "open" or "resolved" threads; empty returns both.
+func (s *CommentService) ListThreads(userID, noteID uuid.UUID, status string) ([]models.Comment, error) {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleViewer); err != nil {
+		return nil, err
+	}
+
+	query := s.withThread(s.db).Where("note_id = ? AND parent_id IS NULL", noteID)
+	switch status {
+	case "open":
+		query = query.Where("resolved_at IS NULL")
+	case "resolved":
+		query = query.Where("resolved_at IS NOT NULL")
+	}
+
+	var threads []models.Comment
+	if err := query.Order("created_at").Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch comments: %w", err)
+	}
+	return threads, nil
+}
+
+// GetComment returns a comment on a note, with its replies if it starts a thread
+func (s *CommentService) GetComment(userID, noteID, commentID uuid.UUID) (*models.Comment, error) {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleViewer); err != nil {
+		return nil, err
+	}
+	return s.load(s.db, noteID, commentID)
+}
+
+// CreateComment starts a thread or replies to one. Commenters and above only.
+// Replies to a reply join the thread of its parent.
+func (s *CommentService) CreateComment(userID, noteID uuid.UUID, input CommentInput) (*models.Comment, error) {
+	note, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleCommenter)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := models.Comment{
+		NoteID: noteID,
+		UserID: userID,
+		Body:   input.Body,
+	}
+	if input.ParentID != nil {
+		var parent models.Comment
+		if err := s.db.Where("id = ? AND note_id = ?", *input.ParentID, noteID).First(&parent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrCommentNotFound
+			}
+			return nil, err
+		}
+		threadID := parent.ID
+		if parent.ParentID != nil {
+			threadID = *parent.ParentID
+		}
+		comment.ParentID = &threadID
+	} else {
+		comment.AnchorPath = input.AnchorPath
+		comment.AnchorFrom = input.AnchorFrom
+		comment.AnchorTo = input.AnchorTo
+		comment.QuotedText = input.QuotedText
+	}
+	if err := comment.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidComment, err)
+	}
+	if comment.ParentID == nil {
+		if err := validateAnchor(note.Content, comment.AnchorPath, comment.AnchorFrom, comment.AnchorTo); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		return s.saveMentions(tx, note, &comment, input.MentionUserIDs, input.MentionPersonIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.load(s.db, noteID, comment.ID)
+}
+
+// UpdateComment changes the body of a comment and its mentions. Only the
+// author may edit a comment.
+func (s *CommentService) UpdateComment(userID, noteID, commentID uuid.UUID, body string, mentionUserIDs, mentionPersonIDs []uuid.UUID) (*models.Comment, error) {
+	note, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleCommenter)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment models.Comment
+	if err := s.db.Where("id = ? AND note_id = ?", commentID, noteID).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	if comment.UserID != userID {
+		return nil, ErrNotCommentAuthor
+	}
+
+	comment.Body = body
+	if err := comment.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidComment, err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&comment).Update("body", comment.Body).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id = ?", comment.ID).Delete(&models.CommentMention{}).Error; err != nil {
+			return err
+		}
+		return s.saveMentions(tx, note, &comment, mentionUserIDs, mentionPersonIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.load(s.db, noteID, comment.ID)
+}
+
+// SetResolved resolves or reopens the thread a comment belongs to and returns
+// the thread. Commenters and above only.
+func (s *CommentService) SetResolved(userID, noteID, commentID uuid.UUID, resolved bool) (*models.Comment, error) {
+	if _, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleCommenter); err != nil {
+		return nil, err
+	}
+
+	var comment models.Comment
+	if err := s.db.Where("id = ? AND note_id = ?", commentID, noteID).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	threadID := comment.ID
+	if comment.ParentID != nil {
+		threadID = *comment.ParentID
+	}
+
+	updates := map[string]interface{}{"resolved_at": nil, "resolved_by": nil}
+	if resolved {
+		updates = map[string]interface{}{"resolved_at": time.Now(), "resolved_by": userID}
+	}
+	if err := s.db.Model(&models.Comment{}).Where("id = ?", threadID).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update comment: %w", err)
+	}
+	return s.load(s.db, noteID, threadID)
+}
+
+// DeleteComment removes a comment, and its replies if it starts a thread. The
+// author and the note's owners may delete a comment.
+func (s *CommentService) DeleteComment(userID, noteID, commentID uuid.UUID) (*models.Comment, error) {
+	_, role, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleCommenter)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment models.Comment
+	if err := s.db.Where("id = ? AND note_id = ?", commentID, noteID).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	if comment.UserID != userID && role != models.NoteRoleOwner {
+		return nil, ErrNoteAccessDenied
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		ids := []uuid.UUID{comment.ID}
+		if comment.ParentID == nil {
+			var replies []uuid.UUID
+			if err := tx.Model(&models.Comment{}).Where("parent_id = ?", comment.ID).Pluck("id", &replies).Error; err != nil {
+				return err
+			}
+			ids = append(ids, replies...)
+		}
+		if err := tx.Where("comment_id IN ?", ids).Delete(&models.CommentMention{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.Comment{}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete comment: %w", err)
+	}
+	return &comment, nil
+}
+
+// saveMentions records the users and people a comment mentions. Users may be
+// listed explicitly or written as @username in the body; either way they
+// must be able to open the note. People must belong to the note's owner or
+// workspace.
+func (s *CommentService) saveMentions(tx *gorm.DB, note *models.Note, comment *models.Comment, userIDs, personIDs []uuid.UUID) error {
+	seen := make(map[uuid.UUID]bool)
+	var mentions []models.CommentMention
+
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		role, err := s.shares.NoteRole(id, note)
+		if err != nil {
+			return err
+		}
+		if role == "" {
+			return ErrMentionNotFound
+		}
+		seen[id] = true
+		mentions = append(mentions, models.CommentMention{CommentID: comment.ID, MentionType: models.MentionTypeUser, MentionedID: id})
+	}
+
+	if usernames := mentionedUsernames(comment.Body); len(usernames) > 0 {
+		var users []models.User
+		if err := tx.Where("username IN ?", usernames).Find(&users).Error; err != nil {
+			return err
+		}
+		for _, user := range users {
+			if seen[user.ID] {
+				continue
+			}
+			// Unknown or unauthorized @names are left as plain text
+			if role, err := s.shares.NoteRole(user.ID, note); err != nil || role == "" {
+				continue
+			}
+			seen[user.ID] = true
+			mentions = append(mentions, models.CommentMention{CommentID: comment.ID, MentionType: models.MentionTypeUser, MentionedID: user.ID})
+		}
+	}
+
+	for _, id := range personIDs {
+		if seen[id] {
+			continue
+		}
+		var count int64
+		if err := tx.Model(&models.Person{}).Scopes(OwnedBy("people", note.UserID, note.WorkspaceID)).
+			Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrMentionNotFound
+		}
+		seen[id] = true
+		mentions = append(mentions, models.CommentMention{CommentID: comment.ID, MentionType: models.MentionTypePerson, MentionedID: id})
+	}
+
+	if len(mentions) == 0 {
+		return nil
+	}
+	return tx.Create(&mentions).Error
+}
+
+// load fetches a comment on a note with its author, mentions and replies
+func (s *CommentService) load(tx *gorm.DB, noteID, commentID uuid.UUID) (*models.Comment, error) {
+	var comment models.Comment
+	if err := s.withThread(tx).Where("id = ? AND note_id = ?", commentID, noteID).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func (s *CommentService) withThread(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("User").Preload("Mentions").
+		Preload("Replies", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Preload("Replies.User").Preload("Replies.Mentions")
+}
+
+// mentionedUsernames returns the distinct @usernames written in a comment body
+func mentionedUsernames(body string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range usernameMentionPattern.FindAllStringSubmatch(body, -1) {
+		name := strings.TrimRight(match[1], ".")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames
+}
+
+// validateAnchor checks that path names a node in the note content and that
+// from and to fall inside it. Offsets count ProseMirror positions within the
+// node's content, so for a paragraph they are character offsets.
+func validateAnchor(content models.JSONB, path string, from, to int) error {
+	node := map[string]interface{}(content)
+	for _, part := range strings.Split(path, ".") {
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			return ErrCommentAnchorInvalid
+		}
+		children := contentOf(node)
+		if index < 0 || index >= len(children) {
+			return ErrCommentAnchorInvalid
+		}
+		child, ok := children[index].(map[string]interface{})
+		if !ok {
+			return ErrCommentAnchorInvalid
+		}
+		node = child
+	}
+
+	size := nodeSize(node)
+	if nodeType(node) != "text" {
+		size = max(size-2, 0)
+	}
+	if to > size || from > to {
+		return ErrCommentAnchorInvalid
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func createCommentTestNote(t *testing.T, db *gorm.DB, userID uuid.UUID) models.Note {
+	note := createTestNote(t, db, userID)
+	note.Content = models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "heading", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Plans"},
+		}},
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Ship the beta "},
+			map[string]interface{}{"type": "text", "text": "on Friday", "marks": []interface{}{map[string]interface{}{"type": "bold"}}},
+		}},
+	}}
+	require.NoError(t, db.Save(&note).Error)
+	return note
+}
+
+func TestCommentService_Threads(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	commenter := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	note := createCommentTestNote(t, db, owner.ID)
+
+	shares := NewShareService(db)
+	_, err := shares.ShareNote(owner.ID, note.ID, commenter.ID, models.NoteRoleCommenter)
+	require.NoError(t, err)
+	_, err = shares.ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+
+	service := NewCommentService(db)
+
+	// Anchors must point inside the note content
+	_, err = service.CreateComment(commenter.ID, note.ID, CommentInput{Body: "Really?", AnchorPath: "1", AnchorFrom: 14, AnchorTo: 24})
+	assert.ErrorIs(t, err, ErrCommentAnchorInvalid)
+	_, err = service.CreateComment(commenter.ID, note.ID, CommentInput{Body: "Really?", AnchorPath: "3", AnchorFrom: 0, AnchorTo: 1})
+	assert.ErrorIs(t, err, ErrCommentAnchorInvalid)
+	_, err = service.CreateComment(commenter.ID, note.ID, CommentInput{Body: "Really?", AnchorPath: "first"})
+	assert.ErrorIs(t, err, ErrInvalidComment)
+
+	// Viewers may read but not comment
+	_, err = service.CreateComment(viewer.ID, note.ID, CommentInput{Body: "Hi", AnchorPath: "0", AnchorTo: 5})
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+
+	thread, err := service.CreateComment(commenter.ID, note.ID, CommentInput{
+		Body:       "Is @" + owner.Username + " sure about this? cc @nobody",
+		AnchorPath: "1",
+		AnchorFrom: 14,
+		AnchorTo:   23,
+		QuotedText: "on Friday",
+	})
+	require.NoError(t, err)
+	require.Len(t, thread.Mentions, 1)
+	assert.Equal(t, owner.ID, thread.Mentions[0].MentionedID)
+	assert.Equal(t, commenter.ID, thread.User.ID)
+
+	// Replies to replies join the thread
+	reply, err := service.CreateComment(owner.ID, note.ID, CommentInput{Body: "Yes", ParentID: &thread.ID})
+	require.NoError(t, err)
+	nested, err := service.CreateComment(commenter.ID, note.ID, CommentInput{Body: "Great", ParentID: &reply.ID})
+	require.NoError(t, err)
+	assert.Equal(t, thread.ID, *nested.ParentID)
+
+	threads, err := service.ListThreads(viewer.ID, note.ID, "")
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Len(t, threads[0].Replies, 2)
+
+	// Only the author can edit
+	_, err = service.UpdateComment(owner.ID, note.ID, thread.ID, "Changed", nil, nil)
+	assert.ErrorIs(t, err, ErrNotCommentAuthor)
+	updated, err := service.UpdateComment(commenter.ID, note.ID, thread.ID, "Still sure?", []uuid.UUID{viewer.ID}, nil)
+	require.NoError(t, err)
+	require.Len(t, updated.Mentions, 1)
+	assert.Equal(t, viewer.ID, updated.Mentions[0].MentionedID)
+
+	// Resolving a reply resolves its thread
+	resolved, err := service.SetResolved(commenter.ID, note.ID, nested.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, thread.ID, resolved.ID)
+	assert.True(t, resolved.IsResolved())
+
+	open, err := service.ListThreads(owner.ID, note.ID, "open")
+	require.NoError(t, err)
+	assert.Empty(t, open)
+
+	reopened, err := service.SetResolved(owner.ID, note.ID, thread.ID, false)
+	require.NoError(t, err)
+	assert.False(t, reopened.IsResolved())
+
+	// Deleting a thread removes its replies; others' comments need the owner
+	_, err = service.DeleteComment(commenter.ID, note.ID, reply.ID)
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+	_, err = service.DeleteComment(owner.ID, note.ID, thread.ID)
+	require.NoError(t, err)
+
+	var remaining int64
+	db.Model(&models.Comment{}).Where("note_id = ?", note.ID).Count(&remaining)
+	assert.Equal(t, int64(0), remaining)
+}
+
+func TestCommentService_Mentions(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	stranger := createTestUser(t, db)
+	note := createCommentTestNote(t, db, owner.ID)
+
+	person := models.Person{UserID: owner.ID, Name: "Ada Lovelace"}
+	require.NoError(t, db.Create(&person).Error)
+	otherPerson := models.Person{UserID: stranger.ID, Name: "Grace Hopper"}
+	require.NoError(t, db.Create(&otherPerson).Error)
+
+	service := NewCommentService(db)
+
+	// Users must be able to open the note and people must belong to its owner
+	_, err := service.CreateComment(owner.ID, note.ID, CommentInput{Body: "Hi", AnchorPath: "0", MentionUserIDs: []uuid.UUID{stranger.ID}})
+	assert.ErrorIs(t, err, ErrMentionNotFound)
+	_, err = service.CreateComment(owner.ID, note.ID, CommentInput{Body: "Hi", AnchorPath: "0", MentionPersonIDs: []uuid.UUID{otherPerson.ID}})
+	assert.ErrorIs(t, err, ErrMentionNotFound)
+
+	// @names of users without access stay plain text
+	comment, err := service.CreateComment(owner.ID, note.ID, CommentInput{
+		Body:             "Ask @" + stranger.Username,
+		AnchorPath:       "0.0",
+		AnchorFrom:       0,
+		AnchorTo:         5,
+		MentionPersonIDs: []uuid.UUID{person.ID},
+	})
+	require.NoError(t, err)
+	require.Len(t, comment.Mentions, 1)
+	assert.Equal(t, models.MentionTypePerson, comment.Mentions[0].MentionType)
+	assert.Equal(t, person.ID, comment.Mentions[0].MentionedID)
+}
+
+func TestWebSocketService_BroadcastToNote(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+
+	user := createTestUser(t, testDB)
+	note := createTestNote(t, testDB, user.ID)
+
+	conn, server := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer conn.Close()
+	defer server.Close()
+
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeJoinRoom,
+		Data: models.JoinRoomData{NoteID: note.ID},
+	}))
+	readMessageOfType(t, conn, models.MessageTypeAck)
+
+	service.BroadcastToNote(note.ID, &models.WebSocketMessage{
+		Type:      models.MessageTypeComment,
+		UserID:    user.ID,
+		Timestamp: time.Now(),
+		Data:      models.CommentData{NoteID: note.ID, Action: models.CommentActionCreated},
+	})
+
+	message := readMessageOfType(t, conn, models.MessageTypeComment)
+	assert.Equal(t, note.ID.String(), message.RoomID)
+	assert.Equal(t, models.CommentActionCreated, message.Data.(map[string]interface{})["action"])
+}
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrConflictResolved means the conflict was already resolved
+	ErrConflictResolved = errors.New("conflict already resolved")
+	// ErrMergeConflict means an automatic merge found overlapping changes
+	ErrMergeConflict = errors.New("changes overlap and cannot be merged automatically")
+	// ErrAncestorUnavailable means the common ancestor version is missing from history
+	ErrAncestorUnavailable = errors.New("common ancestor version is not available")
+	// ErrInvalidResolution means the requested resolution is not supported
+	ErrInvalidResolution = errors.New("resolution must be one of: mine, theirs, merge")
+	// ErrNoteChanged means the note was edited while a conflict on it was being resolved
+	ErrNoteChanged = errors.New("note changed while resolving the conflict, try again")
+	// ErrLocalContentMissing means a conflict has no local content to resolve with
+	ErrLocalContentMissing = errors.New("conflict has no local content to keep")
+)
+
+// ConflictService persists and resolves edit conflicts
+type ConflictService struct {
+	db         *gorm.DB
+	revisions  *RevisionService
+	shares     *ShareService
+	operations *OperationLog
+}
+
+// NewConflictService creates a new conflict service
+func NewConflictService(db *gorm.DB) *ConflictService {
+	return &ConflictService{
+		db:         db,
+		revisions:  NewRevisionService(db),
+		shares:     NewShareService(db),
+		operations: NewOperationLog(),
+	}
+}
+
+// WithOperations returns a copy of the service that serialises its writes with
+// the live edits recorded in log
+func (s *ConflictService) WithOperations(log *OperationLog) *ConflictService {
+	scoped := *s
+	scoped.operations = log
+	return &scoped
+}
+
+// MergeConflict is a region both sides changed differently. Index is where the
+// region starts in the merged document.
+type MergeConflict struct {
+	Index  int            `json:"index"`
+	Base   []models.JSONB `json:"base"`
+	Local  []models.JSONB `json:"local"`
+	Remote []models.JSONB `json:"remote"`
+}
+
+// MergeResult is the outcome of a three-way merge. When Conflicts is non-empty,
+// Content holds the local side of every conflicting region.
+type MergeResult struct {
+	Content   models.JSONB    `json:"content"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// Clean reports whether the merge needed no manual intervention
+func (r *MergeResult) Clean() bool {
+	return len(r.Conflicts) == 0
+}
+
+// CreateConflict records an update that was rejected for being based on an old version
+func (s *ConflictService) CreateConflict(note *models.Note, userID uuid.UUID, localVersion int, localContent models.JSONB) (*models.NoteConflict, error) {
+	conflict := &models.NoteConflict{
+		NoteID:        note.ID,
+		UserID:        userID,
+		LocalVersion:  localVersion,
+		RemoteVersion: note.Version,
+		LocalContent:  localContent,
+		RemoteContent: note.Content,
+	}
+	if err := conflict.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(conflict).Error; err != nil {
+		return nil, fmt.Errorf("failed to record conflict: %w", err)
+	}
+	return conflict, nil
+}
+
+// ListConflicts returns a user's conflicts, newest first. A nil noteID lists
+// conflicts across all notes; an empty status lists every status.
+func (s *ConflictService) ListConflicts(userID uuid.UUID, noteID *uuid.UUID, status string) ([]models.NoteConflict, error) {
+	query := s.db.Where("user_id = ?", userID)
+	if noteID != nil {
+		query = query.Where("note_id = ?", *noteID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var conflicts []models.NoteConflict
+	if err := query.Order("created_at DESC").Find(&conflicts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict settles a conflict and applies the chosen content to the note.
+// For ConflictResolutionMerge, content is the merged document; when it is nil the
+// note is merged automatically against the common ancestor, and ErrMergeConflict
+// is returned together with the merge result if both sides changed the same blocks.
+func (s *ConflictService) ResolveConflict(userID, noteID, conflictID uuid.UUID, resolution string, content models.JSONB) (*models.Note, *MergeResult, error) {
+	var conflict models.NoteConflict
+	if err := s.db.Where("id = ? AND note_id = ? AND user_id = ?", conflictID, noteID, userID).First(&conflict).Error; err != nil {
+		return nil, nil, err
+	}
+	if conflict.Status == models.ConflictStatusResolved {
+		return nil, nil, ErrConflictResolved
+	}
+
+	unlock := s.operations.Lock(noteID)
+	defer unlock()
+
+	// Keeping "mine" or a merge writes to the note, and access may have been
+	// narrowed since the conflict was recorded
+	notePtr, _, err := s.shares.AuthorizeNote(userID, noteID, models.NoteRoleEditor)
+	if err != nil {
+		return nil, nil, err
+	}
+	note := *notePtr
+
+	var newContent models.JSONB
+	var merge *MergeResult
+
+	switch resolution {
+	case models.ConflictResolutionMine:
+		if conflict.LocalContent == nil {
+			return nil, nil, ErrLocalContentMissing
+		}
+		newContent = conflict.LocalContent
+	case models.ConflictResolutionTheirs:
+		// The note already holds their content
+	case models.ConflictResolutionMerge:
+		if content != nil {
+			newContent = content
+			break
+		}
+		base, err := s.revisions.GetRevision(noteID, conflict.LocalVersion)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, ErrAncestorUnavailable
+			}
+			return nil, nil, err
+		}
+		merge = ThreeWayMerge(base.Content, conflict.LocalContent, note.Content)
+		if !merge.Clean() {
+			return nil, merge, ErrMergeConflict
+		}
+		newContent = merge.Content
+	default:
+		return nil, nil, ErrInvalidResolution
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if newContent != nil {
+			// Only replace the version the resolution was worked out against
+			result := tx.Model(&models.Note{}).
+				Where("id = ? AND version = ?", note.ID, note.Version).
+				Updates(map[string]interface{}{"content": newContent, "version": note.Version + 1})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrNoteChanged
+			}
+			note.Content = newContent
+			note.Version++
+			if err := s.revisions.RecordRevision(tx, &note, userID, "Resolved conflict ("+resolution+")"); err != nil {
+				return err
+			}
+			if err := RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionUpdate); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		conflict.Status = models.ConflictStatusResolved
+		conflict.Resolution = resolution
+		conflict.ResolvedVersion = &note.Version
+		conflict.ResolvedAt = &now
+		return tx.Save(&conflict).Error
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve conflict: %w", err)
+	}
+
+	// The new content replaces the document, so older operations must conflict
+	if newContent != nil {
+		s.operations.Forget(note.ID)
+	}
+
+	return &note, merge, nil
+}
+
+// ThreeWayMerge merges the top-level blocks of two documents that both descend
+// from base. Each side's changes are reduced to hunks against base; hunks that do
+// not overlap are applied together, identical changes are taken once and anything
+// else is reported as a conflict.
+func ThreeWayMerge(base, local, remote models.JSONB) *MergeResult {
+	baseBlocks := topLevelBlocks(base)
+	baseKeys := blockKeys(baseBlocks)
+
+	localBlocks := topLevelBlocks(local)
+	remoteBlocks := topLevelBlocks(remote)
+	localHunks := blockHunks(baseKeys, blockKeys(localBlocks), localBlocks, true)
+	remoteHunks := blockHunks(baseKeys, blockKeys(remoteBlocks), remoteBlocks, false)
+
+	hunks := append(localHunks, remoteHunks...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		if hunks[i].start != hunks[j].start {
+			return hunks[i].start < hunks[j].start
+		}
+		return hunks[i].end < hunks[j].end
+	})
+
+	result := &MergeResult{Conflicts: []MergeConflict{}}
+	merged := []interface{}{}
+	pos := 0
+
+	for i := 0; i < len(hunks); {
+		// Collect every hunk that overlaps the group, or inserts at the same place
+		group := []blockHunk{hunks[i]}
+		groupStart, groupEnd := hunks[i].start, hunks[i].end
+		for i++; i < len(hunks); i++ {
+			h := hunks[i]
+			if h.start < groupEnd || (h.start == h.end && groupStart == groupEnd && h.start == groupStart) {
+				group = append(group, h)
+				groupEnd = maxInt(groupEnd, h.end)
+				continue
+			}
+			break
+		}
+
+		merged = appendBlocks(merged, baseBlocks[pos:groupStart])
+		pos = groupEnd
+
+		localSpan, localChanged := applyHunks(baseBlocks, group, groupStart, groupEnd, true)
+		remoteSpan, remoteChanged := applyHunks(baseBlocks, group, groupStart, groupEnd, false)
+		switch {
+		case !remoteChanged:
+			merged = appendBlocks(merged, localSpan)
+		case !localChanged:
+			merged = appendBlocks(merged, remoteSpan)
+		case sameKeys(blockKeys(localSpan), blockKeys(remoteSpan)):
+			merged = appendBlocks(merged, localSpan)
+		default:
+			result.Conflicts = append(result.Conflicts, MergeConflict{
+				Index:  len(merged),
+				Base:   baseBlocks[groupStart:groupEnd],
+				Local:  localSpan,
+				Remote: remoteSpan,
+			})
+			merged = appendBlocks(merged, localSpan)
+		}
+	}
+	merged = appendBlocks(merged, baseBlocks[pos:])
+
+	content := models.JSONB{"type": "doc", "content": merged}
+	for _, doc := range []models.JSONB{remote, local, base} {
+		for key, value := range doc {
+			if key != "content" {
+				content[key] = value
+			}
+		}
+	}
+	result.Content = content
+	return result
+}
+
+// blockHunk replaces base blocks [start, end) with blocks on one side of a merge
+type blockHunk struct {
+	start  int
+	end    int
+	blocks []models.JSONB
+	local  bool
+}
+
+// blockHunks aligns a side against base with a longest common subsequence and
+// returns the runs of base blocks it replaced, removed or inserted between
+func blockHunks(baseKeys, sideKeys []string, sideBlocks []models.JSONB, local bool) []blockHunk {
+	lcs := make([][]int, len(baseKeys)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(sideKeys)+1)
+	}
+	for i := len(baseKeys) - 1; i >= 0; i-- {
+		for j := len(sideKeys) - 1; j >= 0; j-- {
+			if baseKeys[i] == sideKeys[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = maxInt(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	hunks := []blockHunk{}
+	i, j := 0, 0
+	hunkI, hunkJ := 0, 0
+	flush := func() {
+		if i > hunkI || j > hunkJ {
+			hunks = append(hunks, blockHunk{start: hunkI, end: i, blocks: sideBlocks[hunkJ:j], local: local})
+		}
+	}
+	for i < len(baseKeys) || j < len(sideKeys) {
+		switch {
+		case i < len(baseKeys) && j < len(sideKeys) && baseKeys[i] == sideKeys[j]:
+			flush()
+			i++
+			j++
+			hunkI, hunkJ = i, j
+		case j >= len(sideKeys) || (i < len(baseKeys) && lcs[i+1][j] >= lcs[i][j+1]):
+			i++
+		default:
+			j++
+		}
+	}
+	flush()
+	return hunks
+}
+
+// applyHunks returns one side's version of base blocks [start, end) and whether
+// that side changed anything in the range
+func applyHunks(baseBlocks []models.JSONB, hunks []blockHunk, start, end int, local bool) ([]models.JSONB, bool) {
+	span := []models.JSONB{}
+	pos := start
+	changed := false
+	for _, h := range hunks {
+		if h.local != local {
+			continue
+		}
+		span = append(span, baseBlocks[pos:h.start]...)
+		span = append(span, h.blocks...)
+		pos = h.end
+		changed = true
+	}
+	span = append(span, baseBlocks[pos:end]...)
+	return span, changed
+}
+
+func blockKeys(blocks []models.JSONB) []string {
+	keys := make([]string, len(blocks))
+	for i, block := range blocks {
+		keys[i] = canonicalJSON(block)
+	}
+	return keys
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func appendBlocks(content []interface{}, blocks []models.JSONB) []interface{} {
+	for _, block := range blocks {
+		content = append(content, map[string]interface{}(block))
+	}
+	return content
+}
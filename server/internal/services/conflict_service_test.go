@@ -0,0 +1,184 @@
+package services
+
+import (
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	base := doc(paragraph("a"), paragraph("b"), paragraph("c"))
+
+	tests := []struct {
+		name      string
+		local     models.JSONB
+		remote    models.JSONB
+		expected  models.JSONB
+		conflicts int
+	}{
+		{
+			name:     "changes to different blocks",
+			local:    doc(paragraph("A"), paragraph("b"), paragraph("c")),
+			remote:   doc(paragraph("a"), paragraph("b"), paragraph("C")),
+			expected: doc(paragraph("A"), paragraph("b"), paragraph("C")),
+		},
+		{
+			name:     "insert and delete on different sides",
+			local:    doc(paragraph("a"), paragraph("b"), paragraph("new"), paragraph("c")),
+			remote:   doc(paragraph("b"), paragraph("c")),
+			expected: doc(paragraph("b"), paragraph("new"), paragraph("c")),
+		},
+		{
+			name:     "same change on both sides",
+			local:    doc(paragraph("a"), paragraph("B"), paragraph("c")),
+			remote:   doc(paragraph("a"), paragraph("B"), paragraph("c")),
+			expected: doc(paragraph("a"), paragraph("B"), paragraph("c")),
+		},
+		{
+			name:      "different changes to the same block",
+			local:     doc(paragraph("a"), paragraph("mine"), paragraph("c")),
+			remote:    doc(paragraph("a"), paragraph("theirs"), paragraph("c")),
+			expected:  doc(paragraph("a"), paragraph("mine"), paragraph("c")),
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ThreeWayMerge(base, tt.local, tt.remote)
+			assert.Equal(t, canonicalJSON(tt.expected), canonicalJSON(result.Content))
+			assert.Len(t, result.Conflicts, tt.conflicts)
+			assert.Equal(t, tt.conflicts == 0, result.Clean())
+		})
+	}
+
+	result := ThreeWayMerge(base, doc(paragraph("a"), paragraph("mine"), paragraph("c")), doc(paragraph("a"), paragraph("theirs"), paragraph("c")))
+	require.Len(t, result.Conflicts, 1)
+	assert.Equal(t, 1, result.Conflicts[0].Index)
+	assert.Equal(t, "b", nodeText(result.Conflicts[0].Base[0]))
+	assert.Equal(t, "theirs", nodeText(result.Conflicts[0].Remote[0]))
+}
+
+func TestConflictService_Resolve(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	note := createTestNote(t, db, user.ID)
+	revisions := NewRevisionService(db)
+	service := NewConflictService(db)
+
+	// Version 1 is the common ancestor; the server moved on to version 2
+	note.Content = doc(paragraph("a"), paragraph("b"))
+	require.NoError(t, db.Save(&note).Error)
+	require.NoError(t, revisions.RecordRevision(db, &note, user.ID, "Created"))
+	note.Content = doc(paragraph("a"), paragraph("B"))
+	note.Version = 2
+	require.NoError(t, db.Save(&note).Error)
+	require.NoError(t, revisions.RecordRevision(db, &note, user.ID, ""))
+
+	conflict, err := service.CreateConflict(&note, user.ID, 1, doc(paragraph("A"), paragraph("b")))
+	require.NoError(t, err)
+	assert.Equal(t, models.ConflictStatusOpen, conflict.Status)
+	assert.Equal(t, 2, conflict.RemoteVersion)
+
+	open, err := service.ListConflicts(user.ID, nil, models.ConflictStatusOpen)
+	require.NoError(t, err)
+	assert.Len(t, open, 1)
+
+	_, _, err = service.ResolveConflict(user.ID, note.ID, conflict.ID, "rebase", nil)
+	assert.ErrorIs(t, err, ErrInvalidResolution)
+
+	// Keeping mine needs the local content the conflict was recorded with
+	empty, err := service.CreateConflict(&note, user.ID, 1, nil)
+	require.NoError(t, err)
+	_, _, err = service.ResolveConflict(user.ID, note.ID, empty.ID, models.ConflictResolutionMine, nil)
+	assert.ErrorIs(t, err, ErrLocalContentMissing)
+	require.NoError(t, db.First(empty, "id = ?", empty.ID).Error)
+	assert.Equal(t, models.ConflictStatusOpen, empty.Status)
+	require.NoError(t, db.Delete(empty).Error)
+
+	// Automatic merge keeps both edits
+	resolved, merge, err := service.ResolveConflict(user.ID, note.ID, conflict.ID, models.ConflictResolutionMerge, nil)
+	require.NoError(t, err)
+	assert.True(t, merge.Clean())
+	assert.Equal(t, 3, resolved.Version)
+	assert.Equal(t, canonicalJSON(doc(paragraph("A"), paragraph("B"))), canonicalJSON(resolved.Content))
+
+	revision, err := revisions.GetRevision(note.ID, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "Resolved conflict (merge)", revision.ChangeDescription)
+
+	_, _, err = service.ResolveConflict(user.ID, note.ID, conflict.ID, models.ConflictResolutionMine, nil)
+	assert.ErrorIs(t, err, ErrConflictResolved)
+
+	open, err = service.ListConflicts(user.ID, &note.ID, models.ConflictStatusOpen)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+
+	// Keeping theirs leaves the note untouched
+	conflict, err = service.CreateConflict(resolved, user.ID, 2, doc(paragraph("x")))
+	require.NoError(t, err)
+	kept, _, err := service.ResolveConflict(user.ID, note.ID, conflict.ID, models.ConflictResolutionTheirs, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, kept.Version)
+
+	var stored models.NoteConflict
+	require.NoError(t, db.First(&stored, "id = ?", conflict.ID).Error)
+	assert.Equal(t, models.ConflictResolutionTheirs, stored.Resolution)
+	require.NotNil(t, stored.ResolvedVersion)
+	assert.Equal(t, 3, *stored.ResolvedVersion)
+
+	// Overlapping edits are reported rather than merged
+	conflict, err = service.CreateConflict(kept, user.ID, 2, doc(paragraph("mine"), paragraph("B")))
+	require.NoError(t, err)
+	_, merge, err = service.ResolveConflict(user.ID, note.ID, conflict.ID, models.ConflictResolutionMerge, nil)
+	assert.ErrorIs(t, err, ErrMergeConflict)
+	require.NotNil(t, merge)
+	assert.Len(t, merge.Conflicts, 1)
+
+	// Another user cannot see or resolve the conflict
+	other := createTestUser(t, db)
+	_, _, err = service.ResolveConflict(other.ID, note.ID, conflict.ID, models.ConflictResolutionMine, nil)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestConflictService_ResolveRejectsChangedNote(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	note := createTestNote(t, db, user.ID)
+	service := NewConflictService(db)
+
+	conflict, err := service.CreateConflict(&note, user.ID, 0, doc(paragraph("mine")))
+	require.NoError(t, err)
+
+	// Another writer saves the note after the resolution has read it
+	bumped := false
+	require.NoError(t, db.Callback().Update().Before("gorm:update").Register("test:bump_version", func(tx *gorm.DB) {
+		if bumped || tx.Statement.Table != "notes" {
+			return
+		}
+		bumped = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE notes SET version = version + 1 WHERE id = ?", note.ID)
+	}))
+
+	_, _, err = service.ResolveConflict(user.ID, note.ID, conflict.ID, models.ConflictResolutionMine, nil)
+	assert.ErrorIs(t, err, ErrNoteChanged)
+
+	assert.True(t, bumped)
+
+	var stored models.Note
+	require.NoError(t, db.First(&stored, "id = ?", note.ID).Error)
+	assert.Equal(t, canonicalJSON(note.Content), canonicalJSON(stored.Content))
+
+	open, err := service.ListConflicts(user.ID, &note.ID, models.ConflictStatusOpen)
+	require.NoError(t, err)
+	assert.Len(t, open, 1)
+}
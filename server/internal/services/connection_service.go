@@ -14,7 +14,8 @@ import (
 )
 
 type ConnectionService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	workspaceID *uuid.UUID
 }
 
 type ConnectionType string
@@ -65,6 +66,14 @@ func NewConnectionService(db *gorm.DB) *ConnectionService {
 	return &ConnectionService{db: db}
 }
 
+// InWorkspace returns a copy of the service that works on a workspace's graph,
+// or on the user's personal graph when workspaceID is nil
+func (s *ConnectionService) InWorkspace(workspaceID *uuid.UUID) *ConnectionService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
 // DetectConnections analyzes note content and detects @mentions and #references
 func (s *ConnectionService) DetectConnections(userID uuid.UUID, noteID uuid.UUID, content models.JSONB) ([]DetectedConnection, error) {
 	var connections []DetectedConnection
@@ -118,17 +127,31 @@ func (s *ConnectionService) DetectConnections(userID uuid.UUID, noteID uuid.UUID
 func (s *ConnectionService) UpdateConnections(userID uuid.UUID, noteID uuid.UUID, detectedConnections []DetectedConnection) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		// Delete existing connections for this note
-		if err := tx.Where("user_id = ? AND source_id = ? AND source_type = ?", userID, noteID, "note").
-			Delete(&models.Connection{}).Error; err != nil {
-			return fmt.Errorf("failed to delete existing connections: %w", err)
+		var existingIDs []uuid.UUID
+		if err := tx.Model(&models.Connection{}).
+			Scopes(OwnedBy("connections", userID, s.workspaceID)).
+			Where("source_id = ? AND source_type = ?", noteID, "note").
+			Pluck("id", &existingIDs).Error; err != nil {
+			return fmt.Errorf("failed to fetch existing connections: %w", err)
+		}
+		if len(existingIDs) > 0 {
+			if err := tx.Where("id IN ?", existingIDs).Delete(&models.Connection{}).Error; err != nil {
+				return fmt.Errorf("failed to delete existing connections: %w", err)
+			}
+		}
+		for _, id := range existingIDs {
+			if err := RecordSyncChange(tx, userID, models.SyncEntityConnection, id, models.SyncActionDelete); err != nil {
+				return err
+			}
 		}
 		
 		// Create new connections
 		for _, detected := range detectedConnections {
 			// Check if reverse connection exists to calculate strength
 			var existingConnection models.Connection
-			reverseExists := tx.Where("user_id = ? AND source_id = ? AND target_id = ? AND source_type = ? AND target_type = ?",
-				userID, detected.TargetID, detected.SourceID, detected.TargetType, detected.SourceType).
+			reverseExists := tx.Scopes(OwnedBy("connections", userID, s.workspaceID)).
+				Where("source_id = ? AND target_id = ? AND source_type = ? AND target_type = ?",
+					detected.TargetID, detected.SourceID, detected.TargetType, detected.SourceType).
 				First(&existingConnection).Error == nil
 			
 			strength := 1
@@ -137,8 +160,9 @@ func (s *ConnectionService) UpdateConnections(userID uuid.UUID, noteID uuid.UUID
 			}
 			
 			connection := models.Connection{
-				UserID:     userID,
-				SourceID:   detected.SourceID,
+				UserID:      userID,
+				WorkspaceID: s.workspaceID,
+				SourceID:    detected.SourceID,
 				SourceType: detected.SourceType,
 				TargetID:   detected.TargetID,
 				TargetType: detected.TargetType,
@@ -148,6 +172,9 @@ func (s *ConnectionService) UpdateConnections(userID uuid.UUID, noteID uuid.UUID
 			if err := tx.Create(&connection).Error; err != nil {
 				return fmt.Errorf("failed to create connection: %w", err)
 			}
+			if err := RecordSyncChange(tx, userID, models.SyncEntityConnection, connection.ID, models.SyncActionCreate); err != nil {
+				return err
+			}
 			
 			// Update reverse connection strength if it exists
 			if reverseExists {
@@ -155,6 +182,9 @@ func (s *ConnectionService) UpdateConnections(userID uuid.UUID, noteID uuid.UUID
 				if err := tx.Save(&existingConnection).Error; err != nil {
 					return fmt.Errorf("failed to update reverse connection strength: %w", err)
 				}
+				if err := RecordSyncChange(tx, userID, models.SyncEntityConnection, existingConnection.ID, models.SyncActionUpdate); err != nil {
+					return err
+				}
 			}
 		}
 		
@@ -162,6 +192,33 @@ func (s *ConnectionService) UpdateConnections(userID uuid.UUID, noteID uuid.UUID
 	})
 }
 
+// withoutTrashedEndpoints hides connections to or from notes and people in the
+// trash. The connections themselves are kept until the item is purged so that
+// restoring it brings them back.
+func withoutTrashedEndpoints(db *gorm.DB) *gorm.DB {
+	trashed := "SELECT id FROM notes WHERE deleted_at IS NOT NULL UNION SELECT id FROM people WHERE deleted_at IS NOT NULL"
+	return db.Where("connections.source_id NOT IN (" + trashed + ") AND connections.target_id NOT IN (" + trashed + ")")
+}
+
+// visibleConnections limits connections to those a user may see. In a
+// workspace that is the whole workspace graph. In the personal space it is
+// the user's own connections, plus links between two notes they can open
+// that someone else recorded on a note shared with them; links to the
+// owner's people stay private.
+func visibleConnections(userID uuid.UUID, workspaceID *uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if workspaceID != nil {
+			return db.Where("connections.workspace_id = ?", *workspaceID)
+		}
+		shared := "SELECT note_id FROM note_shares WHERE user_id = ?"
+		return db.Where("((connections.user_id = ? AND connections.workspace_id IS NULL) OR "+
+			"(connections.source_type = 'note' AND connections.target_type = 'note' AND "+
+			"connections.source_id IN ("+shared+") AND "+
+			"connections.target_id IN (SELECT id FROM notes WHERE (user_id = ? AND workspace_id IS NULL) OR id IN ("+shared+"))))",
+			userID, userID, userID, userID)
+	}
+}
+
 // GetGraphData returns the complete knowledge graph for a user
 func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]interface{}) (*GraphData, error) {
 	var nodes []GraphNode
@@ -169,7 +226,7 @@ func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]in
 	
 	// Get all notes as nodes
 	var notes []models.Note
-	noteQuery := s.db.Where("user_id = ? AND is_archived = ?", userID, false)
+	noteQuery := s.db.Scopes(AccessibleNotes(userID, s.workspaceID)).Where("is_archived = ?", false)
 	
 	// Apply filters
 	if category, ok := filters["category"].(string); ok && category != "" {
@@ -190,8 +247,8 @@ func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]in
 	for _, note := range notes {
 		// Count connections for this note
 		var connectionCount int64
-		s.db.Model(&models.Connection{}).Where("user_id = ? AND (source_id = ? OR target_id = ?)", 
-			userID, note.ID, note.ID).Count(&connectionCount)
+		s.db.Model(&models.Connection{}).Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Where("(source_id = ? OR target_id = ?)", 
+			note.ID, note.ID).Count(&connectionCount)
 		
 		var tags []string
 		for _, tag := range note.Tags {
@@ -212,7 +269,7 @@ func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]in
 	
 	// Get all people as nodes
 	var people []models.Person
-	if err := s.db.Where("user_id = ?", userID).Find(&people).Error; err != nil {
+	if err := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).Find(&people).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch people: %w", err)
 	}
 	
@@ -220,8 +277,8 @@ func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]in
 	for _, person := range people {
 		// Count connections for this person
 		var connectionCount int64
-		s.db.Model(&models.Connection{}).Where("user_id = ? AND (source_id = ? OR target_id = ?)", 
-			userID, person.ID, person.ID).Count(&connectionCount)
+		s.db.Model(&models.Connection{}).Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Where("(source_id = ? OR target_id = ?)", 
+			person.ID, person.ID).Count(&connectionCount)
 		
 		nodes = append(nodes, GraphNode{
 			ID:          person.ID,
@@ -235,7 +292,7 @@ func (s *ConnectionService) GetGraphData(userID uuid.UUID, filters map[string]in
 	
 	// Get all connections as edges
 	var connections []models.Connection
-	if err := s.db.Where("user_id = ?", userID).Find(&connections).Error; err != nil {
+	if err := s.db.Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Find(&connections).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch connections: %w", err)
 	}
 	
@@ -270,8 +327,8 @@ func (s *ConnectionService) SearchGraph(userID uuid.UUID, query string, nodeType
 	if nodeType == "" || nodeType == "note" {
 		// Search notes
 		var notes []models.Note
-		noteQuery := s.db.Where("user_id = ? AND is_archived = ? AND (LOWER(title) LIKE ? OR LOWER(CAST(content AS TEXT)) LIKE ?)", 
-			userID, false, "%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%")
+		noteQuery := s.db.Scopes(AccessibleNotes(userID, s.workspaceID)).Where("is_archived = ? AND (LOWER(title) LIKE ? OR LOWER(CAST(content AS TEXT)) LIKE ?)", 
+			false, "%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%")
 		
 		if err := noteQuery.Find(&notes).Error; err != nil {
 			return nil, fmt.Errorf("failed to search notes: %w", err)
@@ -279,8 +336,8 @@ func (s *ConnectionService) SearchGraph(userID uuid.UUID, query string, nodeType
 		
 		for _, note := range notes {
 			var connectionCount int64
-			s.db.Model(&models.Connection{}).Where("user_id = ? AND (source_id = ? OR target_id = ?)", 
-				userID, note.ID, note.ID).Count(&connectionCount)
+			s.db.Model(&models.Connection{}).Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Where("(source_id = ? OR target_id = ?)", 
+				note.ID, note.ID).Count(&connectionCount)
 			
 			var tags []string
 			for _, tag := range note.Tags {
@@ -303,8 +360,8 @@ func (s *ConnectionService) SearchGraph(userID uuid.UUID, query string, nodeType
 	if nodeType == "" || nodeType == "person" {
 		// Search people
 		var people []models.Person
-		personQuery := s.db.Where("user_id = ? AND (LOWER(name) LIKE ? OR LOWER(company) LIKE ? OR LOWER(title) LIKE ?)", 
-			userID, "%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%")
+		personQuery := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).Where("(LOWER(name) LIKE ? OR LOWER(company) LIKE ? OR LOWER(title) LIKE ?)", 
+			"%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%", "%"+strings.ToLower(query)+"%")
 		
 		if err := personQuery.Find(&people).Error; err != nil {
 			return nil, fmt.Errorf("failed to search people: %w", err)
@@ -312,8 +369,8 @@ func (s *ConnectionService) SearchGraph(userID uuid.UUID, query string, nodeType
 		
 		for _, person := range people {
 			var connectionCount int64
-			s.db.Model(&models.Connection{}).Where("user_id = ? AND (source_id = ? OR target_id = ?)", 
-				userID, person.ID, person.ID).Count(&connectionCount)
+			s.db.Model(&models.Connection{}).Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Where("(source_id = ? OR target_id = ?)", 
+				person.ID, person.ID).Count(&connectionCount)
 			
 			nodes = append(nodes, GraphNode{
 				ID:          person.ID,
@@ -332,7 +389,7 @@ func (s *ConnectionService) SearchGraph(userID uuid.UUID, query string, nodeType
 // GetNodeConnections returns all connections for a specific node
 func (s *ConnectionService) GetNodeConnections(userID uuid.UUID, nodeID uuid.UUID) ([]GraphEdge, error) {
 	var connections []models.Connection
-	if err := s.db.Where("user_id = ? AND (source_id = ? OR target_id = ?)", userID, nodeID, nodeID).
+	if err := s.db.Scopes(withoutTrashedEndpoints, visibleConnections(userID, s.workspaceID)).Where("(source_id = ? OR target_id = ?)", nodeID, nodeID).
 		Find(&connections).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch node connections: %w", err)
 	}
@@ -396,7 +453,7 @@ func (s *ConnectionService) detectPersonMentions(userID uuid.UUID, content strin
 	
 	// Get all people for this user
 	var people []models.Person
-	if err := s.db.Where("user_id = ?", userID).Find(&people).Error; err != nil {
+	if err := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).Find(&people).Error; err != nil {
 		return nil, err
 	}
 	
@@ -473,7 +530,7 @@ func (s *ConnectionService) detectNoteReferences(userID uuid.UUID, content strin
 	
 	// Get all notes for this user
 	var notes []models.Note
-	if err := s.db.Where("user_id = ? AND is_archived = ?", userID, false).Find(&notes).Error; err != nil {
+	if err := s.db.Scopes(OwnedBy("notes", userID, s.workspaceID)).Where("is_archived = ?", false).Find(&notes).Error; err != nil {
 		return nil, err
 	}
 	
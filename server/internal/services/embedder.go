@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEmbeddingsUnsupported is returned by Embed for providers that cannot
+// embed text
+var ErrEmbeddingsUnsupported = errors.New("the AI provider does not support embeddings")
+
+// Embedder turns text into vectors whose cosine similarity reflects how close
+// the texts are in meaning
+type Embedder interface {
+	// Embed returns one vector for each text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// EmbeddingModel names the model vectors come from. Vectors from
+	// different models are never compared.
+	EmbeddingModel() string
+}
+
+// CanEmbed reports whether the configured provider embeds text with a known
+// model. Local providers, and OpenAI-compatible endpoints set with BaseURL,
+// need no API key.
+func (s *AIService) CanEmbed() bool {
+	return s.EmbeddingModel() != ""
+}
+
+// EmbeddingModel returns the configured embedding model or the provider's
+// default, or an empty string when the provider cannot embed
+func (s *AIService) EmbeddingModel() string {
+	if embedder, ok := s.provider.(AIEmbeddingProvider); ok {
+		return embedder.EmbeddingModel()
+	}
+	return ""
+}
+
+// Embed embeds texts with the configured provider
+func (s *AIService) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if !s.CanEmbed() {
+		return nil, ErrEmbeddingsUnsupported
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	embedder := s.provider.(AIEmbeddingProvider)
+	var vectors [][]float32
+	_, err := s.metered(ctx, embedder.EmbeddingModel(), strings.Join(texts, "\n"), func() (string, AITokens, error) {
+		embedded, tokens, err := embedder.EmbedTexts(ctx, texts)
+		vectors = embedded
+		return "", tokens, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	return vectors, nil
+}
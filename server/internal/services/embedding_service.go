@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// embeddingChunkWords is the length of the passages notes are split into
+	// for embedding, and embeddingChunkOverlap how many words consecutive
+	// passages share so that a sentence is never only cut in half
+	embeddingChunkWords   = 200
+	embeddingChunkOverlap = 40
+
+	// embeddingMaxChunks caps how many passages of a long note are embedded
+	embeddingMaxChunks = 64
+
+	// embeddingIndexBatch is how many notes each run of the index job embeds
+	embeddingIndexBatch = 50
+
+	// hybridCandidates is how many keyword and semantic matches a hybrid
+	// search fuses, and hybridRankConstant damps the weight of the top ranks
+	// in reciprocal rank fusion
+	hybridCandidates   = 50
+	hybridRankConstant = 60
+)
+
+// ErrSemanticSearchDisabled is returned when no embedder is configured
+var ErrSemanticSearchDisabled = errors.New("semantic search is not configured")
+
+// Semantic search modes
+const (
+	SemanticModeSemantic = "semantic"
+	SemanticModeHybrid   = "hybrid"
+)
+
+// EmbeddingService embeds notes and finds the notes closest in meaning to a
+// query. Vectors are compared by brute force, which is fast enough for the
+// tens of thousands of passages a personal knowledge base holds.
+type EmbeddingService struct {
+	db          *gorm.DB
+	embedder    Embedder
+	search      *SearchService
+	workspaceID *uuid.UUID
+}
+
+// NewEmbeddingService creates an embedding service. embedder may be nil, in
+// which case searches return ErrSemanticSearchDisabled.
+func NewEmbeddingService(db *gorm.DB, embedder Embedder) *EmbeddingService {
+	return &EmbeddingService{
+		db:       db,
+		embedder: embedder,
+		search:   NewSearchService(db),
+	}
+}
+
+// InWorkspace returns a copy of the service searching the notes of a
+// workspace, or the user's personal and shared notes when workspaceID is nil
+func (s *EmbeddingService) InWorkspace(workspaceID *uuid.UUID) *EmbeddingService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	scoped.search = s.search.InWorkspace(workspaceID)
+	return &scoped
+}
+
+// Enabled reports whether an embedder is configured
+func (s *EmbeddingService) Enabled() bool {
+	return s.embedder != nil
+}
+
+// SemanticResult is a note matching a semantic or hybrid search
+type SemanticResult struct {
+	Note  models.Note `json:"note"`
+	Score float64     `json:"score"`
+	// Passage is the part of the note closest to the query
+	Passage   string `json:"passage,omitempty"`
+	MatchType string `json:"match_type"` // "semantic", "keyword" or "both"
+}
+
+// SemanticSearchResponse is the response to a semantic or hybrid search
+type SemanticSearchResponse struct {
+	Results []SemanticResult `json:"results"`
+	Query   string           `json:"query"`
+	Mode    string           `json:"mode"`
+	Limit   int              `json:"limit"`
+	Took    time.Duration    `json:"took"`
+}
+
+// IndexNote embeds a note's passages, reusing the vectors of passages that
+// have not changed
+func (s *EmbeddingService) IndexNote(ctx context.Context, note *models.Note) error {
+	if s.embedder == nil {
+		return ErrSemanticSearchDisabled
+	}
+	model := s.embedder.EmbeddingModel()
+
+	var existing []models.NoteEmbedding
+	if err := s.db.Where("note_id = ? AND model = ?", note.ID, model).Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to fetch note embeddings: %w", err)
+	}
+	vectors := make(map[string][]byte, len(existing))
+	for _, embedding := range existing {
+		vectors[embedding.Hash] = embedding.Vector
+	}
+
+	chunks := noteChunks(note)
+	hashes := make([]string, len(chunks))
+	var missing []string
+	for i, chunk := range chunks {
+		sum := sha256.Sum256([]byte(chunk))
+		hashes[i] = hex.EncodeToString(sum[:])
+		if _, ok := vectors[hashes[i]]; !ok {
+			missing = append(missing, chunk)
+			vectors[hashes[i]] = nil
+		}
+	}
+
+	if len(missing) > 0 {
+		embedded, err := s.embedder.Embed(withAICaller(ctx, note.UserID, AIFeatureIndexing), missing)
+		if err != nil {
+			return fmt.Errorf("failed to embed note %s: %w", note.ID, err)
+		}
+		for i, chunk := range missing {
+			sum := sha256.Sum256([]byte(chunk))
+			vectors[hex.EncodeToString(sum[:])] = encodeVector(normalizeVector(embedded[i]))
+		}
+	}
+
+	// A note without text keeps one empty row, so it is not picked up
+	// again until it changes
+	rows := []models.NoteEmbedding{{NoteID: note.ID, Model: model, IndexedAt: note.UpdatedAt}}
+	if len(chunks) > 0 {
+		rows = make([]models.NoteEmbedding, len(chunks))
+		for i, chunk := range chunks {
+			rows[i] = models.NoteEmbedding{
+				NoteID:     note.ID,
+				Model:      model,
+				ChunkIndex: i,
+				Content:    chunk,
+				Hash:       hashes[i],
+				Vector:     vectors[hashes[i]],
+				IndexedAt:  note.UpdatedAt,
+			}
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("note_id = ? AND model = ?", note.ID, model).Delete(&models.NoteEmbedding{}).Error; err != nil {
+			return fmt.Errorf("failed to replace note embeddings: %w", err)
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to store note embeddings: %w", err)
+		}
+		return nil
+	})
+}
+
+// IndexPending embeds up to limit notes that changed since they were last
+// embedded, most recently changed first, and returns how many it embedded
+func (s *EmbeddingService) IndexPending(ctx context.Context, limit int) (int, error) {
+	if s.embedder == nil {
+		return 0, ErrSemanticSearchDisabled
+	}
+
+	var notes []models.Note
+	if err := s.db.Where(`NOT EXISTS (SELECT 1 FROM note_embeddings
+			WHERE note_embeddings.note_id = notes.id AND note_embeddings.model = ?
+			AND note_embeddings.indexed_at >= notes.updated_at)`, s.embedder.EmbeddingModel()).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&notes).Error; err != nil {
+		return 0, fmt.Errorf("failed to find notes to embed: %w", err)
+	}
+
+	for i := range notes {
+		if err := s.IndexNote(ctx, &notes[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(notes), nil
+}
+
+// RunIndexJob embeds changed notes every interval until the process exits.
+// A run stops at the first failure, such as the provider being unreachable,
+// and the next run tries again.
+func (s *EmbeddingService) RunIndexJob(interval time.Duration) {
+	if interval <= 0 || s.embedder == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			indexed, err := s.IndexPending(context.Background(), embeddingIndexBatch)
+			if err != nil {
+				log.Printf("Note embedding failed: %v", err)
+				break
+			}
+			if indexed < embeddingIndexBatch {
+				break
+			}
+		}
+	}
+}
+
+// SemanticSearch returns the unarchived notes whose passages are closest in
+// meaning to q
+func (s *EmbeddingService) SemanticSearch(ctx context.Context, userID uuid.UUID, q string, limit int) (*SemanticSearchResponse, error) {
+	start := time.Now()
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	matches, err := s.nearest(ctx, userID, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.semanticResults(matches, func(match passageMatch) (float64, string) {
+		return match.score, SemanticModeSemantic
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SemanticSearchResponse{
+		Results: results,
+		Query:   q,
+		Mode:    SemanticModeSemantic,
+		Limit:   limit,
+		Took:    time.Since(start),
+	}, nil
+}
+
+// HybridSearch fuses the keyword and semantic rankings of q with reciprocal
+// rank fusion, so notes ranked well by both come first
+func (s *EmbeddingService) HybridSearch(ctx context.Context, userID uuid.UUID, q string, limit int) (*SemanticSearchResponse, error) {
+	start := time.Now()
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	results, err := s.fuse(ctx, userID, q, q, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SemanticSearchResponse{
+		Results: results,
+		Query:   q,
+		Mode:    SemanticModeHybrid,
+		Limit:   limit,
+		Took:    time.Since(start),
+	}, nil
+}
+
+// fuse ranks up to limit notes by reciprocal rank fusion of their keyword
+// ranking for keywords, a search query, and their semantic ranking for text.
+// An empty keywords query only ranks by meaning.
+func (s *EmbeddingService) fuse(ctx context.Context, userID uuid.UUID, keywords, text string, limit int) ([]SemanticResult, error) {
+	keyword := &SearchResponse{}
+	if strings.TrimSpace(keywords) != "" {
+		var err error
+		keyword, err = s.search.FullTextSearch(userID, SearchRequest{Query: keywords, Limit: hybridCandidates, SortBy: "relevance"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	semantic, err := s.nearest(ctx, userID, text, hybridCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uuid.UUID]float64)
+	matchTypes := make(map[uuid.UUID]string)
+	var fused []passageMatch
+	for rank, match := range semantic {
+		scores[match.noteID] += 1 / float64(hybridRankConstant+rank+1)
+		matchTypes[match.noteID] = SemanticModeSemantic
+		fused = append(fused, match)
+	}
+	for rank, result := range keyword.Results {
+		id := result.Note.ID
+		scores[id] += 1 / float64(hybridRankConstant+rank+1)
+		if matchTypes[id] == SemanticModeSemantic {
+			matchTypes[id] = "both"
+			continue
+		}
+		matchTypes[id] = "keyword"
+		fused = append(fused, passageMatch{noteID: id})
+	}
+
+	sort.SliceStable(fused, func(i, j int) bool {
+		return scores[fused[i].noteID] > scores[fused[j].noteID]
+	})
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+
+	return s.semanticResults(fused, func(match passageMatch) (float64, string) {
+		return scores[match.noteID], matchTypes[match.noteID]
+	})
+}
+
+// passageMatch is a note and its passage closest to a query
+type passageMatch struct {
+	noteID  uuid.UUID
+	passage string
+	score   float64
+}
+
+// nearest returns up to limit unarchived notes the user can see, by the
+// cosine similarity of their closest passage to q
+func (s *EmbeddingService) nearest(ctx context.Context, userID uuid.UUID, q string, limit int) ([]passageMatch, error) {
+	if s.embedder == nil {
+		return nil, ErrSemanticSearchDisabled
+	}
+
+	embedded, err := s.embedder.Embed(withAICaller(ctx, userID, AIFeatureSearch), []string{q})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	query := normalizeVector(embedded[0])
+
+	rows, err := s.db.Model(&models.NoteEmbedding{}).
+		Select("note_embeddings.note_id, note_embeddings.content, note_embeddings.vector").
+		Joins("JOIN notes ON notes.id = note_embeddings.note_id AND notes.deleted_at IS NULL").
+		Scopes(AccessibleNotes(userID, s.workspaceID)).
+		Where("note_embeddings.model = ? AND notes.is_archived = ?", s.embedder.EmbeddingModel(), false).
+		Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch note embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	best := make(map[uuid.UUID]passageMatch)
+	for rows.Next() {
+		var match passageMatch
+		var vector []byte
+		if err := rows.Scan(&match.noteID, &match.passage, &vector); err != nil {
+			return nil, fmt.Errorf("failed to read note embedding: %w", err)
+		}
+		if len(vector) != 4*len(query) {
+			continue
+		}
+		match.score = dotProduct(query, vector)
+		if current, ok := best[match.noteID]; !ok || match.score > current.score {
+			best[match.noteID] = match
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read note embeddings: %w", err)
+	}
+
+	matches := make([]passageMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// semanticResults loads the notes of matches, in order, scored by score
+func (s *EmbeddingService) semanticResults(matches []passageMatch, score func(passageMatch) (float64, string)) ([]SemanticResult, error) {
+	results := make([]SemanticResult, 0, len(matches))
+	if len(matches) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uuid.UUID, len(matches))
+	for i, match := range matches {
+		ids[i] = match.noteID
+	}
+	var notes []models.Note
+	if err := s.db.Where("id IN ?", ids).Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch notes: %w", err)
+	}
+	byID := make(map[uuid.UUID]models.Note, len(notes))
+	for _, note := range notes {
+		byID[note.ID] = note
+	}
+
+	for _, match := range matches {
+		note, ok := byID[match.noteID]
+		if !ok {
+			continue
+		}
+		value, matchType := score(match)
+		results = append(results, SemanticResult{
+			Note:      note,
+			Score:     value,
+			Passage:   match.passage,
+			MatchType: matchType,
+		})
+	}
+	return results, nil
+}
+
+// noteChunks splits a note's title and text into overlapping passages of
+// embeddingChunkWords words. Each passage starts with the title, which gives
+// it context.
+func noteChunks(note *models.Note) []string {
+	var words []string
+	collectWords(map[string]interface{}(note.Content), &words)
+
+	title := strings.TrimSpace(note.Title)
+	if len(words) == 0 {
+		if title == "" {
+			return nil
+		}
+		return []string{title}
+	}
+
+	var chunks []string
+	step := embeddingChunkWords - embeddingChunkOverlap
+	for start := 0; start < len(words) && len(chunks) < embeddingMaxChunks; start += step {
+		end := min(start+embeddingChunkWords, len(words))
+		chunk := strings.Join(words[start:end], " ")
+		if title != "" {
+			chunk = title + "\n\n" + chunk
+		}
+		chunks = append(chunks, chunk)
+		if end == len(words) {
+			break
+		}
+	}
+	return chunks
+}
+
+// collectWords appends the words of the text nodes in a ProseMirror node
+func collectWords(node map[string]interface{}, words *[]string) {
+	if node == nil {
+		return
+	}
+	if text, ok := node["text"].(string); ok && node["type"] == "text" {
+		*words = append(*words, strings.Fields(text)...)
+	}
+	if children, ok := node["content"].([]interface{}); ok {
+		for _, child := range children {
+			if childMap, ok := child.(map[string]interface{}); ok {
+				collectWords(childMap, words)
+			}
+		}
+	}
+}
+
+// normalizeVector scales a vector to unit length, so that the dot product
+// of two vectors is their cosine similarity
+func normalizeVector(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := float32(math.Sqrt(sum))
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = v / norm
+	}
+	return normalized
+}
+
+// encodeVector stores a vector as little-endian float32s
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+// dotProduct multiplies a vector with an encoded one without decoding it
+func dotProduct(vector []float32, data []byte) float64 {
+	var sum float64
+	for i, v := range vector {
+		sum += float64(v) * float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+	}
+	return sum
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wordEmbedder embeds text as counts of its words hashed into a few
+// dimensions, so texts sharing words are close
+type wordEmbedder struct {
+	embedded []string
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.embedded = append(e.embedded, texts...)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,")))
+			vectors[i][h.Sum32()%64]++
+		}
+	}
+	return vectors, nil
+}
+
+func (e *wordEmbedder) EmbeddingModel() string {
+	return "words"
+}
+
+func TestNoteChunks(t *testing.T) {
+	words := make([]string, 450)
+	for i := range words {
+		words[i] = "w"
+	}
+	words[0], words[199], words[200], words[449] = "first", "end1", "after", "last"
+	note := &models.Note{Title: "Title", Content: models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": strings.Join(words, " ")},
+		}},
+	}}}
+
+	chunks := noteChunks(note)
+	require.Len(t, chunks, 3)
+	assert.True(t, strings.HasPrefix(chunks[0], "Title\n\nfirst "))
+	assert.True(t, strings.HasSuffix(chunks[0], " end1"))
+	// Consecutive chunks overlap
+	assert.Contains(t, chunks[1], "end1 after")
+	assert.True(t, strings.HasSuffix(chunks[2], " last"))
+
+	assert.Equal(t, []string{"Only a title"}, noteChunks(&models.Note{Title: "Only a title"}))
+	assert.Nil(t, noteChunks(&models.Note{}))
+}
+
+func TestEmbeddingService_IndexAndSearch(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	embedder := &wordEmbedder{}
+	service := NewEmbeddingService(db, embedder)
+	ctx := context.Background()
+
+	indexed, err := service.IndexPending(ctx, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 5, indexed)
+	indexed, err = service.IndexPending(ctx, 50)
+	require.NoError(t, err)
+	assert.Zero(t, indexed, "unchanged notes are not embedded again")
+
+	response, err := service.SemanticSearch(ctx, user.ID, "javascript development patterns", 3)
+	require.NoError(t, err)
+	require.NotEmpty(t, response.Results)
+	assert.Equal(t, "JavaScript Best Practices", response.Results[0].Note.Title)
+	assert.Contains(t, response.Results[0].Passage, "Modern JavaScript development")
+	for _, result := range response.Results {
+		assert.NotEqual(t, "Archived Note", result.Note.Title)
+	}
+
+	// Changed notes are picked up again, and only new passages are embedded
+	var note models.Note
+	require.NoError(t, db.Where("title = ?", "Python Data Science").First(&note).Error)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, db.Model(&note).Update("title", "Python Machine Learning").Error)
+	embedder.embedded = nil
+	indexed, err = service.IndexPending(ctx, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 1, indexed)
+	require.Len(t, embedder.embedded, 1)
+	assert.True(t, strings.HasPrefix(embedder.embedded[0], "Python Machine Learning"))
+
+	// Other users see nothing
+	other := createTestUser(t, db)
+	response, err = service.SemanticSearch(ctx, other.ID, "javascript", 10)
+	require.NoError(t, err)
+	assert.Empty(t, response.Results)
+
+	hybrid, err := service.HybridSearch(ctx, user.ID, "javascript", 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, hybrid.Results)
+	assert.Equal(t, "JavaScript Best Practices", hybrid.Results[0].Note.Title)
+	assert.Equal(t, "both", hybrid.Results[0].MatchType)
+	assert.Equal(t, SemanticModeHybrid, hybrid.Mode)
+
+	_, err = NewEmbeddingService(db, nil).SemanticSearch(ctx, user.ID, "go", 10)
+	assert.ErrorIs(t, err, ErrSemanticSearchDisabled)
+}
+
+func TestAIService_Embed(t *testing.T) {
+	var authorization, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.String()
+		if strings.Contains(path, "batchEmbedContents") {
+			w.Write([]byte(`{"embeddings": [{"values": [1, 0]}, {"values": [0, 1]}]}`))
+			return
+		}
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "nomic-embed-text", body.Model)
+		// Results may come back out of order
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`))
+	}))
+	defer server.Close()
+
+	// A local OpenAI-compatible server needs no key
+	local := NewAIService(nil, &AIConfig{Provider: ProviderOpenAI, BaseURL: server.URL, EmbeddingModel: "nomic-embed-text"})
+	require.True(t, local.CanEmbed())
+	vectors, err := local.Embed(context.Background(), []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, vectors)
+	assert.Empty(t, authorization)
+	assert.Equal(t, "/embeddings", path)
+
+	gemini := NewAIService(nil, &AIConfig{Provider: ProviderGemini, APIKey: "key", BaseURL: server.URL})
+	vectors, err = gemini.Embed(context.Background(), []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, vectors)
+	assert.Equal(t, "/models/text-embedding-004:batchEmbedContents?key=key", path)
+
+	grok := NewAIService(nil, &AIConfig{Provider: ProviderGrok, APIKey: "key"})
+	assert.False(t, grok.CanEmbed())
+	_, err = grok.Embed(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, ErrEmbeddingsUnsupported)
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"notesage-server/internal/markdown"
+	"notesage-server/internal/models"
+)
+
+// enexTimeLayout is the timestamp format used in ENEX files
+const enexTimeLayout = "20060102T150405Z"
+
+// safeImageSrcPattern matches image sources that may be kept from an import
+var safeImageSrcPattern = regexp.MustCompile(`(?i)^https?://`)
+
+// safeHrefPattern matches link targets that may be kept from an import
+var safeHrefPattern = regexp.MustCompile(`(?i)^(?:https?:|mailto:|evernote:)`)
+
+// enexNote is a <note> element of an ENEX file
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+// enexResource is a file attached to an ENEX note
+type enexResource struct {
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// ReadEvernoteExport reads the notes of an Evernote ENEX file into
+// baseFolder. Notes tagged as a person become people. Attached files are not
+// imported and are reported as skipped.
+func ReadEvernoteExport(r io.Reader, baseFolder string) (*ImportBatch, error) {
+	decoder := xml.NewDecoder(r)
+	batch := &ImportBatch{}
+	folderPath := JoinFolderPath(baseFolder, "")
+
+	count := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENEX file: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		count++
+		if count > maxImportEntries {
+			return nil, ErrTooManyImportFiles
+		}
+		var item enexNote
+		if err := decoder.DecodeElement(&item, &start); err != nil {
+			return nil, fmt.Errorf("invalid ENEX file: %w", err)
+		}
+
+		title := strings.TrimSpace(item.Title)
+		if title == "" {
+			title = fmt.Sprintf("Untitled %d", count)
+		}
+		for _, resource := range item.Resources {
+			name := resource.FileName
+			if name == "" {
+				name = resource.Mime
+			}
+			batch.Skipped = append(batch.Skipped, SkippedItem{Path: title + "/" + name, Reason: "attachments are not imported"})
+		}
+
+		content, err := enmlToContent(item.Content)
+		if err != nil {
+			batch.Skipped = append(batch.Skipped, SkippedItem{Path: title, Reason: err.Error()})
+			continue
+		}
+
+		if isPersonDocument("", item.Tags) {
+			batch.People = append(batch.People, ImportedPerson{
+				Path:  title,
+				Name:  title,
+				Notes: strings.TrimSpace(markdown.Render(content)),
+			})
+			continue
+		}
+
+		created, _ := time.Parse(enexTimeLayout, item.Created)
+		updated, _ := time.Parse(enexTimeLayout, item.Updated)
+		batch.Notes = append(batch.Notes, ImportedNote{
+			Path:       title,
+			Title:      title,
+			Tags:       item.Tags,
+			FolderPath: folderPath,
+			Content:    content,
+			CreatedAt:  created,
+			UpdatedAt:  updated,
+		})
+	}
+
+	if count == 0 {
+		return nil, errors.New("invalid ENEX file: no notes found")
+	}
+	return batch, nil
+}
+
+// enmlElement is a parsed element of an ENML note body
+type enmlElement struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*enmlElement
+}
+
+// parseENML reads ENML, the XHTML subset Evernote stores note bodies in, into
+// a tree. Text is held by elements with no name.
+func parseENML(src string) (*enmlElement, error) {
+	decoder := xml.NewDecoder(strings.NewReader(src))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &enmlElement{name: "root"}
+	stack := []*enmlElement{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid note content: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &enmlElement{name: strings.ToLower(t.Name.Local), attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				element.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			parent.children = append(parent.children, element)
+			stack = append(stack, element)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &enmlElement{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// enmlToContent converts an ENML note body to editor content
+func enmlToContent(src string) (models.JSONB, error) {
+	root, err := parseENML(src)
+	if err != nil {
+		return nil, err
+	}
+	body := root
+	for _, child := range root.children {
+		if child.name == "en-note" {
+			body = child
+			break
+		}
+	}
+
+	content := enmlBlocks(body.children)
+	if len(content) == 0 {
+		content = []interface{}{map[string]interface{}{"type": "paragraph"}}
+	}
+	return models.JSONB{"type": "doc", "content": content}, nil
+}
+
+// enmlBlocks converts elements to block nodes. Runs of text and inline
+// elements between blocks become paragraphs, or task items when they start
+// with a checkbox, and adjacent task items are grouped into a task list.
+func enmlBlocks(elements []*enmlElement) []interface{} {
+	var blocks []interface{}
+	var run []*enmlElement
+
+	add := func(block map[string]interface{}) {
+		if block["type"] == "taskItem" {
+			if len(blocks) > 0 {
+				if last, ok := blocks[len(blocks)-1].(map[string]interface{}); ok && last["type"] == "taskList" {
+					last["content"] = append(last["content"].([]interface{}), block)
+					return
+				}
+			}
+			block = enmlNode("taskList", nil, block)
+		}
+		blocks = append(blocks, block)
+	}
+	flush := func() {
+		if block := enmlParagraph(run); block != nil {
+			add(block)
+		}
+		run = nil
+	}
+
+	for _, element := range elements {
+		switch element.name {
+		case "div", "p", "en-note", "center", "section", "article", "span", "font":
+			if element.name == "div" && strings.Contains(element.attrs["style"], "-en-codeblock") {
+				flush()
+				add(enmlCodeBlock(enmlText(element)))
+				continue
+			}
+			if !hasEnmlBlocks(element) {
+				if element.name == "span" || element.name == "font" {
+					run = append(run, element)
+					continue
+				}
+				flush()
+				run = element.children
+				flush()
+				continue
+			}
+			flush()
+			for _, block := range enmlBlocks(element.children) {
+				add(block.(map[string]interface{}))
+			}
+
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			flush()
+			level := int(element.name[1] - '0')
+			add(enmlNode("heading", map[string]interface{}{"level": level}, enmlInline(element.children, nil)...))
+
+		case "ul", "ol":
+			flush()
+			var items []interface{}
+			for _, child := range element.children {
+				if child.name != "li" {
+					continue
+				}
+				itemContent := enmlBlocks(child.children)
+				if len(itemContent) == 0 {
+					itemContent = []interface{}{enmlNode("paragraph", nil)}
+				}
+				items = append(items, enmlNode("listItem", nil, itemContent...))
+			}
+			if len(items) == 0 {
+				continue
+			}
+			listType := "bulletList"
+			if element.name == "ol" {
+				listType = "orderedList"
+			}
+			add(enmlNode(listType, nil, items...))
+
+		case "table":
+			flush()
+			if table := enmlTable(element); table != nil {
+				add(table)
+			}
+
+		case "pre":
+			flush()
+			add(enmlCodeBlock(enmlText(element)))
+
+		case "blockquote":
+			flush()
+			quoted := enmlBlocks(element.children)
+			if len(quoted) == 0 {
+				continue
+			}
+			add(enmlNode("blockquote", nil, quoted...))
+
+		case "hr":
+			flush()
+			add(enmlNode("horizontalRule", nil))
+
+		case "en-media", "en-crypt", "script", "style", "head", "title":
+			// Attachments are reported by the caller; the rest has no content
+
+		default:
+			run = append(run, element)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// hasEnmlBlocks reports whether an element holds block elements
+func hasEnmlBlocks(element *enmlElement) bool {
+	for _, child := range element.children {
+		switch child.name {
+		case "div", "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "table", "pre", "blockquote", "hr":
+			return true
+		case "span", "font":
+			if hasEnmlBlocks(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enmlParagraph converts a run of inline elements to a paragraph, or to a
+// task item when it starts with a checkbox. Runs holding only whitespace and
+// line breaks give nil.
+func enmlParagraph(run []*enmlElement) map[string]interface{} {
+	for len(run) > 0 && run[0].name == "" && strings.TrimSpace(run[0].text) == "" {
+		run = run[1:]
+	}
+	if len(run) > 0 && run[0].name == "en-todo" {
+		checked := strings.EqualFold(run[0].attrs["checked"], "true")
+		paragraph := enmlNode("paragraph", nil, trimInline(enmlInline(run[1:], nil))...)
+		return enmlNode("taskItem", map[string]interface{}{"checked": checked}, paragraph)
+	}
+
+	inline := trimInline(enmlInline(run, nil))
+	if len(inline) == 0 {
+		return nil
+	}
+	return enmlNode("paragraph", nil, inline...)
+}
+
+// enmlInline converts elements to text, hard break and image nodes, with the
+// marks of the elements around them
+func enmlInline(elements []*enmlElement, marks []interface{}) []interface{} {
+	var nodes []interface{}
+	for _, element := range elements {
+		switch element.name {
+		case "":
+			text := whitespacePattern.ReplaceAllString(element.text, " ")
+			if text == "" {
+				continue
+			}
+			textNode := map[string]interface{}{"type": "text", "text": text}
+			if len(marks) > 0 {
+				textNode["marks"] = append([]interface{}{}, marks...)
+			}
+			nodes = append(nodes, textNode)
+		case "br":
+			nodes = append(nodes, enmlNode("hardBreak", nil))
+		case "img":
+			if src := element.attrs["src"]; safeImageSrcPattern.MatchString(src) {
+				nodes = append(nodes, enmlNode("image", map[string]interface{}{"src": src, "alt": element.attrs["alt"]}))
+			}
+		case "en-todo", "en-media", "en-crypt", "script", "style":
+		default:
+			nodes = append(nodes, enmlInline(element.children, enmlMarks(element, marks))...)
+		}
+	}
+	return nodes
+}
+
+// whitespacePattern matches runs of whitespace, which HTML shows as one space
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// enmlMarks adds the mark an inline element applies to marks
+func enmlMarks(element *enmlElement, marks []interface{}) []interface{} {
+	var mark map[string]interface{}
+	switch element.name {
+	case "b", "strong":
+		mark = map[string]interface{}{"type": "bold"}
+	case "i", "em":
+		mark = map[string]interface{}{"type": "italic"}
+	case "s", "strike", "del":
+		mark = map[string]interface{}{"type": "strike"}
+	case "code", "tt":
+		mark = map[string]interface{}{"type": "code"}
+	case "a":
+		href := strings.TrimSpace(element.attrs["href"])
+		if !safeHrefPattern.MatchString(href) {
+			return marks
+		}
+		mark = map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": href}}
+	default:
+		return marks
+	}
+	for _, existing := range marks {
+		if existing.(map[string]interface{})["type"] == mark["type"] {
+			return marks
+		}
+	}
+	return append(append([]interface{}{}, marks...), mark)
+}
+
+// trimInline removes whitespace at the start and end of inline content
+func trimInline(nodes []interface{}) []interface{} {
+	for len(nodes) > 0 {
+		last := nodes[len(nodes)-1].(map[string]interface{})
+		if last["type"] == "hardBreak" {
+			nodes = nodes[:len(nodes)-1]
+			continue
+		}
+		if last["type"] == "text" {
+			text := strings.TrimRight(last["text"].(string), " ")
+			if text == "" {
+				nodes = nodes[:len(nodes)-1]
+				continue
+			}
+			last["text"] = text
+		}
+		break
+	}
+	for len(nodes) > 0 {
+		first := nodes[0].(map[string]interface{})
+		if first["type"] == "text" {
+			text := strings.TrimLeft(first["text"].(string), " ")
+			if text == "" {
+				nodes = nodes[1:]
+				continue
+			}
+			first["text"] = text
+		}
+		break
+	}
+	return nodes
+}
+
+// enmlTable converts a table to a table node, with th cells as headers
+func enmlTable(table *enmlElement) map[string]interface{} {
+	var rows []interface{}
+	var collect func(elements []*enmlElement)
+	collect = func(elements []*enmlElement) {
+		for _, element := range elements {
+			switch element.name {
+			case "thead", "tbody", "tfoot":
+				collect(element.children)
+			case "tr":
+				var cells []interface{}
+				for _, cell := range element.children {
+					if cell.name != "td" && cell.name != "th" {
+						continue
+					}
+					cellType := "tableCell"
+					if cell.name == "th" {
+						cellType = "tableHeader"
+					}
+					content := enmlBlocks(cell.children)
+					if len(content) == 0 {
+						content = []interface{}{enmlNode("paragraph", nil)}
+					}
+					cells = append(cells, enmlNode(cellType, nil, content...))
+				}
+				if len(cells) > 0 {
+					rows = append(rows, enmlNode("tableRow", nil, cells...))
+				}
+			}
+		}
+	}
+	collect(table.children)
+	if len(rows) == 0 {
+		return nil
+	}
+	return enmlNode("table", nil, rows...)
+}
+
+// enmlText returns the text of an element, with block elements and line
+// breaks as new lines
+func enmlText(element *enmlElement) string {
+	var out strings.Builder
+	var walk func(e *enmlElement)
+	walk = func(e *enmlElement) {
+		switch e.name {
+		case "":
+			out.WriteString(e.text)
+			return
+		case "br":
+			out.WriteString("\n")
+			return
+		}
+		for _, child := range e.children {
+			walk(child)
+		}
+		if e.name == "div" || e.name == "p" {
+			if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+				out.WriteString("\n")
+			}
+		}
+	}
+	walk(element)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func enmlCodeBlock(code string) map[string]interface{} {
+	if code == "" {
+		return enmlNode("codeBlock", nil)
+	}
+	return enmlNode("codeBlock", nil, map[string]interface{}{"type": "text", "text": code})
+}
+
+func enmlNode(nodeType string, attrs map[string]interface{}, content ...interface{}) map[string]interface{} {
+	n := map[string]interface{}{"type": nodeType}
+	if attrs != nil {
+		n["attrs"] = attrs
+	}
+	if len(content) > 0 {
+		n["content"] = content
+	}
+	return n
+}
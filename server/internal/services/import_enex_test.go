@@ -0,0 +1,86 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testENEX = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20240501T120000Z" application="Evernote">
+  <note>
+    <title>Trip &amp; plans</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><h2>Packing</h2><div><en-todo checked="true"/>Passport</div><div><en-todo/>Charger&nbsp;cable</div><div><br/></div><div>Ask <b>Sam</b> about <a href="https://example.com/hotel">the hotel</a> <a href="javascript:alert(1)">now</a></div><ul><li>Day one</li><li><div>Day two</div></li></ul><div style="-en-codeblock:true"><div>make pack</div><div>make go</div></div><table><tr><th>City</th><th>Nights</th></tr><tr><td>Rome</td><td>3</td></tr></table><en-media type="image/png" hash="abc"/></en-note>]]></content>
+    <created>20240301T093000Z</created>
+    <updated>20240302T100000Z</updated>
+    <tag>travel</tag>
+    <resource>
+      <mime>image/png</mime>
+      <resource-attributes><file-name>map.png</file-name></resource-attributes>
+    </resource>
+  </note>
+  <note>
+    <title>Sam Lee</title>
+    <content><![CDATA[<en-note><div>Met at the conference.</div></en-note>]]></content>
+    <tag>person</tag>
+  </note>
+</en-export>`
+
+func TestReadEvernoteExport(t *testing.T) {
+	batch, err := ReadEvernoteExport(strings.NewReader(testENEX), "/Evernote")
+	require.NoError(t, err)
+
+	require.Len(t, batch.Notes, 1)
+	note := batch.Notes[0]
+	assert.Equal(t, "Trip & plans", note.Title)
+	assert.Equal(t, "/Evernote", note.FolderPath)
+	assert.Equal(t, []string{"travel"}, note.Tags)
+	assert.Equal(t, time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), note.CreatedAt)
+	assert.Equal(t, time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC), note.UpdatedAt)
+
+	content := note.Content["content"].([]interface{})
+	types := make([]string, len(content))
+	for i, block := range content {
+		types[i] = block.(map[string]interface{})["type"].(string)
+	}
+	assert.Equal(t, []string{"heading", "taskList", "paragraph", "bulletList", "codeBlock", "table"}, types)
+
+	tasks := content[1].(map[string]interface{})["content"].([]interface{})
+	require.Len(t, tasks, 2)
+	assert.Equal(t, true, tasks[0].(map[string]interface{})["attrs"].(map[string]interface{})["checked"])
+	assert.Equal(t, false, tasks[1].(map[string]interface{})["attrs"].(map[string]interface{})["checked"])
+
+	text := noteText(models.Note{Content: note.Content})
+	assert.Contains(t, text, "PassportCharger cable")
+	assert.Contains(t, text, "Ask Sam about the hotel now")
+	assert.Contains(t, text, "make pack\nmake go")
+
+	paragraph := content[2].(map[string]interface{})["content"].([]interface{})
+	var links []interface{}
+	for _, run := range paragraph {
+		if marks, ok := run.(map[string]interface{})["marks"].([]interface{}); ok {
+			links = append(links, marks...)
+		}
+	}
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "bold"},
+		map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": "https://example.com/hotel"}},
+	}, links)
+
+	require.Len(t, batch.People, 1)
+	assert.Equal(t, "Sam Lee", batch.People[0].Name)
+	assert.Equal(t, "Met at the conference.", batch.People[0].Notes)
+
+	assert.Equal(t, []SkippedItem{{Path: "Trip & plans/map.png", Reason: "attachments are not imported"}}, batch.Skipped)
+
+	_, err = ReadEvernoteExport(strings.NewReader("<en-export></en-export>"), "/")
+	assert.Error(t, err)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"notesage-server/internal/markdown"
+)
+
+// Import formats read from other note apps
+const (
+	ImportFormatObsidian = "obsidian"
+	ImportFormatNotion   = "notion"
+	ImportFormatEvernote = "enex"
+)
+
+var (
+	// notionIDPattern matches the page ID Notion appends to exported names
+	notionIDPattern = regexp.MustCompile(`\s+[0-9a-fA-F]{32}$`)
+
+	// notionLinkPattern matches a Markdown link to another exported page
+	notionLinkPattern = regexp.MustCompile(`\[([^\[\]]*)\]\(([^()\s]+\.md)\)`)
+
+	// notionPropertyPattern matches a "Key: Value" property line under a
+	// Notion page title
+	notionPropertyPattern = regexp.MustCompile(`^([\p{L}][\p{L}\d _()/.-]{0,40}):\s+(.+)$`)
+)
+
+// importTimeLayouts are the date formats accepted in created and updated
+// properties
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"January 2, 2006 3:04 PM",
+	"January 2, 2006",
+}
+
+// ReadImportPath reads an import from disk: a directory or zip archive for
+// Obsidian and Notion, or an .enex file for Evernote. Imported notes go under
+// baseFolder, and files larger than maxFileSize bytes (when positive) are
+// skipped.
+func ReadImportPath(format, source, baseFolder string, maxFileSize int64) (*ImportBatch, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		entries, err := dirEntries(source)
+		if err != nil {
+			return nil, err
+		}
+		return readImportFormat(format, entries, baseFolder, maxFileSize)
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadImportArchive(format, f, info.Size(), baseFolder, maxFileSize)
+}
+
+// ReadImportArchive reads an uploaded import: a zip archive for Obsidian and
+// Notion, or an .enex file for Evernote
+func ReadImportArchive(format string, r io.ReaderAt, size int64, baseFolder string, maxFileSize int64) (*ImportBatch, error) {
+	if format == ImportFormatEvernote {
+		return ReadEvernoteExport(io.NewSectionReader(r, 0, size), baseFolder)
+	}
+
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	return readImportFormat(format, zipEntries(archive), baseFolder, maxFileSize)
+}
+
+func readImportFormat(format string, entries []importEntry, baseFolder string, maxFileSize int64) (*ImportBatch, error) {
+	switch format {
+	case ImportFormatObsidian:
+		return readObsidianVault(entries, baseFolder, maxFileSize)
+	case ImportFormatNotion:
+		return readNotionExport(entries, baseFolder, maxFileSize)
+	case ImportFormatEvernote:
+		return nil, fmt.Errorf("an Evernote import is a single .enex file")
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+}
+
+// dirEntries lists the files under a directory with slash-separated names
+// relative to it
+func dirEntries(root string) ([]importEntry, error) {
+	var entries []importEntry
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if len(entries) >= maxImportEntries {
+			return ErrTooManyImportFiles
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, importEntry{
+			name: filepath.ToSlash(rel),
+			size: info.Size(),
+			open: func() (io.ReadCloser, error) { return os.Open(name) },
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readObsidianVault reads the Markdown files of an Obsidian vault. Folders
+// become folder paths, front matter tags become tags, and pages whose type or
+// tags mark them as a person become people. Wikilinks are resolved on import.
+func readObsidianVault(entries []importEntry, baseFolder string, maxFileSize int64) (*ImportBatch, error) {
+	isMarkdown := func(name string) bool {
+		ext := strings.ToLower(path.Ext(name))
+		return ext == ".md" || ext == ".markdown"
+	}
+	files, skipped, err := readImportEntries(entries, isMarkdown, maxFileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &ImportBatch{Skipped: skipped}
+	for _, file := range files {
+		doc, err := readMarkdownDocument(file.name, file.data)
+		if err != nil {
+			batch.Skipped = append(batch.Skipped, SkippedItem{Path: file.name, Reason: err.Error()})
+			continue
+		}
+		addImportedDocument(batch, file.name, JoinFolderPath(baseFolder, file.dir), doc)
+	}
+	return batch, nil
+}
+
+// readNotionExport reads a Notion Markdown export. Page IDs are removed from
+// file and folder names, the property lines under a page title are read like
+// front matter, and links between pages become wikilinks. Databases are
+// exported as CSV files next to a page per row, so the CSV files are skipped.
+func readNotionExport(entries []importEntry, baseFolder string, maxFileSize int64) (*ImportBatch, error) {
+	files, skipped, err := readImportEntries(entries, func(name string) bool {
+		return strings.EqualFold(path.Ext(name), ".md")
+	}, maxFileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, item := range skipped {
+		switch strings.ToLower(path.Ext(item.Path)) {
+		case ".csv":
+			skipped[i].Reason = "database table; its rows are imported as pages"
+		case ".zip":
+			skipped[i].Reason = "nested archive; extract it and import its contents"
+		}
+	}
+
+	batch := &ImportBatch{Skipped: skipped}
+	for _, file := range files {
+		name := notionName(file.name)
+		doc, err := readMarkdownDocument(name, []byte(notionMarkdown(string(file.data))))
+		if err != nil {
+			batch.Skipped = append(batch.Skipped, SkippedItem{Path: file.name, Reason: err.Error()})
+			continue
+		}
+		addImportedDocument(batch, name, JoinFolderPath(baseFolder, notionName(file.dir)), doc)
+	}
+	return batch, nil
+}
+
+// notionName removes the page IDs from each part of an exported path
+func notionName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		ext := path.Ext(part)
+		parts[i] = notionIDPattern.ReplaceAllString(strings.TrimSuffix(part, ext), "") + ext
+	}
+	return strings.Join(parts, "/")
+}
+
+// notionMarkdown turns a Notion page into Markdown with front matter: the
+// property lines under the title move into front matter and links to other
+// pages become wikilinks
+func notionMarkdown(src string) string {
+	src = notionLinkPattern.ReplaceAllStringFunc(src, func(match string) string {
+		parts := notionLinkPattern.FindStringSubmatch(match)
+		if strings.Contains(parts[2], "://") {
+			return match
+		}
+		target := parts[2]
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		target = fileStem(notionName(target))
+		if strings.TrimSpace(parts[1]) == "" {
+			return "[[" + target + "]]"
+		}
+		return "[[" + target + "|" + parts[1] + "]]"
+	})
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	start := 0
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	if start >= len(lines) || !strings.HasPrefix(lines[start], "# ") {
+		return src
+	}
+	props := start + 1
+	for props < len(lines) && strings.TrimSpace(lines[props]) == "" {
+		props++
+	}
+	end := props
+	for end < len(lines) && strings.TrimSpace(lines[end]) != "" {
+		if !notionPropertyPattern.MatchString(lines[end]) {
+			return src
+		}
+		end++
+	}
+	if end == props {
+		return src
+	}
+
+	var fm strings.Builder
+	fm.WriteString("---\n")
+	for _, line := range lines[props:end] {
+		parts := notionPropertyPattern.FindStringSubmatch(line)
+		fmt.Fprintf(&fm, "%q: %q\n", strings.ToLower(strings.TrimSpace(parts[1])), strings.TrimSpace(parts[2]))
+	}
+	fm.WriteString("---\n")
+
+	body := append([]string{lines[start]}, lines[end:]...)
+	return fm.String() + strings.Join(body, "\n")
+}
+
+// addImportedDocument adds a read document to a batch as a person or a note
+func addImportedDocument(batch *ImportBatch, name, folderPath string, doc *markdown.Document) {
+	if isPersonDocument(doc.Properties["type"], doc.Tags) {
+		batch.People = append(batch.People, personFromDocument(name, doc))
+		return
+	}
+
+	note := ImportedNote{
+		Path:       name,
+		Title:      doc.Title,
+		Category:   doc.Category,
+		Tags:       doc.Tags,
+		FolderPath: folderPath,
+		Content:    doc.Content,
+		CreatedAt:  parseImportTime(firstProperty(doc.Properties, "created", "created time", "date")),
+		UpdatedAt:  parseImportTime(firstProperty(doc.Properties, "updated", "modified", "last edited time")),
+	}
+	if note.Category == "" {
+		note.Category = strings.TrimSpace(doc.Properties["category"])
+	}
+	batch.Notes = append(batch.Notes, note)
+}
+
+// isPersonDocument reports whether a page's type property or tags mark it as
+// a person
+func isPersonDocument(pageType string, tags []string) bool {
+	for _, value := range append([]string{pageType}, tags...) {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "person", "people", "contact":
+			return true
+		}
+	}
+	return false
+}
+
+// personFromDocument reads a person's fields from a page's properties; the
+// page body becomes their notes
+func personFromDocument(name string, doc *markdown.Document) ImportedPerson {
+	return ImportedPerson{
+		Path:        name,
+		Name:        doc.Title,
+		Email:       firstProperty(doc.Properties, "email", "e-mail"),
+		Phone:       firstProperty(doc.Properties, "phone", "mobile"),
+		Company:     firstProperty(doc.Properties, "company", "organization", "organisation"),
+		Title:       firstProperty(doc.Properties, "title", "role", "job title", "position"),
+		LinkedinURL: firstProperty(doc.Properties, "linkedin", "linkedin url"),
+		Notes:       strings.TrimSpace(markdown.Render(doc.Content)),
+	}
+}
+
+// firstProperty returns the first of keys set in properties
+func firstProperty(properties map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(properties[key]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseImportTime reads a date in one of importTimeLayouts, returning the
+// zero time when it is empty or not understood
+func parseImportTime(value string) time.Time {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipArchive(t *testing.T, files map[string]string) *bytes.Reader {
+	t.Helper()
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bytes.NewReader(archive.Bytes())
+}
+
+func TestImportObsidianVault(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+	user := createTestUser(t, db)
+
+	vault := t.TempDir()
+	files := map[string]string{
+		"Projects/Launch.md":       "---\ntags: [work, launch]\ncreated: 2024-03-01\n---\nPlan with [[Sam Lee|Sam]] and see [[Budget#Q3]]. Missing [[Nowhere]].\n\n![[diagram.png]]",
+		"Projects/Budget.md":       "Numbers",
+		"People/Sam Lee.md":        "---\ntype: person\nemail: sam@example.com\ncompany: Acme\nrole: CTO\n---\nMet at the offsite.",
+		"People/diagram.png":       "png",
+		".obsidian/workspace.json": "{}",
+		".obsidian/app.json":       "{}",
+	}
+	for name, body := range files {
+		file := filepath.Join(vault, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		require.NoError(t, os.WriteFile(file, []byte(body), 0o644))
+	}
+
+	batch, err := ReadImportPath(ImportFormatObsidian, vault, "/Vault", 0)
+	require.NoError(t, err)
+	require.Len(t, batch.Notes, 2)
+	require.Len(t, batch.People, 1)
+	assert.ElementsMatch(t, []SkippedItem{
+		{Path: ".obsidian", Reason: "hidden file"},
+		{Path: "People/diagram.png", Reason: ErrUnsupportedImport.Error()},
+	}, batch.Skipped)
+
+	result, err := NewImportService(db).Import(user.ID, batch)
+	require.NoError(t, err)
+
+	require.Len(t, result.People, 1)
+	sam := result.People[0]
+	assert.Equal(t, "Sam Lee", sam.Name)
+	assert.Equal(t, "sam@example.com", sam.Email)
+	assert.Equal(t, "Acme", sam.Company)
+	assert.Equal(t, "CTO", sam.Title)
+	assert.Equal(t, "Met at the offsite.", sam.Notes)
+
+	notes := map[string]models.Note{}
+	for _, note := range result.Notes {
+		notes[note.Title] = note
+	}
+	launch := notes["Launch"]
+	assert.Equal(t, "/Vault/Projects", launch.FolderPath)
+	assert.Equal(t, []string{"work", "launch"}, []string(launch.Tags))
+	assert.Equal(t, 2024, launch.CreatedAt.Year())
+	assert.Contains(t, noteText(launch), "Plan with @Sam Lee and see #Budget. Missing Nowhere.")
+
+	var connections []models.Connection
+	require.NoError(t, db.Where("source_id = ?", launch.ID).Find(&connections).Error)
+	targets := map[string]bool{}
+	for _, connection := range connections {
+		targets[connection.TargetID.String()] = true
+	}
+	assert.Equal(t, map[string]bool{sam.ID.String(): true, notes["Budget"].ID.String(): true}, targets)
+	assert.Equal(t, 2, result.Connections)
+}
+
+func TestReadNotionExport(t *testing.T) {
+	archive := zipArchive(t, map[string]string{
+		"Export/Roadmap 0123456789abcdef0123456789abcdef.md":                                         "# Roadmap\n\nTags: planning, q3\nCreated: March 4, 2024 9:30 AM\n\nSee [Sam Lee](People%20fedcba9876543210fedcba9876543210/Sam%20Lee%20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.md).\n\nNote: keep it short",
+		"Export/People fedcba9876543210fedcba9876543210/Sam Lee aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.md": "# Sam Lee\n\nType: Person\nEmail: sam@example.com\n\nLikes tea.",
+		"Export/People fedcba9876543210fedcba9876543210.csv":                                         "Name,Type\nSam Lee,Person",
+		"Export/Roadmap 0123456789abcdef0123456789abcdef/chart.png":                                  "png",
+	})
+
+	batch, err := ReadImportArchive(ImportFormatNotion, archive, archive.Size(), "/", 0)
+	require.NoError(t, err)
+
+	require.Len(t, batch.Notes, 1)
+	roadmap := batch.Notes[0]
+	assert.Equal(t, "Roadmap", roadmap.Title)
+	assert.Equal(t, "/Export", roadmap.FolderPath)
+	assert.Equal(t, []string{"planning", "q3"}, roadmap.Tags)
+	assert.Equal(t, 2024, roadmap.CreatedAt.Year())
+	assert.Contains(t, noteText(models.Note{Content: roadmap.Content}), "See [[Sam Lee|Sam Lee]].")
+	assert.Contains(t, noteText(models.Note{Content: roadmap.Content}), "Note: keep it short")
+
+	require.Len(t, batch.People, 1)
+	assert.Equal(t, "Sam Lee", batch.People[0].Name)
+	assert.Equal(t, "sam@example.com", batch.People[0].Email)
+	assert.Equal(t, "Export/People/Sam Lee.md", batch.People[0].Path)
+
+	reasons := map[string]string{}
+	for _, item := range batch.Skipped {
+		reasons[filepath.Ext(item.Path)] = item.Reason
+	}
+	assert.Equal(t, "database table; its rows are imported as pages", reasons[".csv"])
+	assert.Equal(t, ErrUnsupportedImport.Error(), reasons[".png"])
+
+	_, err = ReadImportArchive("onenote", archive, archive.Size(), "/", 0)
+	assert.Error(t, err)
+}
+
+// noteText joins the text of a note's top-level blocks, a line each
+func noteText(note models.Note) string {
+	var out bytes.Buffer
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		if text, ok := node["text"].(string); ok {
+			out.WriteString(text)
+		}
+		children, _ := node["content"].([]interface{})
+		for _, child := range children {
+			if childNode, ok := child.(map[string]interface{}); ok {
+				walk(childNode)
+			}
+		}
+	}
+	children, _ := note.Content["content"].([]interface{})
+	for _, child := range children {
+		walk(child.(map[string]interface{}))
+		out.WriteString("\n")
+	}
+	return out.String()
+}
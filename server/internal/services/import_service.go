@@ -0,0 +1,544 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"notesage-server/internal/markdown"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// maxImportEntries caps the number of files read from one archive
+const maxImportEntries = 10000
+
+var (
+	// ErrUnsupportedImport means an import file is not a format the server reads
+	ErrUnsupportedImport = errors.New("unsupported file type")
+
+	// ErrTooManyImportFiles means an archive holds more than maxImportEntries files
+	ErrTooManyImportFiles = fmt.Errorf("archive contains more than %d files", maxImportEntries)
+)
+
+// wikilinkPattern matches [[Target]], [[Target#Heading]] and [[Target|Label]],
+// optionally preceded by ! for an embed
+var wikilinkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]|#^]+)(?:[#^][^\[\]|]*)?(?:\|([^\[\]]+))?\]\]`)
+
+// ImportedNote is a note read from an import file, ready to be created
+type ImportedNote struct {
+	Path       string
+	Title      string
+	Category   string
+	Tags       []string
+	FolderPath string
+	Content    models.JSONB
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ImportedPerson is a person read from an import file, ready to be created
+type ImportedPerson struct {
+	Path        string
+	Name        string
+	Email       string
+	Phone       string
+	Company     string
+	Title       string
+	LinkedinURL string
+	Notes       string
+}
+
+// SkippedItem is an entry of an import that did not become a note
+type SkippedItem struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ImportBatch is everything read from one import, before it is saved
+type ImportBatch struct {
+	Notes   []ImportedNote
+	People  []ImportedPerson
+	Skipped []SkippedItem
+}
+
+// ImportResult lists what an import created and the entries it skipped
+type ImportResult struct {
+	Notes       []models.Note   `json:"notes"`
+	People      []models.Person `json:"people"`
+	Connections int             `json:"connections"`
+	Skipped     []SkippedItem   `json:"skipped"`
+}
+
+// ImportService turns Markdown files, archives and exports from other note
+// apps into notes, people and connections
+type ImportService struct {
+	db          *gorm.DB
+	revisions   *RevisionService
+	connections *ConnectionService
+	workspaceID *uuid.UUID
+}
+
+// NewImportService creates a new import service
+func NewImportService(db *gorm.DB) *ImportService {
+	return &ImportService{
+		db:          db,
+		revisions:   NewRevisionService(db),
+		connections: NewConnectionService(db),
+	}
+}
+
+// InWorkspace returns a copy of the service that creates notes in a workspace,
+// or in the personal space when workspaceID is nil
+func (s *ImportService) InWorkspace(workspaceID *uuid.UUID) *ImportService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// IsMarkdownFile reports whether a file name has an extension read as Markdown
+func IsMarkdownFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		return true
+	}
+	return false
+}
+
+// ReadMarkdownFile reads one Markdown file into a note in folderPath. The
+// title falls back to the file name without its extension.
+func ReadMarkdownFile(name string, data []byte, folderPath string) (*ImportedNote, error) {
+	doc, err := readMarkdownDocument(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportedNote{
+		Path:       name,
+		Title:      doc.Title,
+		Category:   doc.Category,
+		Tags:       doc.Tags,
+		FolderPath: folderPath,
+		Content:    doc.Content,
+	}, nil
+}
+
+// ReadMarkdownZip reads the Markdown files in a zip archive. Each file's
+// directory inside the archive is appended to baseFolder to give its folder
+// path. Other files, hidden files and files larger than maxFileSize bytes
+// (when positive) are skipped and reported.
+func ReadMarkdownZip(r io.ReaderAt, size int64, baseFolder string, maxFileSize int64) ([]ImportedNote, []SkippedItem, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	files, skipped, err := readImportEntries(zipEntries(archive), IsMarkdownFile, maxFileSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var notes []ImportedNote
+	for _, file := range files {
+		note, err := ReadMarkdownFile(file.name, file.data, JoinFolderPath(baseFolder, file.dir))
+		if err != nil {
+			skipped = append(skipped, SkippedItem{Path: file.name, Reason: err.Error()})
+			continue
+		}
+		notes = append(notes, *note)
+	}
+	return notes, skipped, nil
+}
+
+// Import saves a batch for a user in one transaction, so an import either
+// lands completely or not at all. Wikilinks to imported or existing notes
+// and people become #Title and @Name references, and the connections for
+// them and for other references in the imported notes are created after.
+func (s *ImportService) Import(userID uuid.UUID, batch *ImportBatch) (*ImportResult, error) {
+	result := &ImportResult{
+		Notes:   make([]models.Note, 0, len(batch.Notes)),
+		People:  make([]models.Person, 0, len(batch.People)),
+		Skipped: append([]SkippedItem{}, batch.Skipped...),
+	}
+
+	for _, item := range batch.People {
+		person := models.Person{
+			ID:          uuid.New(),
+			UserID:      userID,
+			WorkspaceID: s.workspaceID,
+			Name:        truncateRunes(item.Name, 255),
+			Email:       truncateRunes(item.Email, 255),
+			Phone:       truncateRunes(item.Phone, 50),
+			Company:     truncateRunes(item.Company, 255),
+			Title:       truncateRunes(item.Title, 255),
+			LinkedinURL: truncateRunes(item.LinkedinURL, 500),
+			Notes:       item.Notes,
+		}
+		if err := person.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Path, err)
+		}
+		result.People = append(result.People, person)
+	}
+
+	for _, item := range batch.Notes {
+		note := models.Note{
+			ID:          uuid.New(),
+			UserID:      userID,
+			WorkspaceID: s.workspaceID,
+			Title:       truncateRunes(item.Title, 500),
+			Content:     item.Content,
+			Category:    item.Category,
+			Tags:        pq.StringArray(item.Tags),
+			FolderPath:  item.FolderPath,
+			CreatedAt:   item.CreatedAt,
+			UpdatedAt:   item.UpdatedAt,
+		}
+		if note.Category == "" {
+			note.Category = "Note"
+		}
+		if note.FolderPath == "" {
+			note.FolderPath = "/"
+		}
+		if err := note.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Path, err)
+		}
+		result.Notes = append(result.Notes, note)
+	}
+
+	links, err := s.linkTargets(userID, batch, result)
+	if err != nil {
+		return nil, err
+	}
+	explicit := make([][]DetectedConnection, len(result.Notes))
+	for i := range result.Notes {
+		explicit[i] = resolveWikilinks(&result.Notes[i], links)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range result.People {
+			if err := tx.Create(&result.People[i]).Error; err != nil {
+				return err
+			}
+			if err := RecordSyncChange(tx, userID, models.SyncEntityPerson, result.People[i].ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+		}
+		for i := range result.Notes {
+			if err := tx.Create(&result.Notes[i]).Error; err != nil {
+				return err
+			}
+			if err := s.revisions.RecordRevision(tx, &result.Notes[i], userID, "Imported"); err != nil {
+				return err
+			}
+			if err := RecordNoteSyncChange(tx, &result.Notes[i], models.SyncEntityNote, result.Notes[i].ID, models.SyncActionCreate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save import: %w", err)
+	}
+
+	// References between imported notes can only be found once all exist
+	connections := s.connections.InWorkspace(s.workspaceID)
+	for i, note := range result.Notes {
+		detected, err := connections.DetectConnections(userID, note.ID, note.Content)
+		if err != nil {
+			log.Printf("Failed to detect connections for imported note %s: %v", note.ID, err)
+			continue
+		}
+		detected = mergeConnections(detected, explicit[i])
+		if err := connections.UpdateConnections(userID, note.ID, detected); err != nil {
+			log.Printf("Failed to save connections for imported note %s: %v", note.ID, err)
+			continue
+		}
+		result.Connections += len(detected)
+	}
+
+	return result, nil
+}
+
+// linkTarget is something a wikilink can point at
+type linkTarget struct {
+	id         uuid.UUID
+	targetType string
+	name       string
+}
+
+// linkTargets indexes the notes and people a wikilink may name: existing ones
+// by title or name, imported ones also by their file name
+func (s *ImportService) linkTargets(userID uuid.UUID, batch *ImportBatch, result *ImportResult) (map[string]linkTarget, error) {
+	targets := make(map[string]linkTarget)
+	add := func(key string, target linkTarget) {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != "" {
+			targets[key] = target
+		}
+	}
+
+	var notes []models.Note
+	if err := s.db.Select("id, title").Scopes(OwnedBy("notes", userID, s.workspaceID)).Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch notes: %w", err)
+	}
+	for _, note := range notes {
+		add(note.Title, linkTarget{note.ID, "note", note.Title})
+	}
+	var people []models.Person
+	if err := s.db.Select("id, name").Scopes(OwnedBy("people", userID, s.workspaceID)).Find(&people).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch people: %w", err)
+	}
+	for _, person := range people {
+		add(person.Name, linkTarget{person.ID, "person", person.Name})
+	}
+
+	for i, note := range result.Notes {
+		target := linkTarget{note.ID, "note", note.Title}
+		add(note.Title, target)
+		add(fileStem(batch.Notes[i].Path), target)
+	}
+	for i, person := range result.People {
+		target := linkTarget{person.ID, "person", person.Name}
+		add(person.Name, target)
+		add(fileStem(batch.People[i].Path), target)
+	}
+	return targets, nil
+}
+
+// resolveWikilinks rewrites the wikilinks in a note's text to #Title and
+// @Name references and returns connections for the ones that resolved.
+// Unresolved links keep their label as plain text.
+func resolveWikilinks(note *models.Note, targets map[string]linkTarget) []DetectedConnection {
+	var connections []DetectedConnection
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		if text, ok := node["text"].(string); ok {
+			node["text"] = wikilinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+				parts := wikilinkPattern.FindStringSubmatch(match)
+				name := strings.TrimSpace(parts[2])
+				label := strings.TrimSpace(parts[3])
+				if label == "" {
+					label = path.Base(name)
+				}
+
+				target, ok := targets[strings.ToLower(name)]
+				if !ok {
+					target, ok = targets[strings.ToLower(path.Base(name))]
+				}
+				if !ok || parts[1] != "" || target.id == note.ID {
+					return label
+				}
+
+				connection := DetectedConnection{
+					SourceID:   note.ID,
+					SourceType: "note",
+					TargetID:   target.id,
+					TargetType: target.targetType,
+					Type:       ConnectionTypeReference,
+					Context:    match,
+				}
+				if target.targetType == "person" {
+					connection.Type = ConnectionTypeMention
+					connections = append(connections, connection)
+					return "@" + target.name
+				}
+				connections = append(connections, connection)
+				return "#" + target.name
+			})
+		}
+		content, _ := node["content"].([]interface{})
+		for _, child := range content {
+			if childNode, ok := child.(map[string]interface{}); ok {
+				walk(childNode)
+			}
+		}
+	}
+	if note.Content != nil {
+		walk(note.Content)
+	}
+	return connections
+}
+
+// mergeConnections adds explicit connections to detected ones, keeping one
+// connection per target
+func mergeConnections(detected, explicit []DetectedConnection) []DetectedConnection {
+	seen := make(map[uuid.UUID]bool, len(detected))
+	var merged []DetectedConnection
+	for _, connection := range append(detected, explicit...) {
+		if seen[connection.TargetID] {
+			continue
+		}
+		seen[connection.TargetID] = true
+		merged = append(merged, connection)
+	}
+	return merged
+}
+
+// JoinFolderPath appends a relative directory to a folder path, giving a
+// clean absolute folder path such as "/Projects/2024"
+func JoinFolderPath(base, dir string) string {
+	return path.Clean("/" + strings.Trim(base, "/") + "/" + strings.Trim(dir, "/"))
+}
+
+// readMarkdownDocument parses a Markdown file, checking its type and encoding
+func readMarkdownDocument(name string, data []byte) (*markdown.Document, error) {
+	if !IsMarkdownFile(name) {
+		return nil, ErrUnsupportedImport
+	}
+	if !utf8.Valid(data) {
+		return nil, errors.New("file is not valid UTF-8 text")
+	}
+	return markdown.Import(string(data), fileStem(name))
+}
+
+// importEntry is a file in an import source: an archive or a directory
+type importEntry struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// importFile is an import entry that was read
+type importFile struct {
+	name string
+	dir  string
+	data []byte
+}
+
+func zipEntries(archive *zip.Reader) []importEntry {
+	var entries []importEntry
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		entries = append(entries, importEntry{
+			name: strings.ReplaceAll(file.Name, `\`, "/"),
+			size: int64(file.UncompressedSize64),
+			open: file.Open,
+		})
+	}
+	return entries
+}
+
+// readImportEntries reads the entries accept allows. Hidden entries, other
+// types and files larger than maxFileSize bytes (when positive) are skipped
+// and reported, with each hidden folder reported once.
+func readImportEntries(entries []importEntry, accept func(name string) bool, maxFileSize int64) ([]importFile, []SkippedItem, error) {
+	if len(entries) > maxImportEntries {
+		return nil, nil, ErrTooManyImportFiles
+	}
+
+	var files []importFile
+	var skipped []SkippedItem
+	hidden := make(map[string]bool)
+	for _, entry := range entries {
+		dir, reason := archiveFolder(entry.name)
+		switch {
+		case reason == "hidden file":
+			prefix := hiddenPrefix(entry.name)
+			if !hidden[prefix] {
+				hidden[prefix] = true
+				skipped = append(skipped, SkippedItem{Path: prefix, Reason: reason})
+			}
+			continue
+		case reason != "":
+			skipped = append(skipped, SkippedItem{Path: entry.name, Reason: reason})
+			continue
+		case !accept(entry.name):
+			skipped = append(skipped, SkippedItem{Path: entry.name, Reason: ErrUnsupportedImport.Error()})
+			continue
+		case maxFileSize > 0 && entry.size > maxFileSize:
+			skipped = append(skipped, SkippedItem{Path: entry.name, Reason: ErrUploadTooLarge.Error()})
+			continue
+		}
+
+		data, err := readEntry(entry, maxFileSize)
+		if err != nil {
+			skipped = append(skipped, SkippedItem{Path: entry.name, Reason: err.Error()})
+			continue
+		}
+		files = append(files, importFile{name: entry.name, dir: dir, data: data})
+	}
+	return files, skipped, nil
+}
+
+func readEntry(entry importEntry, maxFileSize int64) ([]byte, error) {
+	rc, err := entry.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readLimited(rc, maxFileSize)
+}
+
+// hiddenPrefix returns a path up to and including its first hidden part
+func hiddenPrefix(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return name
+}
+
+// archiveFolder returns the directory of an archive entry, or a reason to
+// skip it when the entry is hidden or its path escapes the archive
+func archiveFolder(name string) (string, string) {
+	if path.IsAbs(name) {
+		return "", "unsafe path"
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", "unsafe path"
+		}
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", "hidden file"
+		}
+	}
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	return dir, ""
+}
+
+// readLimited reads all of r, failing with ErrUploadTooLarge past maxSize
+// bytes when maxSize is positive. Sizes in archive headers can lie, so the
+// limit is enforced while reading.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	return data, nil
+}
+
+// fileStem returns a path's file name without its extension
+func fileStem(name string) string {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMarkdownZip(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, body := range map[string]string{
+		"notes/small.md":  "small",
+		"notes/large.md":  strings.Repeat("x", 64),
+		"notes/image.jpg": "jpg",
+	} {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	notes, skipped, err := ReadMarkdownZip(bytes.NewReader(archive.Bytes()), int64(archive.Len()), "/Imports", 32)
+	require.NoError(t, err)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "small", notes[0].Title)
+	assert.Equal(t, "/Imports/notes", notes[0].FolderPath)
+
+	reasons := map[string]string{}
+	for _, item := range skipped {
+		reasons[item.Path] = item.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"notes/large.md":  ErrUploadTooLarge.Error(),
+		"notes/image.jpg": ErrUnsupportedImport.Error(),
+	}, reasons)
+
+	_, _, err = ReadMarkdownZip(strings.NewReader("nope"), 4, "/", 0)
+	assert.Error(t, err)
+}
+
+func TestImportService_Import(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	workspace, err := NewWorkspaceService(db).CreateWorkspace(user.ID, "Team", "")
+	require.NoError(t, err)
+
+	imported, err := ReadMarkdownFile("Plan.md", []byte("- [ ][t1] Draft agenda @sam 2024-04-02"), "/")
+	require.NoError(t, err)
+
+	result, err := NewImportService(db).InWorkspace(&workspace.ID).Import(user.ID, &ImportBatch{Notes: []ImportedNote{*imported}})
+	require.NoError(t, err)
+	notes := result.Notes
+	require.Len(t, notes, 1)
+	assert.Equal(t, "Plan", notes[0].Title)
+	assert.Equal(t, "Note", notes[0].Category)
+	assert.Equal(t, &workspace.ID, notes[0].WorkspaceID)
+
+	var revisions int64
+	db.Model(&models.NoteRevision{}).Where("note_id = ?", notes[0].ID).Count(&revisions)
+	assert.Equal(t, int64(1), revisions)
+
+	var changes int64
+	db.Model(&models.SyncChange{}).Where("entity_id = ?", notes[0].ID).Count(&changes)
+	assert.Equal(t, int64(1), changes)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// noteAccessDebounce is how long after an access further accesses of
+	// the same note by the same user are not recorded, so that opening a note
+	// in the editor, which both fetches it and joins its room, counts once
+	noteAccessDebounce = time.Minute
+
+	// quickSwitcherAccessWindow is how far back accesses count towards the
+	// quick switcher's ranking
+	quickSwitcherAccessWindow = 30 * 24 * time.Hour
+)
+
+// NoteAccessService records which notes users open and reports the notes
+// they viewed recently or use most
+type NoteAccessService struct {
+	db          *gorm.DB
+	workspaceID *uuid.UUID
+}
+
+// NewNoteAccessService creates a note access service
+func NewNoteAccessService(db *gorm.DB) *NoteAccessService {
+	return &NoteAccessService{db: db}
+}
+
+// InWorkspace returns a copy of the service reporting on the notes of a
+// workspace, or on the user's personal and shared notes when workspaceID is
+// nil
+func (s *NoteAccessService) InWorkspace(workspaceID *uuid.UUID) *NoteAccessService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// Record logs that the user accessed a note, unless they already did within
+// noteAccessDebounce
+func (s *NoteAccessService) Record(userID, noteID uuid.UUID, source string) error {
+	var recent int64
+	if err := s.db.Model(&models.NoteAccess{}).
+		Where("user_id = ? AND note_id = ? AND accessed_at >= ?", userID, noteID, time.Now().Add(-noteAccessDebounce)).
+		Count(&recent).Error; err != nil {
+		return fmt.Errorf("failed to check note access: %w", err)
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	access := models.NoteAccess{UserID: userID, NoteID: noteID, Source: source}
+	if err := s.db.Create(&access).Error; err != nil {
+		return fmt.Errorf("failed to record note access: %w", err)
+	}
+	return nil
+}
+
+// RecentlyViewed returns the notes the user opened most recently
+func (s *NoteAccessService) RecentlyViewed(userID uuid.UUID, limit int) ([]RecentNote, error) {
+	return s.accessedNotes(userID, time.Time{}, "access.last_accessed DESC", limit)
+}
+
+// FrequentlyUsed returns the notes the user opened most often since a point
+// in time
+func (s *NoteAccessService) FrequentlyUsed(userID uuid.UUID, since time.Time, limit int) ([]RecentNote, error) {
+	return s.accessedNotes(userID, since, "access.access_count DESC, access.last_accessed DESC", limit)
+}
+
+// accessedNote is a note with the number of times the user accessed it
+type accessedNote struct {
+	models.Note
+	AccessCount int `gorm:"column:access_count"`
+}
+
+// accessCounts joins a notes query to the number of times the user accessed
+// each note since a point in time, as access.access_count, and the time of
+// the last access, as access.last_accessed. Notes never accessed are left out
+// unless outer is set.
+func accessCounts(query *gorm.DB, userID uuid.UUID, since time.Time, outer bool) *gorm.DB {
+	join := "JOIN"
+	if outer {
+		join = "LEFT JOIN"
+	}
+	return query.Joins(join+` (
+		SELECT note_id, COUNT(*) AS access_count, MAX(accessed_at) AS last_accessed
+		FROM note_access WHERE user_id = ? AND accessed_at >= ?
+		GROUP BY note_id
+	) AS access ON access.note_id = notes.id`, userID, since)
+}
+
+// accessedNotes lists the unarchived notes the user accessed since a point in
+// time, in order
+func (s *NoteAccessService) accessedNotes(userID uuid.UUID, since time.Time, order string, limit int) ([]RecentNote, error) {
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	var notes []accessedNote
+	if err := accessCounts(s.db.Model(&models.Note{}), userID, since, false).
+		Scopes(AccessibleNotes(userID, s.workspaceID)).
+		Where("notes.is_archived = ?", false).
+		Select("notes.id, notes.title, notes.category, notes.updated_at, access.access_count").
+		Order(order).
+		Limit(limit).
+		Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get accessed notes: %w", err)
+	}
+	if len(notes) == 0 {
+		return []RecentNote{}, nil
+	}
+
+	// The time of the last access is read from its row, since databases
+	// return MAX of a timestamp in different types
+	ids := make([]uuid.UUID, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+	}
+	var latest []models.NoteAccess
+	if err := s.db.Where("user_id = ? AND note_id IN ?", userID, ids).
+		Where(`accessed_at = (SELECT MAX(a.accessed_at) FROM note_access AS a
+			WHERE a.user_id = note_access.user_id AND a.note_id = note_access.note_id)`).
+		Find(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to get note accesses: %w", err)
+	}
+	accessedAt := make(map[uuid.UUID]time.Time, len(latest))
+	for _, access := range latest {
+		accessedAt[access.NoteID] = access.AccessedAt
+	}
+
+	results := make([]RecentNote, len(notes))
+	for i, note := range notes {
+		results[i] = RecentNote{
+			ID:          note.ID,
+			Title:       note.Title,
+			Category:    note.Category,
+			UpdatedAt:   note.UpdatedAt,
+			AccessedAt:  accessedAt[note.ID],
+			AccessCount: note.AccessCount,
+		}
+	}
+	return results, nil
+}
+
+// accessBoost is the quick switcher score added for a note opened count
+// times: half a point for one open, rising slowly to at most 2, so that
+// frequent notes win ties without outranking better title matches
+func accessBoost(count int) float64 {
+	if count <= 0 {
+		return 0
+	}
+	return math.Min(2, 0.5*math.Log2(1+float64(count)))
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoteAccessService(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewNoteAccessService(db)
+
+	var notes []models.Note
+	require.NoError(t, db.Where("user_id = ? AND is_archived = ?", user.ID, false).Order("title").Find(&notes).Error)
+	require.Len(t, notes, 4)
+	golang, javascript, meeting := notes[0], notes[1], notes[2]
+
+	// Accesses within a minute of each other count once
+	require.NoError(t, service.Record(user.ID, golang.ID, models.NoteAccessOpen))
+	require.NoError(t, service.Record(user.ID, golang.ID, models.NoteAccessCollaborate))
+	var count int64
+	require.NoError(t, db.Model(&models.NoteAccess{}).Where("note_id = ?", golang.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	old := time.Now().AddDate(0, 0, -60)
+	for _, access := range []models.NoteAccess{
+		{NoteID: javascript.ID, AccessedAt: time.Now().Add(-time.Hour)},
+		{NoteID: javascript.ID, AccessedAt: time.Now().Add(-2 * time.Hour)},
+		{NoteID: meeting.ID, AccessedAt: old},
+		{NoteID: meeting.ID, AccessedAt: old.Add(time.Hour)},
+		{NoteID: meeting.ID, AccessedAt: old.Add(2 * time.Hour)},
+	} {
+		access.UserID = user.ID
+		access.Source = models.NoteAccessOpen
+		require.NoError(t, db.Create(&access).Error)
+	}
+
+	recent, err := service.RecentlyViewed(user.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, recent, 3)
+	assert.Equal(t, []uuid.UUID{golang.ID, javascript.ID, meeting.ID}, []uuid.UUID{recent[0].ID, recent[1].ID, recent[2].ID})
+	assert.Equal(t, 2, recent[1].AccessCount)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), recent[1].AccessedAt, time.Second)
+
+	// Only accesses since the start of the window count
+	frequent, err := service.FrequentlyUsed(user.ID, time.Now().AddDate(0, 0, -30), 10)
+	require.NoError(t, err)
+	require.Len(t, frequent, 2)
+	assert.Equal(t, javascript.ID, frequent[0].ID)
+	frequent, err = service.FrequentlyUsed(user.ID, time.Now().AddDate(0, 0, -90), 10)
+	require.NoError(t, err)
+	assert.Equal(t, meeting.ID, frequent[0].ID)
+	assert.Equal(t, 3, frequent[0].AccessCount)
+
+	// Another user's accesses and other spaces are not reported
+	other := createTestUser(t, db)
+	recent, err = service.RecentlyViewed(other.ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, recent)
+	workspaceID := uuid.New()
+	recent, err = service.InWorkspace(&workspaceID).RecentlyViewed(user.ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, recent)
+}
+
+func TestAccessBoost(t *testing.T) {
+	assert.Zero(t, accessBoost(0))
+	assert.Equal(t, 0.5, accessBoost(1))
+	assert.Equal(t, 1.0, accessBoost(3))
+	assert.Equal(t, 2.0, accessBoost(1000))
+}
@@ -0,0 +1,564 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf16"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Operation types understood by the transform engine
+const (
+	OperationInsert  = "insert"
+	OperationDelete  = "delete"
+	OperationFormat  = "format"
+	OperationReplace = "replace"
+)
+
+// maxOperationHistory is how many applied versions are kept per note for transforming
+// late operations. Clients further behind than this get a conflict instead.
+const maxOperationHistory = 200
+
+var (
+	// ErrHistoryUnavailable means an operation's base version is too old to transform
+	ErrHistoryUnavailable = errors.New("operation history unavailable for base version")
+	// ErrInvalidOperation means an operation cannot be applied to the document
+	ErrInvalidOperation = errors.New("invalid operation")
+)
+
+// leafNodeTypes are ProseMirror nodes with no content that occupy a single position
+var leafNodeTypes = map[string]bool{
+	"hardBreak":      true,
+	"horizontalRule": true,
+	"image":          true,
+	"mention":        true,
+	"mermaid":        true,
+}
+
+// appliedOperations records the operations that produced a note version
+type appliedOperations struct {
+	version    int
+	operations []models.TextOperation
+}
+
+// noteLock serialises edits to a note. refs counts the callers holding or
+// waiting for it, so it can be dropped once nobody is editing the note.
+type noteLock struct {
+	sync.Mutex
+	refs int
+}
+
+// OperationLog keeps a bounded per-note history of applied operations so that an
+// operation based on an older version can be transformed to the current one
+type OperationLog struct {
+	mutex   sync.Mutex
+	history map[uuid.UUID][]appliedOperations
+	locks   map[uuid.UUID]*noteLock
+}
+
+// NewOperationLog creates an empty operation log
+func NewOperationLog() *OperationLog {
+	return &OperationLog{
+		history: make(map[uuid.UUID][]appliedOperations),
+		locks:   make(map[uuid.UUID]*noteLock),
+	}
+}
+
+// Lock serialises edits to a single note and returns the matching unlock
+// function. The lock is removed when the last caller holding or waiting for
+// it unlocks, so notes nobody is editing keep no lock.
+func (l *OperationLog) Lock(noteID uuid.UUID) func() {
+	l.mutex.Lock()
+	lock, exists := l.locks[noteID]
+	if !exists {
+		lock = &noteLock{}
+		l.locks[noteID] = lock
+	}
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		l.mutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, noteID)
+		}
+		l.mutex.Unlock()
+		lock.Unlock()
+	}
+}
+
+// Transform rewrites ops, written against baseVersion, so they apply on top of
+// currentVersion. Every version in between must have been recorded.
+func (l *OperationLog) Transform(noteID uuid.UUID, baseVersion, currentVersion int, ops []models.TextOperation) ([]models.TextOperation, error) {
+	if baseVersion == currentVersion {
+		return ops, nil
+	}
+	if baseVersion > currentVersion {
+		return nil, ErrHistoryUnavailable
+	}
+
+	l.mutex.Lock()
+	history := l.history[noteID]
+	l.mutex.Unlock()
+
+	next := baseVersion + 1
+	for _, entry := range history {
+		if entry.version < next {
+			continue
+		}
+		if entry.version != next {
+			return nil, ErrHistoryUnavailable
+		}
+		ops, _ = TransformOperations(ops, entry.operations)
+		next++
+	}
+
+	if next != currentVersion+1 {
+		return nil, ErrHistoryUnavailable
+	}
+	return ops, nil
+}
+
+// Record stores the operations that produced version
+func (l *OperationLog) Record(noteID uuid.UUID, version int, ops []models.TextOperation) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	history := append(l.history[noteID], appliedOperations{version: version, operations: ops})
+	if len(history) > maxOperationHistory {
+		history = history[len(history)-maxOperationHistory:]
+	}
+	l.history[noteID] = history
+}
+
+// Forget drops the history of a note, e.g. once nobody is editing it
+func (l *OperationLog) Forget(noteID uuid.UUID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.history, noteID)
+}
+
+// OperationFromUpdate converts the single operation carried by a note update
+func OperationFromUpdate(update *models.NoteUpdateData) models.TextOperation {
+	return models.TextOperation{
+		Type:     update.Operation,
+		Position: update.Position,
+		Length:   update.Length,
+		Text:     update.Text,
+		Mark:     update.Mark,
+		Unset:    update.Unset,
+	}
+}
+
+// TransformOperations transforms two concurrent operation sequences against each
+// other. The first result applies after b, the second applies after a. When both
+// sides insert at the same position, b's text is placed first.
+func TransformOperations(a, b []models.TextOperation) ([]models.TextOperation, []models.TextOperation) {
+	if len(a) == 0 || len(b) == 0 {
+		return a, b
+	}
+
+	if len(a) == 1 && len(b) == 1 {
+		return transformOperation(a[0], b[0], false), transformOperation(b[0], a[0], true)
+	}
+
+	if len(a) > 1 {
+		headA, restB := TransformOperations(a[:1], b)
+		tailA, finalB := TransformOperations(a[1:], restB)
+		return append(headA, tailA...), finalB
+	}
+
+	restA, headB := TransformOperations(a, b[:1])
+	finalA, tailB := TransformOperations(restA, b[1:])
+	return finalA, append(headB, tailB...)
+}
+
+// transformOperation rewrites a so it applies after b. aFirst decides which insert
+// goes first when both insert at the same position.
+func transformOperation(a, b models.TextOperation, aFirst bool) []models.TextOperation {
+	switch b.Type {
+	case OperationInsert:
+		inserted := textLength(b.Text)
+		switch a.Type {
+		case OperationInsert:
+			if b.Position < a.Position || (b.Position == a.Position && !aFirst) {
+				a.Position += inserted
+			}
+		case OperationDelete:
+			if b.Position <= a.Position {
+				a.Position += inserted
+			} else if b.Position < a.Position+a.Length {
+				// Keep the concurrently inserted text: delete around it
+				before := b.Position - a.Position
+				first := a
+				first.Length = before
+				second := a
+				second.Position = a.Position + inserted
+				second.Length = a.Length - before
+				return []models.TextOperation{first, second}
+			}
+		case OperationFormat:
+			if b.Position <= a.Position {
+				a.Position += inserted
+			} else if b.Position < a.Position+a.Length {
+				a.Length += inserted
+			}
+		}
+
+	case OperationDelete:
+		start, end := b.Position, b.Position+b.Length
+		switch a.Type {
+		case OperationInsert:
+			if a.Position >= end {
+				a.Position -= b.Length
+			} else if a.Position > start {
+				a.Position = start
+			}
+		case OperationDelete, OperationFormat:
+			a.Position, a.Length = shrinkRange(a.Position, a.Position+a.Length, start, end)
+			if a.Length == 0 {
+				return nil
+			}
+		}
+	}
+
+	return []models.TextOperation{a}
+}
+
+// shrinkRange returns the position and length of what remains of [from, to)
+// after [start, end) is deleted
+func shrinkRange(from, to, start, end int) (int, int) {
+	overlap := maxInt(0, minInt(to, end)-maxInt(from, start))
+	length := to - from - overlap
+
+	switch {
+	case from >= end:
+		from -= end - start
+	case from > start:
+		from = start
+	}
+	return from, length
+}
+
+// ApplyOperations applies a sequence of operations to a ProseMirror document and
+// returns the new document. The input document is not modified.
+func ApplyOperations(doc models.JSONB, ops []models.TextOperation) (models.JSONB, error) {
+	result, err := cloneDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		if err := applyOperation(result, op); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func applyOperation(doc models.JSONB, op models.TextOperation) error {
+	if op.Position < 0 || op.Length < 0 {
+		return fmt.Errorf("%w: negative position or length", ErrInvalidOperation)
+	}
+
+	from, to := op.Position, op.Position
+	switch op.Type {
+	case OperationInsert:
+		if op.Text == "" {
+			return nil
+		}
+	case OperationDelete:
+		to = op.Position + op.Length
+	case OperationFormat:
+		to = op.Position + op.Length
+		if nodeType(op.Mark) == "" {
+			return fmt.Errorf("%w: format requires a mark type", ErrInvalidOperation)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported operation %q", ErrInvalidOperation, op.Type)
+	}
+
+	block, start := findTextblock(doc, 0, from, to)
+	if block == nil {
+		return fmt.Errorf("%w: range %d-%d is not inside a single text block", ErrInvalidOperation, from, to)
+	}
+
+	inline := inlineChildren(block)
+	from -= start
+	to -= start
+
+	switch op.Type {
+	case OperationInsert:
+		inline = insertText(inline, from, op.Text)
+	case OperationDelete:
+		inline = splitInline(splitInline(inline, from), to)
+		inline = filterInline(inline, from, to, func(node map[string]interface{}) map[string]interface{} {
+			return nil
+		})
+	case OperationFormat:
+		inline = splitInline(splitInline(inline, from), to)
+		inline = filterInline(inline, from, to, func(node map[string]interface{}) map[string]interface{} {
+			if nodeType(node) == "text" {
+				node["marks"] = setMark(node["marks"], op.Mark, op.Unset)
+			}
+			return node
+		})
+	}
+
+	inline = normalizeInline(inline)
+	if len(inline) == 0 {
+		delete(block, "content")
+	} else {
+		block["content"] = inline
+	}
+	return nil
+}
+
+// findTextblock locates the deepest node whose inline content contains [from, to].
+// offset is the position at which node's content starts. It returns the node and
+// the position where its content starts.
+func findTextblock(node map[string]interface{}, offset, from, to int) (map[string]interface{}, int) {
+	pos := offset
+	for _, item := range contentOf(node) {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		size := nodeSize(child)
+		if !isLeaf(child) && nodeType(child) != "text" {
+			contentStart, contentEnd := pos+1, pos+size-1
+			if from >= contentStart && to <= contentEnd {
+				if isTextblock(child) {
+					return child, contentStart
+				}
+				return findTextblock(child, contentStart, from, to)
+			}
+		}
+		pos += size
+	}
+	return nil, 0
+}
+
+// insertText inserts text at an offset within inline content. The new text takes
+// the marks of the text node it extends.
+func insertText(inline []interface{}, at int, text string) []interface{} {
+	inline = splitInline(inline, at)
+
+	var marks interface{}
+	index, pos := 0, 0
+	for index < len(inline) && pos < at {
+		node := inline[index].(map[string]interface{})
+		pos += nodeSize(node)
+		if nodeType(node) == "text" {
+			marks = node["marks"]
+		} else {
+			marks = nil
+		}
+		index++
+	}
+
+	textNode := map[string]interface{}{"type": "text", "text": text}
+	if marks != nil {
+		textNode["marks"] = marks
+	}
+
+	result := make([]interface{}, 0, len(inline)+1)
+	result = append(result, inline[:index]...)
+	result = append(result, textNode)
+	return append(result, inline[index:]...)
+}
+
+// splitInline splits the text node that straddles offset at so that a node
+// boundary exists there
+func splitInline(inline []interface{}, at int) []interface{} {
+	result := make([]interface{}, 0, len(inline)+1)
+	pos := 0
+	for _, item := range inline {
+		node := item.(map[string]interface{})
+		size := nodeSize(node)
+		if nodeType(node) == "text" && at > pos && at < pos+size {
+			units := utf16.Encode([]rune(node["text"].(string)))
+			left := copyNode(node)
+			left["text"] = string(utf16.Decode(units[:at-pos]))
+			right := copyNode(node)
+			right["text"] = string(utf16.Decode(units[at-pos:]))
+			result = append(result, left, right)
+		} else {
+			result = append(result, node)
+		}
+		pos += size
+	}
+	return result
+}
+
+// filterInline passes every inline node fully inside [from, to) through fn, dropping
+// nodes for which fn returns nil
+func filterInline(inline []interface{}, from, to int, fn func(map[string]interface{}) map[string]interface{}) []interface{} {
+	result := make([]interface{}, 0, len(inline))
+	pos := 0
+	for _, item := range inline {
+		node := item.(map[string]interface{})
+		size := nodeSize(node)
+		if pos >= from && pos+size <= to {
+			if updated := fn(node); updated != nil {
+				result = append(result, updated)
+			}
+		} else {
+			result = append(result, node)
+		}
+		pos += size
+	}
+	return result
+}
+
+// normalizeInline drops empty text nodes and merges neighbours with equal marks
+func normalizeInline(inline []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(inline))
+	for _, item := range inline {
+		node := item.(map[string]interface{})
+		if nodeType(node) == "text" {
+			text, _ := node["text"].(string)
+			if text == "" {
+				continue
+			}
+			if len(result) > 0 {
+				prev := result[len(result)-1].(map[string]interface{})
+				if nodeType(prev) == "text" && sameMarks(prev["marks"], node["marks"]) {
+					prev["text"] = prev["text"].(string) + text
+					continue
+				}
+			}
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// setMark adds or removes a mark from a text node's mark list, keyed by mark type
+func setMark(marks interface{}, mark models.JSONB, unset bool) interface{} {
+	markType := nodeType(mark)
+	existing, _ := marks.([]interface{})
+
+	updated := make([]interface{}, 0, len(existing)+1)
+	for _, item := range existing {
+		if m, ok := item.(map[string]interface{}); ok && nodeType(m) == markType {
+			continue
+		}
+		updated = append(updated, item)
+	}
+	if !unset {
+		updated = append(updated, map[string]interface{}(mark))
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+	return updated
+}
+
+func sameMarks(a, b interface{}) bool {
+	aMarks, _ := a.([]interface{})
+	bMarks, _ := b.([]interface{})
+	if len(aMarks) == 0 && len(bMarks) == 0 {
+		return true
+	}
+	aJSON, _ := json.Marshal(aMarks)
+	bJSON, _ := json.Marshal(bMarks)
+	return string(aJSON) == string(bJSON)
+}
+
+// nodeSize returns the number of ProseMirror positions a node occupies
+func nodeSize(node map[string]interface{}) int {
+	if nodeType(node) == "text" {
+		text, _ := node["text"].(string)
+		return textLength(text)
+	}
+	if isLeaf(node) {
+		return 1
+	}
+
+	size := 2
+	for _, item := range contentOf(node) {
+		if child, ok := item.(map[string]interface{}); ok {
+			size += nodeSize(child)
+		}
+	}
+	return size
+}
+
+// isTextblock reports whether a node holds inline content (text and inline leaves)
+func isTextblock(node map[string]interface{}) bool {
+	for _, item := range contentOf(node) {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if nodeType(child) != "text" && !isLeaf(child) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLeaf(node map[string]interface{}) bool {
+	return leafNodeTypes[nodeType(node)]
+}
+
+func contentOf(node map[string]interface{}) []interface{} {
+	content, _ := node["content"].([]interface{})
+	return content
+}
+
+func inlineChildren(node map[string]interface{}) []interface{} {
+	return append([]interface{}{}, contentOf(node)...)
+}
+
+func copyNode(node map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(node))
+	for key, value := range node {
+		copied[key] = value
+	}
+	return copied
+}
+
+// textLength measures text in ProseMirror positions, which count UTF-16 code units
+func textLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+func cloneDocument(doc models.JSONB) (models.JSONB, error) {
+	if doc == nil {
+		return models.JSONB{"type": "doc", "content": []interface{}{}}, nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var cloned models.JSONB
+	if err := json.Unmarshal(data, &cloned); err != nil {
+		return nil, err
+	}
+	return cloned, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
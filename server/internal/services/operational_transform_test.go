@@ -0,0 +1,266 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Positions in a doc with one paragraph start at 1: <p>Hello world</p> spans 0..13
+func helloDoc() models.JSONB {
+	return doc(paragraph("Hello world"))
+}
+
+func insertOp(position int, text string) models.TextOperation {
+	return models.TextOperation{Type: OperationInsert, Position: position, Text: text}
+}
+
+func deleteOp(position, length int) models.TextOperation {
+	return models.TextOperation{Type: OperationDelete, Position: position, Length: length}
+}
+
+func formatOp(position, length int, markType string) models.TextOperation {
+	return models.TextOperation{Type: OperationFormat, Position: position, Length: length, Mark: models.JSONB{"type": markType}}
+}
+
+func TestApplyOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      models.JSONB
+		ops      []models.TextOperation
+		expected models.JSONB
+	}{
+		{
+			name:     "insert in the middle of text",
+			doc:      helloDoc(),
+			ops:      []models.TextOperation{insertOp(6, ",")},
+			expected: doc(paragraph("Hello, world")),
+		},
+		{
+			name:     "insert into an empty paragraph",
+			doc:      doc(map[string]interface{}{"type": "paragraph"}),
+			ops:      []models.TextOperation{insertOp(1, "Hi")},
+			expected: doc(paragraph("Hi")),
+		},
+		{
+			name:     "insert into the second block",
+			doc:      doc(paragraph("ab"), paragraph("cd")),
+			ops:      []models.TextOperation{insertOp(6, "X")},
+			expected: doc(paragraph("ab"), paragraph("cXd")),
+		},
+		{
+			name:     "delete a range",
+			doc:      helloDoc(),
+			ops:      []models.TextOperation{deleteOp(6, 6)},
+			expected: doc(paragraph("Hello")),
+		},
+		{
+			name:     "delete everything leaves an empty paragraph",
+			doc:      helloDoc(),
+			ops:      []models.TextOperation{deleteOp(1, 11)},
+			expected: doc(map[string]interface{}{"type": "paragraph"}),
+		},
+		{
+			name: "format a range",
+			doc:  helloDoc(),
+			ops:  []models.TextOperation{formatOp(7, 5, "bold")},
+			expected: doc(map[string]interface{}{
+				"type": "paragraph",
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "Hello "},
+					map[string]interface{}{"type": "text", "text": "world", "marks": []interface{}{map[string]interface{}{"type": "bold"}}},
+				},
+			}),
+		},
+		{
+			name: "unset a mark merges text back together",
+			doc:  helloDoc(),
+			ops: []models.TextOperation{
+				formatOp(7, 5, "bold"),
+				{Type: OperationFormat, Position: 7, Length: 5, Mark: models.JSONB{"type": "bold"}, Unset: true},
+			},
+			expected: helloDoc(),
+		},
+		{
+			name:     "positions count UTF-16 code units",
+			doc:      doc(paragraph("a😀b")),
+			ops:      []models.TextOperation{insertOp(4, "-")},
+			expected: doc(paragraph("a😀-b")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyOperations(tt.doc, tt.ops)
+			require.NoError(t, err)
+			assert.Equal(t, canonicalJSON(tt.expected), canonicalJSON(result))
+		})
+	}
+
+	t.Run("input document is not modified", func(t *testing.T) {
+		original := helloDoc()
+		_, err := ApplyOperations(original, []models.TextOperation{insertOp(1, "X")})
+		require.NoError(t, err)
+		assert.Equal(t, canonicalJSON(helloDoc()), canonicalJSON(original))
+	})
+
+	t.Run("invalid operations", func(t *testing.T) {
+		invalid := []models.TextOperation{
+			deleteOp(4, 10),                                 // crosses the end of the paragraph
+			insertOp(0, "X"),                                // between blocks
+			{Type: "rotate", Position: 1},                   // unknown type
+			{Type: OperationFormat, Position: 1, Length: 2}, // no mark
+			insertOp(-1, "X"),
+		}
+		for _, op := range invalid {
+			_, err := ApplyOperations(doc(paragraph("ab"), paragraph("cd")), []models.TextOperation{op})
+			assert.ErrorIs(t, err, ErrInvalidOperation, "%+v", op)
+		}
+	})
+}
+
+// TestTransformOperations_Converges checks that applying a then b' yields the same
+// document as applying b then a', for every pair of concurrent operations
+func TestTransformOperations_Converges(t *testing.T) {
+	ops := []models.TextOperation{
+		insertOp(1, "A"),
+		insertOp(6, "B"),
+		insertOp(12, "C"),
+		deleteOp(1, 5),
+		deleteOp(4, 4),
+		deleteOp(7, 5),
+		formatOp(2, 6, "italic"),
+	}
+
+	for _, a := range ops {
+		for _, b := range ops {
+			aPrime, bPrime := TransformOperations([]models.TextOperation{a}, []models.TextOperation{b})
+
+			afterB, err := ApplyOperations(helloDoc(), []models.TextOperation{b})
+			require.NoError(t, err)
+			left, err := ApplyOperations(afterB, aPrime)
+			require.NoError(t, err, "a=%+v b=%+v a'=%+v", a, b, aPrime)
+
+			afterA, err := ApplyOperations(helloDoc(), []models.TextOperation{a})
+			require.NoError(t, err)
+			right, err := ApplyOperations(afterA, bPrime)
+			require.NoError(t, err, "a=%+v b=%+v b'=%+v", a, b, bPrime)
+
+			assert.Equal(t, canonicalJSON(left), canonicalJSON(right), "a=%+v b=%+v", a, b)
+		}
+	}
+}
+
+func TestTransformOperations_DeleteAroundConcurrentInsert(t *testing.T) {
+	// One user deletes "lo wo" while another types "X" inside it
+	aPrime, _ := TransformOperations([]models.TextOperation{deleteOp(4, 5)}, []models.TextOperation{insertOp(6, "X")})
+	require.Len(t, aPrime, 2)
+
+	afterInsert, err := ApplyOperations(helloDoc(), []models.TextOperation{insertOp(6, "X")})
+	require.NoError(t, err)
+	result, err := ApplyOperations(afterInsert, aPrime)
+	require.NoError(t, err)
+	assert.Equal(t, canonicalJSON(doc(paragraph("HelXrld"))), canonicalJSON(result))
+}
+
+func TestOperationLog_Transform(t *testing.T) {
+	log := NewOperationLog()
+	noteID := uuid.New()
+
+	log.Record(noteID, 2, []models.TextOperation{insertOp(1, "AB")})
+	log.Record(noteID, 3, []models.TextOperation{deleteOp(1, 1)})
+
+	// An insert written against version 1 is shifted past both recorded edits
+	ops, err := log.Transform(noteID, 1, 3, []models.TextOperation{insertOp(6, "!")})
+	require.NoError(t, err)
+	assert.Equal(t, []models.TextOperation{insertOp(7, "!")}, ops)
+
+	// Current version needs no transform
+	ops, err = log.Transform(noteID, 3, 3, []models.TextOperation{insertOp(6, "!")})
+	require.NoError(t, err)
+	assert.Equal(t, 6, ops[0].Position)
+
+	// Versions that were never recorded cannot be transformed against
+	_, err = log.Transform(noteID, 1, 4, []models.TextOperation{insertOp(6, "!")})
+	assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+	log.Forget(noteID)
+	_, err = log.Transform(noteID, 1, 3, []models.TextOperation{insertOp(6, "!")})
+	assert.ErrorIs(t, err, ErrHistoryUnavailable)
+}
+
+func TestOperationLog_Lock(t *testing.T) {
+	log := NewOperationLog()
+	noteID := uuid.New()
+
+	unlock := log.Lock(noteID)
+	acquired := make(chan struct{})
+	go func() {
+		defer log.Lock(noteID)()
+		close(acquired)
+	}()
+
+	// A waiting caller keeps the held lock in place
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+
+	// The lock is dropped once nobody holds or waits for it
+	assert.Eventually(t, func() bool {
+		log.mutex.Lock()
+		defer log.mutex.Unlock()
+		return len(log.locks) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestWebSocketService_ConcurrentOperations(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+
+	user := createTestUser(t, testDB)
+	note := createTestNote(t, testDB, user.ID)
+	require.NoError(t, testDB.Model(&note).Update("content", helloDoc()).Error)
+
+	conn, server := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer conn.Close()
+	defer server.Close()
+
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeJoinRoom,
+		Data: models.JoinRoomData{NoteID: note.ID},
+	}))
+	_ = readMessageOfType(t, conn, models.MessageTypeAck)
+
+	// Two edits both written against version 1
+	for _, update := range []models.NoteUpdateData{
+		{NoteID: note.ID, Version: 1, Operation: OperationInsert, Position: 1, Text: ">> "},
+		{NoteID: note.ID, Version: 1, Operation: OperationInsert, Position: 12, Text: "!"},
+	} {
+		require.NoError(t, conn.WriteJSON(models.WebSocketMessage{Type: models.MessageTypeNoteUpdate, Data: update}))
+		ack := readMessageOfType(t, conn, models.MessageTypeAck)
+		assert.Equal(t, "note_updated", ack.Data.(map[string]interface{})["action"])
+	}
+
+	var updated models.Note
+	require.NoError(t, testDB.First(&updated, "id = ?", note.ID).Error)
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, canonicalJSON(doc(paragraph(">> Hello world!"))), canonicalJSON(updated.Content))
+
+	// Operations outside a text block are rejected without touching the note
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeNoteUpdate,
+		Data: models.NoteUpdateData{NoteID: note.ID, Version: 3, Operation: OperationDelete, Position: 0, Length: 50},
+	}))
+	errorMessage := readMessageOfType(t, conn, models.MessageTypeError)
+	assert.Equal(t, "invalid_operation", errorMessage.Data.(map[string]interface{})["code"])
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RevisionService records and queries note version history
+type RevisionService struct {
+	db *gorm.DB
+}
+
+// NewRevisionService creates a new revision service
+func NewRevisionService(db *gorm.DB) *RevisionService {
+	return &RevisionService{db: db}
+}
+
+// RevisionSummary is a revision without its content, used for history listings
+type RevisionSummary struct {
+	ID                uuid.UUID `json:"id"`
+	NoteID            uuid.UUID `json:"note_id"`
+	Version           int       `json:"version"`
+	Title             string    `json:"title"`
+	AuthorID          uuid.UUID `json:"author_id"`
+	AuthorName        string    `json:"author_name"`
+	ChangeDescription string    `json:"change_description"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// BlockChange describes how a single top-level block differs between two versions
+type BlockChange struct {
+	Op       string       `json:"op"` // "added", "removed", "modified"
+	NodeType string       `json:"node_type"`
+	OldIndex *int         `json:"old_index,omitempty"`
+	NewIndex *int         `json:"new_index,omitempty"`
+	OldText  string       `json:"old_text,omitempty"`
+	NewText  string       `json:"new_text,omitempty"`
+	OldNode  models.JSONB `json:"old_node,omitempty"`
+	NewNode  models.JSONB `json:"new_node,omitempty"`
+}
+
+// FieldChange describes a change to a note attribute outside the document body
+type FieldChange struct {
+	Field    string      `json:"field"`
+	OldValue interface{} `json:"old_value"`
+	NewValue interface{} `json:"new_value"`
+}
+
+// DiffStats summarises a diff
+type DiffStats struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+}
+
+// NoteDiff is a structural diff between two versions of a note
+type NoteDiff struct {
+	NoteID      uuid.UUID     `json:"note_id"`
+	FromVersion int           `json:"from_version"`
+	ToVersion   int           `json:"to_version"`
+	Fields      []FieldChange `json:"fields"`
+	Blocks      []BlockChange `json:"blocks"`
+	Stats       DiffStats     `json:"stats"`
+}
+
+// RecordRevision snapshots the note's current version. It must be called with the
+// same transaction that persisted the note so history and note never diverge.
+func (s *RevisionService) RecordRevision(tx *gorm.DB, note *models.Note, authorID uuid.UUID, changeDescription string) error {
+	revision := models.NewNoteRevision(note, authorID, changeDescription)
+	if err := revision.Validate(); err != nil {
+		return err
+	}
+	if err := tx.Create(revision).Error; err != nil {
+		return fmt.Errorf("failed to record revision %d: %w", note.Version, err)
+	}
+	return nil
+}
+
+// ListRevisions returns the history of a note, newest first
+func (s *RevisionService) ListRevisions(noteID uuid.UUID, limit, offset int) ([]RevisionSummary, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.NoteRevision{}).Where("note_id = ?", noteID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count revisions: %w", err)
+	}
+
+	var summaries []RevisionSummary
+	if err := s.db.Table("note_revisions").
+		Select("note_revisions.id, note_revisions.note_id, note_revisions.version, note_revisions.title, note_revisions.author_id, users.username AS author_name, note_revisions.change_description, note_revisions.created_at").
+		Joins("LEFT JOIN users ON users.id = note_revisions.author_id").
+		Where("note_revisions.note_id = ?", noteID).
+		Order("note_revisions.version DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&summaries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list revisions: %w", err)
+	}
+
+	return summaries, total, nil
+}
+
+// GetRevision returns a single version of a note
+func (s *RevisionService) GetRevision(noteID uuid.UUID, version int) (*models.NoteRevision, error) {
+	var revision models.NoteRevision
+	if err := s.db.Where("note_id = ? AND version = ?", noteID, version).First(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// DiffRevisions computes a structural diff between two versions of a note
+func (s *RevisionService) DiffRevisions(noteID uuid.UUID, fromVersion, toVersion int) (*NoteDiff, error) {
+	from, err := s.GetRevision(noteID, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetRevision(noteID, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &NoteDiff{
+		NoteID:      noteID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Fields:      diffFields(from, to),
+		Blocks:      DiffContent(from.Content, to.Content),
+	}
+	for _, block := range diff.Blocks {
+		switch block.Op {
+		case "added":
+			diff.Stats.Added++
+		case "removed":
+			diff.Stats.Removed++
+		case "modified":
+			diff.Stats.Modified++
+		}
+	}
+
+	return diff, nil
+}
+
+// RestoreRevision makes an old version the current content of the note. The
+// restore is itself recorded as a new version so it can be undone, and the
+// todos and connections are extracted again from the restored content.
+func (s *RevisionService) RestoreRevision(note *models.Note, version int, authorID uuid.UUID) error {
+	revision, err := s.GetRevision(note.ID, version)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		note.Title = revision.Title
+		note.Content = revision.Content
+		note.Category = revision.Category
+		note.Tags = revision.Tags
+		note.FolderPath = revision.FolderPath
+		note.Version++
+
+		if err := tx.Save(note).Error; err != nil {
+			return fmt.Errorf("failed to restore note: %w", err)
+		}
+
+		if err := s.RecordRevision(tx, note, authorID, fmt.Sprintf("Restored version %d", version)); err != nil {
+			return err
+		}
+		if err := RecordNoteSyncChange(tx, note, models.SyncEntityNote, note.ID, models.SyncActionUpdate); err != nil {
+			return err
+		}
+
+		// The restored content brings back its own todos and connections
+		if err := NewTodoService(tx).InWorkspace(note.WorkspaceID).SyncNoteTodos(note.ID, note.UserID); err != nil {
+			return fmt.Errorf("failed to restore todos: %w", err)
+		}
+		if note.Content == nil {
+			return nil
+		}
+		connectionService := NewConnectionService(tx).InWorkspace(note.WorkspaceID)
+		connections, err := connectionService.DetectConnections(note.UserID, note.ID, note.Content)
+		if err != nil {
+			return fmt.Errorf("failed to restore connections: %w", err)
+		}
+		if err := connectionService.UpdateConnections(note.UserID, note.ID, connections); err != nil {
+			return fmt.Errorf("failed to restore connections: %w", err)
+		}
+		return nil
+	})
+}
+
+// diffFields compares the note attributes that live outside the document body
+func diffFields(from, to *models.NoteRevision) []FieldChange {
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", OldValue: from.Title, NewValue: to.Title})
+	}
+	if from.Category != to.Category {
+		changes = append(changes, FieldChange{Field: "category", OldValue: from.Category, NewValue: to.Category})
+	}
+	if from.FolderPath != to.FolderPath {
+		changes = append(changes, FieldChange{Field: "folder_path", OldValue: from.FolderPath, NewValue: to.FolderPath})
+	}
+	if strings.Join(from.Tags, "\x00") != strings.Join(to.Tags, "\x00") {
+		changes = append(changes, FieldChange{Field: "tags", OldValue: []string(from.Tags), NewValue: []string(to.Tags)})
+	}
+
+	return changes
+}
+
+// DiffContent diffs the top-level blocks of two ProseMirror documents. Blocks are
+// aligned with a longest-common-subsequence over their canonical JSON, and a
+// removal immediately followed by an addition of the same node type is reported
+// as a modification.
+func DiffContent(oldDoc, newDoc models.JSONB) []BlockChange {
+	oldBlocks := topLevelBlocks(oldDoc)
+	newBlocks := topLevelBlocks(newDoc)
+
+	oldKeys := make([]string, len(oldBlocks))
+	for i, block := range oldBlocks {
+		oldKeys[i] = canonicalJSON(block)
+	}
+	newKeys := make([]string, len(newBlocks))
+	for i, block := range newBlocks {
+		newKeys[i] = canonicalJSON(block)
+	}
+
+	// lcs[i][j] is the LCS length of oldKeys[i:] and newKeys[j:]
+	lcs := make([][]int, len(oldKeys)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newKeys)+1)
+	}
+	for i := len(oldKeys) - 1; i >= 0; i-- {
+		for j := len(newKeys) - 1; j >= 0; j-- {
+			if oldKeys[i] == newKeys[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var raw []BlockChange
+	i, j := 0, 0
+	for i < len(oldKeys) || j < len(newKeys) {
+		switch {
+		case i < len(oldKeys) && j < len(newKeys) && oldKeys[i] == newKeys[j]:
+			i++
+			j++
+		case i < len(oldKeys) && (j >= len(newKeys) || lcs[i+1][j] >= lcs[i][j+1]):
+			// Prefer removals on ties so an edited block is reported as removed+added
+			raw = append(raw, removedBlock(oldBlocks[i], i))
+			i++
+		default:
+			raw = append(raw, addedBlock(newBlocks[j], j))
+			j++
+		}
+	}
+
+	// Collapse removed+added pairs of the same node type into modifications
+	changes := make([]BlockChange, 0, len(raw))
+	for k := 0; k < len(raw); k++ {
+		if k+1 < len(raw) && raw[k].Op == "removed" && raw[k+1].Op == "added" && raw[k].NodeType == raw[k+1].NodeType {
+			changes = append(changes, BlockChange{
+				Op:       "modified",
+				NodeType: raw[k].NodeType,
+				OldIndex: raw[k].OldIndex,
+				NewIndex: raw[k+1].NewIndex,
+				OldText:  raw[k].OldText,
+				NewText:  raw[k+1].NewText,
+				OldNode:  raw[k].OldNode,
+				NewNode:  raw[k+1].NewNode,
+			})
+			k++
+			continue
+		}
+		changes = append(changes, raw[k])
+	}
+
+	return changes
+}
+
+func addedBlock(block models.JSONB, index int) BlockChange {
+	return BlockChange{
+		Op:       "added",
+		NodeType: nodeType(block),
+		NewIndex: &index,
+		NewText:  nodeText(block),
+		NewNode:  block,
+	}
+}
+
+func removedBlock(block models.JSONB, index int) BlockChange {
+	return BlockChange{
+		Op:       "removed",
+		NodeType: nodeType(block),
+		OldIndex: &index,
+		OldText:  nodeText(block),
+		OldNode:  block,
+	}
+}
+
+// topLevelBlocks returns the direct children of a ProseMirror doc node
+func topLevelBlocks(doc models.JSONB) []models.JSONB {
+	if doc == nil {
+		return nil
+	}
+	content, ok := doc["content"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	blocks := make([]models.JSONB, 0, len(content))
+	for _, item := range content {
+		if node, ok := item.(map[string]interface{}); ok {
+			blocks = append(blocks, models.JSONB(node))
+		}
+	}
+	return blocks
+}
+
+func nodeType(node models.JSONB) string {
+	if t, ok := node["type"].(string); ok {
+		return t
+	}
+	return ""
+}
+
+// nodeText concatenates every text node below a block
+func nodeText(node map[string]interface{}) string {
+	if text, ok := node["text"].(string); ok {
+		return text
+	}
+
+	var parts []string
+	if content, ok := node["content"].([]interface{}); ok {
+		for _, item := range content {
+			if child, ok := item.(map[string]interface{}); ok {
+				if text := nodeText(child); text != "" {
+					parts = append(parts, text)
+				}
+			}
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// canonicalJSON serialises a node deterministically; encoding/json sorts map keys
+func canonicalJSON(node models.JSONB) string {
+	data, err := json.Marshal(map[string]interface{}(node))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func paragraph(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "paragraph",
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+	}
+}
+
+func doc(blocks ...map[string]interface{}) models.JSONB {
+	content := make([]interface{}, len(blocks))
+	for i, block := range blocks {
+		content[i] = block
+	}
+	return models.JSONB{"type": "doc", "content": content}
+}
+
+func TestDiffContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldDoc   models.JSONB
+		newDoc   models.JSONB
+		expected []string
+	}{
+		{
+			name:     "identical documents",
+			oldDoc:   doc(paragraph("a"), paragraph("b")),
+			newDoc:   doc(paragraph("a"), paragraph("b")),
+			expected: []string{},
+		},
+		{
+			name:     "block appended",
+			oldDoc:   doc(paragraph("a")),
+			newDoc:   doc(paragraph("a"), paragraph("b")),
+			expected: []string{"added"},
+		},
+		{
+			name:     "block removed",
+			oldDoc:   doc(paragraph("a"), paragraph("b")),
+			newDoc:   doc(paragraph("b")),
+			expected: []string{"removed"},
+		},
+		{
+			name:     "block edited in place",
+			oldDoc:   doc(paragraph("a"), paragraph("b"), paragraph("c")),
+			newDoc:   doc(paragraph("a"), paragraph("B"), paragraph("c")),
+			expected: []string{"modified"},
+		},
+		{
+			name:     "node type changed",
+			oldDoc:   doc(paragraph("a")),
+			newDoc:   doc(map[string]interface{}{"type": "heading", "content": []interface{}{map[string]interface{}{"type": "text", "text": "a"}}}),
+			expected: []string{"removed", "added"},
+		},
+		{
+			name:     "nil document",
+			oldDoc:   nil,
+			newDoc:   doc(paragraph("a")),
+			expected: []string{"added"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := DiffContent(tt.oldDoc, tt.newDoc)
+			ops := make([]string, len(changes))
+			for i, change := range changes {
+				ops[i] = change.Op
+			}
+			assert.Equal(t, tt.expected, ops)
+		})
+	}
+
+	changes := DiffContent(doc(paragraph("hello")), doc(paragraph("hello world")))
+	require.Len(t, changes, 1)
+	assert.Equal(t, "paragraph", changes[0].NodeType)
+	assert.Equal(t, "hello", changes[0].OldText)
+	assert.Equal(t, "hello world", changes[0].NewText)
+	assert.Equal(t, 0, *changes[0].OldIndex)
+	assert.Equal(t, 0, *changes[0].NewIndex)
+}
+
+func TestRevisionService_RecordListAndRestore(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	note := createTestNote(t, db, user.ID)
+	service := NewRevisionService(db)
+
+	require.NoError(t, service.RecordRevision(db, &note, user.ID, "Created"))
+
+	note.Title = "Renamed"
+	note.Content = doc(paragraph("second"))
+	note.Version++
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&note).Error; err != nil {
+			return err
+		}
+		return service.RecordRevision(tx, &note, user.ID, "")
+	}))
+
+	summaries, total, err := service.ListRevisions(note.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, 2, summaries[0].Version)
+	assert.Equal(t, user.Username, summaries[0].AuthorName)
+
+	diff, err := service.DiffRevisions(note.ID, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, diff.Fields, 1)
+	assert.Equal(t, "title", diff.Fields[0].Field)
+	assert.Equal(t, 1, diff.Stats.Added)
+
+	// Recording the same version twice violates the unique index
+	assert.Error(t, service.RecordRevision(db, &note, user.ID, "duplicate"))
+
+	require.NoError(t, service.RestoreRevision(&note, 1, user.ID))
+	assert.Equal(t, 3, note.Version)
+	assert.Equal(t, "Test Note", note.Title)
+
+	restored, err := service.GetRevision(note.ID, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "Restored version 1", restored.ChangeDescription)
+
+	var stored models.Note
+	require.NoError(t, db.First(&stored, "id = ?", note.ID).Error)
+	assert.Equal(t, 3, stored.Version)
+	assert.Equal(t, "Test Note", stored.Title)
+
+	_, err = service.GetRevision(note.ID, 99)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestRevisionService_RestoreRevisionSyncsTodos(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	note := createTestNote(t, db, user.ID)
+	service := NewRevisionService(db)
+	todos := NewTodoService(db)
+
+	note.Content = doc(paragraph("- [ ][t1] Call the plumber"), paragraph("- [x][t2] Pay rent"))
+	require.NoError(t, db.Save(&note).Error)
+	require.NoError(t, service.RecordRevision(db, &note, user.ID, "Created"))
+	require.NoError(t, todos.SyncNoteTodos(note.ID, user.ID))
+
+	note.Content = doc(paragraph("- [ ][t3] Something else"))
+	note.Version++
+	require.NoError(t, db.Save(&note).Error)
+	require.NoError(t, service.RecordRevision(db, &note, user.ID, ""))
+	require.NoError(t, todos.SyncNoteTodos(note.ID, user.ID))
+
+	require.NoError(t, service.RestoreRevision(&note, 1, user.ID))
+
+	var restored []models.Todo
+	require.NoError(t, db.Where("note_id = ?", note.ID).Order("todo_id").Find(&restored).Error)
+	require.Len(t, restored, 2)
+	assert.Equal(t, "t1", restored[0].TodoID)
+	assert.Equal(t, "Call the plumber", restored[0].Text)
+	assert.False(t, restored[0].IsCompleted)
+	assert.Equal(t, "t2", restored[1].TodoID)
+	assert.True(t, restored[1].IsCompleted)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"notesage-server/internal/models"
+)
+
+// SanitizeContent removes potentially dangerous HTML tags and attributes from text fields
+func SanitizeContent(content models.JSONB) models.JSONB {
+	if content == nil {
+		return content
+	}
+
+	// Convert to map for processing
+	var contentMap map[string]interface{}
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return content
+	}
+
+	err = json.Unmarshal(contentBytes, &contentMap)
+	if err != nil {
+		return content
+	}
+
+	// Recursively sanitize the content
+	sanitizedMap := sanitizeMap(contentMap)
+
+	// Convert back to JSONB
+	sanitizedBytes, err := json.Marshal(sanitizedMap)
+	if err != nil {
+		return content
+	}
+
+	var sanitizedContent models.JSONB
+	err = json.Unmarshal(sanitizedBytes, &sanitizedContent)
+	if err != nil {
+		return content
+	}
+
+	return sanitizedContent
+}
+
+// sanitizeMap recursively sanitizes text fields in a map
+func sanitizeMap(m map[string]interface{}) map[string]interface{} {
+	sanitized := make(map[string]interface{})
+
+	for key, value := range m {
+		switch v := value.(type) {
+		case string:
+			// Sanitize text fields
+			if key == "text" {
+				sanitized[key] = sanitizeText(v)
+			} else {
+				sanitized[key] = v
+			}
+		case map[string]interface{}:
+			// Recursively sanitize nested maps
+			sanitized[key] = sanitizeMap(v)
+		case []interface{}:
+			// Recursively sanitize arrays
+			sanitized[key] = sanitizeArray(v)
+		default:
+			sanitized[key] = v
+		}
+	}
+
+	return sanitized
+}
+
+// sanitizeArray recursively sanitizes arrays
+func sanitizeArray(arr []interface{}) []interface{} {
+	sanitized := make([]interface{}, len(arr))
+
+	for i, value := range arr {
+		switch v := value.(type) {
+		case string:
+			sanitized[i] = v
+		case map[string]interface{}:
+			sanitized[i] = sanitizeMap(v)
+		case []interface{}:
+			sanitized[i] = sanitizeArray(v)
+		default:
+			sanitized[i] = v
+		}
+	}
+
+	return sanitized
+}
+
+// sanitizeText removes script tags and other dangerous HTML
+func sanitizeText(text string) string {
+	// Remove script tags and their content
+	scriptRegex := regexp.MustCompile(`(?i)<script[^>]*>.*?</script>`)
+	text = scriptRegex.ReplaceAllString(text, "")
+
+	// Remove other potentially dangerous tags
+	dangerousTags := []string{"iframe", "object", "embed", "form", "input", "button", "select", "textarea"}
+	for _, tag := range dangerousTags {
+		regex := regexp.MustCompile(`(?i)<` + tag + `[^>]*>.*?</` + tag + `>`)
+		text = regex.ReplaceAllString(text, "")
+	}
+
+	// Remove dangerous attributes
+	dangerousAttrs := []string{"onclick", "onload", "onerror", "onmouseover", "onfocus", "onblur"}
+	for _, attr := range dangerousAttrs {
+		regex := regexp.MustCompile(`(?i)\s+` + attr + `\s*=\s*["'][^"']*["']`)
+		text = regex.ReplaceAllString(text, "")
+	}
+
+	return text
+}
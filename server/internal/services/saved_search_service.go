@@ -0,0 +1,288 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSavedSearchNotFound is returned for saved searches that do not exist
+	// or belong to someone else
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+
+	// ErrInvalidSavedSearch is returned for saved searches that fail
+	// validation
+	ErrInvalidSavedSearch = errors.New("invalid saved search")
+)
+
+// SavedSearchService stores users' saved searches, runs them, and tells users
+// over the WebSocket when a note starts matching one
+type SavedSearchService struct {
+	db          *gorm.DB
+	search      *SearchService
+	wsService   *WebSocketService
+	workspaceID *uuid.UUID
+}
+
+// NewSavedSearchService creates a saved search service. wsService may be nil,
+// in which case no notifications are sent.
+func NewSavedSearchService(db *gorm.DB, wsService *WebSocketService) *SavedSearchService {
+	return &SavedSearchService{
+		db:        db,
+		search:    NewSearchService(db),
+		wsService: wsService,
+	}
+}
+
+// InWorkspace returns a copy of the service for the user's saved searches in
+// a workspace, or in their personal space when workspaceID is nil
+func (s *SavedSearchService) InWorkspace(workspaceID *uuid.UUID) *SavedSearchService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	scoped.search = s.search.InWorkspace(workspaceID)
+	return &scoped
+}
+
+// SavedSearchUpdate holds the fields of a saved search to change
+type SavedSearchUpdate struct {
+	Name    *string       `json:"name"`
+	Icon    *string       `json:"icon"`
+	Query   *string       `json:"query"`
+	Filters *models.JSONB `json:"filters"`
+	Notify  *bool         `json:"notify"`
+}
+
+// owned limits a query to the user's own saved searches in the service's
+// space. Saved searches are personal even in a shared workspace.
+func (s *SavedSearchService) owned(userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if s.workspaceID != nil {
+			return db.Where("saved_searches.user_id = ? AND saved_searches.workspace_id = ?", userID, *s.workspaceID)
+		}
+		return db.Where("saved_searches.user_id = ? AND saved_searches.workspace_id IS NULL", userID)
+	}
+}
+
+// List returns the user's saved searches by name
+func (s *SavedSearchService) List(userID uuid.UUID) ([]models.SavedSearch, error) {
+	var searches []models.SavedSearch
+	if err := s.db.Scopes(s.owned(userID)).Order("name ASC").Find(&searches).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch saved searches: %w", err)
+	}
+	return searches, nil
+}
+
+// Get returns one of the user's saved searches
+func (s *SavedSearchService) Get(userID, id uuid.UUID) (*models.SavedSearch, error) {
+	var saved models.SavedSearch
+	err := s.db.Scopes(s.owned(userID)).Where("id = ?", id).First(&saved).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSavedSearchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch saved search: %w", err)
+	}
+	return &saved, nil
+}
+
+// Create saves a search for the user. A malformed query is returned as a
+// *QueryError.
+func (s *SavedSearchService) Create(userID uuid.UUID, saved *models.SavedSearch) error {
+	saved.ID = uuid.Nil
+	saved.UserID = userID
+	saved.WorkspaceID = s.workspaceID
+	if err := validateSavedSearch(saved); err != nil {
+		return err
+	}
+	if err := s.db.Create(saved).Error; err != nil {
+		return fmt.Errorf("failed to create saved search: %w", err)
+	}
+	return nil
+}
+
+// Update changes one of the user's saved searches
+func (s *SavedSearchService) Update(userID, id uuid.UUID, update SavedSearchUpdate) (*models.SavedSearch, error) {
+	saved, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		saved.Name = *update.Name
+	}
+	if update.Icon != nil {
+		saved.Icon = *update.Icon
+	}
+	if update.Query != nil {
+		saved.Query = *update.Query
+	}
+	if update.Filters != nil {
+		saved.Filters = *update.Filters
+	}
+	if update.Notify != nil {
+		saved.Notify = *update.Notify
+	}
+
+	if err := validateSavedSearch(saved); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(saved).Error; err != nil {
+		return nil, fmt.Errorf("failed to update saved search: %w", err)
+	}
+	return saved, nil
+}
+
+// Delete removes one of the user's saved searches
+func (s *SavedSearchService) Delete(userID, id uuid.UUID) error {
+	result := s.db.Scopes(s.owned(userID)).Where("id = ?", id).Delete(&models.SavedSearch{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete saved search: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// Run executes one of the user's saved searches. The paging, snippet and
+// type fields of page apply to this run.
+func (s *SavedSearchService) Run(userID, id uuid.UUID, page SearchRequest) (*UnifiedSearchResponse, error) {
+	saved, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	req, _, err := savedSearchRequest(saved)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Limit = page.Limit
+	req.Offset = page.Offset
+	req.IncludeSnippets = page.IncludeSnippets
+	if len(page.Types) > 0 {
+		req.Types = page.Types
+	}
+	return s.search.Search(userID, req)
+}
+
+// NoteQuery selects the notes matching one of the user's saved searches, so
+// that it can be listed like a folder
+func (s *SavedSearchService) NoteQuery(userID, id uuid.UUID) (*gorm.DB, error) {
+	saved, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	req, parsed, err := savedSearchRequest(saved)
+	if err != nil {
+		return nil, err
+	}
+	return s.search.noteQuery(userID, req, parsed), nil
+}
+
+// Matching returns the IDs of the saved searches with notifications on that
+// note matches. Pass them to NotifyNewMatches after changing the note.
+func (s *SavedSearchService) Matching(note *models.Note) (map[uuid.UUID]bool, error) {
+	var candidates []models.SavedSearch
+	query := s.db.Where("notify = ?", true)
+	if note.WorkspaceID != nil {
+		query = query.Where("workspace_id = ?", *note.WorkspaceID)
+	} else {
+		query = query.Where("workspace_id IS NULL AND (user_id = ? OR user_id IN (SELECT user_id FROM note_shares WHERE note_id = ?))",
+			note.UserID, note.ID)
+	}
+	if err := query.Find(&candidates).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch saved searches: %w", err)
+	}
+
+	matching := make(map[uuid.UUID]bool)
+	for _, saved := range candidates {
+		req, parsed, err := savedSearchRequest(&saved)
+		if err != nil {
+			continue
+		}
+		var count int64
+		if err := s.search.InWorkspace(saved.WorkspaceID).noteQuery(saved.UserID, req, parsed).
+			Where("notes.id = ?", note.ID).
+			Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to match saved search: %w", err)
+		}
+		if count > 0 {
+			matching[saved.ID] = true
+		}
+	}
+	return matching, nil
+}
+
+// NotifyNewMatches tells the owners of saved searches that note now matches
+// and did not match before it changed. Pass nil as before for new notes.
+func (s *SavedSearchService) NotifyNewMatches(note *models.Note, before map[uuid.UUID]bool) {
+	if s.wsService == nil {
+		return
+	}
+
+	after, err := s.Matching(note)
+	if err != nil {
+		log.Printf("Failed to match saved searches for note %s: %v", note.ID, err)
+		return
+	}
+	for id := range after {
+		if before[id] {
+			continue
+		}
+		var saved models.SavedSearch
+		if err := s.db.Where("id = ?", id).First(&saved).Error; err != nil {
+			continue
+		}
+		s.wsService.SendToUser(saved.UserID, &models.WebSocketMessage{
+			Type:      models.MessageTypeSavedSearchMatch,
+			UserID:    saved.UserID,
+			Timestamp: time.Now(),
+			Data: models.SavedSearchMatchData{
+				SavedSearchID: saved.ID,
+				Name:          saved.Name,
+				NoteID:        note.ID,
+				NoteTitle:     note.Title,
+			},
+		})
+	}
+}
+
+// validateSavedSearch checks a saved search's fields, query and filters
+func validateSavedSearch(saved *models.SavedSearch) error {
+	if err := saved.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSavedSearch, err)
+	}
+	_, _, err := savedSearchRequest(saved)
+	return err
+}
+
+// savedSearchRequest builds the search request a saved search stands for
+func savedSearchRequest(saved *models.SavedSearch) (SearchRequest, *SearchQuery, error) {
+	var req SearchRequest
+	if saved.Filters != nil {
+		data, err := json.Marshal(saved.Filters)
+		if err == nil {
+			err = json.Unmarshal(data, &req)
+		}
+		if err != nil {
+			return req, nil, fmt.Errorf("%w: filters: %v", ErrInvalidSavedSearch, err)
+		}
+	}
+	req.Query = saved.Query
+	req.Limit = 0
+	req.Offset = 0
+
+	parsed, err := ParseSearchQuery(req.Query)
+	if err != nil {
+		return req, nil, err
+	}
+	return req, parsed, nil
+}
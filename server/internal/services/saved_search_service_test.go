@@ -0,0 +1,126 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedSearchService_CRUD(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSavedSearchService(db, nil)
+
+	saved := models.SavedSearch{Name: "Go", Icon: "code", Query: "tag:go", Filters: models.JSONB{"is_pinned": true}}
+	require.NoError(t, service.Create(user.ID, &saved))
+	assert.Equal(t, user.ID, saved.UserID)
+
+	fetched, err := service.Get(user.ID, saved.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "tag:go", fetched.Query)
+
+	// Saved searches are personal
+	other := createTestUser(t, db)
+	_, err = service.Get(other.ID, saved.ID)
+	assert.ErrorIs(t, err, ErrSavedSearchNotFound)
+	assert.ErrorIs(t, service.Delete(other.ID, saved.ID), ErrSavedSearchNotFound)
+
+	// And kept apart by workspace
+	workspaceID := uuid.New()
+	list, err := service.InWorkspace(&workspaceID).List(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	name := "Go notes"
+	updated, err := service.Update(user.ID, saved.ID, SavedSearchUpdate{Name: &name})
+	require.NoError(t, err)
+	assert.Equal(t, "Go notes", updated.Name)
+	assert.Equal(t, "tag:go", updated.Query)
+
+	bad := "tag:"
+	_, err = service.Update(user.ID, saved.ID, SavedSearchUpdate{Query: &bad})
+	assert.ErrorAs(t, err, new(*QueryError))
+	assert.ErrorIs(t, service.Create(user.ID, &models.SavedSearch{Query: "go"}), ErrInvalidSavedSearch)
+	assert.ErrorIs(t, service.Create(user.ID, &models.SavedSearch{Name: "Bad", Filters: models.JSONB{"tags": "go"}}), ErrInvalidSavedSearch)
+
+	list, err = service.List(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	require.NoError(t, service.Delete(user.ID, saved.ID))
+	_, err = service.Get(user.ID, saved.ID)
+	assert.ErrorIs(t, err, ErrSavedSearchNotFound)
+}
+
+func TestSavedSearchService_RunAndNoteQuery(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSavedSearchService(db, nil)
+
+	saved := models.SavedSearch{Name: "Pinned programming", Query: "programming", Filters: models.JSONB{"is_pinned": true}}
+	require.NoError(t, service.Create(user.ID, &saved))
+
+	response, err := service.Run(user.ID, saved.ID, SearchRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, response.Results, 1)
+	assert.Equal(t, "Go Programming Tutorial", response.Results[0].Title)
+
+	query, err := service.NoteQuery(user.ID, saved.ID)
+	require.NoError(t, err)
+	var notes []models.Note
+	require.NoError(t, query.Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "Go Programming Tutorial", notes[0].Title)
+
+	_, err = service.Run(user.ID, uuid.New(), SearchRequest{})
+	assert.ErrorIs(t, err, ErrSavedSearchNotFound)
+}
+
+func TestSavedSearchService_NotifyNewMatches(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	wsService := NewWebSocketService(db)
+	service := NewSavedSearchService(db, wsService)
+
+	saved := models.SavedSearch{Name: "Urgent", Query: "tag:urgent", Notify: true}
+	require.NoError(t, service.Create(user.ID, &saved))
+	quiet := models.SavedSearch{Name: "Quiet", Query: "tag:urgent"}
+	require.NoError(t, service.Create(user.ID, &quiet))
+
+	note := models.Note{UserID: user.ID, Title: "Call the plumber", Tags: pq.StringArray{"home"}}
+	require.NoError(t, db.Create(&note).Error)
+
+	before, err := service.Matching(&note)
+	require.NoError(t, err)
+	assert.Empty(t, before)
+
+	require.NoError(t, db.Model(&note).Update("tags", pq.StringArray{"home", "urgent"}).Error)
+	after, err := service.Matching(&note)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]bool{saved.ID: true}, after)
+
+	conn, server := setupWebSocketConnection(t, wsService, user.ID, user.Username)
+	defer server.Close()
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		wsService.mutex.RLock()
+		defer wsService.mutex.RUnlock()
+		return len(wsService.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// A note that already matched is not announced again
+	service.NotifyNewMatches(&note, after)
+	service.NotifyNewMatches(&note, before)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	message := readMessageOfType(t, conn, models.MessageTypeSavedSearchMatch)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, saved.ID.String(), data["saved_search_id"])
+	assert.Equal(t, note.ID.String(), data["note_id"])
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	assert.Error(t, conn.ReadJSON(&message), "only one notification is sent")
+}
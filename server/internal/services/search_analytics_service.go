@@ -0,0 +1,207 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// searchStatsLatencySample caps how many recent searches latency
+// percentiles are computed from
+const searchStatsLatencySample = 10000
+
+// SearchAnalyticsService logs the searches users make and reports on them
+type SearchAnalyticsService struct {
+	db          *gorm.DB
+	workspaceID *uuid.UUID
+}
+
+// NewSearchAnalyticsService creates a search analytics service
+func NewSearchAnalyticsService(db *gorm.DB) *SearchAnalyticsService {
+	return &SearchAnalyticsService{db: db}
+}
+
+// InWorkspace returns a copy of the service for the user's searches in a
+// workspace, or in their personal space when workspaceID is nil
+func (s *SearchAnalyticsService) InWorkspace(workspaceID *uuid.UUID) *SearchAnalyticsService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// QueryCount is a normalized query and how often it was searched for
+type QueryCount struct {
+	Query string `json:"query"`
+	Count int64  `json:"count"`
+}
+
+// SearchPerformance summarizes search latency in milliseconds
+type SearchPerformance struct {
+	AvgMs        float64 `json:"avg_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	TotalIndexed int64   `json:"total_indexed"`
+}
+
+// SearchStats reports on a user's searches since a point in time
+type SearchStats struct {
+	UserID            uuid.UUID         `json:"user_id"`
+	Since             time.Time         `json:"since"`
+	TotalSearches     int64             `json:"total_searches"`
+	RecentSearches    []string          `json:"recent_searches"`
+	PopularQueries    []QueryCount      `json:"popular_queries"`
+	ZeroResultQueries []QueryCount      `json:"zero_result_queries"`
+	SearchPerformance SearchPerformance `json:"search_performance"`
+}
+
+// logged limits a query to the user's searches in the service's space.
+// Searches are personal even in a shared workspace.
+func (s *SearchAnalyticsService) logged(userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if s.workspaceID != nil {
+			return db.Where("search_logs.user_id = ? AND search_logs.workspace_id = ?", userID, *s.workspaceID)
+		}
+		return db.Where("search_logs.user_id = ? AND search_logs.workspace_id IS NULL", userID)
+	}
+}
+
+// Record logs a search with the number of results it found and how long it
+// took
+func (s *SearchAnalyticsService) Record(userID uuid.UUID, query string, results int64, latency time.Duration) error {
+	entry := models.SearchLog{
+		UserID:      userID,
+		WorkspaceID: s.workspaceID,
+		Query:       strings.TrimSpace(query),
+		Normalized:  normalizeSearchQuery(query),
+		ResultCount: results,
+		LatencyMs:   float64(latency.Microseconds()) / 1000,
+	}
+	if err := s.db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record search: %w", err)
+	}
+	return nil
+}
+
+// Stats reports on the user's searches since a point in time, listing up to
+// limit recent, popular and zero-result queries. Searches without text, which
+// only browse by filters, count towards the totals and latency but are not
+// listed.
+func (s *SearchAnalyticsService) Stats(userID uuid.UUID, since time.Time, limit int) (*SearchStats, error) {
+	stats := &SearchStats{
+		UserID:            userID,
+		Since:             since,
+		RecentSearches:    []string{},
+		PopularQueries:    []QueryCount{},
+		ZeroResultQueries: []QueryCount{},
+	}
+	base := func() *gorm.DB {
+		return s.db.Model(&models.SearchLog{}).Scopes(s.logged(userID)).Where("search_logs.created_at >= ?", since)
+	}
+
+	if err := base().Count(&stats.TotalSearches).Error; err != nil {
+		return nil, fmt.Errorf("failed to count searches: %w", err)
+	}
+
+	recent, err := s.recentQueries(base(), limit)
+	if err != nil {
+		return nil, err
+	}
+	stats.RecentSearches = recent
+
+	if err := s.queryCounts(base(), limit, &stats.PopularQueries); err != nil {
+		return nil, err
+	}
+	if err := s.queryCounts(base().Where("search_logs.result_count = 0"), limit, &stats.ZeroResultQueries); err != nil {
+		return nil, err
+	}
+
+	var latencies []float64
+	if err := base().Order("search_logs.created_at DESC").Limit(searchStatsLatencySample).
+		Pluck("latency_ms", &latencies).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch search latencies: %w", err)
+	}
+	stats.SearchPerformance.AvgMs, stats.SearchPerformance.P50Ms, stats.SearchPerformance.P95Ms = latencySummary(latencies)
+
+	if err := s.db.Model(&models.Note{}).Scopes(OwnedBy("notes", userID, s.workspaceID)).
+		Count(&stats.SearchPerformance.TotalIndexed).Error; err != nil {
+		return nil, fmt.Errorf("failed to count notes: %w", err)
+	}
+	return stats, nil
+}
+
+// RecentQueries returns up to limit of the user's past queries starting with
+// prefix, most recent first
+func (s *SearchAnalyticsService) RecentQueries(userID uuid.UUID, prefix string, limit int) ([]string, error) {
+	query := s.db.Model(&models.SearchLog{}).Scopes(s.logged(userID))
+	if prefix = normalizeSearchQuery(prefix); prefix != "" {
+		query = query.Where("search_logs.normalized LIKE ?", prefix+"%")
+	}
+	return s.recentQueries(query, limit)
+}
+
+// recentQueries lists the distinct queries of a search log query, most
+// recently searched first
+func (s *SearchAnalyticsService) recentQueries(query *gorm.DB, limit int) ([]string, error) {
+	var counts []QueryCount
+	if err := s.groupQueries(query, limit, "MAX(search_logs.created_at) DESC", &counts); err != nil {
+		return nil, err
+	}
+	recent := make([]string, len(counts))
+	for i, count := range counts {
+		recent[i] = count.Query
+	}
+	return recent, nil
+}
+
+// queryCounts lists the distinct queries of a search log query, most
+// searched first
+func (s *SearchAnalyticsService) queryCounts(query *gorm.DB, limit int, counts *[]QueryCount) error {
+	return s.groupQueries(query, limit, "COUNT(*) DESC, MAX(search_logs.created_at) DESC", counts)
+}
+
+// groupQueries groups a search log query by normalized query
+func (s *SearchAnalyticsService) groupQueries(query *gorm.DB, limit int, order string, counts *[]QueryCount) error {
+	*counts = []QueryCount{}
+	if err := query.
+		Select("search_logs.normalized AS query, COUNT(*) AS count").
+		Where("search_logs.normalized <> ''").
+		Group("search_logs.normalized").
+		Order(order).
+		Limit(limit).
+		Scan(counts).Error; err != nil {
+		return fmt.Errorf("failed to group searches: %w", err)
+	}
+	return nil
+}
+
+// normalizeSearchQuery lowercases a query and collapses its spaces
+func normalizeSearchQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// latencySummary returns the mean, median and 95th percentile of latencies,
+// using the nearest-rank method
+func latencySummary(latencies []float64) (avg, p50, p95 float64) {
+	if len(latencies) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]float64(nil), latencies...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, latency := range sorted {
+		sum += latency
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		return sorted[max(rank, 1)-1]
+	}
+	return sum / float64(len(sorted)), percentile(50), percentile(95)
+}
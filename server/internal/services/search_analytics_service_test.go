@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAnalyticsService_Stats(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSearchAnalyticsService(db)
+
+	searches := []struct {
+		query   string
+		results int64
+		latency time.Duration
+	}{
+		{"Go tutorial", 3, 10 * time.Millisecond},
+		{"go  TUTORIAL", 3, 20 * time.Millisecond},
+		{"kubernetes", 0, 30 * time.Millisecond},
+		{"", 5, 40 * time.Millisecond},
+		{"alpha", 1, 100 * time.Millisecond},
+	}
+	for _, search := range searches {
+		require.NoError(t, service.Record(user.ID, search.query, search.results, search.latency))
+	}
+
+	// Older searches and other spaces are left out
+	require.NoError(t, service.Record(user.ID, "ancient", 0, time.Second))
+	require.NoError(t, db.Model(&models.SearchLog{}).Where("query = ?", "ancient").
+		Update("created_at", time.Now().AddDate(0, 0, -60)).Error)
+	workspaceID := uuid.New()
+	require.NoError(t, service.InWorkspace(&workspaceID).Record(user.ID, "elsewhere", 0, time.Second))
+
+	stats, err := service.Stats(user.ID, time.Now().AddDate(0, 0, -30), 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.TotalSearches)
+	assert.ElementsMatch(t, []string{"go tutorial", "kubernetes", "alpha"}, stats.RecentSearches)
+	require.NotEmpty(t, stats.PopularQueries)
+	assert.Equal(t, QueryCount{Query: "go tutorial", Count: 2}, stats.PopularQueries[0])
+	assert.Equal(t, []QueryCount{{Query: "kubernetes", Count: 1}}, stats.ZeroResultQueries)
+	assert.InDelta(t, 40, stats.SearchPerformance.AvgMs, 0.01)
+	assert.InDelta(t, 30, stats.SearchPerformance.P50Ms, 0.01)
+	assert.InDelta(t, 100, stats.SearchPerformance.P95Ms, 0.01)
+	assert.Equal(t, int64(5), stats.SearchPerformance.TotalIndexed)
+
+	recent, err := service.RecentQueries(user.ID, "GO", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go tutorial"}, recent)
+
+	stats, err = service.Stats(uuid.New(), time.Now().AddDate(0, 0, -30), 10)
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalSearches)
+	assert.Empty(t, stats.RecentSearches)
+}
+
+func TestLatencySummary(t *testing.T) {
+	avg, p50, p95 := latencySummary(nil)
+	assert.Zero(t, avg+p50+p95)
+
+	latencies := make([]float64, 100)
+	for i := range latencies {
+		latencies[i] = float64(100 - i)
+	}
+	avg, p50, p95 = latencySummary(latencies)
+	assert.InDelta(t, 50.5, avg, 0.001)
+	assert.Equal(t, 50.0, p50)
+	assert.Equal(t, 95.0, p95)
+	assert.Equal(t, 100.0, latencies[0], "the input is not reordered")
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// SearchQuery is a parsed search box query. A note matches when it matches
+// every clause.
+//
+// The syntax is a list of terms separated by spaces:
+//
+//	word           notes containing word
+//	"exact phrase" notes containing the phrase
+//	-term          notes not matching term
+//	a OR b         notes matching either term, in any case
+//	tag:go         notes tagged go
+//	category:Work  notes in a category
+//	folder:/work   notes in a folder or its subfolders
+//	person:ana     notes mentioning a person whose name contains ana
+//	has:todo       notes with todos
+//	is:pinned      pinned notes; also is:favorite and is:archived
+//	created:>2026-01-01, updated:last-week
+//
+// Filter values may be quoted. Dates are YYYY-MM-DD or one of today,
+// yesterday, last-week, last-month and last-year, and may be prefixed with >,
+// >=, < or <=. Without an operator a date matches the day, and a relative
+// range matches the time since its start.
+type SearchQuery struct {
+	Clauses []QueryClause `json:"clauses"`
+}
+
+// QueryClause is one or more terms joined by OR
+type QueryClause struct {
+	Terms []QueryTerm `json:"terms"`
+}
+
+// QueryTerm is a word, phrase or field filter
+type QueryTerm struct {
+	// Field is empty for words and phrases
+	Field    string `json:"field,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value"`
+	Phrase   bool   `json:"phrase,omitempty"`
+	Negated  bool   `json:"negated,omitempty"`
+	// Pos is the offset of the term in the query, in characters
+	Pos int `json:"pos"`
+}
+
+// QueryError reports a malformed search query
+type QueryError struct {
+	// Pos is the offset of the problem in the query, in characters
+	Pos     int    `json:"position"`
+	Message string `json:"message"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid search query at position %d: %s", e.Pos, e.Message)
+}
+
+// queryFields are the fields a term may filter on. Other prefixes followed by
+// a colon, such as times, are searched for as text.
+var queryFields = map[string]bool{
+	"tag": true, "category": true, "folder": true, "person": true,
+	"has": true, "is": true, "created": true, "updated": true,
+}
+
+// queryDateFields are the fields compared with dates, by column
+var queryDateFields = map[string]string{
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+// ParseSearchQuery parses a search box query
+func ParseSearchQuery(q string) (*SearchQuery, error) {
+	p := queryParser{runes: []rune(q)}
+	return p.parse()
+}
+
+type queryParser struct {
+	runes []rune
+	pos   int
+}
+
+func (p *queryParser) parse() (*SearchQuery, error) {
+	query := &SearchQuery{}
+	joinNext := false
+	orPos := 0
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.runes) {
+			break
+		}
+
+		start := p.pos
+		if strings.EqualFold(p.word(), "OR") {
+			if len(query.Clauses) == 0 || joinNext {
+				return nil, &QueryError{Pos: start, Message: "OR must be between two terms"}
+			}
+			joinNext = true
+			orPos = start
+			continue
+		}
+		p.pos = start
+
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if joinNext {
+			clause := &query.Clauses[len(query.Clauses)-1]
+			if (term.Field == "") != (clause.Terms[0].Field == "") {
+				return nil, &QueryError{Pos: term.Pos, Message: "OR cannot join text with a field filter"}
+			}
+			clause.Terms = append(clause.Terms, term)
+			joinNext = false
+		} else {
+			query.Clauses = append(query.Clauses, QueryClause{Terms: []QueryTerm{term}})
+		}
+	}
+
+	if joinNext {
+		return nil, &QueryError{Pos: orPos, Message: "OR must be between two terms"}
+	}
+	return query, nil
+}
+
+func (p *queryParser) term() (QueryTerm, error) {
+	term := QueryTerm{Pos: p.pos}
+	if p.runes[p.pos] == '-' {
+		term.Negated = true
+		p.pos++
+		if p.pos >= len(p.runes) || unicode.IsSpace(p.runes[p.pos]) {
+			return term, &QueryError{Pos: term.Pos, Message: "nothing to exclude after -"}
+		}
+	}
+
+	if p.runes[p.pos] == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return term, err
+		}
+		if value == "" {
+			return term, &QueryError{Pos: term.Pos, Message: "empty phrase"}
+		}
+		term.Value = value
+		term.Phrase = true
+		return term, nil
+	}
+
+	start := p.pos
+	word := p.word()
+	field, rest, found := strings.Cut(word, ":")
+	field = strings.ToLower(field)
+	if !found || !queryFields[field] {
+		term.Value = word
+		return term, nil
+	}
+
+	// Field filter: rewind to its value, which may be quoted
+	term.Field = field
+	p.pos = start + len([]rune(field)) + 1
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(rest, op) {
+			term.Operator = op
+			p.pos += len(op)
+			break
+		}
+	}
+	valuePos := p.pos
+	if p.pos < len(p.runes) && p.runes[p.pos] == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return term, err
+		}
+		term.Value = value
+	} else {
+		term.Value = p.word()
+	}
+
+	if term.Value == "" {
+		return term, &QueryError{Pos: valuePos, Message: fmt.Sprintf("%s: needs a value", field)}
+	}
+	if term.Operator != "" && queryDateFields[field] == "" {
+		return term, &QueryError{Pos: valuePos - len(term.Operator), Message: fmt.Sprintf("%s: cannot be compared with %s", field, term.Operator)}
+	}
+	if err := validateQueryTerm(term); err != nil {
+		return term, &QueryError{Pos: valuePos, Message: err.Error()}
+	}
+	return term, nil
+}
+
+// word reads up to the next space
+func (p *queryParser) word() string {
+	start := p.pos
+	for p.pos < len(p.runes) && !unicode.IsSpace(p.runes[p.pos]) {
+		p.pos++
+	}
+	return string(p.runes[start:p.pos])
+}
+
+// quoted reads a double-quoted string starting at the current position
+func (p *queryParser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.runes) && p.runes[p.pos] != '"' {
+		p.pos++
+	}
+	if p.pos >= len(p.runes) {
+		return "", &QueryError{Pos: start, Message: "unterminated quote"}
+	}
+	p.pos++
+	return string(p.runes[start+1 : p.pos-1]), nil
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.runes) && unicode.IsSpace(p.runes[p.pos]) {
+		p.pos++
+	}
+}
+
+// validateQueryTerm checks the value of a field filter
+func validateQueryTerm(term QueryTerm) error {
+	switch term.Field {
+	case "has":
+		if strings.ToLower(term.Value) != "todo" {
+			return fmt.Errorf("has: must be todo, not %q", term.Value)
+		}
+	case "is":
+		switch strings.ToLower(term.Value) {
+		case "pinned", "favorite", "archived":
+		default:
+			return fmt.Errorf("is: must be pinned, favorite or archived, not %q", term.Value)
+		}
+	case "created", "updated":
+		if _, _, err := queryDateRange(term.Value, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryDateRange resolves a date value to the times from and to, exclusive,
+// that it covers
+func queryDateRange(value string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(value) {
+	case "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	case "last-week":
+		return now.AddDate(0, 0, -7), now, nil
+	case "last-month":
+		return now.AddDate(0, -1, 0), now, nil
+	case "last-year":
+		return now.AddDate(-1, 0, 0), now, nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%q is not a date; use YYYY-MM-DD, today, yesterday, last-week, last-month or last-year", value)
+	}
+	return day, day.AddDate(0, 0, 1), nil
+}
+
+// Text returns the words and phrases of the query, without field filters, in
+// the syntax the text search backends accept
+func (q *SearchQuery) Text() string {
+	var parts []string
+	for _, clause := range q.Clauses {
+		if clause.Terms[0].Field != "" {
+			continue
+		}
+		terms := make([]string, len(clause.Terms))
+		for i, term := range clause.Terms {
+			terms[i] = term.text()
+		}
+		parts = append(parts, strings.Join(terms, " OR "))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (t QueryTerm) text() string {
+	value := t.Value
+	if t.Phrase {
+		value = `"` + value + `"`
+	}
+	if t.Negated {
+		value = "-" + value
+	}
+	return value
+}
+
+// hasFilter reports whether the query filters on field having value
+func (q *SearchQuery) hasFilter(field, value string) bool {
+	for _, clause := range q.Clauses {
+		for _, term := range clause.Terms {
+			if term.Field == field && strings.EqualFold(term.Value, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyFilters adds the query's field filters to query
+func (q *SearchQuery) applyFilters(query *gorm.DB, now time.Time) *gorm.DB {
+	for _, clause := range q.Clauses {
+		if clause.Terms[0].Field == "" {
+			continue
+		}
+		conditions := make([]string, len(clause.Terms))
+		var args []interface{}
+		for i, term := range clause.Terms {
+			condition, termArgs := term.condition(now)
+			if term.Negated {
+				condition = "NOT (" + condition + ")"
+			}
+			conditions[i] = condition
+			args = append(args, termArgs...)
+		}
+		query = query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	return query
+}
+
+// condition is the SQL for a field filter
+func (t QueryTerm) condition(now time.Time) (string, []interface{}) {
+	switch t.Field {
+	case "tag":
+		return tagCondition([]string{t.Value})
+	case "category":
+		return "LOWER(notes.category) = ?", []interface{}{strings.ToLower(t.Value)}
+	case "folder":
+		return folderCondition(t.Value)
+	case "person":
+		return `EXISTS (SELECT 1 FROM connections JOIN people ON people.id = connections.target_id
+			WHERE connections.source_id = notes.id AND connections.source_type = 'note'
+			AND connections.target_type = 'person' AND people.deleted_at IS NULL
+			AND LOWER(people.name) LIKE ?)`, []interface{}{"%" + strings.ToLower(t.Value) + "%"}
+	case "has":
+		return "EXISTS (SELECT 1 FROM todos WHERE todos.note_id = notes.id AND todos.deleted_at IS NULL)", nil
+	case "is":
+		return "notes.is_" + strings.ToLower(t.Value) + " = ?", []interface{}{true}
+	default:
+		column := "notes." + queryDateFields[t.Field]
+		from, to, _ := queryDateRange(t.Value, now)
+		switch t.Operator {
+		case ">":
+			return column + " >= ?", []interface{}{to}
+		case ">=":
+			return column + " >= ?", []interface{}{from}
+		case "<":
+			return column + " < ?", []interface{}{from}
+		case "<=":
+			return column + " < ?", []interface{}{to}
+		default:
+			return column + " >= ? AND " + column + " < ?", []interface{}{from, to}
+		}
+	}
+}
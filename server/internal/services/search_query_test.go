@@ -0,0 +1,128 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := ParseSearchQuery(`"project alpha" go OR rust -draft tag:"best practices" created:>=2026-01-01 Is:Pinned`)
+	require.NoError(t, err)
+	assert.Equal(t, []QueryClause{
+		{Terms: []QueryTerm{{Value: "project alpha", Phrase: true, Pos: 0}}},
+		{Terms: []QueryTerm{{Value: "go", Pos: 16}, {Value: "rust", Pos: 22}}},
+		{Terms: []QueryTerm{{Value: "draft", Negated: true, Pos: 27}}},
+		{Terms: []QueryTerm{{Field: "tag", Value: "best practices", Pos: 34}}},
+		{Terms: []QueryTerm{{Field: "created", Operator: ">=", Value: "2026-01-01", Pos: 55}}},
+		{Terms: []QueryTerm{{Field: "is", Value: "Pinned", Pos: 76}}},
+	}, query.Clauses)
+	assert.Equal(t, `"project alpha" go OR rust -draft`, query.Text())
+
+	// Unknown prefixes are text
+	query, err = ParseSearchQuery("meet at 10:30 url:x")
+	require.NoError(t, err)
+	assert.Equal(t, "meet at 10:30 url:x", query.Text())
+
+	query, err = ParseSearchQuery("  ")
+	require.NoError(t, err)
+	assert.Empty(t, query.Clauses)
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{`notes "unfinished`, 6},
+		{`OR notes`, 0},
+		{`notes OR`, 6},
+		{`a OR OR b`, 5},
+		{`notes - x`, 6},
+		{`"" x`, 0},
+		{`tag: go`, 4},
+		{`has:attachment`, 4},
+		{`is:deleted`, 3},
+		{`tag:>go`, 4},
+		{`created:>2026-13-01`, 9},
+		{`updated:someday`, 8},
+		{`tag:go OR alpha`, 10},
+		{`é tag:"x`, 6},
+	}
+	for _, tt := range tests {
+		_, err := ParseSearchQuery(tt.query)
+		var queryErr *QueryError
+		if assert.ErrorAs(t, err, &queryErr, tt.query) {
+			assert.Equal(t, tt.pos, queryErr.Pos, tt.query)
+		}
+	}
+}
+
+func TestQueryDateRange(t *testing.T) {
+	now := time.Date(2026, 3, 15, 14, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	from, to, err := queryDateRange("2026-03-10", now)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{day(10), day(11)}, []time.Time{from, to})
+
+	from, to, err = queryDateRange("yesterday", now)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{day(14), day(15)}, []time.Time{from, to})
+
+	from, to, err = queryDateRange("last-week", now)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{now.AddDate(0, 0, -7), now}, []time.Time{from, to})
+}
+
+func TestSearchService_QueryFilters(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSearchService(db)
+
+	old := models.Note{UserID: user.ID, Title: "Old planning", Category: "Work", FolderPath: "/work/2025", Tags: pq.StringArray{"planning"}}
+	require.NoError(t, db.Create(&old).Error)
+	require.NoError(t, db.Model(&old).UpdateColumns(map[string]interface{}{
+		"created_at": time.Now().AddDate(-1, 0, 0),
+		"updated_at": time.Now().AddDate(-1, 0, 0),
+	}).Error)
+	require.NoError(t, db.Create(&models.Todo{NoteID: old.ID, TodoID: "t1", Text: "Follow up"}).Error)
+
+	person := models.Person{UserID: user.ID, Name: "Ana Lopez"}
+	require.NoError(t, db.Create(&person).Error)
+	require.NoError(t, db.Create(&models.Connection{
+		UserID: user.ID, SourceID: old.ID, SourceType: "note", TargetID: person.ID, TargetType: "person",
+	}).Error)
+
+	titles := func(q string) []string {
+		response, err := service.FullTextSearch(user.ID, SearchRequest{Query: q, SortBy: "title", SortOrder: "asc"})
+		require.NoError(t, err, q)
+		var titles []string
+		for _, result := range response.Results {
+			titles = append(titles, result.Note.Title)
+		}
+		return titles
+	}
+
+	assert.Equal(t, []string{"Old planning"}, titles("has:todo"))
+	assert.Equal(t, []string{"Old planning"}, titles(`person:"ana lo"`))
+	assert.Equal(t, []string{"Old planning"}, titles("folder:/work category:work"))
+	assert.Equal(t, []string{"Old planning"}, titles("updated:<last-month"))
+	assert.NotContains(t, titles("created:last-week"), "Old planning")
+	assert.Equal(t, []string{"Go Programming Tutorial"}, titles("is:pinned"))
+	assert.Equal(t, []string{"Go Programming Tutorial", "JavaScript Best Practices"}, titles("tag:go OR tag:javascript"))
+	assert.Equal(t, []string{"Go Programming Tutorial"}, titles("tag:go -tag:javascript programming"))
+	assert.Equal(t, []string{"Old planning"}, titles("planning -alpha"))
+
+	// Archived notes are only searched when asked for
+	require.NoError(t, db.Model(&old).Update("is_archived", true).Error)
+	assert.Empty(t, titles("planning"))
+	assert.Equal(t, []string{"Old planning"}, titles("planning is:archived"))
+
+	_, err := service.FullTextSearch(user.ID, SearchRequest{Query: "tag:"})
+	assert.ErrorAs(t, err, new(*QueryError))
+}
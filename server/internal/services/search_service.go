@@ -14,7 +14,8 @@ import (
 
 // SearchService provides advanced search capabilities
 type SearchService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	workspaceID *uuid.UUID
 }
 
 // NewSearchService creates a new search service
@@ -22,6 +23,14 @@ func NewSearchService(db *gorm.DB) *SearchService {
 	return &SearchService{db: db}
 }
 
+// InWorkspace returns a copy of the service that searches a workspace, or the
+// user's personal space when workspaceID is nil
+func (s *SearchService) InWorkspace(workspaceID *uuid.UUID) *SearchService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
 // SearchRequest represents a search query with filters
 type SearchRequest struct {
 	Query         string     `json:"query" form:"q"`
@@ -38,6 +47,8 @@ type SearchRequest struct {
 	SortBy        string     `json:"sort_by" form:"sort_by"`         // "relevance", "date", "title", "updated"
 	SortOrder     string     `json:"sort_order" form:"sort_order"`   // "asc", "desc"
 	IncludeSnippets bool     `json:"include_snippets" form:"include_snippets"`
+	// Types limits Search to these result types; all types when empty
+	Types         []string   `json:"types" form:"types"`
 }
 
 // SearchResult represents a search result with ranking and snippets
@@ -77,11 +88,8 @@ type RecentNote struct {
 	AccessCount int       `json:"access_count"`
 }
 
-// FullTextSearch performs advanced full-text search with ranking
-func (s *SearchService) FullTextSearch(userID uuid.UUID, req SearchRequest) (*SearchResponse, error) {
-	start := time.Now()
-	
-	// Set defaults
+// setDefaults fills in paging and sorting the request leaves out
+func (req *SearchRequest) setDefaults() {
 	if req.Limit <= 0 || req.Limit > 100 {
 		req.Limit = 20
 	}
@@ -94,52 +102,108 @@ func (s *SearchService) FullTextSearch(userID uuid.UUID, req SearchRequest) (*Se
 	if req.SortOrder == "" {
 		req.SortOrder = "desc"
 	}
+}
 
-	var notes []models.Note
-	var total int64
+// FullTextSearch performs advanced full-text search with ranking
+func (s *SearchService) FullTextSearch(userID uuid.UUID, req SearchRequest) (*SearchResponse, error) {
+	start := time.Now()
+	req.setDefaults()
 
-	query := s.db.Model(&models.Note{}).Where("user_id = ?", userID)
+	// Field filters in the query join the request's filters, and the rest
+	// goes to the text search
+	parsed, err := ParseSearchQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	results, total, err := s.searchNotes(userID, req, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SearchResponse{
+		Results: results,
+		Total:   total,
+		Limit:   req.Limit,
+		Offset:  req.Offset,
+		Query:   req.Query,
+		Took:    time.Since(start),
+	}, nil
+}
+
+// noteQuery selects the notes matching a request and its parsed query
+func (s *SearchService) noteQuery(userID uuid.UUID, req SearchRequest, parsed *SearchQuery) *gorm.DB {
+	query := s.db.Model(&models.Note{}).Scopes(AccessibleNotes(userID, s.workspaceID))
 
 	// Apply basic filters first
-	query = s.applyFilters(query, req)
+	query = s.applyFilters(query, req, parsed)
 
 	// Apply search query if provided
-	if req.Query != "" {
-		query = s.applySearchQuery(query, req.Query)
+	if text := parsed.Text(); text != "" {
+		query = s.textSearch().match(query, text)
 	}
+	return query
+}
+
+// searchNotes returns a page of the notes matching a request, ranked and
+// with snippets, and the number of matches
+func (s *SearchService) searchNotes(userID uuid.UUID, req SearchRequest, parsed *SearchQuery) ([]SearchResult, int64, error) {
+	var notes []rankedNote
+	var total int64
+
+	search := s.textSearch()
+	text := parsed.Text()
+	query := s.noteQuery(userID, req, parsed)
 
 	// Get total count
 	if err := query.Count(&total).Error; err != nil {
-		return nil, fmt.Errorf("failed to count search results: %w", err)
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
 	}
 
-	// Apply sorting
-	query = s.applySorting(query, req)
+	// Apply sorting, ranking in the database when it has a search index so
+	// that pages are in relevance order
+	rankInDB := req.SortBy == "relevance" && text != "" && search.ranks()
+	if rankInDB {
+		query = search.orderByRank(query, text, req.SortOrder == "asc")
+	} else {
+		query = s.applySorting(query, req).Select("notes.*")
+	}
 
 	// Get paginated results
 	if err := query.Limit(req.Limit).Offset(req.Offset).Find(&notes).Error; err != nil {
-		return nil, fmt.Errorf("failed to execute search: %w", err)
+		return nil, 0, fmt.Errorf("failed to execute search: %w", err)
+	}
+
+	// Generate snippets if requested
+	var snippets map[uuid.UUID][]string
+	if req.IncludeSnippets && text != "" {
+		page := make([]models.Note, len(notes))
+		for i, note := range notes {
+			page[i] = note.Note
+		}
+		var err error
+		if snippets, err = search.snippets(page, text); err != nil {
+			return nil, 0, fmt.Errorf("failed to generate snippets: %w", err)
+		}
 	}
 
 	// Convert to search results with scoring
 	results := make([]SearchResult, len(notes))
 	for i, note := range notes {
-		result := SearchResult{
-			Note:      note,
-			Score:     s.calculateRelevanceScore(note, req.Query),
-			MatchType: s.determineMatchType(note, req.Query),
+		score := note.SearchRank
+		if !rankInDB {
+			score = s.calculateRelevanceScore(note.Note, text)
 		}
-
-		// Generate snippets if requested
-		if req.IncludeSnippets && req.Query != "" {
-			result.Snippets = s.generateSnippets(note, req.Query)
+		results[i] = SearchResult{
+			Note:      note.Note,
+			Score:     score,
+			Snippets:  snippets[note.ID],
+			MatchType: s.determineMatchType(note.Note, text),
 		}
-
-		results[i] = result
 	}
 
 	// Sort by relevance if requested
-	if req.SortBy == "relevance" && req.Query != "" {
+	if req.SortBy == "relevance" && text != "" && !rankInDB {
 		sort.Slice(results, func(i, j int) bool {
 			if req.SortOrder == "asc" {
 				return results[i].Score < results[j].Score
@@ -148,14 +212,7 @@ func (s *SearchService) FullTextSearch(userID uuid.UUID, req SearchRequest) (*Se
 		})
 	}
 
-	return &SearchResponse{
-		Results: results,
-		Total:   total,
-		Limit:   req.Limit,
-		Offset:  req.Offset,
-		Query:   req.Query,
-		Took:    time.Since(start),
-	}, nil
+	return results, total, nil
 }
 
 // QuickSwitcher provides fuzzy search for note navigation
@@ -164,11 +221,13 @@ func (s *SearchService) QuickSwitcher(userID uuid.UUID, query string, limit int)
 		limit = 10
 	}
 
-	var notes []models.Note
-	
-	dbQuery := s.db.Model(&models.Note{}).
-		Where("user_id = ? AND is_archived = ?", userID, false).
-		Select("id, title, category, folder_path, updated_at")
+	var notes []accessedNote
+
+	// Notes the user opens often rank higher
+	dbQuery := accessCounts(s.db.Model(&models.Note{}), userID, time.Now().Add(-quickSwitcherAccessWindow), true).
+		Scopes(AccessibleNotes(userID, s.workspaceID)).
+		Where("notes.is_archived = ?", false).
+		Select("notes.id, notes.title, notes.category, notes.folder_path, notes.updated_at, COALESCE(access.access_count, 0) AS access_count")
 
 	if query != "" {
 		// Fuzzy search on title with different scoring
@@ -176,7 +235,7 @@ func (s *SearchService) QuickSwitcher(userID uuid.UUID, query string, limit int)
 		dbQuery = dbQuery.Where("LOWER(title) LIKE ?", "%"+searchQuery+"%")
 	}
 
-	if err := dbQuery.Order("is_pinned DESC, updated_at DESC").
+	if err := dbQuery.Order("notes.is_pinned DESC, access_count DESC, notes.updated_at DESC").
 		Limit(limit * 2). // Get more results for better fuzzy matching
 		Find(&notes).Error; err != nil {
 		return nil, fmt.Errorf("failed to execute quick switcher search: %w", err)
@@ -191,14 +250,14 @@ func (s *SearchService) QuickSwitcher(userID uuid.UUID, query string, limit int)
 				ID:       note.ID,
 				Title:    note.Title,
 				Category: note.Category,
-				Score:    score,
+				Score:    score + accessBoost(note.AccessCount),
 				Path:     note.FolderPath,
 			})
 		}
 	}
 
-	// Sort by fuzzy score
-	sort.Slice(results, func(i, j int) bool {
+	// Sort by score, keeping the database order for ties
+	sort.SliceStable(results, func(i, j int) bool {
 		return results[i].Score > results[j].Score
 	})
 
@@ -210,47 +269,19 @@ func (s *SearchService) QuickSwitcher(userID uuid.UUID, query string, limit int)
 	return results, nil
 }
 
-// GetRecentNotes retrieves recently accessed notes
+// GetRecentNotes returns the notes the user opened most recently
 func (s *SearchService) GetRecentNotes(userID uuid.UUID, limit int) ([]RecentNote, error) {
-	if limit <= 0 || limit > 50 {
-		limit = 10
-	}
-
-	var notes []models.Note
-	
-	// For now, use updated_at as a proxy for recent access
-	// In a full implementation, you'd track actual access times
-	if err := s.db.Model(&models.Note{}).
-		Where("user_id = ? AND is_archived = ?", userID, false).
-		Select("id, title, category, updated_at").
-		Order("updated_at DESC").
-		Limit(limit).
-		Find(&notes).Error; err != nil {
-		return nil, fmt.Errorf("failed to get recent notes: %w", err)
-	}
-
-	results := make([]RecentNote, len(notes))
-	for i, note := range notes {
-		results[i] = RecentNote{
-			ID:          note.ID,
-			Title:       note.Title,
-			Category:    note.Category,
-			UpdatedAt:   note.UpdatedAt,
-			AccessedAt:  note.UpdatedAt, // Using updated_at as proxy
-			AccessCount: 1,              // Placeholder
-		}
-	}
-
-	return results, nil
+	return NewNoteAccessService(s.db).InWorkspace(s.workspaceID).RecentlyViewed(userID, limit)
 }
 
-// applyFilters applies search filters to the query
-func (s *SearchService) applyFilters(query *gorm.DB, req SearchRequest) *gorm.DB {
+// applyFilters applies the request's filters and the field filters of its
+// parsed query
+func (s *SearchService) applyFilters(query *gorm.DB, req SearchRequest, parsed *SearchQuery) *gorm.DB {
 	// Exclude archived notes by default unless explicitly requested
-	if req.IsArchived == nil {
-		query = query.Where("is_archived = ?", false)
-	} else {
+	if req.IsArchived != nil {
 		query = query.Where("is_archived = ?", *req.IsArchived)
+	} else if !parsed.hasFilter("is", "archived") {
+		query = query.Where("is_archived = ?", false)
 	}
 
 	if len(req.Categories) > 0 {
@@ -258,20 +289,13 @@ func (s *SearchService) applyFilters(query *gorm.DB, req SearchRequest) *gorm.DB
 	}
 
 	if len(req.Tags) > 0 {
-		// Build OR conditions for each tag
-		tagConditions := make([]string, len(req.Tags))
-		tagArgs := make([]interface{}, len(req.Tags))
-		for i, tag := range req.Tags {
-			tagConditions[i] = "tags LIKE ?"
-			tagArgs[i] = "%\"" + tag + "\"%"
-		}
-		if len(tagConditions) > 0 {
-			query = query.Where("("+strings.Join(tagConditions, " OR ")+")", tagArgs...)
-		}
+		condition, args := tagCondition(req.Tags)
+		query = query.Where(condition, args...)
 	}
 
 	if req.FolderPath != "" {
-		query = query.Where("folder_path LIKE ?", req.FolderPath+"%")
+		condition, args := folderCondition(req.FolderPath)
+		query = query.Where(condition, args...)
 	}
 
 	if req.IsPinned != nil {
@@ -290,20 +314,35 @@ func (s *SearchService) applyFilters(query *gorm.DB, req SearchRequest) *gorm.DB
 		query = query.Where("created_at <= ?", *req.DateTo)
 	}
 
-	return query
+	return parsed.applyFilters(query, time.Now())
+}
+
+// tagCondition matches notes with any of tags
+func tagCondition(tags []string) (string, []interface{}) {
+	// Build OR conditions for each tag
+	tagConditions := make([]string, len(tags))
+	tagArgs := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		tagConditions[i] = "tags LIKE ?"
+		tagArgs[i] = "%\"" + tag + "\"%"
+	}
+	return "(" + strings.Join(tagConditions, " OR ") + ")", tagArgs
 }
 
-// applySearchQuery applies the search query with full-text search
+// folderCondition matches notes in a folder or its subfolders
+func folderCondition(folder string) (string, []interface{}) {
+	return "folder_path LIKE ?", []interface{}{folder + "%"}
+}
+
+// applySearchQuery matches the words and phrases of the search query against
+// titles and serialized content with LIKE, for databases without a search
+// index
 func (s *SearchService) applySearchQuery(query *gorm.DB, searchQuery string) *gorm.DB {
-	searchQuery = strings.ToLower(searchQuery)
-	
-	// Use PostgreSQL full-text search if available, otherwise fall back to LIKE
-	// This is a simplified version - in production you'd detect the database type
-	return query.Where(
-		"LOWER(title) LIKE ? OR LOWER(CAST(content AS TEXT)) LIKE ?",
-		"%"+searchQuery+"%",
-		"%"+searchQuery+"%",
-	)
+	parsed, err := ParseSearchQuery(searchQuery)
+	if err != nil {
+		parsed = &SearchQuery{Clauses: []QueryClause{{Terms: []QueryTerm{{Value: searchQuery, Phrase: true}}}}}
+	}
+	return matchColumns(query, parsed, "title", "CAST(content AS TEXT)")
 }
 
 // applySorting applies sorting to the query
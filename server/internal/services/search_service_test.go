@@ -353,6 +353,16 @@ func TestSearchService_GetRecentNotes(t *testing.T) {
 
 	service := NewSearchService(db)
 
+	// Open every note, the archived one included, a minute apart
+	var notes []models.Note
+	require.NoError(t, db.Where("user_id = ?", user.ID).Order("title").Find(&notes).Error)
+	for i, note := range notes {
+		require.NoError(t, db.Create(&models.NoteAccess{
+			UserID: user.ID, NoteID: note.ID, Source: models.NoteAccessOpen,
+			AccessedAt: time.Now().Add(-time.Duration(i) * time.Minute),
+		}).Error)
+	}
+
 	tests := []struct {
 		name          string
 		limit         int
@@ -382,9 +392,10 @@ func TestSearchService_GetRecentNotes(t *testing.T) {
 
 			assert.Equal(t, tt.expectedCount, len(results))
 
-			// Verify results are sorted by updated_at desc
-			if len(results) > 1 {
-				assert.True(t, results[0].UpdatedAt.After(results[1].UpdatedAt) || results[0].UpdatedAt.Equal(results[1].UpdatedAt))
+			// Verify results are sorted by accessed_at desc
+			for i := 1; i < len(results); i++ {
+				assert.True(t, results[i-1].AccessedAt.After(results[i].AccessedAt))
+				assert.Equal(t, 1, results[i].AccessCount)
 			}
 		})
 	}
@@ -587,3 +598,41 @@ func TestSearchService_GenerateSnippets(t *testing.T) {
 func searchBoolPtr(b bool) *bool {
 	return &b
 }
+
+func TestSearchService_SearchPagesAcrossTypes(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSearchService(db)
+
+	for _, name := range []string{"Tutorial Reviewer", "Tutor Smith"} {
+		require.NoError(t, db.Create(&models.Person{UserID: user.ID, Name: name}).Error)
+	}
+
+	all, err := service.Search(user.ID, SearchRequest{Query: "tutor", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(all.Results)), all.Total)
+	require.GreaterOrEqual(t, len(all.Results), 3)
+	for i := 1; i < len(all.Results); i++ {
+		assert.GreaterOrEqual(t, all.Results[i-1].Score, all.Results[i].Score)
+	}
+
+	// Pages are slices of the same ranking
+	page, err := service.Search(user.ID, SearchRequest{Query: "tutor", Limit: 2, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 2)
+	assert.Equal(t, all.Results[1].ID, page.Results[0].ID)
+	assert.Equal(t, all.Results[2].ID, page.Results[1].ID)
+	assert.Equal(t, all.Total, page.Total)
+
+	_, err = service.Search(user.ID, SearchRequest{Query: "tutor", Types: []string{"note,song"}})
+	assert.ErrorIs(t, err, ErrUnknownSearchType)
+}
+
+func TestSearchScore(t *testing.T) {
+	old := time.Now().AddDate(-1, 0, 0)
+	assert.Equal(t, 10.0, searchScore([]string{"go"}, "Go", "", old))
+	assert.Equal(t, 7.0, searchScore([]string{"go"}, "Go tips", "", old))
+	assert.Equal(t, 5.0, searchScore([]string{"go"}, "Learning go", "", old))
+	assert.Equal(t, 1.0, searchScore([]string{"go"}, "Tips", "go routines", old))
+	assert.Equal(t, 12.5, searchScore([]string{"go", "tips"}, "Go tips", "", time.Now()))
+	assert.Equal(t, 1.0, searchScore(nil, "Anything", "", old))
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// postgresSearchConfig is the text search configuration notes.search_vector
+// is built with; queries must use the same one to match its lexemes
+const postgresSearchConfig = "english"
+
+// textSearch matches, ranks and highlights notes for a full-text query using
+// the index the database provides
+type textSearch interface {
+	// match restricts query to notes matching q
+	match(query *gorm.DB, q string) *gorm.DB
+	// ranks reports whether orderByRank can rank notes in SQL
+	ranks() bool
+	// orderByRank selects each note's relevance to q as search_rank and
+	// orders query by it
+	orderByRank(query *gorm.DB, q string, ascending bool) *gorm.DB
+	// snippets returns highlighted excerpts of notes for q, by note ID
+	snippets(notes []models.Note, q string) (map[uuid.UUID][]string, error)
+}
+
+// rankedNote is a note with the relevance selected by textSearch.orderByRank
+type rankedNote struct {
+	models.Note
+	SearchRank float64 `gorm:"column:search_rank"`
+}
+
+// textSearch returns the full-text search for the service's database.
+// SQLite builds without FTS5 have no notes_fts index and fall back to LIKE.
+func (s *SearchService) textSearch() textSearch {
+	switch s.db.Dialector.Name() {
+	case "postgres":
+		return postgresTextSearch{s: s}
+	case "sqlite":
+		if s.db.Migrator().HasTable("notes_fts") {
+			return sqliteTextSearch{s: s}
+		}
+	}
+	return likeTextSearch{s: s}
+}
+
+// likeTextSearch scans titles and the serialized content with LIKE. It is
+// the fallback for databases without a search index and ranks in Go.
+type likeTextSearch struct {
+	s *SearchService
+}
+
+func (l likeTextSearch) match(query *gorm.DB, q string) *gorm.DB {
+	return l.s.applySearchQuery(query, q)
+}
+
+func (likeTextSearch) ranks() bool {
+	return false
+}
+
+func (likeTextSearch) orderByRank(query *gorm.DB, q string, ascending bool) *gorm.DB {
+	return query
+}
+
+func (l likeTextSearch) snippets(notes []models.Note, q string) (map[uuid.UUID][]string, error) {
+	snippets := make(map[uuid.UUID][]string, len(notes))
+	for _, note := range notes {
+		snippets[note.ID] = l.s.generateSnippets(note, q)
+	}
+	return snippets, nil
+}
+
+// postgresTextSearch queries the notes.search_vector column with
+// websearch_to_tsquery, so q may use quoted phrases, OR and -word
+type postgresTextSearch struct {
+	s *SearchService
+}
+
+// tsquery is the SQL for q as a tsquery
+func (postgresTextSearch) tsquery(q string) clause.Expr {
+	return gorm.Expr("websearch_to_tsquery(?, ?)", postgresSearchConfig, q)
+}
+
+func (p postgresTextSearch) match(query *gorm.DB, q string) *gorm.DB {
+	return query.Where("notes.search_vector @@ ?", p.tsquery(q))
+}
+
+func (postgresTextSearch) ranks() bool {
+	return true
+}
+
+func (p postgresTextSearch) orderByRank(query *gorm.DB, q string, ascending bool) *gorm.DB {
+	direction := "DESC"
+	if ascending {
+		direction = "ASC"
+	}
+	return query.
+		Select("notes.*, ts_rank_cd(notes.search_vector, ?) AS search_rank", p.tsquery(q)).
+		Order("search_rank " + direction + ", notes.updated_at DESC")
+}
+
+// snippets highlights the title in Go and the content with ts_headline. Only
+// the notes on the page are highlighted, since ts_headline reads the whole
+// text of each note.
+func (p postgresTextSearch) snippets(notes []models.Note, q string) (map[uuid.UUID][]string, error) {
+	snippets := make(map[uuid.UUID][]string, len(notes))
+	if len(notes) == 0 {
+		return snippets, nil
+	}
+
+	ids := make([]uuid.UUID, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+		if strings.Contains(strings.ToLower(note.Title), strings.ToLower(q)) {
+			snippets[note.ID] = append(snippets[note.ID], p.s.highlightMatch(note.Title, q))
+		}
+	}
+
+	var headlines []struct {
+		ID       uuid.UUID
+		Headline string
+	}
+	err := p.s.db.Model(&models.Note{}).
+		Select("id, ts_headline(?, note_search_text(content), ?, ?) AS headline",
+			postgresSearchConfig, p.tsquery(q),
+			`StartSel=**, StopSel=**, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" ... "`).
+		Where("id IN ?", ids).
+		Scan(&headlines).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, headline := range headlines {
+		// ts_headline returns the start of the text when nothing matched
+		if strings.Contains(headline.Headline, "**") {
+			snippets[headline.ID] = append(snippets[headline.ID], headline.Headline)
+		}
+	}
+	return snippets, nil
+}
+
+// sqliteTextSearch queries the notes_fts FTS5 index. Words match as
+// prefixes, "quoted phrases" match exactly, -word excludes notes and OR
+// between words matches either.
+type sqliteTextSearch struct {
+	s *SearchService
+}
+
+// sqliteRank weights matches in the title, body, tags and mentioned people
+const sqliteRank = "bm25(notes_fts, 10.0, 1.0, 5.0, 3.0)"
+
+// matches joins query to the notes matching an FTS5 expression, with their
+// bm25 score as fts.rank. The derived table keeps note_search's columns from
+// clashing with those of notes.
+func (sqliteTextSearch) matches(query *gorm.DB, expr string) *gorm.DB {
+	return query.Joins(`JOIN (
+		SELECT note_search.note_id AS note_id, `+sqliteRank+` AS rank
+		FROM notes_fts JOIN note_search ON note_search.id = notes_fts.rowid
+		WHERE notes_fts MATCH ?
+	) AS fts ON fts.note_id = notes.id`, expr)
+}
+
+func (f sqliteTextSearch) match(query *gorm.DB, q string) *gorm.DB {
+	include, exclude := ftsQuery(q)
+	switch {
+	case include != "":
+		return f.matches(query, include)
+	case exclude != "":
+		// FTS5 cannot negate on its own, so drop the excluded notes instead
+		return query.Where(`notes.id NOT IN (
+			SELECT note_search.note_id FROM notes_fts JOIN note_search ON note_search.id = notes_fts.rowid
+			WHERE notes_fts MATCH ?
+		)`, exclude)
+	default:
+		// Nothing the tokenizer would index, such as punctuation
+		return f.s.applySearchQuery(query, q)
+	}
+}
+
+func (sqliteTextSearch) ranks() bool {
+	return true
+}
+
+func (sqliteTextSearch) orderByRank(query *gorm.DB, q string, ascending bool) *gorm.DB {
+	if include, _ := ftsQuery(q); include == "" {
+		return query.Select("notes.*, 0 AS search_rank").Order("notes.updated_at DESC")
+	}
+	// bm25 is lower for better matches
+	direction := "DESC"
+	if ascending {
+		direction = "ASC"
+	}
+	return query.
+		Select("notes.*, -fts.rank AS search_rank").
+		Order("search_rank " + direction + ", notes.updated_at DESC")
+}
+
+// snippets highlights the title in Go and the body with snippet()
+func (f sqliteTextSearch) snippets(notes []models.Note, q string) (map[uuid.UUID][]string, error) {
+	snippets := make(map[uuid.UUID][]string, len(notes))
+	include, _ := ftsQuery(q)
+	if len(notes) == 0 || include == "" {
+		return likeTextSearch{s: f.s}.snippets(notes, q)
+	}
+
+	ids := make([]string, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID.String()
+		if strings.Contains(strings.ToLower(note.Title), strings.ToLower(q)) {
+			snippets[note.ID] = append(snippets[note.ID], f.s.highlightMatch(note.Title, q))
+		}
+	}
+
+	var headlines []struct {
+		NoteID   string
+		Headline string
+	}
+	err := f.s.db.Raw(`SELECT note_search.note_id AS note_id, snippet(notes_fts, 1, '**', '**', '...', 24) AS headline
+		FROM notes_fts JOIN note_search ON note_search.id = notes_fts.rowid
+		WHERE notes_fts MATCH ? AND note_search.note_id IN ?`, include, ids).Scan(&headlines).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, headline := range headlines {
+		id, err := uuid.Parse(headline.NoteID)
+		if err != nil || !strings.Contains(headline.Headline, "**") {
+			continue
+		}
+		snippets[id] = append(snippets[id], headline.Headline)
+	}
+	return snippets, nil
+}
+
+// ftsQuery turns the text of a search query into FTS5 expressions for the
+// notes to include and, when there is nothing to include, the notes to
+// exclude. Words become prefix queries and phrases stay phrases. Everything is
+// quoted, so FTS5 syntax in q is searched for literally.
+func ftsQuery(q string) (include, exclude string) {
+	parsed, err := ParseSearchQuery(q)
+	if err != nil {
+		parsed = &SearchQuery{Clauses: []QueryClause{{Terms: []QueryTerm{{Value: q, Phrase: true}}}}}
+	}
+
+	var groups, excluded []string
+	for _, clause := range parsed.Clauses {
+		var group []string
+		for _, term := range clause.Terms {
+			if !strings.ContainsFunc(term.Value, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+				continue
+			}
+			quoted := `"` + strings.ReplaceAll(term.Value, `"`, `""`) + `"`
+			if !term.Phrase {
+				quoted += "*"
+			}
+			if term.Negated {
+				excluded = append(excluded, quoted)
+			} else {
+				group = append(group, quoted)
+			}
+		}
+		switch len(group) {
+		case 0:
+		case 1:
+			groups = append(groups, group[0])
+		default:
+			groups = append(groups, "("+strings.Join(group, " OR ")+")")
+		}
+	}
+
+	include = strings.Join(groups, " AND ")
+	if len(excluded) == 0 {
+		return include, ""
+	}
+	exclude = strings.Join(excluded, " OR ")
+	if include == "" {
+		return "", exclude
+	}
+	return include + " NOT (" + exclude + ")", ""
+}
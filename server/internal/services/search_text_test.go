@@ -0,0 +1,219 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"notesage-server/internal/config"
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestPostgresTextSearchSQL(t *testing.T) {
+	// Build statements without a server
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=notesage"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	require.NoError(t, err)
+
+	service := NewSearchService(db)
+	search := service.textSearch()
+	require.IsType(t, postgresTextSearch{}, search)
+	assert.True(t, search.ranks())
+
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		query := search.match(tx.Model(&models.Note{}), `"project alpha" -draft`)
+		return search.orderByRank(query, `"project alpha" -draft`, false).Limit(20).Find(&[]rankedNote{})
+	})
+	assert.Contains(t, sql, `notes.search_vector @@ websearch_to_tsquery('english', '"project alpha" -draft')`)
+	assert.Contains(t, sql, `ts_rank_cd(notes.search_vector, websearch_to_tsquery('english', '"project alpha" -draft')) AS search_rank`)
+	assert.Contains(t, sql, `ORDER BY search_rank DESC, notes.updated_at DESC`)
+}
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		query, include, exclude string
+	}{
+		{"meeting notes", `"meeting"* AND "notes"*`, ""},
+		{`"project alpha" review`, `"project alpha" AND "review"*`, ""},
+		{"go OR rust tutorial", `("go"* OR "rust"*) AND "tutorial"*`, ""},
+		{"meeting -draft -old", `"meeting"* NOT ("draft"* OR "old"*)`, ""},
+		{"-draft", "", `"draft"*`},
+		{`NEAR(a b) col:x`, `"NEAR(a"* AND "b)"* AND "col:x"*`, ""},
+		{"-- ??", "", ""},
+		// Queries the parser rejects are searched for as a phrase
+		{`say "hi`, `"say ""hi"`, ""},
+	}
+	for _, tt := range tests {
+		include, exclude := ftsQuery(tt.query)
+		assert.Equal(t, tt.include, include, tt.query)
+		assert.Equal(t, tt.exclude, exclude, tt.query)
+	}
+}
+
+func TestSQLiteTextSearch(t *testing.T) {
+	db, user := setupSearchTestDB(t)
+	service := NewSearchService(db)
+	if _, ok := service.textSearch().(sqliteTextSearch); !ok {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	mention := models.Note{
+		UserID: user.ID,
+		Title:  "Quarterly planning",
+		Content: models.JSONB{"type": "doc", "content": []interface{}{
+			map[string]interface{}{"type": "paragraph", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "Budget review with "},
+				map[string]interface{}{"type": "mention", "attrs": map[string]interface{}{"id": "1", "name": "Zelda Fitzgerald"}},
+			}},
+		}},
+		Tags: pq.StringArray{"finance"},
+	}
+	require.NoError(t, db.Create(&mention).Error)
+
+	search := func(query string) *SearchResponse {
+		response, err := service.FullTextSearch(user.ID, SearchRequest{Query: query, SortBy: "relevance", Limit: 20, IncludeSnippets: true})
+		require.NoError(t, err)
+		return response
+	}
+	titles := func(response *SearchResponse) []string {
+		var titles []string
+		for _, result := range response.Results {
+			titles = append(titles, result.Note.Title)
+		}
+		return titles
+	}
+
+	// Prefixes match, and title matches outrank body matches
+	response := search("program")
+	require.NotEmpty(t, response.Results)
+	assert.Equal(t, "Go Programming Tutorial", response.Results[0].Note.Title)
+	assert.Greater(t, response.Results[0].Score, 0.0)
+
+	// Mentioned people and tags are indexed, JSON keys are not
+	assert.Equal(t, []string{"Quarterly planning"}, titles(search("zelda")))
+	assert.Equal(t, []string{"Quarterly planning"}, titles(search("finance")))
+	assert.Empty(t, search("paragraph").Results)
+
+	// Body matches are highlighted with snippet()
+	response = search("budget")
+	require.Len(t, response.Results, 1)
+	require.NotEmpty(t, response.Results[0].Snippets)
+	assert.Contains(t, response.Results[0].Snippets[0], "**Budget**")
+
+	// Exclusions
+	assert.NotContains(t, titles(search("-zelda")), "Quarterly planning")
+	assert.Empty(t, search("budget -finance").Results)
+
+	// Updates and deletes keep the index in step
+	require.NoError(t, db.Model(&mention).Update("title", "Annual planning").Error)
+	assert.Equal(t, []string{"Annual planning"}, titles(search("annual")))
+	assert.Empty(t, search("quarterly").Results)
+	require.NoError(t, db.Unscoped().Delete(&mention).Error)
+	assert.Empty(t, search("zelda").Results)
+}
+
+func TestPostgresTextSearch(t *testing.T) {
+	// Runs against the server CI configures with DB_TYPE=postgres and DB_*
+	if os.Getenv("DB_TYPE") != "postgres" {
+		t.Skip("set DB_TYPE=postgres and DB_* to run against PostgreSQL")
+	}
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	db, err := database.Initialize(cfg.Database)
+	require.NoError(t, err)
+	require.NoError(t, database.Migrate(db, nil))
+
+	user := models.User{
+		ID:       uuid.New(),
+		Password: "hashedpassword",
+		Role:     models.RoleUser,
+		IsActive: true,
+	}
+	user.Username = "pgsearch_" + user.ID.String()[:8]
+	user.Email = user.Username + "@example.com"
+	require.NoError(t, db.Create(&user).Error)
+	t.Cleanup(func() {
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Note{})
+		db.Unscoped().Delete(&user)
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	paragraph := func(nodes ...interface{}) models.JSONB {
+		return models.JSONB{"type": "doc", "content": []interface{}{
+			map[string]interface{}{"type": "paragraph", "content": nodes},
+		}}
+	}
+	notes := []models.Note{
+		{
+			UserID:  user.ID,
+			Title:   "Budget planning",
+			Content: paragraph(map[string]interface{}{"type": "text", "text": "Numbers for next year."}),
+			Tags:    pq.StringArray{"finance"},
+		},
+		{
+			UserID: user.ID,
+			Title:  "Quarterly review",
+			Content: paragraph(
+				map[string]interface{}{"type": "text", "text": "The budget review with "},
+				map[string]interface{}{"type": "mention", "attrs": map[string]interface{}{"id": "1", "name": "Zelda Fitzgerald"}},
+			),
+		},
+	}
+	require.NoError(t, db.Create(&notes).Error)
+
+	service := NewSearchService(db)
+	require.IsType(t, postgresTextSearch{}, service.textSearch())
+	search := func(query string) *SearchResponse {
+		response, err := service.FullTextSearch(user.ID, SearchRequest{Query: query, SortBy: "relevance", Limit: 20, IncludeSnippets: true})
+		require.NoError(t, err)
+		return response
+	}
+	titles := func(response *SearchResponse) []string {
+		var titles []string
+		for _, result := range response.Results {
+			titles = append(titles, result.Note.Title)
+		}
+		return titles
+	}
+
+	// Title matches (weight A) outrank body matches (weight C)
+	response := search("budget")
+	require.Equal(t, []string{"Budget planning", "Quarterly review"}, titles(response))
+	assert.Greater(t, response.Results[0].Score, response.Results[1].Score)
+	assert.Greater(t, response.Results[1].Score, 0.0)
+
+	// Titles are highlighted in Go and bodies with ts_headline
+	assert.Equal(t, []string{"**Budget** planning"}, response.Results[0].Snippets)
+	require.Len(t, response.Results[1].Snippets, 1)
+	assert.Contains(t, response.Results[1].Snippets[0], "The **budget** review")
+
+	// Words are stemmed with the english configuration
+	assert.ElementsMatch(t, []string{"Budget planning", "Quarterly review"}, titles(search("budgets")))
+
+	// Mentioned people and tags are indexed, JSON keys are not
+	assert.Equal(t, []string{"Quarterly review"}, titles(search("zelda")))
+	assert.Equal(t, []string{"Budget planning"}, titles(search("finance")))
+	assert.Empty(t, search("paragraph").Results)
+
+	// websearch_to_tsquery syntax
+	assert.Equal(t, []string{"Quarterly review"}, titles(search(`"budget review"`)))
+	assert.Equal(t, []string{"Budget planning"}, titles(search("budget -zelda")))
+	assert.ElementsMatch(t, []string{"Budget planning", "Quarterly review"}, titles(search("numbers OR zelda")))
+
+	// The generated column follows updates
+	require.NoError(t, db.Model(&notes[1]).Update("title", "Annual review").Error)
+	assert.Equal(t, []string{"Annual review"}, titles(search("annual")))
+	assert.Empty(t, search("quarterly").Results)
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Search result types
+const (
+	SearchTypeNote       = "note"
+	SearchTypePerson     = "person"
+	SearchTypeTodo       = "todo"
+	SearchTypeAttachment = "attachment"
+)
+
+// searchTypes are the result types in the order their groups are listed
+var searchTypes = []string{SearchTypeNote, SearchTypePerson, SearchTypeTodo, SearchTypeAttachment}
+
+// ErrUnknownSearchType is returned for a requested type Search cannot return
+var ErrUnknownSearchType = errors.New("unknown search type")
+
+// UnifiedSearchResult is a note, person, todo or attachment matching a search.
+// Exactly one of Note, Person, Todo and Attachment is set, as named by Type.
+type UnifiedSearchResult struct {
+	Type       string             `json:"type"`
+	ID         uuid.UUID          `json:"id"`
+	Title      string             `json:"title"`
+	Score      float64            `json:"score"`
+	Snippets   []string           `json:"snippets,omitempty"`
+	MatchType  string             `json:"match_type,omitempty"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+	Note       *models.Note       `json:"note,omitempty"`
+	Person     *models.Person     `json:"person,omitempty"`
+	Todo       *models.Todo       `json:"todo,omitempty"`
+	Attachment *models.Attachment `json:"attachment,omitempty"`
+}
+
+// SearchGroup is the results of one type on a page, and how many there are
+// in all
+type SearchGroup struct {
+	Type    string                `json:"type"`
+	Total   int64                 `json:"total"`
+	Results []UnifiedSearchResult `json:"results"`
+}
+
+// SearchFacets counts all matches by type, and matching notes by category
+// and tag
+type SearchFacets struct {
+	Type     map[string]int64 `json:"type"`
+	Category map[string]int64 `json:"category"`
+	Tag      map[string]int64 `json:"tag"`
+}
+
+// UnifiedSearchResponse is a page of mixed search results
+type UnifiedSearchResponse struct {
+	Results []UnifiedSearchResult `json:"results"`
+	Groups  []SearchGroup         `json:"groups"`
+	Facets  SearchFacets          `json:"facets"`
+	Total   int64                 `json:"total"`
+	Limit   int                   `json:"limit"`
+	Offset  int                   `json:"offset"`
+	Query   string                `json:"query"`
+	Took    time.Duration         `json:"took"`
+}
+
+// Search searches notes, people, todos and attachments and ranks them
+// together with searchScore.
+//
+// Filters only apply to notes, so queries with filters, like queries without
+// any text, only return notes.
+func (s *SearchService) Search(userID uuid.UUID, req SearchRequest) (*UnifiedSearchResponse, error) {
+	start := time.Now()
+	req.setDefaults()
+
+	parsed, err := ParseSearchQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	types, err := s.resultTypes(req, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each type contributes its best matches up to the end of the page
+	window := req.Offset + req.Limit
+	words := parsed.words()
+	facets := SearchFacets{Type: map[string]int64{}, Category: map[string]int64{}, Tag: map[string]int64{}}
+	var candidates []UnifiedSearchResult
+	var total int64
+
+	for _, resultType := range types {
+		var results []UnifiedSearchResult
+		var count int64
+		switch resultType {
+		case SearchTypeNote:
+			results, count, err = s.searchNoteResults(userID, req, parsed, window, &facets)
+		case SearchTypePerson:
+			results, count, err = s.searchPeople(userID, parsed, window)
+		case SearchTypeTodo:
+			results, count, err = s.searchTodos(userID, parsed, window)
+		case SearchTypeAttachment:
+			results, count, err = s.searchAttachments(userID, parsed, window)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			results[i].Score = searchScore(words, results[i].Title, resultBody(results[i]), results[i].UpdatedAt)
+		}
+		candidates = append(candidates, results...)
+		facets.Type[resultType] = count
+		total += count
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].UpdatedAt.After(candidates[j].UpdatedAt)
+	})
+	page := []UnifiedSearchResult{}
+	if req.Offset < len(candidates) {
+		page = candidates[req.Offset:min(window, len(candidates))]
+	}
+
+	groups := make([]SearchGroup, 0, len(types))
+	for _, resultType := range types {
+		group := SearchGroup{Type: resultType, Total: facets.Type[resultType], Results: []UnifiedSearchResult{}}
+		for _, result := range page {
+			if result.Type == resultType {
+				group.Results = append(group.Results, result)
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	return &UnifiedSearchResponse{
+		Results: page,
+		Groups:  groups,
+		Facets:  facets,
+		Total:   total,
+		Limit:   req.Limit,
+		Offset:  req.Offset,
+		Query:   req.Query,
+		Took:    time.Since(start),
+	}, nil
+}
+
+// resultTypes returns the types a request searches
+func (s *SearchService) resultTypes(req SearchRequest, parsed *SearchQuery) ([]string, error) {
+	requested := map[string]bool{}
+	for _, value := range req.Types {
+		for _, resultType := range strings.Split(value, ",") {
+			resultType = strings.TrimSpace(resultType)
+			if resultType == "" {
+				continue
+			}
+			if !slices.Contains(searchTypes, resultType) {
+				return nil, fmt.Errorf("%w %q", ErrUnknownSearchType, resultType)
+			}
+			requested[resultType] = true
+		}
+	}
+
+	notesOnly := parsed.Text() == "" || len(parsed.Clauses) != len(parsed.textClauses()) ||
+		len(req.Categories) > 0 || len(req.Tags) > 0 || req.FolderPath != "" ||
+		req.IsArchived != nil || req.IsPinned != nil || req.IsFavorite != nil ||
+		req.DateFrom != nil || req.DateTo != nil
+
+	var types []string
+	for _, resultType := range searchTypes {
+		if len(requested) > 0 && !requested[resultType] {
+			continue
+		}
+		if notesOnly && resultType != SearchTypeNote {
+			continue
+		}
+		types = append(types, resultType)
+	}
+	return types, nil
+}
+
+// searchNoteResults returns the best matching notes and counts all of them
+// into facets
+func (s *SearchService) searchNoteResults(userID uuid.UUID, req SearchRequest, parsed *SearchQuery, window int, facets *SearchFacets) ([]UnifiedSearchResult, int64, error) {
+	notesReq := req
+	notesReq.Limit = window
+	notesReq.Offset = 0
+	notes, total, err := s.searchNotes(userID, notesReq, parsed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var categories []struct {
+		Category string
+		Count    int64
+	}
+	if err := s.noteQuery(userID, req, parsed).
+		Select("notes.category AS category, COUNT(*) AS count").
+		Group("notes.category").
+		Scan(&categories).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count categories: %w", err)
+	}
+	for _, category := range categories {
+		facets.Category[category.Category] = category.Count
+	}
+
+	var tags []pq.StringArray
+	if err := s.noteQuery(userID, req, parsed).Pluck("notes.tags", &tags).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count tags: %w", err)
+	}
+	for _, noteTags := range tags {
+		for _, tag := range noteTags {
+			facets.Tag[tag]++
+		}
+	}
+
+	results := make([]UnifiedSearchResult, len(notes))
+	for i := range notes {
+		note := notes[i].Note
+		results[i] = UnifiedSearchResult{
+			Type:      SearchTypeNote,
+			ID:        note.ID,
+			Title:     note.Title,
+			Snippets:  notes[i].Snippets,
+			MatchType: notes[i].MatchType,
+			UpdatedAt: note.UpdatedAt,
+			Note:      &note,
+		}
+	}
+	return results, total, nil
+}
+
+// searchPeople returns the most recently updated people matching parsed by
+// name, email, company, title or notes
+func (s *SearchService) searchPeople(userID uuid.UUID, parsed *SearchQuery, window int) ([]UnifiedSearchResult, int64, error) {
+	query := matchColumns(s.db.Model(&models.Person{}).Scopes(OwnedBy("people", userID, s.workspaceID)), parsed,
+		"people.name", "people.email", "people.company", "people.title", "people.notes")
+
+	var total int64
+	var people []models.Person
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count people: %w", err)
+	}
+	if err := query.Order("people.updated_at DESC").Limit(window).Find(&people).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to search people: %w", err)
+	}
+
+	results := make([]UnifiedSearchResult, len(people))
+	for i := range people {
+		person := people[i]
+		results[i] = UnifiedSearchResult{
+			Type:      SearchTypePerson,
+			ID:        person.ID,
+			Title:     person.Name,
+			UpdatedAt: person.UpdatedAt,
+			Person:    &person,
+		}
+	}
+	return results, total, nil
+}
+
+// searchTodos returns the most recently updated todos in the notes the user
+// can see whose text matches parsed
+func (s *SearchService) searchTodos(userID uuid.UUID, parsed *SearchQuery, window int) ([]UnifiedSearchResult, int64, error) {
+	query := matchColumns(s.db.Model(&models.Todo{}).
+		Joins("JOIN notes ON notes.id = todos.note_id AND notes.deleted_at IS NULL").
+		Scopes(AccessibleNotes(userID, s.workspaceID)), parsed, "todos.text")
+
+	var total int64
+	var todos []models.Todo
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count todos: %w", err)
+	}
+	if err := query.Select("todos.*").Order("todos.updated_at DESC").Limit(window).Find(&todos).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to search todos: %w", err)
+	}
+
+	results := make([]UnifiedSearchResult, len(todos))
+	for i := range todos {
+		todo := todos[i]
+		results[i] = UnifiedSearchResult{
+			Type:      SearchTypeTodo,
+			ID:        todo.ID,
+			Title:     todo.Text,
+			UpdatedAt: todo.UpdatedAt,
+			Todo:      &todo,
+		}
+	}
+	return results, total, nil
+}
+
+// searchAttachments returns the newest attachments whose filename matches
+// parsed
+func (s *SearchService) searchAttachments(userID uuid.UUID, parsed *SearchQuery, window int) ([]UnifiedSearchResult, int64, error) {
+	query := matchColumns(s.db.Model(&models.Attachment{}).Scopes(OwnedBy("attachments", userID, s.workspaceID)), parsed,
+		"attachments.filename")
+
+	var total int64
+	var attachments []models.Attachment
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count attachments: %w", err)
+	}
+	if err := query.Order("attachments.created_at DESC").Limit(window).Find(&attachments).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to search attachments: %w", err)
+	}
+
+	results := make([]UnifiedSearchResult, len(attachments))
+	for i := range attachments {
+		attachment := attachments[i]
+		results[i] = UnifiedSearchResult{
+			Type:       SearchTypeAttachment,
+			ID:         attachment.ID,
+			Title:      attachment.Filename,
+			UpdatedAt:  attachment.CreatedAt,
+			Attachment: &attachment,
+		}
+	}
+	return results, total, nil
+}
+
+// matchColumns restricts query to rows where any of columns contains each
+// word and phrase of parsed, ignoring case. Terms match literally, so % and _
+// are not wildcards.
+func matchColumns(query *gorm.DB, parsed *SearchQuery, columns ...string) *gorm.DB {
+	for _, clause := range parsed.textClauses() {
+		var conditions []string
+		var args []interface{}
+		for _, term := range clause.Terms {
+			like := make([]string, len(columns))
+			for i, column := range columns {
+				like[i] = "LOWER(" + column + `) LIKE ? ESCAPE '\'`
+				args = append(args, "%"+escapeLike(strings.ToLower(term.Value))+"%")
+			}
+			condition := "(" + strings.Join(like, " OR ") + ")"
+			if term.Negated {
+				condition = "NOT " + condition
+			}
+			conditions = append(conditions, condition)
+		}
+		query = query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	return query
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike quotes s for a LIKE pattern with ESCAPE '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// resultBody is the text of a result searchScore weighs below its title
+func resultBody(result UnifiedSearchResult) string {
+	switch {
+	case result.Note != nil:
+		return fmt.Sprintf("%v %s %s", result.Note.Content, strings.Join(result.Note.Tags, " "), result.Note.Category)
+	case result.Person != nil:
+		return strings.Join([]string{result.Person.Email, result.Person.Company, result.Person.Title, result.Person.Notes}, " ")
+	case result.Attachment != nil:
+		return result.Attachment.ContentType
+	}
+	return ""
+}
+
+// searchScore scores how well a result matches the words of a query. Every
+// type of result is scored the same way so that they can be ranked together:
+// each word scores by where it appears in the title, or lower when only in the
+// body, and recently changed results score slightly higher.
+func searchScore(words []string, title, body string, updatedAt time.Time) float64 {
+	title = strings.ToLower(title)
+	body = strings.ToLower(body)
+
+	score := 1.0
+	if len(words) > 0 {
+		score = 0
+	}
+	for _, word := range words {
+		switch {
+		case title == word:
+			score += 10
+		case strings.HasPrefix(title, word):
+			score += 7
+		case strings.Contains(title, word):
+			score += 5
+		case strings.Contains(body, word):
+			score += 1
+		}
+	}
+
+	if time.Since(updatedAt) < 7*24*time.Hour {
+		score += 0.5
+	}
+	return score
+}
+
+// textClauses returns the clauses of words and phrases, without filters
+func (q *SearchQuery) textClauses() []QueryClause {
+	var clauses []QueryClause
+	for _, clause := range q.Clauses {
+		if clause.Terms[0].Field == "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+// words returns the words and phrases a match should contain, in lower case
+func (q *SearchQuery) words() []string {
+	var words []string
+	for _, clause := range q.textClauses() {
+		for _, term := range clause.Terms {
+			if !term.Negated {
+				words = append(words, strings.ToLower(term.Value))
+			}
+		}
+	}
+	return words
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoteAccessDenied means the user can see a note but their role does not
+	// allow the requested action
+	ErrNoteAccessDenied = errors.New("insufficient permission for this note")
+	// ErrShareWithOwner means a note was shared with the user who owns it
+	ErrShareWithOwner = errors.New("a note cannot be shared with its owner")
+	// ErrShareUserNotFound means the user a note was shared with does not exist
+	ErrShareUserNotFound = errors.New("user not found")
+	// ErrShareNotFound means the user has no grant on the note
+	ErrShareNotFound = errors.New("note is not shared with this user")
+)
+
+// AccessibleNotes scopes a notes query to the notes of a workspace or, when
+// workspaceID is nil, to the user's personal notes and those shared with them
+func AccessibleNotes(userID uuid.UUID, workspaceID *uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if workspaceID != nil {
+			return db.Where("notes.workspace_id = ?", *workspaceID)
+		}
+		return db.Where("((notes.user_id = ? AND notes.workspace_id IS NULL) OR notes.id IN (SELECT note_id FROM note_shares WHERE user_id = ?))", userID, userID)
+	}
+}
+
+// SharedNote is a note someone else has shared with the user
+type SharedNote struct {
+	Note     models.Note `json:"note"`
+	Role     string      `json:"role"`
+	SharedBy uuid.UUID   `json:"shared_by"`
+	SharedAt time.Time   `json:"shared_at"`
+}
+
+// ShareService manages per-note access grants and answers access checks
+type ShareService struct {
+	db *gorm.DB
+}
+
+// NewShareService creates a new share service
+func NewShareService(db *gorm.DB) *ShareService {
+	return &ShareService{db: db}
+}
+
+// NoteRole returns the user's role on a note, or "" if they have no access.
+// Workspace notes are governed by the user's workspace role, falling back to
+// a direct share for users outside the workspace.
+func (s *ShareService) NoteRole(userID uuid.UUID, note *models.Note) (string, error) {
+	if note.WorkspaceID != nil {
+		var member models.WorkspaceMember
+		err := s.db.Where("workspace_id = ? AND user_id = ?", *note.WorkspaceID, userID).First(&member).Error
+		switch {
+		case err == nil:
+			return noteRoleForWorkspace(member.Role), nil
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return "", err
+		}
+	} else if note.UserID == userID {
+		return models.NoteRoleOwner, nil
+	}
+
+	var share models.NoteShare
+	if err := s.db.Where("note_id = ? AND user_id = ?", note.ID, userID).First(&share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return share.Role, nil
+}
+
+// AuthorizeNote loads a note and checks that the user holds at least the
+// required role on it. A note the user cannot see at all is reported as
+// gorm.ErrRecordNotFound so its existence is not revealed; a note they can
+// see but not act on is reported as ErrNoteAccessDenied.
+func (s *ShareService) AuthorizeNote(userID, noteID uuid.UUID, required string) (*models.Note, string, error) {
+	var note models.Note
+	if err := s.db.Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, "", err
+	}
+
+	role, err := s.NoteRole(userID, &note)
+	if err != nil {
+		return nil, "", err
+	}
+	if role == "" {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	if !models.NoteRoleAllows(role, required) {
+		return &note, role, ErrNoteAccessDenied
+	}
+	return &note, role, nil
+}
+
+// ListShares returns the grants on a note. Anyone who can open the note may
+// see who else has access.
+func (s *ShareService) ListShares(userID, noteID uuid.UUID) ([]models.NoteShare, error) {
+	if _, _, err := s.AuthorizeNote(userID, noteID, models.NoteRoleViewer); err != nil {
+		return nil, err
+	}
+
+	var shares []models.NoteShare
+	if err := s.db.Preload("User").Where("note_id = ?", noteID).Order("created_at").Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch shares: %w", err)
+	}
+	return shares, nil
+}
+
+// ShareNote grants a user a role on a note, or changes the role of an existing
+// grant. Only the owner may share a note.
+func (s *ShareService) ShareNote(ownerID, noteID, granteeID uuid.UUID, role string) (*models.NoteShare, error) {
+	note, _, err := s.AuthorizeNote(ownerID, noteID, models.NoteRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	if granteeID == note.UserID {
+		return nil, ErrShareWithOwner
+	}
+
+	var grantee models.User
+	if err := s.db.Where("id = ? AND is_active = ?", granteeID, true).First(&grantee).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareUserNotFound
+		}
+		return nil, err
+	}
+
+	share := models.NoteShare{NoteID: note.ID, UserID: grantee.ID, Role: role, GrantedBy: ownerID}
+	if err := share.Validate(); err != nil {
+		return nil, err
+	}
+
+	var existing models.NoteShare
+	err = s.db.Where("note_id = ? AND user_id = ?", note.ID, grantee.ID).First(&existing).Error
+	switch {
+	case err == nil:
+		existing.Role = role
+		existing.GrantedBy = ownerID
+		if err := s.db.Save(&existing).Error; err != nil {
+			return nil, fmt.Errorf("failed to update share: %w", err)
+		}
+		share = existing
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := s.db.Create(&share).Error; err != nil {
+			return nil, fmt.Errorf("failed to create share: %w", err)
+		}
+	default:
+		return nil, err
+	}
+
+	share.User = grantee
+	return &share, nil
+}
+
+// RevokeShare removes a user's access to a note. The owner may revoke anyone;
+// anyone else may only remove themselves.
+func (s *ShareService) RevokeShare(userID, noteID, granteeID uuid.UUID) error {
+	note, role, err := s.AuthorizeNote(userID, noteID, models.NoteRoleViewer)
+	if err != nil {
+		return err
+	}
+	if role != models.NoteRoleOwner && granteeID != userID {
+		return ErrNoteAccessDenied
+	}
+
+	result := s.db.Where("note_id = ? AND user_id = ?", note.ID, granteeID).Delete(&models.NoteShare{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke share: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// SharedWithUser returns the notes other users have shared with a user, most
+// recently updated first
+func (s *ShareService) SharedWithUser(userID uuid.UUID) ([]SharedNote, error) {
+	var shares []models.NoteShare
+	if err := s.db.Preload("Note").
+		Joins("JOIN notes ON notes.id = note_shares.note_id").
+		Where("note_shares.user_id = ? AND notes.deleted_at IS NULL", userID).
+		Order("notes.updated_at DESC").
+		Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch shared notes: %w", err)
+	}
+
+	notes := make([]SharedNote, len(shares))
+	for i, share := range shares {
+		notes[i] = SharedNote{Note: share.Note, Role: share.Role, SharedBy: share.GrantedBy, SharedAt: share.CreatedAt}
+	}
+	return notes, nil
+}
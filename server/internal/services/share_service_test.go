@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestShareService_Roles(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	stranger := createTestUser(t, db)
+	note := createTestNote(t, db, owner.ID)
+	service := NewShareService(db)
+
+	_, err := service.ShareNote(owner.ID, note.ID, owner.ID, models.NoteRoleEditor)
+	assert.ErrorIs(t, err, ErrShareWithOwner)
+
+	_, err = service.ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleOwner)
+	assert.Error(t, err)
+
+	share, err := service.ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+	assert.Equal(t, viewer.ID, share.User.ID)
+
+	// Viewers can read but not edit or reshare
+	_, role, err := service.AuthorizeNote(viewer.ID, note.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+	assert.Equal(t, models.NoteRoleViewer, role)
+
+	_, _, err = service.AuthorizeNote(viewer.ID, note.ID, models.NoteRoleEditor)
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+
+	_, err = service.ShareNote(viewer.ID, note.ID, stranger.ID, models.NoteRoleViewer)
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+
+	// Users without a grant cannot tell the note exists
+	_, _, err = service.AuthorizeNote(stranger.ID, note.ID, models.NoteRoleViewer)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Sharing again changes the role in place
+	_, err = service.ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleEditor)
+	require.NoError(t, err)
+	shares, err := service.ListShares(viewer.ID, note.ID)
+	require.NoError(t, err)
+	require.Len(t, shares, 1)
+	assert.Equal(t, models.NoteRoleEditor, shares[0].Role)
+
+	shared, err := service.SharedWithUser(viewer.ID)
+	require.NoError(t, err)
+	require.Len(t, shared, 1)
+	assert.Equal(t, note.ID, shared[0].Note.ID)
+	assert.Equal(t, owner.ID, shared[0].SharedBy)
+
+	// Collaborators may leave but not remove others
+	err = service.RevokeShare(stranger.ID, note.ID, viewer.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	require.NoError(t, service.RevokeShare(viewer.ID, note.ID, viewer.ID))
+	assert.ErrorIs(t, service.RevokeShare(owner.ID, note.ID, viewer.ID), ErrShareNotFound)
+
+	_, _, err = service.AuthorizeNote(viewer.ID, note.ID, models.NoteRoleViewer)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestShareService_SharedNotesInSearchAndGraph(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	collaborator := createTestUser(t, db)
+
+	shared := createTestNote(t, db, owner.ID)
+	private := createTestNote(t, db, owner.ID)
+	require.NoError(t, db.Model(&shared).Update("title", "Shared roadmap").Error)
+	require.NoError(t, db.Model(&private).Update("title", "Private roadmap").Error)
+
+	person := models.Person{UserID: owner.ID, Name: "Ada"}
+	require.NoError(t, db.Create(&person).Error)
+	require.NoError(t, db.Create(&models.Connection{UserID: owner.ID, SourceID: shared.ID, SourceType: "note", TargetID: person.ID, TargetType: "person"}).Error)
+	require.NoError(t, db.Create(&models.Connection{UserID: owner.ID, SourceID: shared.ID, SourceType: "note", TargetID: private.ID, TargetType: "note"}).Error)
+
+	_, err := NewShareService(db).ShareNote(owner.ID, shared.ID, collaborator.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+
+	results, err := NewSearchService(db).QuickSwitcher(collaborator.ID, "roadmap", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, shared.ID, results[0].ID)
+
+	// The shared note is a node, but the owner's person and private note stay hidden
+	graph, err := NewConnectionService(db).GetGraphData(collaborator.ID, map[string]interface{}{})
+	require.NoError(t, err)
+	require.Len(t, graph.Nodes, 1)
+	assert.Equal(t, shared.ID, graph.Nodes[0].ID)
+	assert.Empty(t, graph.Edges)
+
+	// Once the linked note is shared too, the edge between them appears
+	_, err = NewShareService(db).ShareNote(owner.ID, private.ID, collaborator.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+	edges, err := NewConnectionService(db).GetNodeConnections(collaborator.ID, shared.ID)
+	require.NoError(t, err)
+	require.Len(t, edges, 1)
+	assert.Equal(t, private.ID, edges[0].TargetID)
+}
@@ -0,0 +1,1010 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSyncPageSize = 500
+	maxSyncPageSize     = 1000
+	// MaxSyncPushSize is the largest batch of mutations accepted in one push
+	MaxSyncPushSize = 500
+
+	syncCursorPrefix = "v1:"
+)
+
+// Push result statuses
+const (
+	SyncStatusAccepted = "accepted"
+	SyncStatusConflict = "conflict"
+	SyncStatusRejected = "rejected"
+)
+
+var (
+	// ErrInvalidCursor means a sync cursor could not be decoded
+	ErrInvalidCursor = errors.New("invalid sync cursor")
+	// errStaleVersion means a mutation was based on an outdated copy of an entity
+	errStaleVersion = errors.New("entity has changed since the base version")
+)
+
+// RecordSyncChange appends an entry to a user's change feed. Call it with the
+// transaction that makes the change so the feed never runs ahead of the data.
+func RecordSyncChange(tx *gorm.DB, userID uuid.UUID, entityType string, entityID uuid.UUID, action string) error {
+	change := &models.SyncChange{
+		UserID:     userID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+	}
+	if err := change.Validate(); err != nil {
+		return err
+	}
+	if err := tx.Create(change).Error; err != nil {
+		return fmt.Errorf("failed to record sync change: %w", err)
+	}
+	return nil
+}
+
+// RecordNoteSyncChange appends a change to a note, or to one of its todos, to
+// the feed of everyone who can see the note: its owner or the members of its
+// workspace, and the users it is shared with
+func RecordNoteSyncChange(tx *gorm.DB, note *models.Note, entityType string, entityID uuid.UUID, action string) error {
+	audience := []uuid.UUID{note.UserID}
+	if note.WorkspaceID != nil {
+		audience = nil
+		if err := tx.Model(&models.WorkspaceMember{}).Where("workspace_id = ?", *note.WorkspaceID).
+			Pluck("user_id", &audience).Error; err != nil {
+			return fmt.Errorf("failed to fetch workspace members: %w", err)
+		}
+	}
+	var shared []uuid.UUID
+	if err := tx.Model(&models.NoteShare{}).Where("note_id = ?", note.ID).Pluck("user_id", &shared).Error; err != nil {
+		return fmt.Errorf("failed to fetch note shares: %w", err)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for _, userID := range append(audience, shared...) {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		if err := RecordSyncChange(tx, userID, entityType, entityID, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteNote moves a note and its todos to the trash and records the deletions
+// in the change feed. The note must belong to the workspace, or to the user's
+// personal space when workspaceID is nil. The todos share the note's tombstone
+// so that restoring the note brings back exactly the todos deleted with it. It
+// reports false if the note does not exist.
+func DeleteNote(tx *gorm.DB, userID uuid.UUID, workspaceID *uuid.UUID, noteID uuid.UUID) (bool, error) {
+	var note models.Note
+	if err := tx.Scopes(OwnedBy("notes", userID, workspaceID)).Where("id = ?", noteID).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var todoIDs []uuid.UUID
+	if err := tx.Model(&models.Todo{}).
+		Joins("JOIN notes ON notes.id = todos.note_id").
+		Scopes(OwnedBy("notes", userID, workspaceID)).
+		Where("todos.note_id = ?", noteID).
+		Pluck("todos.id", &todoIDs).Error; err != nil {
+		return false, err
+	}
+
+	deletedAt := time.Now()
+	result := tx.Model(&models.Note{}).Scopes(OwnedBy("notes", userID, workspaceID)).
+		Where("id = ?", noteID).UpdateColumn("deleted_at", deletedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if len(todoIDs) > 0 {
+		if err := tx.Model(&models.Todo{}).Where("id IN ?", todoIDs).UpdateColumn("deleted_at", deletedAt).Error; err != nil {
+			return false, err
+		}
+	}
+
+	for _, todoID := range todoIDs {
+		if err := RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todoID, models.SyncActionDelete); err != nil {
+			return false, err
+		}
+	}
+	return true, RecordNoteSyncChange(tx, &note, models.SyncEntityNote, noteID, models.SyncActionDelete)
+}
+
+// EncodeSyncCursor turns a change sequence number into an opaque cursor
+func EncodeSyncCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncCursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// DecodeSyncCursor returns the sequence number behind a cursor. An empty cursor
+// means "from the beginning".
+func DecodeSyncCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), syncCursorPrefix) {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), syncCursorPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
+
+// SyncEntityChange is the latest state of one entity in a page of the change feed.
+// Data holds the entity for creates and updates and is empty for deletes.
+type SyncEntityChange struct {
+	Seq        int64       `json:"seq"`
+	EntityType string      `json:"entity_type"`
+	EntityID   uuid.UUID   `json:"entity_id"`
+	Action     string      `json:"action"`
+	Data       interface{} `json:"data,omitempty"`
+	ChangedAt  time.Time   `json:"changed_at"`
+}
+
+// SyncChangeSet is a page of the change feed
+type SyncChangeSet struct {
+	Changes []SyncEntityChange `json:"changes"`
+	Cursor  string             `json:"cursor"`
+	HasMore bool               `json:"has_more"`
+}
+
+// SyncMutation is a change made offline. Notes are checked against BaseVersion;
+// people, todos and connections against BaseUpdatedAt. Data carries the entity
+// fields for creates and the changed fields for updates.
+type SyncMutation struct {
+	ClientID      string          `json:"client_id"`
+	EntityType    string          `json:"entity_type"`
+	Action        string          `json:"action"`
+	EntityID      uuid.UUID       `json:"entity_id"`
+	BaseVersion   *int            `json:"base_version,omitempty"`
+	BaseUpdatedAt *time.Time      `json:"base_updated_at,omitempty"`
+	Data          json.RawMessage `json:"data,omitempty"`
+}
+
+// SyncPushResult reports what happened to one pushed mutation. Current is the
+// server's copy of the entity after an accepted change or on conflict.
+type SyncPushResult struct {
+	ClientID   string      `json:"client_id,omitempty"`
+	EntityType string      `json:"entity_type"`
+	EntityID   uuid.UUID   `json:"entity_id"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	ConflictID *uuid.UUID  `json:"conflict_id,omitempty"`
+	Current    interface{} `json:"current,omitempty"`
+}
+
+// SyncService serves the change feed and applies offline mutations
+type SyncService struct {
+	db                *gorm.DB
+	workspaceID       *uuid.UUID
+	revisions         *RevisionService
+	conflicts         *ConflictService
+	shares            *ShareService
+	todoService       *TodoService
+	connectionService *ConnectionService
+}
+
+// NewSyncService creates a new sync service
+func NewSyncService(db *gorm.DB) *SyncService {
+	return &SyncService{
+		db:                db,
+		revisions:         NewRevisionService(db),
+		conflicts:         NewConflictService(db),
+		shares:            NewShareService(db),
+		todoService:       NewTodoService(db),
+		connectionService: NewConnectionService(db),
+	}
+}
+
+// InWorkspace returns a copy of the service that syncs a workspace, or the
+// user's personal space when workspaceID is nil. The change feed is shared by
+// all of a user's spaces, so clients keep a cursor per space.
+func (s *SyncService) InWorkspace(workspaceID *uuid.UUID) *SyncService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	scoped.todoService = s.todoService.InWorkspace(workspaceID)
+	scoped.connectionService = s.connectionService.InWorkspace(workspaceID)
+	return &scoped
+}
+
+// GetChanges returns the entities that changed after cursor, oldest first. Several
+// changes to the same entity within a page are collapsed into its latest state.
+func (s *SyncService) GetChanges(userID uuid.UUID, cursor string, limit int) (*SyncChangeSet, error) {
+	since, err := DecodeSyncCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultSyncPageSize
+	}
+	if limit > maxSyncPageSize {
+		limit = maxSyncPageSize
+	}
+
+	var entries []models.SyncChange
+	if err := s.db.Where("user_id = ? AND seq > ?", userID, since).
+		Order("seq ASC").
+		Limit(limit + 1).
+		Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch changes: %w", err)
+	}
+
+	result := &SyncChangeSet{Changes: []SyncEntityChange{}, Cursor: EncodeSyncCursor(since)}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		result.HasMore = true
+	}
+	if len(entries) == 0 {
+		return result, nil
+	}
+	result.Cursor = EncodeSyncCursor(entries[len(entries)-1].Seq)
+
+	latest := make(map[string]*SyncEntityChange)
+	for _, entry := range entries {
+		key := entry.EntityType + ":" + entry.EntityID.String()
+		change, exists := latest[key]
+		if !exists {
+			latest[key] = &SyncEntityChange{
+				Seq:        entry.Seq,
+				EntityType: entry.EntityType,
+				EntityID:   entry.EntityID,
+				Action:     entry.Action,
+				ChangedAt:  entry.CreatedAt,
+			}
+			continue
+		}
+		// An entity created and then updated within the page is still new to the client
+		if !(change.Action == models.SyncActionCreate && entry.Action == models.SyncActionUpdate) {
+			change.Action = entry.Action
+		}
+		change.Seq = entry.Seq
+		change.ChangedAt = entry.CreatedAt
+	}
+
+	if err := s.loadChangedEntities(userID, latest); err != nil {
+		return nil, err
+	}
+
+	for _, change := range latest {
+		result.Changes = append(result.Changes, *change)
+	}
+	sort.Slice(result.Changes, func(i, j int) bool {
+		return result.Changes[i].Seq < result.Changes[j].Seq
+	})
+
+	return result, nil
+}
+
+// loadChangedEntities fills in the current state of every created or updated
+// entity in the active space. Entities the user can no longer see are reported
+// as deleted, and those that live in another of the user's spaces are left out
+// for the client syncing that space.
+func (s *SyncService) loadChangedEntities(userID uuid.UUID, changes map[string]*SyncEntityChange) error {
+	ids := make(map[string][]uuid.UUID)
+	for _, change := range changes {
+		if change.Action != models.SyncActionDelete {
+			ids[change.EntityType] = append(ids[change.EntityType], change.EntityID)
+		}
+	}
+
+	found := make(map[string]interface{})
+	visible := make(map[string]bool)
+	markVisible := func(entityType string, query *gorm.DB, column string) error {
+		var visibleIDs []uuid.UUID
+		if err := query.Pluck(column, &visibleIDs).Error; err != nil {
+			return fmt.Errorf("failed to fetch %s access: %w", entityType, err)
+		}
+		for _, id := range visibleIDs {
+			visible[entityType+":"+id.String()] = true
+		}
+		return nil
+	}
+
+	if len(ids[models.SyncEntityNote]) > 0 {
+		var notes []models.Note
+		if err := s.db.Scopes(AccessibleNotes(userID, s.workspaceID)).
+			Where("notes.id IN ?", ids[models.SyncEntityNote]).Find(&notes).Error; err != nil {
+			return fmt.Errorf("failed to fetch notes: %w", err)
+		}
+		for _, note := range notes {
+			found[models.SyncEntityNote+":"+note.ID.String()] = note
+		}
+		if err := markVisible(models.SyncEntityNote, s.db.Model(&models.Note{}).Scopes(visibleAnywhere("notes", userID)).
+			Where("notes.id IN ?", ids[models.SyncEntityNote]), "notes.id"); err != nil {
+			return err
+		}
+	}
+	if len(ids[models.SyncEntityPerson]) > 0 {
+		var people []models.Person
+		if err := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).
+			Where("id IN ?", ids[models.SyncEntityPerson]).Find(&people).Error; err != nil {
+			return fmt.Errorf("failed to fetch people: %w", err)
+		}
+		for _, person := range people {
+			found[models.SyncEntityPerson+":"+person.ID.String()] = person
+		}
+		if err := markVisible(models.SyncEntityPerson, s.db.Model(&models.Person{}).Scopes(visibleAnywhere("people", userID)).
+			Where("people.id IN ?", ids[models.SyncEntityPerson]), "people.id"); err != nil {
+			return err
+		}
+	}
+	if len(ids[models.SyncEntityTodo]) > 0 {
+		var todos []models.Todo
+		if err := s.db.Joins("JOIN notes ON notes.id = todos.note_id").
+			Scopes(AccessibleNotes(userID, s.workspaceID)).
+			Where("todos.id IN ?", ids[models.SyncEntityTodo]).
+			Find(&todos).Error; err != nil {
+			return fmt.Errorf("failed to fetch todos: %w", err)
+		}
+		for _, todo := range todos {
+			found[models.SyncEntityTodo+":"+todo.ID.String()] = todo
+		}
+		if err := markVisible(models.SyncEntityTodo, s.db.Model(&models.Todo{}).
+			Joins("JOIN notes ON notes.id = todos.note_id").
+			Scopes(visibleAnywhere("notes", userID)).
+			Where("todos.id IN ?", ids[models.SyncEntityTodo]), "todos.id"); err != nil {
+			return err
+		}
+	}
+	if len(ids[models.SyncEntityConnection]) > 0 {
+		var connections []models.Connection
+		if err := s.db.Scopes(OwnedBy("connections", userID, s.workspaceID)).
+			Where("id IN ?", ids[models.SyncEntityConnection]).Find(&connections).Error; err != nil {
+			return fmt.Errorf("failed to fetch connections: %w", err)
+		}
+		for _, connection := range connections {
+			found[models.SyncEntityConnection+":"+connection.ID.String()] = connection
+		}
+		if err := markVisible(models.SyncEntityConnection, s.db.Model(&models.Connection{}).Scopes(visibleAnywhere("connections", userID)).
+			Where("connections.id IN ?", ids[models.SyncEntityConnection]), "connections.id"); err != nil {
+			return err
+		}
+	}
+
+	for key, change := range changes {
+		if change.Action == models.SyncActionDelete {
+			continue
+		}
+		if entity, ok := found[key]; ok {
+			change.Data = entity
+		} else if visible[key] {
+			delete(changes, key)
+		} else {
+			change.Action = models.SyncActionDelete
+		}
+	}
+	return nil
+}
+
+// visibleAnywhere scopes a query on table to the rows the user can see in any
+// of their spaces: their personal rows, those of every workspace they belong
+// to and, for notes, those shared with them
+func visibleAnywhere(table string, userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		condition := "(" + table + ".user_id = ? AND " + table + ".workspace_id IS NULL) OR " +
+			table + ".workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)"
+		args := []interface{}{userID, userID}
+		if table == "notes" {
+			condition += " OR notes.id IN (SELECT note_id FROM note_shares WHERE user_id = ?)"
+			args = append(args, userID)
+		}
+		return db.Where("("+condition+")", args...)
+	}
+}
+
+// authorizeNote checks the user holds at least the required role on a note of
+// the active space
+func (s *SyncService) authorizeNote(userID, noteID uuid.UUID, required string) (*models.Note, string, error) {
+	var count int64
+	if err := s.db.Model(&models.Note{}).Scopes(AccessibleNotes(userID, s.workspaceID)).
+		Where("notes.id = ?", noteID).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count == 0 {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	return s.shares.AuthorizeNote(userID, noteID, required)
+}
+
+// Push applies a batch of offline mutations in order. Each mutation succeeds or
+// fails on its own; a conflict or rejection does not stop the rest of the batch.
+func (s *SyncService) Push(userID uuid.UUID, mutations []SyncMutation) []SyncPushResult {
+	results := make([]SyncPushResult, len(mutations))
+	for i := range mutations {
+		mutation := &mutations[i]
+		result := SyncPushResult{
+			ClientID:   mutation.ClientID,
+			EntityType: mutation.EntityType,
+			EntityID:   mutation.EntityID,
+		}
+
+		current, conflictID, err := s.applyMutation(userID, mutation)
+		switch {
+		case err == nil:
+			result.Status = SyncStatusAccepted
+		case errors.Is(err, errStaleVersion):
+			result.Status = SyncStatusConflict
+			result.ConflictID = conflictID
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			result.Status = SyncStatusRejected
+			result.Error = mutation.EntityType + " not found"
+		default:
+			result.Status = SyncStatusRejected
+			result.Error = err.Error()
+		}
+		// Creates fill in the ID of the new entity
+		result.EntityID = mutation.EntityID
+		result.Current = current
+
+		results[i] = result
+	}
+	return results
+}
+
+func (s *SyncService) applyMutation(userID uuid.UUID, mutation *SyncMutation) (interface{}, *uuid.UUID, error) {
+	switch mutation.Action {
+	case models.SyncActionCreate, models.SyncActionUpdate, models.SyncActionDelete:
+	default:
+		return nil, nil, errors.New("action must be one of: create, update, delete")
+	}
+	if mutation.Action != models.SyncActionCreate && mutation.EntityID == uuid.Nil {
+		return nil, nil, errors.New("entity_id is required")
+	}
+
+	switch mutation.EntityType {
+	case models.SyncEntityNote:
+		return s.applyNoteMutation(userID, mutation)
+	case models.SyncEntityPerson:
+		current, err := s.applyPersonMutation(userID, mutation)
+		return current, nil, err
+	case models.SyncEntityTodo:
+		current, err := s.applyTodoMutation(userID, mutation)
+		return current, nil, err
+	case models.SyncEntityConnection:
+		current, err := s.applyConnectionMutation(userID, mutation)
+		return current, nil, err
+	default:
+		return nil, nil, errors.New("entity_type must be one of: note, person, todo, connection")
+	}
+}
+
+// syncNoteData holds the note fields a mutation may set
+type syncNoteData struct {
+	Title         *string      `json:"title"`
+	Content       models.JSONB `json:"content"`
+	Category      *string      `json:"category"`
+	Tags          []string     `json:"tags"`
+	FolderPath    *string      `json:"folder_path"`
+	ScheduledDate *time.Time   `json:"scheduled_date"`
+	IsArchived    *bool        `json:"is_archived"`
+	IsPinned      *bool        `json:"is_pinned"`
+	IsFavorite    *bool        `json:"is_favorite"`
+}
+
+func (d *syncNoteData) apply(note *models.Note, fields map[string]json.RawMessage) {
+	if d.Title != nil {
+		note.Title = *d.Title
+	}
+	if d.Content != nil {
+		note.Content = d.Content
+	}
+	if d.Category != nil {
+		note.Category = *d.Category
+	}
+	if d.Tags != nil {
+		note.Tags = pq.StringArray(d.Tags)
+	}
+	if d.FolderPath != nil {
+		note.FolderPath = *d.FolderPath
+	}
+	if d.ScheduledDate != nil || isNull(fields, "scheduled_date") {
+		note.ScheduledDate = d.ScheduledDate
+	}
+	if d.IsArchived != nil {
+		note.IsArchived = *d.IsArchived
+	}
+	if d.IsPinned != nil {
+		note.IsPinned = *d.IsPinned
+	}
+	if d.IsFavorite != nil {
+		note.IsFavorite = *d.IsFavorite
+	}
+}
+
+func (s *SyncService) applyNoteMutation(userID uuid.UUID, mutation *SyncMutation) (interface{}, *uuid.UUID, error) {
+	var data syncNoteData
+	fields, err := decodeMutationData(mutation.Data, &data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mutation.Action == models.SyncActionCreate {
+		if existing, replayed, err := s.findReplayedCreate(userID, mutation, &models.Note{}); err != nil || replayed {
+			return existing, nil, err
+		}
+
+		note := models.Note{ID: mutation.EntityID, UserID: userID, WorkspaceID: s.workspaceID, Category: "Note", FolderPath: "/"}
+		data.apply(&note, fields)
+		if err := note.Validate(); err != nil {
+			return nil, nil, err
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&note).Error; err != nil {
+				return err
+			}
+			if err := s.revisions.RecordRevision(tx, &note, userID, "Created"); err != nil {
+				return err
+			}
+			return RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionCreate)
+		}); err != nil {
+			return nil, nil, fmt.Errorf("failed to create note: %w", err)
+		}
+		mutation.EntityID = note.ID
+		s.updateNoteConnections(&note)
+		return note, nil, nil
+	}
+
+	notePtr, role, err := s.authorizeNote(userID, mutation.EntityID, models.NoteRoleEditor)
+	if err != nil {
+		return nil, nil, err
+	}
+	note := *notePtr
+	// Where a note lives and how it is flagged belongs to its owner
+	if role != models.NoteRoleOwner &&
+		(data.FolderPath != nil || data.IsArchived != nil || data.IsPinned != nil || data.IsFavorite != nil) {
+		return nil, nil, ErrNoteAccessDenied
+	}
+	if mutation.BaseVersion == nil {
+		return nil, nil, errors.New("base_version is required")
+	}
+
+	if *mutation.BaseVersion != note.Version {
+		// Record content conflicts so they can be resolved like real-time ones
+		if mutation.Action == models.SyncActionUpdate && data.Content != nil {
+			conflict, err := s.conflicts.CreateConflict(&note, userID, *mutation.BaseVersion, data.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+			return note, &conflict.ID, errStaleVersion
+		}
+		return note, nil, errStaleVersion
+	}
+
+	if mutation.Action == models.SyncActionDelete {
+		deleted, err := DeleteNote(s.db, userID, note.WorkspaceID, note.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to delete note: %w", err)
+		}
+		if !deleted {
+			return nil, nil, gorm.ErrRecordNotFound
+		}
+		return nil, nil, nil
+	}
+
+	data.apply(&note, fields)
+	note.Version++
+	if err := note.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&note).Error; err != nil {
+			return err
+		}
+		if err := s.revisions.RecordRevision(tx, &note, userID, "Offline edit"); err != nil {
+			return err
+		}
+		return RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionUpdate)
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to update note: %w", err)
+	}
+	if data.Content != nil {
+		s.updateNoteConnections(&note)
+	}
+	return note, nil, nil
+}
+
+// updateNoteConnections refreshes the connections detected in a note's content
+func (s *SyncService) updateNoteConnections(note *models.Note) {
+	connections, err := s.connectionService.DetectConnections(note.UserID, note.ID, note.Content)
+	if err != nil {
+		return
+	}
+	if err := s.connectionService.UpdateConnections(note.UserID, note.ID, connections); err != nil {
+		log.Printf("Failed to update connections for note %s: %v", note.ID, err)
+	}
+}
+
+// syncPersonData holds the person fields a mutation may set
+type syncPersonData struct {
+	Name        *string `json:"name"`
+	Email       *string `json:"email"`
+	Phone       *string `json:"phone"`
+	Company     *string `json:"company"`
+	Title       *string `json:"title"`
+	LinkedinURL *string `json:"linkedin_url"`
+	AvatarURL   *string `json:"avatar_url"`
+	Notes       *string `json:"notes"`
+}
+
+func (d *syncPersonData) apply(person *models.Person) {
+	for field, value := range map[*string]*string{
+		&person.Name:        d.Name,
+		&person.Email:       d.Email,
+		&person.Phone:       d.Phone,
+		&person.Company:     d.Company,
+		&person.Title:       d.Title,
+		&person.LinkedinURL: d.LinkedinURL,
+		&person.AvatarURL:   d.AvatarURL,
+		&person.Notes:       d.Notes,
+	} {
+		if value != nil {
+			*field = *value
+		}
+	}
+}
+
+func (s *SyncService) applyPersonMutation(userID uuid.UUID, mutation *SyncMutation) (interface{}, error) {
+	var data syncPersonData
+	if _, err := decodeMutationData(mutation.Data, &data); err != nil {
+		return nil, err
+	}
+
+	if mutation.Action == models.SyncActionCreate {
+		if existing, replayed, err := s.findReplayedCreate(userID, mutation, &models.Person{}); err != nil || replayed {
+			return existing, err
+		}
+
+		person := models.Person{ID: mutation.EntityID, UserID: userID, WorkspaceID: s.workspaceID}
+		data.apply(&person)
+		if err := person.Validate(); err != nil {
+			return nil, err
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&person).Error; err != nil {
+				return err
+			}
+			return RecordSyncChange(tx, userID, models.SyncEntityPerson, person.ID, models.SyncActionCreate)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create person: %w", err)
+		}
+		mutation.EntityID = person.ID
+		return person, nil
+	}
+
+	var person models.Person
+	if err := s.db.Scopes(OwnedBy("people", userID, s.workspaceID)).Where("id = ?", mutation.EntityID).First(&person).Error; err != nil {
+		return nil, err
+	}
+	if err := checkBaseUpdatedAt(mutation, person.UpdatedAt); err != nil {
+		return person, err
+	}
+
+	if mutation.Action == models.SyncActionDelete {
+		return nil, s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&person).Error; err != nil {
+				return err
+			}
+			return RecordSyncChange(tx, userID, models.SyncEntityPerson, person.ID, models.SyncActionDelete)
+		})
+	}
+
+	data.apply(&person)
+	if err := person.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&person).Error; err != nil {
+			return err
+		}
+		return RecordSyncChange(tx, userID, models.SyncEntityPerson, person.ID, models.SyncActionUpdate)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update person: %w", err)
+	}
+	return person, nil
+}
+
+// syncTodoData holds the todo fields a mutation may set
+type syncTodoData struct {
+	NoteID           *uuid.UUID `json:"note_id"`
+	TodoID           *string    `json:"todo_id"`
+	Text             *string    `json:"text"`
+	IsCompleted      *bool      `json:"is_completed"`
+	AssignedPersonID *uuid.UUID `json:"assigned_person_id"`
+	DueDate          *time.Time `json:"due_date"`
+}
+
+func (d *syncTodoData) apply(todo *models.Todo, fields map[string]json.RawMessage) {
+	if d.Text != nil {
+		todo.Text = *d.Text
+	}
+	if d.IsCompleted != nil {
+		todo.IsCompleted = *d.IsCompleted
+	}
+	if d.AssignedPersonID != nil || isNull(fields, "assigned_person_id") {
+		todo.AssignedPersonID = d.AssignedPersonID
+	}
+	if d.DueDate != nil || isNull(fields, "due_date") {
+		todo.DueDate = d.DueDate
+	}
+}
+
+func (s *SyncService) applyTodoMutation(userID uuid.UUID, mutation *SyncMutation) (interface{}, error) {
+	var data syncTodoData
+	fields, err := decodeMutationData(mutation.Data, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	if mutation.Action == models.SyncActionCreate {
+		if existing, replayed, err := s.findReplayedCreate(userID, mutation, &models.Todo{}); err != nil || replayed {
+			return existing, err
+		}
+		if data.NoteID == nil {
+			return nil, errors.New("note_id is required")
+		}
+
+		note, _, err := s.authorizeNote(userID, *data.NoteID, models.NoteRoleEditor)
+		if err != nil {
+			return nil, err
+		}
+
+		todo := models.Todo{ID: mutation.EntityID, NoteID: note.ID}
+		if data.TodoID != nil && *data.TodoID != "" {
+			todo.TodoID = *data.TodoID
+		} else {
+			todoID, err := s.todoService.GenerateNextTodoID(note.ID)
+			if err != nil {
+				return nil, err
+			}
+			todo.TodoID = todoID
+		}
+		data.apply(&todo, fields)
+		if err := todo.Validate(); err != nil {
+			return nil, err
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&todo).Error; err != nil {
+				return err
+			}
+			return RecordNoteSyncChange(tx, note, models.SyncEntityTodo, todo.ID, models.SyncActionCreate)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create todo: %w", err)
+		}
+		mutation.EntityID = todo.ID
+		return todo, nil
+	}
+
+	var todo models.Todo
+	if err := s.db.Where("id = ?", mutation.EntityID).First(&todo).Error; err != nil {
+		return nil, err
+	}
+	note, _, err := s.authorizeNote(userID, todo.NoteID, models.NoteRoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBaseUpdatedAt(mutation, todo.UpdatedAt); err != nil {
+		return todo, err
+	}
+
+	if mutation.Action == models.SyncActionDelete {
+		return nil, s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&todo).Error; err != nil {
+				return err
+			}
+			return RecordNoteSyncChange(tx, note, models.SyncEntityTodo, todo.ID, models.SyncActionDelete)
+		})
+	}
+
+	data.apply(&todo, fields)
+	if err := todo.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&todo).Error; err != nil {
+			return err
+		}
+		return RecordNoteSyncChange(tx, note, models.SyncEntityTodo, todo.ID, models.SyncActionUpdate)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update todo: %w", err)
+	}
+	return todo, nil
+}
+
+// syncConnectionData holds the connection fields a mutation may set
+type syncConnectionData struct {
+	SourceID   *uuid.UUID `json:"source_id"`
+	SourceType *string    `json:"source_type"`
+	TargetID   *uuid.UUID `json:"target_id"`
+	TargetType *string    `json:"target_type"`
+	Strength   *int       `json:"strength"`
+}
+
+func (s *SyncService) applyConnectionMutation(userID uuid.UUID, mutation *SyncMutation) (interface{}, error) {
+	var data syncConnectionData
+	if _, err := decodeMutationData(mutation.Data, &data); err != nil {
+		return nil, err
+	}
+
+	if mutation.Action == models.SyncActionCreate {
+		if existing, replayed, err := s.findReplayedCreate(userID, mutation, &models.Connection{}); err != nil || replayed {
+			return existing, err
+		}
+		if data.SourceID == nil || data.SourceType == nil || data.TargetID == nil || data.TargetType == nil {
+			return nil, errors.New("source_id, source_type, target_id and target_type are required")
+		}
+		for _, nodeType := range []string{*data.SourceType, *data.TargetType} {
+			if nodeType != "note" && nodeType != "person" {
+				return nil, errors.New("connection endpoints must be of type note or person")
+			}
+		}
+
+		connection := models.Connection{
+			ID:          mutation.EntityID,
+			UserID:      userID,
+			WorkspaceID: s.workspaceID,
+			SourceID:    *data.SourceID,
+			SourceType:  *data.SourceType,
+			TargetID:    *data.TargetID,
+			TargetType:  *data.TargetType,
+			Strength:    1,
+		}
+		if data.Strength != nil {
+			connection.Strength = *data.Strength
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&connection).Error; err != nil {
+				return err
+			}
+			return RecordSyncChange(tx, userID, models.SyncEntityConnection, connection.ID, models.SyncActionCreate)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create connection: %w", err)
+		}
+		mutation.EntityID = connection.ID
+		return connection, nil
+	}
+
+	var connection models.Connection
+	if err := s.db.Scopes(OwnedBy("connections", userID, s.workspaceID)).Where("id = ?", mutation.EntityID).First(&connection).Error; err != nil {
+		return nil, err
+	}
+	if err := checkBaseUpdatedAt(mutation, connection.UpdatedAt); err != nil {
+		return connection, err
+	}
+
+	if mutation.Action == models.SyncActionDelete {
+		return nil, s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&connection).Error; err != nil {
+				return err
+			}
+			return RecordSyncChange(tx, userID, models.SyncEntityConnection, connection.ID, models.SyncActionDelete)
+		})
+	}
+
+	if data.Strength != nil {
+		connection.Strength = *data.Strength
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&connection).Error; err != nil {
+			return err
+		}
+		return RecordSyncChange(tx, userID, models.SyncEntityConnection, connection.ID, models.SyncActionUpdate)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update connection: %w", err)
+	}
+	return connection, nil
+}
+
+// findReplayedCreate treats a create for an entity that already exists as a retry
+// of a push whose response was lost, and returns the stored entity. Entity IDs
+// owned by someone else are rejected.
+func (s *SyncService) findReplayedCreate(userID uuid.UUID, mutation *SyncMutation, entity interface{}) (interface{}, bool, error) {
+	if mutation.EntityID == uuid.Nil {
+		return nil, false, nil
+	}
+
+	if err := s.db.Where("id = ?", mutation.EntityID).First(entity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var owner uuid.UUID
+	switch e := entity.(type) {
+	case *models.Note:
+		owner = e.UserID
+	case *models.Person:
+		owner = e.UserID
+	case *models.Connection:
+		owner = e.UserID
+	case *models.Todo:
+		var note models.Note
+		if err := s.db.Select("user_id").Where("id = ?", e.NoteID).First(&note).Error; err != nil {
+			return nil, false, err
+		}
+		owner = note.UserID
+	}
+	if owner != userID {
+		return nil, false, errors.New("entity_id is already in use")
+	}
+
+	return derefEntity(entity), true, nil
+}
+
+// checkBaseUpdatedAt rejects changes to entities modified after the client's copy
+func checkBaseUpdatedAt(mutation *SyncMutation, updatedAt time.Time) error {
+	if mutation.BaseUpdatedAt == nil {
+		if mutation.Action == models.SyncActionUpdate {
+			return errors.New("base_updated_at is required")
+		}
+		return nil
+	}
+	if updatedAt.After(*mutation.BaseUpdatedAt) {
+		return errStaleVersion
+	}
+	return nil
+}
+
+// decodeMutationData decodes mutation data into target and also returns the raw
+// fields, so an explicit null can be told apart from a field that was left out
+func decodeMutationData(data json.RawMessage, target interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(data) == 0 {
+		return fields, nil
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	return fields, nil
+}
+
+func isNull(fields map[string]json.RawMessage, key string) bool {
+	value, ok := fields[key]
+	return ok && string(value) == "null"
+}
+
+// derefEntity dereferences a pointer to one of the synced models
+func derefEntity(entity interface{}) interface{} {
+	switch e := entity.(type) {
+	case *models.Note:
+		return *e
+	case *models.Person:
+		return *e
+	case *models.Todo:
+		return *e
+	case *models.Connection:
+		return *e
+	}
+	return entity
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mutationData(t *testing.T, data map[string]interface{}) json.RawMessage {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+	return raw
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestSyncCursor(t *testing.T) {
+	seq, err := DecodeSyncCursor("")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), seq)
+
+	seq, err = DecodeSyncCursor(EncodeSyncCursor(42))
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), seq)
+
+	for _, cursor := range []string{"42", "!!!", EncodeSyncCursor(-1)[:2]} {
+		_, err := DecodeSyncCursor(cursor)
+		assert.ErrorIs(t, err, ErrInvalidCursor, cursor)
+	}
+}
+
+func TestSyncService_GetChanges(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	service := NewSyncService(db)
+
+	note := createTestNote(t, db, user.ID)
+	require.NoError(t, RecordSyncChange(db, user.ID, models.SyncEntityNote, note.ID, models.SyncActionCreate))
+	require.NoError(t, RecordSyncChange(db, user.ID, models.SyncEntityNote, note.ID, models.SyncActionUpdate))
+
+	person := models.Person{UserID: user.ID, Name: "Ada"}
+	require.NoError(t, db.Create(&person).Error)
+	require.NoError(t, RecordSyncChange(db, user.ID, models.SyncEntityPerson, person.ID, models.SyncActionCreate))
+
+	// Someone else's changes never appear
+	otherNote := createTestNote(t, db, other.ID)
+	require.NoError(t, RecordSyncChange(db, other.ID, models.SyncEntityNote, otherNote.ID, models.SyncActionCreate))
+
+	changes, err := service.GetChanges(user.ID, "", 0)
+	require.NoError(t, err)
+	assert.False(t, changes.HasMore)
+	require.Len(t, changes.Changes, 2)
+
+	// Create followed by update collapses into a single create with the latest data
+	assert.Equal(t, models.SyncEntityNote, changes.Changes[0].EntityType)
+	assert.Equal(t, models.SyncActionCreate, changes.Changes[0].Action)
+	assert.Equal(t, note.ID, changes.Changes[0].Data.(models.Note).ID)
+	assert.Equal(t, models.SyncEntityPerson, changes.Changes[1].EntityType)
+
+	// Nothing new since the returned cursor
+	cursor := changes.Cursor
+	changes, err = service.GetChanges(user.ID, cursor, 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Changes)
+	assert.Equal(t, cursor, changes.Cursor)
+
+	// Deletes carry no data
+	require.NoError(t, db.Delete(&person).Error)
+	require.NoError(t, RecordSyncChange(db, user.ID, models.SyncEntityPerson, person.ID, models.SyncActionDelete))
+	changes, err = service.GetChanges(user.ID, cursor, 0)
+	require.NoError(t, err)
+	require.Len(t, changes.Changes, 1)
+	assert.Equal(t, models.SyncActionDelete, changes.Changes[0].Action)
+	assert.Nil(t, changes.Changes[0].Data)
+
+	// Paging
+	changes, err = service.GetChanges(user.ID, "", 1)
+	require.NoError(t, err)
+	assert.True(t, changes.HasMore)
+	require.Len(t, changes.Changes, 1)
+	assert.Equal(t, note.ID, changes.Changes[0].EntityID)
+
+	// An entity that no longer exists is reported as deleted, even for its create
+	changes, err = service.GetChanges(user.ID, changes.Cursor, 2)
+	require.NoError(t, err)
+	assert.True(t, changes.HasMore)
+	require.Len(t, changes.Changes, 2)
+	assert.Equal(t, models.SyncActionUpdate, changes.Changes[0].Action)
+	assert.Equal(t, person.ID, changes.Changes[1].EntityID)
+	assert.Equal(t, models.SyncActionDelete, changes.Changes[1].Action)
+
+	_, err = service.GetChanges(user.ID, "not-a-cursor", 0)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestSyncService_Push(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	service := NewSyncService(db)
+
+	noteID := uuid.New()
+	todoEntityID := uuid.New()
+	results := service.Push(user.ID, []SyncMutation{
+		{
+			ClientID:   "1",
+			EntityType: models.SyncEntityNote,
+			Action:     models.SyncActionCreate,
+			EntityID:   noteID,
+			Data:       mutationData(t, map[string]interface{}{"title": "Written offline", "content": doc(paragraph("a"))}),
+		},
+		{
+			ClientID:   "2",
+			EntityType: models.SyncEntityTodo,
+			Action:     models.SyncActionCreate,
+			EntityID:   todoEntityID,
+			Data:       mutationData(t, map[string]interface{}{"note_id": noteID, "text": "Follow up"}),
+		},
+		{
+			ClientID:   "3",
+			EntityType: models.SyncEntityPerson,
+			Action:     models.SyncActionCreate,
+			Data:       mutationData(t, map[string]interface{}{"name": "Grace"}),
+		},
+		{
+			ClientID:   "4",
+			EntityType: models.SyncEntityPerson,
+			Action:     models.SyncActionCreate,
+			Data:       mutationData(t, map[string]interface{}{"email": "nameless@example.com"}),
+		},
+	})
+	require.Len(t, results, 4)
+	assert.Equal(t, SyncStatusAccepted, results[0].Status)
+	assert.Equal(t, noteID, results[0].EntityID)
+	assert.Equal(t, SyncStatusAccepted, results[1].Status, results[1].Error)
+	assert.Equal(t, "t1", results[1].Current.(models.Todo).TodoID)
+	assert.Equal(t, SyncStatusAccepted, results[2].Status)
+	assert.NotEqual(t, uuid.Nil, results[2].EntityID)
+	assert.Equal(t, SyncStatusRejected, results[3].Status)
+	assert.Equal(t, "name is required", results[3].Error)
+
+	// Retrying a create whose response was lost is accepted without duplicating
+	results = service.Push(user.ID, []SyncMutation{{
+		EntityType: models.SyncEntityNote,
+		Action:     models.SyncActionCreate,
+		EntityID:   noteID,
+		Data:       mutationData(t, map[string]interface{}{"title": "Written offline"}),
+	}})
+	assert.Equal(t, SyncStatusAccepted, results[0].Status)
+	var count int64
+	db.Model(&models.Note{}).Where("id = ?", noteID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// ...but another user cannot claim the ID
+	results = service.Push(other.ID, []SyncMutation{{
+		EntityType: models.SyncEntityNote,
+		Action:     models.SyncActionCreate,
+		EntityID:   noteID,
+		Data:       mutationData(t, map[string]interface{}{"title": "Mine now"}),
+	}})
+	assert.Equal(t, SyncStatusRejected, results[0].Status)
+
+	// Version checks
+	results = service.Push(user.ID, []SyncMutation{
+		{
+			EntityType:  models.SyncEntityNote,
+			Action:      models.SyncActionUpdate,
+			EntityID:    noteID,
+			BaseVersion: intPtr(1),
+			Data:        mutationData(t, map[string]interface{}{"content": doc(paragraph("b"))}),
+		},
+		{
+			EntityType:  models.SyncEntityNote,
+			Action:      models.SyncActionUpdate,
+			EntityID:    noteID,
+			BaseVersion: intPtr(1),
+			Data:        mutationData(t, map[string]interface{}{"content": doc(paragraph("c"))}),
+		},
+		{
+			EntityType: models.SyncEntityNote,
+			Action:     models.SyncActionUpdate,
+			EntityID:   noteID,
+			Data:       mutationData(t, map[string]interface{}{"title": "No base"}),
+		},
+		{
+			EntityType:  models.SyncEntityNote,
+			Action:      models.SyncActionUpdate,
+			EntityID:    uuid.New(),
+			BaseVersion: intPtr(1),
+		},
+	})
+	assert.Equal(t, SyncStatusAccepted, results[0].Status)
+	assert.Equal(t, 2, results[0].Current.(models.Note).Version)
+	assert.Equal(t, SyncStatusConflict, results[1].Status)
+	require.NotNil(t, results[1].ConflictID)
+	assert.Equal(t, 2, results[1].Current.(models.Note).Version)
+	assert.Equal(t, SyncStatusRejected, results[2].Status)
+	assert.Equal(t, "base_version is required", results[2].Error)
+	assert.Equal(t, SyncStatusRejected, results[3].Status)
+	assert.Equal(t, "note not found", results[3].Error)
+
+	// The conflict can be resolved like a real-time one
+	open, err := NewConflictService(db).ListConflicts(user.ID, &noteID, models.ConflictStatusOpen)
+	require.NoError(t, err)
+	require.Len(t, open, 1)
+	assert.Equal(t, *results[1].ConflictID, open[0].ID)
+
+	var todo models.Todo
+	require.NoError(t, db.First(&todo, "id = ?", todoEntityID).Error)
+	stale := todo.UpdatedAt.Add(-time.Minute)
+	results = service.Push(user.ID, []SyncMutation{
+		{
+			EntityType:    models.SyncEntityTodo,
+			Action:        models.SyncActionUpdate,
+			EntityID:      todo.ID,
+			BaseUpdatedAt: &stale,
+			Data:          mutationData(t, map[string]interface{}{"is_completed": true}),
+		},
+		{
+			EntityType:    models.SyncEntityTodo,
+			Action:        models.SyncActionUpdate,
+			EntityID:      todo.ID,
+			BaseUpdatedAt: &todo.UpdatedAt,
+			Data:          mutationData(t, map[string]interface{}{"is_completed": true, "due_date": nil}),
+		},
+	})
+	assert.Equal(t, SyncStatusConflict, results[0].Status)
+	assert.Nil(t, results[0].ConflictID)
+	assert.Equal(t, SyncStatusAccepted, results[1].Status)
+	assert.True(t, results[1].Current.(models.Todo).IsCompleted)
+
+	// Deleting a note also removes its todos from the feed
+	note := results[1].Current.(models.Todo).NoteID
+	var current models.Note
+	require.NoError(t, db.First(&current, "id = ?", note).Error)
+	before, err := service.GetChanges(user.ID, "", 0)
+	require.NoError(t, err)
+
+	results = service.Push(user.ID, []SyncMutation{{
+		EntityType:  models.SyncEntityNote,
+		Action:      models.SyncActionDelete,
+		EntityID:    note,
+		BaseVersion: &current.Version,
+	}})
+	assert.Equal(t, SyncStatusAccepted, results[0].Status)
+
+	changes, err := service.GetChanges(user.ID, before.Cursor, 0)
+	require.NoError(t, err)
+	deleted := map[string]bool{}
+	for _, change := range changes.Changes {
+		if change.Action == models.SyncActionDelete {
+			deleted[change.EntityType] = true
+		}
+	}
+	assert.True(t, deleted[models.SyncEntityNote])
+	assert.True(t, deleted[models.SyncEntityTodo])
+
+	results = service.Push(user.ID, []SyncMutation{{EntityType: "attachment", Action: models.SyncActionCreate}})
+	assert.Equal(t, SyncStatusRejected, results[0].Status)
+}
+
+func TestSyncService_Workspace(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	member := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	stranger := createTestUser(t, db)
+	workspaces := NewWorkspaceService(db)
+	workspace, err := workspaces.CreateWorkspace(owner.ID, "Team", "")
+	require.NoError(t, err)
+	_, err = workspaces.InviteMember(owner.ID, workspace.ID, member.ID, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+	_, err = workspaces.InviteMember(owner.ID, workspace.ID, viewer.ID, models.WorkspaceRoleViewer)
+	require.NoError(t, err)
+
+	personal := NewSyncService(db)
+	team := personal.InWorkspace(&workspace.ID)
+
+	ownNote := createTestNote(t, db, owner.ID)
+	require.NoError(t, RecordNoteSyncChange(db, &ownNote, models.SyncEntityNote, ownNote.ID, models.SyncActionCreate))
+
+	// A note written offline in the workspace reaches every member's feed
+	noteID := uuid.New()
+	results := team.Push(member.ID, []SyncMutation{{
+		EntityType: models.SyncEntityNote,
+		Action:     models.SyncActionCreate,
+		EntityID:   noteID,
+		Data:       mutationData(t, map[string]interface{}{"title": "Team plan"}),
+	}})
+	require.Equal(t, SyncStatusAccepted, results[0].Status, results[0].Error)
+	assert.Equal(t, workspace.ID, *results[0].Current.(models.Note).WorkspaceID)
+
+	for _, user := range []models.User{owner, member, viewer} {
+		changes, err := team.GetChanges(user.ID, "", 0)
+		require.NoError(t, err)
+		require.Len(t, changes.Changes, 1, user.Username)
+		assert.Equal(t, noteID, changes.Changes[0].EntityID)
+		assert.Equal(t, models.SyncActionCreate, changes.Changes[0].Action)
+	}
+	changes, err := team.GetChanges(stranger.ID, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Changes)
+
+	// Each space only returns its own entities, without reporting the other
+	// space's as deleted
+	changes, err = personal.GetChanges(owner.ID, "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes.Changes, 1)
+	assert.Equal(t, ownNote.ID, changes.Changes[0].EntityID)
+	assert.Equal(t, models.SyncActionCreate, changes.Changes[0].Action)
+
+	update := func(userID uuid.UUID, service *SyncService) SyncPushResult {
+		return service.Push(userID, []SyncMutation{{
+			EntityType:  models.SyncEntityNote,
+			Action:      models.SyncActionUpdate,
+			EntityID:    noteID,
+			BaseVersion: intPtr(1),
+			Data:        mutationData(t, map[string]interface{}{"title": "Changed"}),
+		}})[0]
+	}
+
+	// Viewers cannot edit, outsiders and other spaces cannot see the note
+	result := update(viewer.ID, team)
+	assert.Equal(t, SyncStatusRejected, result.Status)
+	assert.Equal(t, ErrNoteAccessDenied.Error(), result.Error)
+	result = update(stranger.ID, team)
+	assert.Equal(t, "note not found", result.Error)
+	result = update(member.ID, personal)
+	assert.Equal(t, "note not found", result.Error)
+
+	result = update(owner.ID, team)
+	assert.Equal(t, SyncStatusAccepted, result.Status, result.Error)
+}
@@ -15,7 +15,8 @@ import (
 
 // TodoService handles todo parsing, scanning, and management
 type TodoService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	workspaceID *uuid.UUID
 }
 
 // NewTodoService creates a new todo service
@@ -23,6 +24,14 @@ func NewTodoService(db *gorm.DB) *TodoService {
 	return &TodoService{db: db}
 }
 
+// InWorkspace returns a copy of the service that works on the todos of a
+// workspace's notes, or of the user's personal notes when workspaceID is nil
+func (s *TodoService) InWorkspace(workspaceID *uuid.UUID) *TodoService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
 // TodoParseResult represents a parsed todo from note content
 type TodoParseResult struct {
 	TodoID           string
@@ -100,16 +109,17 @@ func (s *TodoService) ScanNoteForTodos(noteContent models.JSONB) TodoScanResult
 func (s *TodoService) SyncNoteTodos(noteID uuid.UUID, userID uuid.UUID) error {
 	// Get the note
 	var note models.Note
-	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+	if err := s.db.Scopes(OwnedBy("notes", userID, s.workspaceID)).Where("id = ?", noteID).First(&note).Error; err != nil {
 		return fmt.Errorf("note not found: %w", err)
 	}
 	
 	// Scan note content for todos
 	scanResult := s.ScanNoteForTodos(note.Content)
 	
-	// Get existing todos for this note
+	// Get existing todos for this note, including those in the trash, since
+	// todo IDs stay unique within a note
 	var existingTodos []models.Todo
-	if err := s.db.Where("note_id = ?", noteID).Find(&existingTodos).Error; err != nil {
+	if err := s.db.Unscoped().Where("note_id = ?", noteID).Find(&existingTodos).Error; err != nil {
 		return fmt.Errorf("failed to fetch existing todos: %w", err)
 	}
 	
@@ -127,13 +137,24 @@ func (s *TodoService) SyncNoteTodos(noteID uuid.UUID, userID uuid.UUID) error {
 		seenTodoIDs[parsed.TodoID] = true
 		
 		if existingTodo, exists := existingTodoMap[parsed.TodoID]; exists {
-			// Update existing todo
+			// Update existing todo, bringing it back from the trash if it is
+			// still written in the note
+			action := models.SyncActionUpdate
+			if existingTodo.DeletedAt.Valid {
+				existingTodo.DeletedAt = gorm.DeletedAt{}
+				action = models.SyncActionCreate
+			}
 			existingTodo.Text = parsed.Text
 			existingTodo.IsCompleted = parsed.IsCompleted
 			existingTodo.AssignedPersonID = parsed.AssignedPersonID
 			existingTodo.DueDate = parsed.DueDate
 			
-			if err := s.db.Save(existingTodo).Error; err != nil {
+			if err := s.db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Unscoped().Save(existingTodo).Error; err != nil {
+					return err
+				}
+				return RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, existingTodo.ID, action)
+			}); err != nil {
 				return fmt.Errorf("failed to update todo %s: %w", parsed.TodoID, err)
 			}
 		} else {
@@ -147,16 +168,30 @@ func (s *TodoService) SyncNoteTodos(noteID uuid.UUID, userID uuid.UUID) error {
 				DueDate:          parsed.DueDate,
 			}
 			
-			if err := s.db.Create(&newTodo).Error; err != nil {
+			if err := s.db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&newTodo).Error; err != nil {
+					return err
+				}
+				return RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, newTodo.ID, models.SyncActionCreate)
+			}); err != nil {
 				return fmt.Errorf("failed to create todo %s: %w", parsed.TodoID, err)
 			}
 		}
 	}
 	
-	// Remove todos that are no longer in the note content
+	// Remove todos that are no longer in the note content. The note's revision
+	// history already keeps them, so they skip the trash.
 	for todoID, existingTodo := range existingTodoMap {
 		if !seenTodoIDs[todoID] {
-			if err := s.db.Delete(existingTodo).Error; err != nil {
+			if existingTodo.DeletedAt.Valid {
+				continue
+			}
+			if err := s.db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Unscoped().Delete(existingTodo).Error; err != nil {
+					return err
+				}
+				return RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, existingTodo.ID, models.SyncActionDelete)
+			}); err != nil {
 				return fmt.Errorf("failed to delete todo %s: %w", todoID, err)
 			}
 		}
@@ -168,7 +203,7 @@ func (s *TodoService) SyncNoteTodos(noteID uuid.UUID, userID uuid.UUID) error {
 // GenerateNextTodoID generates the next available todo ID for a note
 func (s *TodoService) GenerateNextTodoID(noteID uuid.UUID) (string, error) {
 	var todos []models.Todo
-	if err := s.db.Where("note_id = ?", noteID).Find(&todos).Error; err != nil {
+	if err := s.db.Unscoped().Where("note_id = ?", noteID).Find(&todos).Error; err != nil {
 		return "", fmt.Errorf("failed to fetch existing todos: %w", err)
 	}
 	
@@ -187,7 +222,7 @@ func (s *TodoService) GenerateNextTodoID(noteID uuid.UUID) (string, error) {
 // GetTodosWithFilters retrieves todos with various filtering options
 func (s *TodoService) GetTodosWithFilters(userID uuid.UUID, filters TodoFilters) ([]models.Todo, error) {
 	query := s.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("notes.user_id = ?", userID).
+		Scopes(OwnedBy("notes", userID, s.workspaceID)).
 		Preload("Note").
 		Preload("AssignedPerson")
 	
@@ -242,7 +277,8 @@ func (s *TodoService) GetCalendarTodos(userID uuid.UUID, startDate, endDate time
 	var todos []models.Todo
 	
 	if err := s.db.Joins("JOIN notes ON todos.note_id = notes.id").
-		Where("notes.user_id = ? AND todos.due_date BETWEEN ? AND ?", userID, startDate, endDate).
+		Scopes(OwnedBy("notes", userID, s.workspaceID)).
+		Where("todos.due_date BETWEEN ? AND ?", startDate, endDate).
 		Preload("Note").
 		Preload("AssignedPerson").
 		Order("todos.due_date ASC").
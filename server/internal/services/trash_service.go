@@ -0,0 +1,457 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidTrashType means a trash item type is not note, person or todo
+	ErrInvalidTrashType = errors.New("type must be one of: note, person, todo")
+	// ErrParentInTrash means a todo cannot be restored while its note is in the trash
+	ErrParentInTrash = errors.New("the todo's note is in the trash; restore the note first")
+)
+
+// TrashItem is a deleted note, person or todo waiting to be restored or purged
+type TrashItem struct {
+	Type      string     `json:"type"`
+	ID        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	NoteID    *uuid.UUID `json:"note_id,omitempty"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+}
+
+// TrashService lists, restores and permanently purges soft-deleted entities
+type TrashService struct {
+	db          *gorm.DB
+	retention   time.Duration
+	workspaceID *uuid.UUID
+}
+
+// NewTrashService creates a new trash service. Items older than retention are
+// purged by PurgeExpired; a zero retention keeps them until purged by hand.
+func NewTrashService(db *gorm.DB, retention time.Duration) *TrashService {
+	return &TrashService{db: db, retention: retention}
+}
+
+// InWorkspace returns a copy of the service that works on a workspace's trash,
+// or on the user's personal trash when workspaceID is nil
+func (s *TrashService) InWorkspace(workspaceID *uuid.UUID) *TrashService {
+	scoped := *s
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// Retention returns how long deleted items are kept
+func (s *TrashService) Retention() time.Duration {
+	return s.retention
+}
+
+// ListTrash returns a user's deleted items, most recently deleted first. An
+// empty itemType lists every type. Todos deleted together with their note are
+// not listed separately; they come back when the note is restored.
+func (s *TrashService) ListTrash(userID uuid.UUID, itemType string) ([]TrashItem, error) {
+	if itemType != "" && !isTrashType(itemType) {
+		return nil, ErrInvalidTrashType
+	}
+
+	items := []TrashItem{}
+
+	if itemType == "" || itemType == models.SyncEntityNote {
+		var notes []models.Note
+		if err := s.db.Unscoped().Select("id, title, deleted_at").
+			Scopes(OwnedBy("notes", userID, s.workspaceID)).
+			Where("deleted_at IS NOT NULL").
+			Find(&notes).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch deleted notes: %w", err)
+		}
+		for _, note := range notes {
+			items = append(items, s.trashItem(models.SyncEntityNote, note.ID, note.Title, nil, note.DeletedAt.Time))
+		}
+	}
+
+	if itemType == "" || itemType == models.SyncEntityPerson {
+		var people []models.Person
+		if err := s.db.Unscoped().Select("id, name, deleted_at").
+			Scopes(OwnedBy("people", userID, s.workspaceID)).
+			Where("deleted_at IS NOT NULL").
+			Find(&people).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch deleted people: %w", err)
+		}
+		for _, person := range people {
+			items = append(items, s.trashItem(models.SyncEntityPerson, person.ID, person.Name, nil, person.DeletedAt.Time))
+		}
+	}
+
+	if itemType == "" || itemType == models.SyncEntityTodo {
+		var todos []models.Todo
+		if err := s.db.Unscoped().Select("todos.id, todos.note_id, todos.text, todos.deleted_at").
+			Joins("JOIN notes ON notes.id = todos.note_id").
+			Scopes(OwnedBy("notes", userID, s.workspaceID)).
+			Where("notes.deleted_at IS NULL AND todos.deleted_at IS NOT NULL").
+			Find(&todos).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch deleted todos: %w", err)
+		}
+		for _, todo := range todos {
+			noteID := todo.NoteID
+			items = append(items, s.trashItem(models.SyncEntityTodo, todo.ID, todo.Text, &noteID, todo.DeletedAt.Time))
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// Restore takes an item out of the trash and returns it. Restoring a note also
+// restores the todos that were deleted with it.
+func (s *TrashService) Restore(userID uuid.UUID, itemType string, id uuid.UUID) (interface{}, error) {
+	switch itemType {
+	case models.SyncEntityNote:
+		return s.restoreNote(userID, id)
+	case models.SyncEntityPerson:
+		return s.restorePerson(userID, id)
+	case models.SyncEntityTodo:
+		return s.restoreTodo(userID, id)
+	default:
+		return nil, ErrInvalidTrashType
+	}
+}
+
+func (s *TrashService) restoreNote(userID, noteID uuid.UUID) (*models.Note, error) {
+	var note models.Note
+	if err := s.db.Unscoped().Scopes(OwnedBy("notes", userID, s.workspaceID)).
+		Where("id = ? AND deleted_at IS NOT NULL", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Todos deleted on their own before the note stay in the trash
+		var todoIDs []uuid.UUID
+		if err := tx.Unscoped().Model(&models.Todo{}).
+			Where("note_id = ? AND deleted_at >= ?", note.ID, note.DeletedAt.Time).
+			Pluck("id", &todoIDs).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&note).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := RecordNoteSyncChange(tx, &note, models.SyncEntityNote, note.ID, models.SyncActionCreate); err != nil {
+			return err
+		}
+
+		if len(todoIDs) == 0 {
+			return nil
+		}
+		if err := tx.Unscoped().Model(&models.Todo{}).Where("id IN ?", todoIDs).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		for _, todoID := range todoIDs {
+			if err := RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todoID, models.SyncActionCreate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore note: %w", err)
+	}
+
+	note.DeletedAt = gorm.DeletedAt{}
+	return &note, nil
+}
+
+func (s *TrashService) restorePerson(userID, personID uuid.UUID) (*models.Person, error) {
+	var person models.Person
+	if err := s.db.Unscoped().Scopes(OwnedBy("people", userID, s.workspaceID)).
+		Where("id = ? AND deleted_at IS NOT NULL", personID).First(&person).Error; err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&person).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return RecordSyncChange(tx, userID, models.SyncEntityPerson, person.ID, models.SyncActionCreate)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore person: %w", err)
+	}
+
+	person.DeletedAt = gorm.DeletedAt{}
+	return &person, nil
+}
+
+func (s *TrashService) restoreTodo(userID, todoID uuid.UUID) (*models.Todo, error) {
+	var todo models.Todo
+	if err := s.db.Unscoped().Select("todos.*").
+		Joins("JOIN notes ON notes.id = todos.note_id").
+		Scopes(OwnedBy("notes", userID, s.workspaceID)).
+		Where("todos.id = ? AND todos.deleted_at IS NOT NULL", todoID).
+		First(&todo).Error; err != nil {
+		return nil, err
+	}
+
+	var note models.Note
+	if err := s.db.Where("id = ?", todo.NoteID).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrParentInTrash
+		}
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&todo).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todo.ID, models.SyncActionCreate)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore todo: %w", err)
+	}
+
+	todo.DeletedAt = gorm.DeletedAt{}
+	return &todo, nil
+}
+
+// Purge permanently deletes an item that is in the trash. Purging a note also
+// removes its todos, history and connections; purging a person unassigns their
+// todos and removes their connections.
+func (s *TrashService) Purge(userID uuid.UUID, itemType string, id uuid.UUID) error {
+	if !isTrashType(itemType) {
+		return ErrInvalidTrashType
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return purgeItem(tx, userID, s.workspaceID, itemType, id)
+	})
+}
+
+// EmptyTrash permanently deletes everything in a user's trash and reports how
+// many items were purged
+func (s *TrashService) EmptyTrash(userID uuid.UUID) (int, error) {
+	items, err := s.ListTrash(userID, "")
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			if err := purgeItem(tx, userID, s.workspaceID, item.Type, item.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
+
+// PurgeExpired permanently deletes every item, for all users, that has been in
+// the trash for longer than the retention period and reports how many were purged
+func (s *TrashService) PurgeExpired() (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-s.retention)
+
+	type expired struct {
+		UserID      uuid.UUID
+		WorkspaceID *uuid.UUID
+		ID          uuid.UUID
+	}
+	queries := []struct {
+		itemType string
+		query    *gorm.DB
+	}{
+		{models.SyncEntityNote, s.db.Unscoped().Model(&models.Note{}).
+			Select("user_id, workspace_id, id").Where("deleted_at < ?", cutoff)},
+		{models.SyncEntityPerson, s.db.Unscoped().Model(&models.Person{}).
+			Select("user_id, workspace_id, id").Where("deleted_at < ?", cutoff)},
+		// Todos of a deleted note go when the note does
+		{models.SyncEntityTodo, s.db.Unscoped().Model(&models.Todo{}).
+			Select("notes.user_id, notes.workspace_id, todos.id").
+			Joins("JOIN notes ON notes.id = todos.note_id").
+			Where("todos.deleted_at < ? AND notes.deleted_at IS NULL", cutoff)},
+	}
+
+	purged := 0
+	for _, q := range queries {
+		var rows []expired
+		if err := q.query.Scan(&rows).Error; err != nil {
+			return purged, fmt.Errorf("failed to find expired %s items: %w", q.itemType, err)
+		}
+		for _, row := range rows {
+			err := s.db.Transaction(func(tx *gorm.DB) error {
+				return purgeItem(tx, row.UserID, row.WorkspaceID, q.itemType, row.ID)
+			})
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Restored or purged since the query ran
+				continue
+			}
+			if err != nil {
+				return purged, fmt.Errorf("failed to purge %s %s: %w", q.itemType, row.ID, err)
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// RunPurgeJob purges expired items every interval. It blocks, so run it in its
+// own goroutine; it returns immediately when retention is disabled.
+func (s *TrashService) RunPurgeJob(interval time.Duration) {
+	if s.retention <= 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := s.PurgeExpired()
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired items from the trash", purged)
+		}
+	}
+}
+
+func (s *TrashService) trashItem(itemType string, id uuid.UUID, title string, noteID *uuid.UUID, deletedAt time.Time) TrashItem {
+	item := TrashItem{Type: itemType, ID: id, Title: title, NoteID: noteID, DeletedAt: deletedAt}
+	if s.retention > 0 {
+		purgeAt := deletedAt.Add(s.retention)
+		item.PurgeAt = &purgeAt
+	}
+	return item
+}
+
+// purgeItem hard-deletes a trashed entity and everything that depends on it
+func purgeItem(tx *gorm.DB, userID uuid.UUID, workspaceID *uuid.UUID, itemType string, id uuid.UUID) error {
+	switch itemType {
+	case models.SyncEntityNote:
+		var note models.Note
+		if err := tx.Unscoped().Scopes(OwnedBy("notes", userID, workspaceID)).
+			Where("id = ? AND deleted_at IS NOT NULL", id).First(&note).Error; err != nil {
+			return err
+		}
+		if err := purgeConnections(tx, userID, workspaceID, id); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("note_id = ?", id).Delete(&models.Todo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id = ?", id).Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id = ?", id).Delete(&models.NoteConflict{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id = ?", id).Delete(&models.NoteShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id IN (?)", tx.Model(&models.Comment{}).Select("id").Where("note_id = ?", id)).
+			Delete(&models.CommentMention{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id = ?", id).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id = ?", id).Delete(&models.NoteAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&note).Error
+
+	case models.SyncEntityPerson:
+		var person models.Person
+		if err := tx.Unscoped().Scopes(OwnedBy("people", userID, workspaceID)).
+			Where("id = ? AND deleted_at IS NOT NULL", id).First(&person).Error; err != nil {
+			return err
+		}
+		if err := purgeConnections(tx, userID, workspaceID, id); err != nil {
+			return err
+		}
+
+		var assigned []models.Todo
+		if err := tx.Where("assigned_person_id = ?", id).Find(&assigned).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Todo{}).Where("assigned_person_id = ?", id).UpdateColumn("assigned_person_id", nil).Error; err != nil {
+			return err
+		}
+		for _, todo := range assigned {
+			var note models.Note
+			if err := tx.Unscoped().Where("id = ?", todo.NoteID).First(&note).Error; err != nil {
+				return err
+			}
+			if err := RecordNoteSyncChange(tx, &note, models.SyncEntityTodo, todo.ID, models.SyncActionUpdate); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("mention_type = ? AND mentioned_id = ?", models.MentionTypePerson, id).
+			Delete(&models.CommentMention{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&person).Error
+
+	case models.SyncEntityTodo:
+		var todo models.Todo
+		if err := tx.Unscoped().Select("todos.*").
+			Joins("JOIN notes ON notes.id = todos.note_id").
+			Scopes(OwnedBy("notes", userID, workspaceID)).
+			Where("todos.id = ? AND todos.deleted_at IS NOT NULL", id).
+			First(&todo).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&todo).Error
+
+	default:
+		return ErrInvalidTrashType
+	}
+}
+
+// purgeConnections deletes the connections to or from an entity and records
+// their removal in the change feed
+func purgeConnections(tx *gorm.DB, userID uuid.UUID, workspaceID *uuid.UUID, entityID uuid.UUID) error {
+	var connectionIDs []uuid.UUID
+	if err := tx.Model(&models.Connection{}).
+		Scopes(OwnedBy("connections", userID, workspaceID)).
+		Where("(source_id = ? OR target_id = ?)", entityID, entityID).
+		Pluck("id", &connectionIDs).Error; err != nil {
+		return err
+	}
+	if len(connectionIDs) == 0 {
+		return nil
+	}
+
+	if err := tx.Where("id IN ?", connectionIDs).Delete(&models.Connection{}).Error; err != nil {
+		return err
+	}
+	for _, connectionID := range connectionIDs {
+		if err := RecordSyncChange(tx, userID, models.SyncEntityConnection, connectionID, models.SyncActionDelete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTrashType(itemType string) bool {
+	switch itemType {
+	case models.SyncEntityNote, models.SyncEntityPerson, models.SyncEntityTodo:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTrashService_DeleteAndRestore(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	service := NewTrashService(db, 24*time.Hour)
+
+	note := createTestNote(t, db, user.ID)
+	kept := models.Todo{NoteID: note.ID, TodoID: "t1", Text: "Deleted with the note"}
+	alone := models.Todo{NoteID: note.ID, TodoID: "t2", Text: "Deleted on its own"}
+	require.NoError(t, db.Create(&kept).Error)
+	require.NoError(t, db.Create(&alone).Error)
+
+	// A todo deleted by itself is listed on its own
+	require.NoError(t, db.Delete(&alone).Error)
+	items, err := service.ListTrash(user.ID, "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, models.SyncEntityTodo, items[0].Type)
+	require.NotNil(t, items[0].PurgeAt)
+	assert.WithinDuration(t, items[0].DeletedAt.Add(24*time.Hour), *items[0].PurgeAt, time.Second)
+
+	time.Sleep(10 * time.Millisecond)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		_, err := DeleteNote(tx, user.ID, nil, note.ID)
+		return err
+	})
+	require.NoError(t, err)
+
+	// The note hides its todos, and the deleted note is invisible to normal queries
+	items, err = service.ListTrash(user.ID, "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, models.SyncEntityNote, items[0].Type)
+	assert.Equal(t, note.ID, items[0].ID)
+	assert.ErrorIs(t, db.First(&models.Note{}, "id = ?", note.ID).Error, gorm.ErrRecordNotFound)
+
+	items, err = service.ListTrash(other.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	_, err = service.ListTrash(user.ID, "connection")
+	assert.ErrorIs(t, err, ErrInvalidTrashType)
+
+	// A todo cannot come back while its note is in the trash
+	_, err = service.Restore(user.ID, models.SyncEntityTodo, alone.ID)
+	assert.ErrorIs(t, err, ErrParentInTrash)
+
+	_, err = service.Restore(other.ID, models.SyncEntityNote, note.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Restoring the note brings back only the todos deleted with it
+	restored, err := service.Restore(user.ID, models.SyncEntityNote, note.ID)
+	require.NoError(t, err)
+	assert.Equal(t, note.ID, restored.(*models.Note).ID)
+
+	var todos []models.Todo
+	require.NoError(t, db.Where("note_id = ?", note.ID).Find(&todos).Error)
+	require.Len(t, todos, 1)
+	assert.Equal(t, kept.ID, todos[0].ID)
+
+	_, err = service.Restore(user.ID, models.SyncEntityTodo, alone.ID)
+	require.NoError(t, err)
+	require.NoError(t, db.Where("note_id = ?", note.ID).Find(&todos).Error)
+	assert.Len(t, todos, 2)
+
+	// Restoring something that is not in the trash fails
+	_, err = service.Restore(user.ID, models.SyncEntityNote, note.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Restores appear in the change feed as creates
+	var actions []string
+	require.NoError(t, db.Model(&models.SyncChange{}).
+		Where("user_id = ? AND entity_id = ?", user.ID, note.ID).
+		Order("seq").Pluck("action", &actions).Error)
+	assert.Equal(t, []string{models.SyncActionDelete, models.SyncActionCreate}, actions)
+}
+
+func TestTrashService_Purge(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	service := NewTrashService(db, time.Hour)
+
+	note := createTestNote(t, db, user.ID)
+	person := models.Person{UserID: user.ID, Name: "Ada"}
+	require.NoError(t, db.Create(&person).Error)
+	todo := models.Todo{NoteID: note.ID, TodoID: "t1", Text: "Call Ada", AssignedPersonID: &person.ID}
+	require.NoError(t, db.Create(&todo).Error)
+	connection := models.Connection{UserID: user.ID, SourceID: note.ID, SourceType: "note", TargetID: person.ID, TargetType: "person"}
+	require.NoError(t, db.Create(&connection).Error)
+
+	// Only items in the trash can be purged
+	err := service.Purge(user.ID, models.SyncEntityPerson, person.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// While in the trash, the person's connections are hidden from the graph
+	require.NoError(t, db.Delete(&person).Error)
+	edges, err := NewConnectionService(db).GetNodeConnections(user.ID, note.ID)
+	require.NoError(t, err)
+	assert.Empty(t, edges)
+
+	// Purging a person unassigns their todos and removes their connections
+	require.NoError(t, service.Purge(user.ID, models.SyncEntityPerson, person.ID))
+	var unassigned models.Todo
+	require.NoError(t, db.First(&unassigned, "id = ?", todo.ID).Error)
+	assert.Nil(t, unassigned.AssignedPersonID)
+	var count int64
+	db.Model(&models.Connection{}).Where("id = ?", connection.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Unscoped().Model(&models.Person{}).Where("id = ?", person.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// Expired notes are purged with their todos; recent ones stay
+	expired := createTestNote(t, db, user.ID)
+	recent := createTestNote(t, db, user.ID)
+	require.NoError(t, db.Model(&models.Note{}).Where("id IN ?", []uuid.UUID{note.ID, expired.ID}).
+		UpdateColumn("deleted_at", time.Now().Add(-2*time.Hour)).Error)
+	require.NoError(t, db.Model(&models.Todo{}).Where("id = ?", todo.ID).
+		UpdateColumn("deleted_at", time.Now().Add(-2*time.Hour)).Error)
+	require.NoError(t, db.Delete(&recent).Error)
+
+	purged, err := service.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	db.Unscoped().Model(&models.Todo{}).Where("id = ?", todo.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	items, err := service.ListTrash(user.ID, "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, recent.ID, items[0].ID)
+
+	// Without a retention period nothing expires
+	purged, err = NewTrashService(db, 0).PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	purged, err = service.EmptyTrash(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	items, err = service.ListTrash(user.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestTodoService_SyncNoteTodosRevivesTrashedTodo(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	user := createTestUser(t, db)
+	service := NewTodoService(db)
+
+	note := createTestNote(t, db, user.ID)
+	note.Content = doc(paragraph("- [ ][t1] Still written down"))
+	require.NoError(t, db.Save(&note).Error)
+	require.NoError(t, service.SyncNoteTodos(note.ID, user.ID))
+
+	var todo models.Todo
+	require.NoError(t, db.Where("note_id = ? AND todo_id = ?", note.ID, "t1").First(&todo).Error)
+	require.NoError(t, db.Delete(&todo).Error)
+
+	// Trashed todo IDs are not handed out again
+	next, err := service.GenerateNextTodoID(note.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "t2", next)
+
+	// A todo still in the note content comes back on the next sync
+	require.NoError(t, service.SyncNoteTodos(note.ID, user.ID))
+	var revived models.Todo
+	require.NoError(t, db.Where("note_id = ? AND todo_id = ?", note.ID, "t1").First(&revived).Error)
+	assert.Equal(t, todo.ID, revived.ID)
+}
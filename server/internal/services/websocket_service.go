@@ -1,9 +1,12 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,9 +30,21 @@ var upgrader = websocket.Upgrader{
 // WebSocketService manages WebSocket connections and real-time collaboration
 type WebSocketService struct {
 	db          *gorm.DB
+	revisions   *RevisionService
+	conflicts   *ConflictService
+	shares      *ShareService
+	access      *NoteAccessService
+	workspaces  *WorkspaceService
+	ask         *AskService
+	operations  *OperationLog
 	rooms       map[string]*models.Room
 	connections map[uuid.UUID]*websocket.Conn
 	clients     map[uuid.UUID]*models.Client
+	// writeLocks serializes writes to each connection, which answers
+	// streamed from other goroutines share with the client's own replies
+	writeLocks map[uuid.UUID]*sync.Mutex
+	// aiStreams cancels the answers being streamed to each client
+	aiStreams map[aiStreamKey]context.CancelFunc
 	mutex       sync.RWMutex
 	broadcast   chan *models.WebSocketMessage
 	register    chan *models.Client
@@ -38,11 +53,20 @@ type WebSocketService struct {
 
 // NewWebSocketService creates a new WebSocket service
 func NewWebSocketService(db *gorm.DB) *WebSocketService {
+	operations := NewOperationLog()
 	service := &WebSocketService{
 		db:          db,
+		revisions:   NewRevisionService(db),
+		conflicts:   NewConflictService(db).WithOperations(operations),
+		shares:      NewShareService(db),
+		access:      NewNoteAccessService(db),
+		workspaces:  NewWorkspaceService(db),
+		operations:  operations,
 		rooms:       make(map[string]*models.Room),
 		connections: make(map[uuid.UUID]*websocket.Conn),
 		clients:     make(map[uuid.UUID]*models.Client),
+		writeLocks:  make(map[uuid.UUID]*sync.Mutex),
+		aiStreams:   make(map[aiStreamKey]context.CancelFunc),
 		broadcast:   make(chan *models.WebSocketMessage, 256),
 		register:    make(chan *models.Client, 256),
 		unregister:  make(chan *models.Client, 256),
@@ -54,6 +78,20 @@ func NewWebSocketService(db *gorm.DB) *WebSocketService {
 	return service
 }
 
+// SetAskService lets clients ask questions about their notes over the
+// WebSocket, with answers streamed back as they are written
+func (s *WebSocketService) SetAskService(ask *AskService) {
+	s.mutex.Lock()
+	s.ask = ask
+	s.mutex.Unlock()
+}
+
+// Operations returns the log that serialises live edits to each note, for
+// services that write notes outside the WebSocket
+func (s *WebSocketService) Operations() *OperationLog {
+	return s.operations
+}
+
 // HandleWebSocket upgrades HTTP connection to WebSocket
 func (s *WebSocketService) HandleWebSocket(c *gin.Context) {
 	// Get user from context (set by auth middleware)
@@ -95,6 +133,7 @@ func (s *WebSocketService) HandleWebSocket(c *gin.Context) {
 	s.mutex.Lock()
 	s.connections[client.ID] = conn
 	s.clients[client.ID] = client
+	s.writeLocks[client.ID] = &sync.Mutex{}
 	s.mutex.Unlock()
 
 	// Register client
@@ -135,7 +174,11 @@ func (s *WebSocketService) handleClient(client *models.Client, conn *websocket.C
 		s.mutex.Lock()
 		delete(s.connections, client.ID)
 		delete(s.clients, client.ID)
+		delete(s.writeLocks, client.ID)
 		s.mutex.Unlock()
+
+		// Answers to a client that left are no longer needed
+		s.cancelAIStreams(client.ID)
 	}()
 
 	// Set read deadline and pong handler
@@ -179,6 +222,12 @@ func (s *WebSocketService) handleMessage(client *models.Client, message *models.
 		s.handleCursorUpdate(client, message)
 	case models.MessageTypePresence:
 		s.handlePresenceUpdate(client, message)
+	case models.MessageTypeResolveConflict:
+		s.handleResolveConflict(client, message)
+	case models.MessageTypeAIAsk:
+		s.handleAIAsk(client, message)
+	case models.MessageTypeAICancel:
+		s.handleAICancel(client, message)
 	default:
 		s.sendError(client, "unknown_message_type", "Unknown message type: "+message.Type)
 	}
@@ -193,12 +242,16 @@ func (s *WebSocketService) handleJoinRoom(client *models.Client, message *models
 	}
 
 	// Verify user has access to the note
-	var note models.Note
-	if err := s.db.Where("id = ? AND user_id = ?", joinData.NoteID, client.UserID).First(&note).Error; err != nil {
+	_, role, err := s.shares.AuthorizeNote(client.UserID, joinData.NoteID, models.NoteRoleViewer)
+	if err != nil {
 		s.sendError(client, "note_not_found", "Note not found or access denied")
 		return
 	}
 
+	if err := s.access.Record(client.UserID, joinData.NoteID, models.NoteAccessCollaborate); err != nil {
+		log.Printf("Failed to record access to note %s: %v", joinData.NoteID, err)
+	}
+
 	roomID := joinData.NoteID.String()
 	client.RoomID = roomID
 
@@ -229,6 +282,7 @@ func (s *WebSocketService) handleJoinRoom(client *models.Client, message *models
 		Data: map[string]interface{}{
 			"action": "joined_room",
 			"room_id": roomID,
+			"role":    role,
 		},
 	})
 
@@ -264,6 +318,7 @@ func (s *WebSocketService) handleLeaveRoom(client *models.Client, message *model
 		// Remove room if empty
 		if len(room.Clients) == 0 {
 			delete(s.rooms, client.RoomID)
+			s.operations.Forget(room.NoteID)
 		}
 	}
 	s.mutex.Unlock()
@@ -292,16 +347,36 @@ func (s *WebSocketService) handleNoteUpdate(client *models.Client, message *mode
 		return
 	}
 
+	// Edits are broadcast to the client's room, so they must be for its note
+	if client.RoomID != updateData.NoteID.String() {
+		s.sendError(client, "not_in_room", "Join the note's room before editing it")
+		return
+	}
+
+	// Serialise edits to the note so transforms see a consistent history
+	unlock := s.operations.Lock(updateData.NoteID)
+	defer unlock()
+
 	// Get current note version
-	var note models.Note
-	if err := s.db.Where("id = ? AND user_id = ?", updateData.NoteID, client.UserID).First(&note).Error; err != nil {
-		s.sendError(client, "note_not_found", "Note not found or access denied")
+	note, _, err := s.shares.AuthorizeNote(client.UserID, updateData.NoteID, models.NoteRoleEditor)
+	if err != nil {
+		if errors.Is(err, ErrNoteAccessDenied) {
+			s.sendError(client, "access_denied", "You do not have permission to edit this note")
+		} else {
+			s.sendError(client, "note_not_found", "Note not found or access denied")
+		}
+		return
+	}
+
+	// Incremental edits are transformed against concurrent ones instead of conflicting
+	if updateData.Operation != "" && updateData.Operation != OperationReplace {
+		s.handleNoteOperation(client, note, &updateData)
 		return
 	}
 
 	// Check for version conflicts
 	if updateData.Version != note.Version {
-		s.handleVersionConflict(client, &note, &updateData)
+		s.handleVersionConflict(client, note, &updateData)
 		return
 	}
 
@@ -310,11 +385,22 @@ func (s *WebSocketService) handleNoteUpdate(client *models.Client, message *mode
 	note.Version++
 	note.UpdatedAt = time.Now()
 
-	if err := s.db.Save(&note).Error; err != nil {
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(note).Error; err != nil {
+			return err
+		}
+		if err := s.revisions.RecordRevision(tx, note, client.UserID, "Real-time edit"); err != nil {
+			return err
+		}
+		return RecordNoteSyncChange(tx, note, models.SyncEntityNote, note.ID, models.SyncActionUpdate)
+	}); err != nil {
 		s.sendError(client, "update_failed", "Failed to update note")
 		return
 	}
 
+	// A full replacement cannot be transformed against, so older operations must conflict
+	s.operations.Forget(note.ID)
+
 	// Broadcast update to room (excluding sender)
 	updateData.Version = note.Version
 	s.broadcastToRoom(client.RoomID, &models.WebSocketMessage{
@@ -340,6 +426,69 @@ func (s *WebSocketService) handleNoteUpdate(client *models.Client, message *mode
 	})
 }
 
+// handleNoteOperation transforms an insert/delete/format operation written against
+// an older version onto the current document, applies it and broadcasts the result
+func (s *WebSocketService) handleNoteOperation(client *models.Client, note *models.Note, updateData *models.NoteUpdateData) {
+	ops, err := s.operations.Transform(note.ID, updateData.Version, note.Version, []models.TextOperation{OperationFromUpdate(updateData)})
+	if err != nil {
+		// Too far behind (or history lost after a restart or full save): fall back to conflict resolution
+		s.handleVersionConflict(client, note, updateData)
+		return
+	}
+
+	content, err := ApplyOperations(note.Content, ops)
+	if err != nil {
+		s.sendError(client, "invalid_operation", err.Error())
+		return
+	}
+
+	note.Content = content
+	note.Version++
+	note.UpdatedAt = time.Now()
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(note).Error; err != nil {
+			return err
+		}
+		if err := s.revisions.RecordRevision(tx, note, client.UserID, "Real-time edit"); err != nil {
+			return err
+		}
+		return RecordNoteSyncChange(tx, note, models.SyncEntityNote, note.ID, models.SyncActionUpdate)
+	}); err != nil {
+		s.sendError(client, "update_failed", "Failed to update note")
+		return
+	}
+	s.operations.Record(note.ID, note.Version, ops)
+
+	s.broadcastToRoom(client.RoomID, &models.WebSocketMessage{
+		Type:      models.MessageTypeNoteUpdate,
+		RoomID:    client.RoomID,
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		Data: models.NoteUpdateData{
+			NoteID:     note.ID,
+			Content:    note.Content,
+			Version:    note.Version,
+			Operation:  updateData.Operation,
+			Operations: ops,
+		},
+	}, client.ID)
+
+	s.sendMessage(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeAck,
+		RoomID:    client.RoomID,
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"action":     "note_updated",
+			"version":    note.Version,
+			"operations": ops,
+		},
+	})
+}
+
 // handleCursorUpdate handles cursor position updates
 func (s *WebSocketService) handleCursorUpdate(client *models.Client, message *models.WebSocketMessage) {
 	var cursorData models.CursorUpdateData
@@ -381,17 +530,33 @@ func (s *WebSocketService) handlePresenceUpdate(client *models.Client, message *
 	}, client.ID)
 }
 
-// handleVersionConflict handles version conflicts during note updates
+// handleVersionConflict records a conflicting update so it can be resolved later,
+// even after the client reconnects, and notifies the sender
 func (s *WebSocketService) handleVersionConflict(client *models.Client, note *models.Note, updateData *models.NoteUpdateData) {
-	conflictID := uuid.New().String()
+	localContent := updateData.Content
+	if localContent == nil && updateData.Operation != "" && updateData.Operation != OperationReplace {
+		// Rebuild what the client was looking at from the version it edited
+		if base, err := s.revisions.GetRevision(note.ID, updateData.Version); err == nil {
+			if content, err := ApplyOperations(base.Content, []models.TextOperation{OperationFromUpdate(updateData)}); err == nil {
+				localContent = content
+			}
+		}
+	}
+
+	conflict, err := s.conflicts.CreateConflict(note, client.UserID, updateData.Version, localContent)
+	if err != nil {
+		log.Printf("Failed to record conflict on note %s: %v", note.ID, err)
+		s.sendError(client, "update_failed", "Failed to record conflict")
+		return
+	}
 
 	conflictData := models.ConflictData{
 		NoteID:        note.ID,
-		ConflictID:    conflictID,
-		LocalVersion:  updateData.Version,
-		RemoteVersion: note.Version,
-		LocalContent:  updateData.Content,
-		RemoteContent: note.Content,
+		ConflictID:    conflict.ID.String(),
+		LocalVersion:  conflict.LocalVersion,
+		RemoteVersion: conflict.RemoteVersion,
+		LocalContent:  conflict.LocalContent,
+		RemoteContent: conflict.RemoteContent,
 	}
 
 	s.sendMessage(client, &models.WebSocketMessage{
@@ -404,6 +569,202 @@ func (s *WebSocketService) handleVersionConflict(client *models.Client, note *mo
 	})
 }
 
+// handleResolveConflict resolves a recorded conflict and broadcasts the resulting note
+func (s *WebSocketService) handleResolveConflict(client *models.Client, message *models.WebSocketMessage) {
+	var resolveData models.ResolveConflictData
+	if err := s.parseMessageData(message.Data, &resolveData); err != nil {
+		s.sendError(client, "invalid_resolve_data", "Invalid resolve conflict data")
+		return
+	}
+
+	note, merge, err := s.conflicts.ResolveConflict(client.UserID, resolveData.NoteID, resolveData.ConflictID, resolveData.Resolution, resolveData.Content)
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		s.sendError(client, "conflict_not_found", "Conflict not found or access denied")
+		return
+	case errors.Is(err, ErrNoteAccessDenied):
+		s.sendError(client, "access_denied", "You do not have permission to edit this note")
+		return
+	case errors.Is(err, ErrMergeConflict):
+		s.sendMessage(client, &models.WebSocketMessage{
+			Type:      models.MessageTypeConflict,
+			RoomID:    client.RoomID,
+			UserID:    client.UserID,
+			Username:  client.Username,
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"note_id":     resolveData.NoteID,
+				"conflict_id": resolveData.ConflictID,
+				"merge":       merge,
+			},
+		})
+		return
+	case errors.Is(err, ErrConflictResolved), errors.Is(err, ErrInvalidResolution), errors.Is(err, ErrAncestorUnavailable), errors.Is(err, ErrNoteChanged), errors.Is(err, ErrLocalContentMissing):
+		s.sendError(client, "resolve_failed", err.Error())
+		return
+	default:
+		s.sendError(client, "resolve_failed", "Failed to resolve conflict")
+		return
+	}
+
+	s.broadcastToRoom(note.ID.String(), &models.WebSocketMessage{
+		Type:      models.MessageTypeNoteUpdate,
+		RoomID:    note.ID.String(),
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		Data: models.NoteUpdateData{
+			NoteID:    note.ID,
+			Content:   note.Content,
+			Version:   note.Version,
+			Operation: OperationReplace,
+		},
+	}, client.ID)
+
+	s.sendMessage(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeAck,
+		RoomID:    client.RoomID,
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"action":      "conflict_resolved",
+			"conflict_id": resolveData.ConflictID,
+			"version":     note.Version,
+			"content":     note.Content,
+		},
+	})
+}
+
+// aiStreamKey identifies an answer being streamed to a client by the
+// message_id of its question
+type aiStreamKey struct {
+	clientID  uuid.UUID
+	messageID string
+}
+
+// handleAIAsk answers a question about the user's notes, streaming the answer
+// back as it is written. Answers run in the background, so the client can keep
+// editing or cancel the answer meanwhile.
+func (s *WebSocketService) handleAIAsk(client *models.Client, message *models.WebSocketMessage) {
+	if message.MessageID == "" {
+		s.sendError(client, "invalid_data", "AI questions need a message_id")
+		return
+	}
+	var askData models.AIAskData
+	if err := s.parseMessageData(message.Data, &askData); err != nil || strings.TrimSpace(askData.Question) == "" {
+		s.sendAIError(client, message.MessageID, "invalid_data", "Invalid question")
+		return
+	}
+
+	s.mutex.RLock()
+	ask := s.ask
+	s.mutex.RUnlock()
+	if ask == nil {
+		s.sendAIError(client, message.MessageID, "ai_unavailable", ErrAIDisabled.Error())
+		return
+	}
+	if askData.WorkspaceID != nil {
+		if _, err := s.workspaces.Membership(client.UserID, *askData.WorkspaceID); err != nil {
+			s.sendAIError(client, message.MessageID, "workspace_not_found", "Workspace not found")
+			return
+		}
+	}
+
+	key := aiStreamKey{clientID: client.ID, messageID: message.MessageID}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mutex.Lock()
+	if _, exists := s.aiStreams[key]; exists {
+		s.mutex.Unlock()
+		cancel()
+		s.sendAIError(client, message.MessageID, "duplicate_message_id", "An answer with this message_id is already streaming")
+		return
+	}
+	s.aiStreams[key] = cancel
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.aiStreams, key)
+			s.mutex.Unlock()
+			cancel()
+		}()
+
+		response, err := ask.InWorkspace(askData.WorkspaceID).AskStream(ctx, client.UserID, askData.SessionID, askData.Question, func(delta string) error {
+			s.sendMessage(client, &models.WebSocketMessage{
+				Type:      models.MessageTypeAIChunk,
+				UserID:    client.UserID,
+				Username:  client.Username,
+				Timestamp: time.Now(),
+				MessageID: message.MessageID,
+				Data:      models.AIChunkData{Text: delta},
+			})
+			return nil
+		})
+		switch {
+		case ctx.Err() != nil:
+			// Cancelled by the client, who needs no reply
+		case errors.Is(err, ErrAIDisabled):
+			s.sendAIError(client, message.MessageID, "ai_unavailable", err.Error())
+		case errors.Is(err, ErrChatSessionNotFound):
+			s.sendAIError(client, message.MessageID, "session_not_found", err.Error())
+		case errors.Is(err, ErrAIQuotaExceeded):
+			s.sendAIError(client, message.MessageID, "ai_quota_exceeded", err.Error())
+		case err != nil:
+			log.Printf("Streamed AI answer failed: %v", err)
+			s.sendAIError(client, message.MessageID, "ai_failed", "Failed to answer question")
+		default:
+			s.sendMessage(client, &models.WebSocketMessage{
+				Type:      models.MessageTypeAIDone,
+				UserID:    client.UserID,
+				Username:  client.Username,
+				Timestamp: time.Now(),
+				MessageID: message.MessageID,
+				Data:      response,
+			})
+		}
+	}()
+}
+
+// handleAICancel stops an answer being streamed to the client. Nothing of a
+// cancelled answer is saved.
+func (s *WebSocketService) handleAICancel(client *models.Client, message *models.WebSocketMessage) {
+	key := aiStreamKey{clientID: client.ID, messageID: message.MessageID}
+	s.mutex.Lock()
+	cancel, exists := s.aiStreams[key]
+	delete(s.aiStreams, key)
+	s.mutex.Unlock()
+	if !exists {
+		return
+	}
+	cancel()
+
+	s.sendMessage(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeAck,
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		MessageID: message.MessageID,
+		Data: map[string]interface{}{
+			"action": "ai_cancelled",
+		},
+	})
+}
+
+// cancelAIStreams stops every answer being streamed to a client
+func (s *WebSocketService) cancelAIStreams(clientID uuid.UUID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, cancel := range s.aiStreams {
+		if key.clientID == clientID {
+			cancel()
+			delete(s.aiStreams, key)
+		}
+	}
+}
+
 // Utility methods
 
 // registerClient registers a new client
@@ -426,6 +787,31 @@ func (s *WebSocketService) broadcastMessage(message *models.WebSocketMessage) {
 	}
 }
 
+// BroadcastToNote sends a message to every client in a note's room. REST
+// handlers use it to tell live collaborators about changes made outside the
+// socket.
+func (s *WebSocketService) BroadcastToNote(noteID uuid.UUID, message *models.WebSocketMessage) {
+	message.RoomID = noteID.String()
+	s.broadcastToRoom(message.RoomID, message, uuid.Nil)
+}
+
+// SendToUser sends a message to every connection of a user, whichever room
+// they are in
+func (s *WebSocketService) SendToUser(userID uuid.UUID, message *models.WebSocketMessage) {
+	s.mutex.RLock()
+	clients := make([]*models.Client, 0)
+	for _, client := range s.clients {
+		if client.UserID == userID {
+			clients = append(clients, client)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, client := range clients {
+		s.sendMessage(client, message)
+	}
+}
+
 // broadcastToRoom broadcasts a message to all clients in a room
 func (s *WebSocketService) broadcastToRoom(roomID string, message *models.WebSocketMessage, excludeClientID uuid.UUID) {
 	s.mutex.RLock()
@@ -452,13 +838,21 @@ func (s *WebSocketService) broadcastToRoom(roomID string, message *models.WebSoc
 func (s *WebSocketService) sendMessage(client *models.Client, message *models.WebSocketMessage) {
 	s.mutex.RLock()
 	conn, exists := s.connections[client.ID]
+	lock := s.writeLocks[client.ID]
 	s.mutex.RUnlock()
 
 	if !exists {
 		return
 	}
 
-	if err := conn.WriteJSON(message); err != nil {
+	if lock != nil {
+		lock.Lock()
+	}
+	err := conn.WriteJSON(message)
+	if lock != nil {
+		lock.Unlock()
+	}
+	if err != nil {
 		log.Printf("Error sending message to client %s: %v", client.ID, err)
 		s.unregister <- client
 	}
@@ -479,6 +873,22 @@ func (s *WebSocketService) sendError(client *models.Client, code, message string
 	s.sendMessage(client, errorMsg)
 }
 
+// sendAIError tells a client that the answer to the question with a
+// message_id failed
+func (s *WebSocketService) sendAIError(client *models.Client, messageID, code, message string) {
+	s.sendMessage(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeError,
+		UserID:    client.UserID,
+		Username:  client.Username,
+		Timestamp: time.Now(),
+		MessageID: messageID,
+		Data: models.ErrorData{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
 // sendActiveUsers sends the list of active users in a room to a client
 func (s *WebSocketService) sendActiveUsers(client *models.Client, roomID string) {
 	s.mutex.RLock()
@@ -527,6 +937,7 @@ func (s *WebSocketService) cleanupInactiveClients() {
 				conn.Close()
 				delete(s.connections, clientID)
 			}
+			delete(s.writeLocks, clientID)
 			s.unregister <- client
 		}
 	}
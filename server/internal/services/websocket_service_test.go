@@ -161,6 +161,11 @@ func TestWebSocketService_JoinRoom(t *testing.T) {
 	assert.True(t, exists)
 	assert.Equal(t, note.ID, room.NoteID)
 	assert.Equal(t, 1, len(room.Clients))
+
+	// Joining counts as opening the note
+	var access models.NoteAccess
+	require.NoError(t, testDB.Where("user_id = ? AND note_id = ?", user.ID, note.ID).First(&access).Error)
+	assert.Equal(t, models.NoteAccessCollaborate, access.Source)
 }
 
 func TestWebSocketService_JoinRoom_UnauthorizedNote(t *testing.T) {
@@ -334,6 +339,65 @@ func TestWebSocketService_NoteUpdate_VersionConflict(t *testing.T) {
 	assert.Equal(t, float64(2), conflictData["remote_version"])
 }
 
+func TestWebSocketService_ResolveConflict(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+
+	user := createTestUser(t, testDB)
+	note := createTestNote(t, testDB, user.ID)
+	require.NoError(t, testDB.Model(&note).Update("version", 2).Error)
+
+	conn, server := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer conn.Close()
+	defer server.Close()
+
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeJoinRoom,
+		Data: models.JoinRoomData{NoteID: note.ID},
+	}))
+	_ = readMessageOfType(t, conn, models.MessageTypeAck)
+
+	mine := models.JSONB{"type": "doc", "content": []interface{}{
+		map[string]interface{}{"type": "paragraph", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "mine"},
+		}},
+	}}
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeNoteUpdate,
+		Data: models.NoteUpdateData{NoteID: note.ID, Content: mine, Version: 1},
+	}))
+	conflictMessage := readMessageOfType(t, conn, models.MessageTypeConflict)
+	conflictID, err := uuid.Parse(conflictMessage.Data.(map[string]interface{})["conflict_id"].(string))
+	require.NoError(t, err)
+
+	// The conflict is persisted so it survives a reconnect
+	var conflict models.NoteConflict
+	require.NoError(t, testDB.First(&conflict, "id = ?", conflictID).Error)
+	assert.Equal(t, models.ConflictStatusOpen, conflict.Status)
+
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeResolveConflict,
+		Data: models.ResolveConflictData{NoteID: note.ID, ConflictID: conflictID, Resolution: models.ConflictResolutionMine},
+	}))
+	ack := readMessageOfType(t, conn, models.MessageTypeAck)
+	ackData := ack.Data.(map[string]interface{})
+	assert.Equal(t, "conflict_resolved", ackData["action"])
+	assert.Equal(t, float64(3), ackData["version"])
+
+	var updated models.Note
+	require.NoError(t, testDB.First(&updated, "id = ?", note.ID).Error)
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, mine, updated.Content)
+
+	// Resolving twice is an error
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeResolveConflict,
+		Data: models.ResolveConflictData{NoteID: note.ID, ConflictID: conflictID, Resolution: models.ConflictResolutionTheirs},
+	}))
+	errorMessage := readMessageOfType(t, conn, models.MessageTypeError)
+	assert.Equal(t, "resolve_failed", errorMessage.Data.(map[string]interface{})["code"])
+}
+
 func TestWebSocketService_GetRoomStats(t *testing.T) {
 	service, testDB := setupWebSocketTest(t)
 	defer database.CleanupTestDB(testDB)
@@ -371,4 +435,172 @@ func TestWebSocketService_GetRoomStats(t *testing.T) {
 	assert.Len(t, rooms, 1)
 	assert.Equal(t, note.ID.String(), rooms[0]["room_id"])
 	assert.Equal(t, 1, rooms[0]["client_count"])
-}
\ No newline at end of file
+}
+func TestWebSocketService_SharedNoteRoles(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+	
+	owner := createTestUser(t, testDB)
+	viewer := createTestUser(t, testDB)
+	note := createTestNote(t, testDB, owner.ID)
+	
+	_, err := NewShareService(testDB).ShareNote(owner.ID, note.ID, viewer.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+	
+	conn, server := setupWebSocketConnection(t, service, viewer.ID, viewer.Username)
+	defer conn.Close()
+	defer server.Close()
+	
+	// Viewers may join the room and are told their role
+	err = conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeJoinRoom,
+		Data: models.JoinRoomData{NoteID: note.ID},
+	})
+	require.NoError(t, err)
+	
+	ack := readMessageOfType(t, conn, models.MessageTypeAck)
+	assert.Equal(t, models.NoteRoleViewer, ack.Data.(map[string]interface{})["role"])
+	
+	// but may not edit
+	err = conn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeNoteUpdate,
+		Data: models.NoteUpdateData{
+			NoteID:    note.ID,
+			Content:   models.JSONB{"type": "doc", "content": []interface{}{}},
+			Version:   1,
+			Operation: "replace",
+		},
+	})
+	require.NoError(t, err)
+	
+	errorMessage := readMessageOfType(t, conn, models.MessageTypeError)
+	assert.Equal(t, "access_denied", errorMessage.Data.(map[string]interface{})["code"])
+	
+	var unchanged models.Note
+	require.NoError(t, testDB.First(&unchanged, "id = ?", note.ID).Error)
+	assert.Equal(t, 1, unchanged.Version)
+}
+
+func TestWebSocketService_AIAsk(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+
+	user := createTestUser(t, testDB)
+	conn, server := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer server.Close()
+	defer conn.Close()
+
+	ask := func(messageID string) {
+		require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+			Type:      models.MessageTypeAIAsk,
+			MessageID: messageID,
+			Data:      models.AIAskData{Question: "What did I plan?"},
+		}))
+	}
+
+	// Without an AI provider questions are refused
+	ask("q0")
+	message := readMessageOfType(t, conn, models.MessageTypeError)
+	assert.Equal(t, "q0", message.MessageID)
+	assert.Equal(t, "ai_unavailable", message.Data.(map[string]interface{})["code"])
+
+	provider, _ := streamChatServer(t, []string{"Nothing ", "yet."}, 0, nil)
+	ai := NewAIService(testDB, &AIConfig{Provider: ProviderOpenAI, APIKey: "test-key", BaseURL: provider.URL})
+	service.SetAskService(NewAskService(testDB, ai, nil))
+
+	ask("q1")
+	var answer strings.Builder
+	for {
+		var message models.WebSocketMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, "q1", message.MessageID)
+		if message.Type == models.MessageTypeAIDone {
+			assert.Equal(t, "Nothing yet.", message.Data.(map[string]interface{})["answer"])
+			break
+		}
+		require.Equal(t, models.MessageTypeAIChunk, message.Type)
+		answer.WriteString(message.Data.(map[string]interface{})["text"].(string))
+	}
+	assert.Equal(t, "Nothing yet.", answer.String())
+
+	// Cancelling stops the provider's stream
+	hold := make(chan struct{})
+	defer close(hold)
+	provider, cancelled := streamChatServer(t, []string{"Let me"}, 0, hold)
+	ai = NewAIService(testDB, &AIConfig{Provider: ProviderOpenAI, APIKey: "test-key", BaseURL: provider.URL})
+	service.SetAskService(NewAskService(testDB, ai, nil))
+
+	ask("q2")
+	message = readMessageOfType(t, conn, models.MessageTypeAIChunk)
+	assert.Equal(t, "q2", message.MessageID)
+	require.NoError(t, conn.WriteJSON(models.WebSocketMessage{Type: models.MessageTypeAICancel, MessageID: "q2"}))
+	message = readMessageOfType(t, conn, models.MessageTypeAck)
+	assert.Equal(t, "q2", message.MessageID)
+	assert.Equal(t, "ai_cancelled", message.Data.(map[string]interface{})["action"])
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider request was not cancelled")
+	}
+}
+
+func TestWebSocketService_NoteUpdate_OtherRoom(t *testing.T) {
+	service, testDB := setupWebSocketTest(t)
+	defer database.CleanupTestDB(testDB)
+
+	user := createTestUser(t, testDB)
+	first := createTestNote(t, testDB, user.ID)
+	second := createTestNote(t, testDB, user.ID)
+
+	firstConn, firstServer := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer firstConn.Close()
+	defer firstServer.Close()
+	secondConn, secondServer := setupWebSocketConnection(t, service, user.ID, user.Username)
+	defer secondConn.Close()
+	defer secondServer.Close()
+
+	for conn, note := range map[*websocket.Conn]models.Note{firstConn: first, secondConn: second} {
+		require.NoError(t, conn.WriteJSON(models.WebSocketMessage{
+			Type: models.MessageTypeJoinRoom,
+			Data: models.JoinRoomData{NoteID: note.ID},
+		}))
+		_ = readMessageOfType(t, conn, models.MessageTypeAck)
+	}
+
+	// Editing the second note from the first note's room would broadcast it
+	// to the wrong collaborators
+	require.NoError(t, firstConn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeNoteUpdate,
+		Data: models.NoteUpdateData{
+			NoteID:    second.ID,
+			Content:   models.JSONB{"type": "doc", "content": []interface{}{}},
+			Version:   1,
+			Operation: "replace",
+		},
+	}))
+
+	errorMessage := readMessageOfType(t, firstConn, models.MessageTypeError)
+	assert.Equal(t, "not_in_room", errorMessage.Data.(map[string]interface{})["code"])
+
+	var unchanged models.Note
+	require.NoError(t, testDB.First(&unchanged, "id = ?", second.ID).Error)
+	assert.Equal(t, 1, unchanged.Version)
+
+	// From its own room the edit goes through
+	require.NoError(t, firstConn.WriteJSON(models.WebSocketMessage{
+		Type: models.MessageTypeNoteUpdate,
+		Data: models.NoteUpdateData{
+			NoteID:    first.ID,
+			Content:   models.JSONB{"type": "doc", "content": []interface{}{}},
+			Version:   1,
+			Operation: "replace",
+		},
+	}))
+
+	ack := readMessageOfType(t, firstConn, models.MessageTypeAck)
+	assert.Equal(t, "note_updated", ack.Data.(map[string]interface{})["action"])
+
+	require.NoError(t, testDB.First(&unchanged, "id = ?", second.ID).Error)
+	assert.Equal(t, 1, unchanged.Version)
+}
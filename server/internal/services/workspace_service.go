@@ -0,0 +1,361 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"notesage-server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrWorkspaceAccessDenied means the user is a member of the workspace but
+	// their role does not allow the requested action
+	ErrWorkspaceAccessDenied = errors.New("insufficient permission for this workspace")
+	// ErrAlreadyMember means the invited user already belongs to the workspace
+	ErrAlreadyMember = errors.New("user is already a member of this workspace")
+	// ErrMemberNotFound means the user does not belong to the workspace
+	ErrMemberNotFound = errors.New("user is not a member of this workspace")
+	// ErrLastWorkspaceOwner means the change would leave the workspace without an owner
+	ErrLastWorkspaceOwner = errors.New("a workspace must keep at least one owner")
+	// ErrWorkspaceNotEmpty means the workspace still holds notes or people
+	ErrWorkspaceNotEmpty = errors.New("workspace still contains notes or people")
+)
+
+// OwnedBy scopes a query on table to the rows of a workspace or, when
+// workspaceID is nil, to the user's personal rows
+func OwnedBy(table string, userID uuid.UUID, workspaceID *uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if workspaceID != nil {
+			return db.Where(table+".workspace_id = ?", *workspaceID)
+		}
+		return db.Where(table+".user_id = ? AND "+table+".workspace_id IS NULL", userID)
+	}
+}
+
+// WorkspaceSummary is a workspace along with the user's role in it
+type WorkspaceSummary struct {
+	models.Workspace
+	Role        string `json:"role"`
+	MemberCount int64  `json:"member_count"`
+}
+
+// WorkspaceService manages workspaces and their memberships
+type WorkspaceService struct {
+	db *gorm.DB
+}
+
+// NewWorkspaceService creates a new workspace service
+func NewWorkspaceService(db *gorm.DB) *WorkspaceService {
+	return &WorkspaceService{db: db}
+}
+
+// Membership returns the user's membership of a workspace. Workspaces the
+// user does not belong to are reported as gorm.ErrRecordNotFound.
+func (s *WorkspaceService) Membership(userID, workspaceID uuid.UUID) (*models.WorkspaceMember, error) {
+	var member models.WorkspaceMember
+	if err := s.db.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// authorize checks that the user holds at least the required role in a workspace
+func (s *WorkspaceService) authorize(userID, workspaceID uuid.UUID, required string) (*models.WorkspaceMember, error) {
+	member, err := s.Membership(userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if !models.WorkspaceRoleAllows(member.Role, required) {
+		return member, ErrWorkspaceAccessDenied
+	}
+	return member, nil
+}
+
+// CreateWorkspace creates a workspace with the user as its owner
+func (s *WorkspaceService) CreateWorkspace(userID uuid.UUID, name, description string) (*models.Workspace, error) {
+	workspace := models.Workspace{Name: name, Description: description, CreatedBy: userID}
+	if err := workspace.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      userID,
+			Role:        models.WorkspaceRoleOwner,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// ListWorkspaces returns the workspaces a user belongs to, oldest first
+func (s *WorkspaceService) ListWorkspaces(userID uuid.UUID) ([]WorkspaceSummary, error) {
+	var members []models.WorkspaceMember
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch memberships: %w", err)
+	}
+
+	summaries := make([]WorkspaceSummary, 0, len(members))
+	for _, member := range members {
+		summary, err := s.summarize(member)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, *summary)
+	}
+	return summaries, nil
+}
+
+// GetWorkspace returns a workspace the user belongs to
+func (s *WorkspaceService) GetWorkspace(userID, workspaceID uuid.UUID) (*WorkspaceSummary, error) {
+	member, err := s.Membership(userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.summarize(*member)
+}
+
+func (s *WorkspaceService) summarize(member models.WorkspaceMember) (*WorkspaceSummary, error) {
+	summary := WorkspaceSummary{Role: member.Role}
+	if err := s.db.First(&summary.Workspace, "id = ?", member.WorkspaceID).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch workspace: %w", err)
+	}
+	if err := s.db.Model(&models.WorkspaceMember{}).Where("workspace_id = ?", member.WorkspaceID).
+		Count(&summary.MemberCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+	return &summary, nil
+}
+
+// UpdateWorkspace renames a workspace or changes its description. Admins and
+// owners only.
+func (s *WorkspaceService) UpdateWorkspace(userID, workspaceID uuid.UUID, name, description *string) (*models.Workspace, error) {
+	if _, err := s.authorize(userID, workspaceID, models.WorkspaceRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var workspace models.Workspace
+	if err := s.db.First(&workspace, "id = ?", workspaceID).Error; err != nil {
+		return nil, err
+	}
+	if name != nil {
+		workspace.Name = *name
+	}
+	if description != nil {
+		workspace.Description = *description
+	}
+	if err := workspace.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&workspace).Error; err != nil {
+		return nil, fmt.Errorf("failed to update workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// DeleteWorkspace removes an empty workspace. Owners only; notes and people,
+// including those in the trash, must be moved out or purged first.
+func (s *WorkspaceService) DeleteWorkspace(userID, workspaceID uuid.UUID) error {
+	if _, err := s.authorize(userID, workspaceID, models.WorkspaceRoleOwner); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Note{}, &models.Person{}} {
+			var count int64
+			if err := tx.Unscoped().Model(model).Where("workspace_id = ?", workspaceID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrWorkspaceNotEmpty
+			}
+		}
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&models.Connection{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Workspace{}, "id = ?", workspaceID).Error
+	})
+}
+
+// ListMembers returns the members of a workspace. Any member may see who
+// else belongs to it.
+func (s *WorkspaceService) ListMembers(userID, workspaceID uuid.UUID) ([]models.WorkspaceMember, error) {
+	if _, err := s.Membership(userID, workspaceID); err != nil {
+		return nil, err
+	}
+
+	var members []models.WorkspaceMember
+	if err := s.db.Preload("User").Where("workspace_id = ?", workspaceID).Order("created_at").Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch members: %w", err)
+	}
+	return members, nil
+}
+
+// InviteMember adds a user to a workspace. Admins may invite viewers, members
+// and admins; only owners may add other owners.
+func (s *WorkspaceService) InviteMember(userID, workspaceID, inviteeID uuid.UUID, role string) (*models.WorkspaceMember, error) {
+	inviter, err := s.authorize(userID, workspaceID, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if role == models.WorkspaceRoleOwner && inviter.Role != models.WorkspaceRoleOwner {
+		return nil, ErrWorkspaceAccessDenied
+	}
+
+	var invitee models.User
+	if err := s.db.Where("id = ? AND is_active = ?", inviteeID, true).First(&invitee).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareUserNotFound
+		}
+		return nil, err
+	}
+
+	member := models.WorkspaceMember{WorkspaceID: workspaceID, UserID: invitee.ID, Role: role, InvitedBy: &userID}
+	if err := member.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Membership(invitee.ID, workspaceID); err == nil {
+		return nil, ErrAlreadyMember
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := s.db.Create(&member).Error; err != nil {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+	member.User = invitee
+	return &member, nil
+}
+
+// UpdateMemberRole changes a member's role. Admins may re-role viewers,
+// members and admins; only owners may promote to or demote from owner.
+func (s *WorkspaceService) UpdateMemberRole(userID, workspaceID, memberID uuid.UUID, role string) (*models.WorkspaceMember, error) {
+	actor, err := s.authorize(userID, workspaceID, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	var member models.WorkspaceMember
+	var updated *models.WorkspaceMember
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("User").Where("workspace_id = ? AND user_id = ?", workspaceID, memberID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMemberNotFound
+			}
+			return err
+		}
+		if (role == models.WorkspaceRoleOwner || member.Role == models.WorkspaceRoleOwner) && actor.Role != models.WorkspaceRoleOwner {
+			return ErrWorkspaceAccessDenied
+		}
+		if member.Role == models.WorkspaceRoleOwner && role != models.WorkspaceRoleOwner {
+			if err := ensureAnotherOwner(tx, workspaceID, member.UserID); err != nil {
+				return err
+			}
+		}
+
+		member.Role = role
+		if err := member.Validate(); err != nil {
+			return err
+		}
+		if err := tx.Save(&member).Error; err != nil {
+			return fmt.Errorf("failed to update member: %w", err)
+		}
+		updated = &member
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// RemoveMember removes a user from a workspace. Admins may remove viewers,
+// members and admins and owners may remove anyone; any member may leave.
+// Notes and people the user created stay in the workspace and are handed over
+// to its longest-standing owner.
+func (s *WorkspaceService) RemoveMember(userID, workspaceID, memberID uuid.UUID) error {
+	actor, err := s.Membership(userID, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var member models.WorkspaceMember
+		if err := tx.Where("workspace_id = ? AND user_id = ?", workspaceID, memberID).First(&member).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMemberNotFound
+			}
+			return err
+		}
+
+		if memberID != userID {
+			if !models.WorkspaceRoleAllows(actor.Role, models.WorkspaceRoleAdmin) {
+				return ErrWorkspaceAccessDenied
+			}
+			if member.Role == models.WorkspaceRoleOwner && actor.Role != models.WorkspaceRoleOwner {
+				return ErrWorkspaceAccessDenied
+			}
+		}
+		if member.Role == models.WorkspaceRoleOwner {
+			if err := ensureAnotherOwner(tx, workspaceID, member.UserID); err != nil {
+				return err
+			}
+		}
+
+		var owner models.WorkspaceMember
+		if err := tx.Where("workspace_id = ? AND role = ? AND user_id <> ?", workspaceID, models.WorkspaceRoleOwner, member.UserID).
+			Order("created_at").First(&owner).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Note{}, &models.Person{}, &models.Connection{}, &models.Attachment{}} {
+			if err := tx.Unscoped().Model(model).Where("workspace_id = ? AND user_id = ?", workspaceID, member.UserID).
+				UpdateColumn("user_id", owner.UserID).Error; err != nil {
+				return fmt.Errorf("failed to hand over workspace content: %w", err)
+			}
+		}
+
+		return tx.Delete(&member).Error
+	})
+}
+
+// ensureAnotherOwner fails if the workspace has no owner besides userID
+func ensureAnotherOwner(tx *gorm.DB, workspaceID, userID uuid.UUID) error {
+	var owners int64
+	if err := tx.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND role = ? AND user_id <> ?", workspaceID, models.WorkspaceRoleOwner, userID).
+		Count(&owners).Error; err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastWorkspaceOwner
+	}
+	return nil
+}
+
+// noteRoleForWorkspace maps a workspace role onto the access it grants to the
+// workspace's notes. Members may edit them, but only admins and owners may
+// share, move or flag them.
+func noteRoleForWorkspace(role string) string {
+	switch role {
+	case models.WorkspaceRoleOwner, models.WorkspaceRoleAdmin:
+		return models.NoteRoleOwner
+	case models.WorkspaceRoleMember:
+		return models.NoteRoleEditor
+	case models.WorkspaceRoleViewer:
+		return models.NoteRoleViewer
+	}
+	return ""
+}
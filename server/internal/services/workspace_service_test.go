@@ -0,0 +1,159 @@
+package services
+
+import (
+	"testing"
+
+	"notesage-server/internal/database"
+	"notesage-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestWorkspaceService_MembersAndRoles(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	admin := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	stranger := createTestUser(t, db)
+	service := NewWorkspaceService(db)
+
+	workspace, err := service.CreateWorkspace(owner.ID, "Team", "Shared notes")
+	require.NoError(t, err)
+
+	member, err := service.Membership(owner.ID, workspace.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkspaceRoleOwner, member.Role)
+
+	// Only owners may add owners
+	_, err = service.InviteMember(owner.ID, workspace.ID, admin.ID, models.WorkspaceRoleAdmin)
+	require.NoError(t, err)
+	_, err = service.InviteMember(admin.ID, workspace.ID, viewer.ID, models.WorkspaceRoleOwner)
+	assert.ErrorIs(t, err, ErrWorkspaceAccessDenied)
+	_, err = service.InviteMember(admin.ID, workspace.ID, viewer.ID, models.WorkspaceRoleViewer)
+	require.NoError(t, err)
+	_, err = service.InviteMember(admin.ID, workspace.ID, viewer.ID, models.WorkspaceRoleMember)
+	assert.ErrorIs(t, err, ErrAlreadyMember)
+
+	// Viewers cannot manage members and strangers cannot see the workspace
+	_, err = service.InviteMember(viewer.ID, workspace.ID, stranger.ID, models.WorkspaceRoleViewer)
+	assert.ErrorIs(t, err, ErrWorkspaceAccessDenied)
+	_, err = service.GetWorkspace(stranger.ID, workspace.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	summary, err := service.GetWorkspace(viewer.ID, workspace.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkspaceRoleViewer, summary.Role)
+	assert.Equal(t, int64(3), summary.MemberCount)
+
+	// The last owner can neither be demoted nor leave
+	_, err = service.UpdateMemberRole(owner.ID, workspace.ID, owner.ID, models.WorkspaceRoleAdmin)
+	assert.ErrorIs(t, err, ErrLastWorkspaceOwner)
+	assert.ErrorIs(t, service.RemoveMember(owner.ID, workspace.ID, owner.ID), ErrLastWorkspaceOwner)
+
+	updated, err := service.UpdateMemberRole(admin.ID, workspace.ID, viewer.ID, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkspaceRoleMember, updated.Role)
+
+	// Members may leave on their own
+	require.NoError(t, service.RemoveMember(viewer.ID, workspace.ID, viewer.ID))
+	assert.ErrorIs(t, service.RemoveMember(owner.ID, workspace.ID, viewer.ID), ErrMemberNotFound)
+
+	workspaces, err := service.ListWorkspaces(admin.ID)
+	require.NoError(t, err)
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, models.WorkspaceRoleAdmin, workspaces[0].Role)
+}
+
+func TestWorkspaceService_ScopesNotes(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	member := createTestUser(t, db)
+	viewer := createTestUser(t, db)
+	service := NewWorkspaceService(db)
+
+	workspace, err := service.CreateWorkspace(owner.ID, "Team", "")
+	require.NoError(t, err)
+	_, err = service.InviteMember(owner.ID, workspace.ID, member.ID, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+	_, err = service.InviteMember(owner.ID, workspace.ID, viewer.ID, models.WorkspaceRoleViewer)
+	require.NoError(t, err)
+
+	personal := createTestNote(t, db, owner.ID)
+	team := createTestNote(t, db, owner.ID)
+	require.NoError(t, db.Model(&team).Update("workspace_id", workspace.ID).Error)
+
+	var notes []models.Note
+	require.NoError(t, db.Scopes(OwnedBy("notes", owner.ID, nil)).Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, personal.ID, notes[0].ID)
+
+	require.NoError(t, db.Scopes(OwnedBy("notes", viewer.ID, &workspace.ID)).Find(&notes).Error)
+	require.Len(t, notes, 1)
+	assert.Equal(t, team.ID, notes[0].ID)
+
+	// Workspace roles carry over to the notes in it
+	shares := NewShareService(db)
+	_, role, err := shares.AuthorizeNote(viewer.ID, team.ID, models.NoteRoleViewer)
+	require.NoError(t, err)
+	assert.Equal(t, models.NoteRoleViewer, role)
+	_, _, err = shares.AuthorizeNote(viewer.ID, team.ID, models.NoteRoleEditor)
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+	_, _, err = shares.AuthorizeNote(viewer.ID, personal.ID, models.NoteRoleViewer)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Members edit workspace notes but only admins and owners manage them
+	_, role, err = shares.AuthorizeNote(member.ID, team.ID, models.NoteRoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, models.NoteRoleEditor, role)
+	_, _, err = shares.AuthorizeNote(member.ID, team.ID, models.NoteRoleOwner)
+	assert.ErrorIs(t, err, ErrNoteAccessDenied)
+	_, role, err = shares.AuthorizeNote(owner.ID, team.ID, models.NoteRoleOwner)
+	require.NoError(t, err)
+	assert.Equal(t, models.NoteRoleOwner, role)
+
+	// Workspaces with content cannot be deleted
+	assert.ErrorIs(t, service.DeleteWorkspace(owner.ID, workspace.ID), ErrWorkspaceNotEmpty)
+}
+
+func TestWorkspaceService_RemoveMemberHandsOverContent(t *testing.T) {
+	db := database.SetupTestDB(t)
+	defer database.CleanupTestDB(db)
+
+	owner := createTestUser(t, db)
+	member := createTestUser(t, db)
+	service := NewWorkspaceService(db)
+
+	workspace, err := service.CreateWorkspace(owner.ID, "Team", "")
+	require.NoError(t, err)
+	_, err = service.InviteMember(owner.ID, workspace.ID, member.ID, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+
+	personal := createTestNote(t, db, member.ID)
+	team := createTestNote(t, db, member.ID)
+	require.NoError(t, db.Model(&team).Update("workspace_id", workspace.ID).Error)
+	person := models.Person{UserID: member.ID, WorkspaceID: &workspace.ID, Name: "Ada"}
+	require.NoError(t, db.Create(&person).Error)
+
+	require.NoError(t, service.RemoveMember(owner.ID, workspace.ID, member.ID))
+
+	// What the member wrote in the workspace now belongs to its owner
+	require.NoError(t, db.First(&team, "id = ?", team.ID).Error)
+	assert.Equal(t, owner.ID, team.UserID)
+	require.NoError(t, db.First(&person, "id = ?", person.ID).Error)
+	assert.Equal(t, owner.ID, person.UserID)
+	require.NoError(t, db.First(&personal, "id = ?", personal.ID).Error)
+	assert.Equal(t, member.ID, personal.UserID)
+
+	shares := NewShareService(db)
+	_, _, err = shares.AuthorizeNote(member.ID, team.ID, models.NoteRoleViewer)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, role, err := shares.AuthorizeNote(owner.ID, team.ID, models.NoteRoleOwner)
+	require.NoError(t, err)
+	assert.Equal(t, models.NoteRoleOwner, role)
+}
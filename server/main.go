@@ -4,12 +4,34 @@ import (
 	"log"
 	"os"
 
+	"notesage-server/internal/backup"
+	"notesage-server/internal/cli"
 	"notesage-server/internal/config"
 	"notesage-server/internal/database"
 	"notesage-server/internal/router"
+
+	"github.com/spf13/cobra"
 )
 
+// rootCmd runs the server; its subcommands are maintenance tools
+var rootCmd = &cobra.Command{
+	Use:          "notesage-server",
+	Short:        "NoteSage server",
+	SilenceUsage: true,
+	RunE:         runServer,
+}
+
 func main() {
+	rootCmd.AddCommand(cli.MigrateCmd)
+	rootCmd.AddCommand(cli.ImportCmd)
+	rootCmd.AddCommand(cli.BackupCmd)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func runServer(cmd *cobra.Command, args []string) error {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -22,8 +44,17 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Apply pending migrations, backing up existing data first
+	backups := backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, cfg.Backup.MaxAge)
+	if err := database.Migrate(db, backups); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	// Setup router
-	r := router.Setup(db, cfg)
+	r, err := router.Setup(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up server: %v", err)
+	}
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -32,7 +63,5 @@ func main() {
 	}
 
 	log.Printf("Starting NoteSage server on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
\ No newline at end of file
+	return r.Run(":" + port)
+}
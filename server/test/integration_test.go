@@ -31,7 +31,9 @@ func setupIntegrationTest(t *testing.T) (*httptest.Server, *gorm.DB, string) {
 			SessionTimeout: 24 * time.Hour,
 		},
 		Features: config.FeaturesConfig{
-			AIEnabled: false,
+			AIEnabled:   false,
+			FileUploads: true,
+			UploadPath:  t.TempDir(),
 		},
 		AI: config.AIConfig{
 			Provider: "disabled",
@@ -43,7 +45,10 @@ func setupIntegrationTest(t *testing.T) (*httptest.Server, *gorm.DB, string) {
 
 	// Setup router
 	gin.SetMode(gin.TestMode)
-	appRouter := router.Setup(db, cfg)
+	appRouter, err := router.Setup(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set up router: %v", err)
+	}
 	server := httptest.NewServer(appRouter)
 
 	// Create test user with correct request structure
@@ -52,7 +52,10 @@ func setupLoadTest(t *testing.T) {
 		loadTestDB = database.SetupTestDB(t)
 
 		gin.SetMode(gin.TestMode)
-		appRouter := router.Setup(loadTestDB, cfg)
+		appRouter, err := router.Setup(loadTestDB, cfg)
+		if err != nil {
+			t.Fatalf("Failed to set up router: %v", err)
+		}
 		loadTestServer = httptest.NewServer(appRouter)
 	})
 }
@@ -58,7 +58,9 @@ func setupTestServer(t *testing.T, username string) (*httptest.Server, *gorm.DB,
 			SessionTimeout: 24 * time.Hour,
 		},
 		Features: config.FeaturesConfig{
-			AIEnabled: false,
+			AIEnabled:   false,
+			FileUploads: true,
+			UploadPath:  t.TempDir(),
 		},
 		AI: config.AIConfig{
 			Provider: "disabled",
@@ -69,7 +71,10 @@ func setupTestServer(t *testing.T, username string) (*httptest.Server, *gorm.DB,
 	db := database.SetupTestDB(t)
 
 	gin.SetMode(gin.TestMode)
-	appRouter := router.Setup(db, cfg)
+	appRouter, err := router.Setup(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set up router: %v", err)
+	}
 	server := httptest.NewServer(appRouter)
 
 	// Create test user